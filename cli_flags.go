@@ -20,12 +20,15 @@ import (
 	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/debug/log"
 	"github.com/elastic/otel-profiling-agent/hostmetadata/host"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/reporter"
 	"github.com/elastic/otel-profiling-agent/tracer"
 )
 
 const (
 	// Default values for CLI flags
 	defaultArgSamplesPerSecond       = 20
+	defaultArgSecondarySamplesPerSec = 5
 	defaultArgReporterInterval       = 5.0 * time.Second
 	defaultArgMonitorInterval        = 5.0 * time.Second
 	defaultArgPrivateMachineID       = ""
@@ -33,6 +36,8 @@ const (
 	defaultProbabilisticThreshold    = tracer.ProbabilisticThresholdMax
 	defaultProbabilisticInterval     = 1 * time.Minute
 	defaultArgSendErrorFrames        = false
+	defaultCommandLineRedactPatterns = "*pass*,*secret*,*token*,*key*,*credential*,*auth*"
+	defaultSymbolizationTimeout      = 1 * time.Second
 
 	// This is the X in 2^(n + x) where n is the default hardcoded map size value
 	defaultArgMapScaleFactor = 0
@@ -45,7 +50,7 @@ var (
 	noKernelVersionCheckHelp = "Disable checking kernel version for eBPF support. " +
 		"Use at your own risk, to run the agent on older kernels with backported eBPF features."
 	copyrightHelp      = "Show copyright and short license text."
-	collAgentAddrHelp  = "The collection agent address in the format of host:port."
+	collAgentAddrHelp  = "The collection agent address in the format of host:port. " + otlpEnvHelp
 	verboseModeHelp    = "Enable verbose logging and debugging capabilities."
 	tracersHelp        = "Comma-separated list of interpreter tracers to include."
 	mapScaleFactorHelp = fmt.Sprintf("Scaling factor for eBPF map sizes. "+
@@ -59,10 +64,32 @@ var (
 	secretTokenHelp    = "The secret token associated with the project id."
 	tagsHelp           = fmt.Sprintf("User-specified tags separated by ';'. "+
 		"Each tag should match '%v'.", host.ValidTagRegex)
-	disableTLSHelp          = "Disable encryption for data in transit."
-	bpfVerifierLogLevelHelp = "Log level of the eBPF verifier output (0,1,2). Default is 0."
-	bpfVerifierLogSizeHelp  = "Size in bytes that will be allocated for the eBPF " +
+	debugAddressHelp = "Address, e.g. 'localhost:6061', to serve an HTTP endpoint on that " +
+		"controls the agent at run time. 'POST /flush' sends out the collected samples " +
+		"immediately and returns their number. 'GET /config' returns and 'POST /config' " +
+		"changes the sampling frequency and the enabled profiling modes. " +
+		"'POST /diff?interval=30s' returns a pprof profile of the difference of the " +
+		"samples of two consecutive intervals. 'GET /samples' streams the samples as " +
+		"newline delimited JSON. Default is none, " +
+		"which disables the endpoint."
+	disableTLSHelp    = "Disable encryption for data in transit. " + otlpEnvHelp
+	reporterProxyHelp = "URL of the proxy, e.g. 'http://proxy:3128' or " +
+		"'socks5://proxy:1080', to connect to the collection agent through. gRPC over TLS " +
+		"is tunneled through HTTP proxies with CONNECT. Overrides HTTPS_PROXY, while " +
+		"NO_PROXY still applies. Default is the proxy set in HTTPS_PROXY, if any."
+	otlpEnvHelp = "Defaults to the standard OTEL_EXPORTER_OTLP_* environment " +
+		"variables: the endpoint of OTEL_EXPORTER_OTLP_PROFILES_ENDPOINT or " +
+		"OTEL_EXPORTER_OTLP_ENDPOINT, with TLS disabled for 'http://' endpoints or by " +
+		"OTEL_EXPORTER_OTLP_INSECURE. The headers of OTEL_EXPORTER_OTLP_HEADERS are sent " +
+		"with each request."
+	bpfVerifierLogLevelHelp = "Log level of the eBPF verifier output (0,1,2) for all eBPF " +
+		"programs the agent loads. The output is logged in verbose mode if a program is " +
+		"rejected. Default is 0."
+	bpfVerifierLogSizeHelp = "Size in bytes that will be allocated for the eBPF " +
 		"verifier output. Only takes effect if bpf-log-level > 0."
+	bpfStatsHelp = "Enable the kernel statistics for eBPF programs and periodically log " +
+		"the run count and run time of each program. Adds a small overhead to each " +
+		"program invocation. Requires Linux 5.8 or newer."
 	versionHelp                = "Show version."
 	probabilisticThresholdHelp = fmt.Sprintf("If set to a value between 1 and %d will enable "+
 		"probabilistic profiling: "+
@@ -73,12 +100,184 @@ var (
 		tracer.ProbabilisticThresholdMax-1, tracer.ProbabilisticThresholdMax-1)
 	probabilisticIntervalHelp = "Time interval for which probabilistic profiling will be " +
 		"enabled or disabled."
+	selfThrottleThresholdHelp = "If set to a value between 0 and 1, the sampling frequency is " +
+		"temporarily lowered while the CPU usage of the agent exceeds this fraction of the " +
+		"CPU quota of its cgroup. Default is 0 (disabled)."
+	pidFilterHelp = "Only profile the PIDs read from the given source. The source is either " +
+		"a file that is watched for changes, or 'unix:<path>' to create a Unix socket that " +
+		"accepts the complete PID set on each connection. PIDs are separated by whitespace."
+	pidSampleWeightHelp = fmt.Sprintf("Sample the given processes more often than others, "+
+		"as comma separated list of PID=WEIGHT pairs. The sampling frequency is raised by "+
+		"the least common multiple of the weights, at most %d, and the samples of each "+
+		"process are kept in proportion to its weight, or 1 for other processes. The "+
+		"sample counts are scaled, so that they remain unbiased.",
+		config.MaxSampleWeightFactor)
+	logFormatHelp = "Log output format: 'text' or 'json'. Default is 'text'."
+	perfEventHelp = fmt.Sprintf("Perf event that triggers the sampling, one of %s. "+
+		"Hardware events fall back to %s if the PMU is not usable, e.g. in virtual "+
+		"machines. %s samples each major page fault, regardless of the sampling "+
+		"frequency. Default is %s.",
+		strings.Join(tracer.PerfEventNames(), ", "), tracer.PerfEventCPUClock,
+		tracer.PerfEventMajorFaults, tracer.PerfEventCPUClock)
+	alignedSamplingHelp = "Sample all CPUs at the same time with a fixed period instead of " +
+		"independently per CPU, for a coherent snapshot of the system at each tick. This " +
+		"interrupts all CPUs at once and causes bursts of processing load. Requires " +
+		"perf-event cpu-clock. Default is false."
+	cpuTimeWeightsHelp = "Weight each sample with the CPU time that elapsed on its CPU " +
+		"since the previous sample, in addition to counting it. Unlike the number of " +
+		"samples, this reflects the CPU time spent, also with frequency scaling and idle " +
+		"periods. Requires perf-event task-clock. Default is false."
+	idleBackoffHelp = "Lower the sampling frequency step by step, down to an eighth, while " +
+		"the CPUs of the host are idle for a sustained period. The sampling frequency is " +
+		"restored as soon as the host is active again. Requires perf-event cpu-clock and " +
+		"can not be combined with -self-throttle-threshold. Default is false."
+	interpreterOffsetsFileHelp = "JSON file with interpreter offsets that augment or " +
+		"override the offsets built into the Perl, PHP, Python and Ruby tracers, to fix " +
+		"or add the support of an interpreter version without a new build of the agent. " +
+		"The format is described in docs/interpreter-offsets.md. Default is none."
+	samplerWatchdogIntervalsHelp = "Number of consecutive monitor intervals without any " +
+		"traces, while sampling is enabled and the host is busy, after which the perf events " +
+		"are re-created and the eBPF programs attached to them again. This recovers from " +
+		"perf events that silently stopped delivering samples. 0 disables the watchdog. " +
+		"Default is 12."
+	secondaryPerfEventHelp = "Perf event that triggers the sampling of an additional, " +
+		"independent set of samples, which is reported as a separate profile type. This " +
+		"allows e.g. a low frequency profile of a hardware event, or a profile of the major " +
+		"page faults with major-faults, next to the CPU profile. The secondary set is " +
+		"sampled with its own frequency, except for major-faults, which samples each " +
+		"fault, and does not fall back to another event if the event is not supported. " +
+		"Default is empty, which disables the secondary set."
+	secondarySamplesPerSecondHelp = fmt.Sprintf("Sampling frequency of the perf event "+
+		"selected with secondary-perf-event. Default is %d.", defaultArgSecondarySamplesPerSec)
+	labelCoreTypeHelp = "Label each sample with the type of the CPU core it was taken on " +
+		"('performance' or 'efficiency') on hybrid CPUs, as cores of different types run at " +
+		"different speeds. Default is false."
+	labelSyscallHelp = "Label each sample taken during a system call with the name of the " +
+		"system call, as found in the kernel stack. Default is false."
+	excludeThreadsHelp = "Comma-separated list of shell patterns for thread names, e.g. " +
+		"'GC Thread*', whose samples are dropped. Patterns are case-sensitive and matched " +
+		"against the complete name. Default is none."
+	framesOnlyHelp = "Report only the executable and address of the frames and leave all " +
+		"symbolization to the backend, for minimal CPU and memory use on the host. This " +
+		"disables the symbolization of interpreter frames and Go executables on the host. " +
+		"The interpreter frames are still unwound and reported with the raw address from " +
+		"the unwinder. Kernel frames are still symbolized. Default is false."
+	trimFramesHelp = "Comma-separated list of shell patterns for the file names of " +
+		"libraries, e.g. 'libc.so*'. Consecutive native frames of a matching library are " +
+		"collapsed into a single placeholder frame like [libc], keeping the outermost and " +
+		"innermost frame of the library. Default is no trimming."
+	flameGraphDirHelp = "Directory to write a flame graph SVG of the samples of each " +
+		"reporting interval and of each flush of the debug endpoint to, instead of sending " +
+		"them to the collection agent. The SVGs are self-contained and can be zoomed into by " +
+		"clicking frames in a web browser. Native frames are shown by executable and address. " +
+		"Default is sending to the collection agent."
+	redactSymbolsHelp = "Semicolon-separated list of redaction rules in the format " +
+		"REGEX=REPLACEMENT, e.g. '/home/[^/]+/=/home/user/;acme[A-Za-z]*=[redacted]'. The " +
+		"matches of the regular expressions in symbol names and file paths are replaced " +
+		"before the profiles are exported, with $1 referring to submatches. The rules are " +
+		"applied in order. Default is no redaction."
+	btfFileHelp = "Path of a file with the BTF type information of the running kernel, " +
+		"e.g. from BTFHub, for kernels that do not provide /sys/kernel/btf/vmlinux."
+	groupByThreadHelp = "Keep the samples of different threads of a process apart by " +
+		"labeling them with the thread ID, so that the backend can split profiles per " +
+		"thread. This increases the number of reported samples. Default is false."
+	stackDeltasDirHelp = "Directory with stack deltas that were extracted ahead of time " +
+		"with utils/stackdeltas, in files named after the build ID of the executable. " +
+		"These are used instead of extracting the stack deltas at runtime. Default is none."
+	pidHelp = "Only profile the process with the given PID and write the collected profile " +
+		"in pprof format to pprof-output when stopping, instead of sending it to the " +
+		"collection agent. Not compatible with pid-filter."
+	durationHelp = "Stop profiling after the given duration, e.g. 30s. Requires pid. " +
+		"Default is to profile until the agent is interrupted."
+	pprofOutputHelp = "Path of the file the pprof profile is written to if pid is set. " +
+		"Default is 'profile.pb.gz'."
+	processLabelEnvPrefixHelp = "Prefix of the environment variables by which processes " +
+		"label their samples, e.g. 'OTEL_PROFILE_LABEL_'. The variable " +
+		"OTEL_PROFILE_LABEL_TENANT=acme adds the label 'tenant' with value 'acme' to the " +
+		"samples of the process. The environment is read when the process is discovered, " +
+		"at most 8 labels are used. Default is none."
+	commandLineLabelHelp = "Label the samples of processes with their command line, " +
+		"truncated to this many bytes after redacting secrets, see command-line-redact. " +
+		"The command line is read when the process is discovered. Default is 0 (disabled)."
+	commandLineRedactHelp = fmt.Sprintf("Comma-separated list of shell patterns for the "+
+		"names of command line options whose values are redacted in the command line label, "+
+		"e.g. '--db-password=x', 'DB_PASSWORD=x' or '--db-password x'. Patterns are "+
+		"case-insensitive and matched against the name without leading dashes. Passwords "+
+		"in URLs are always redacted. Default is '%s'.", defaultCommandLineRedactPatterns)
+	rootFrameHelp = "Add a synthetic root frame '[<comm> (<executable>)]' to each stack, so " +
+		"that all stacks of a process share a common root in flame graphs, regardless of " +
+		"where unwinding stopped. Default is false."
+	lostSamplesFrameHelp = "Report the samples the kernel lost, as the trace buffer was " +
+		"full, as a synthetic sample of each CPU with the single frame '[lost N samples]', " +
+		"so that profiles show how many samples they miss. Default is false."
+	pyroscopeURLHelp = "Base URL of a Pyroscope or Grafana Phlare server, e.g. " +
+		"'http://pyroscope:4040', to send the profiles to in pprof format through its " +
+		"ingest API instead of to the collection agent. Credentials in the URL are sent " +
+		"with basic authentication. Default is none."
+	pyroscopeAppNameHelp = "Application name of the series the profiles are sent to in " +
+		"Pyroscope. Only takes effect with pyroscope-url."
+	rawDumpHelp = "Write the traces received from the eBPF unwinder before symbolization, " +
+		"together with the executables they refer to, to the given file for offline " +
+		"replay with utils/rawreplay. The format is described in docs/raw-dump.md. " +
+		"Default is none."
+	sampleFilterHelp = "Comma-separated list of conditions in the format KEY=PATTERN or " +
+		"KEY!=PATTERN that samples must satisfy to be sent to the collection agent, e.g. " +
+		"'pod=prod-*,label.env!=test'. The keys are comm, executable, pid, cgroup, pod, " +
+		"container, containerized and label.NAME, and the patterns are shell patterns, " +
+		"whose '*' does not match the '/' of cgroup paths. Default is all samples."
+	rawDumpSampleFilterHelp = "Conditions like those of sample-filter that samples must " +
+		"satisfy to be written to the raw dump, which may only use the comm and pid keys. " +
+		"Only takes effect with raw-dump. Default is all samples."
+	kernelSymbolCacheSizeHelp = "Number of kernel addresses whose symbols are cached, so " +
+		"that hot addresses are not resolved again for each sample. The cache only applies " +
+		"to kernel frames: Go frames are symbolized once per address regardless, and the " +
+		"interpreter and JIT unwinders cache their symbols themselves. 0 disables the " +
+		"cache. Default is 16384."
+	unsymbolizedFramesHelp = "How to report interpreter frames that can not be " +
+		"symbolized, e.g. as the interpreter state of the process is not known (yet): " +
+		"'no-address' reports the frame without an address, 'raw-address' reports the " +
+		"frame with the raw address from the unwinder, 'drop' omits the frame from the " +
+		"trace and 'placeholder' reports a single frame named '[<interpreter> unknown]' " +
+		"per interpreter, so that such frames aggregate. Default is no-address."
+	maxTrackedProcessesHelp = "Maximum number of processes whose mappings and interpreter " +
+		"state are tracked. If more processes are discovered, the state of the least " +
+		"recently sampled ones is released and set up again once they are sampled again. " +
+		"This bounds the memory usage on hosts with high process churn. Default is 0, " +
+		"which is unlimited."
+	maxStackDepthHelp = "Maximum number of frames of a stack, counted from the innermost " +
+		"frame. The frames beyond it are collapsed into a single frame named '[deeper]', " +
+		"so that stacks that only differ in their outermost frames are aggregated. This " +
+		"reduces the number of unique stacks of processes with deep and varying stacks. " +
+		"Default is 0, which keeps all frames."
+	symbolizationTimeoutHelp = fmt.Sprintf("Maximum time spent symbolizing the frames of a single "+
+		"stack. While the eBPF unwinder is bounded to a fixed number of frames, the "+
+		"symbolization of interpreter frames reads process memory and can take long for "+
+		"corrupt or adversarial stacks. The frames left when the timeout expires are "+
+		"replaced with a single frame named '[symbolization-timeout]'. 0 disables the "+
+		"timeout. Default is %v.", defaultSymbolizationTimeout)
+	kernelDenylistHelp = fmt.Sprintf("Comma-separated list of kernel releases, as reported "+
+		"by 'uname -r', the agent refuses to run on. An entry matches releases equal to it "+
+		"or starting with it followed by a non-digit, e.g. '5.15' matches '5.15.0-91-generic'. "+
+		"Entries with shell pattern characters are matched against the complete release. "+
+		"Default is '%s'.", tracer.DefaultKernelDenylist)
+	tpbaseOffsetBoundsHelp = "Advanced: Range MIN-MAX of offsets of the thread pointer in " +
+		"the kernel task_struct that are accepted as sane, e.g. '500-20000'. Only needed for " +
+		"kernels whose offset is rejected, which disables the Perl, Python, Ruby and BEAM " +
+		"tracers. Default is an architecture specific range."
+	elfMaxBufferSizeHelp = fmt.Sprintf("Maximum size in bytes of ELF section data that is "+
+		"loaded into memory at once. Executables requiring more are skipped. Default is %d.",
+		pfelf.DefaultMaxBufferSize)
+	verifyUnwindRateHelp = "Advanced: Check the stack deltas of the native frames in one of " +
+		"this many traces against the frame pointers, where the frames have them, and count " +
+		"and log the mismatches. This is meant to find wrong stack deltas and is only " +
+		"supported on x86_64. Default is 0 (disabled)."
 )
 
 // Variables for command line arguments
 var (
 	// Customer-visible flag variables.
 	argNoKernelVersionCheck   bool
+	argKernelDenylist         string
 	argCollAgentAddr          string
 	argCopyright              bool
 	argVersion                bool
@@ -89,12 +288,58 @@ var (
 	argConfigFile             string
 	argSecretToken            string
 	argDisableTLS             bool
+	argReporterProxy          string
 	argTags                   string
 	argBpfVerifierLogLevel    uint
 	argBpfVerifierLogSize     int
+	argBpfStats               bool
+	argBTFFile                string
 	argMapScaleFactor         uint
 	argProbabilisticThreshold uint
 	argProbabilisticInterval  time.Duration
+	argSelfThrottleThreshold  float64
+	argSamplerWatchdog        uint
+	argELFMaxBufferSize       uint64
+	argLogFormat              string
+	argPIDFilter              string
+	argPIDSampleWeights       string
+	argPID                    uint
+	argDuration               time.Duration
+	argPprofOutput            string
+	argPerfEvent              string
+	argAlignedSampling        bool
+	argSecondaryPerfEvent     string
+	argCPUTimeWeights         bool
+	argIdleBackoff            bool
+	argInterpreterOffsetsFile string
+	argSecondarySamplesPerSec int
+	argLabelCoreType          bool
+	argLabelSyscall           bool
+	argFramesOnly             bool
+	argGroupByThread          bool
+	argStackDeltasDir         string
+	argTrimFrames             string
+	argRootFrame              bool
+	argLostSamplesFrame       bool
+	argProcessLabelEnvPrefix  string
+	argCommandLineLabel       uint
+	argCommandLineRedact      string
+	argRawDump                string
+	argRawDumpSampleFilter    string
+	argSampleFilter           string
+	argFlameGraphDir          string
+	argRedactSymbols          string
+	argPyroscopeURL           string
+	argPyroscopeAppName       string
+	argMaxTrackedProcesses    uint
+	argKernelSymbolCacheSize  uint
+	argUnsymbolizedFrames     string
+	argDebugAddress           string
+	argExcludeThreads         string
+	argTPBaseOffsetBounds     string
+	argVerifyUnwindRate       uint
+	argMaxStackDepth          uint
+	argSymbolizationTimeout   time.Duration
 
 	// "internal" flag variables.
 	// Flag variables that are configured in "internal" builds will have to be assigned
@@ -113,9 +358,13 @@ var fs = flag.NewFlagSet("otel-profiling-agent", flag.ExitOnError)
 
 func parseArgs() error {
 	// Please keep the parameters ordered alphabetically in the source-code.
+	fs.BoolVar(&argAlignedSampling, "aligned-sampling", false, alignedSamplingHelp)
+
 	fs.UintVar(&argBpfVerifierLogLevel, "bpf-log-level", 0, bpfVerifierLogLevelHelp)
 	fs.IntVar(&argBpfVerifierLogSize, "bpf-log-size", cebpf.DefaultVerifierLogSize,
 		bpfVerifierLogSizeHelp)
+	fs.BoolVar(&argBpfStats, "bpf-stats", false, bpfStatsHelp)
+	fs.StringVar(&argBTFFile, "btf-file", "", btfFileHelp)
 
 	fs.StringVar(&argCacheDirectory, "cache-directory", config.CacheDirectory(),
 		cacheDirectoryHelp)
@@ -124,26 +373,98 @@ func parseArgs() error {
 	fs.StringVar(&argConfigFile, "config", "/etc/otel/profiling-agent/agent.conf",
 		configFileHelp)
 	fs.BoolVar(&argCopyright, "copyright", false, copyrightHelp)
+	fs.BoolVar(&argCPUTimeWeights, "cpu-time-weights", false, cpuTimeWeightsHelp)
 
+	fs.StringVar(&argDebugAddress, "debug-address", "", debugAddressHelp)
 	fs.BoolVar(&argDisableTLS, "disable-tls", false, disableTLSHelp)
+	fs.DurationVar(&argDuration, "duration", 0, durationHelp)
+
+	fs.StringVar(&argExcludeThreads, "exclude-thread", "", excludeThreadsHelp)
+
+	fs.Uint64Var(&argELFMaxBufferSize, "elf-max-buffer-size", pfelf.DefaultMaxBufferSize,
+		elfMaxBufferSizeHelp)
+
+	fs.StringVar(&argFlameGraphDir, "flamegraph-dir", "", flameGraphDirHelp)
+
+	fs.BoolVar(&argFramesOnly, "frames-only", false, framesOnlyHelp)
+
+	fs.BoolVar(&argGroupByThread, "group-by-thread", false, groupByThreadHelp)
+
+	fs.BoolVar(&argIdleBackoff, "idle-backoff", false, idleBackoffHelp)
+	fs.StringVar(&argInterpreterOffsetsFile, "interpreter-offsets-file", "",
+		interpreterOffsetsFileHelp)
+
+	fs.StringVar(&argKernelDenylist, "kernel-denylist", tracer.DefaultKernelDenylist,
+		kernelDenylistHelp)
+
+	fs.BoolVar(&argLabelCoreType, "label-core-type", false, labelCoreTypeHelp)
+	fs.BoolVar(&argLabelSyscall, "label-syscall", false, labelSyscallHelp)
+
+	fs.StringVar(&argLogFormat, "log-format", "text", logFormatHelp)
 
 	fs.UintVar(&argMapScaleFactor, "map-scale-factor",
 		defaultArgMapScaleFactor, mapScaleFactorHelp)
+	fs.UintVar(&argMaxStackDepth, "max-stack-depth", 0, maxStackDepthHelp)
+	fs.DurationVar(&argSymbolizationTimeout, "symbolization-timeout", defaultSymbolizationTimeout,
+		symbolizationTimeoutHelp)
+	fs.UintVar(&argMaxTrackedProcesses, "max-tracked-processes", 0, maxTrackedProcessesHelp)
 
 	fs.BoolVar(&argNoKernelVersionCheck, "no-kernel-version-check", false, noKernelVersionCheckHelp)
 
+	fs.StringVar(&argPerfEvent, "perf-event", tracer.PerfEventCPUClock.String(), perfEventHelp)
+	fs.UintVar(&argPID, "pid", 0, pidHelp)
+	fs.StringVar(&argPIDFilter, "pid-filter", "", pidFilterHelp)
+	fs.StringVar(&argPIDSampleWeights, "pid-sample-weight", "", pidSampleWeightHelp)
+	fs.StringVar(&argPprofOutput, "pprof-output", "profile.pb.gz", pprofOutputHelp)
+
+	fs.StringVar(&argProcessLabelEnvPrefix, "process-label-env-prefix", "",
+		processLabelEnvPrefixHelp)
+	fs.UintVar(&argCommandLineLabel, "command-line-label", 0, commandLineLabelHelp)
+	fs.StringVar(&argCommandLineRedact, "command-line-redact", defaultCommandLineRedactPatterns,
+		commandLineRedactHelp)
 	fs.UintVar(&argProjectID, "project-id", 1, projectIDHelp)
+	fs.StringVar(&argPyroscopeAppName, "pyroscope-app-name", "otel-profiling-agent",
+		pyroscopeAppNameHelp)
+	fs.StringVar(&argPyroscopeURL, "pyroscope-url", "", pyroscopeURLHelp)
+
+	fs.StringVar(&argRawDump, "raw-dump", "", rawDumpHelp)
+	fs.StringVar(&argRawDumpSampleFilter, "raw-dump-sample-filter", "",
+		rawDumpSampleFilterHelp)
+	fs.StringVar(&argRedactSymbols, "redact-symbols", "", redactSymbolsHelp)
+	fs.StringVar(&argReporterProxy, "reporter-proxy", "", reporterProxyHelp)
+	fs.StringVar(&argSampleFilter, "sample-filter", "", sampleFilterHelp)
 
 	// Using a default value here to simplify OTEL review process.
+	fs.BoolVar(&argRootFrame, "root-frame", false, rootFrameHelp)
+	fs.BoolVar(&argLostSamplesFrame, "lost-samples-frame", false, lostSamplesFrameHelp)
+
+	fs.UintVar(&argSamplerWatchdog, "sampler-watchdog-intervals", 12,
+		samplerWatchdogIntervalsHelp)
+
+	fs.StringVar(&argSecondaryPerfEvent, "secondary-perf-event", "", secondaryPerfEventHelp)
+	fs.IntVar(&argSecondarySamplesPerSec, "secondary-samples-per-second",
+		defaultArgSecondarySamplesPerSec, secondarySamplesPerSecondHelp)
 	fs.StringVar(&argSecretToken, "secret-token", "abc123", secretTokenHelp)
+	fs.Float64Var(&argSelfThrottleThreshold, "self-throttle-threshold", 0,
+		selfThrottleThresholdHelp)
+
+	fs.StringVar(&argStackDeltasDir, "stack-deltas-dir", "", stackDeltasDirHelp)
+	fs.UintVar(&argKernelSymbolCacheSize, "kernel-symbol-cache-size", 16384,
+		kernelSymbolCacheSizeHelp)
 
 	fs.StringVar(&argTags, "tags", "", tagsHelp)
+	fs.StringVar(&argTPBaseOffsetBounds, "tpbase-offset-bounds", "", tpbaseOffsetBoundsHelp)
 	fs.StringVar(&argTracers, "t", "all", "Shorthand for -tracers.")
 	fs.StringVar(&argTracers, "tracers", "all", tracersHelp)
+	fs.StringVar(&argTrimFrames, "trim-frames", "", trimFramesHelp)
+
+	fs.StringVar(&argUnsymbolizedFrames, "unsymbolized-frames", "no-address",
+		unsymbolizedFramesHelp)
 
 	fs.BoolVar(&argVerboseMode, "v", false, "Shorthand for -verbose.")
 	fs.BoolVar(&argVerboseMode, "verbose", false, verboseModeHelp)
 	fs.BoolVar(&argVersion, "version", false, versionHelp)
+	fs.UintVar(&argVerifyUnwindRate, "verify-unwind-rate", 0, verifyUnwindRateHelp)
 
 	fs.UintVar(&argProbabilisticThreshold, "probabilistic-threshold",
 		defaultProbabilisticThreshold, probabilisticThresholdHelp)
@@ -163,8 +484,42 @@ func parseArgs() error {
 		ff.WithIgnoreUndefined(true),
 		ff.WithAllowMissingConfigFile(true),
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return applyOTLPEnv(os.LookupEnv)
+}
+
+// argOTLPHeaders holds the headers configured by the OTLP environment variables.
+var argOTLPHeaders map[string]string
+
+// applyOTLPEnv configures the connection to the collection agent by the standard
+// OTEL_EXPORTER_OTLP_* environment variables, which are looked up with lookup. Flags that
+// are set explicitly, also by OTEL_PROFILING_AGENT_* variables or the configuration file,
+// take precedence over them.
+func applyOTLPEnv(lookup func(string) (string, bool)) error {
+	env, err := reporter.ParseOTLPEnv(lookup)
+	if err != nil {
+		return err
+	}
+
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	endpointApplies := env.Endpoint != "" && !setFlags["collection-agent"]
+	if endpointApplies {
+		argCollAgentAddr = env.Endpoint
+	}
+	// The TLS setting derived from the scheme of an endpoint only applies together with it.
+	if env.Insecure != nil && !setFlags["disable-tls"] && (endpointApplies ||
+		env.Endpoint == "") {
+		argDisableTLS = *env.Insecure
+	}
+	argOTLPHeaders = env.Headers
+	return nil
 }
 
 // parseTracers parses a string that specifies one or more eBPF tracers to enable.
@@ -185,6 +540,7 @@ func parseTracers(tracers string) ([]bool, error) {
 		"ruby":    config.RubyTracer,
 		"python":  config.PythonTracer,
 		"hotspot": config.HotspotTracer,
+		"beam":    config.BEAMTracer,
 	}
 
 	// Parse and validate tracers string
@@ -233,6 +589,30 @@ func parseTracers(tracers string) ([]bool, error) {
 	return result, nil
 }
 
+// splitPatterns splits a comma-separated list of patterns, ignoring empty elements.
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// splitRedactionRules returns the non-empty rules of a semicolon-separated list. Unlike
+// patterns, the rules are not separated by commas, as these are common in regular
+// expressions.
+func splitRedactionRules(list string) []string {
+	var rules []string
+	for _, rule := range strings.Split(list, ";") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
 func dumpArgs() {
 	log.Debug("Config:")
 	fs.VisitAll(func(f *flag.Flag) {
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -21,40 +22,60 @@ const (
 	traceCacheMinSize = 65536
 )
 
-// Config is the structure to pass the configuration into host-agent.
+// Config is the structure to pass the configuration into host-agent. It is serialized to
+// show the configuration the agent resolved, which leaves out the secret token.
 type Config struct {
-	EnvironmentType        string
-	MachineID              string
-	SecretToken            string
-	Tags                   string
-	ValidatedTags          string
-	CollectionAgentAddr    string
-	ConfigurationFile      string
-	Tracers                string
-	CacheDirectory         string
-	BpfVerifierLogSize     int
-	BpfVerifierLogLevel    uint
-	MonitorInterval        time.Duration
-	TracePollInterval      time.Duration
-	ReportInterval         time.Duration
-	ProjectID              uint32
-	SamplesPerSecond       uint16
-	PresentCPUCores        uint16
-	DisableTLS             bool
-	UploadSymbols          bool
-	NoKernelVersionCheck   bool
-	TraceCacheIntervals    uint8
-	Verbose                bool
-	MapScaleFactor         uint8
-	StartTime              time.Time
-	ProbabilisticInterval  time.Duration
-	ProbabilisticThreshold uint
+	EnvironmentType        string               `json:"environment_type"`
+	MachineID              string               `json:"machine_id"`
+	SecretToken            string               `json:"-"`
+	Tags                   string               `json:"tags"`
+	ValidatedTags          string               `json:"validated_tags"`
+	CollectionAgentAddr    string               `json:"collection_agent_addr"`
+	ConfigurationFile      string               `json:"configuration_file"`
+	Tracers                string               `json:"tracers"`
+	CacheDirectory         string               `json:"cache_directory"`
+	BpfVerifierLogSize     int                  `json:"bpf_verifier_log_size"`
+	BTFFile                string               `json:"btf_file"`
+	BpfVerifierLogLevel    uint                 `json:"bpf_verifier_log_level"`
+	MonitorInterval        time.Duration        `json:"monitor_interval"`
+	TracePollInterval      time.Duration        `json:"trace_poll_interval"`
+	ReportInterval         time.Duration        `json:"report_interval"`
+	ProjectID              uint32               `json:"project_id"`
+	SamplesPerSecond       uint16               `json:"samples_per_second"`
+	PresentCPUCores        uint16               `json:"present_cpu_cores"`
+	DisableTLS             bool                 `json:"disable_tls"`
+	UploadSymbols          bool                 `json:"upload_symbols"`
+	NoKernelVersionCheck   bool                 `json:"no_kernel_version_check"`
+	TraceCacheIntervals    uint8                `json:"trace_cache_intervals"`
+	Verbose                bool                 `json:"verbose"`
+	MapScaleFactor         uint8                `json:"map_scale_factor"`
+	StartTime              time.Time            `json:"start_time"`
+	ProbabilisticInterval  time.Duration        `json:"probabilistic_interval"`
+	ProbabilisticThreshold uint                 `json:"probabilistic_threshold"`
+	LabelCoreType          bool                 `json:"label_core_type"`
+	LabelSyscall           bool                 `json:"label_syscall"`
+	GroupByThread          bool                 `json:"group_by_thread"`
+	StackDeltasDir         string               `json:"stack_deltas_dir"`
+	ProcessLabelEnvPrefix  string               `json:"process_label_env_prefix"`
+	CommandLineLabelLen    uint32               `json:"command_line_label_len"`
+	CommandLineRedact      []string             `json:"command_line_redact"`
+	MaxTrackedProcesses    uint32               `json:"max_tracked_processes"`
+	KernelSymbolCacheSize  uint32               `json:"kernel_symbol_cache_size"`
+	UnsymbolizedFrames     string               `json:"unsymbolized_frames"`
+	TPBaseMinOffset        uint32               `json:"tpbase_min_offset"`
+	TPBaseMaxOffset        uint32               `json:"tpbase_max_offset"`
+	ExcludeThreads         []string             `json:"exclude_threads"`
+	FramesOnly             bool                 `json:"frames_only"`
+	PIDSampleWeights       map[libpf.PID]uint32 `json:"pid_sample_weights"`
+	VerifyUnwindRate       uint32               `json:"verify_unwind_rate"`
+	MaxStackDepth          uint32               `json:"max_stack_depth"`
+	SymbolizationTimeout   time.Duration        `json:"symbolization_timeout"`
 
 	// Bits of hostmetadata that we save in config so that they can be
 	// conveniently accessed globally in the agent.
-	IPAddress     string
-	Hostname      string
-	KernelVersion string
+	IPAddress     string `json:"ip_address"`
+	Hostname      string `json:"hostname"`
+	KernelVersion string `json:"kernel_version"`
 }
 
 // Profiling specific variables which are set once at startup of the agent.
@@ -88,6 +109,59 @@ var (
 	noKernelVersionCheck bool
 	// uploadSymbols indicates whether automatic uploading of symbols is enabled
 	uploadSymbols bool
+	// labelCoreType indicates whether samples are labeled with the type of the CPU core
+	// they were taken on
+	labelCoreType bool
+	// labelSyscall indicates whether samples taken in the kernel are labeled with the
+	// system call being executed
+	labelSyscall bool
+	// groupByThread indicates whether samples of different threads of a process are kept
+	// apart
+	groupByThread bool
+	// stackDeltasDir holds the path of a directory with precomputed stack deltas
+	stackDeltasDir string
+	// processLabelEnvPrefix holds the prefix of the environment variables of processes
+	// that label their samples
+	processLabelEnvPrefix string
+	// commandLineLabelLen holds the maximum length of the label with the command line of
+	// processes, or 0 if the samples are not labeled with it
+	commandLineLabelLen uint32
+	// commandLineRedact holds the lower case shell patterns for the names of the command
+	// line options whose values are redacted
+	commandLineRedact []string
+	// maxTrackedProcesses holds the maximum number of processes tracked by the process
+	// manager, or 0 if unlimited
+	maxTrackedProcesses uint32
+	// kernelSymbolCacheSize holds the number of kernel address to symbol resolutions that
+	// are cached, or 0 if they are not cached
+	kernelSymbolCacheSize uint32
+	// unsymbolizedFrames selects how interpreter frames that can not be symbolized are
+	// reported
+	unsymbolizedFrames UnsymbolizedFrameMode
+	// tpbaseMinOffset and tpbaseMaxOffset override the range of accepted tpbase offsets
+	tpbaseMinOffset uint32
+	tpbaseMaxOffset uint32
+	// excludeThreads holds the shell patterns for the names of the threads whose samples
+	// are dropped
+	excludeThreads []string
+	// framesOnly indicates whether symbolization on the host is disabled, so that only
+	// the raw frames are reported
+	framesOnly bool
+	// pidSampleWeights holds the factors by which the processes with the PIDs are sampled
+	// more often than other processes
+	pidSampleWeights map[libpf.PID]uint32
+	// sampleWeightFactor holds the factor the sampling frequency is raised by for the
+	// sample weights, or 1 if there are none
+	sampleWeightFactor uint32
+	// verifyUnwindRate holds the number of traces of which one has its stack deltas checked
+	// against the frame pointers, or 0 if the checks are disabled
+	verifyUnwindRate uint32
+	// maxStackDepth holds the maximum number of frames of a stack, beyond which the frames
+	// are collapsed into a single frame, or 0 if all frames are kept
+	maxStackDepth uint32
+	// symbolizationTimeout holds the maximum time the frames of a trace are symbolized for,
+	// or 0 if there is no limit
+	symbolizationTimeout time.Duration
 	// bpfVerifierLogLevel holds the defined log level of the eBPF verifier.
 	// Currently there are three different log levels applied by the kernel verifier:
 	// 0 - no logging
@@ -99,6 +173,9 @@ var (
 	// bpfVerifierLogSize defines the number of bytes that are pre-allocated to hold the output
 	// of the eBPF verifier log.
 	bpfVerifierLogSize int
+	// btfFile holds the path of a file with the BTF type information of the running kernel,
+	// for kernels that do not provide it themselves.
+	btfFile string
 	// maxElementsPerInterval defines the maximum number of possible elements reported per
 	// monitor interval (MonitorInterval).
 	maxElementsPerInterval uint32
@@ -163,6 +240,7 @@ func SetConfiguration(conf *Config) error {
 
 	bpfVerifierLogLevel = uint32(conf.BpfVerifierLogLevel)
 	bpfVerifierLogSize = conf.BpfVerifierLogSize
+	btfFile = conf.BTFFile
 
 	// The environment type (aws/gcp/bare metal) is overridden vs. the default auto-detect.
 	// WARN: Environment type and machineID are internal flag arguments and not exposed
@@ -203,6 +281,33 @@ func SetConfiguration(conf *Config) error {
 	disableTLS = conf.DisableTLS
 	noKernelVersionCheck = conf.NoKernelVersionCheck
 	uploadSymbols = conf.UploadSymbols
+	labelCoreType = conf.LabelCoreType
+	labelSyscall = conf.LabelSyscall
+	groupByThread = conf.GroupByThread
+	stackDeltasDir = conf.StackDeltasDir
+	processLabelEnvPrefix = conf.ProcessLabelEnvPrefix
+	commandLineLabelLen = conf.CommandLineLabelLen
+	commandLineRedact = make([]string, 0, len(conf.CommandLineRedact))
+	for _, pattern := range conf.CommandLineRedact {
+		commandLineRedact = append(commandLineRedact, strings.ToLower(pattern))
+	}
+	maxTrackedProcesses = conf.MaxTrackedProcesses
+	kernelSymbolCacheSize = conf.KernelSymbolCacheSize
+	if unsymbolizedFrames, err = unsymbolizedFrameModeFromString(
+		conf.UnsymbolizedFrames); err != nil {
+		return fmt.Errorf("invalid unsymbolized frames handling: %v", err)
+	}
+	tpbaseMinOffset = conf.TPBaseMinOffset
+	tpbaseMaxOffset = conf.TPBaseMaxOffset
+	excludeThreads = conf.ExcludeThreads
+	framesOnly = conf.FramesOnly
+	pidSampleWeights = conf.PIDSampleWeights
+	if sampleWeightFactor, err = sampleWeightFactorOf(pidSampleWeights); err != nil {
+		return fmt.Errorf("invalid sample weights: %v", err)
+	}
+	verifyUnwindRate = conf.VerifyUnwindRate
+	maxStackDepth = conf.MaxStackDepth
+	symbolizationTimeout = conf.SymbolizationTimeout
 	tracers = conf.Tracers
 	startTime = conf.StartTime
 	mapScaleFactor = conf.MapScaleFactor
@@ -264,6 +369,12 @@ func BpfVerifierLogSetting() (level uint32, size int) {
 	return bpfVerifierLogLevel, bpfVerifierLogSize
 }
 
+// BTFFile returns the path of the file holding the BTF of the running kernel, or an empty
+// string if the BTF provided by the kernel is used.
+func BTFFile() string {
+	return btfFile
+}
+
 // HostID returns the hostID of the running agent. The host ID is calculated by calling
 // GenerateNewHostIDIfNecessary().
 func HostID() uint64 {
@@ -329,6 +440,117 @@ func UploadSymbols() bool {
 	return uploadSymbols
 }
 
+// Indicates whether samples are labeled with the type of the CPU core they were taken on
+func LabelCoreType() bool {
+	return labelCoreType
+}
+
+// Indicates whether samples taken in the kernel are labeled with the system call being executed
+func LabelSyscall() bool {
+	return labelSyscall
+}
+
+// Indicates whether samples of different threads of a process are kept apart
+func GroupByThread() bool {
+	return groupByThread
+}
+
+// Directory with precomputed stack deltas, or an empty string if not used
+func StackDeltasDir() string {
+	return stackDeltasDir
+}
+
+// Prefix of the environment variables of processes that label their samples, or an empty
+// string if not used
+func ProcessLabelEnvPrefix() string {
+	return processLabelEnvPrefix
+}
+
+// Maximum length of the label with the command line of processes, or 0 if the samples are
+// not labeled with it
+func CommandLineLabelLen() uint32 {
+	return commandLineLabelLen
+}
+
+// Lower case shell patterns for the names of the command line options whose values are
+// redacted
+func CommandLineRedact() []string {
+	return commandLineRedact
+}
+
+// Maximum number of processes tracked by the process manager, or 0 if unlimited
+func MaxTrackedProcesses() uint32 {
+	return maxTrackedProcesses
+}
+
+// Number of kernel address to symbol resolutions that are cached, or 0 if they are not cached
+func KernelSymbolCacheSize() uint32 {
+	return kernelSymbolCacheSize
+}
+
+// How interpreter frames that can not be symbolized are reported
+func UnsymbolizedFrames() UnsymbolizedFrameMode {
+	return unsymbolizedFrames
+}
+
+// Range of tpbase offsets accepted as sane, or 0 for the maximum if the default is used
+func TPBaseOffsetBounds() (minOffset, maxOffset uint32) {
+	return tpbaseMinOffset, tpbaseMaxOffset
+}
+
+// Shell patterns for the names of the threads whose samples are dropped
+func ExcludeThreads() []string {
+	return excludeThreads
+}
+
+// Indicates whether symbolization on the host is disabled
+func FramesOnly() bool {
+	return framesOnly
+}
+
+// Factors by which the processes with the PIDs are sampled more often than other processes
+func PIDSampleWeights() map[libpf.PID]uint32 {
+	return pidSampleWeights
+}
+
+// Factor the sampling frequency is raised by for the sample weights, or 1 if there are none
+func SampleWeightFactor() uint32 {
+	return max(sampleWeightFactor, 1)
+}
+
+// SampleCount returns the number of samples of the raised sampling frequency a sample of
+// the process with pid stands for. Only a fraction of the samples of each process is kept,
+// which is the sample weight of the process divided by the factor the frequency is raised
+// by, so that the counts remain unbiased.
+func SampleCount(pid libpf.PID) uint16 {
+	if sampleWeightFactor <= 1 {
+		return 1
+	}
+	weight, ok := pidSampleWeights[pid]
+	if !ok {
+		weight = 1
+	}
+	return uint16(sampleWeightFactor / weight)
+}
+
+// Number of traces of which one has its stack deltas checked against the frame pointers,
+// or 0 if the checks are disabled
+func VerifyUnwindRate() uint32 {
+	return verifyUnwindRate
+}
+
+// Maximum number of frames of a stack, beyond which the frames are collapsed into a single
+// frame, or 0 if all frames are kept
+func MaxStackDepth() uint32 {
+	return maxStackDepth
+}
+
+// Maximum time the frames of a trace are symbolized for, beyond which the remaining frames
+// are replaced with a single frame, or 0 if there is no limit
+func SymbolizationTimeout() time.Duration {
+	return symbolizationTimeout
+}
+
 // User-specified tracers to enable
 func Tracers() string {
 	return tracers
@@ -9,6 +9,8 @@ package config
 import (
 	"os"
 	"testing"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
 )
 
 func TestSetConfiguration(t *testing.T) {
@@ -60,4 +62,30 @@ func TestSetConfiguration(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected failure using empty secretToken for environment")
 	}
+
+	cfg6 := cfg
+	cfg6.UnsymbolizedFrames = "placeholder"
+	if err = SetConfiguration(&cfg6); err != nil {
+		t.Fatalf("failure to set unsymbolized frames handling: %v", err)
+	}
+	if UnsymbolizedFrames() != UnsymbolizedPlaceholder {
+		t.Fatalf("expected unsymbolized frames handling placeholder, got %v",
+			UnsymbolizedFrames())
+	}
+
+	cfg7 := cfg
+	cfg7.UnsymbolizedFrames = "bla"
+	if err = SetConfiguration(&cfg7); err == nil {
+		t.Fatalf("expected failure using invalid unsymbolized frames handling")
+	}
+
+	cfg8 := cfg
+	cfg8.PIDSampleWeights = map[libpf.PID]uint32{1234: 4, 5678: 2}
+	if err = SetConfiguration(&cfg8); err != nil {
+		t.Fatalf("failure to set sample weights: %v", err)
+	}
+	if SampleCount(1234) != 1 || SampleCount(5678) != 2 || SampleCount(42) != 4 {
+		t.Fatalf("expected sample counts 1, 2 and 4, got %d, %d and %d",
+			SampleCount(1234), SampleCount(5678), SampleCount(42))
+	}
 }
@@ -0,0 +1,71 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// MaxSampleWeightFactor is the maximum factor the sampling frequency is raised by to sample
+// the processes with sample weights more often.
+const MaxSampleWeightFactor = 64
+
+// ParseSampleWeights parses a comma separated list of PID=WEIGHT pairs, which sample the
+// processes with the PIDs WEIGHT times as often as other processes. It also returns the
+// factor the sampling frequency is raised by for this, which is the least common multiple
+// of the weights, so that each sample can be counted with an integral number of samples of
+// the raised frequency. The factor is 1 if no weights are given.
+func ParseSampleWeights(list string) (weights map[libpf.PID]uint32, factor uint32,
+	err error) {
+	weights = make(map[libpf.PID]uint32)
+	for _, pair := range strings.Split(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		pidStr, weightStr, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid sample weight '%s', expected PID=WEIGHT",
+				pair)
+		}
+		pid, err := strconv.ParseUint(strings.TrimSpace(pidStr), 10, 32)
+		if err != nil || pid == 0 {
+			return nil, 0, fmt.Errorf("invalid PID '%s'", pidStr)
+		}
+		weight, err := strconv.ParseUint(strings.TrimSpace(weightStr), 10, 32)
+		if err != nil || weight == 0 || weight > MaxSampleWeightFactor {
+			return nil, 0, fmt.Errorf("invalid weight '%s' for PID %d (need 1 to %d)",
+				weightStr, pid, MaxSampleWeightFactor)
+		}
+		weights[libpf.PID(pid)] = uint32(weight)
+	}
+
+	if factor, err = sampleWeightFactorOf(weights); err != nil {
+		return nil, 0, err
+	}
+	return weights, factor, nil
+}
+
+// sampleWeightFactorOf returns the least common multiple of weights.
+func sampleWeightFactorOf(weights map[libpf.PID]uint32) (uint32, error) {
+	factor := uint32(1)
+	for _, weight := range weights {
+		a, b := factor, weight
+		for b != 0 {
+			a, b = b, a%b
+		}
+		factor = factor / a * weight
+		if factor > MaxSampleWeightFactor {
+			return 0, fmt.Errorf("the least common multiple of the sample weights "+
+				"exceeds %d", MaxSampleWeightFactor)
+		}
+	}
+	return factor, nil
+}
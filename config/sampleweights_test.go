@@ -0,0 +1,64 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestParseSampleWeights(t *testing.T) {
+	tests := map[string]struct {
+		list    string
+		weights map[libpf.PID]uint32
+		factor  uint32
+		err     bool
+	}{
+		"empty": {
+			weights: map[libpf.PID]uint32{},
+			factor:  1,
+		},
+		"single": {
+			list:    "1234=4",
+			weights: map[libpf.PID]uint32{1234: 4},
+			factor:  4,
+		},
+		"least common multiple": {
+			list:    "1234=4, 5678=6,",
+			weights: map[libpf.PID]uint32{1234: 4, 5678: 6},
+			factor:  12,
+		},
+		"factor too large":  {list: "1=32,2=3", err: true},
+		"weight too large":  {list: "1=65", err: true},
+		"zero weight":       {list: "1=0", err: true},
+		"zero pid":          {list: "0=2", err: true},
+		"missing weight":    {list: "1234", err: true},
+		"non numeric value": {list: "1234=high", err: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			weights, factor, err := ParseSampleWeights(test.list)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected failure parsing '%s'", test.list)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failure to parse '%s': %v", test.list, err)
+			}
+			if !reflect.DeepEqual(weights, test.weights) || factor != test.factor {
+				t.Fatalf("expected %v with factor %d, got %v with factor %d",
+					test.weights, test.factor, weights, factor)
+			}
+		})
+	}
+}
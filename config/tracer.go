@@ -16,6 +16,7 @@ const (
 	HotspotTracer
 	RubyTracer
 	V8Tracer
+	BEAMTracer
 
 	// MaxTracers indicates the max. number of different tracers
 	MaxTracers
@@ -28,6 +29,7 @@ var tracerTypeToString = map[TracerType]string{
 	HotspotTracer: "hotspot",
 	RubyTracer:    "ruby",
 	V8Tracer:      "v8",
+	BEAMTracer:    "beam",
 }
 
 // allTracers is returned by a call to AllTracers(). To avoid allocating memory every time the
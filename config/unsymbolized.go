@@ -0,0 +1,56 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package config
+
+import "fmt"
+
+// UnsymbolizedFrameMode values select how interpreter frames are reported that can not be
+// symbolized, e.g. because the interpreter offsets are missing or the process memory could
+// not be read.
+type UnsymbolizedFrameMode uint8
+
+const (
+	// UnsymbolizedNoAddress reports the frame as unsymbolized frame without an address.
+	UnsymbolizedNoAddress UnsymbolizedFrameMode = iota
+	// UnsymbolizedRawAddress reports the frame with the raw value the eBPF unwinder
+	// recorded for it.
+	UnsymbolizedRawAddress
+	// UnsymbolizedDrop removes the frame from the trace.
+	UnsymbolizedDrop
+	// UnsymbolizedPlaceholder reports the frame as "[<runtime> unknown]", which keeps the
+	// shape of the stack.
+	UnsymbolizedPlaceholder
+)
+
+var unsymbolizedFrameModeToString = map[UnsymbolizedFrameMode]string{
+	UnsymbolizedNoAddress:   "no-address",
+	UnsymbolizedRawAddress:  "raw-address",
+	UnsymbolizedDrop:        "drop",
+	UnsymbolizedPlaceholder: "placeholder",
+}
+
+// unsymbolizedFrameModeFromString returns the mode of the given name. An empty name selects
+// the default UnsymbolizedNoAddress.
+func unsymbolizedFrameModeFromString(name string) (UnsymbolizedFrameMode, error) {
+	if name == "" {
+		return UnsymbolizedNoAddress, nil
+	}
+	for mode, modeName := range unsymbolizedFrameModeToString {
+		if name == modeName {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mode '%s'", name)
+}
+
+// String returns the name of the mode.
+func (m UnsymbolizedFrameMode) String() string {
+	if name, ok := unsymbolizedFrameModeToString[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(m))
+}
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -33,6 +34,7 @@ import (
 	lru "github.com/elastic/go-freelru"
 	log "github.com/sirupsen/logrus"
 	"github.com/zeebo/xxh3"
+	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -45,6 +47,7 @@ import (
 	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
 	"github.com/elastic/otel-profiling-agent/libpf/stringutil"
 	"github.com/elastic/otel-profiling-agent/metrics"
+	"github.com/elastic/otel-profiling-agent/proc"
 )
 
 const (
@@ -81,7 +84,21 @@ var (
 
 	containerIDPattern = regexp.MustCompile(`.+://([0-9a-f]{64})`)
 
+	// containerCgroupPattern matches the cgroup paths created by container runtimes, for
+	// both cgroup v1 (e.g. /docker/<id>) and v2 with the systemd driver (e.g.
+	// /system.slice/docker-<id>.scope). The cgroup of the docker daemon itself
+	// (docker.service) is not matched.
+	containerCgroupPattern = regexp.MustCompile(
+		`/(docker|kubepods|libpod|crio|cri-containerd)[-/]|/lxc\.payload\.`)
+
 	cgroup = "/proc/%d/cgroup"
+	// namespace is the path of a namespace of a process, given its PID and namespace type.
+	namespace = "/proc/%d/ns/%s"
+
+	// namespaceTypes lists the namespaces that containerized processes do not share with
+	// the host. The mount namespace is not used, as it is also private for systemd services
+	// that use e.g. PrivateTmp.
+	namespaceTypes = []string{"pid", "cgroup"}
 )
 
 // Handler does the retrieval of container metadata for a particular pid.
@@ -104,6 +121,10 @@ type Handler struct {
 	dockerClient  *client.Client
 
 	containerdClient *containerd.Client
+
+	// hostNamespaces holds the namespace IDs of the host in namespaceTypes order, or nil
+	// if they are not accessible.
+	hostNamespaces []uint64
 }
 
 // ContainerMetadata contains the container and/or pod metadata.
@@ -111,6 +132,12 @@ type ContainerMetadata struct {
 	containerID   string
 	PodName       string
 	ContainerName string
+	// Containerized is set if the process runs in a container. This is the case if its
+	// cgroup belongs to a known container technology, or if it does not share its
+	// namespaces with the host.
+	Containerized bool
+	// Cgroup is the path of the cgroup of the process, or empty if not known.
+	Cgroup string
 }
 
 // hashString is a helper function for containerMetadataCache
@@ -142,6 +169,11 @@ func isContainerEnvironment(env, target containerEnvironment) bool {
 type containerIDEntry struct {
 	containerID string
 	env         containerEnvironment
+	// containerized is set if the process runs in a container. It is always set if env
+	// is not envUndefined.
+	containerized bool
+	// cgroup is the path of the cgroup of the process.
+	cgroup string
 }
 
 // GetHandler returns a new Handler instance used for retrieving container metadata.
@@ -158,6 +190,14 @@ func GetHandler(ctx context.Context, monitorInterval time.Duration) (*Handler, e
 		containerdClient: getContainerdClient(),
 	}
 
+	// The namespaces of PID 1 are those of the host, as the agent needs to run in the
+	// host PID namespace to profile all processes.
+	instance.hostNamespaces, err = getNamespaces(1)
+	if err != nil {
+		log.Warnf("Failed to read the host namespaces, containerized processes are only "+
+			"detected by their cgroup: %v", err)
+	}
+
 	if os.Getenv(kubernetesServiceHost) != "" {
 		err = createKubernetesClient(ctx, instance)
 		if err != nil {
@@ -439,15 +479,29 @@ func (h *Handler) GetContainerMetadata(pid libpf.PID) (ContainerMetadata, error)
 	// Fast path, check container metadata has been cached
 	// For kubernetes pods, the shared informer may have updated
 	// the container id to container metadata cache, so retrieve the container ID for this pid.
-	pidContainerID, env, err := h.lookupContainerID(pid)
+	entry, err := h.lookupContainerID(pid)
 	if err != nil {
 		return ContainerMetadata{}, fmt.Errorf("failed to get container id for pid %d", pid)
 	}
-	if envUndefined == env {
+	if envUndefined == entry.env {
 		// We were not able to identify a container technology for the given PID.
-		return ContainerMetadata{}, nil
+		return ContainerMetadata{Containerized: entry.containerized, Cgroup: entry.cgroup}, nil
 	}
 
+	meta, err := h.getContainerMetadata(entry.containerID, entry.env)
+	if err != nil {
+		return ContainerMetadata{}, err
+	}
+	meta.Containerized = true
+	meta.Cgroup = entry.cgroup
+	return meta, nil
+}
+
+// getContainerMetadata returns the metadata of the container with the ID pidContainerID
+// that is managed by the container technology env.
+func (h *Handler) getContainerMetadata(pidContainerID string, env containerEnvironment) (
+	ContainerMetadata, error) {
+
 	// Fast path, check if the containerID metadata has been cached
 	if data, ok := h.containerMetadataCache.Get(pidContainerID); ok {
 		return data, nil
@@ -595,50 +649,82 @@ func (h *Handler) getContainerdContainerMetadata(pidContainerID string) (
 }
 
 // lookupContainerID looks up a process ID from the host PID namespace,
-// returning its container ID and the used container technology.
-func (h *Handler) lookupContainerID(pid libpf.PID) (containerID string, env containerEnvironment,
-	err error) {
+// returning its container ID, the used container technology and whether it is
+// containerized.
+func (h *Handler) lookupContainerID(pid libpf.PID) (containerIDEntry, error) {
 	cgroupFilePath := fmt.Sprintf(cgroup, pid)
 
 	fileIdentifier, err := libpf.GetOnDiskFileIdentifier(cgroupFilePath)
 	if err != nil {
-		return "", envUndefined, nil
+		return containerIDEntry{}, nil
 	}
 
 	if entry, exists := h.containerIDCache.Get(fileIdentifier); exists {
-		return entry.containerID, entry.env, nil
+		return entry, nil
 	}
 
-	containerID, env, err = h.extractContainerIDFromFile(cgroupFilePath)
+	entry, err := h.extractContainerIDFromFile(cgroupFilePath)
 	if err != nil {
-		return "", envUndefined, err
+		return containerIDEntry{}, err
+	}
+
+	if !entry.containerized && h.hostNamespaces != nil {
+		// A process may run in a container of an unknown technology.
+		nsIDs, err := getNamespaces(pid)
+		if err != nil {
+			log.Debugf("Failed to read namespaces of PID %d: %v", pid, err)
+		} else {
+			entry.containerized = !slices.Equal(nsIDs, h.hostNamespaces)
+		}
 	}
 
 	// Store the result in the cache.
-	h.containerIDCache.Add(fileIdentifier, containerIDEntry{
-		containerID: containerID,
-		env:         env,
-	})
+	h.containerIDCache.Add(fileIdentifier, entry)
 
-	return containerID, env, nil
+	return entry, nil
 }
 
-func (h *Handler) extractContainerIDFromFile(cgroupFilePath string) (
-	containerID string, env containerEnvironment, err error) {
+// getNamespaces returns the IDs of the namespaces of the process pid in namespaceTypes
+// order.
+func getNamespaces(pid libpf.PID) ([]uint64, error) {
+	ids := make([]uint64, 0, len(namespaceTypes))
+	for _, nsType := range namespaceTypes {
+		// The inode number of the namespace file identifies the namespace.
+		var stat unix.Stat_t
+		if err := unix.Stat(fmt.Sprintf(namespace, pid, nsType), &stat); err != nil {
+			return nil, fmt.Errorf("failed to stat %s namespace: %v", nsType, err)
+		}
+		ids = append(ids, stat.Ino)
+	}
+	return ids, nil
+}
+
+// extractContainerIDFromFile parses the cgroup file of a process. The containerized field of
+// the result is set if the process is in the cgroup of a container, even if the container
+// technology can not be handled.
+func (h *Handler) extractContainerIDFromFile(cgroupFilePath string) (containerIDEntry, error) {
 	f, err := os.Open(cgroupFilePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			log.Debugf("%s does not exist anymore. "+
 				"Failed to get container id", cgroupFilePath)
-			return "", envUndefined, nil
+			return containerIDEntry{}, nil
 		}
-		return "", envUndefined, fmt.Errorf("failed to get container id from %s: %v",
+		return containerIDEntry{}, fmt.Errorf("failed to get container id from %s: %v",
 			cgroupFilePath, err)
 	}
 	defer f.Close()
 
-	containerID = ""
-	env = envUndefined
+	containerID := ""
+	env := envUndefined
+	// The cgroup path identifies containers even if the container technology can not be
+	// handled. proc.ReadCgroup picks the relevant hierarchy of cgroup v1 and v2 layouts.
+	containerized := false
+	cgroupPath := ""
+	if cg, err := proc.ReadCgroup(cgroupFilePath, proc.DefaultCgroupMountPoint); err == nil {
+		containerized = containerCgroupPattern.MatchString(cg.Path)
+		cgroupPath = cg.Path
+	}
 
 	scanner := bufio.NewScanner(f)
 	buf := make([]byte, 512)
@@ -709,5 +795,10 @@ func (h *Handler) extractContainerIDFromFile(cgroupFilePath string) (
 		}
 	}
 
-	return containerID, env, nil
+	return containerIDEntry{
+		containerID:   containerID,
+		env:           env,
+		containerized: containerized || env != envUndefined,
+		cgroup:        cgroupPath,
+	}, nil
 }
@@ -143,23 +143,118 @@ func TestExtractContainerIDFromFile(t *testing.T) {
 	for _, test := range tests {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
-			containerID, env, err := h.extractContainerIDFromFile(test.cgroupname)
+			entry, err := h.extractContainerIDFromFile(test.cgroupname)
 			if err != nil {
 				t.Fatal(err)
 			}
-			if test.expContainerID != containerID {
+			if test.expContainerID != entry.containerID {
 				t.Fatalf("expected containerID %v but found %v",
-					test.expContainerID, containerID)
+					test.expContainerID, entry.containerID)
 			}
 
-			if test.expEnv != env {
+			if test.expEnv != entry.env {
 				t.Fatalf("expected container technology %v but got %v",
-					test.expEnv, env)
+					test.expEnv, entry.env)
+			}
+			if !entry.containerized {
+				t.Fatalf("expected %s to be containerized", test.cgroupname)
 			}
 		})
 	}
 }
 
+func TestExtractContainerizedFromFile(t *testing.T) {
+	tests := map[string]bool{
+		"testdata/cgroupv1docker":        true,
+		"testdata/cgroupv2docker":        true,
+		"testdata/cgroupv1kubernetes":    true,
+		"testdata/cgroupv2kubernetes":    true,
+		"testdata/cgroupv1crikubernetes": true,
+		"testdata/cgroupv2altkubernetes": true,
+		"testdata/lxcpayload":            true,
+		"testdata/cgroupv1host":          false,
+		"testdata/cgroupv2host":          false,
+		"testdata/cgroupv2dockerd":       false,
+		"testdata/does-not-exist/cgroup": false,
+	}
+
+	containerIDCache, err := lru.NewSynced[libpf.OnDiskFileIdentifier, containerIDEntry](
+		containerIDCacheSize, libpf.OnDiskFileIdentifier.Hash32)
+	if err != nil {
+		t.Fatalf("failed to provide cache: %v", err)
+	}
+	// Without clients, most container technologies are not identified, but the
+	// containers are still detected by their cgroup path.
+	h := &Handler{containerIDCache: containerIDCache}
+
+	for cgroupname, expContainerized := range tests {
+		entry, err := h.extractContainerIDFromFile(cgroupname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.containerized != expContainerized {
+			t.Errorf("expected containerized %v for %s but got %v",
+				expContainerized, cgroupname, entry.containerized)
+		}
+	}
+}
+
+func TestLookupContainerIDNamespaces(t *testing.T) {
+	// Emulate the procfs layout of three processes: PID 1 is on the host, PID 2 shares
+	// all namespaces with the host and PID 3 has its own cgroup namespace.
+	proc := t.TempDir()
+	for pid := 1; pid <= 3; pid++ {
+		dir := fmt.Sprintf("%s/%d/ns", proc, pid)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fmt.Sprintf("%s/%d/cgroup", proc, pid),
+			[]byte("0::/user.slice\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, nsType := range namespaceTypes {
+			nsPath := fmt.Sprintf("%s/%s", dir, nsType)
+			var err error
+			if pid == 1 || (pid == 3 && nsType == "cgroup") {
+				err = os.WriteFile(nsPath, nil, 0o644)
+			} else {
+				// Hard links share the inode, i.e. the namespace ID.
+				err = os.Link(fmt.Sprintf("%s/1/ns/%s", proc, nsType), nsPath)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	oldCgroup, oldNamespace := cgroup, namespace
+	defer func() { cgroup, namespace = oldCgroup, oldNamespace }()
+	cgroup = proc + "/%d/cgroup"
+	namespace = proc + "/%d/ns/%s"
+
+	containerIDCache, err := lru.NewSynced[libpf.OnDiskFileIdentifier, containerIDEntry](
+		containerIDCacheSize, libpf.OnDiskFileIdentifier.Hash32)
+	if err != nil {
+		t.Fatalf("failed to provide cache: %v", err)
+	}
+	h := &Handler{containerIDCache: containerIDCache}
+	h.hostNamespaces, err = getNamespaces(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pid, expContainerized := range map[libpf.PID]bool{1: false, 2: false, 3: true} {
+		meta, err := h.GetContainerMetadata(pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Containerized != expContainerized {
+			t.Errorf("expected containerized %v for PID %d but got %v",
+				expContainerized, pid, meta.Containerized)
+		}
+	}
+}
+
 func TestGetKubernetesPodMetadata(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -156,7 +156,12 @@ func SetLevel(level logrus.Level) {
 }
 
 // SetJSONFormatter replaces the default Formatter settings with the given ones.
+// The fixed-width timestamp format of the default Formatter is used, unless the
+// formatter sets another one.
 func SetJSONFormatter(formatter logrus.JSONFormatter, serviceName string) {
+	if formatter.TimestampFormat == "" {
+		formatter.TimestampFormat = timeStampFormat
+	}
 	logger.(*logrus.Logger).SetFormatter(JSONFormatter{
 		formatter:   formatter,
 		serviceName: serviceName,
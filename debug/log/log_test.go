@@ -160,6 +160,20 @@ func TestLogging_logJSONFormatter(t *testing.T) {
 	assert.Equal(t, serviceName, r.Name)
 }
 
+func TestJSONFormatterTimestamp(t *testing.T) {
+	output := setupLogger(log.StandardLogger(), t)
+	log.SetJSONFormatter(logrus.JSONFormatter{}, "")
+
+	log.Info("testMsg")
+
+	var r struct {
+		Time string `json:"time"`
+	}
+	err := json.NewDecoder(output).Decode(&r)
+	assert.Nil(t, err)
+	assert.Regexp(t, `^[0-9\-]+T[0-9:]+\.[0-9]{9}(\+|\-|Z)([0-9:]+)?$`, r.Time)
+}
+
 func setupLogger(logger log.Logger, tb testing.TB) *bytes.Buffer {
 	b := bytes.NewBufferString("")
 	logger.(*logrus.Logger).Out = b
@@ -0,0 +1,367 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Package server implements the optional debug HTTP endpoint of the agent, which allows
+// controlling the agent at run time, e.g. from integration tests.
+//
+// The endpoint serves the following requests:
+//
+//	POST /flush  sends out the samples collected so far immediately and responds with
+//	             the number of samples sent as JSON, e.g. {"samples":42}
+//	GET /config  responds with the active profiling configuration as JSON, e.g.
+//	             {"samples_per_second":20,"effective_samples_per_second":10,
+//	             "modes":{"primary":true,"secondary":false}}, together with the settings the
+//	             agent resolved at startup from its flags, the environment and the defaults,
+//	             in which durations are nanoseconds. The effective frequency is the one the
+//	             agent samples with after the self-throttle and the idle backoff reduced the
+//	             configured one. Only the configured frequency can be changed.
+//	POST /config applies the changes of the configuration given as JSON, e.g.
+//	             {"modes":{"secondary":true}}, and responds with the resulting one.
+//	             Omitted fields are not changed.
+//	POST /diff   captures the samples of two consecutive intervals, A and B, of the length
+//	             given by the interval parameter, e.g. /diff?interval=30s, and responds
+//	             with a gzip compressed pprof profile of the samples of A and B and their
+//	             difference, e.g. to compare the profiles before and after a deploy.
+//	GET /samples streams the samples as they are reported as newline delimited JSON, until
+//	             the client disconnects. Samples are dropped if the client does not keep up.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+const (
+	// shutdownTimeout bounds the time requests in flight may take on shutdown.
+	shutdownTimeout = 5 * time.Second
+	// defaultDiffInterval is the length of the intervals of a differential profile if the
+	// request does not specify it.
+	defaultDiffInterval = 10 * time.Second
+	// maxDiffInterval bounds the length of the intervals of a differential profile.
+	maxDiffInterval = 10 * time.Minute
+	// sampleStreamBuffer is the number of samples buffered for a client of /samples.
+	sampleStreamBuffer = 1024
+)
+
+// errInvalidConfig is wrapped by the errors about invalid configuration changes.
+var errInvalidConfig = errors.New("invalid configuration")
+
+// flushResponse is the response to a flush request.
+type flushResponse struct {
+	Samples int `json:"samples"`
+}
+
+// Tracer is the part of the tracer that is reconfigured by the endpoint.
+type Tracer interface {
+	// SetSampleFrequency changes the configured sampling frequency of the primary event set.
+	SetSampleFrequency(sampleFreq int) error
+	// SampleFrequency returns the configured sampling frequency of the primary event set.
+	SampleFrequency() int
+	// EffectiveSampleFrequency returns the sampling frequency of the primary event set
+	// after the self-throttle and the idle backoff lowered it.
+	EffectiveSampleFrequency() int
+	// SetEventSetEnabled enables or disables sampling with the perf events of eventSet.
+	SetEventSetEnabled(eventSet libpf.EventSet, enabled bool) error
+}
+
+// Config is the profiling configuration that can be changed at run time.
+type Config struct {
+	// SamplesPerSecond is the configured sampling frequency of the primary event set.
+	SamplesPerSecond int `json:"samples_per_second"`
+	// EffectiveSamplesPerSecond is the sampling frequency of the primary event set after
+	// the self-throttle and the idle backoff lowered it. It can not be changed.
+	EffectiveSamplesPerSecond int `json:"effective_samples_per_second"`
+	// Modes maps the names of the profiling modes to whether they are enabled.
+	Modes map[string]bool `json:"modes"`
+	// Settings holds the configuration the agent resolved at startup. It is not changed at
+	// run time.
+	Settings any `json:"settings,omitempty"`
+}
+
+// configUpdate is the request to change the configuration. Nil fields are not changed.
+type configUpdate struct {
+	SamplesPerSecond *int            `json:"samples_per_second"`
+	Modes            map[string]bool `json:"modes"`
+}
+
+// Controller applies configuration changes to the tracer and keeps track of the active
+// configuration. The sampling frequency is owned by the tracer.
+type Controller struct {
+	mu     sync.Mutex
+	tracer Tracer
+	config Config
+	// eventSets maps the names of the profiling modes to the event sets sampling them.
+	eventSets map[string]libpf.EventSet
+}
+
+// NewController returns a Controller for tracer, which has all the event sets of the modes
+// enabled. The configuration includes settings, which has to be serializable as JSON and
+// must not be modified afterwards.
+func NewController(tracer Tracer, modes map[string]libpf.EventSet,
+	settings any) *Controller {
+	c := &Controller{
+		tracer:    tracer,
+		config:    Config{Modes: map[string]bool{}, Settings: settings},
+		eventSets: modes,
+	}
+	for name := range modes {
+		c.config.Modes[name] = true
+	}
+	return c
+}
+
+// Config returns a copy of the active configuration.
+func (c *Controller) Config() Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.copyConfig()
+}
+
+func (c *Controller) copyConfig() Config {
+	config := Config{SamplesPerSecond: c.tracer.SampleFrequency(),
+		EffectiveSamplesPerSecond: c.tracer.EffectiveSampleFrequency(),
+		Modes:                     make(map[string]bool, len(c.config.Modes)),
+		Settings:                  c.config.Settings}
+	for name, enabled := range c.config.Modes {
+		config.Modes[name] = enabled
+	}
+	return config
+}
+
+// update validates and applies the changes of u, and returns the resulting configuration.
+// Changes applied before an error occurred are kept.
+func (c *Controller) update(u configUpdate) (Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name := range u.Modes {
+		if _, ok := c.eventSets[name]; !ok {
+			return Config{}, fmt.Errorf("%w: unknown profiling mode %s",
+				errInvalidConfig, name)
+		}
+	}
+	if u.SamplesPerSecond != nil {
+		if *u.SamplesPerSecond <= 0 {
+			return Config{}, fmt.Errorf("%w: samples per second %d",
+				errInvalidConfig, *u.SamplesPerSecond)
+		}
+		if err := c.tracer.SetSampleFrequency(*u.SamplesPerSecond); err != nil {
+			return Config{}, err
+		}
+	}
+	for name, enabled := range u.Modes {
+		if c.config.Modes[name] == enabled {
+			continue
+		}
+		if err := c.tracer.SetEventSetEnabled(c.eventSets[name], enabled); err != nil {
+			return Config{}, err
+		}
+		c.config.Modes[name] = enabled
+	}
+	return c.copyConfig(), nil
+}
+
+// writeJSON writes v as JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("Failed to write response: %v", err)
+	}
+}
+
+// diffInterval returns the length of the intervals of the differential profile requested
+// by r.
+func diffInterval(r *http.Request) (time.Duration, error) {
+	param := r.URL.Query().Get("interval")
+	if param == "" {
+		return defaultDiffInterval, nil
+	}
+	interval, err := time.ParseDuration(param)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 || interval > maxDiffInterval {
+		return 0, fmt.Errorf("interval %v is not in (0, %v]", interval, maxDiffInterval)
+	}
+	return interval, nil
+}
+
+// streamSamples writes the samples of sub as newline delimited JSON to w until ctx is done.
+func streamSamples(ctx context.Context, w http.ResponseWriter, sub *reporter.Subscription) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	// The header is sent right away, so that the client knows that it is subscribed.
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sample, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(sample); err != nil {
+				log.Debugf("Failed to write sample: %v", err)
+				return
+			}
+			// Samples that arrived in the meantime are written before flushing.
+			if len(sub.C) == 0 && flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// newHandler returns the handler for the requests of the endpoint. The /config requests are
+// only served if ctrl is not nil, the /diff requests only if flusher implements
+// reporter.DiffProfiler, and the /samples requests only if it implements
+// reporter.SampleSubscriber.
+func newHandler(flusher reporter.Flusher, ctrl *Controller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		samples, err := flusher.Flush(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to flush: %v", err),
+				http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, flushResponse{Samples: samples})
+	})
+	if differ, ok := flusher.(reporter.DiffProfiler); ok {
+		mux.HandleFunc("/diff", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			interval, err := diffInterval(r)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid interval: %v", err), http.StatusBadRequest)
+				return
+			}
+			// The profile is buffered, so that errors can still be responded with.
+			var profile bytes.Buffer
+			if err = differ.WriteDiffProfile(r.Context(), interval, &profile); err != nil {
+				status := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, reporter.ErrCaptureInProgress):
+					status = http.StatusConflict
+				case errors.Is(err, reporter.ErrNotSupported):
+					status = http.StatusNotImplemented
+				}
+				http.Error(w, fmt.Sprintf("failed to capture profile: %v", err), status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", `attachment; filename="diff.pb.gz"`)
+			if _, err = w.Write(profile.Bytes()); err != nil {
+				log.Debugf("Failed to write response: %v", err)
+			}
+		})
+	}
+	if subscriber, ok := flusher.(reporter.SampleSubscriber); ok {
+		mux.HandleFunc("/samples", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			sub := subscriber.Subscribe(sampleStreamBuffer)
+			defer func() {
+				if dropped := sub.Dropped(); dropped > 0 {
+					log.Infof("Dropped %d samples of the debug endpoint stream", dropped)
+				}
+				sub.Close()
+			}()
+			streamSamples(r.Context(), w, sub)
+		})
+	}
+	if ctrl == nil {
+		return mux
+	}
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, ctrl.Config())
+		case http.MethodPost:
+			var u configUpdate
+			if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+				http.Error(w, fmt.Sprintf("%v: %v", errInvalidConfig, err),
+					http.StatusBadRequest)
+				return
+			}
+			config, err := ctrl.update(u)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, errInvalidConfig) {
+					status = http.StatusBadRequest
+				}
+				http.Error(w, fmt.Sprintf("failed to configure: %v", err), status)
+				return
+			}
+			log.Infof("Profiling reconfigured by debug endpoint: samples per second %d, "+
+				"modes %v", config.SamplesPerSecond, config.Modes)
+			writeJSON(w, config)
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// Start serves the debug endpoint on addr until ctx is done. Flush requests are handled by
+// flusher, and configuration requests by ctrl, if it is not nil.
+func Start(ctx context.Context, addr string, flusher reporter.Flusher, ctrl *Controller) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	srv := &http.Server{
+		Handler:           newHandler(flusher, ctrl),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Streaming requests end when ctx is done, as shutting down does not cancel them.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Debug endpoint failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Warnf("Failed to shut down debug endpoint: %v", err)
+		}
+	}()
+
+	log.Infof("Serving debug endpoint on %s", listener.Addr())
+	return nil
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+// fakeFlusher returns a fixed number of samples or error on each flush.
+type fakeFlusher struct {
+	samples int
+	err     error
+	calls   int
+}
+
+func (f *fakeFlusher) Flush(context.Context) (int, error) {
+	f.calls++
+	return f.samples, f.err
+}
+
+func TestFlush(t *testing.T) {
+	tests := map[string]struct {
+		method       string
+		flusher      fakeFlusher
+		expectedCode int
+		expectedBody string
+		expectFlush  bool
+	}{
+		"flush": {
+			method:       http.MethodPost,
+			flusher:      fakeFlusher{samples: 42},
+			expectedCode: http.StatusOK,
+			expectedBody: "{\"samples\":42}\n",
+			expectFlush:  true,
+		},
+		"failure": {
+			method:       http.MethodPost,
+			flusher:      fakeFlusher{err: errors.New("unavailable")},
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "failed to flush: unavailable\n",
+			expectFlush:  true,
+		},
+		"wrong method": {
+			method:       http.MethodGet,
+			expectedCode: http.StatusMethodNotAllowed,
+			expectedBody: "method not allowed\n",
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newHandler(&test.flusher, nil).ServeHTTP(rec,
+				httptest.NewRequest(test.method, "/flush", http.NoBody))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, test.expectedBody, rec.Body.String())
+			assert.Equal(t, test.expectFlush, test.flusher.calls == 1)
+		})
+	}
+}
+
+// fakeTracer records the configuration changes applied to it. Its sampling frequency is
+// lowered by divisor.
+type fakeTracer struct {
+	sampleFreq int
+	divisor    int
+	disabled   libpf.Set[libpf.EventSet]
+	err        error
+}
+
+func (f *fakeTracer) SetSampleFrequency(sampleFreq int) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sampleFreq = sampleFreq
+	return nil
+}
+
+func (f *fakeTracer) SampleFrequency() int {
+	return f.sampleFreq
+}
+
+func (f *fakeTracer) EffectiveSampleFrequency() int {
+	return f.sampleFreq / f.divisor
+}
+
+func (f *fakeTracer) SetEventSetEnabled(eventSet libpf.EventSet, enabled bool) error {
+	if enabled {
+		delete(f.disabled, eventSet)
+	} else {
+		f.disabled[eventSet] = libpf.Void{}
+	}
+	return f.err
+}
+
+func TestConfig(t *testing.T) {
+	tests := map[string]struct {
+		method       string
+		body         string
+		err          error
+		expectedCode int
+		expectedBody string
+		expectedFreq int
+	}{
+		"get": {
+			method:       http.MethodGet,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"samples_per_second":20,"effective_samples_per_second":5,` +
+				`"modes":{"primary":true,"secondary":true},"settings":{"tracers":"all"}}`,
+			expectedFreq: 20,
+		},
+		"update": {
+			method:       http.MethodPost,
+			body:         `{"samples_per_second":50,"modes":{"secondary":false}}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"samples_per_second":50,"effective_samples_per_second":12,` +
+				`"modes":{"primary":true,"secondary":false},"settings":{"tracers":"all"}}`,
+			expectedFreq: 50,
+		},
+		"unknown mode": {
+			method:       http.MethodPost,
+			body:         `{"modes":{"off-cpu":true}}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "failed to configure: invalid configuration: " +
+				"unknown profiling mode off-cpu",
+			expectedFreq: 20,
+		},
+		"invalid frequency": {
+			method:       http.MethodPost,
+			body:         `{"samples_per_second":0}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "failed to configure: invalid configuration: samples per second 0",
+			expectedFreq: 20,
+		},
+		"tracer failure": {
+			method:       http.MethodPost,
+			body:         `{"samples_per_second":50}`,
+			err:          errors.New("no perf events"),
+			expectedCode: http.StatusInternalServerError,
+			expectedBody: "failed to configure: no perf events",
+			expectedFreq: 20,
+		},
+		"wrong method": {
+			method:       http.MethodPut,
+			expectedCode: http.StatusMethodNotAllowed,
+			expectedBody: "method not allowed",
+			expectedFreq: 20,
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			tracer := &fakeTracer{sampleFreq: 20, divisor: 4,
+				disabled: libpf.Set[libpf.EventSet]{}, err: test.err}
+			ctrl := NewController(tracer, map[string]libpf.EventSet{
+				"primary":   libpf.PrimaryEventSet,
+				"secondary": libpf.SecondaryEventSet,
+			}, map[string]string{"tracers": "all"})
+			rec := httptest.NewRecorder()
+			newHandler(&fakeFlusher{}, ctrl).ServeHTTP(rec,
+				httptest.NewRequest(test.method, "/config", strings.NewReader(test.body)))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, test.expectedBody, strings.TrimSpace(rec.Body.String()))
+			assert.Equal(t, test.expectedFreq, tracer.sampleFreq)
+			if test.expectedCode == http.StatusOK {
+				assert.Equal(t, ctrl.Config().Modes["secondary"],
+					len(tracer.disabled) == 0)
+			}
+		})
+	}
+}
+
+// fakeDiffProfiler writes a fixed profile or returns an error on each request.
+type fakeDiffProfiler struct {
+	fakeFlusher
+	err      error
+	interval time.Duration
+}
+
+func (f *fakeDiffProfiler) WriteDiffProfile(_ context.Context, interval time.Duration,
+	w io.Writer) error {
+	f.interval = interval
+	if f.err != nil {
+		return f.err
+	}
+	_, err := io.WriteString(w, "profile")
+	return err
+}
+
+func TestDiff(t *testing.T) {
+	tests := map[string]struct {
+		target           string
+		err              error
+		expectedCode     int
+		expectedBody     string
+		expectedInterval time.Duration
+	}{
+		"default interval": {
+			target:           "/diff",
+			expectedCode:     http.StatusOK,
+			expectedBody:     "profile",
+			expectedInterval: defaultDiffInterval,
+		},
+		"interval": {
+			target:           "/diff?interval=30s",
+			expectedCode:     http.StatusOK,
+			expectedBody:     "profile",
+			expectedInterval: 30 * time.Second,
+		},
+		"invalid interval": {
+			target:       "/diff?interval=-1s",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "invalid interval: interval -1s is not in (0, 10m0s]",
+		},
+		"in progress": {
+			target:           "/diff",
+			err:              reporter.ErrCaptureInProgress,
+			expectedCode:     http.StatusConflict,
+			expectedBody:     "failed to capture profile: " + reporter.ErrCaptureInProgress.Error(),
+			expectedInterval: defaultDiffInterval,
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			differ := &fakeDiffProfiler{err: test.err}
+			rec := httptest.NewRecorder()
+			newHandler(differ, nil).ServeHTTP(rec,
+				httptest.NewRequest(http.MethodPost, test.target, http.NoBody))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, test.expectedBody, strings.TrimSpace(rec.Body.String()))
+			assert.Equal(t, test.expectedInterval, differ.interval)
+		})
+	}
+
+	// Without support of the reporter, the requests are not served.
+	rec := httptest.NewRecorder()
+	newHandler(&fakeFlusher{}, nil).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/diff", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// fakeSubscriber streams the samples reported to the OTLP reporter it embeds.
+type fakeSubscriber struct {
+	fakeFlusher
+	*reporter.OTLPReporter
+}
+
+func (f *fakeSubscriber) Flush(ctx context.Context) (int, error) {
+	return f.fakeFlusher.Flush(ctx)
+}
+
+func TestSamples(t *testing.T) {
+	r, err := reporter.NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+
+	server := httptest.NewServer(newHandler(&fakeSubscriber{OTLPReporter: r}, nil))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/samples")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	// The subscription is made before the response header is sent.
+	r.ReportCountForTrace(traceHash, &reporter.TraceEventMeta{
+		Timestamp: 1700000000,
+		Count:     1,
+		Comm:      "worker",
+		EventSet:  libpf.PrimaryEventSet,
+	})
+	var sample reporter.LiveSample
+	if assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sample)) {
+		assert.Equal(t, "worker", sample.Comm)
+		assert.Equal(t, uint16(1), sample.Count)
+	}
+}
@@ -63,4 +63,15 @@ type Trace struct {
 	Hash   TraceHash
 	KTime  libpf.KTime
 	PID    libpf.PID
+	TID    libpf.PID
+	// CPU is the number of the CPU the trace was sampled on.
+	CPU int
+	// Syscall is the name of the system call the thread executed when the trace was
+	// sampled, or empty if it is not known.
+	Syscall string
+	// EventSet is the set of perf events whose event triggered the sampling.
+	EventSet libpf.EventSet
+	// Period is the period of the perf event sample, i.e. the nanoseconds of CPU time since
+	// the previous sample on the CPU for clock events.
+	Period uint64
 }
@@ -11,16 +11,18 @@ import (
 	"os"
 
 	"github.com/elastic/otel-profiling-agent/config"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 	"github.com/elastic/otel-profiling-agent/libpf/vc"
 )
 
 // Agent metadata keys
 const (
 	// Build metadata
-	keyAgentVersion        = "agent:version"
-	keyAgentRevision       = "agent:revision"
-	keyAgentBuildTimestamp = "agent:build_timestamp"
-	keyAgentStartTimeMilli = "agent:start_time_milli"
+	keyAgentVersion         = "agent:version"
+	keyAgentRevision        = "agent:revision"
+	keyAgentBuildTimestamp  = "agent:build_timestamp"
+	keyAgentStartTimeMilli  = "agent:start_time_milli"
+	keyAgentFileIDAlgorithm = "agent:fileid_algorithm"
 
 	// Environment metadata
 	keyAgentEnvHTTPSProxy = "agent:env_https_proxy"
@@ -53,6 +55,7 @@ func AddMetadata(result map[string]string) {
 
 	result[keyAgentBuildTimestamp] = vc.BuildTimestamp()
 	result[keyAgentStartTimeMilli] = fmt.Sprintf("%d", config.StartTime().UnixMilli())
+	result[keyAgentFileIDAlgorithm] = pfelf.FileIDAlgorithm
 
 	bpfLogLevel, bpfLogSize := config.BpfVerifierLogSetting()
 	result[keyAgentConfigBpfLoglevel] = fmt.Sprintf("%d", bpfLogLevel)
@@ -0,0 +1,103 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CoreType describes the kind of a CPU core on hybrid systems.
+type CoreType string
+
+const (
+	// CoreTypePerformance denotes a performance core, e.g. an Intel P-core or an ARM big core.
+	CoreTypePerformance CoreType = "performance"
+	// CoreTypeEfficiency denotes an efficiency core, e.g. an Intel E-core or an ARM LITTLE
+	// core.
+	CoreTypeEfficiency CoreType = "efficiency"
+)
+
+// CoreTypes returns the core type of each online CPU, keyed by the CPU number. A nil map is
+// returned if all CPUs are of the same type.
+func CoreTypes() (map[int]CoreType, error) {
+	return readCoreTypes("/sys")
+}
+
+// readCoreTypes determines the core types from the sysfs mounted at sysfs. On Intel hybrid
+// CPUs the kernel registers a separate PMU for each core type, which lists the CPUs it
+// covers. On ARM the relative capacity of each CPU is used instead: the CPUs with the
+// highest capacity are the performance cores.
+func readCoreTypes(sysfs string) (map[int]CoreType, error) {
+	coreTypes := make(map[int]CoreType)
+	for pmu, coreType := range map[string]CoreType{
+		"cpu_core": CoreTypePerformance,
+		"cpu_atom": CoreTypeEfficiency,
+	} {
+		buf, err := os.ReadFile(filepath.Join(sysfs, "devices", pmu, "cpus"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		cpus, err := readCPURange(string(buf))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list of PMU %s: %v", pmu, err)
+		}
+		for _, cpu := range cpus {
+			coreTypes[cpu] = coreType
+		}
+	}
+	if len(coreTypes) != 0 {
+		return coreTypes, nil
+	}
+
+	cpuDir := filepath.Join(sysfs, "devices", "system", "cpu")
+	cpus, err := ParseCPUCoreIDs(filepath.Join(cpuDir, "online"))
+	if err != nil {
+		return nil, err
+	}
+	capacities := make(map[int]uint64, len(cpus))
+	var maxCapacity, minCapacity uint64
+	for _, cpu := range cpus {
+		buf, err := os.ReadFile(filepath.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "cpu_capacity"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Only asymmetric ARM systems expose the capacity.
+				return nil, nil
+			}
+			return nil, err
+		}
+		capacity, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capacity of CPU %d: %v", cpu, err)
+		}
+		capacities[cpu] = capacity
+		if len(capacities) == 1 || capacity < minCapacity {
+			minCapacity = capacity
+		}
+		maxCapacity = max(maxCapacity, capacity)
+	}
+	if maxCapacity == minCapacity {
+		return nil, nil
+	}
+	for cpu, capacity := range capacities {
+		coreTypes[cpu] = CoreTypeEfficiency
+		if capacity == maxCapacity {
+			coreTypes[cpu] = CoreTypePerformance
+		}
+	}
+	return coreTypes, nil
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSysfs creates the given files below a temporary sysfs root and returns the root.
+func writeSysfs(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return root
+}
+
+func TestReadCoreTypes(t *testing.T) {
+	tests := map[string]struct {
+		files    map[string]string
+		expected map[int]CoreType
+	}{
+		"intel hybrid": {
+			files: map[string]string{
+				"devices/cpu_core/cpus":     "0-1\n",
+				"devices/cpu_atom/cpus":     "2-3\n",
+				"devices/system/cpu/online": "0-3\n",
+			},
+			expected: map[int]CoreType{
+				0: CoreTypePerformance,
+				1: CoreTypePerformance,
+				2: CoreTypeEfficiency,
+				3: CoreTypeEfficiency,
+			},
+		},
+		"arm big.LITTLE": {
+			files: map[string]string{
+				"devices/system/cpu/online":            "0-2\n",
+				"devices/system/cpu/cpu0/cpu_capacity": "446\n",
+				"devices/system/cpu/cpu1/cpu_capacity": "1024\n",
+				"devices/system/cpu/cpu2/cpu_capacity": "871\n",
+			},
+			expected: map[int]CoreType{
+				0: CoreTypeEfficiency,
+				1: CoreTypePerformance,
+				2: CoreTypeEfficiency,
+			},
+		},
+		"arm symmetric": {
+			files: map[string]string{
+				"devices/system/cpu/online":            "0-1\n",
+				"devices/system/cpu/cpu0/cpu_capacity": "1024\n",
+				"devices/system/cpu/cpu1/cpu_capacity": "1024\n",
+			},
+		},
+		"no capacity": {
+			files: map[string]string{
+				"devices/system/cpu/online": "0-7\n",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			coreTypes, err := readCoreTypes(writeSysfs(t, test.files))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, coreTypes)
+		})
+	}
+}
@@ -0,0 +1,619 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package beam
+
+// BEAM (Erlang virtual machine) unwinder
+//
+// The eBPF unwinder (beam_tracer.ebpf.c) reports the instruction pointer and the
+// continuation pointers found on the Erlang stack of the process executed by the
+// sampled scheduler thread. All of these point into the loaded code of a module.
+//
+// The loaded modules are tracked by the emulator in a range table per code index
+// (beam_ranges.c), sorted by address, from which the BeamCodeHeader of the module
+// containing an address is found. The header holds the sorted table of the
+// ErtsCodeInfo of its functions, which precede the code of each function and hold
+// its module, function and arity (MFA). Module and function are atoms, whose names
+// are stored in the atom table.
+//
+// Only the emulator flavor (interpreter) is supported. With the JIT flavor, Erlang
+// code is translated to native code which keeps none of the structures used here.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/interpreter"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/freelru"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+	"github.com/elastic/otel-profiling-agent/libpf/successfailurecounter"
+	"github.com/elastic/otel-profiling-agent/metrics"
+	"github.com/elastic/otel-profiling-agent/reporter"
+	"github.com/elastic/otel-profiling-agent/support"
+	"github.com/elastic/otel-profiling-agent/tpbase"
+)
+
+// #include "../../support/ebpf/types.h"
+import "C"
+
+// nolint:lll
+const (
+	// ERTS_NUM_CODE_IX
+	// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/code_ix.h#L72
+	numCodeIx = 3
+
+	// _TAG_IMMED2_ATOM and _TAG_IMMED2_SIZE
+	// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/erl_term.h#L101
+	atomTag       = 0x0b
+	atomTagMask   = 0x3f
+	atomTagShift  = 6
+	maxAtomLength = 255 * 4
+
+	// INDEX_PAGE_SHIFT
+	// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/index.h#L40
+	indexPageShift = 10
+	indexPageMask  = 1<<indexPageShift - 1
+
+	// maxModules and maxFunctions bound the binary searches in the range and function
+	// tables to sane table sizes.
+	maxModules   = 1 << 20
+	maxFunctions = 1 << 20
+)
+
+var (
+	// regex to identify the BEAM executable, e.g. beam.smp or beam.debug.smp
+	beamRegex = regexp.MustCompile(`^(?:.*/)?beam(?:\.[a-z]+)?\.smp$`)
+	// regex to extract the version from the ERTS version string
+	beamVersionRegex = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?`)
+
+	// compiler check to make sure the needed interfaces are satisfied
+	_ interpreter.Data           = &beamData{}
+	_ interpreter.VersionLabeler = &beamData{}
+	_ interpreter.Instance       = &beamInstance{}
+)
+
+// nolint:lll
+type beamData struct {
+	// version of the ERTS: major*0x10000 + minor*0x100 + patch (e.g. 14.2.1 -> 0xe0201)
+	version uint32
+
+	// esdKey is the address of `erts_esd_key`, the TSD key of the scheduler data.
+	esdKey libpf.Address
+	// activeCodeIndex is the address of `the_active_code_index`.
+	activeCodeIndex libpf.Address
+	// ranges is the address of the range table `r` of beam_ranges.c.
+	ranges libpf.Address
+	// atomTable is the address of `erts_atom_table`.
+	atomTable libpf.Address
+
+	// vmStructs reflects the ERTS internal names and offsets of named fields.
+	// nolint:golint,stylecheck,revive
+	vmStructs struct {
+		// ErtsSchedulerData
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/erl_process.h#L650
+		ErtsSchedulerData struct {
+			current_process uint16
+		}
+
+		// Process
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/erl_process.h#L1009
+		Process struct {
+			stop, hend, i uint16
+		}
+
+		// struct ranges and Range
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/beam_ranges.c#L29
+		ranges struct {
+			modules, n, sizeof uint8
+		}
+		Range struct {
+			start, end, sizeof uint8
+		}
+
+		// BeamCodeHeader
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/beam_code.h#L50
+		BeamCodeHeader struct {
+			num_functions, functions uint8
+		}
+
+		// ErtsCodeInfo and ErtsCodeMFA
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/code_ix.h
+		ErtsCodeInfo struct {
+			mfa uint8
+		}
+		ErtsCodeMFA struct {
+			module, function, arity uint8
+		}
+
+		// IndexTable, the Hash it starts with, and Atom
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/index.h#L35
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/hash.h
+		// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/atom.h#L75
+		IndexTable struct {
+			seg_table uint8
+		}
+		Atom struct {
+			len, name uint8
+		}
+	}
+}
+
+func (d *beamData) VersionLabel() (name, value string) {
+	ver := d.version
+	return "erts.version", fmt.Sprintf("%d.%d.%d", (ver>>16)&0xff, (ver>>8)&0xff, ver&0xff)
+}
+
+func (d *beamData) Attach(_ interpreter.EbpfHandler, _ libpf.PID, bias libpf.Address,
+	rm remotememory.RemoteMemory) (interpreter.Instance, error) {
+	addrToFunction, err := freelru.New[libpf.Address, *beamFunction](
+		interpreter.LruFunctionCacheSize, libpf.Address.Hash32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &beamInstance{
+		d:              d,
+		rm:             rm,
+		bias:           bias,
+		addrToFunction: addrToFunction,
+	}, nil
+}
+
+// beamFunction holds the information extracted for an address in the loaded code.
+type beamFunction struct {
+	// fileID is the synthesized ID of the function
+	fileID libpf.FileID
+	// offset of the address from the start of the function
+	offset libpf.AddressOrLineno
+}
+
+type beamInstance struct {
+	interpreter.InstanceStubs
+
+	// BEAM symbolization metrics
+	successCount atomic.Uint64
+	failCount    atomic.Uint64
+
+	d    *beamData
+	rm   remotememory.RemoteMemory
+	bias libpf.Address
+
+	// addrToFunction maps an address in the loaded code to the function it belongs to.
+	addrToFunction *freelru.LRU[libpf.Address, *beamFunction]
+
+	// procInfoInserted tracks whether we've already inserted process info into BPF maps.
+	procInfoInserted bool
+}
+
+func (i *beamInstance) UpdateTSDInfo(ebpf interpreter.EbpfHandler, pid libpf.PID,
+	tsdInfo tpbase.TSDInfo) error {
+	d := i.d
+	vms := &d.vmStructs
+	cdata := C.BEAMProcInfo{
+		esd_key_addr: C.u64(d.esdKey + i.bias),
+		version:      C.u32(d.version),
+
+		tsdInfo: C.TSDInfo{
+			offset:     C.s16(tsdInfo.Offset),
+			multiplier: C.u8(tsdInfo.Multiplier),
+			indirect:   C.u8(tsdInfo.Indirect),
+		},
+
+		current_process: C.u16(vms.ErtsSchedulerData.current_process),
+		process_stop:    C.u16(vms.Process.stop),
+		process_hend:    C.u16(vms.Process.hend),
+		process_i:       C.u16(vms.Process.i),
+	}
+
+	if err := ebpf.UpdateProcData(libpf.BEAM, pid, unsafe.Pointer(&cdata)); err != nil {
+		return err
+	}
+
+	i.procInfoInserted = true
+	return nil
+}
+
+func (i *beamInstance) Detach(ebpf interpreter.EbpfHandler, pid libpf.PID) error {
+	if !i.procInfoInserted {
+		return nil
+	}
+	return ebpf.DeleteProcData(libpf.BEAM, pid)
+}
+
+// lookupModule returns the address of the BeamCodeHeader of the module whose code contains
+// addr, following erts_lookup_function_info.
+//
+// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/beam_ranges.c#L281
+func (i *beamInstance) lookupModule(addr libpf.Address) (libpf.Address, error) {
+	vms := &i.d.vmStructs
+	codeIx := i.rm.Uint32(i.d.activeCodeIndex + i.bias)
+	if codeIx >= numCodeIx {
+		return 0, fmt.Errorf("invalid active code index %d", codeIx)
+	}
+	ranges := i.d.ranges + i.bias + libpf.Address(codeIx)*libpf.Address(vms.ranges.sizeof)
+	modules := i.rm.Ptr(ranges + libpf.Address(vms.ranges.modules))
+	n := i.rm.Uint64(ranges + libpf.Address(vms.ranges.n))
+	if modules == 0 || n > maxModules {
+		return 0, fmt.Errorf("invalid range table at 0x%x", ranges)
+	}
+
+	low, high := uint64(0), n
+	for low < high {
+		mid := low + (high-low)/2
+		rp := modules + libpf.Address(mid)*libpf.Address(vms.Range.sizeof)
+		start := i.rm.Ptr(rp + libpf.Address(vms.Range.start))
+		end := i.rm.Ptr(rp + libpf.Address(vms.Range.end))
+		switch {
+		case addr < start:
+			high = mid
+		case addr >= end:
+			low = mid + 1
+		default:
+			return start, nil
+		}
+	}
+	return 0, fmt.Errorf("no module contains 0x%x", addr)
+}
+
+// lookupFunction returns the address of the ErtsCodeInfo of the function of the module with
+// the BeamCodeHeader at hdr whose code contains addr.
+func (i *beamInstance) lookupFunction(hdr, addr libpf.Address) (libpf.Address, error) {
+	vms := &i.d.vmStructs
+	numFunctions := i.rm.Uint64(hdr + libpf.Address(vms.BeamCodeHeader.num_functions))
+	if numFunctions > maxFunctions {
+		return 0, fmt.Errorf("invalid number of functions %d in 0x%x", numFunctions, hdr)
+	}
+	functions := hdr + libpf.Address(vms.BeamCodeHeader.functions)
+
+	// The function table has an additional entry marking the end of the last function.
+	low, high := uint64(0), numFunctions
+	for low < high {
+		mid := low + (high-low)/2
+		ci := i.rm.Ptr(functions + libpf.Address(mid*8))
+		next := i.rm.Ptr(functions + libpf.Address((mid+1)*8))
+		switch {
+		case addr < ci:
+			high = mid
+		case addr >= next:
+			low = mid + 1
+		default:
+			return ci, nil
+		}
+	}
+	return 0, fmt.Errorf("no function of 0x%x contains 0x%x", hdr, addr)
+}
+
+// readAtom returns the name of the atom term.
+//
+// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/atom.h#L87
+func (i *beamInstance) readAtom(term uint64) (string, error) {
+	if term&atomTagMask != atomTag {
+		return "", fmt.Errorf("term 0x%x is not an atom", term)
+	}
+	vms := &i.d.vmStructs
+	index := term >> atomTagShift
+
+	segTable := i.rm.Ptr(i.d.atomTable + i.bias + libpf.Address(vms.IndexTable.seg_table))
+	segment := i.rm.Ptr(segTable + libpf.Address(index>>indexPageShift)*8)
+	if segment == 0 {
+		return "", fmt.Errorf("atom %d not found", index)
+	}
+	atom := i.rm.Ptr(segment + libpf.Address(index&indexPageMask)*8)
+	if atom == 0 {
+		return "", fmt.Errorf("atom %d not found", index)
+	}
+
+	length := int16(i.rm.Uint16(atom + libpf.Address(vms.Atom.len)))
+	if length < 0 || length > maxAtomLength {
+		return "", fmt.Errorf("invalid length %d of atom %d", length, index)
+	}
+	name := make([]byte, length)
+	if err := i.rm.Read(i.rm.Ptr(atom+libpf.Address(vms.Atom.name)), name); err != nil {
+		return "", fmt.Errorf("failed to read atom %d: %v", index, err)
+	}
+	return string(name), nil
+}
+
+// readMFA returns the module:function/arity name of the function with the ErtsCodeInfo at ci.
+func (i *beamInstance) readMFA(ci libpf.Address) (string, error) {
+	vms := &i.d.vmStructs
+	mfa := ci + libpf.Address(vms.ErtsCodeInfo.mfa)
+	module, err := i.readAtom(i.rm.Uint64(mfa + libpf.Address(vms.ErtsCodeMFA.module)))
+	if err != nil {
+		return "", err
+	}
+	function, err := i.readAtom(i.rm.Uint64(mfa + libpf.Address(vms.ErtsCodeMFA.function)))
+	if err != nil {
+		return "", err
+	}
+	arity := i.rm.Uint64(mfa + libpf.Address(vms.ErtsCodeMFA.arity))
+	return fmt.Sprintf("%s:%s/%d", module, function, arity), nil
+}
+
+func (i *beamInstance) Symbolize(symbolReporter reporter.SymbolReporter,
+	frame *host.Frame, trace *libpf.Trace) error {
+	if !frame.Type.IsInterpType(libpf.BEAM) {
+		return interpreter.ErrMismatchInterpreterType
+	}
+
+	sfCounter := successfailurecounter.New(&i.successCount, &i.failCount)
+	defer sfCounter.DefaultToFailure()
+
+	// The eBPF BEAM unwinder reports the address in the loaded code in the Linenos field.
+	addr := libpf.Address(frame.Lineno)
+	if function, ok := i.addrToFunction.Get(addr); ok {
+		trace.AppendFrame(libpf.BEAMFrame, function.fileID, function.offset)
+		sfCounter.ReportSuccess()
+		return nil
+	}
+
+	hdr, err := i.lookupModule(addr)
+	if err != nil {
+		return err
+	}
+	ci, err := i.lookupFunction(hdr, addr)
+	if err != nil {
+		return err
+	}
+	functionName, err := i.readMFA(ci)
+	if err != nil {
+		return err
+	}
+	if !libpf.IsValidString(functionName) {
+		log.Debugf("Extracted invalid BEAM function name at 0x%x '%v'",
+			ci, []byte(functionName))
+		return fmt.Errorf("extracted invalid BEAM function name from address 0x%x", ci)
+	}
+
+	// The fnv hash Write() method calls cannot fail, so it's safe to ignore the errors.
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(functionName))
+	_, _ = h.Write(binary.LittleEndian.AppendUint64(nil, uint64(ci)))
+	fileID, err := libpf.FileIDFromBytes(h.Sum(nil))
+	if err != nil {
+		return fmt.Errorf("failed to create a file ID: %v", err)
+	}
+
+	function := &beamFunction{
+		fileID: fileID,
+		offset: libpf.AddressOrLineno(addr - ci),
+	}
+	i.addrToFunction.Add(addr, function)
+
+	trace.AppendFrame(libpf.BEAMFrame, fileID, function.offset)
+
+	// The emulator code carries no line information that maps back to the source, so the
+	// offset within the function is reported instead.
+	symbolReporter.FrameMetadata(fileID, function.offset, 0, uint32(function.offset),
+		functionName, interpreter.UnknownSourceFile)
+
+	log.Debugf("[%d] [%x] %v+%v", len(trace.FrameTypes), fileID, functionName,
+		function.offset)
+
+	sfCounter.ReportSuccess()
+	return nil
+}
+
+func (i *beamInstance) GetAndResetMetrics() ([]metrics.Metric, error) {
+	addrToFunctionStats := i.addrToFunction.GetAndResetStatistics()
+
+	return []metrics.Metric{
+		{
+			ID:    metrics.IDBEAMSymbolizationSuccess,
+			Value: metrics.MetricValue(i.successCount.Swap(0)),
+		},
+		{
+			ID:    metrics.IDBEAMSymbolizationFailure,
+			Value: metrics.MetricValue(i.failCount.Swap(0)),
+		},
+		{
+			ID:    metrics.IDBEAMAddrToFunctionHit,
+			Value: metrics.MetricValue(addrToFunctionStats.Hit),
+		},
+		{
+			ID:    metrics.IDBEAMAddrToFunctionMiss,
+			Value: metrics.MetricValue(addrToFunctionStats.Miss),
+		},
+		{
+			ID:    metrics.IDBEAMAddrToFunctionAdd,
+			Value: metrics.MetricValue(addrToFunctionStats.Added),
+		},
+		{
+			ID:    metrics.IDBEAMAddrToFunctionDel,
+			Value: metrics.MetricValue(addrToFunctionStats.Deleted),
+		},
+	}, nil
+}
+
+// determineBEAMVersion looks for the symbol etp_erts_version and extracts version
+// information from its value.
+func determineBEAMVersion(ef *pfelf.File) (uint32, error) {
+	sym, err := ef.LookupSymbol("etp_erts_version")
+	if err != nil {
+		return 0, fmt.Errorf("symbol etp_erts_version not found: %v", err)
+	}
+
+	memory := make([]byte, 16)
+	if _, err := ef.ReadVirtualMemory(memory, int64(sym.Address)); err != nil {
+		return 0, fmt.Errorf("failed to read process memory at 0x%x:%v",
+			sym.Address, err)
+	}
+
+	return parseVersion(string(memory))
+}
+
+// parseVersion parses an ERTS version string, e.g. "14.2.1".
+func parseVersion(version string) (uint32, error) {
+	matches := beamVersionRegex.FindStringSubmatch(version)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid ERTS version '%s'", version)
+	}
+
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	patch, _ := strconv.Atoi(matches[3])
+	if major > 0xff || minor > 0xff || patch > 0xff {
+		return 0, fmt.Errorf("invalid ERTS version '%s'", version)
+	}
+
+	return uint32(major*0x10000 + minor*0x100 + patch), nil
+}
+
+// newBEAMData returns the beamData for the given ERTS version with the struct field
+// offsets set.
+func newBEAMData(version uint32) *beamData {
+	d := &beamData{version: version}
+	vms := &d.vmStructs
+
+	// ERTS does not provide introspection data, hard code the struct field offsets of
+	// the 64-bit builds of the supported versions. The structs, whose sources are linked in
+	// vmStructs, are the same in ERTS 13 (OTP 25) and ERTS 14 (OTP 26). The members that
+	// precede each field are noted to derive its offset.
+
+	// current_process follows the registers, the timer and ETS data and the sleep info.
+	vms.ErtsSchedulerData.current_process = 176
+
+	// The 80 bytes of ErtsPTabElementCommon common and htop precede stop, which is followed
+	// by fcalls, freason, fvalue, heap and hend. abandoned_heap, heap_sz, min_heap_size,
+	// min_vheap_size, max_heap_size, num_live, arg_reg, max_arg_reg and def_arg_reg[6]
+	// follow before i.
+	vms.Process.stop = 88
+	vms.Process.hend = 128
+	vms.Process.i = 248
+
+	// struct ranges is Range *modules, Sint n, Sint allocated and erts_atomic_t mid.
+	vms.ranges.modules = 0
+	vms.ranges.n = 8
+	vms.ranges.sizeof = 32
+	// Range is ErtsCodePtr start and erts_atomic_t end.
+	vms.Range.start = 0
+	vms.Range.end = 8
+	vms.Range.sizeof = 16
+
+	// num_functions is followed by attr_ptr, attr_size, attr_size_on_heap, compile_ptr,
+	// compile_size, compile_size_on_heap, literal_area, on_load, are_nifs and line_table
+	// before the functions table.
+	vms.BeamCodeHeader.num_functions = 0
+	vms.BeamCodeHeader.functions = 88
+	// The op word and the breakpoint union u precede mfa, which is Eterm module,
+	// Eterm function and Uint arity.
+	vms.ErtsCodeInfo.mfa = 16
+	vms.ErtsCodeMFA.module = 0
+	vms.ErtsCodeMFA.function = 8
+	vms.ErtsCodeMFA.arity = 16
+
+	// The Hash htable holds the seven functions of HashFunctions fun, is_allocated,
+	// meta_alloc_type, name, shift, max_shift, shrink_threshold, grow_threshold, nobjs and
+	// bucket, 104 bytes. It is followed by the ints type, size, limit and entries before
+	// seg_table.
+	vms.IndexTable.seg_table = 120
+	// The IndexSlot slot, i.e. HashBucket bucket with next and hvalue and the int index,
+	// precedes len, which is followed by latin1_chars and ord0 before name.
+	vms.Atom.len = 24
+	vms.Atom.name = 32
+	return d
+}
+
+// Loader is the interpreter.Loader for BEAM.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
+
+// Detect implements the interpreter.Loader interface.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return beamRegex.MatchString(info.FileName())
+}
+
+// New implements the interpreter.Loader interface.
+func (loader) New(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
+	ef, err := info.GetELF()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := determineBEAMVersion(ef)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reason for lowest supported version:
+	// - ERTS 13 (OTP 25) is the oldest release receiving fixes at time of writing this code.
+	// Reason for maximum supported version 14.x:
+	// - ERTS 14 (OTP 26) is currently the newest stable version.
+	const minVer, maxVer = 0xd0000, 0xf0000
+	versionString := fmt.Sprintf("%d.%d.%d",
+		(version>>16)&0xff, (version>>8)&0xff, version&0xff)
+	supported := fmt.Sprintf(">= %d.%d and < %d.%d without JIT",
+		(minVer>>16)&0xff, (minVer>>8)&0xff, (maxVer>>16)&0xff, (maxVer>>8)&0xff)
+	if version < minVer || version >= maxVer {
+		return nil, &interpreter.UnsupportedVersionError{
+			Runtime:   "Erlang",
+			Version:   versionString,
+			Supported: supported,
+		}
+	}
+	// beamasm_init only exists in the JIT flavor of the BEAM.
+	if _, err = ef.LookupSymbol("beamasm_init"); err == nil {
+		return nil, &interpreter.UnsupportedVersionError{
+			Runtime:   "Erlang",
+			Version:   versionString + " with JIT",
+			Supported: supported,
+		}
+	}
+
+	d := newBEAMData(version)
+	for _, sym := range []struct {
+		name libpf.SymbolName
+		addr *libpf.Address
+	}{
+		{"erts_esd_key", &d.esdKey},
+		{"the_active_code_index", &d.activeCodeIndex},
+		{"erts_atom_table", &d.atomTable},
+	} {
+		addr, err := ef.LookupSymbolAddress(sym.name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v not found: %v", interpreter.ErrOffsetsUnavailable, sym.name, err)
+		}
+		*sym.addr = libpf.Address(addr)
+	}
+
+	// The range table is a static variable, so it is only found in the symbol table.
+	symbols, err := ef.ReadSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symbols: %v", err)
+	}
+	ranges, err := symbols.LookupSymbol("r")
+	if err != nil {
+		return nil, fmt.Errorf("%w: range table not found: %v", interpreter.ErrOffsetsUnavailable, err)
+	}
+	if ranges.Size != numCodeIx*int(d.vmStructs.ranges.sizeof) {
+		return nil, fmt.Errorf("unexpected range table size %d", ranges.Size)
+	}
+	d.ranges = libpf.Address(ranges.Address)
+
+	// process_main is the emulator loop executing the Erlang code.
+	interpRanges, err := info.GetSymbolAsRanges("process_main")
+	if err != nil {
+		return nil, err
+	}
+	if err = ebpf.UpdateInterpreterOffsets(support.ProgUnwindBEAM, info.FileID(),
+		interpRanges); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package beam
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/interpreter"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+func TestBEAMRegex(t *testing.T) {
+	for _, s := range []string{"beam.smp", "/usr/lib/erlang/erts-14.2.1/bin/beam.smp",
+		"beam.debug.smp"} {
+		assert.True(t, beamRegex.MatchString(s), s)
+	}
+	for _, s := range []string{"beam", "erl", "beam.smp.so", "foobeam.smp"} {
+		assert.False(t, beamRegex.MatchString(s), s)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := map[string]struct {
+		given       string
+		expected    uint32
+		expectError bool
+	}{
+		"major.minor":       {given: "14.2", expected: 0xe0200},
+		"major.minor.patch": {given: "13.2.2\x00\x00", expected: 0xd0202},
+		"invalid":           {given: "erts", expectError: true},
+		"out of range":      {given: "14.256", expectError: true},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			version, err := parseVersion(test.given)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, version)
+		})
+	}
+}
+
+// fakeMemory is the memory of a process whose addresses start at 0.
+type fakeMemory []byte
+
+func (m fakeMemory) put(addr libpf.Address, values ...uint64) {
+	for _, v := range values {
+		binary.LittleEndian.PutUint64(m[addr:], v)
+		addr += 8
+	}
+}
+
+func atom(index uint64) uint64 {
+	return index<<atomTagShift | atomTag
+}
+
+func TestSymbolizeAddress(t *testing.T) {
+	d := newBEAMData(0xe0200)
+	d.activeCodeIndex = 0x100
+	d.ranges = 0x200
+	d.atomTable = 0x300
+
+	mem := make(fakeMemory, 0x4000)
+	mem.put(d.activeCodeIndex, 1)
+	// The range table of code index 1 with two modules.
+	mem.put(d.ranges+32, 0x400, 2)
+	mem.put(0x400, 0x1000, 0x1100, 0x2000, 0x2200)
+	// The code header of the second module with two functions.
+	mem.put(0x2000, 2)
+	mem.put(0x2000+88, 0x2080, 0x2100, 0x2200)
+	mem.put(0x2100+16, atom(1), atom(2), 3)
+	// The atom table.
+	mem.put(d.atomTable+120, 0x3000)
+	mem.put(0x3000, 0x3100)
+	mem.put(0x3100+8, 0x3200, 0x3300)
+	mem.put(0x3200+24, 5, 0x3400)
+	mem.put(0x3300+24, 5, 0x3500)
+	copy(mem[0x3400:], "lists")
+	copy(mem[0x3500:], "foldl")
+
+	i := &beamInstance{
+		d:  d,
+		rm: remotememory.RemoteMemory{ReaderAt: bytes.NewReader(mem)},
+	}
+
+	hdr, err := i.lookupModule(0x2150)
+	require.NoError(t, err)
+	assert.Equal(t, libpf.Address(0x2000), hdr)
+	ci, err := i.lookupFunction(hdr, 0x2150)
+	require.NoError(t, err)
+	assert.Equal(t, libpf.Address(0x2100), ci)
+	name, err := i.readMFA(ci)
+	require.NoError(t, err)
+	assert.Equal(t, "lists:foldl/3", name)
+
+	_, err = i.lookupModule(0x1800)
+	assert.Error(t, err)
+	_, err = i.lookupFunction(hdr, 0x2040)
+	assert.Error(t, err)
+	_, err = i.readAtom(3)
+	assert.Error(t, err)
+}
+
+// frameMetadata holds the arguments of a SymbolReporter.FrameMetadata call.
+type frameMetadata struct {
+	fileID         libpf.FileID
+	addressOrLine  libpf.AddressOrLineno
+	functionOffset uint32
+	functionName   string
+}
+
+// frameMetadataRecorder is a reporter.SymbolReporter that records reported frames.
+type frameMetadataRecorder struct {
+	frames []frameMetadata
+}
+
+var _ reporter.SymbolReporter = (*frameMetadataRecorder)(nil)
+
+func (r *frameMetadataRecorder) ReportFallbackSymbol(libpf.FrameID, string) {}
+
+func (r *frameMetadataRecorder) ExecutableMetadata(context.Context, libpf.FileID, string,
+	string, uint64, uint64, pfelf.AddressMapper) {
+}
+
+func (r *frameMetadataRecorder) FrameMetadata(fileID libpf.FileID,
+	addressOrLine libpf.AddressOrLineno, _ libpf.SourceLineno, functionOffset uint32,
+	functionName, _ string) {
+	r.frames = append(r.frames, frameMetadata{
+		fileID:         fileID,
+		addressOrLine:  addressOrLine,
+		functionOffset: functionOffset,
+		functionName:   functionName,
+	})
+}
+
+// TestSymbolize symbolizes addresses in memory laid out like the structures of the
+// emulator of ERTS 13 and 14, with the range table and function table searched for them.
+func TestSymbolize(t *testing.T) {
+	const bias = 0x800
+	for name, version := range map[string]uint32{"13.2.2": 0xd0202, "14.2.1": 0xe0201} {
+		version := version
+		t.Run(name, func(t *testing.T) {
+			d := newBEAMData(version)
+			d.activeCodeIndex = 0x100
+			d.ranges = 0x200
+			d.atomTable = 0x300
+
+			mem := make(fakeMemory, 0x8000)
+			mem.put(d.activeCodeIndex+bias, 2)
+			// The range table of code index 2, struct ranges is 32 bytes, with three
+			// modules whose Range is ErtsCodePtr start and erts_atomic_t end.
+			mem.put(d.ranges+bias+2*32, 0x1000, 3)
+			mem.put(0x1000, 0x2000, 0x2400, 0x3000, 0x3400, 0x4000, 0x4800)
+			// The BeamCodeHeader of the second module with num_functions, the function
+			// table at offset 88 and its entry marking the end of the last function.
+			mem.put(0x3000, 4)
+			mem.put(0x3000+88, 0x3080, 0x3100, 0x3200, 0x3300, 0x3400)
+			// The ErtsCodeMFA of the functions at offset 16 of their ErtsCodeInfo.
+			mem.put(0x3080+16, atom(1), atom(2), 3)
+			mem.put(0x3100+16, atom(1), atom(3), 2)
+			mem.put(0x3200+16, atom(1), atom(4), 1)
+			mem.put(0x3300+16, atom(1), atom(5), 0)
+			// The atom table with the IndexTable seg_table at offset 120, and the atoms
+			// with len at offset 24 and name at offset 32.
+			mem.put(d.atomTable+bias+120, 0x5000)
+			mem.put(0x5000, 0x5100)
+			mem.put(0x5100+8, 0x5200, 0x5240, 0x5280, 0x52c0, 0x5300)
+			for n, atomName := range []string{"lists", "foldl", "map", "reverse", "seq"} {
+				atom := libpf.Address(0x5200 + n*0x40)
+				addr := libpf.Address(0x6000 + n*0x100)
+				mem.put(atom+24, uint64(len(atomName)), uint64(addr))
+				copy(mem[addr:], atomName)
+			}
+
+			instance, err := d.Attach(nil, 0, bias,
+				remotememory.RemoteMemory{ReaderAt: bytes.NewReader(mem)})
+			require.NoError(t, err)
+			i := instance.(*beamInstance)
+
+			var symbols frameMetadataRecorder
+			symbolize := func(addr libpf.Address) (*libpf.Trace, error) {
+				trace := &libpf.Trace{}
+				err := i.Symbolize(&symbols, &host.Frame{
+					Type:   libpf.BEAMFrame,
+					Lineno: libpf.AddressOrLineno(addr),
+				}, trace)
+				return trace, err
+			}
+
+			for _, test := range []struct {
+				addr         libpf.Address
+				functionName string
+				offset       libpf.AddressOrLineno
+			}{
+				{0x3088, "lists:foldl/3", 0x8},
+				{0x3100, "lists:map/2", 0},
+				{0x3250, "lists:reverse/1", 0x50},
+				{0x33f8, "lists:seq/0", 0xf8},
+			} {
+				trace, err := symbolize(test.addr)
+				require.NoError(t, err, test.functionName)
+				require.Len(t, trace.Files, 1)
+				assert.Equal(t, []libpf.FrameType{libpf.BEAMFrame}, trace.FrameTypes)
+				assert.Equal(t, []libpf.AddressOrLineno{test.offset}, trace.Linenos)
+
+				frame := symbols.frames[len(symbols.frames)-1]
+				assert.Equal(t, trace.Files[0], frame.fileID)
+				assert.Equal(t, test.offset, frame.addressOrLine)
+				assert.Equal(t, uint32(test.offset), frame.functionOffset)
+				assert.Equal(t, test.functionName, frame.functionName)
+			}
+			assert.Len(t, symbols.frames, 4)
+
+			// Symbolized addresses are cached and reported once.
+			trace, err := symbolize(0x3088)
+			require.NoError(t, err)
+			assert.Equal(t, []libpf.AddressOrLineno{0x8}, trace.Linenos)
+			assert.Len(t, symbols.frames, 4)
+
+			// Addresses before, between and after the modules, and in the code header.
+			for _, addr := range []libpf.Address{0x1800, 0x2800, 0x4800, 0x3040} {
+				_, err = symbolize(addr)
+				assert.Error(t, err, "0x%x", addr)
+			}
+
+			err = i.Symbolize(&symbols, &host.Frame{Type: libpf.PythonFrame}, &libpf.Trace{})
+			assert.ErrorIs(t, err, interpreter.ErrMismatchInterpreterType)
+		})
+	}
+}
@@ -116,6 +116,7 @@ import (
 	"runtime"
 	"strings"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/elastic/otel-profiling-agent/host"
@@ -145,8 +146,9 @@ var (
 	// The following regex is intended to match the HotSpot libjvm.so
 	libjvmRegex = regexp.MustCompile(`.*/libjvm\.so`)
 
-	_ interpreter.Data     = &hotspotData{}
-	_ interpreter.Instance = &hotspotInstance{}
+	_ interpreter.Data           = &hotspotData{}
+	_ interpreter.VersionLabeler = &hotspotData{}
+	_ interpreter.Instance       = &hotspotInstance{}
 )
 
 var (
@@ -593,6 +595,14 @@ type hotspotInstance struct {
 
 	// stubs stores all known stub routine regions.
 	stubs map[libpf.Address]StubRoutine
+
+	// jitDumpPath is the path of the jitdump file mapped by the process, if any, and
+	// jitDump holds the JIT compiled code it describes. It symbolizes JIT frames whose
+	// nmethod can not be introspected, e.g. because it was freed since the sample.
+	jitDumpPath string
+	jitDump     jitDump
+	// jitDumpUpdated is the time the jitdump file was last read.
+	jitDumpUpdated time.Time
 }
 
 // heapInfo contains info about all HotSpot heaps.
@@ -1384,7 +1394,9 @@ func (d *hotspotInstance) updateStubMappings(vmd *hotspotVMData,
 }
 
 func (d *hotspotInstance) SynchronizeMappings(ebpf interpreter.EbpfHandler,
-	_ reporter.SymbolReporter, pr process.Process, _ []process.Mapping) error {
+	_ reporter.SymbolReporter, pr process.Process, mappings []process.Mapping) error {
+	d.findJITDump(pr, mappings)
+
 	vmd, err := d.d.GetOrInit(d.initVMData)
 	if err != nil {
 		return err
@@ -1437,19 +1449,26 @@ func (d *hotspotInstance) Symbolize(symbolReporter reporter.SymbolReporter,
 		// to be seen in the trace.
 		stubID, err1 := d.getStubNameID(symbolReporter, ripOrBci, ptr, ptrCheck)
 		if err1 != nil {
-			return err
+			return err1
 		}
 		trace.AppendFrame(libpf.HotSpotFrame, hotspotStubsFileID, stubID)
 	case C.FRAME_HOTSPOT_INTERPRETER:
 		method, err1 := d.getMethod(ptr, ptrCheck)
 		if err1 != nil {
-			return err
+			return err1
 		}
 		err = method.symbolize(symbolReporter, ripOrBci, d, trace)
 	case C.FRAME_HOTSPOT_NATIVE:
 		jitinfo, err1 := d.getJITInfo(ptr, ptrCheck)
 		if err1 != nil {
-			return err1
+			// Fall back to the jitdump file, if the process writes one.
+			vms := &d.d.Get().vmStructs
+			codeStart := d.rm.Ptr(ptr + libpf.Address(vms.CodeBlob.CodeBegin))
+			if d.symbolizeJITDump(symbolReporter, codeStart+libpf.Address(ripOrBci),
+				trace) != nil {
+				return err1
+			}
+			break
 		}
 		err = jitinfo.symbolize(symbolReporter, ripOrBci, d, trace)
 	default:
@@ -1480,6 +1499,17 @@ func (d *hotspotData) String() string {
 	return "<unintrospected JVM>"
 }
 
+func (d *hotspotData) VersionLabel() (name, value string) {
+	vmd := d.Get()
+	if vmd == nil {
+		// The version is only known once the JVM has been introspected.
+		return "", ""
+	}
+	return "java.version", fmt.Sprintf("%d.%d.%d+%d",
+		(vmd.version>>24)&0xff, (vmd.version>>16)&0xff,
+		(vmd.version>>8)&0xff, vmd.version&0xff)
+}
+
 // Attach loads to the ebpf program the needed pointers and sizes to unwind given hotspot process.
 // As the hotspot unwinder depends on the native unwinder, a part of the cleanup is done by the
 // process manager and not the corresponding Detach() function of hotspot objects.
@@ -1786,7 +1816,8 @@ func (d *hotspotInstance) initVMData() (hotspotVMData, error) {
 					return nil
 				}
 			}
-			return fmt.Errorf("JVM symbol '%v' not found", name)
+			return fmt.Errorf("%w: JVM symbol '%v' not found",
+				interpreter.ErrOffsetsUnavailable, name)
 		})
 	if err != nil {
 		vmd.err = err
@@ -1881,13 +1912,20 @@ func locateJvmciVMStructs(ef *pfelf.File) (libpf.Address, error) {
 	return libpf.Address(dataSec.Addr + uint64(offs) - 8), nil
 }
 
-// Loader is the main function for ProcessManager to recognize and hook the HotSpot
+// Loader is the interpreter.Loader for ProcessManager to recognize and hook the HotSpot
 // libjvm for enabling JVM unwinding and symbolization.
-func Loader(_ interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
-	if !libjvmRegex.MatchString(info.FileName()) {
-		return nil, nil
-	}
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
+
+// Detect implements the interpreter.Loader interface.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return libjvmRegex.MatchString(info.FileName())
+}
 
+// New implements the interpreter.Loader interface.
+func (loader) New(_ interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
 	log.Debugf("HotSpot inspecting %v", info.FileName())
 
 	ef, err := info.GetELF()
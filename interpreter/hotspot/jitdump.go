@@ -0,0 +1,260 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package hotspot
+
+// The jitdump file format is written by JVMTI agents for 'perf inject', and describes
+// the code the JIT compiler placed in the code cache. To make perf notice the file, the
+// agents map it to the process as executable, which is how it is found here.
+//
+// The format is documented at:
+//   https://github.com/torvalds/linux/blob/master/tools/perf/Documentation/jitdump-specification.txt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/process"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+const (
+	// jitDumpMagic is the magic of the jitdump header in the byte order of the writer.
+	jitDumpMagic = 0x4A695444
+	// jitDumpHeaderSize and jitDumpRecordHeaderSize are the sizes of the file header
+	// and the header of each record.
+	jitDumpHeaderSize       = 40
+	jitDumpRecordHeaderSize = 16
+	// jitCodeLoad is the record type describing newly compiled code.
+	jitCodeLoad = 0
+	// jitCodeLoadFixedSize is the size of the fields of a JIT_CODE_LOAD record which
+	// precede the function name: pid, tid, vma, code_addr, code_size and code_index.
+	jitCodeLoadFixedSize = 40
+	// jitDumpMaxRecordSize bounds the size of a record, to detect corrupt files.
+	jitDumpMaxRecordSize = 16 * 1024 * 1024
+	// jitDumpRefreshInterval is the minimum time between reads of the jitdump file
+	// to look for code not known yet.
+	jitDumpRefreshInterval = time.Second
+)
+
+// jitDumpRegex matches the names of jitdump files, which are named jit-<pid>.dump.
+var jitDumpRegex = regexp.MustCompile(`/jit-\d+\.dump$`)
+
+// jitDumpMethod is the code of a method, as described by a JIT_CODE_LOAD record.
+type jitDumpMethod struct {
+	start, end libpf.Address
+	name       string
+	// objectID identifies the method in traces, and is derived from its name.
+	objectID libpf.FileID
+	// offsetSeen holds the code offsets whose metadata was reported already.
+	offsetSeen libpf.Set[uint32]
+}
+
+// jitDump holds the code locations read from a jitdump file. The file is written as
+// the JIT compiler works, so it is read incrementally.
+type jitDump struct {
+	// byteOrder is the byte order of the file, or nil if the header was not read yet.
+	byteOrder binary.ByteOrder
+	// offset is the file offset of the first record not read yet.
+	offset int64
+	// methods holds the non-overlapping code ranges of the methods, sorted by address.
+	methods []jitDumpMethod
+}
+
+// update reads the records added to the jitdump file r of the given size since the
+// previous update. Records which were not written completely yet are read later.
+func (j *jitDump) update(r io.ReaderAt, size int64) error {
+	if size < j.offset {
+		// The file was truncated, e.g. because the JVM was restarted with the same PID.
+		*j = jitDump{}
+	}
+	if j.byteOrder == nil {
+		if size < jitDumpHeaderSize {
+			return nil
+		}
+		var hdr [jitDumpHeaderSize]byte
+		if _, err := r.ReadAt(hdr[:], 0); err != nil {
+			return fmt.Errorf("failed to read jitdump header: %v", err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(hdr[0:]) == jitDumpMagic:
+			j.byteOrder = binary.LittleEndian
+		case binary.BigEndian.Uint32(hdr[0:]) == jitDumpMagic:
+			j.byteOrder = binary.BigEndian
+		default:
+			return errors.New("invalid jitdump magic")
+		}
+		// The header size is recorded in the file to allow for extensions.
+		hdrSize := int64(j.byteOrder.Uint32(hdr[8:]))
+		if hdrSize < jitDumpHeaderSize {
+			return fmt.Errorf("invalid jitdump header size %d", hdrSize)
+		}
+		j.offset = hdrSize
+	}
+
+	var hdr [jitDumpRecordHeaderSize]byte
+	for j.offset+jitDumpRecordHeaderSize <= size {
+		if _, err := r.ReadAt(hdr[:], j.offset); err != nil {
+			return fmt.Errorf("failed to read jitdump record at %d: %v", j.offset, err)
+		}
+		id := j.byteOrder.Uint32(hdr[0:])
+		recordSize := int64(j.byteOrder.Uint32(hdr[4:]))
+		if recordSize < jitDumpRecordHeaderSize || recordSize > jitDumpMaxRecordSize {
+			return fmt.Errorf("invalid jitdump record size %d at %d", recordSize, j.offset)
+		}
+		if j.offset+recordSize > size {
+			// The record is still being written.
+			break
+		}
+		if id == jitCodeLoad {
+			if err := j.readCodeLoad(r, j.offset+jitDumpRecordHeaderSize,
+				recordSize-jitDumpRecordHeaderSize); err != nil {
+				return err
+			}
+		}
+		j.offset += recordSize
+	}
+	return nil
+}
+
+// readCodeLoad reads the JIT_CODE_LOAD record body of the given size at offset.
+func (j *jitDump) readCodeLoad(r io.ReaderAt, offset, size int64) error {
+	if size < jitCodeLoadFixedSize {
+		return fmt.Errorf("invalid JIT_CODE_LOAD record size %d at %d", size, offset)
+	}
+	// Only the fixed fields and the function name are read, not the code that follows.
+	body := make([]byte, size)
+	if _, err := r.ReadAt(body, offset); err != nil {
+		return fmt.Errorf("failed to read JIT_CODE_LOAD record at %d: %v", offset, err)
+	}
+	codeAddr := libpf.Address(j.byteOrder.Uint64(body[16:]))
+	codeSize := libpf.Address(j.byteOrder.Uint64(body[24:]))
+	name := body[jitCodeLoadFixedSize:]
+	end := bytes.IndexByte(name, 0)
+	if end < 0 {
+		return fmt.Errorf("unterminated JIT_CODE_LOAD name at %d", offset)
+	}
+	if codeSize == 0 {
+		return nil
+	}
+	h := fnv.New128a()
+	_, _ = h.Write(name[:end])
+	objectID, err := libpf.FileIDFromBytes(h.Sum(nil))
+	if err != nil {
+		return fmt.Errorf("failed to create a code object ID: %v", err)
+	}
+	j.add(jitDumpMethod{
+		start:      codeAddr,
+		end:        codeAddr + codeSize,
+		name:       string(name[:end]),
+		objectID:   objectID,
+		offsetSeen: make(libpf.Set[uint32]),
+	})
+	return nil
+}
+
+// add inserts method, replacing the methods whose code it overwrote.
+func (j *jitDump) add(method jitDumpMethod) {
+	first := sort.Search(len(j.methods), func(i int) bool {
+		return j.methods[i].end > method.start
+	})
+	last := first
+	for last < len(j.methods) && j.methods[last].start < method.end {
+		last++
+	}
+	j.methods = append(j.methods[:first],
+		append([]jitDumpMethod{method}, j.methods[last:]...)...)
+}
+
+// lookup returns the method whose code contains addr.
+func (j *jitDump) lookup(addr libpf.Address) (*jitDumpMethod, bool) {
+	i := sort.Search(len(j.methods), func(i int) bool {
+		return j.methods[i].end > addr
+	})
+	if i == len(j.methods) || j.methods[i].start > addr {
+		return nil, false
+	}
+	return &j.methods[i], true
+}
+
+// findJITDump records the path of the jitdump file the process mapped, if any.
+func (d *hotspotInstance) findJITDump(pr process.Process, mappings []process.Mapping) {
+	for i := range mappings {
+		m := &mappings[i]
+		if !jitDumpRegex.MatchString(m.Path) {
+			continue
+		}
+		if path := pr.GetMappingFile(m); path != "" && path != d.jitDumpPath {
+			log.Debugf("Found jitdump %s of PID %d", m.Path, pr.PID())
+			d.jitDumpPath = path
+			d.jitDump = jitDump{}
+			d.jitDumpUpdated = time.Time{}
+		}
+		return
+	}
+}
+
+// updateJITDump reads the records added to the jitdump file of the process, if any.
+// Updates are rate limited, as they are triggered by lookups of unknown code.
+func (d *hotspotInstance) updateJITDump() {
+	if d.jitDumpPath == "" || time.Since(d.jitDumpUpdated) < jitDumpRefreshInterval {
+		return
+	}
+	d.jitDumpUpdated = time.Now()
+
+	f, err := os.Open(d.jitDumpPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// The file was unmapped, or the process exited.
+			d.jitDumpPath = ""
+			return
+		}
+		log.Debugf("Failed to open jitdump %s: %v", d.jitDumpPath, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Debugf("Failed to stat jitdump %s: %v", d.jitDumpPath, err)
+		return
+	}
+	if err = d.jitDump.update(f, info.Size()); err != nil {
+		log.Debugf("Failed to read jitdump %s: %v", d.jitDumpPath, err)
+	}
+}
+
+// symbolizeJITDump symbolizes the JIT compiled code at addr with the method names of the
+// jitdump file, which is used when the nmethod can not be introspected.
+func (d *hotspotInstance) symbolizeJITDump(symbolizer reporter.SymbolReporter,
+	addr libpf.Address, trace *libpf.Trace) error {
+	method, ok := d.jitDump.lookup(addr)
+	if !ok {
+		d.updateJITDump()
+		if method, ok = d.jitDump.lookup(addr); !ok {
+			return fmt.Errorf("no jitdump entry for 0x%x", addr)
+		}
+	}
+	offset := uint32(addr - method.start)
+	trace.AppendFrame(libpf.HotSpotFrame, method.objectID, libpf.AddressOrLineno(offset))
+	if _, ok := method.offsetSeen[offset]; ok {
+		return nil
+	}
+	symbolizer.FrameMetadata(method.objectID, libpf.AddressOrLineno(offset), 0, offset,
+		method.name, "")
+	method.offsetSeen[offset] = libpf.Void{}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package hotspot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// jitDumpWriter writes jitdump files in the given byte order.
+type jitDumpWriter struct {
+	bytes.Buffer
+	order binary.ByteOrder
+}
+
+func newJITDumpWriter(order binary.ByteOrder) *jitDumpWriter {
+	w := &jitDumpWriter{order: order}
+	// magic, version, total_size, elf_mach, pad1, pid, timestamp, flags
+	_ = binary.Write(w, order, []uint32{jitDumpMagic, 1, jitDumpHeaderSize, 62, 0, 1234})
+	_ = binary.Write(w, order, []uint64{0, 0})
+	return w
+}
+
+func (w *jitDumpWriter) record(id uint32, body []byte) {
+	_ = binary.Write(w, w.order, []uint32{id, uint32(jitDumpRecordHeaderSize + len(body))})
+	_ = binary.Write(w, w.order, uint64(0))
+	w.Write(body)
+}
+
+func (w *jitDumpWriter) codeLoad(addr, size uint64, name string) {
+	var body bytes.Buffer
+	// pid, tid, vma, code_addr, code_size, code_index
+	_ = binary.Write(&body, w.order, []uint32{1234, 1235})
+	_ = binary.Write(&body, w.order, []uint64{addr, addr, size, 0})
+	body.WriteString(name)
+	body.WriteByte(0)
+	body.Write(make([]byte, size))
+	w.record(jitCodeLoad, body.Bytes())
+}
+
+func lookupName(j *jitDump, addr libpf.Address) string {
+	if method, ok := j.lookup(addr); ok {
+		return method.name
+	}
+	return ""
+}
+
+func TestJITDump(t *testing.T) {
+	for name, order := range map[string]binary.ByteOrder{
+		"little endian": binary.LittleEndian,
+		"big endian":    binary.BigEndian,
+	} {
+		order := order
+		t.Run(name, func(t *testing.T) {
+			w := newJITDumpWriter(order)
+			w.codeLoad(0x1000, 0x100, "Lcom/example/Foo;.bar(I)V")
+			// A JIT_CODE_MOVE record, which is skipped.
+			w.record(1, make([]byte, 48))
+			w.codeLoad(0x1100, 0x80, "Lcom/example/Foo;.baz()J")
+			written := int64(w.Len())
+			w.codeLoad(0x2000, 0x40, "Lcom/example/Qux;.run()V")
+
+			j := &jitDump{}
+			// An empty file, e.g. one that was just created.
+			require.NoError(t, j.update(bytes.NewReader(nil), 0))
+			assert.Empty(t, lookupName(j, 0x1000))
+
+			// The last record is still being written.
+			r := bytes.NewReader(w.Bytes())
+			require.NoError(t, j.update(r, written+20))
+			assert.Equal(t, "Lcom/example/Foo;.bar(I)V", lookupName(j, 0x1000))
+			assert.Equal(t, "Lcom/example/Foo;.bar(I)V", lookupName(j, 0x10ff))
+			assert.Equal(t, "Lcom/example/Foo;.baz()J", lookupName(j, 0x1100))
+			assert.Empty(t, lookupName(j, 0x1180))
+			assert.Empty(t, lookupName(j, 0x2000))
+
+			require.NoError(t, j.update(r, int64(w.Len())))
+			assert.Equal(t, "Lcom/example/Qux;.run()V", lookupName(j, 0x2010))
+
+			// Code replacing the code of freed methods.
+			w.codeLoad(0x10c0, 0x80, "Lcom/example/Foo;.recompiled()V")
+			require.NoError(t, j.update(bytes.NewReader(w.Bytes()), int64(w.Len())))
+			assert.Empty(t, lookupName(j, 0x1000))
+			assert.Equal(t, "Lcom/example/Foo;.recompiled()V", lookupName(j, 0x10c0))
+			assert.Empty(t, lookupName(j, 0x1140))
+			assert.Equal(t, "Lcom/example/Qux;.run()V", lookupName(j, 0x2000))
+		})
+	}
+}
+
+func TestJITDumpTruncated(t *testing.T) {
+	w := newJITDumpWriter(binary.LittleEndian)
+	w.codeLoad(0x1000, 0x100, "Lcom/example/Foo;.bar(I)V")
+	w.codeLoad(0x2000, 0x100, "Lcom/example/Foo;.baz()J")
+	j := &jitDump{}
+	require.NoError(t, j.update(bytes.NewReader(w.Bytes()), int64(w.Len())))
+
+	// The file is recreated by a new process with the same PID.
+	w = newJITDumpWriter(binary.LittleEndian)
+	w.codeLoad(0x3000, 0x100, "Lcom/example/Qux;.run()V")
+	require.NoError(t, j.update(bytes.NewReader(w.Bytes()), int64(w.Len())))
+	assert.Empty(t, lookupName(j, 0x1000))
+	assert.Equal(t, "Lcom/example/Qux;.run()V", lookupName(j, 0x3000))
+}
+
+func TestJITDumpInvalid(t *testing.T) {
+	data := make([]byte, jitDumpHeaderSize)
+	assert.Error(t, (&jitDump{}).update(bytes.NewReader(data), int64(len(data))))
+}
+
+func TestJITDumpRegex(t *testing.T) {
+	assert.True(t, jitDumpRegex.MatchString("/tmp/jit-1234.dump"))
+	assert.False(t, jitDumpRegex.MatchString("/tmp/perf-1234.map"))
+	assert.False(t, jitDumpRegex.MatchString("/tmp/jit-1234.dump.old"))
+}
@@ -7,6 +7,7 @@
 package interpreter
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/elastic/otel-profiling-agent/host"
@@ -19,12 +20,19 @@ import (
 type LoaderInfo struct {
 	// fileID is the FileID of the ELF file.
 	fileID host.FileID
-	// elfRef provides a cached access to the ELF file.
+	// elfRef provides a cached access to the ELF file. It is nil if the LoaderInfo was
+	// created from in-memory symbols.
 	elfRef *pfelf.Reference
 	// gaps represents holes in the stack deltas of the executable.
 	gaps []libpf.Range
+	// fileName and symbols replace the ELF file if elfRef is nil.
+	fileName string
+	symbols  *libpf.SymbolMap
 }
 
+// errNoELF is returned by GetELF for a LoaderInfo that was created from in-memory symbols.
+var errNoELF = errors.New("no ELF file available")
+
 // NewLoaderInfo returns a populated LoaderInfo struct.
 func NewLoaderInfo(fileID host.FileID, elfRef *pfelf.Reference, gaps []libpf.Range) *LoaderInfo {
 	return &LoaderInfo{
@@ -34,18 +42,64 @@ func NewLoaderInfo(fileID host.FileID, elfRef *pfelf.Reference, gaps []libpf.Ran
 	}
 }
 
+// NewLoaderInfoFromSymbols returns a LoaderInfo for an executable named fileName that is
+// backed by the given in-memory symbols instead of an ELF file on disk. This allows testing
+// code that consumes a LoaderInfo without fixture files. GetELF fails for such a LoaderInfo.
+func NewLoaderInfoFromSymbols(fileID host.FileID, fileName string,
+	symbols map[libpf.SymbolName]libpf.Symbol, gaps []libpf.Range) *LoaderInfo {
+	syms := make([]libpf.Symbol, 0, len(symbols))
+	for name, sym := range symbols {
+		sym.Name = name
+		syms = append(syms, sym)
+	}
+
+	return &LoaderInfo{
+		fileID:   fileID,
+		gaps:     gaps,
+		fileName: fileName,
+		symbols:  libpf.NewSymbolMap(syms...),
+	}
+}
+
 // GetELF returns and caches a *pfelf.File for this LoaderInfo.
 func (i *LoaderInfo) GetELF() (*pfelf.File, error) {
+	if i.elfRef == nil {
+		return nil, errNoELF
+	}
 	return i.elfRef.GetELF()
 }
 
-// GetSymbolAsRanges returns the normalized virtual address ranges for the named symbol
-func (i *LoaderInfo) GetSymbolAsRanges(symbol libpf.SymbolName) ([]libpf.Range, error) {
+// lookupSymbol looks up the named symbol in the in-memory symbols or the ELF file.
+func (i *LoaderInfo) lookupSymbol(symbol libpf.SymbolName) (*libpf.Symbol, error) {
+	if i.elfRef == nil {
+		return i.symbols.LookupSymbol(symbol)
+	}
 	ef, err := i.GetELF()
 	if err != nil {
 		return nil, err
 	}
-	sym, err := ef.LookupSymbol(symbol)
+	return ef.LookupSymbol(symbol)
+}
+
+// HasSymbol checks whether the executable defines the named symbol. Executables without
+// dynamic symbols are searched in their symbol table, so that interpreters statically linked
+// into executables with arbitrary names can be detected by their symbols. Go executables
+// without dynamic symbols are skipped, as they have large symbol tables but do not embed
+// interpreters.
+func (i *LoaderInfo) HasSymbol(symbol libpf.SymbolName) bool {
+	if i.elfRef != nil {
+		ef, err := i.GetELF()
+		if err != nil || (!ef.HasDynamicSymbols() && ef.IsGolang()) {
+			return false
+		}
+	}
+	sym, err := i.lookupSymbol(symbol)
+	return err == nil && sym.Address != 0
+}
+
+// GetSymbolAsRanges returns the normalized virtual address ranges for the named symbol
+func (i *LoaderInfo) GetSymbolAsRanges(symbol libpf.SymbolName) ([]libpf.Range, error) {
+	sym, err := i.lookupSymbol(symbol)
 	if err != nil {
 		return nil, fmt.Errorf("symbol '%v' not found: %w", symbol, err)
 	}
@@ -63,6 +117,9 @@ func (i *LoaderInfo) FileID() host.FileID {
 
 // FileName returns the fileName  element of the LoaderInfo struct.
 func (i *LoaderInfo) FileName() string {
+	if i.elfRef == nil {
+		return i.fileName
+	}
 	return i.elfRef.FileName()
 }
 
@@ -0,0 +1,43 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package interpreter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestNewLoaderInfoFromSymbols(t *testing.T) {
+	gaps := []libpf.Range{{Start: 0x2000, End: 0x3000}}
+	info := NewLoaderInfoFromSymbols(host.FileID(42), "libpython3.11.so.1.0",
+		map[libpf.SymbolName]libpf.Symbol{
+			"_PyEval_EvalFrameDefault": {Address: 0x1000, Size: 0x200},
+			"_PyRuntime":               {Address: 0x5000, Size: 0x100},
+		}, gaps)
+
+	assert.Equal(t, host.FileID(42), info.FileID())
+	assert.Equal(t, "libpython3.11.so.1.0", info.FileName())
+	assert.Equal(t, gaps, info.Gaps())
+
+	ranges, err := info.GetSymbolAsRanges("_PyEval_EvalFrameDefault")
+	require.NoError(t, err)
+	assert.Equal(t, []libpf.Range{{Start: 0x1000, End: 0x1200}}, ranges)
+
+	_, err = info.GetSymbolAsRanges("PyEval_EvalFrameEx")
+	assert.Error(t, err)
+
+	assert.True(t, info.HasSymbol("_PyRuntime"))
+	assert.False(t, info.HasSymbol("Py_Version"))
+
+	_, err = info.GetELF()
+	assert.ErrorIs(t, err, errNoELF)
+}
@@ -233,8 +233,9 @@ var (
 	unknownSource = &v8Source{fileName: interpreter.UnknownSourceFile}
 
 	// compiler check to make sure the needed interfaces are satisfied
-	_ interpreter.Data     = &v8Data{}
-	_ interpreter.Instance = &v8Instance{}
+	_ interpreter.Data           = &v8Data{}
+	_ interpreter.VersionLabeler = &v8Data{}
+	_ interpreter.Instance       = &v8Instance{}
 )
 
 // nolint:lll
@@ -1741,6 +1742,11 @@ func (i *v8Instance) Symbolize(symbolReporter reporter.SymbolReporter,
 	return nil
 }
 
+func (d *v8Data) VersionLabel() (name, value string) {
+	ver := d.version
+	return "v8.version", fmt.Sprintf("%d.%d.%d", (ver>>24)&0xff, (ver>>16)&0xff, ver&0xffff)
+}
+
 func (d *v8Data) String() string {
 	ver := d.version
 	return fmt.Sprintf("V8 %d.%d.%d", (ver>>24)&0xff, (ver>>16)&0xff, ver&0xffff)
@@ -2030,11 +2036,19 @@ func (d *v8Data) readIntrospectionData(ef *pfelf.File, syms libpf.SymbolFinder)
 	return nil
 }
 
-func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
-	if !v8Regex.MatchString(info.FileName()) {
-		return nil, nil
-	}
+// Loader is the interpreter.Loader for V8.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
+
+// Detect implements the interpreter.Loader interface.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return v8Regex.MatchString(info.FileName())
+}
 
+// New implements the interpreter.Loader interface.
+func (loader) New(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
 	ef, err := info.GetELF()
 	if err != nil {
 		return nil, err
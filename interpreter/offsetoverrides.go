@@ -0,0 +1,244 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package interpreter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// OffsetOverrides holds interpreter offsets that augment or override the offsets the loaders
+// have built in. This allows to fix the support of an interpreter version whose offsets
+// differ from the built-in ones with a configuration file, instead of a new build of the
+// agent. The offsets are keyed by the runtime, e.g. "ruby", the version, e.g. "3.2.3" or "3.2"
+// for all 3.2 releases, and the name of the offset in the built-in table of the loader, whose
+// nested structs are separated by dots, e.g. "control_frame_struct.pc".
+type OffsetOverrides map[string]map[string]map[string]uint64
+
+// offsetOverrideCheckFrames is the number of frames after which the frames symbolized with
+// overridden offsets are judged.
+const offsetOverrideCheckFrames = 1000
+
+// ErrInvalidFrame indicates that a frame symbolized with overridden offsets is implausible.
+var ErrInvalidFrame = errors.New("implausible frame with overridden offsets")
+
+var (
+	offsetOverridesMu sync.RWMutex
+	// offsetOverrides are the offset overrides set with SetOffsetOverrides.
+	offsetOverrides OffsetOverrides
+	// offsetTables maps the runtimes whose loaders consult the offset overrides to the types
+	// of their built-in offset tables, as registered with RegisterOffsetTable.
+	offsetTables = map[string]reflect.Type{}
+)
+
+// RegisterOffsetTable registers the type of vmStructs, the built-in offset table of the
+// loader of runtime, so that LoadOffsetOverrides checks the overrides of runtime against
+// it. It is meant to be called from the init function of the loader.
+func RegisterOffsetTable(runtime string, vmStructs any) {
+	offsetOverridesMu.Lock()
+	defer offsetOverridesMu.Unlock()
+	offsetTables[runtime] = reflect.TypeOf(vmStructs)
+}
+
+// LoadOffsetOverrides reads OffsetOverrides in JSON format from fileName. Overrides of
+// runtimes without registered offset table, of offsets that do not exist in the table and
+// that do not fit into their field are an error.
+func LoadOffsetOverrides(fileName string) (OffsetOverrides, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interpreter offsets: %v", err)
+	}
+	var overrides OffsetOverrides
+	if err = json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse interpreter offsets %s: %v", fileName, err)
+	}
+	offsetOverridesMu.RLock()
+	defer offsetOverridesMu.RUnlock()
+	for runtime, versions := range overrides {
+		tableType, ok := offsetTables[runtime]
+		if !ok {
+			runtimes := make([]string, 0, len(offsetTables))
+			for name := range offsetTables {
+				runtimes = append(runtimes, name)
+			}
+			slices.Sort(runtimes)
+			return nil, fmt.Errorf("interpreter offsets of unsupported runtime '%s', "+
+				"supported are %s", runtime, strings.Join(runtimes, ", "))
+		}
+		table := reflect.New(tableType).Elem()
+		for version, offsets := range versions {
+			for name, value := range offsets {
+				if err := setOffset(table, name, value); err != nil {
+					return nil, fmt.Errorf("invalid %s %s offset override %s in %s: %v",
+						runtime, version, name, fileName, err)
+				}
+			}
+		}
+	}
+	return overrides, nil
+}
+
+// SetOffsetOverrides sets the offset overrides the loaders consult when loading an
+// interpreter. It is meant to be called on startup, before any interpreter is loaded.
+func SetOffsetOverrides(overrides OffsetOverrides) {
+	offsetOverridesMu.Lock()
+	defer offsetOverridesMu.Unlock()
+	offsetOverrides = overrides
+}
+
+// OverriddenOffsets tracks the frames of an interpreter whose offsets were overridden, to
+// tell whether the overrides produce plausible frames. If they do not, the built-in offsets
+// are restored.
+type OverriddenOffsets struct {
+	runtime string
+	version string
+
+	// table is the overridden offset table and builtin a copy of it before the overrides
+	// were applied.
+	table, builtin reflect.Value
+
+	frames  atomic.Uint64
+	invalid atomic.Uint64
+	// restored is set once the built-in offsets were restored.
+	restored atomic.Bool
+}
+
+// HasOffsetOverrides returns true if offset overrides apply to version of runtime. The
+// loaders then also load versions they do not support, so that the support of a version
+// can be added with overrides.
+func HasOffsetOverrides(runtime, version string) bool {
+	return len(applyingOffsetOverrides(runtime, version)) > 0
+}
+
+// applyingOffsetOverrides returns the offset overrides of runtime that apply to version, from
+// the most to the least specific version.
+func applyingOffsetOverrides(runtime, version string) []map[string]uint64 {
+	offsetOverridesMu.RLock()
+	versions := offsetOverrides[runtime]
+	offsetOverridesMu.RUnlock()
+
+	var applying []map[string]uint64
+	for v := version; v != ""; {
+		if offsets, ok := versions[v]; ok {
+			applying = append(applying, offsets)
+		}
+		i := strings.LastIndexByte(v, '.')
+		if i < 0 {
+			break
+		}
+		v = v[:i]
+	}
+	return applying
+}
+
+// ApplyOffsetOverrides overrides the offsets in the built-in table vmStructs, a pointer to a
+// struct, with the offset overrides of runtime and version. The overrides of a version apply
+// also to its more specific versions, which take precedence, e.g. those of "3.2" to "3.2.3".
+// If no overrides apply, nil is returned. Offsets that do not exist in vmStructs or do not fit
+// into their field are an error. The frames of the interpreter then need to be checked with
+// OverriddenOffsets.ValidateFrame, which restores the built-in offsets in vmStructs if the
+// overrides look wrong. So the loaders read the offsets from vmStructs when they are used,
+// instead of deriving values from them when they are loaded.
+func ApplyOffsetOverrides(runtime, version string, vmStructs any) (*OverriddenOffsets, error) {
+	applying := applyingOffsetOverrides(runtime, version)
+	if len(applying) == 0 {
+		return nil, nil
+	}
+
+	table := reflect.ValueOf(vmStructs).Elem()
+	builtin := reflect.New(table.Type()).Elem()
+	builtin.Set(table)
+	for i := len(applying) - 1; i >= 0; i-- {
+		for name, value := range applying[i] {
+			if err := setOffset(table, name, value); err != nil {
+				table.Set(builtin)
+				return nil, fmt.Errorf("invalid %s %s offset override %s: %v",
+					runtime, version, name, err)
+			}
+		}
+	}
+	log.Infof("Using offset overrides for %s %s", runtime, version)
+	return &OverriddenOffsets{runtime: runtime, version: version, table: table,
+		builtin: builtin}, nil
+}
+
+// setOffset sets the offset of table with the given dot-separated name to value.
+func setOffset(table reflect.Value, name string, value uint64) error {
+	field, err := offsetField(table, name)
+	if err != nil {
+		return err
+	}
+	if field.OverflowUint(value) {
+		return fmt.Errorf("%d exceeds %v", value, field.Type())
+	}
+	field.SetUint(value)
+	return nil
+}
+
+// offsetField returns the settable offset field of table with the given dot-separated name.
+// Fields of the built-in tables are mostly unexported, so they are set through their address.
+func offsetField(table reflect.Value, name string) (reflect.Value, error) {
+	field := table
+	for _, part := range strings.Split(name, ".") {
+		if field.Kind() != reflect.Struct {
+			return reflect.Value{}, errors.New("no such offset")
+		}
+		if field = field.FieldByName(part); !field.IsValid() {
+			return reflect.Value{}, errors.New("no such offset")
+		}
+	}
+	switch field.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return reflect.Value{}, errors.New("not an offset")
+	}
+	// nolint:gosec
+	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem(), nil
+}
+
+// ValidateFrame checks that the function name a frame was symbolized with is empty or
+// printable, as names read with wrong offsets typically are not. Once enough frames were
+// checked, it is logged whether the overrides look right. If most frames are invalid, the
+// built-in offsets are restored, which apply to the instances attached afterwards and to
+// the frames symbolized afterwards. ValidateFrame accepts all frames if o is nil, i.e. if
+// the offsets were not overridden, or if the built-in offsets were restored. The frames are
+// symbolized and the instances attached under the lock of the process manager, which thus
+// also guards the restore.
+func (o *OverriddenOffsets) ValidateFrame(functionName string) error {
+	if o == nil || o.restored.Load() {
+		return nil
+	}
+	var err error
+	if functionName != "" && !libpf.IsValidString(functionName) {
+		err = fmt.Errorf("%w: invalid function name %q", ErrInvalidFrame, functionName)
+		o.invalid.Add(1)
+	}
+	if frames := o.frames.Add(1); frames == offsetOverrideCheckFrames {
+		if invalid := o.invalid.Load(); invalid*2 > frames {
+			log.Warnf("Offset overrides for %s %s look wrong, %d of %d frames are invalid, "+
+				"restoring the built-in offsets", o.runtime, o.version, invalid, frames)
+			o.table.Set(o.builtin)
+			o.restored.Store(true)
+		} else {
+			log.Infof("Offset overrides for %s %s look right, %d of %d frames are valid",
+				o.runtime, o.version, frames-invalid, frames)
+		}
+	}
+	return err
+}
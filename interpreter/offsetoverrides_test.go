@@ -0,0 +1,158 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package interpreter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOffsets mimics the built-in offset tables of the loaders.
+// nolint:golint,stylecheck,revive
+type testOffsets struct {
+	frame_struct struct {
+		pc, iseq uint8
+	}
+	Sizeof uint
+	name   string
+}
+
+// registerTestOffsets registers testOffsets as the offset table of ruby for the test.
+func registerTestOffsets(t *testing.T) {
+	tables := offsetTables
+	offsetTables = map[string]reflect.Type{}
+	t.Cleanup(func() { offsetTables = tables })
+	RegisterOffsetTable("ruby", testOffsets{})
+}
+
+func TestLoadOffsetOverrides(t *testing.T) {
+	registerTestOffsets(t)
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "offsets.json")
+	require.NoError(t, os.WriteFile(fileName,
+		[]byte(`{"ruby": {"3.2.3": {"frame_struct.pc": 8}}}`), 0o644))
+	overrides, err := LoadOffsetOverrides(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, OffsetOverrides{"ruby": {"3.2.3": {"frame_struct.pc": 8}}}, overrides)
+
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"java": {"17": {"pc": 8}}}`), 0o644))
+	_, err = LoadOffsetOverrides(fileName)
+	assert.ErrorContains(t, err, "unsupported runtime 'java'")
+
+	require.NoError(t, os.WriteFile(fileName, []byte(`{"ruby": {"3.2.3": {"pc": -1}}}`), 0o644))
+	_, err = LoadOffsetOverrides(fileName)
+	assert.Error(t, err)
+
+	// The names and values are checked against the registered offset table.
+	require.NoError(t, os.WriteFile(fileName,
+		[]byte(`{"ruby": {"3.2.3": {"frame_struct.ep": 8}}}`), 0o644))
+	_, err = LoadOffsetOverrides(fileName)
+	assert.ErrorContains(t, err, "offset override frame_struct.ep")
+	require.NoError(t, os.WriteFile(fileName,
+		[]byte(`{"ruby": {"3.2": {"frame_struct.pc": 256}}}`), 0o644))
+	_, err = LoadOffsetOverrides(fileName)
+	assert.ErrorContains(t, err, "256 exceeds uint8")
+
+	_, err = LoadOffsetOverrides(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestApplyOffsetOverrides(t *testing.T) {
+	t.Cleanup(func() { SetOffsetOverrides(nil) })
+
+	SetOffsetOverrides(OffsetOverrides{"ruby": {
+		"3.2":   {"frame_struct.pc": 8, "frame_struct.iseq": 16},
+		"3.2.3": {"frame_struct.iseq": 24},
+		"3.3.0": {"Sizeof": 80},
+	}})
+
+	assert.True(t, HasOffsetOverrides("ruby", "3.2.3"))
+	assert.True(t, HasOffsetOverrides("ruby", "3.2"))
+	assert.False(t, HasOffsetOverrides("ruby", "3.1.4"))
+	assert.False(t, HasOffsetOverrides("python", "3.2"))
+
+	var offsets testOffsets
+	overrides, err := ApplyOffsetOverrides("ruby", "3.2.3", &offsets)
+	require.NoError(t, err)
+	assert.NotNil(t, overrides)
+	assert.Equal(t, uint8(8), offsets.frame_struct.pc)
+	assert.Equal(t, uint8(24), offsets.frame_struct.iseq)
+	assert.Equal(t, uint(0), offsets.Sizeof)
+
+	offsets = testOffsets{Sizeof: 40}
+	overrides, err = ApplyOffsetOverrides("ruby", "3.1.4", &offsets)
+	require.NoError(t, err)
+	assert.Nil(t, overrides)
+	assert.Equal(t, testOffsets{Sizeof: 40}, offsets)
+
+	overrides, err = ApplyOffsetOverrides("python", "3.2", &offsets)
+	require.NoError(t, err)
+	assert.Nil(t, overrides)
+
+	for name, value := range map[string]uint64{
+		"frame_struct.ep":    0,
+		"frame_struct":       0,
+		"frame_struct.pc.lo": 0,
+		"name":               0,
+		"frame_struct.pc":    256,
+	} {
+		SetOffsetOverrides(OffsetOverrides{"ruby": {"3.2": {name: value}}})
+		offsets = testOffsets{Sizeof: 40}
+		_, err = ApplyOffsetOverrides("ruby", "3.2.3", &offsets)
+		assert.Error(t, err, name)
+		assert.Equal(t, testOffsets{Sizeof: 40}, offsets, name)
+	}
+}
+
+func TestValidateFrame(t *testing.T) {
+	var none *OverriddenOffsets
+	assert.NoError(t, none.ValidateFrame("\x00\x01"))
+
+	o := &OverriddenOffsets{runtime: "ruby", version: "3.2.3"}
+	assert.NoError(t, o.ValidateFrame("main"))
+	assert.NoError(t, o.ValidateFrame(""))
+	err := o.ValidateFrame("\x00\x01")
+	assert.True(t, errors.Is(err, ErrInvalidFrame))
+	assert.Equal(t, uint64(3), o.frames.Load())
+	assert.Equal(t, uint64(1), o.invalid.Load())
+}
+
+func TestValidateFrameRestore(t *testing.T) {
+	t.Cleanup(func() { SetOffsetOverrides(nil) })
+	SetOffsetOverrides(OffsetOverrides{"ruby": {"3.2": {"frame_struct.pc": 8}}})
+
+	offsets := testOffsets{Sizeof: 40}
+	offsets.frame_struct.pc = 16
+	o, err := ApplyOffsetOverrides("ruby", "3.2.3", &offsets)
+	require.NoError(t, err)
+	require.Equal(t, uint8(8), offsets.frame_struct.pc)
+
+	// Once most frames are invalid, the built-in offsets are restored and all frames are
+	// accepted.
+	for i := 0; i < offsetOverrideCheckFrames; i++ {
+		_ = o.ValidateFrame("\x00\x01")
+	}
+	assert.Equal(t, uint8(16), offsets.frame_struct.pc)
+	assert.Equal(t, uint(40), offsets.Sizeof)
+	assert.NoError(t, o.ValidateFrame("\x00\x01"))
+
+	// Mostly valid frames keep the overrides.
+	offsets.frame_struct.pc = 16
+	o, err = ApplyOffsetOverrides("ruby", "3.2.3", &offsets)
+	require.NoError(t, err)
+	for i := 0; i < offsetOverrideCheckFrames; i++ {
+		_ = o.ValidateFrame("main")
+	}
+	assert.Equal(t, uint8(8), offsets.frame_struct.pc)
+	assert.Error(t, o.ValidateFrame("\x00\x01"))
+}
@@ -95,8 +95,9 @@ var (
 	libperlRegex = regexp.MustCompile(`^(?:.*/)?libperl\.so[^/]*$`)
 
 	// compiler check to make sure the needed interfaces are satisfied
-	_ interpreter.Data     = &perlData{}
-	_ interpreter.Instance = &perlInstance{}
+	_ interpreter.Data           = &perlData{}
+	_ interpreter.VersionLabeler = &perlData{}
+	_ interpreter.Instance       = &perlInstance{}
 )
 
 type perlData struct {
@@ -196,6 +197,10 @@ type perlData struct {
 
 	// stateInTSD is set if the we have state TSD key address
 	stateInTSD bool
+
+	// overrides tracks the frames symbolized with overridden offsets, if the offsets of
+	// vmStructs were overridden.
+	overrides *interpreter.OverriddenOffsets
 }
 
 type perlInstance struct {
@@ -609,6 +614,9 @@ func (i *perlInstance) Symbolize(symbolReporter reporter.SymbolReporter,
 	if err != nil {
 		return fmt.Errorf("failed to get Perl GV %x: %v", gvAddr, err)
 	}
+	if err = i.d.overrides.ValidateFrame(functionName); err != nil {
+		return err
+	}
 
 	// This can only happen if gvAddr is 0,
 	// which we use to denote code at the top level (e.g
@@ -641,6 +649,11 @@ func (i *perlInstance) Symbolize(symbolReporter reporter.SymbolReporter,
 	return nil
 }
 
+func (d *perlData) VersionLabel() (name, value string) {
+	ver := d.version
+	return "perl.version", fmt.Sprintf("%d.%d.%d", (ver>>16)&0xff, (ver>>8)&0xff, ver&0xff)
+}
+
 func (d *perlData) String() string {
 	ver := d.version
 	return fmt.Sprintf("Perl %d.%d.%d", (ver>>16)&0xff, (ver>>8)&0xff, ver&0xff)
@@ -684,14 +697,26 @@ func (d *perlData) Attach(_ interpreter.EbpfHandler, _ libpf.PID, bias libpf.Add
 	}, nil
 }
 
-func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
-	mainDSO := false
-	if !libperlRegex.MatchString(info.FileName()) {
-		mainDSO = true
-		if !perlRegex.MatchString(info.FileName()) {
-			return nil, nil
-		}
-	}
+// Loader is the interpreter.Loader for Perl.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
+
+func init() {
+	interpreter.RegisterOffsetTable("perl", perlData{}.vmStructs)
+}
+
+// Detect implements the interpreter.Loader interface.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return libperlRegex.MatchString(info.FileName()) ||
+		perlRegex.MatchString(info.FileName()) ||
+		info.HasSymbol("PL_revision")
+}
+
+// New implements the interpreter.Loader interface.
+func (loader) New(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
+	mainDSO := !libperlRegex.MatchString(info.FileName())
 
 	ef, err := info.GetELF()
 	if err != nil {
@@ -735,11 +760,16 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 	// checking the introspection offset validity. 5.14 had major rework for internals.
 	// And 5.18 had some HV related changes.
 	const minVer, maxVer = 0x051c00, 0x052500
-	if version < minVer || version >= maxVer {
-		return nil, fmt.Errorf("unsupported Perl %d.%d.%d (need >= %d.%d and < %d.%d)",
-			verBytes[0], verBytes[1], verBytes[2],
-			(minVer>>16)&0xff, (minVer>>8)&0xff,
-			(maxVer>>16)&0xff, (maxVer>>8)&0xff)
+	perlVersion := fmt.Sprintf("%d.%d.%d", verBytes[0], verBytes[1], verBytes[2])
+	if (version < minVer || version >= maxVer) &&
+		!interpreter.HasOffsetOverrides("perl", perlVersion) {
+		return nil, &interpreter.UnsupportedVersionError{
+			Runtime: "Perl",
+			Version: perlVersion,
+			Supported: fmt.Sprintf(">= %d.%d and < %d.%d",
+				(minVer>>16)&0xff, (minVer>>8)&0xff,
+				(maxVer>>16)&0xff, (maxVer>>8)&0xff),
+		}
 	}
 
 	// "PL_thr_key" contains the TSD key since Perl 5.15.2
@@ -752,11 +782,13 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		// Fallback to using the global interpreter state.
 		curcopAddr, err = ef.LookupSymbolAddress("PL_curcop")
 		if err != nil {
-			return nil, fmt.Errorf("perl %x: PL_curcop not found: %v", version, err)
+			return nil, fmt.Errorf("%w: perl %x: PL_curcop not found: %v",
+				interpreter.ErrOffsetsUnavailable, version, err)
 		}
 		cursiAddr, err = ef.LookupSymbolAddress("PL_curstackinfo")
 		if err != nil {
-			return nil, fmt.Errorf("perl %x: PL_curstackinfo not found: %v", version, err)
+			return nil, fmt.Errorf("%w: perl %x: PL_curstackinfo not found: %v",
+				interpreter.ErrOffsetsUnavailable, version, err)
 		}
 		stateInTSD = false
 		if curcopAddr < cursiAddr {
@@ -841,6 +873,11 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		vms.xpvhv_with_aux.xpvhv_aux = 0x20
 	}
 
+	if d.overrides, err = interpreter.ApplyOffsetOverrides("perl", perlVersion,
+		vms); err != nil {
+		return nil, err
+	}
+
 	if err = ebpf.UpdateInterpreterOffsets(support.ProgUnwindPerl,
 		info.FileID(), interpRanges); err != nil {
 		return nil, err
@@ -72,8 +72,9 @@ var (
 	versionMatch = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)`)
 
 	// compiler check to make sure the needed interfaces are satisfied
-	_ interpreter.Data     = &php7Data{}
-	_ interpreter.Instance = &php7Instance{}
+	_ interpreter.Data           = &php7Data{}
+	_ interpreter.VersionLabeler = &php7Data{}
+	_ interpreter.Instance       = &php7Instance{}
 )
 
 type php7Data struct {
@@ -89,6 +90,10 @@ type php7Data struct {
 	// store return addresses.
 	rtAddr libpf.Address
 
+	// overrides tracks the frames symbolized with overridden offsets, if the offsets of
+	// vmStructs were overridden.
+	overrides *interpreter.OverriddenOffsets
+
 	// vmStructs reflects the PHP internal class names and the offsets of named field
 	// nolint:golint,stylecheck,revive
 	vmStructs struct {
@@ -205,12 +210,15 @@ func (i *php7Instance) getFunction(addr libpf.Address, typeInfo uint32) (*phpFun
 	vms := &i.d.vmStructs
 	fobj := make([]byte, vms.zend_function.Sizeof)
 	if err := i.rm.Read(addr, fobj); err != nil {
-		return nil, fmt.Errorf("failed to read function object: %v", err)
+		return nil, fmt.Errorf("%w: function object: %v", interpreter.ErrMemoryReadFailed, err)
 	}
 
 	// Parse the zend_function structure
 	ftype := npsr.Uint8(fobj, vms.zend_function.common_type)
 	fname := i.rm.String(npsr.Ptr(fobj, vms.zend_function.common_funcname) + vms.zend_string.val)
+	if err := i.d.overrides.ValidateFrame(fname); err != nil {
+		return nil, err
+	}
 
 	if fname != "" && !libpf.IsValidString(fname) {
 		log.Debugf("Extracted invalid PHP function name at 0x%x '%v'", addr, []byte(fname))
@@ -321,6 +329,11 @@ func (i *php7Instance) Symbolize(symbolReporter reporter.SymbolReporter,
 	return nil
 }
 
+func (d *php7Data) VersionLabel() (name, value string) {
+	ver := d.version
+	return "php.version", fmt.Sprintf("%d.%d.%d", (ver>>16)&0xff, (ver>>8)&0xff, ver&0xff)
+}
+
 func (d *php7Data) String() string {
 	ver := d.version
 	return fmt.Sprintf("PHP %d.%d.%d", (ver>>16)&0xff, (ver>>8)&0xff, ver&0xff)
@@ -473,11 +486,23 @@ func determineVMKind(ef *pfelf.File) (uint, error) {
 	return vmKind, nil
 }
 
-func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
-	if !phpRegex.MatchString(info.FileName()) {
-		return nil, nil
-	}
+// Loader is the interpreter.Loader for PHP.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
 
+func init() {
+	interpreter.RegisterOffsetTable("php", php7Data{}.vmStructs)
+}
+
+// Detect implements the interpreter.Loader interface.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return phpRegex.MatchString(info.FileName())
+}
+
+// New implements the interpreter.Loader interface.
+func (loader) New(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
 	ef, err := info.GetELF()
 	if err != nil {
 		return nil, err
@@ -491,16 +516,22 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 	// Only tested on PHP7.3-PHP8.1. Other similar versions probably only require
 	// tweaking the offsets.
 	const minVer, maxVer = 0x070300, 0x080300
-	if version < minVer || version >= maxVer {
-		return nil, fmt.Errorf("PHP version %d.%d.%d (need >= %d.%d and < %d.%d)",
-			(version>>16)&0xff, (version>>8)&0xff, version&0xff,
-			(minVer>>16)&0xff, (minVer>>8)&0xff,
-			(maxVer>>16)&0xff, (maxVer>>8)&0xff)
+	phpVersion := fmt.Sprintf("%d.%d.%d", (version>>16)&0xff, (version>>8)&0xff, version&0xff)
+	if (version < minVer || version >= maxVer) &&
+		!interpreter.HasOffsetOverrides("php", phpVersion) {
+		return nil, &interpreter.UnsupportedVersionError{
+			Runtime: "PHP",
+			Version: phpVersion,
+			Supported: fmt.Sprintf(">= %d.%d and < %d.%d",
+				(minVer>>16)&0xff, (minVer>>8)&0xff,
+				(maxVer>>16)&0xff, (maxVer>>8)&0xff),
+		}
 	}
 
 	egAddr, err := ef.LookupSymbolAddress("executor_globals")
 	if err != nil {
-		return nil, fmt.Errorf("PHP %x: executor_globals not found: %v", version, err)
+		return nil, fmt.Errorf("%w: PHP %x: executor_globals not found: %v",
+			interpreter.ErrOffsetsUnavailable, version, err)
 	}
 
 	// Zend/zend_vm_execute.h: execute_ex(zend_execute_data *ex) is the main VM
@@ -570,6 +601,11 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		vms.zend_function.op_array_linestart = 144
 	}
 
+	if pid.overrides, err = interpreter.ApplyOffsetOverrides("php", phpVersion,
+		vms); err != nil {
+		return nil, err
+	}
+
 	if err = ebpf.UpdateInterpreterOffsets(support.ProgUnwindPHP,
 		info.FileID(), interpRanges); err != nil {
 		return nil, err
@@ -348,11 +348,19 @@ func getOpcacheJITInfo(ef *pfelf.File) (dasmBuf, dasmSize libpf.Address, err err
 	return libpf.Address(dasmBufPtr), libpf.Address(dasmSizePtr), nil
 }
 
-func Loader(_ interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
-	if !opcacheRegex.MatchString(info.FileName()) {
-		return nil, nil
-	}
+// Loader is the interpreter.Loader for the PHP OPcache JIT.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
+
+// Detect implements the interpreter.Loader interface.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return opcacheRegex.MatchString(info.FileName())
+}
 
+// New implements the interpreter.Loader interface.
+func (loader) New(_ interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
 	ef, err := info.GetELF()
 	if err != nil {
 		return nil, fmt.Errorf("could not get ELF: %w", err)
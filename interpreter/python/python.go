@@ -50,6 +50,13 @@ type pythonData struct {
 
 	autoTLSKey libpf.SymbolValue
 
+	// pyRuntime is the address of _PyRuntime, or zero before Python 3.7, which has none.
+	pyRuntime libpf.SymbolValue
+
+	// overrides tracks the frames symbolized with overridden offsets, if the offsets of
+	// vmStructs were overridden.
+	overrides *interpreter.OverriddenOffsets
+
 	// vmStructs reflects the Python Interpreter introspection data we want
 	// need to extract data from the runtime. The fields are named as they are
 	// in the Python code. Eventually some of these fields will be read from
@@ -89,15 +96,34 @@ type pythonData struct {
 		PyBytesObject struct {
 			Sizeof uint
 		}
+		// https://github.com/python/cpython/blob/deaf509e8fc6e0363bd6f26d52ad42f976ec42f2/Include/internal/pycore_runtime.h#L90
+		PyRuntimeState struct {
+			InterpretersHead uint `name:"interpreters.head"`
+			// https://github.com/python/cpython/blob/v3.12.1/Include/internal/pycore_runtime.h#L124
+			AutoTSSKey uint `name:"autoTSSkey"` // Python 3.12+
+		}
+		// https://github.com/python/cpython/blob/deaf509e8fc6e0363bd6f26d52ad42f976ec42f2/Include/internal/pycore_interp.h#L88
+		PyInterpreterState struct {
+			Next       uint `name:"next"`
+			TStateHead uint `name:"tstate_head"` // threads.head in Python 3.11+
+		}
 		// https://github.com/python/cpython/blob/deaf509e8fc6e0363bd6f26d52ad42f976ec42f2/Include/cpython/pystate.h#L82
 		PyThreadState struct {
-			Frame uint `name:"frame"`
+			Next           uint `name:"next"`
+			Frame          uint `name:"frame"`
+			ThreadID       uint `name:"thread_id"`
+			NativeThreadID uint `name:"native_thread_id"` // Python 3.11+
 		}
+		// https://github.com/python/cpython/blob/v3.12.1/Include/internal/pycore_frame.h#L51
 		PyFrameObject struct {
-			Back    uint `name:"f_back"`
-			Code    uint `name:"f_code"`
-			LastI   uint `name:"f_lasti"`
-			IsEntry uint `name:"f_is_entry"`
+			Back  uint `name:"f_back"`
+			Code  uint `name:"f_code"`
+			LastI uint `name:"f_lasti"`
+			// EntryMember is the offset of the member that marks the frames that are the
+			// first of an evaluation, if it has EntryValue: is_entry in Python 3.11 and
+			// owner with FRAME_OWNED_BY_CSTACK since Python 3.12.
+			EntryMember uint `name:"f_is_entry"`
+			EntryValue  uint
 		}
 		// https://github.com/python/cpython/blob/deaf509e8fc6e0363bd6f26d52ad42f976ec42f2/Include/cpython/pystate.h#L38
 		PyCFrame struct {
@@ -107,11 +133,16 @@ type pythonData struct {
 }
 
 var _ interpreter.Data = &pythonData{}
+var _ interpreter.VersionLabeler = &pythonData{}
 
 func (d *pythonData) String() string {
 	return fmt.Sprintf("Python %d.%d", d.version>>8, d.version&0xff)
 }
 
+func (d *pythonData) VersionLabel() (name, value string) {
+	return "python.version", fmt.Sprintf("%d.%d", d.version>>8, d.version&0xff)
+}
+
 func (d *pythonData) Attach(_ interpreter.EbpfHandler, _ libpf.PID, bias libpf.Address,
 	rm remotememory.RemoteMemory) (interpreter.Instance, error) {
 	addrToCodeObject, err :=
@@ -128,10 +159,10 @@ func (d *pythonData) Attach(_ interpreter.EbpfHandler, _ libpf.PID, bias libpf.A
 		addrToCodeObject: addrToCodeObject,
 	}
 
-	switch d.version {
-	case 0x030b:
+	switch {
+	case d.version >= 0x030b:
 		i.getFuncOffset = walkLocationTable
-	case 0x030a:
+	case d.version == 0x030a:
 		i.getFuncOffset = walkLineTable
 	default:
 		i.getFuncOffset = mapByteCodeIndexToLine
@@ -158,6 +189,11 @@ type pythonCodeObject struct {
 	// For Python version >= 3.10 lineTable is the extracted co_linetable.
 	lineTable []byte
 
+	// codeBase holds, for Python 3.11+, the low 16 bits of the address of the bytecode of
+	// the code object (co_code_adaptive). The eBPF code reports the low 16 bits of the
+	// instruction pointer of a frame, which are converted to an instruction index with it.
+	codeBase uint16
+
 	// firstLineNo is the extracted co_firstlineno field, and contains the line
 	// number where the method definition in source code starts
 	firstLineNo uint32
@@ -176,135 +212,134 @@ type pythonCodeObject struct {
 	bciSeen libpf.Set[uint32]
 }
 
-// readSignedVarint returns a variable length encoded signed integer from a location table entry.
-func readSignedVarint(lt []byte) int {
-	uval := readVarint(lt)
+// codeUnitSize is the size of a bytecode instruction (_Py_CODEUNIT) since Python 3.6.
+const codeUnitSize = 2
+
+// readSignedVarint returns a variable length encoded signed integer from a location table entry
+// and the number of bytes it occupies, which is zero if lt is truncated.
+func readSignedVarint(lt []byte) (val, n int) {
+	uval, n := readVarint(lt)
 	if (uval & 1) != 0 {
-		return int(uval>>1) * -1
+		return int(uval>>1) * -1, n
 	}
-	return int(uval >> 1)
+	return int(uval >> 1), n
 }
 
-// readVarint returns a variable length encoded unsigned integer from a location table entry.
-func readVarint(lt []byte) uint {
-	lenLT := len(lt)
-	i := 0
-	nextVal := lt[i]
-	i++
-	val := uint(nextVal & 63)
+// readVarint returns a variable length encoded unsigned integer from a location table entry
+// and the number of bytes it occupies, which is zero if lt is truncated.
+func readVarint(lt []byte) (val uint, n int) {
 	shift := 0
-	for (nextVal & 64) != 0 {
-		if i >= lenLT {
-			return 0
+	for i, b := range lt {
+		val |= uint(b&63) << shift
+		if (b & 64) == 0 {
+			return val, i + 1
 		}
-		nextVal = lt[i]
-		i++
 		shift += 6
-		val |= uint(nextVal&63) << shift
 	}
-	return val
+	return 0, 0
+}
+
+// relativeLine returns the line offset of line from the first line of the function, or zero
+// if line is before it.
+func relativeLine(line int) uint32 {
+	if line < 0 {
+		return 0
+	}
+	return uint32(line)
 }
 
 // walkLocationTable implements the algorithm to read entries from the location table.
-// This was introduced in Python 3.11.
+// This was introduced in Python 3.11. bci is the index of the instruction in code units.
 // nolint:lll
 // https://github.com/python/cpython/blob/deaf509e8fc6e0363bd6f26d52ad42f976ec42f2/Objects/locations.md
-func walkLocationTable(m *pythonCodeObject, addrq uint32) uint32 {
-	if addrq == 0 {
-		return 0
-	}
+func walkLocationTable(m *pythonCodeObject, bci uint32) uint32 {
 	lineTable := m.lineTable
-	lenLineTable := len(lineTable)
-	var i, steps int
-	var firstByte, code uint8
+	// line is relative to co_firstlineno, and start and end delimit the code units of
+	// the entry.
 	var line int
-	for i = 0; i < lenLineTable; {
-		// firstByte encodes initial information about the table entry and how to handle it.
-		firstByte = lineTable[i]
-
-		code = (firstByte >> 3) & 15
+	var start, end uint32
+	for i := 0; i < len(lineTable); {
+		// firstByte encodes the kind of the entry and the number of code units it covers.
+		firstByte := lineTable[i]
+		i++
+		start = end
+		end += uint32(firstByte&7) + 1
 
 		// Handle the 16 possible different codes known as _PyCodeLocationInfoKind.
 		// nolint:lll
 		// https://github.com/python/cpython/blob/deaf509e8fc6e0363bd6f26d52ad42f976ec42f2/Include/cpython/code.h#L219
-		switch code {
+		hasLine := true
+		switch code := (firstByte >> 3) & 15; code {
 		case 0, 1, 2, 3, 4, 5, 6, 7, 8, 9:
-			// PY_CODE_LOCATION_INFO_SHORT does not hold line information.
-			steps = 1
+			// PY_CODE_LOCATION_INFO_SHORT keeps the line and holds one byte of columns.
+			i++
 		case 10, 11, 12:
-			// PY_CODE_LOCATION_INFO_ONE_LINE embeds the line information in the code.
-			steps = 2
+			// PY_CODE_LOCATION_INFO_ONE_LINE embeds the line delta in the code and holds
+			// two bytes of columns.
 			line += int(code - 10)
+			i += 2
 		case 13:
-			// PY_CODE_LOCATION_INFO_NO_COLUMNS
-			steps = 1
-			if i+1 >= lenLineTable {
+			// PY_CODE_LOCATION_INFO_NO_COLUMNS holds only the line delta.
+			diff, n := readSignedVarint(lineTable[i:])
+			if n == 0 {
 				return 0
 			}
-			diff := readSignedVarint(lineTable[i+1:])
 			line += diff
+			i += n
 		case 14:
-			// PY_CODE_LOCATION_INFO_LONG
-			steps = 4
-			if i+1 >= lenLineTable {
+			// PY_CODE_LOCATION_INFO_LONG holds the line delta, followed by the end line
+			// delta and the start and end columns.
+			diff, n := readSignedVarint(lineTable[i:])
+			if n == 0 {
 				return 0
 			}
-			diff := readSignedVarint(lineTable[i+1:])
 			line += diff
+			i += n
+			for j := 0; j < 3; j++ {
+				if _, n = readVarint(lineTable[i:]); n == 0 {
+					return 0
+				}
+				i += n
+			}
 		case 15:
 			// PY_CODE_LOCATION_INFO_NONE does not hold line information
-			steps = 0
-		default:
-			log.Debugf("Unexpected PyCodeLocationInfoKind %d", code)
-			return 0
+			hasLine = false
 		}
 
-		// Calculate position of the next table entry.
-		// One is added for the firstByte of the current entry and steps represents its
-		// variable length.
-		i += steps + 1
-
-		if line >= int(addrq) {
-			return uint32(line)
+		if start <= bci && bci < end {
+			if !hasLine {
+				return 0
+			}
+			return relativeLine(line)
 		}
 	}
-	if line < 0 {
-		return 0
-	}
-
-	return uint32(line)
+	return 0
 }
 
 // walkLineTable implements the algorithm to walk the line number table that was introduced
 // with Python 3.10. While firstLineNo still holds the line number of the function, the line
-// number table extends this information with the offset into this function.
-func walkLineTable(m *pythonCodeObject, addrq uint32) uint32 {
-	// The co_linetab format is specified in python Objects/lnotab_notes.txt
-	if addrq == 0 {
-		return 0
-	}
+// number table extends this information with the offset into this function. bci is the index
+// of the instruction in code units, while the table describes ranges of bytes.
+func walkLineTable(m *pythonCodeObject, bci uint32) uint32 {
+	// The co_linetable format is specified in python Objects/lnotab_notes.txt
+	addrq := bci * codeUnitSize
 	lineTable := m.lineTable
-	var line, start, end uint32
-	for i := 0; i < len(lineTable)/2; i += 2 {
-		sDelta := lineTable[i]
-		lDelta := int8(lineTable[i+1])
-		if lDelta == 0 {
-			end += uint32(sDelta)
-			continue
-		}
+	var line int
+	var start, end uint32
+	for i := 0; i+1 < len(lineTable); i += 2 {
 		start = end
-		end = start + uint32(sDelta)
-		if lDelta == -128 {
-			// A line delta of -128 is a special indicator mentioned in
-			// Objects/lnotab_notes.txt and indicates an invalid line number.
-			continue
-		}
-		line += uint32(lDelta)
-		if end == start {
-			continue
+		end += uint32(lineTable[i])
+		lDelta := int8(lineTable[i+1])
+		// A line delta of -128 is a special indicator mentioned in
+		// Objects/lnotab_notes.txt and indicates an invalid line number.
+		if lDelta != -128 {
+			line += int(lDelta)
 		}
-		if end > addrq {
-			return line
+		if start <= addrq && addrq < end {
+			if lDelta == -128 {
+				return 0
+			}
+			return relativeLine(line)
 		}
 	}
 	return 0
@@ -331,6 +366,11 @@ func mapByteCodeIndexToLine(m *pythonCodeObject, bci uint32) uint32 {
 
 func (m *pythonCodeObject) symbolize(symbolizer interpreter.Symbolizer, bci uint32,
 	getFuncOffset getFuncOffsetFunc, trace *libpf.Trace) error {
+	if m.version >= 0x30b {
+		// Report the instruction index, which does not depend on the address of the code
+		// object, so that it identifies the same line in all processes.
+		bci = uint32(uint16(bci)-m.codeBase) / codeUnitSize
+	}
 	trace.AppendFrame(libpf.PythonFrame, m.fileID, libpf.AddressOrLineno(bci))
 
 	// Check if this is already symbolized
@@ -432,17 +472,35 @@ func (p *pythonInstance) UpdateTSDInfo(ebpf interpreter.EbpfHandler, pid libpf.P
 			indirect:   C.u8(tsdInfo.Indirect),
 		},
 
+		PyInterpreterState_next:        C.u8(vm.PyInterpreterState.Next),
+		PyInterpreterState_tstate_head: C.u8(vm.PyInterpreterState.TStateHead),
+		PyThreadState_next:             C.u8(vm.PyThreadState.Next),
+		PyThreadState_thread_id:        C.u8(vm.PyThreadState.ThreadID),
+		PyThreadState_native_thread_id: C.u8(vm.PyThreadState.NativeThreadID),
 		PyThreadState_frame:            C.u8(vm.PyThreadState.Frame),
 		PyCFrame_current_frame:         C.u8(vm.PyCFrame.CurrentFrame),
 		PyFrameObject_f_back:           C.u8(vm.PyFrameObject.Back),
 		PyFrameObject_f_code:           C.u8(vm.PyFrameObject.Code),
 		PyFrameObject_f_lasti:          C.u8(vm.PyFrameObject.LastI),
-		PyFrameObject_f_is_entry:       C.u8(vm.PyFrameObject.IsEntry),
+		PyFrameObject_entry_member:     C.u8(vm.PyFrameObject.EntryMember),
+		PyFrameObject_entry_value:      C.u8(vm.PyFrameObject.EntryValue),
 		PyCodeObject_co_argcount:       C.u8(vm.PyCodeObject.ArgCount),
 		PyCodeObject_co_kwonlyargcount: C.u8(vm.PyCodeObject.KwOnlyArgCount),
 		PyCodeObject_co_flags:          C.u8(vm.PyCodeObject.Flags),
 		PyCodeObject_co_firstlineno:    C.u8(vm.PyCodeObject.FirstLineno),
 	}
+	// Python 3.12 moved autoTSSkey into _PyRuntime. As for the decoded key of earlier
+	// versions, the pthread_key_t is the second int of the Py_tss_t.
+	if vm.PyRuntimeState.AutoTSSKey != 0 {
+		cdata.autoTLSKeyAddr = C.u64(d.pyRuntime) +
+			C.u64(vm.PyRuntimeState.AutoTSSKey) + 4 + p.bias
+	}
+	// The thread states are not searched for threads without thread state in TSD if the
+	// offset of the pointer to the first interpreter in _PyRuntime is unknown.
+	if vm.PyRuntimeState.InterpretersHead != 0 {
+		cdata.interpHeadAddr = C.u64(d.pyRuntime) +
+			C.u64(vm.PyRuntimeState.InterpretersHead) + p.bias
+	}
 
 	err := ebpf.UpdateProcData(libpf.Python, pid, unsafe.Pointer(&cdata))
 	if err != nil {
@@ -511,7 +569,7 @@ func (p *pythonInstance) getCodeObject(addr libpf.Address,
 	vms := &p.d.vmStructs
 	cobj := make([]byte, vms.PyCodeObject.Sizeof)
 	if err := p.rm.Read(addr, cobj); err != nil {
-		return nil, fmt.Errorf("failed to read code object: %v", err)
+		return nil, fmt.Errorf("%w: code object: %v", interpreter.ErrMemoryReadFailed, err)
 	}
 
 	// Parse the PyCodeObject structure
@@ -535,6 +593,9 @@ func (p *pythonInstance) getCodeObject(addr libpf.Address,
 	if name == "" {
 		name = p.rm.String(data + npsr.Ptr(cobj, vms.PyCodeObject.Name))
 	}
+	if err := p.d.overrides.ValidateFrame(name); err != nil {
+		return nil, err
+	}
 	if !libpf.IsValidString(name) {
 		log.Debugf("Extracted invalid Python method/function name at 0x%x '%v'",
 			addr, []byte(name))
@@ -571,7 +632,7 @@ func (p *pythonInstance) getCodeObject(addr libpf.Address,
 	lineTable := make([]byte, lineTableSize)
 	err = p.rm.Read(lineInfoPtr+libpf.Address(vms.PyBytesObject.Sizeof)-1, lineTable)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read line table: %v", err)
+		return nil, fmt.Errorf("%w: line table: %v", interpreter.ErrMemoryReadFailed, err)
 	}
 
 	// The fnv hash Write() method calls cannot fail, so it's safe to ignore the errors.
@@ -587,8 +648,16 @@ func (p *pythonInstance) getCodeObject(addr libpf.Address,
 		return nil, fmt.Errorf("failed to create a file ID: %v", err)
 	}
 
+	var codeBase uint16
+	if p.d.version >= 0x30b {
+		// Since Python 3.11, the basic size of code objects is the offset of their
+		// bytecode, which follows the PyCodeObject fields.
+		codeBase = uint16(addr + libpf.Address(vms.PyCodeObject.Sizeof))
+	}
+
 	pco := &pythonCodeObject{
 		version:        p.d.version,
+		codeBase:       codeBase,
 		name:           name,
 		sourceFileName: sourceFileName,
 		firstLineNo:    firstLineNo,
@@ -714,15 +783,47 @@ func decodeStub(ef *pfelf.File, addrBase libpf.SymbolValue, symbolName libpf.Sym
 	return libpf.SymbolValueInvalid
 }
 
-func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
+// Loader is the interpreter.Loader for Python.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
+
+func init() {
+	interpreter.RegisterOffsetTable("python", pythonData{}.vmStructs)
+}
+
+// Detect implements the interpreter.Loader interface. Executables with other names are
+// detected by the interpreter loop, which every executable embedding Python links.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return libpythonRegex.MatchString(info.FileName()) ||
+		pythonRegex.MatchString(info.FileName()) ||
+		info.HasSymbol("_PyEval_EvalFrameDefault")
+}
+
+// staticPythonVersion returns the version of an interpreter that is statically linked into an
+// executable whose name does not tell the version. It is read from Py_Version, which holds
+// PY_VERSION_HEX since Python 3.11, so older versions are not supported in such executables.
+func staticPythonVersion(ef *pfelf.File) (uint16, error) {
+	addr, err := ef.LookupSymbolAddress("Py_Version")
+	if err != nil {
+		return 0, fmt.Errorf("%w: unable to determine the version of the embedded "+
+			"interpreter: %v", interpreter.ErrOffsetsUnavailable, err)
+	}
+	var hexVersion uint32
+	if _, err = ef.ReadVirtualMemory(libpf.SliceFrom(&hexVersion), int64(addr)); err != nil {
+		return 0, fmt.Errorf("failed to read Py_Version: %v", err)
+	}
+	return uint16(hexVersion >> 16), nil
+}
+
+// New implements the interpreter.Loader interface.
+func (loader) New(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
 	mainDSO := false
 	matches := libpythonRegex.FindStringSubmatch(info.FileName())
 	if matches == nil {
 		mainDSO = true
 		matches = pythonRegex.FindStringSubmatch(info.FileName())
-		if matches == nil {
-			return nil, nil
-		}
 	}
 
 	ef, err := info.GetELF()
@@ -745,31 +846,48 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		}
 	}
 
+	var version uint16
+	if matches != nil {
+		major, _ := strconv.Atoi(matches[1])
+		minor, _ := strconv.Atoi(matches[2])
+		version = uint16(major*0x100 + minor)
+	} else if version, err = staticPythonVersion(ef); err != nil {
+		return nil, err
+	}
+	major, minor := version>>8, version&0xff
+
 	var pyruntimeAddr, autoTLSKey libpf.SymbolValue
-	major, _ := strconv.Atoi(matches[1])
-	minor, _ := strconv.Atoi(matches[2])
-	version := uint16(major*0x100 + minor)
 
-	const minVer, maxVer = 0x306, 0x30b
-	if version < minVer || version > maxVer {
-		return nil, fmt.Errorf("unsupported Python %d.%d (need >= %d.%d and <= %d.%d)",
-			major, minor,
-			(minVer>>8)&0xff, minVer&0xff,
-			(maxVer>>8)&0xff, maxVer&0xff)
+	const minVer, maxVer = 0x306, 0x30c
+	pythonVersion := fmt.Sprintf("%d.%d", major, minor)
+	if (version < minVer || version > maxVer) &&
+		!interpreter.HasOffsetOverrides("python", pythonVersion) {
+		return nil, &interpreter.UnsupportedVersionError{
+			Runtime: "Python",
+			Version: pythonVersion,
+			Supported: fmt.Sprintf(">= %d.%d and <= %d.%d",
+				(minVer>>8)&0xff, minVer&0xff,
+				(maxVer>>8)&0xff, maxVer&0xff),
+		}
 	}
 
 	if version >= 0x307 {
 		if pyruntimeAddr, err = ef.LookupSymbolAddress("_PyRuntime"); err != nil {
-			return nil, fmt.Errorf("_PyRuntime not defined: %v", err)
+			return nil, fmt.Errorf("%w: _PyRuntime not defined: %v", interpreter.ErrOffsetsUnavailable, err)
 		}
 	}
 
 	// Calls first: PyThread_tss_get(autoTSSKey)
-	autoTLSKey = decodeStub(ef, pyruntimeAddr, "PyGILState_GetThisThreadState", 0)
-	if autoTLSKey == libpf.SymbolValueInvalid {
-		return nil, fmt.Errorf("unable to resolve autoTLSKey")
+	// Python 3.12+ builds may keep the key in a callee-saved register for the call, so its
+	// offset in _PyRuntime is used instead, see UpdateTSDInfo.
+	if version < 0x30c {
+		autoTLSKey = decodeStub(ef, pyruntimeAddr, "PyGILState_GetThisThreadState", 0)
+		if autoTLSKey == libpf.SymbolValueInvalid {
+			return nil, fmt.Errorf("%w: unable to resolve autoTLSKey",
+				interpreter.ErrOffsetsUnavailable)
+		}
 	}
-	if version >= 0x307 && autoTLSKey%8 == 0 {
+	if version >= 0x307 && version < 0x30c && autoTLSKey%8 == 0 {
 		// On Python 3.7+, the call is to PyThread_tss_get, but can get optimized to
 		// call directly pthread_getspecific. So we might be finding the address
 		// for "Py_tss_t" or "pthread_key_t" depending on call target.
@@ -803,6 +921,7 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 	pd := &pythonData{
 		version:    version,
 		autoTLSKey: autoTLSKey,
+		pyRuntime:  pyruntimeAddr,
 	}
 	vms := &pd.vmStructs
 
@@ -817,16 +936,33 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 	vms.PyVarObject.ObSize = 16
 	vms.PyThreadState.Frame = 24
 
-	if version >= 0x30b {
-		// Starting with 3.11 we no longer can extract needed information from
-		// PyFrameObject. In addition PyFrameObject was replaced with _PyInterpreterFrame.
-		// The following offsets come from _PyInterpreterFrame but we continue to use
-		// PyFrameObject as the structure name, since the struct elements serve the same
-		// function as before.
+	// Starting with 3.11 we no longer can extract needed information from
+	// PyFrameObject. In addition PyFrameObject was replaced with _PyInterpreterFrame.
+	// The following offsets come from _PyInterpreterFrame but we continue to use
+	// PyFrameObject as the structure name, since the struct elements serve the same
+	// function as before.
+	switch {
+	case version >= 0x30c:
+		vms.PyFrameObject.Code = 0
+		vms.PyFrameObject.LastI = 56 // prev_instr
+		vms.PyFrameObject.Back = 8   // previous
+		vms.PyFrameObject.EntryMember = 70
+		vms.PyFrameObject.EntryValue = 3 // FRAME_OWNED_BY_CSTACK
+
+		vms.PyThreadState.Frame = 56 // cframe
+		vms.PyCFrame.CurrentFrame = 0
+
+		// The wstr member got removed from PyASCIIObject.
+		vms.PyASCIIObject.Data = 40
+
+		// autoTSSkey got moved from gilstate into _PyRuntime.
+		vms.PyRuntimeState.AutoTSSKey = 1544
+	case version == 0x30b:
 		vms.PyFrameObject.Code = 32
 		vms.PyFrameObject.LastI = 56 // f_lasti got renamed to prev_instr
 		vms.PyFrameObject.Back = 48  // f_back got renamed to previous
-		vms.PyFrameObject.IsEntry = 68
+		vms.PyFrameObject.EntryMember = 68
+		vms.PyFrameObject.EntryValue = 1
 
 		// frame got removed in PyThreadState but we can use cframe instead.
 		vms.PyThreadState.Frame = 56
@@ -834,6 +970,37 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		vms.PyCFrame.CurrentFrame = 8
 	}
 
+	// The thread states of all interpreters, including sub-interpreters, are searched for
+	// threads whose thread state is not in TSD. Python 3.6 has no _PyRuntime to find the
+	// interpreters.
+	vms.PyInterpreterState.Next = 0
+	vms.PyThreadState.Next = 8
+	switch {
+	case version >= 0x30c:
+		vms.PyRuntimeState.InterpretersHead = 40
+		vms.PyInterpreterState.TStateHead = 72
+		vms.PyThreadState.ThreadID = 136
+		vms.PyThreadState.NativeThreadID = 144
+	case version == 0x30b:
+		vms.PyRuntimeState.InterpretersHead = 40
+		vms.PyInterpreterState.TStateHead = 16
+		vms.PyThreadState.ThreadID = 152
+		vms.PyThreadState.NativeThreadID = 160
+	case version >= 0x308:
+		vms.PyRuntimeState.InterpretersHead = 32
+		vms.PyInterpreterState.TStateHead = 8
+		vms.PyThreadState.ThreadID = 176
+	case version == 0x307:
+		vms.PyRuntimeState.InterpretersHead = 24
+		vms.PyInterpreterState.TStateHead = 8
+		vms.PyThreadState.ThreadID = 176
+	}
+	// Before Python 3.11, the thread states record only the pthread_t of their thread,
+	// which can be matched with the thread pointer on x86_64 only.
+	if ef.Machine != elf.EM_X86_64 {
+		vms.PyThreadState.ThreadID = 0
+	}
+
 	// Read the introspection data from objects types that have it
 	if err := pd.readIntrospectionData(ef, "PyCode_Type", &vms.PyCodeObject); err != nil {
 		return nil, err
@@ -845,6 +1012,13 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		return nil, err
 	}
 
+	// The overrides are applied last, so that they also take precedence over the offsets
+	// read from the introspection data.
+	if pd.overrides, err = interpreter.ApplyOffsetOverrides("python", pythonVersion,
+		vms); err != nil {
+		return nil, err
+	}
+
 	if err := ebpf.UpdateInterpreterOffsets(support.ProgUnwindPython, info.FileID(),
 		interpRanges); err != nil {
 		return nil, err
@@ -95,3 +95,50 @@ func TestPythonRegexs(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTables(t *testing.T) {
+	tests := map[string]struct {
+		getFuncOffset getFuncOffsetFunc
+		lineTable     []byte
+		// expect maps bytecode indexes to the expected line offsets.
+		expect map[uint32]uint32
+	}{
+		"co_lnotab (3.6 - 3.9)": {
+			getFuncOffset: mapByteCodeIndexToLine,
+			// 6 bytes +1 line, 8 bytes +2 lines, 4 bytes -1 line
+			lineTable: []byte{6, 1, 8, 2, 4, 0xff},
+			expect:    map[uint32]uint32{0: 0, 6: 1, 13: 1, 14: 3, 20: 2},
+		},
+		"co_linetable (3.10)": {
+			getFuncOffset: walkLineTable,
+			// 4 bytes at +1 line, 2 bytes without line, 2 bytes at +2 lines
+			lineTable: []byte{4, 1, 2, 0x80, 2, 2},
+			expect:    map[uint32]uint32{0: 1, 1: 1, 2: 0, 3: 3, 4: 0},
+		},
+		"co_linetable (3.11)": {
+			getFuncOffset: walkLocationTable,
+			lineTable: []byte{
+				0xe8, 0x02, // NO_COLUMNS, 1 unit, +1 line
+				0xd9, 0x04, 0x09, // ONE_LINE1, 2 units, columns 4 - 9
+				0xf8,                               // NONE, 1 unit
+				0xf2, 0x03, 0x46, 0x01, 0x00, 0x05, // LONG, 3 units, -1 line, +70 end line
+				0x80, 0x00, // SHORT0, 1 unit
+			},
+			expect: map[uint32]uint32{0: 1, 1: 2, 2: 2, 3: 0, 4: 1, 6: 1, 7: 1, 8: 0},
+		},
+	}
+
+	for name, testcase := range tests {
+		name := name
+		testcase := testcase
+		t.Run(name, func(t *testing.T) {
+			m := &pythonCodeObject{lineTable: testcase.lineTable}
+			for bci, expect := range testcase.expect {
+				if offset := testcase.getFuncOffset(m, bci); offset != expect {
+					t.Fatalf("bci %d: line offset %d does not match expected %d",
+						bci, offset, expect)
+				}
+			}
+		})
+	}
+}
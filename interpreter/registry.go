@@ -0,0 +1,60 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package interpreter
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	registryMu sync.Mutex
+	// registry holds the loaders added with Register in order of registration.
+	registry []registeredLoader
+)
+
+type registeredLoader struct {
+	name   string
+	loader Loader
+}
+
+// Register adds an interpreter Loader that is used in addition to the built-in ones. This
+// allows builds of the agent to support further interpreters without modifying it. The
+// registered loaders are consulted after the enabled built-in loaders, in the order of
+// registration. The name identifies the loader and must be unique.
+//
+// Register is meant to be called from init functions. It panics if the name is empty or
+// was already registered.
+//
+// Frames of a new interpreter type also require support by the eBPF unwinder, so a Loader
+// registered this way typically returns Data that reuses the unwinding of an existing
+// interpreter or native code and only customizes the host agent side handling.
+func Register(name string, loader Loader) {
+	if name == "" || loader == nil {
+		panic("interpreter: Register requires a name and a loader")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, r := range registry {
+		if r.name == name {
+			panic(fmt.Sprintf("interpreter: loader %s registered twice", name))
+		}
+	}
+	registry = append(registry, registeredLoader{name: name, loader: loader})
+}
+
+// RegisteredLoaders returns the loaders added with Register in order of registration.
+func RegisteredLoaders() []Loader {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	loaders := make([]Loader, 0, len(registry))
+	for _, r := range registry {
+		loaders = append(loaders, r.loader)
+	}
+	return loaders
+}
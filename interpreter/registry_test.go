@@ -0,0 +1,34 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package interpreter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testLoader struct{ name string }
+
+func (testLoader) Detect(*LoaderInfo) bool { return false }
+
+func (testLoader) New(EbpfHandler, *LoaderInfo) (Data, error) { return nil, nil }
+
+func TestRegister(t *testing.T) {
+	registry = nil
+	t.Cleanup(func() { registry = nil })
+
+	first, second := testLoader{name: "first"}, testLoader{name: "second"}
+	Register("first", first)
+	Register("second", second)
+	assert.Equal(t, []Loader{first, second}, RegisteredLoaders())
+
+	assert.Panics(t, func() { Register("first", second) })
+	assert.Panics(t, func() { Register("", first) })
+	assert.Panics(t, func() { Register("third", nil) })
+	assert.Len(t, RegisteredLoaders(), 2)
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/elastic/otel-profiling-agent/metrics"
 	"github.com/elastic/otel-profiling-agent/reporter"
 	"github.com/elastic/otel-profiling-agent/support"
+	"github.com/elastic/otel-profiling-agent/tpbase"
 )
 
 // #include "../../support/ebpf/types.h"
@@ -82,8 +83,9 @@ var (
 	rubyVersionRegex = regexp.MustCompile(`^(\d)\.(\d)\.(\d)$`)
 
 	// compiler check to make sure the needed interfaces are satisfied
-	_ interpreter.Data     = &rubyData{}
-	_ interpreter.Instance = &rubyInstance{}
+	_ interpreter.Data           = &rubyData{}
+	_ interpreter.VersionLabeler = &rubyData{}
+	_ interpreter.Instance       = &rubyInstance{}
 )
 
 // nolint:lll
@@ -92,6 +94,17 @@ type rubyData struct {
 	// eBPF program to build ruby backtraces.
 	currentCtxPtr libpf.Address
 
+	// tlsModuleIDSlot is the address of the GOT entry that the dynamic linker fills with
+	// the TLS module ID of libruby, and currentEcTLSOffset the offset of the thread-local
+	// `ruby_current_ec` in its TLS block. If tlsModuleIDSlot is zero, the execution context
+	// is found via currentCtxPtr.
+	tlsModuleIDSlot    libpf.Address
+	currentEcTLSOffset uint64
+
+	// overrides tracks the frames symbolized with overridden offsets, if the offsets of
+	// vmStructs were overridden.
+	overrides *interpreter.OverriddenOffsets
+
 	// version of the currently used Ruby interpreter.
 	// major*0x10000 + minor*0x100 + release (e.g. 3.0.1 -> 0x30001)
 	version uint32
@@ -175,6 +188,28 @@ type rubyData struct {
 	}
 }
 
+// lookupCurrentEcTLS looks up the location of the thread-local ruby_current_ec in ef.
+// This is only possible if libruby accesses it with the general dynamic TLS model,
+// which requires a TLS module ID to be relocated by the dynamic linker.
+func (r *rubyData) lookupCurrentEcTLS(ef *pfelf.File) error {
+	sym, err := ef.LookupSymbol("ruby_current_ec")
+	if err != nil {
+		return err
+	}
+	slot, err := ef.LookupTLSModuleIDSlot("ruby_current_ec")
+	if err != nil {
+		return err
+	}
+	r.tlsModuleIDSlot = slot
+	r.currentEcTLSOffset = uint64(sym.Address)
+	return nil
+}
+
+func (r *rubyData) VersionLabel() (name, value string) {
+	ver := r.version
+	return "ruby.version", fmt.Sprintf("%d.%d.%d", (ver>>16)&0xff, (ver>>8)&0xff, ver&0xff)
+}
+
 func (r *rubyData) Attach(ebpf interpreter.EbpfHandler, pid libpf.PID, bias libpf.Address,
 	rm remotememory.RemoteMemory) (interpreter.Instance, error) {
 	cdata := C.RubyProcInfo{
@@ -202,6 +237,15 @@ func (r *rubyData) Attach(ebpf interpreter.EbpfHandler, pid libpf.PID, bias libp
 		running_ec: C.u16(r.vmStructs.rb_ractor_struct.running_ec),
 	}
 
+	if r.tlsModuleIDSlot != 0 {
+		cdata.tls_module_id_addr = C.u64(r.tlsModuleIDSlot + bias)
+		cdata.current_ec_tls_offset = C.u64(r.currentEcTLSOffset)
+		// Assume glibc until the C-library of the process is known from UpdateTSDInfo.
+		if err := setDTVInfo(&cdata, tpbase.Glibc); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := ebpf.UpdateProcData(libpf.Ruby, pid, unsafe.Pointer(&cdata)); err != nil {
 		return nil, err
 	}
@@ -221,6 +265,7 @@ func (r *rubyData) Attach(ebpf interpreter.EbpfHandler, pid libpf.PID, bias libp
 	return &rubyInstance{
 		r:                    r,
 		rm:                   rm,
+		procInfo:             cdata,
 		iseqBodyPCToFunction: iseqBodyPCToFunction,
 		addrToString:         addrToString,
 		memPool: sync.Pool{
@@ -267,6 +312,9 @@ type rubyInstance struct {
 	r  *rubyData
 	rm remotememory.RemoteMemory
 
+	// procInfo is the process data last loaded into the eBPF maps.
+	procInfo C.RubyProcInfo
+
 	// iseqBodyPCToFunction maps an address and Ruby VM program counter combination to extracted
 	// information from a Ruby instruction sequence object.
 	iseqBodyPCToFunction *freelru.LRU[rubyIseqBodyPC, *rubyIseq]
@@ -286,6 +334,33 @@ func (r *rubyInstance) Detach(ebpf interpreter.EbpfHandler, pid libpf.PID) error
 	return ebpf.DeleteProcData(libpf.Ruby, pid)
 }
 
+// setDTVInfo sets the information to find the TLS block of libruby for the C-library libc.
+func setDTVInfo(cdata *C.RubyProcInfo, libc tpbase.Libc) error {
+	dtvInfo, err := tpbase.DTVInfo(libc, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	cdata.dtvInfo = C.TSDInfo{
+		offset:     C.s16(dtvInfo.Offset),
+		multiplier: C.u8(dtvInfo.Multiplier),
+		indirect:   C.u8(dtvInfo.Indirect),
+	}
+	return nil
+}
+
+// UpdateTSDInfo updates the layout of the dynamic thread vector to the one of the C-library
+// of the process, if the execution context is found through it.
+func (r *rubyInstance) UpdateTSDInfo(ebpf interpreter.EbpfHandler, pid libpf.PID,
+	tsdInfo tpbase.TSDInfo) error {
+	if r.r.tlsModuleIDSlot == 0 {
+		return nil
+	}
+	if err := setDTVInfo(&r.procInfo, tsdInfo.Libc()); err != nil {
+		return err
+	}
+	return ebpf.UpdateProcData(libpf.Ruby, pid, unsafe.Pointer(&r.procInfo))
+}
+
 // readRubyArrayDataPtr obtains the data pointer of a Ruby array (RArray).
 //
 // https://github.com/ruby/ruby/blob/95aff2146/include/ruby/internal/core/rarray.h#L87
@@ -682,6 +757,9 @@ func (r *rubyInstance) Symbolize(symbolReporter reporter.SymbolReporter,
 	if err != nil {
 		return err
 	}
+	if err = r.r.overrides.ValidateFrame(functionName); err != nil {
+		return err
+	}
 	if !libpf.IsValidString(functionName) {
 		log.Debugf("Extracted invalid Ruby method name at 0x%x '%v'",
 			iseqBody, []byte(functionName))
@@ -803,11 +881,24 @@ func determineRubyVersion(ef *pfelf.File) (uint32, error) {
 	return uint32(major*0x10000 + minor*0x100 + release), nil
 }
 
-func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpreter.Data, error) {
-	if !rubyRegex.MatchString(info.FileName()) {
-		return nil, nil
-	}
+// Loader is the interpreter.Loader for Ruby.
+var Loader interpreter.Loader = loader{}
+
+type loader struct{}
 
+func init() {
+	interpreter.RegisterOffsetTable("ruby", rubyData{}.vmStructs)
+}
+
+// Detect implements the interpreter.Loader interface. Executables that link libruby
+// statically are detected by the version symbol, which is also used to determine the version.
+func (loader) Detect(info *interpreter.LoaderInfo) bool {
+	return rubyRegex.MatchString(info.FileName()) || info.HasSymbol("ruby_version")
+}
+
+// New implements the interpreter.Loader interface.
+func (loader) New(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
 	ef, err := info.GetELF()
 	if err != nil {
 		return nil, err
@@ -825,11 +916,17 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 	// - this is currently the newest stable version
 
 	const minVer, maxVer = 0x20500, 0x30300
-	if version < minVer || version >= maxVer {
-		return nil, fmt.Errorf("unsupported Ruby %d.%d.%d (need >= %d.%d.%d and <= %d.%d.%d)",
-			(version>>16)&0xff, (version>>8)&0xff, version&0xff,
-			(minVer>>16)&0xff, (minVer>>8)&0xff, minVer&0xff,
-			(maxVer>>16)&0xff, (maxVer>>8)&0xff, maxVer&0xff)
+	rubyVersion := fmt.Sprintf("%d.%d.%d",
+		(version>>16)&0xff, (version>>8)&0xff, version&0xff)
+	if (version < minVer || version >= maxVer) &&
+		!interpreter.HasOffsetOverrides("ruby", rubyVersion) {
+		return nil, &interpreter.UnsupportedVersionError{
+			Runtime: "Ruby",
+			Version: rubyVersion,
+			Supported: fmt.Sprintf(">= %d.%d.%d and < %d.%d.%d",
+				(minVer>>16)&0xff, (minVer>>8)&0xff, minVer&0xff,
+				(maxVer>>16)&0xff, (maxVer>>8)&0xff, maxVer&0xff),
+		}
 	}
 
 	// Before Ruby 2.5 the symbol ruby_current_thread was used for the current execution
@@ -845,7 +942,8 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 	}
 	currentCtxPtr, err := ef.LookupSymbolAddress(currentCtxSymbol)
 	if err != nil {
-		return nil, fmt.Errorf("%v not found: %v", currentCtxSymbol, err)
+		return nil, fmt.Errorf("%w: %v not found: %v", interpreter.ErrOffsetsUnavailable,
+			currentCtxSymbol, err)
 	}
 
 	// rb_vm_exec is used to execute the Ruby frames in the Ruby VM and is called within
@@ -865,6 +963,15 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		currentCtxPtr: libpf.Address(currentCtxPtr),
 	}
 
+	// Ruby 3.x keeps the execution context of the running thread in the thread-local
+	// variable ruby_current_ec. It is preferred over the running_ec of the main ractor,
+	// which does not reflect the executing thread if several threads run Ruby code.
+	if version >= 0x30000 {
+		if err = rid.lookupCurrentEcTLS(ef); err != nil {
+			log.Debugf("Using ruby_single_main_ractor for the execution context: %v", err)
+		}
+	}
+
 	vms := &rid.vmStructs
 
 	// Ruby does not provide introspection data, hard code the struct field offsets. Some
@@ -964,6 +1071,11 @@ func Loader(ebpf interpreter.EbpfHandler, info *interpreter.LoaderInfo) (interpr
 		}
 	}
 
+	if rid.overrides, err = interpreter.ApplyOffsetOverrides("ruby", rubyVersion,
+		vms); err != nil {
+		return nil, err
+	}
+
 	if err = ebpf.UpdateInterpreterOffsets(support.ProgUnwindRuby, info.FileID(),
 		interpRanges); err != nil {
 		return nil, err
@@ -8,6 +8,7 @@ package interpreter
 
 import (
 	"errors"
+	"fmt"
 	"unsafe"
 
 	"github.com/elastic/otel-profiling-agent/host"
@@ -35,17 +36,47 @@ const (
 
 var (
 	ErrMismatchInterpreterType = errors.New("mismatched interpreter type")
+
+	// ErrInterpreterVersionUnsupported is matched by the errors returned by Loader.New for
+	// interpreters whose version is not supported. See UnsupportedVersionError.
+	ErrInterpreterVersionUnsupported = errors.New("unsupported interpreter version")
+	// ErrOffsetsUnavailable is wrapped by the errors returned when the symbols or structure
+	// offsets needed to unwind an interpreter can not be found in its executable.
+	ErrOffsetsUnavailable = errors.New("interpreter offsets unavailable")
+	// ErrMemoryReadFailed is wrapped by the errors returned when the memory of an
+	// interpreter process could not be read, e.g. because the process exited.
+	ErrMemoryReadFailed = errors.New("failed to read interpreter memory")
 )
 
-// The following function Loader and interfaces Data and Instance work together
+// UnsupportedVersionError is returned by Loader.New when an executable was recognized as
+// an interpreter, but its version is not supported by the agent.
+type UnsupportedVersionError struct {
+	// Runtime is the name of the interpreter, e.g. Python.
+	Runtime string
+	// Version is the version of the interpreter found in the executable.
+	Version string
+	// Supported describes the range of supported versions, e.g. ">= 3.6 and <= 3.11".
+	Supported string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported %s %s (need %s)", e.Runtime, e.Version, e.Supported)
+}
+
+// Is makes UnsupportedVersionError match ErrInterpreterVersionUnsupported with errors.Is.
+func (e *UnsupportedVersionError) Is(target error) bool {
+	return target == ErrInterpreterVersionUnsupported
+}
+
+// The following interfaces Loader, Data and Instance work together
 // as an abstraction to support language specific eBPF unwinding and host agent side symbolization
 // of frames.
 //
 // Functionality for these interfaces is divided as follows:
 //
-//  1. Loader is responsible for recognizing if the given mapping/ELF DSO matches by name,
-//     and later by content, to an interpreter supported by the specific implementation.
-//     If yes, it returns Data for this specific DSO. The Loader loads and checks data
+//  1. Loader is responsible for recognizing if the given mapping/ELF DSO matches by name
+//     (Detect), and later by content (New), to an interpreter supported by the specific
+//     implementation. If yes, it returns Data for this specific DSO. The Loader loads and checks data
 //     from given ELF DSO. The intent is to load needed symbols and keep their addresses
 //     relative to the file virtual address space. It can also load static data from the
 //     DSO, such as the exact interpreter version string or number. All this is returned
@@ -108,25 +139,48 @@ type EbpfHandler interface {
 	DeletePidInterpreterMapping(libpf.PID, lpm.Prefix) error
 }
 
-// Loader is a function to detect and load data from given interpreter ELF file.
-// ProcessManager will call each configured Loader in order to see if additional handling and data
-// is needed to unwind interpreter frames.
-//
-// A Loader can return one of the following value combinations:
-//
-//   - `nil, nil`, indicating that it didn't detect the interpreter to belong to it
-//   - `data, nil`, indicating that it wants to handle the executable
-//   - `nil, error`, indicating that a permanent failure occurred during interpreter detection
-type Loader func(ebpf EbpfHandler, info *LoaderInfo) (Data, error)
+// Loader detects and loads data from a given interpreter ELF file. ProcessManager will
+// call each configured Loader in order to see if additional handling and data is needed
+// to unwind interpreter frames. Symbolization of the frames is then done by the Instance
+// returned from the Attach method of the loaded Data.
+type Loader interface {
+	// Detect checks, typically by file name only, whether the executable may belong to
+	// the interpreter. It is called for each new executable and must therefore be cheap.
+	// Interpreters that are statically linked into other executables are detected by
+	// their symbols, see LoaderInfo.HasSymbol.
+	Detect(info *LoaderInfo) bool
+
+	// New loads the interpreter data of an executable for which Detect returned true.
+	// It can return one of the following value combinations:
+	//
+	//   - `nil, nil`, indicating that it didn't detect the interpreter to belong to it
+	//   - `data, nil`, indicating that it wants to handle the executable
+	//   - `nil, error`, indicating that a permanent failure occurred during interpreter
+	//     detection. Errors matching ErrInterpreterVersionUnsupported or
+	//     ErrOffsetsUnavailable disable the interpreter support for the executable.
+	//     Transient errors, as reported by pfelf.IsTransientError, are retried a few
+	//     times when the executable is encountered again.
+	New(ebpf EbpfHandler, info *LoaderInfo) (Data, error)
+}
 
 // Data is the interface to operate on per-ELF DSO data.
 type Data interface {
 	// Attach checks if the given dso is supported, and loads the information
-	// of it to the ebpf maps.
+	// of it to the ebpf maps. bias is the load bias of the dso in the process,
+	// which has to be added to the ELF virtual addresses of LoaderInfo to get the
+	// addresses at run time. It is non-zero for shared libraries and PIE binaries.
 	Attach(ebpf EbpfHandler, pid libpf.PID, bias libpf.Address, rm remotememory.RemoteMemory) (
 		Instance, error)
 }
 
+// VersionLabeler is implemented by Data of interpreters that know their version. The
+// version is attached as a label to the samples of the processes running the interpreter.
+type VersionLabeler interface {
+	// VersionLabel returns the name and value of the label, e.g. "python.version" and
+	// "3.12", or empty strings if the version is not known.
+	VersionLabel() (name, value string)
+}
+
 // Instance is the interface to operate on per-PID data.
 type Instance interface {
 	// Detach removes any information from the ebpf maps. The pid is given as argument so
@@ -0,0 +1,43 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsupportedVersionError(t *testing.T) {
+	err := fmt.Errorf("loading failed: %w", &UnsupportedVersionError{
+		Runtime:   "Python",
+		Version:   "3.14",
+		Supported: ">= 3.6 and <= 3.11",
+	})
+	assert.Equal(t, "loading failed: unsupported Python 3.14 (need >= 3.6 and <= 3.11)",
+		err.Error())
+
+	var versionErr *UnsupportedVersionError
+	require.True(t, errors.As(err, &versionErr))
+	assert.Equal(t, "3.14", versionErr.Version)
+}
+
+func TestLoaderErrors(t *testing.T) {
+	err := fmt.Errorf("loading failed: %w", &UnsupportedVersionError{Runtime: "Ruby"})
+	assert.ErrorIs(t, err, ErrInterpreterVersionUnsupported)
+	assert.NotErrorIs(t, err, ErrOffsetsUnavailable)
+
+	err = fmt.Errorf("%w: _PyRuntime not defined: %v", ErrOffsetsUnavailable,
+		errors.New("symbol not found"))
+	assert.ErrorIs(t, err, ErrOffsetsUnavailable)
+	assert.NotErrorIs(t, err, ErrInterpreterVersionUnsupported)
+	assert.Equal(t, "interpreter offsets unavailable: _PyRuntime not defined: "+
+		"symbol not found", err.Error())
+}
@@ -231,6 +231,17 @@ func (h TraceHash) Hash32() uint32 {
 var _ encoding.TextUnmarshaler = (*TraceHash)(nil)
 var _ encoding.TextMarshaler = (*TraceHash)(nil)
 
+// EventSet identifies the set of perf events whose event triggered the sampling of a trace.
+// The samples of each set are reported as a separate profile type.
+type EventSet uint8
+
+const (
+	// PrimaryEventSet is the set of perf events that is always sampled.
+	PrimaryEventSet EventSet = support.EventSetPrimary
+	// SecondaryEventSet is the optional set of perf events sampled with its own settings.
+	SecondaryEventSet EventSet = support.EventSetSecondary
+)
+
 // AddressOrLineno represents a line number in an interpreted file or an offset into
 // a native file. TODO(thomasdullien): check with regards to JSON marshaling/demarshaling.
 type AddressOrLineno uint64
@@ -280,6 +291,8 @@ const (
 	Perl InterpType = support.FrameMarkerPerl
 	// V8 identifies the V8 interpreter.
 	V8 InterpType = support.FrameMarkerV8
+	// BEAM identifies the BEAM (Erlang) virtual machine.
+	BEAM InterpType = support.FrameMarkerBEAM
 )
 
 // Frame converts the interpreter type into the corresponding frame type.
@@ -298,6 +311,7 @@ var interpTypeToString = map[InterpType]string{
 	Ruby:          "ruby",
 	Perl:          "perl",
 	V8:            "v8",
+	BEAM:          "beam",
 }
 
 // String converts the frame type int to the related string value to be displayed in the UI.
@@ -348,6 +362,8 @@ const (
 	PerlFrame FrameType = support.FrameMarkerPerl
 	// V8Frame identifies the V8 interpreter frames.
 	V8Frame FrameType = support.FrameMarkerV8
+	// BEAMFrame identifies the BEAM (Erlang) virtual machine frames.
+	BEAMFrame FrameType = support.FrameMarkerBEAM
 	// AbortFrame identifies frames that report that further unwinding was aborted due to an error.
 	AbortFrame FrameType = support.FrameMarkerAbort
 )
@@ -476,6 +492,9 @@ type TraceAndCounts struct {
 	Comm          string
 	PodName       string
 	ContainerName string
+	Containerized bool
+	CoreType      string
+	TID           PID
 }
 
 type FrameMetadata struct {
@@ -304,3 +304,75 @@ func TestTraceType(t *testing.T) {
 		assert.Equal(t, test.str, test.ty.String())
 	}
 }
+
+func TestNewSymbolMap(t *testing.T) {
+	symmap := NewSymbolMap(
+		Symbol{Name: "foo", Address: 0x1000, Size: 0x10},
+		Symbol{Name: "bar", Address: 0x2000, Size: 0x20},
+	)
+	assert.Equal(t, 2, symmap.Len())
+
+	addr, err := symmap.LookupSymbolAddress("bar")
+	assert.NoError(t, err)
+	assert.Equal(t, SymbolValue(0x2000), addr)
+
+	name, offs, ok := symmap.LookupByAddress(0x1008)
+	assert.True(t, ok)
+	assert.Equal(t, SymbolName("foo"), name)
+	assert.Equal(t, Address(8), offs)
+
+	_, _, ok = symmap.LookupByAddress(0x1800)
+	assert.False(t, ok)
+}
+
+func TestLookupByAddressTieBreak(t *testing.T) {
+	tests := map[string]struct {
+		symbols  []Symbol
+		expected SymbolName
+	}{
+		"prefer sized": {
+			symbols: []Symbol{
+				{Name: "a_zero", Address: 0x1000, Global: true},
+				{Name: "b_sized", Address: 0x1000, Size: 0x10},
+			},
+			expected: "b_sized",
+		},
+		"prefer global": {
+			symbols: []Symbol{
+				{Name: "a_local", Address: 0x1000, Size: 0x10},
+				{Name: "b_global", Address: 0x1000, Size: 0x10, Global: true},
+			},
+			expected: "b_global",
+		},
+		"prefer name": {
+			symbols: []Symbol{
+				{Name: "c", Address: 0x1000, Size: 0x10, Global: true},
+				{Name: "a", Address: 0x1000, Size: 0x10, Global: true},
+				{Name: "b", Address: 0x1000, Size: 0x10, Global: true},
+			},
+			expected: "a",
+		},
+		"skip sized not covering": {
+			symbols: []Symbol{
+				{Name: "a_short", Address: 0x1000, Size: 0x4, Global: true},
+				{Name: "b_long", Address: 0x1000, Size: 0x10},
+			},
+			expected: "b_long",
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			// The result must not depend on the insertion order.
+			for i := range test.symbols {
+				rotated := append(append([]Symbol{}, test.symbols[i:]...),
+					test.symbols[:i]...)
+				symmap := NewSymbolMap(rotated...)
+				sym, _, ok := symmap.LookupByAddress(0x1008)
+				assert.True(t, ok)
+				assert.Equal(t, test.expected, sym)
+			}
+		})
+	}
+}
@@ -9,9 +9,11 @@ package elfunwindinfo
 import (
 	"bytes"
 	"debug/elf"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"unsafe"
 
 	lru "github.com/elastic/go-freelru"
@@ -160,10 +162,17 @@ type ehFrameHdr struct {
 type reader struct {
 	debugFrame bool
 
+	// data holds the section data starting at the section offset base. Positions are
+	// section offsets, so that base is only non-zero if a part of the section is loaded.
 	data  []byte
+	base  uintptr
 	pos   uintptr
 	end   uintptr
 	vaddr uintptr
+
+	// stream is set if the section is read one entry at a time, and is then used to
+	// read the referenced CIEs.
+	stream *frameStream
 }
 
 // hasData checks if the reader is still in valid state
@@ -189,7 +198,7 @@ func (r *reader) get(n uintptr) unsafe.Pointer {
 		v := uint64(0)
 		return unsafe.Pointer(&v)
 	}
-	return unsafe.Pointer(&r.data[pos])
+	return unsafe.Pointer(&r.data[pos-r.base])
 }
 
 // u8 reads one unsigned byte
@@ -243,11 +252,11 @@ func (r *reader) sleb() sleb128 {
 func (r *reader) str() []byte {
 	cur := r.pos
 	end := r.pos
-	for r.data[end] != 0 {
+	for r.data[end-r.base] != 0 {
 		end++
 	}
 	r.pos = end + 1
-	return r.data[cur:end]
+	return r.data[cur-r.base : end-r.base]
 }
 
 // bytes reads one n-length byte array value
@@ -258,7 +267,7 @@ func (r *reader) bytes(num uintptr) []byte {
 	if !r.isValid() {
 		return nil
 	}
-	return r.data[cur:end]
+	return r.data[cur-r.base : end-r.base]
 }
 
 // expression reads one DWARF expression, and normalizes it in the sense that
@@ -369,7 +378,9 @@ type fdeInfo struct {
 	ciePos  uint64
 	ipLen   uintptr
 	ipStart uintptr
-	sorted  bool
+	// sorted is set if the stack deltas can be added in order. The fdeHook may clear
+	// it to have the deltas merged with the other sources later.
+	sorted bool
 }
 
 const (
@@ -739,7 +750,7 @@ func (r *reader) parseHDR(expectCIE bool) (hlen, ciePos uint64, err error) {
 		return 0, 0, fmt.Errorf("unsupported initial length %#x", hlen)
 	}
 	r.end = pos + uintptr(hlen)
-	if r.end > uintptr(len(r.data)) {
+	if r.end > r.base+uintptr(len(r.data)) {
 		return 0, 0, fmt.Errorf("CIE/FDE extends beyond end at %#x", r.pos)
 	}
 	if !r.debugFrame {
@@ -756,13 +767,21 @@ func (r *reader) parseHDR(expectCIE bool) (hlen, ciePos uint64, err error) {
 			// not to the start of section.
 			ciePos = idPos - ciePos
 		}
-		if ciePos >= uint64(len(r.data)) {
+		if ciePos >= r.sectionSize() {
 			return 0, 0, fmt.Errorf("FDE starts beyond end at %#x", ciePos)
 		}
 	}
 	return hlen, ciePos, nil
 }
 
+// sectionSize returns the size of the section read by r.
+func (r *reader) sectionSize() uint64 {
+	if r.stream != nil {
+		return r.stream.size
+	}
+	return uint64(len(r.data))
+}
+
 // parseCIE reads and processes one Common Information Entry
 // http://dwarfstd.org/doc/DWARF5.pdf §6.4.1
 // https://refspecs.linuxfoundation.org/LSB_5.0.0/LSB-Core-generic/LSB-Core-generic/ehframechpt.html
@@ -904,6 +923,11 @@ func (r *reader) parseFDE(ef, efCode *pfelf.File, ipStart uintptr, deltas *sdtyp
 	if !ok {
 		cr := *r
 		cr.pos = uintptr(fde.ciePos)
+		if r.stream != nil {
+			if cr, err = r.stream.entry(uintptr(fde.ciePos)); err != nil {
+				return 0, fmt.Errorf("CIE %#x failed: %v", fde.ciePos, err)
+			}
+		}
 
 		cie = &cieInfo{}
 		if err = cr.parseCIE(cie); err != nil {
@@ -971,7 +995,7 @@ func (r *reader) parseFDE(ef, efCode *pfelf.File, ipStart uintptr, deltas *sdtyp
 		if hooks != nil {
 			hooks.deltaHook(st.loc, &st.cur, delta)
 		}
-		deltas.AddEx(delta, sorted)
+		deltas.AddEx(delta, fde.sorted)
 	} else {
 		hint := sdtypes.UnwindHintKeep
 		for r.hasData() {
@@ -987,7 +1011,7 @@ func (r *reader) parseFDE(ef, efCode *pfelf.File, ipStart uintptr, deltas *sdtyp
 			if hooks != nil {
 				hooks.deltaHook(ip, &st.cur, delta)
 			}
-			deltas.AddEx(delta, sorted)
+			deltas.AddEx(delta, fde.sorted)
 			hint = sdtypes.UnwindHintNone
 		}
 
@@ -996,7 +1020,7 @@ func (r *reader) parseFDE(ef, efCode *pfelf.File, ipStart uintptr, deltas *sdtyp
 			Hints:   hint,
 			Info:    st.cur.getUnwindInfo(),
 		}
-		deltas.AddEx(delta, sorted)
+		deltas.AddEx(delta, fde.sorted)
 
 		if !r.isValid() {
 			return 0, fmt.Errorf("FDE %x parsing failed", fdeID)
@@ -1014,7 +1038,7 @@ func (r *reader) parseFDE(ef, efCode *pfelf.File, ipStart uintptr, deltas *sdtyp
 		Address: uint64(fde.ipStart + fde.ipLen),
 		Hints:   sdtypes.UnwindHintGap,
 		Info:    info,
-	}, sorted)
+	}, fde.sorted)
 
 	return uintptr(fde.len), nil
 }
@@ -1026,33 +1050,36 @@ type elfRegion struct {
 	vaddr uintptr
 }
 
-// reader creates a `reader` for this ELF region.
-func (ref *elfRegion) reader(pos uintptr, debugFrame bool) reader {
+// reader creates a `reader` for this ELF region, which holds .eh_frame data.
+func (ref *elfRegion) reader(pos uintptr) reader {
 	return reader{
-		debugFrame: debugFrame,
-		data:       ref.data,
-		pos:        pos,
-		end:        uintptr(len(ref.data)),
-		vaddr:      ref.vaddr,
+		data:  ref.data,
+		pos:   pos,
+		end:   uintptr(len(ref.data)),
+		vaddr: ref.vaddr,
 	}
 }
 
 // elfRegionFromSection checks whether a given ELF section looks valid and has data, then
-// creating a elfRegion for it. Otherwise, returns `nil`.
-func elfRegionFromSection(sec *pfelf.Section) *elfRegion {
+// loads it into an elfRegion. If the section is not available, nil is returned. An
+// error is only returned if the section is too large to be loaded into memory.
+func elfRegionFromSection(sec *pfelf.Section) (*elfRegion, error) {
 	if sec == nil || sec.Type == elf.SHT_NOBITS {
-		return nil
+		return nil, nil
 	}
 
 	data, err := sec.Data(maxBytesEHFrame)
 	if err != nil {
-		return nil
+		if errors.Is(err, pfelf.ErrSizeLimitExceeded) {
+			return nil, err
+		}
+		return nil, nil
 	}
 
 	return &elfRegion{
 		data:  data,
 		vaddr: uintptr(sec.Addr),
-	}
+	}, nil
 }
 
 // validateEhFrameHdr checks whether the given `.eh_frame_hdr` section is in a format that we
@@ -1090,8 +1117,12 @@ func findEhSections(ef *pfelf.File) (
 
 	// Attempt to find .eh_frame{,_hdr} via their section header. This should work for the majority
 	// of well-behaved ELF binaries.
-	ehFrameSec = elfRegionFromSection(ef.Section(".eh_frame"))
-	ehFrameHdrSec = elfRegionFromSection(ef.Section(".eh_frame_hdr"))
+	if ehFrameSec, err = elfRegionFromSection(ef.Section(".eh_frame")); err != nil {
+		return nil, nil, err
+	}
+	if ehFrameHdrSec, err = elfRegionFromSection(ef.Section(".eh_frame_hdr")); err != nil {
+		return nil, nil, err
+	}
 
 	// Validate whether we can use the eh_frame_hdr section.
 	if hdr := validateEhFrameHdr(ehFrameHdrSec); hdr == nil {
@@ -1160,7 +1191,7 @@ func walkBinSearchTable(ef *pfelf.File, ehFrameHdrSec *elfRegion, ehFrameSec *el
 
 	// Skip header, which is immediately followed by the binary search table. The header was
 	// already previously validated in `validateEhFrameHdr`.
-	r := ehFrameHdrSec.reader(unsafe.Sizeof(*h), false)
+	r := ehFrameHdrSec.reader(unsafe.Sizeof(*h))
 
 	if _, err := r.ptr(h.ehFramePtrEnc); err != nil {
 		return err
@@ -1192,7 +1223,7 @@ func walkBinSearchTable(ef *pfelf.File, ehFrameHdrSec *elfRegion, ehFrameSec *el
 				fdeAddr, ehFrameSec.vaddr)
 		}
 
-		fr := ehFrameSec.reader(fdeAddr-ehFrameSec.vaddr, false)
+		fr := ehFrameSec.reader(fdeAddr - ehFrameSec.vaddr)
 		_, err = fr.parseFDE(ef, ef, ipStart, deltas, hooks, cieCache, true)
 		if err != nil {
 			return fmt.Errorf("failed to parse FDE: %v", err)
@@ -1204,7 +1235,7 @@ func walkBinSearchTable(ef *pfelf.File, ehFrameHdrSec *elfRegion, ehFrameSec *el
 
 // walkFDEs walks .debug_frame or .eh_frame section, and processes it for stack deltas.
 func walkFDEs(ef, efCode *pfelf.File, ehFrameSec *elfRegion, deltas *sdtypes.StackDeltaArray,
-	hooks ehframeHooks, debugFrame bool) error {
+	hooks ehframeHooks) error {
 	var err error
 
 	cieCache, err := lru.New[uint64, *cieInfo](cieCacheSize, hashUint64)
@@ -1215,7 +1246,7 @@ func walkFDEs(ef, efCode *pfelf.File, ehFrameSec *elfRegion, deltas *sdtypes.Sta
 	// Walk the section, and process each FDE it contains
 	var entryLen uintptr
 	for f := uintptr(0); f < uintptr(len(ehFrameSec.data)); f += entryLen {
-		fr := ehFrameSec.reader(f, debugFrame)
+		fr := ehFrameSec.reader(f)
 		entryLen, err = fr.parseFDE(ef, efCode, 0, deltas, hooks, cieCache, false)
 		if err != nil && !errors.Is(err, errUnexpectedType) {
 			return fmt.Errorf("failed to parse FDE %#x: %v", f, err)
@@ -1253,16 +1284,106 @@ func parseEHFrame(ef *pfelf.File, deltas *sdtypes.StackDeltaArray, hooks ehframe
 	}
 
 	// Otherwise, manually walk the FDEs.
-	return walkFDEs(ef, ef, ehFrameSec, deltas, hooks, false)
+	return walkFDEs(ef, ef, ehFrameSec, deltas, hooks)
+}
+
+// frameStream reads the entries of a .debug_frame section one at a time. In separate
+// debug files the section can be too large to be loaded into memory as a whole.
+type frameStream struct {
+	r     io.ReadSeeker
+	size  uint64
+	vaddr uintptr
 }
 
-// parseDebugFrame parses the .debug_frame DWARF info, extracting stack deltas.
+// read reads len(p) bytes at the section offset pos.
+func (s *frameStream) read(p []byte, pos uintptr) error {
+	if _, err := s.r.Seek(int64(pos), io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(s.r, p)
+	return err
+}
+
+// entry loads the CIE or FDE at the section offset pos, and returns a reader for it.
+func (s *frameStream) entry(pos uintptr) (reader, error) {
+	var hdr [12]byte
+	if err := s.read(hdr[:4], pos); err != nil {
+		return reader{}, err
+	}
+	size := uint64(binary.LittleEndian.Uint32(hdr[:4]))
+	switch {
+	case size < 0xfffffff0:
+		size += 4
+	case size == 0xffffffff:
+		if err := s.read(hdr[4:], pos+4); err != nil {
+			return reader{}, err
+		}
+		size = binary.LittleEndian.Uint64(hdr[4:]) + 4 + 8
+	default:
+		return reader{}, fmt.Errorf("unsupported initial length %#x", size)
+	}
+	if size > s.size-uint64(pos) {
+		return reader{}, fmt.Errorf("CIE/FDE extends beyond end at %#x", pos)
+	}
+	if size > maxBytesEHFrame {
+		return reader{}, &pfelf.SizeLimitError{Kind: ".debug_frame entry", Size: size,
+			Limit: maxBytesEHFrame}
+	}
+
+	data := make([]byte, size)
+	if err := s.read(data, pos); err != nil {
+		return reader{}, err
+	}
+	return reader{
+		debugFrame: true,
+		data:       data,
+		base:       pos,
+		pos:        pos,
+		end:        pos + uintptr(size),
+		vaddr:      s.vaddr,
+		stream:     s,
+	}, nil
+}
+
+// parseDebugFrame parses the .debug_frame DWARF info, extracting stack deltas. The
+// section is read through a frameStream, so only the CIE and FDE being parsed are
+// held in memory.
 func parseDebugFrame(ef, efCode *pfelf.File, deltas *sdtypes.StackDeltaArray,
 	hooks ehframeHooks) error {
-	debugFrameSection := elfRegionFromSection(ef.Section(".debug_frame"))
-	if debugFrameSection == nil {
+	sec := ef.Section(".debug_frame")
+	if sec == nil || sec.Type == elf.SHT_NOBITS || sec.Flags&elf.SHF_COMPRESSED != 0 {
 		return nil
 	}
 
-	return walkFDEs(ef, efCode, debugFrameSection, deltas, hooks, true)
+	stream := &frameStream{r: sec.Open(), size: sec.FileSize, vaddr: uintptr(sec.Addr)}
+	return walkFrameStream(ef, efCode, stream, deltas, hooks)
+}
+
+// walkFrameStream walks the CIEs and FDEs of a .debug_frame section read through stream.
+func walkFrameStream(ef, efCode *pfelf.File, stream *frameStream,
+	deltas *sdtypes.StackDeltaArray, hooks ehframeHooks) error {
+	cieCache, err := lru.New[uint64, *cieInfo](cieCacheSize, hashUint64)
+	if err != nil {
+		return err
+	}
+
+	var entryLen uintptr
+	for f := uintptr(0); uint64(f) < stream.size; f += entryLen {
+		fr, err := stream.entry(f)
+		if err != nil {
+			if errors.Is(err, pfelf.ErrSizeLimitExceeded) {
+				return err
+			}
+			return fmt.Errorf("failed to read FDE %#x: %v", f, err)
+		}
+		entryLen, err = fr.parseFDE(ef, efCode, 0, deltas, hooks, cieCache, false)
+		if err != nil && !errors.Is(err, errUnexpectedType) {
+			return fmt.Errorf("failed to parse FDE %#x: %v", f, err)
+		}
+		if entryLen == 0 {
+			return fmt.Errorf("failed to parse FDE %#x: internal error", f)
+		}
+	}
+
+	return nil
 }
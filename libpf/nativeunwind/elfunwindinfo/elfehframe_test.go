@@ -7,9 +7,13 @@
 package elfunwindinfo
 
 import (
+	"bytes"
+	"debug/elf"
 	"errors"
 	"testing"
 
+	lru "github.com/elastic/go-freelru"
+
 	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
 	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 	"github.com/google/go-cmp/cmp"
@@ -207,3 +211,110 @@ func TestParseCIE(t *testing.T) {
 		})
 	}
 }
+
+func TestUnwindInfoX86CFARegisters(t *testing.T) {
+	tests := map[string]struct {
+		cfa      vmReg
+		expected sdtypes.UnwindInfo
+	}{
+		"rsp": {
+			cfa:      vmReg{arch: elf.EM_X86_64, reg: x86RegRSP, off: 16},
+			expected: deltaRSP(16, 0),
+		},
+		"rbp": {
+			cfa:      vmReg{arch: elf.EM_X86_64, reg: x86RegRBP, off: 16},
+			expected: deltaRBP(16, 0),
+		},
+		// The CFA of _dl_runtime_resolve_xsavec after it saved the stack pointer in RBX.
+		"rbx": {
+			cfa:      vmReg{arch: elf.EM_X86_64, reg: x86RegRBX, off: 32},
+			expected: genDelta(sdtypes.UnwindOpcodeBaseRBX, 32, 0),
+		},
+		"unsupported": {
+			cfa:      vmReg{arch: elf.EM_X86_64, reg: x86RegR12, off: 8},
+			expected: sdtypes.UnwindInfoInvalid,
+		},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			regs := newVMRegsX86()
+			regs.cfa = tc.cfa
+			regs.ra = vmReg{arch: elf.EM_X86_64, reg: regCFA, off: -8}
+			if diff := cmp.Diff(tc.expected, regs.getUnwindInfoX86()); diff != "" {
+				t.Fatalf("unexpected unwind info: %s", diff)
+			}
+		})
+	}
+}
+
+func TestWalkFrameStream(t *testing.T) {
+	ef, err := pfelf.Open("testdata/helloworld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+
+	data := []byte{
+		// CIE
+		16, 0, 0, 0, // length
+		255, 255, 255, 255, // CIE_id
+		1,        // version
+		0,        // augmentation
+		1,        // code_alignment_factor
+		120,      // data_alignment_factor
+		16,       // RIP is the return address
+		12, 7, 8, // CFA = RSP+8
+		0x90, 1, // RIP at CFA-8
+		0, 0, // DW_CFA_nop
+		// FDE
+		24, 0, 0, 0, // length
+		0, 0, 0, 0, // CIE_pointer
+		0, 0x10, 0, 0, 0, 0, 0, 0, // initial_location
+		0x10, 0, 0, 0, 0, 0, 0, 0, // address_range
+		0x41,     // DW_CFA_advance_loc 1
+		0x0e, 16, // CFA = RSP+16
+		0, // DW_CFA_nop
+	}
+
+	// Parse the section loaded as a whole, which the streamed parsing has to match.
+	var expected sdtypes.StackDeltaArray
+	region := &elfRegion{data: data}
+	for pos := uintptr(0); pos < uintptr(len(data)); {
+		r := region.reader(pos)
+		r.debugFrame = true
+		n, err := r.parseFDE(ef, ef, 0, &expected, nil, newCIECache(t), false)
+		if err != nil && !errors.Is(err, errUnexpectedType) {
+			t.Fatal(err)
+		}
+		pos += n
+	}
+	if len(expected) == 0 {
+		t.Fatal("no stack deltas parsed")
+	}
+
+	var deltas sdtypes.StackDeltaArray
+	stream := &frameStream{r: bytes.NewReader(data), size: uint64(len(data))}
+	if err := walkFrameStream(ef, ef, stream, &deltas, nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, deltas); diff != "" {
+		t.Fatalf("unexpected stack deltas: %s", diff)
+	}
+
+	// Entries extending beyond the section are detected.
+	stream = &frameStream{r: bytes.NewReader(data[:len(data)-1]), size: uint64(len(data) - 1)}
+	if err := walkFrameStream(ef, ef, stream, &deltas, nil); err == nil {
+		t.Fatal("truncated section parsed without error")
+	}
+}
+
+func newCIECache(t *testing.T) *lru.LRU[uint64, *cieInfo] {
+	cieCache, err := lru.New[uint64, *cieInfo](cieCacheSize, hashUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cieCache
+}
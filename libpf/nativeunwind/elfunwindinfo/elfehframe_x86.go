@@ -140,6 +140,11 @@ func (regs *vmRegs) getUnwindInfoX86() sdtypes.UnwindInfo {
 			info.Opcode = sdtypes.UnwindOpcodeBaseSP
 			info.Param = int32(regs.cfa.off)
 		}
+	case x86RegRBX:
+		// glibc's lazy binding trampoline _dl_runtime_resolve aligns the stack for
+		// saving the extended state and keeps the original stack pointer in RBX.
+		info.Opcode = sdtypes.UnwindOpcodeBaseRBX
+		info.Param = int32(regs.cfa.off)
 	case regExprPLT:
 		info.Opcode = sdtypes.UnwindOpcodeCommand
 		info.Param = sdtypes.UnwindCommandPLT
@@ -335,138 +335,274 @@ func SearchGoPclntab(ef *pfelf.File) ([]byte, error) {
 	return nil, nil
 }
 
-// Parse Golang .gopclntab spdelta tables and try to produce minified intervals
-// by using large frame pointer ranges when possible
-func parseGoPclntab(ef *pfelf.File, deltas *sdtypes.StackDeltaArray, f *extractionFilter) error {
-	var err error
-	var data []byte
+// goPclntab is a parsed .gopclntab header with references to the tables it describes.
+type goPclntab struct {
+	hdr *pclntabHeader
+	// textStart is the base address of the function entries (Go 1.18+)
+	textStart uintptr
+	// mapSize and funSize are the sizes of a functab entry and a function descriptor
+	mapSize, funSize uintptr
+
+	functab, funcdata, funcnametab, filetab, pctab, cutab []byte
+}
 
-	if ef.InsideCore {
-		// Section tables not available. Use heuristic. Ignore errors as
-		// this might not be a Go binary.
-		data, _ = SearchGoPclntab(ef)
-	} else if s := ef.Section(".gopclntab"); s != nil {
-		// Load the .gopclntab via section if available.
-		if data, err = s.Data(maxBytesGoPclntab); err != nil {
-			return fmt.Errorf("failed to load .gopclntab section: %v", err)
-		}
-	} else if s := ef.Section(".go.buildinfo"); s != nil {
-		// This looks like Go binary. Lookup the runtime.pclntab symbols,
-		// as the .gopclntab section is not available on PIE binaries.
-		// A full symbol table read is needed as these are not dynamic symbols.
-		// Consequently these symbols might be unavailable on a stripped binary.
-		symtab, err := ef.ReadSymbols()
-		if err != nil {
-			// It seems the Go binary was stripped. So we use the heuristic approach
-			// to get the stack deltas.
-			if data, err = SearchGoPclntab(ef); err != nil {
-				return fmt.Errorf("failed to search .gopclntab: %v", err)
-			}
-		} else {
-			start, err := symtab.LookupSymbolAddress("runtime.pclntab")
-			if err != nil {
-				return fmt.Errorf("failed to load .gopclntab via symbols: %v", err)
-			}
-			end, err := symtab.LookupSymbolAddress("runtime.epclntab")
-			if err != nil {
-				return fmt.Errorf("failed to load .gopclntab via symbols: %v", err)
-			}
-			if start >= end {
-				return fmt.Errorf("invalid .gopclntab symbols: %v-%v", start, end)
-			}
-			data = make([]byte, end-start)
-			if _, err := ef.ReadVirtualMemory(data, int64(start)); err != nil {
-				return fmt.Errorf("failed to load .gopclntab via symbols: %v", err)
-			}
-		}
+// newGoPclntab parses the .gopclntab header of data. For Go 1.18 and newer, the function
+// textStart is called to get the address of runtime.text if the header does not contain it.
+func newGoPclntab(data []byte, textStart func() uintptr) (*goPclntab, error) {
+	p := &goPclntab{
+		mapSize: unsafe.Sizeof(pclntabFuncMap{}),
+		funSize: unsafe.Sizeof(pclntabFunc{}),
 	}
-	if data == nil {
-		return nil
-	}
-
-	var textStart uintptr
 	hdrSize := uintptr(PclntabHeaderSize())
-	mapSize := unsafe.Sizeof(pclntabFuncMap{})
-	funSize := unsafe.Sizeof(pclntabFunc{})
 	dataLen := uintptr(len(data))
 	if dataLen < hdrSize {
-		return fmt.Errorf(".gopclntab is too short (%v)", len(data))
+		return nil, fmt.Errorf(".gopclntab is too short (%v)", len(data))
 	}
 
-	var functab, funcdata, funcnametab, filetab, pctab, cutab []byte
-
 	hdr := (*pclntabHeader)(unsafe.Pointer(&data[0]))
-	fieldSize := uintptr(hdr.ptrSize)
+	p.hdr = hdr
 	switch hdr.magic {
 	case magicGo1_2:
-		functabEnd := int(hdrSize + uintptr(hdr.numFuncs)*mapSize + uintptr(hdr.ptrSize))
+		functabEnd := int(hdrSize + uintptr(hdr.numFuncs)*p.mapSize + uintptr(hdr.ptrSize))
 		filetabOffset := getInt32(data, functabEnd)
 		numSourceFiles := getInt32(data, filetabOffset)
-		if filetabOffset == 0 || numSourceFiles == 0 {
-			return fmt.Errorf(".gopclntab corrupt (filetab 0x%x, nfiles %d)",
+		if filetabOffset <= 0 || numSourceFiles <= 0 {
+			return nil, fmt.Errorf(".gopclntab corrupt (filetab 0x%x, nfiles %d)",
 				filetabOffset, numSourceFiles)
 		}
-		functab = data[hdrSize:filetabOffset]
-		cutab = data[filetabOffset:]
-		pctab = data
-		funcnametab = data
-		funcdata = data
-		filetab = data
+		p.functab = data[hdrSize:filetabOffset]
+		p.cutab = data[filetabOffset:]
+		p.pctab = data
+		p.funcnametab = data
+		p.funcdata = data
+		p.filetab = data
 	case magicGo1_16:
 		hdrSize = unsafe.Sizeof(pclntabHeader116{})
 		if dataLen < hdrSize {
-			return fmt.Errorf(".gopclntab is too short (%v)", len(data))
+			return nil, fmt.Errorf(".gopclntab is too short (%v)", len(data))
 		}
 		hdr116 := (*pclntabHeader116)(unsafe.Pointer(&data[0]))
 		if dataLen < hdr116.funcnameOffset || dataLen < hdr116.cuOffset ||
 			dataLen < hdr116.filetabOffset || dataLen < hdr116.pctabOffset ||
 			dataLen < hdr116.pclnOffset {
-			return fmt.Errorf(".gopclntab is corrupt (%x, %x, %x, %x, %x)",
+			return nil, fmt.Errorf(".gopclntab is corrupt (%x, %x, %x, %x, %x)",
 				hdr116.funcnameOffset, hdr116.cuOffset,
 				hdr116.filetabOffset, hdr116.pctabOffset,
 				hdr116.pclnOffset)
 		}
-		funcnametab = data[hdr116.funcnameOffset:]
-		cutab = data[hdr116.cuOffset:]
-		filetab = data[hdr116.filetabOffset:]
-		pctab = data[hdr116.pctabOffset:]
-		functab = data[hdr116.pclnOffset:]
-		funcdata = functab
+		p.funcnametab = data[hdr116.funcnameOffset:]
+		p.cutab = data[hdr116.cuOffset:]
+		p.filetab = data[hdr116.filetabOffset:]
+		p.pctab = data[hdr116.pctabOffset:]
+		p.functab = data[hdr116.pclnOffset:]
+		p.funcdata = p.functab
 	case magicGo1_18, magicGo1_20:
 		hdrSize = unsafe.Sizeof(pclntabHeader118{})
 		if dataLen < hdrSize {
-			return fmt.Errorf(".gopclntab is too short (%v)", dataLen)
+			return nil, fmt.Errorf(".gopclntab is too short (%v)", dataLen)
 		}
 		hdr118 := (*pclntabHeader118)(unsafe.Pointer(&data[0]))
 		if dataLen < hdr118.funcnameOffset || dataLen < hdr118.cuOffset ||
 			dataLen < hdr118.filetabOffset || dataLen < hdr118.pctabOffset ||
 			dataLen < hdr118.pclnOffset {
-			return fmt.Errorf(".gopclntab is corrupt (%x, %x, %x, %x, %x)",
+			return nil, fmt.Errorf(".gopclntab is corrupt (%x, %x, %x, %x, %x)",
 				hdr118.funcnameOffset, hdr118.cuOffset,
 				hdr118.filetabOffset, hdr118.pctabOffset,
 				hdr118.pclnOffset)
 		}
-		funcnametab = data[hdr118.funcnameOffset:]
-		cutab = data[hdr118.cuOffset:]
-		filetab = data[hdr118.filetabOffset:]
-		pctab = data[hdr118.pctabOffset:]
-		functab = data[hdr118.pclnOffset:]
-		funcdata = functab
-		textStart = hdr118.textStart
-		funSize = unsafe.Sizeof(pclntabFunc118{})
-		// With the change of the type of the first field of _func in Go 1.18, this
-		// value is now hard coded.
+		p.funcnametab = data[hdr118.funcnameOffset:]
+		p.cutab = data[hdr118.cuOffset:]
+		p.filetab = data[hdr118.filetabOffset:]
+		p.pctab = data[hdr118.pctabOffset:]
+		p.functab = data[hdr118.pclnOffset:]
+		p.funcdata = p.functab
+		p.textStart = hdr118.textStart
+		if p.textStart == 0 {
+			// Recent linkers no longer fill in the header field.
+			p.textStart = textStart()
+		}
+		p.funSize = unsafe.Sizeof(pclntabFunc118{})
+		// With the change of the type of the first field of _func in Go 1.18, the
+		// functab fields are now 32-bit.
 		//
 		// nolint:lll
 		// See https://github.com/golang/go/blob/6df0957060b1315db4fd6a359eefc3ee92fcc198/src/debug/gosym/pclntab.go#L376-L382
-		fieldSize = uintptr(4)
-		mapSize = fieldSize * 2
+		p.mapSize = 2 * 4
 	default:
-		return fmt.Errorf(".gopclntab format (0x%x) not supported", hdr.magic)
+		return nil, fmt.Errorf(".gopclntab format (0x%x) not supported", hdr.magic)
 	}
 	if hdr.pad != 0 || hdr.ptrSize != 8 {
-		return fmt.Errorf(".gopclntab header: %x, %x", hdr.pad, hdr.ptrSize)
+		return nil, fmt.Errorf(".gopclntab header: %x, %x", hdr.pad, hdr.ptrSize)
+	}
+	// The functab has an entry per function, followed by the end address of the
+	// last function which is half of an entry.
+	if uintptr(len(p.functab)) < uintptr(hdr.numFuncs)*p.mapSize+p.mapSize/2 {
+		return nil, fmt.Errorf(".gopclntab functab is too short for %d functions",
+			hdr.numFuncs)
+	}
+	return p, nil
+}
+
+// entryPC returns the start address of function i. For i equal to the number of
+// functions, the end address of the last function is returned.
+func (p *goPclntab) entryPC(i uint64) uintptr {
+	off := uintptr(i) * p.mapSize
+	if IsGo118orNewer(p.hdr.magic) {
+		// nolint:lll
+		// See: https://github.com/golang/go/blob/6df0957060b1315db4fd6a359eefc3ee92fcc198/src/debug/gosym/pclntab.go#L401-L413
+		return p.textStart + uintptr(*(*uint32)(unsafe.Pointer(&p.functab[off])))
 	}
+	return *(*uintptr)(unsafe.Pointer(&p.functab[off]))
+}
+
+// function returns the descriptor of function i.
+func (p *goPclntab) function(i uint64) (pclntabFunc, error) {
+	off := uintptr(i) * p.mapSize
+	var funcOff uintptr
+	if IsGo118orNewer(p.hdr.magic) {
+		funcOff = uintptr(*(*uint32)(unsafe.Pointer(&p.functab[off+p.mapSize/2])))
+	} else {
+		funcOff = uintptr((*pclntabFuncMap)(unsafe.Pointer(&p.functab[off])).funcOff)
+	}
+	if uintptr(len(p.funcdata)) < funcOff+p.funSize {
+		return pclntabFunc{}, fmt.Errorf(".gopclntab func %v descriptor is invalid", i)
+	}
+	if IsGo118orNewer(p.hdr.magic) {
+		tmp := (*pclntabFunc118)(unsafe.Pointer(&p.funcdata[funcOff]))
+		return pclntabFunc{
+			startPc:   uint64(p.textStart) + uint64(tmp.entryoff),
+			nameOff:   tmp.nameOff,
+			argsSize:  tmp.argsSize,
+			frameSize: tmp.argsSize,
+			pcspOff:   tmp.pcspOff,
+			pcfileOff: tmp.pcfileOff,
+			pclnOff:   tmp.pclnOff,
+			nfuncData: tmp.nfuncData,
+			npcData:   tmp.npcData,
+		}, nil
+	}
+	return *(*pclntabFunc)(unsafe.Pointer(&p.funcdata[funcOff])), nil
+}
+
+// pcvalue returns the value of the pcvalue table at offset off for the address pc in
+// the function fun.
+func (p *goPclntab) pcvalue(off int32, fun *pclntabFunc, pc uintptr) (int32, bool) {
+	if off <= 0 || int(off) >= len(p.pctab) {
+		return 0, false
+	}
+	v := newPcval(p.pctab[off:], uint(fun.startPc), p.hdr.quantum)
+	for uint(pc) >= v.pcEnd {
+		if !v.step() {
+			return 0, false
+		}
+	}
+	return v.val, true
+}
+
+// cuIndex returns the index into the cutab for a file index of the function fun.
+func (p *goPclntab) cuIndex(fun *pclntabFunc, fileIndex int32) int {
+	if p.hdr.magic == magicGo1_16 || IsGo118orNewer(p.hdr.magic) {
+		// Starting with Go 1.16 the file indexes are relative to the compilation
+		// unit, which is stored in the last field of the function descriptor.
+		return int(fileIndex) + int(fun.npcData)
+	}
+	return int(fileIndex)
+}
+
+// fileName returns the source file name for the cutab index.
+func (p *goPclntab) fileName(cuIndex int) []byte {
+	return getString(p.filetab, getInt32(p.cutab, 4*cuIndex))
+}
+
+// goTextStart returns the address of runtime.text, which is the base of the function
+// addresses in the Go 1.18+ .gopclntab.
+func goTextStart(ef *pfelf.File) uintptr {
+	// With external linking, C code can precede runtime.text in the .text section.
+	// So the symbol is preferred, but it is unavailable in stripped executables.
+	if symtab, err := ef.ReadSymbols(); err == nil {
+		if addr, err := symtab.LookupSymbolAddress("runtime.text"); err == nil {
+			return uintptr(addr)
+		}
+	}
+	if s := ef.Section(".text"); s != nil {
+		return uintptr(s.Addr)
+	}
+	return 0
+}
+
+// LoadGoPclntab returns the .gopclntab data of a Go executable. The section is used
+// if available, otherwise the runtime.pclntab symbols or, as last resort, a heuristic
+// search of the RO data segments. No data and no error is returned for files that do
+// not look like Go executables.
+func LoadGoPclntab(ef *pfelf.File) ([]byte, error) {
+	var err error
+	var data []byte
+
+	if ef.InsideCore {
+		// Section tables not available. Use heuristic. Ignore errors as
+		// this might not be a Go binary.
+		data, _ = SearchGoPclntab(ef)
+	} else if s := ef.Section(".gopclntab"); s != nil {
+		// Load the .gopclntab via section if available.
+		if data, err = s.Data(maxBytesGoPclntab); err != nil {
+			return nil, fmt.Errorf("failed to load .gopclntab section: %w", err)
+		}
+	} else if s := ef.Section(".go.buildinfo"); s != nil {
+		// This looks like Go binary. Lookup the runtime.pclntab symbols,
+		// as the .gopclntab section is not available on PIE binaries.
+		// A full symbol table read is needed as these are not dynamic symbols.
+		// Consequently these symbols might be unavailable on a stripped binary.
+		symtab, err := ef.ReadSymbols()
+		if err != nil {
+			// It seems the Go binary was stripped. So we use the heuristic approach
+			// to get the stack deltas.
+			if data, err = SearchGoPclntab(ef); err != nil {
+				return nil, fmt.Errorf("failed to search .gopclntab: %w", err)
+			}
+		} else {
+			start, err := symtab.LookupSymbolAddress("runtime.pclntab")
+			if err != nil {
+				return nil, fmt.Errorf("failed to load .gopclntab via symbols: %v", err)
+			}
+			end, err := symtab.LookupSymbolAddress("runtime.epclntab")
+			if err != nil {
+				return nil, fmt.Errorf("failed to load .gopclntab via symbols: %v", err)
+			}
+			if start >= end {
+				return nil, fmt.Errorf("invalid .gopclntab symbols: %v-%v", start, end)
+			}
+			data = make([]byte, end-start)
+			if _, err := ef.ReadVirtualMemory(data, int64(start)); err != nil {
+				return nil, fmt.Errorf("failed to load .gopclntab via symbols: %v", err)
+			}
+		}
+	} else if ef.IsGolang() {
+		// The Go build ID note is present, but the section headers are not. This is
+		// the case for statically linked executables with stripped section headers.
+		if data, err = SearchGoPclntab(ef); err != nil {
+			return nil, fmt.Errorf("failed to search .gopclntab: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// Parse Golang .gopclntab spdelta tables and try to produce minified intervals
+// by using large frame pointer ranges when possible
+func parseGoPclntab(ef *pfelf.File, deltas *sdtypes.StackDeltaArray, f *extractionFilter) error {
+	data, err := LoadGoPclntab(ef)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+
+	p, err := newGoPclntab(data, func() uintptr { return goTextStart(ef) })
+	if err != nil {
+		return err
+	}
+	hdr := p.hdr
+	dataLen := uintptr(len(data))
 
 	// Go uses frame-pointers by default since Go 1.7, but unfortunately
 	// it is not necessarily available when in code from non-Golang source
@@ -480,43 +616,14 @@ func parseGoPclntab(ef *pfelf.File, deltas *sdtypes.StackDeltaArray, f *extracti
 	// Get target machine architecture for the ELF file
 	arch := ef.Machine
 
-	fmap := &pclntabFuncMap{}
-	fun := &pclntabFunc{}
 	// Iterate the golang PC to function lookup table (sorted by PC)
 	for i := uint64(0); i < hdr.numFuncs; i++ {
-		if IsGo118orNewer(hdr.magic) {
-			// nolint:lll
-			// See: https://github.com/golang/go/blob/6df0957060b1315db4fd6a359eefc3ee92fcc198/src/debug/gosym/pclntab.go#L401-L413
-			*fmap = pclntabFuncMap{}
-			funcIdx := uintptr(i) * 2 * fieldSize
-			fmap.pc = uint64(*(*uint32)(unsafe.Pointer(&functab[funcIdx])))
-			fmap.funcOff = uint64(*(*uint32)(unsafe.Pointer(&functab[funcIdx+fieldSize])))
-			fmap.pc += uint64(textStart)
-		} else {
-			fmap = (*pclntabFuncMap)(unsafe.Pointer(&functab[uintptr(i)*mapSize]))
-		}
-		// Get the function data
-		if uintptr(len(funcdata)) < uintptr(fmap.funcOff)+funSize {
-			return fmt.Errorf(".gopclntab func %v descriptor is invalid", i)
-		}
-		if IsGo118orNewer(hdr.magic) {
-			tmp := (*pclntabFunc118)(unsafe.Pointer(&funcdata[fmap.funcOff]))
-			*fun = pclntabFunc{
-				startPc:   uint64(textStart) + uint64(tmp.entryoff),
-				nameOff:   tmp.nameOff,
-				argsSize:  tmp.argsSize,
-				frameSize: tmp.argsSize,
-				pcspOff:   tmp.pcspOff,
-				pcfileOff: tmp.pcfileOff,
-				pclnOff:   tmp.pclnOff,
-				nfuncData: tmp.nfuncData,
-				npcData:   tmp.npcData,
-			}
-		} else {
-			fun = (*pclntabFunc)(unsafe.Pointer(&funcdata[fmap.funcOff]))
+		fun, err := p.function(i)
+		if err != nil {
+			return err
 		}
 		// First, check for functions with special handling.
-		funcName := getString(funcnametab, int(fun.nameOff))
+		funcName := getString(p.funcnametab, int(fun.nameOff))
 		if info, found := goFunctionsStopDelta[string(funcName)]; found {
 			deltas.Add(sdtypes.StackDelta{
 				Address: fun.startPc,
@@ -528,30 +635,26 @@ func parseGoPclntab(ef *pfelf.File, deltas *sdtypes.StackDeltaArray, f *extracti
 		// Use source file to determine strategy if possible, and default
 		// to using frame pointers in the unlikely case of no file info
 		strategy := strategyFramePointer
-		if fun.pcfileOff != 0 {
-			p := newPcval(pctab[fun.pcfileOff:], uint(fun.startPc), hdr.quantum)
-			fileIndex := int(p.val)
-			if hdr.magic == magicGo1_16 || IsGo118orNewer(hdr.magic) {
-				fileIndex += int(fun.npcData)
-			}
+		if fileIndex, ok := p.pcvalue(fun.pcfileOff, &fun, uintptr(fun.startPc)); ok {
+			cuIndex := p.cuIndex(&fun, fileIndex)
 
 			// Determine strategy
-			strategy = sourceStrategy[fileIndex]
+			strategy = sourceStrategy[cuIndex]
 			if strategy == strategyUnknown {
-				sourceFile := getString(filetab, getInt32(cutab, 4*fileIndex))
+				sourceFile := p.fileName(cuIndex)
 				strategy = getSourceFileStrategy(arch, sourceFile)
-				sourceStrategy[fileIndex] = strategy
+				sourceStrategy[cuIndex] = strategy
 			}
 		}
 
 		switch arch {
 		case elf.EM_X86_64:
-			if err := parseX86pclntabFunc(deltas, fun, dataLen, pctab, strategy, i,
+			if err := parseX86pclntabFunc(deltas, &fun, dataLen, p.pctab, strategy, i,
 				hdr.quantum); err != nil {
 				return err
 			}
 		case elf.EM_AARCH64:
-			if err := parseArm64pclntabFunc(deltas, fun, dataLen, pctab, i,
+			if err := parseArm64pclntabFunc(deltas, &fun, dataLen, p.pctab, i,
 				hdr.quantum); err != nil {
 				return err
 			}
@@ -559,21 +662,12 @@ func parseGoPclntab(ef *pfelf.File, deltas *sdtypes.StackDeltaArray, f *extracti
 	}
 
 	// Filter out .gopclntab info from other sources
-	if IsGo118orNewer(hdr.magic) {
-		// nolint:lll
-		// https://github.com/golang/go/blob/6df0957060b1315db4fd6a359eefc3ee92fcc198/src/debug/gosym/pclntab.go#L440-L450
-		f.start = uintptr(*(*uint32)(unsafe.Pointer(&functab[0])))
-		f.start += textStart
-		// From go12symtab document, reason for indexing beyond hdr.numFuncs:
-		// "The final pcN value is the address just beyond func(N-1), so that the binary
-		// search can distinguish between a pc inside func(N-1) and a pc outside the text
-		// segment."
-		f.end = uintptr(*(*uint32)(unsafe.Pointer(&functab[uintptr(hdr.numFuncs)*mapSize])))
-		f.end += textStart
-	} else {
-		f.start = *(*uintptr)(unsafe.Pointer(&functab[0]))
-		f.end = *(*uintptr)(unsafe.Pointer(&functab[uintptr(hdr.numFuncs)*mapSize]))
-	}
+	f.start = p.entryPC(0)
+	// From go12symtab document, reason for indexing beyond hdr.numFuncs:
+	// "The final pcN value is the address just beyond func(N-1), so that the binary
+	// search can distinguish between a pc inside func(N-1) and a pc outside the text
+	// segment."
+	f.end = p.entryPC(hdr.numFuncs)
 	f.golangFrames = true
 
 	// Add end of code indicator
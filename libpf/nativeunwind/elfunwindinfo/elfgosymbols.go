@@ -0,0 +1,94 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package elfunwindinfo
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// GoSourceInfo describes the source code location of an address in a Go executable.
+type GoSourceInfo struct {
+	// FunctionName is the fully qualified name of the function.
+	FunctionName string
+	// FunctionStart is the address of the first instruction of the function.
+	FunctionStart uint64
+	// FileName is the source file name, and Line the line number within it.
+	FileName string
+	Line     int
+}
+
+// GoSymbolTable resolves addresses of a Go executable to function names and source
+// lines using its .gopclntab. The Go 1.2, 1.16, 1.18 and 1.20 formats are supported.
+// Unlike DWARF or the ELF symbol table, the .gopclntab is present in all Go executables,
+// including statically linked and stripped ones. Inlined functions are not resolved.
+type GoSymbolTable struct {
+	pclntab *goPclntab
+	// size is the size of the .gopclntab in bytes.
+	size uint64
+}
+
+// NewGoSymbolTable creates a GoSymbolTable for the Go executable ef.
+func NewGoSymbolTable(ef *pfelf.File) (*GoSymbolTable, error) {
+	data, err := LoadGoPclntab(ef)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("no .gopclntab found")
+	}
+	return newGoSymbolTable(data, func() uintptr { return goTextStart(ef) })
+}
+
+// newGoSymbolTable creates a GoSymbolTable from the .gopclntab data.
+func newGoSymbolTable(data []byte, textStart func() uintptr) (*GoSymbolTable, error) {
+	p, err := newGoPclntab(data, textStart)
+	if err != nil {
+		return nil, err
+	}
+	return &GoSymbolTable{pclntab: p, size: uint64(len(data))}, nil
+}
+
+// Size returns the size of the .gopclntab in bytes. It grows with the number of functions
+// and the amount of code of the executable.
+func (t *GoSymbolTable) Size() uint64 {
+	return t.size
+}
+
+// Lookup returns the source code location of the ELF virtual address addr. The second
+// return value is false if the address is not part of a Go function.
+func (t *GoSymbolTable) Lookup(addr uint64) (GoSourceInfo, bool) {
+	p := t.pclntab
+	numFuncs := p.hdr.numFuncs
+	pc := uintptr(addr)
+	if numFuncs == 0 || pc < p.entryPC(0) || pc >= p.entryPC(numFuncs) {
+		return GoSourceInfo{}, false
+	}
+
+	// Find the last function starting at or before pc.
+	i := sort.Search(int(numFuncs), func(i int) bool {
+		return p.entryPC(uint64(i)) > pc
+	}) - 1
+	fun, err := p.function(uint64(i))
+	if err != nil {
+		return GoSourceInfo{}, false
+	}
+
+	info := GoSourceInfo{
+		FunctionName:  string(getString(p.funcnametab, int(fun.nameOff))),
+		FunctionStart: fun.startPc,
+	}
+	if fileIndex, ok := p.pcvalue(fun.pcfileOff, &fun, pc); ok {
+		info.FileName = string(p.fileName(p.cuIndex(&fun, fileIndex)))
+	}
+	if line, ok := p.pcvalue(fun.pclnOff, &fun, pc); ok {
+		info.Line = int(line)
+	}
+	return info, true
+}
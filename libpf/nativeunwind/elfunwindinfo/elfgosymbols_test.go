@@ -0,0 +1,381 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package elfunwindinfo
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// pclntabTestLine is a range of a pcvalue table ending at end (exclusive).
+type pclntabTestLine struct {
+	end  uint64
+	line int32
+}
+
+// pclntabTestFunc describes a function of a synthetic .gopclntab.
+type pclntabTestFunc struct {
+	name  string
+	start uint64
+	file  string
+	lines []pclntabTestLine
+}
+
+var pclntabTestFuncs = []pclntabTestFunc{
+	{
+		name:  "main.foo",
+		start: 0x1000,
+		file:  "/src/foo.go",
+		lines: []pclntabTestLine{{end: 0x1010, line: 10}, {end: 0x1020, line: 11}},
+	},
+	{
+		name:  "main.bar",
+		start: 0x1020,
+		file:  "/src/bar.go",
+		lines: []pclntabTestLine{{end: 0x1050, line: 20}},
+	},
+}
+
+// pclntabTestEnd is the end address of the last function.
+const pclntabTestEnd = 0x1050
+
+// pclntabBuilder assembles a synthetic .gopclntab.
+type pclntabBuilder struct {
+	data []byte
+}
+
+func (b *pclntabBuilder) u32(v uint32) {
+	b.data = binary.LittleEndian.AppendUint32(b.data, v)
+}
+
+func (b *pclntabBuilder) u64(v uint64) {
+	b.data = binary.LittleEndian.AppendUint64(b.data, v)
+}
+
+func (b *pclntabBuilder) putU32(off int, v uint32) {
+	binary.LittleEndian.PutUint32(b.data[off:], v)
+}
+
+func (b *pclntabBuilder) putU64(off int, v uint64) {
+	binary.LittleEndian.PutUint64(b.data[off:], v)
+}
+
+func (b *pclntabBuilder) str(s string) {
+	b.data = append(append(b.data, s...), 0)
+}
+
+func (b *pclntabBuilder) align(n int) {
+	for len(b.data)%n != 0 {
+		b.data = append(b.data, 0)
+	}
+}
+
+// pcvalue appends a pcvalue table with one value per range starting at start.
+func (b *pclntabBuilder) pcvalue(start uint64, lines []pclntabTestLine) {
+	prev, pc := int32(-1), start
+	for _, l := range lines {
+		delta := l.line - prev
+		var v uint32
+		if delta < 0 {
+			v = uint32(^delta)<<1 | 1
+		} else {
+			v = uint32(delta) << 1
+		}
+		b.data = binary.AppendUvarint(b.data, uint64(v))
+		b.data = binary.AppendUvarint(b.data, l.end-pc)
+		prev, pc = l.line, l.end
+	}
+	b.data = append(b.data, 0)
+}
+
+// buildPclntab12 creates a synthetic .gopclntab in the Go 1.2 format, where all
+// offsets are relative to the start of the data.
+func buildPclntab12() []byte {
+	b := &pclntabBuilder{}
+	b.u32(magicGo1_2)
+	b.data = append(b.data, 0, 0, 1, 8)
+	b.u64(uint64(len(pclntabTestFuncs)))
+
+	functab := len(b.data)
+	for _, fn := range pclntabTestFuncs {
+		b.u64(fn.start)
+		b.u64(0)
+	}
+	b.u64(pclntabTestEnd)
+	filetabOffsetPos := len(b.data)
+	b.u32(0)
+	b.align(8)
+
+	funcOffs := make([]int, len(pclntabTestFuncs))
+	for i := range pclntabTestFuncs {
+		funcOffs[i] = len(b.data)
+		b.putU64(functab+16*i+8, uint64(funcOffs[i]))
+		b.data = append(b.data, make([]byte, 40)...)
+	}
+
+	for i, fn := range pclntabTestFuncs {
+		b.putU64(funcOffs[i], fn.start)
+		b.putU32(funcOffs[i]+8, uint32(len(b.data)))
+		b.str(fn.name)
+		b.putU32(funcOffs[i]+24, uint32(len(b.data)))
+		b.pcvalue(fn.start, []pclntabTestLine{{end: fn.lines[len(fn.lines)-1].end,
+			line: int32(i + 1)}})
+		b.putU32(funcOffs[i]+28, uint32(len(b.data)))
+		b.pcvalue(fn.start, fn.lines)
+	}
+
+	b.align(4)
+	filetab := len(b.data)
+	b.putU32(filetabOffsetPos, uint32(filetab))
+	b.u32(uint32(len(pclntabTestFuncs) + 1))
+	for range pclntabTestFuncs {
+		b.u32(0)
+	}
+	for i, fn := range pclntabTestFuncs {
+		b.putU32(filetab+4*(i+1), uint32(len(b.data)))
+		b.str(fn.file)
+	}
+	return b.data
+}
+
+// buildPclntab116 creates a synthetic .gopclntab in the Go 1.16 or newer format, where
+// the tables are referenced from the header. Function addresses are relative to
+// textStart for Go 1.18+. If headerTextStart is false, the header field is left zero.
+func buildPclntab116(magic uint32, textStart uint64, headerTextStart bool) []byte {
+	go118 := IsGo118orNewer(magic)
+	b := &pclntabBuilder{}
+	b.u32(magic)
+	b.data = append(b.data, 0, 0, 1, 8)
+	b.u64(uint64(len(pclntabTestFuncs)))
+	b.u64(uint64(len(pclntabTestFuncs)))
+	if go118 {
+		if headerTextStart {
+			b.u64(textStart)
+		} else {
+			b.u64(0)
+		}
+	}
+	offsetsPos := len(b.data)
+	b.data = append(b.data, make([]byte, 5*8)...)
+
+	// funcnametab
+	funcnametab := len(b.data)
+	b.putU64(offsetsPos, uint64(funcnametab))
+	nameOffs := make([]int, len(pclntabTestFuncs))
+	for i, fn := range pclntabTestFuncs {
+		nameOffs[i] = len(b.data) - funcnametab
+		b.str(fn.name)
+	}
+
+	// cutab, with an unused first entry to verify the cuOffset handling
+	b.align(4)
+	cutab := len(b.data)
+	b.putU64(offsetsPos+8, uint64(cutab))
+	b.u32(^uint32(0))
+	for range pclntabTestFuncs {
+		b.u32(0)
+	}
+
+	// filetab
+	filetab := len(b.data)
+	b.putU64(offsetsPos+16, uint64(filetab))
+	for i, fn := range pclntabTestFuncs {
+		b.putU32(cutab+4*(i+1), uint32(len(b.data)-filetab))
+		b.str(fn.file)
+	}
+
+	// pctab, offset 0 is invalid
+	pctab := len(b.data)
+	b.putU64(offsetsPos+24, uint64(pctab))
+	b.data = append(b.data, 0)
+	fileOffs := make([]int, len(pclntabTestFuncs))
+	lineOffs := make([]int, len(pclntabTestFuncs))
+	for i, fn := range pclntabTestFuncs {
+		fileOffs[i] = len(b.data) - pctab
+		b.pcvalue(fn.start, []pclntabTestLine{{end: fn.lines[len(fn.lines)-1].end,
+			line: int32(i)}})
+		lineOffs[i] = len(b.data) - pctab
+		b.pcvalue(fn.start, fn.lines)
+	}
+
+	// functab followed by the function descriptors
+	b.align(8)
+	functab := len(b.data)
+	b.putU64(offsetsPos+32, uint64(functab))
+	entrySize, funcSize := 16, 40
+	if go118 {
+		entrySize, funcSize = 8, 36
+	}
+	b.data = append(b.data, make([]byte, len(pclntabTestFuncs)*entrySize+entrySize/2)...)
+	b.align(8)
+	for i, fn := range pclntabTestFuncs {
+		funcOff := len(b.data) - functab
+		entry := functab + i*entrySize
+		b.data = append(b.data, make([]byte, funcSize)...)
+		fields := funcOff + functab
+		if go118 {
+			b.putU32(entry, uint32(fn.start-textStart))
+			b.putU32(entry+4, uint32(funcOff))
+			b.putU32(fields, uint32(fn.start-textStart))
+			fields -= 4
+		} else {
+			b.putU64(entry, fn.start)
+			b.putU64(entry+8, uint64(funcOff))
+			b.putU64(fields, fn.start)
+		}
+		b.putU32(fields+8, uint32(nameOffs[i]))
+		b.putU32(fields+24, uint32(fileOffs[i]))
+		b.putU32(fields+28, uint32(lineOffs[i]))
+		// cuOffset
+		b.putU32(fields+36, 1)
+	}
+	end := functab + len(pclntabTestFuncs)*entrySize
+	if go118 {
+		b.putU32(end, uint32(pclntabTestEnd-textStart))
+	} else {
+		b.putU64(end, pclntabTestEnd)
+	}
+	return b.data
+}
+
+func TestGoSymbolTableFormats(t *testing.T) {
+	const textStart = 0x1000
+
+	tests := map[string][]byte{
+		"Go 1.2":  buildPclntab12(),
+		"Go 1.16": buildPclntab116(magicGo1_16, 0, false),
+		"Go 1.18": buildPclntab116(magicGo1_18, textStart, true),
+		"Go 1.20": buildPclntab116(magicGo1_20, textStart, true),
+		// Recent linkers leave the textStart header field zero.
+		"Go 1.20 without textStart": buildPclntab116(magicGo1_20, textStart, false),
+	}
+
+	for name, data := range tests {
+		name := name
+		data := data
+		t.Run(name, func(t *testing.T) {
+			symbols, err := newGoSymbolTable(data, func() uintptr { return textStart })
+			require.NoError(t, err)
+
+			for _, fn := range pclntabTestFuncs {
+				start := fn.start
+				for _, l := range fn.lines {
+					for _, addr := range []uint64{start, l.end - 1} {
+						info, ok := symbols.Lookup(addr)
+						if assert.True(t, ok, "0x%x", addr) {
+							assert.Equal(t, GoSourceInfo{
+								FunctionName:  fn.name,
+								FunctionStart: fn.start,
+								FileName:      fn.file,
+								Line:          int(l.line),
+							}, info, "0x%x", addr)
+						}
+					}
+					start = l.end
+				}
+			}
+
+			for _, addr := range []uint64{0, textStart - 1, pclntabTestEnd} {
+				_, ok := symbols.Lookup(addr)
+				assert.False(t, ok, "0x%x", addr)
+			}
+		})
+	}
+}
+
+func TestGoSymbolTableInvalid(t *testing.T) {
+	_, err := newGoSymbolTable([]byte{0xf1, 0xff, 0xff}, nil)
+	assert.Error(t, err)
+
+	data := buildPclntab116(magicGo1_20, 0x1000, true)
+	data[0] = 0x42
+	_, err = newGoSymbolTable(data, nil)
+	assert.Error(t, err)
+
+	// functab truncated
+	data = buildPclntab12()
+	binary.LittleEndian.PutUint64(data[8:], 1000)
+	_, err = newGoSymbolTable(data, nil)
+	assert.Error(t, err)
+}
+
+func TestGoSymbolTable(t *testing.T) {
+	tests := map[string]struct {
+		elfFile string
+	}{
+		// helloworld is statically linked, it has no PT_INTERP and no .dynsym.
+		"regular Go binary":       {elfFile: "testdata/helloworld"},
+		"regular ARM64 Go binary": {elfFile: "testdata/helloworld.arm64"},
+		"PIE Go binary":           {elfFile: "testdata/helloworld.pie"},
+		"stripped PIE Go binary":  {elfFile: "testdata/helloworld.stripped.pie"},
+	}
+
+	for name, test := range tests {
+		name := name
+		test := test
+		t.Run(name, func(t *testing.T) {
+			ef, err := pfelf.Open(test.elfFile)
+			require.NoError(t, err)
+			defer ef.Close()
+
+			symbols, err := NewGoSymbolTable(ef)
+			require.NoError(t, err)
+
+			// Compare against the standard library implementation.
+			stdFile, err := elf.Open(test.elfFile)
+			require.NoError(t, err)
+			defer stdFile.Close()
+			data, err := stdFile.Section(".gopclntab").Data()
+			require.NoError(t, err)
+			table, err := gosym.NewTable(nil, gosym.NewLineTable(data,
+				uint64(goTextStart(ef))))
+			require.NoError(t, err)
+			require.NotEmpty(t, table.Funcs)
+
+			for i := range table.Funcs {
+				fn := &table.Funcs[i]
+				if strings.HasPrefix(fn.Name, "go:") {
+					// Skip linker generated markers, like go:textfipsstart.
+					continue
+				}
+				for _, addr := range []uint64{fn.Entry, (fn.Entry + fn.End) / 2, fn.End - 1} {
+					file, line, _ := table.PCToLine(addr)
+					info, ok := symbols.Lookup(addr)
+					if !assert.True(t, ok, "0x%x", addr) {
+						continue
+					}
+					if file == "" {
+						// The alignment padding after a function has no line
+						// information, gosym reports line -1 for it.
+						line = 0
+					}
+					assert.Equal(t, GoSourceInfo{
+						FunctionName:  fn.Name,
+						FunctionStart: fn.Entry,
+						FileName:      file,
+						Line:          line,
+					}, info, "0x%x", addr)
+				}
+			}
+
+			mainFunc := table.LookupFunc("main.main")
+			require.NotNil(t, mainFunc)
+			info, ok := symbols.Lookup(mainFunc.Entry)
+			require.True(t, ok)
+			assert.Equal(t, "main.main", info.FunctionName)
+			assert.Equal(t, 15, info.Line)
+		})
+	}
+}
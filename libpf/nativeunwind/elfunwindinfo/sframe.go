@@ -0,0 +1,324 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package elfunwindinfo
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// The SFrame format is described in:
+// https://sourceware.org/binutils/docs/sframe-spec.html
+const (
+	sframeMagic = 0xdee2
+
+	sframeVersion1 = 1
+	sframeVersion2 = 2
+
+	sframeHeaderSize = 28
+	sframeFDESizeV1  = 17
+	sframeFDESizeV2  = 20
+
+	// Header flags
+	sframeFlagFDESorted      = 0x1
+	sframeFlagFuncStartPCRel = 0x4
+
+	// ABI identifiers
+	sframeABIAArch64LE = 2
+	sframeABIAMD64LE   = 3
+
+	// FDE types encoded in the FDE info
+	sframeFDETypePCMask = 1
+
+	// Bit positions of the fields in the FRE info
+	sframeFREOffsetCountShift = 1
+	sframeFREOffsetSizeShift  = 5
+
+	// FRE start address sizes encoded in the FDE info
+	sframeFREAddr1 = 0
+	sframeFREAddr2 = 1
+	sframeFREAddr4 = 2
+
+	// CFA base registers encoded in the FRE info
+	sframeBaseRegFP = 0
+	sframeBaseRegSP = 1
+)
+
+// sframeHeader contains the fields of the SFrame header needed for parsing.
+type sframeHeader struct {
+	version       uint8
+	flags         uint8
+	abi           uint8
+	fixedFPOffset int8
+	fixedRAOffset int8
+	numFDEs       uint32
+	fdeOff        uint32
+	freOff        uint32
+	// dataOff is the offset of the FDE and FRE subsections from the section start.
+	dataOff uint32
+}
+
+// sframeRange is the address range of a function described by SFrame data.
+type sframeRange struct {
+	start, end uintptr
+}
+
+// sframeReader reads little-endian values from SFrame data. Reads beyond the end of
+// the data return zero and mark the reader as invalid.
+type sframeReader struct {
+	data  []byte
+	pos   uint64
+	valid bool
+}
+
+func (r *sframeReader) bytes(n uint64) []byte {
+	if !r.valid || r.pos+n > uint64(len(r.data)) {
+		r.valid = false
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *sframeReader) u8() uint8 {
+	return r.bytes(1)[0]
+}
+
+func (r *sframeReader) u16() uint16 {
+	return binary.LittleEndian.Uint16(r.bytes(2))
+}
+
+func (r *sframeReader) u32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+// uint reads an unsigned value of the given size in bytes.
+func (r *sframeReader) uint(size int) uint32 {
+	switch size {
+	case 1:
+		return uint32(r.u8())
+	case 2:
+		return uint32(r.u16())
+	default:
+		return r.u32()
+	}
+}
+
+// int reads a signed value of the given size in bytes.
+func (r *sframeReader) int(size int) int32 {
+	switch size {
+	case 1:
+		return int32(int8(r.u8()))
+	case 2:
+		return int32(int16(r.u16()))
+	default:
+		return int32(r.u32())
+	}
+}
+
+// parseSFrameHeader parses the SFrame header and checks that it is supported for ef.
+// A nil header is returned for SFrame versions that are not supported.
+func parseSFrameHeader(r *sframeReader, machine elf.Machine) (*sframeHeader, error) {
+	if r.u16() != sframeMagic {
+		return nil, errors.New("invalid SFrame magic")
+	}
+	hdr := &sframeHeader{
+		version:       r.u8(),
+		flags:         r.u8(),
+		abi:           r.u8(),
+		fixedFPOffset: int8(r.u8()),
+		fixedRAOffset: int8(r.u8()),
+	}
+	auxLen := r.u8()
+	hdr.numFDEs = r.u32()
+	_ = r.u32() // number of FREs
+	_ = r.u32() // length of the FRE subsection
+	hdr.fdeOff = r.u32()
+	hdr.freOff = r.u32()
+	hdr.dataOff = sframeHeaderSize + uint32(auxLen)
+	if !r.valid {
+		return nil, errors.New("truncated SFrame header")
+	}
+
+	if hdr.version != sframeVersion1 && hdr.version != sframeVersion2 {
+		return nil, nil
+	}
+	switch {
+	case hdr.abi == sframeABIAMD64LE && machine == elf.EM_X86_64:
+	case hdr.abi == sframeABIAArch64LE && machine == elf.EM_AARCH64:
+	default:
+		return nil, fmt.Errorf("SFrame ABI %d does not match ELF machine %s", hdr.abi, machine)
+	}
+	return hdr, nil
+}
+
+// sframeRegs converts the rules of an SFrame frame row entry to vmRegs. Registers not
+// described by the frame row entry keep the initial state of the architecture.
+func sframeRegs(hdr *sframeHeader, machine elf.Machine, baseReg uint8,
+	offsets []int32) vmRegs {
+	regs := newVMRegs(machine)
+	if len(offsets) == 0 {
+		// Without CFA offset the return address is undefined, e.g. for the
+		// outermost frame.
+		return regs
+	}
+
+	regs.cfa.off = sleb128(offsets[0])
+	switch machine {
+	case elf.EM_X86_64:
+		regs.cfa.reg = x86RegRSP
+		if baseReg == sframeBaseRegFP {
+			regs.cfa.reg = x86RegRBP
+		}
+		// The return address is always at a fixed offset from the CFA on x86-64.
+		regs.ra = vmReg{arch: machine, reg: regCFA, off: sleb128(hdr.fixedRAOffset)}
+		offsets = offsets[1:]
+	case elf.EM_AARCH64:
+		regs.cfa.reg = armRegSP
+		if baseReg == sframeBaseRegFP {
+			regs.cfa.reg = armRegFP
+		}
+		offsets = offsets[1:]
+		if len(offsets) > 0 {
+			regs.ra = vmReg{arch: machine, reg: regCFA, off: sleb128(offsets[0])}
+			offsets = offsets[1:]
+		}
+	}
+
+	switch {
+	case len(offsets) > 0:
+		regs.fp = vmReg{arch: machine, reg: regCFA, off: sleb128(offsets[0])}
+	case hdr.fixedFPOffset != 0:
+		regs.fp = vmReg{arch: machine, reg: regCFA, off: sleb128(hdr.fixedFPOffset)}
+	}
+	return regs
+}
+
+// parseSFrame parses the .sframe section, extracting stack deltas. The address ranges of
+// the functions covered are recorded in filter, so that other sources of stack deltas
+// for the same functions can be skipped.
+func parseSFrame(ef *pfelf.File, deltas *sdtypes.StackDeltaArray,
+	filter *extractionFilter) error {
+	sframeSec, err := elfRegionFromSection(ef.Section(".sframe"))
+	if sframeSec == nil {
+		return err
+	}
+
+	r := &sframeReader{data: sframeSec.data, valid: true}
+	hdr, err := parseSFrameHeader(r, ef.Machine)
+	if err != nil {
+		return err
+	}
+	if hdr == nil {
+		log.Debugf("Ignoring SFrame version %d", sframeSec.data[2])
+		return nil
+	}
+
+	sorted := hdr.flags&sframeFlagFDESorted != 0
+	fdeSize := uint64(sframeFDESizeV1)
+	if hdr.version == sframeVersion2 {
+		fdeSize = sframeFDESizeV2
+	}
+	freBase := uint64(hdr.dataOff) + uint64(hdr.freOff)
+
+	for i := uint64(0); i < uint64(hdr.numFDEs); i++ {
+		r.pos = uint64(hdr.dataOff) + uint64(hdr.fdeOff) + i*fdeSize
+		fdePos := r.pos
+		funcStart := uintptr(int64(sframeSec.vaddr) + int64(int32(r.u32())))
+		if hdr.flags&sframeFlagFuncStartPCRel != 0 {
+			funcStart += uintptr(fdePos)
+		}
+		funcSize := r.u32()
+		freOff := r.u32()
+		numFREs := r.u32()
+		info := r.u8()
+		if !r.valid {
+			return fmt.Errorf("SFrame FDE %d is truncated", i)
+		}
+
+		// FDEs with PC mask describe repetitive code blocks like PLT stubs. These
+		// are left to .eh_frame, which describes them with expressions.
+		if (info>>4)&1 == sframeFDETypePCMask {
+			continue
+		}
+		// Skip functions superseded by .gopclntab data.
+		if funcStart >= filter.start && funcStart <= filter.end {
+			continue
+		}
+
+		var addrSize int
+		switch info & 0xf {
+		case sframeFREAddr1:
+			addrSize = 1
+		case sframeFREAddr2:
+			addrSize = 2
+		case sframeFREAddr4:
+			addrSize = 4
+		default:
+			return fmt.Errorf("SFrame FDE %d has invalid FRE type %d", i, info&0xf)
+		}
+
+		r.pos = freBase + uint64(freOff)
+		hint := sdtypes.UnwindHintKeep
+		for j := uint32(0); j < numFREs; j++ {
+			freStart := r.uint(addrSize)
+			freInfo := r.u8()
+			count := int((freInfo >> sframeFREOffsetCountShift) & 0xf)
+			offsetSize := 1 << ((freInfo >> sframeFREOffsetSizeShift) & 0x3)
+			offsets := make([]int32, count)
+			for k := range offsets {
+				offsets[k] = r.int(offsetSize)
+			}
+			if !r.valid {
+				return fmt.Errorf("SFrame FRE %d of FDE %d is truncated", j, i)
+			}
+
+			regs := sframeRegs(hdr, ef.Machine, freInfo&1, offsets)
+			deltas.AddEx(sdtypes.StackDelta{
+				Address: uint64(funcStart) + uint64(freStart),
+				Hints:   hint,
+				Info:    regs.getUnwindInfo(),
+			}, sorted)
+			hint = sdtypes.UnwindHintNone
+		}
+
+		funcEnd := funcStart + uintptr(funcSize)
+		endInfo := sdtypes.UnwindInfoInvalid
+		if ef.Entry == uint64(funcEnd) {
+			endInfo = sdtypes.UnwindInfoStop
+		}
+		// Add end-of-function stop delta. This might later get removed if there is
+		// another function starting on this address.
+		deltas.AddEx(sdtypes.StackDelta{
+			Address: uint64(funcEnd),
+			Hints:   sdtypes.UnwindHintGap,
+			Info:    endInfo,
+		}, sorted)
+
+		filter.sframeRanges = append(filter.sframeRanges, sframeRange{funcStart, funcEnd})
+	}
+
+	if len(filter.sframeRanges) > 0 {
+		filter.sframeFrames = true
+		if !sorted {
+			filter.unsortedFrames = true
+			sort.Slice(filter.sframeRanges, func(i, j int) bool {
+				return filter.sframeRanges[i].start < filter.sframeRanges[j].start
+			})
+		}
+	}
+	return nil
+}
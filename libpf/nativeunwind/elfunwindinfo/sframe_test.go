@@ -0,0 +1,111 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package elfunwindinfo
+
+import (
+	"debug/elf"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// testdata/sframe has both .sframe and .eh_frame sections, which describe the same
+// unwinding rules in different formats.
+func TestParseSFrame(t *testing.T) {
+	ef, err := pfelf.Open("testdata/sframe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+
+	sframeDeltas := sdtypes.StackDeltaArray{}
+	filter := &extractionFilter{}
+	if err = parseSFrame(ef, &sframeDeltas, filter); err != nil {
+		t.Fatal(err)
+	}
+	if !filter.sframeFrames || len(filter.sframeRanges) == 0 {
+		t.Fatal("Failed to extract SFrame stack deltas")
+	}
+
+	// Within the functions described by .sframe, the unwinding rules must match the
+	// ones from .eh_frame.
+	ehFrameDeltas := sdtypes.StackDeltaArray{}
+	if err = parseEHFrame(ef, &ehFrameDeltas, &extractionFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, delta := range sframeDeltas {
+		if delta.Hints&sdtypes.UnwindHintGap != 0 {
+			continue
+		}
+		idx := sort.Search(len(ehFrameDeltas), func(i int) bool {
+			return ehFrameDeltas[i].Address > delta.Address
+		}) - 1
+		if idx < 0 || ehFrameDeltas[idx].Info != delta.Info {
+			t.Errorf("Delta at %#x does not match .eh_frame", delta.Address)
+		}
+	}
+
+	// Functions without .sframe data, e.g. PLT stubs, are still taken from .eh_frame.
+	var data sdtypes.IntervalData
+	if err = Extract("testdata/sframe", &data); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(ehFrameDeltas, data.Deltas); diff != "" {
+		t.Errorf("Deltas are wrong: %s", diff)
+	}
+}
+
+func TestSFrameHeader(t *testing.T) {
+	header := []byte{
+		0xe2, 0xde, // magic
+		sframeVersion2, sframeFlagFDESorted, sframeABIAMD64LE,
+		0, 0xf8, // fixed FP and RA offsets
+		4,          // auxiliary header length
+		1, 0, 0, 0, // number of FDEs
+		2, 0, 0, 0, // number of FREs
+		8, 0, 0, 0, // length of FREs
+		0, 0, 0, 0, // FDE offset
+		20, 0, 0, 0, // FRE offset
+	}
+
+	hdr, err := parseSFrameHeader(&sframeReader{data: header, valid: true}, elf.EM_X86_64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &sframeHeader{
+		version:       sframeVersion2,
+		flags:         sframeFlagFDESorted,
+		abi:           sframeABIAMD64LE,
+		fixedRAOffset: -8,
+		numFDEs:       1,
+		freOff:        20,
+		dataOff:       sframeHeaderSize + 4,
+	}
+	if diff := cmp.Diff(expected, hdr, cmp.AllowUnexported(sframeHeader{})); diff != "" {
+		t.Errorf("Header is wrong: %s", diff)
+	}
+
+	if _, err = parseSFrameHeader(&sframeReader{data: header, valid: true},
+		elf.EM_AARCH64); err == nil {
+		t.Error("Expected an error for mismatching ABI")
+	}
+	if _, err = parseSFrameHeader(&sframeReader{data: header[:20], valid: true},
+		elf.EM_X86_64); err == nil {
+		t.Error("Expected an error for truncated header")
+	}
+
+	// Unknown versions are ignored.
+	header[2] = 42
+	hdr, err = parseSFrameHeader(&sframeReader{data: header, valid: true}, elf.EM_X86_64)
+	if hdr != nil || err != nil {
+		t.Errorf("Unexpected result for unknown version: %v, %v", hdr, err)
+	}
+}
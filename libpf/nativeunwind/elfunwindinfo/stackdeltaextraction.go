@@ -7,9 +7,12 @@
 package elfunwindinfo
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 
+	log "github.com/sirupsen/logrus"
+
 	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
 	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 )
@@ -17,7 +20,7 @@ import (
 const (
 	// Some DSOs have few limited .eh_frame FDEs (e.g. PLT), and additional
 	// FDEs are in .debug_frame or external debug file. This controls how many
-	// intervals are needed to not follow .gnu_debuglink.
+	// intervals are needed to not look up the external debug file.
 	numIntervalsToOmitDebugLink = 20
 )
 
@@ -34,6 +37,13 @@ type extractionFilter struct {
 	// golangFrames is true if .gopclntab stack deltas are found
 	golangFrames bool
 
+	// sframeFrames is true if .sframe stack deltas are found
+	sframeFrames bool
+
+	// sframeRanges holds the sorted address ranges of the functions for which
+	// .sframe stack deltas are found
+	sframeRanges []sframeRange
+
 	// unsortedFrames is set if stack deltas from unsorted source are found
 	unsortedFrames bool
 }
@@ -49,6 +59,15 @@ func (f *extractionFilter) fdeHook(_ *cieInfo, fde *fdeInfo) bool {
 		}
 		f.unsortedFrames = true
 	}
+	// Skip functions for which .sframe data is preferred
+	if f.sframeFrames {
+		if f.coveredBySFrame(fde.ipStart, fde.ipStart+fde.ipLen) {
+			return false
+		}
+		// The .sframe stack deltas are already added, so the remaining ones
+		// need to be sorted in.
+		fde.sorted = false
+	}
 	// Parse functions outside the gopclntab area
 	if fde.ipStart < f.start || fde.ipStart > f.end {
 		// This is here to set the flag only when we have collected at least
@@ -59,6 +78,15 @@ func (f *extractionFilter) fdeHook(_ *cieInfo, fde *fdeInfo) bool {
 	return false
 }
 
+// coveredBySFrame reports whether .sframe stack deltas exist for all of [start, end).
+func (f *extractionFilter) coveredBySFrame(start, end uintptr) bool {
+	idx := sort.Search(len(f.sframeRanges), func(i int) bool {
+		return f.sframeRanges[i].end > start
+	})
+	return idx < len(f.sframeRanges) && f.sframeRanges[idx].start <= start &&
+		f.sframeRanges[idx].end >= end
+}
+
 // deltaHook is a stub to satisfy ehframeHooks interface
 func (f *extractionFilter) deltaHook(uintptr, *vmRegs, sdtypes.StackDelta) {
 }
@@ -74,6 +102,11 @@ func extractDebugDeltas(elfFile *pfelf.File, elfRef *pfelf.Reference,
 	if debugELF != nil {
 		err = parseDebugFrame(debugELF, elfFile, deltas, filter)
 		debugELF.Close()
+		// Debug files can be huge. Skip them instead of failing the whole extraction.
+		if errors.Is(err, pfelf.ErrSizeLimitExceeded) {
+			log.Debugf("Skipping debug file for %s: %v", elfRef.FileName(), err)
+			err = nil
+		}
 	}
 	return err
 }
@@ -99,28 +132,40 @@ func ExtractELF(elfRef *pfelf.Reference, interval *sdtypes.IntervalData) error {
 	filter := &extractionFilter{}
 
 	if err = parseGoPclntab(elfFile, &deltas, filter); err != nil {
-		return fmt.Errorf("failure to parse golang stack deltas: %v", err)
+		return fmt.Errorf("failure to parse golang stack deltas: %w", err)
+	}
+	// SFrame data is cheaper to evaluate than DWARF CFI, so use it where present.
+	if err = parseSFrame(elfFile, &deltas, filter); err != nil {
+		return fmt.Errorf("failure to parse sframe stack deltas: %w", err)
 	}
 	if err = parseEHFrame(elfFile, &deltas, filter); err != nil {
-		return fmt.Errorf("failure to parse eh_frame stack deltas: %v", err)
+		return fmt.Errorf("failure to parse eh_frame stack deltas: %w", err)
 	}
 	if err = parseDebugFrame(elfFile, elfFile, &deltas, filter); err != nil {
-		return fmt.Errorf("failure to parse debug_frame stack deltas: %v", err)
+		return fmt.Errorf("failure to parse debug_frame stack deltas: %w", err)
 	}
 	if len(deltas) < numIntervalsToOmitDebugLink {
-		// There is only few stack deltas. See if we find the .gnu_debuglink
-		// debug information for additional .debug_frame stack deltas.
+		// There is only few stack deltas. See if we find the separate debug
+		// file, by build ID or .gnu_debuglink, for additional .debug_frame stack deltas.
 		if err = extractDebugDeltas(elfFile, elfRef, &deltas, filter); err != nil {
 			return fmt.Errorf("failure to parse debug stack deltas: %v", err)
 		}
 	}
 
 	// If multiple sources were merged, sort them.
-	if filter.unsortedFrames || (filter.ehFrames && filter.golangFrames) {
+	if filter.unsortedFrames || (filter.ehFrames && filter.golangFrames) ||
+		(filter.sframeFrames && (filter.ehFrames || filter.golangFrames)) {
 		sort.Slice(deltas, func(i, j int) bool {
 			if deltas[i].Address != deltas[j].Address {
 				return deltas[i].Address < deltas[j].Address
 			}
+			// Make sure that an end-of-function marker is sorted before the
+			// delta of a function starting at the same address, so it gets
+			// overwritten.
+			gapI := deltas[i].Hints&sdtypes.UnwindHintGap != 0
+			if gapJ := deltas[j].Hints&sdtypes.UnwindHintGap != 0; gapI != gapJ {
+				return gapI
+			}
 			// Make sure that the potential duplicate stop delta is sorted
 			// after the real delta.
 			return deltas[i].Info.Opcode < deltas[j].Info.Opcode
@@ -0,0 +1,31 @@
+#include <stdio.h>
+#include <stdlib.h>
+
+// Functions with varying stack usage so that the SFrame table has several
+// frame row entries per function.
+__attribute__((noinline)) static long leaf(long n)
+{
+	return n * 3 + 1;
+}
+
+__attribute__((noinline)) static long with_locals(long n)
+{
+	volatile long buf[64];
+	for (long i = 0; i < 64; i++)
+		buf[i] = leaf(n + i);
+	return buf[n % 64];
+}
+
+__attribute__((noinline)) static long with_alloca(long n)
+{
+	volatile char *p = __builtin_alloca(n + 16);
+	p[0] = (char)n;
+	return with_locals(p[0]);
+}
+
+int main(int argc, char **argv)
+{
+	(void)argv;
+	printf("%ld\n", with_alloca(argc));
+	return 0;
+}
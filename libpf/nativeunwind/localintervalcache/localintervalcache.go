@@ -30,11 +30,16 @@ import (
 	"github.com/elastic/otel-profiling-agent/host"
 	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind"
 	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 )
 
 // cacheElementExtension defines the file extension used for elements in the cache.
 const cacheElementExtension = "gz"
 
+// fileIDAlgorithmFile is the name of the file in the cache directory that records the
+// pfelf.FileIDAlgorithm of the FileIDs the elements are named by.
+const fileIDAlgorithmFile = "fileid_algorithm"
+
 // errElementTooLarge indicates that the element is larger than the max cache size.
 var errElementTooLarge = errors.New("element too large for cache")
 
@@ -123,6 +128,11 @@ func New(maxSize uint64) (*Cache, error) {
 		return nil, err
 	}
 
+	// Delete cache entries named by FileIDs of another algorithm.
+	if err := checkFileIDAlgorithm(cacheDir); err != nil {
+		return nil, err
+	}
+
 	var elements []elementData
 
 	// Elements in the localintervalcache are persistent on the file system. So we add the already
@@ -132,7 +142,7 @@ func New(maxSize uint64) (*Cache, error) {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
+		if !info.IsDir() && info.Name() != fileIDAlgorithmFile {
 			entry, errInfo := info.Info()
 			if errInfo != nil {
 				log.Debugf("Did not get file info from '%s': %v", path, errInfo)
@@ -396,6 +406,41 @@ func deleteObsoletedABICaches(cacheDir string) error {
 	return nil
 }
 
+// checkFileIDAlgorithm ensures that the elements in cacheDir are named by FileIDs of the
+// current pfelf.FileIDAlgorithm. With another algorithm, the FileIDs of the elements would
+// refer to different executables, so that the elements are deleted instead. Caches that do
+// not record the algorithm predate its versioning, and use the first version.
+func checkFileIDAlgorithm(cacheDir string) error {
+	algorithmPath := path.Join(cacheDir, fileIDAlgorithmFile)
+	algorithm, err := os.ReadFile(algorithmPath)
+	if err == nil && string(algorithm) == pfelf.FileIDAlgorithm {
+		return nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read FileID algorithm of interval cache: %v", err)
+	}
+
+	if err == nil {
+		log.Infof("Deleting interval cache of FileID algorithm %s", algorithm)
+		elements, errDir := os.ReadDir(cacheDir)
+		if errDir != nil {
+			return fmt.Errorf("failed to read interval cache directory: %v", errDir)
+		}
+		for _, e := range elements {
+			if err = os.RemoveAll(path.Join(cacheDir, e.Name())); err != nil {
+				return fmt.Errorf("failed to delete %s: %v", e.Name(), err)
+			}
+		}
+	}
+
+	// The algorithm is recorded only after deleting the elements, so that an interrupted
+	// deletion is resumed with the next start.
+	if err = os.WriteFile(algorithmPath, []byte(pfelf.FileIDAlgorithm), 0o644); err != nil {
+		return fmt.Errorf("failed to write FileID algorithm of interval cache: %v", err)
+	}
+	return nil
+}
+
 // evictEntries deletes elements from the cache. It will delete elements with the oldest modTime
 // information until the sum of deleted bytes is at toBeDeletedBytes.
 // The caller is responsible to hold the lock on the cache to avoid race conditions.
@@ -23,6 +23,7 @@ import (
 	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/host"
 	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 )
 
 // preTestSetup defines a type for a setup function that can be run prior to a particular test
@@ -218,6 +219,60 @@ func TestDeleteObsoletedABICaches(t *testing.T) {
 	t.Fatalf("Expected obsolete cache directory to no longer exist but got %v", err)
 }
 
+func TestFileIDAlgorithm(t *testing.T) {
+	tests := map[string]struct {
+		// algorithm is the FileID algorithm recorded in the cache, if not empty.
+		algorithm string
+		// expectedSize is the size of the cache after its creation.
+		expectedSize uint64
+	}{
+		"current algorithm": {algorithm: pfelf.FileIDAlgorithm, expectedSize: 10 * 10},
+		"no algorithm":      {expectedSize: 10 * 10},
+		"other algorithm":   {algorithm: "sha1/0", expectedSize: 0},
+	}
+
+	for name, tc := range tests {
+		name := name
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			testTopLevel := setupDirAndConf(t, "*_TestFileIDAlgorithm")
+			defer os.RemoveAll(testTopLevel)
+			cacheDir := path.Join(testTopLevel, cacheDirPathSuffix())
+			if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+				t.Fatalf("Failed to create directory (%s): %s", cacheDir, err)
+			}
+			populateCache(t, cacheDir, 10)
+			algorithmPath := path.Join(cacheDir, fileIDAlgorithmFile)
+			if tc.algorithm != "" {
+				if err := os.WriteFile(algorithmPath, []byte(tc.algorithm), 0o644); err != nil {
+					t.Fatalf("Failed to write '%s': %v", algorithmPath, err)
+				}
+			}
+
+			cache, err := New(100 * 10)
+			if err != nil {
+				t.Fatalf("failed to create cache for test: %v", err)
+			}
+			size, err := cache.GetCurrentCacheSize()
+			if err != nil {
+				t.Fatalf("Failed to get current size: %v", err)
+			}
+			assert.Equal(t, tc.expectedSize, size)
+
+			files, err := os.ReadDir(cacheDir)
+			if err != nil {
+				t.Fatalf("Failed to read cache directory: %v", err)
+			}
+			assert.Len(t, files, int(tc.expectedSize/10)+1)
+			algorithm, err := os.ReadFile(algorithmPath)
+			if err != nil {
+				t.Fatalf("Failed to read '%s': %v", algorithmPath, err)
+			}
+			assert.Equal(t, pfelf.FileIDAlgorithm, string(algorithm))
+		})
+	}
+}
+
 // TestEvictionFullCache tests with a cache that exceeds the maximum size that a newly
 // added element is added to the tail of the LRU and after this element got accessed it
 // is moved to the front of the LRU.
@@ -425,7 +480,7 @@ func TestCacheHasIntervals(t *testing.T) {
 				if err != nil {
 					t.Fatalf("failed to create interval cache: %s", err)
 				}
-				if err = os.Remove(icWithBrokenCache.cacheDir); err != nil {
+				if err = os.RemoveAll(icWithBrokenCache.cacheDir); err != nil {
 					t.Fatalf("Failed to remove %s: %s", icWithBrokenCache.cacheDir, err)
 				}
 			}}}
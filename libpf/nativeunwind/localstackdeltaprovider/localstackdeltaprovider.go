@@ -61,7 +61,7 @@ func (provider *LocalStackDeltaProvider) GetIntervalStructuresForFile(fileID hos
 	err := elfunwindinfo.ExtractELF(elfRef, interval)
 	if err != nil {
 		provider.extractionErrorCount.Add(1)
-		return fmt.Errorf("failed to extract stack deltas from %s: %v",
+		return fmt.Errorf("failed to extract stack deltas from %s: %w",
 			elfRef.FileName(), err)
 	}
 
@@ -0,0 +1,164 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Package precomputed provides access to stack deltas that were extracted ahead of time,
+// e.g. for hosts on which the extraction can not run.
+//
+// The stack deltas of an executable are stored in a directory in a file named after the
+// GNU build ID of the executable with the suffix FileSuffix. The file holds the gzip
+// compressed gob encoding of a File, which records the build ID and the stack delta ABI
+// version used when generating the file.
+package precomputed
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind"
+	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// FileSuffix is the suffix of the files holding precomputed stack deltas.
+const FileSuffix = ".sdelta"
+
+// File is the on-disk representation of the precomputed stack deltas of an executable.
+type File struct {
+	// ABI is the stack delta ABI version (stackdeltatypes.ABI) of the generator.
+	ABI int
+	// BuildID is the GNU build ID of the executable the stack deltas belong to.
+	BuildID string
+	// Intervals holds the stack deltas.
+	Intervals sdtypes.IntervalData
+}
+
+// Path returns the path of the file holding the stack deltas for the executable with the
+// given build ID in dir.
+func Path(dir, buildID string) string {
+	return filepath.Join(dir, buildID+FileSuffix)
+}
+
+// Write stores the stack deltas of the executable with the given build ID in dir.
+func Write(dir, buildID string, interval *sdtypes.IntervalData) error {
+	if buildID == "" {
+		return errors.New("missing build ID")
+	}
+	out, err := os.Create(Path(dir, buildID))
+	if err != nil {
+		return fmt.Errorf("failed to create stack delta file: %v", err)
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	if err = gob.NewEncoder(zw).Encode(&File{
+		ABI:       sdtypes.ABI,
+		BuildID:   buildID,
+		Intervals: *interval,
+	}); err != nil {
+		return fmt.Errorf("failed to encode stack deltas: %v", err)
+	}
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress stack deltas: %v", err)
+	}
+	return out.Close()
+}
+
+// Read loads the stack deltas of the executable with the given build ID from dir into
+// interval. It returns an error wrapping os.ErrNotExist if no stack deltas are available.
+func Read(dir, buildID string, interval *sdtypes.IntervalData) error {
+	in, err := os.Open(Path(dir, buildID))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %v", in.Name(), err)
+	}
+	var data File
+	if err = gob.NewDecoder(zr).Decode(&data); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", in.Name(), err)
+	}
+	if data.ABI != sdtypes.ABI {
+		return fmt.Errorf("%s has stack delta ABI %d, expected %d",
+			in.Name(), data.ABI, sdtypes.ABI)
+	}
+	if data.BuildID != buildID {
+		return fmt.Errorf("%s belongs to build ID %s", in.Name(), data.BuildID)
+	}
+	*interval = data.Intervals
+	return nil
+}
+
+// Provider is a StackDeltaProvider that returns precomputed stack deltas if available,
+// and otherwise delegates to another StackDeltaProvider.
+type Provider struct {
+	// Metrics
+	hitCount   atomic.Uint64
+	errorCount atomic.Uint64
+
+	// dir is the directory holding the precomputed stack deltas.
+	dir string
+	// next provides the stack deltas of executables without precomputed stack deltas.
+	next nativeunwind.StackDeltaProvider
+}
+
+// Compile time check that the Provider implements its interface correctly.
+var _ nativeunwind.StackDeltaProvider = (*Provider)(nil)
+
+// NewProvider returns a Provider reading the precomputed stack deltas from dir, and
+// falling back to next for executables without them.
+func NewProvider(dir string, next nativeunwind.StackDeltaProvider) (*Provider, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access precomputed stack deltas: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("precomputed stack deltas: %s is not a directory", dir)
+	}
+	return &Provider{dir: dir, next: next}, nil
+}
+
+// GetIntervalStructuresForFile implements the StackDeltaProvider interface.
+func (provider *Provider) GetIntervalStructuresForFile(fileID host.FileID,
+	elfRef *pfelf.Reference, interval *sdtypes.IntervalData) error {
+	ef, err := elfRef.GetELF()
+	if err != nil {
+		return provider.next.GetIntervalStructuresForFile(fileID, elfRef, interval)
+	}
+	buildID, err := ef.GetBuildID()
+	if err != nil || buildID == "" {
+		return provider.next.GetIntervalStructuresForFile(fileID, elfRef, interval)
+	}
+
+	if err = Read(provider.dir, buildID, interval); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			provider.errorCount.Add(1)
+			log.Warnf("Ignoring precomputed stack deltas for %s: %v", elfRef.FileName(), err)
+		}
+		return provider.next.GetIntervalStructuresForFile(fileID, elfRef, interval)
+	}
+	provider.hitCount.Add(1)
+	return nil
+}
+
+// GetAndResetStatistics implements the StackDeltaProvider interface. Executables served
+// from precomputed stack deltas are counted as hits, invalid files as extraction errors.
+func (provider *Provider) GetAndResetStatistics() nativeunwind.Statistics {
+	stats := provider.next.GetAndResetStatistics()
+	stats.Hit += provider.hitCount.Swap(0)
+	stats.ExtractionErrors += provider.errorCount.Swap(0)
+	return stats
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package precomputed
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind"
+	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+const testExecutable = "../elfunwindinfo/testdata/helloworld"
+
+var testIntervals = sdtypes.IntervalData{
+	Deltas: sdtypes.StackDeltaArray{
+		{Address: 0x1000, Info: sdtypes.UnwindInfoFramePointer},
+		{Address: 0x1100, Info: sdtypes.UnwindInfoInvalid},
+	},
+}
+
+// fakeProvider is a StackDeltaProvider that counts its invocations.
+type fakeProvider struct {
+	calls int
+}
+
+func (f *fakeProvider) GetIntervalStructuresForFile(host.FileID, *pfelf.Reference,
+	*sdtypes.IntervalData) error {
+	f.calls++
+	return nil
+}
+
+func (f *fakeProvider) GetAndResetStatistics() nativeunwind.Statistics {
+	return nativeunwind.Statistics{Miss: 1}
+}
+
+// writeFile writes data as precomputed stack delta file for buildID to dir.
+func writeFile(t *testing.T, dir, buildID string, data *File) {
+	out, err := os.Create(Path(dir, buildID))
+	require.NoError(t, err)
+	defer out.Close()
+	zw := gzip.NewWriter(out)
+	require.NoError(t, gob.NewEncoder(zw).Encode(data))
+	require.NoError(t, zw.Close())
+}
+
+func TestReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, Write(dir, "abcd", &testIntervals))
+
+	var interval sdtypes.IntervalData
+	require.NoError(t, Read(dir, "abcd", &interval))
+	assert.Equal(t, testIntervals, interval)
+
+	err := Read(dir, "0123", &interval)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	assert.Error(t, Write(dir, "", &testIntervals))
+}
+
+func TestReadValidation(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "abcd", &File{ABI: sdtypes.ABI - 1, BuildID: "abcd"})
+	writeFile(t, dir, "0123", &File{ABI: sdtypes.ABI, BuildID: "4567"})
+
+	var interval sdtypes.IntervalData
+	assert.ErrorContains(t, Read(dir, "abcd", &interval), "ABI")
+	assert.ErrorContains(t, Read(dir, "0123", &interval), "belongs to build ID 4567")
+}
+
+func TestProvider(t *testing.T) {
+	ef, err := pfelf.Open(testExecutable)
+	require.NoError(t, err)
+	buildID, err := ef.GetBuildID()
+	ef.Close()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	next := &fakeProvider{}
+	provider, err := NewProvider(dir, next)
+	require.NoError(t, err)
+
+	// Without precomputed stack deltas, the next provider is used.
+	var interval sdtypes.IntervalData
+	elfRef := pfelf.NewReference(testExecutable, pfelf.SystemOpener)
+	defer elfRef.Close()
+	require.NoError(t, provider.GetIntervalStructuresForFile(1, elfRef, &interval))
+	assert.Equal(t, 1, next.calls)
+
+	require.NoError(t, Write(dir, buildID, &testIntervals))
+	require.NoError(t, provider.GetIntervalStructuresForFile(1, elfRef, &interval))
+	assert.Equal(t, 1, next.calls)
+	assert.Equal(t, testIntervals, interval)
+
+	assert.Equal(t, nativeunwind.Statistics{Hit: 1, Miss: 1}, provider.GetAndResetStatistics())
+
+	_, err = NewProvider(Path(dir, buildID), next)
+	assert.Error(t, err)
+}
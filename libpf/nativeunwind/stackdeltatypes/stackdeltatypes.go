@@ -28,6 +28,7 @@ const (
 	UnwindOpcodeBaseSP    uint8 = C.UNWIND_OPCODE_BASE_SP
 	UnwindOpcodeBaseFP    uint8 = C.UNWIND_OPCODE_BASE_FP
 	UnwindOpcodeBaseLR    uint8 = C.UNWIND_OPCODE_BASE_LR
+	UnwindOpcodeBaseRBX   uint8 = C.UNWIND_OPCODE_BASE_RBX
 	UnwindOpcodeFlagDeref uint8 = C.UNWIND_OPCODEF_DEREF
 
 	// UnwindCommands from the C header file
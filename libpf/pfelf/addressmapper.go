@@ -55,6 +55,51 @@ func (am *AddressMapper) FileOffsetToVirtualAddress(fileOffset uint64) (uint64,
 	return 0, false
 }
 
+// LoadBias returns the load bias of an ELF file with an executable mapping of fileOffset at
+// the virtual address vaddr. The load bias is the difference between the addresses the
+// file is mapped at and its ELF virtual addresses. It is zero for executables linked to a
+// fixed address, and the random base address chosen at run time for shared libraries and
+// position independent executables.
+func (am *AddressMapper) LoadBias(vaddr, fileOffset uint64) (uint64, bool) {
+	elfSpaceVA, ok := am.FileOffsetToVirtualAddress(fileOffset)
+	if !ok {
+		return 0, false
+	}
+	return vaddr - elfSpaceVA, true
+}
+
+// VirtualAddressToFileOffset converts an ELF virtual address in an executable segment to
+// the offset in the file it is loaded from. Unlike virtual addresses, which depend on the
+// link time layout, file offsets identify code by build ID and position in the file only.
+// The virtual address must not include the load bias, if any.
+func (am *AddressMapper) VirtualAddressToFileOffset(virtualAddress uint64) (uint64, bool) {
+	segment, ok := am.Segment(virtualAddress)
+	if !ok {
+		return 0, false
+	}
+	return virtualAddress - segment.Vaddr + segment.Offset, true
+}
+
+// Segment is an executable segment, within which file offsets and virtual addresses
+// differ by the same amount.
+type Segment struct {
+	// Vaddr and Offset are the ELF virtual address and file offset of the segment start.
+	Vaddr  uint64
+	Offset uint64
+	// Size is the size of the segment in the file.
+	Size uint64
+}
+
+// Segment returns the executable segment containing the ELF virtual address.
+func (am *AddressMapper) Segment(virtualAddress uint64) (Segment, bool) {
+	for _, p := range am.phdrs {
+		if virtualAddress >= p.vaddr && virtualAddress < p.vaddr+p.filesz {
+			return Segment{Vaddr: p.vaddr, Offset: p.offset, Size: p.filesz}, true
+		}
+	}
+	return Segment{}, false
+}
+
 // NewAddressMapper returns an address mapper for given ELF File
 func (f *File) GetAddressMapper() AddressMapper {
 	phdrs := make([]addressMapperPHDR, 0, 1)
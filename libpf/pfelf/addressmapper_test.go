@@ -7,11 +7,13 @@
 package pfelf
 
 import (
+	"debug/elf"
 	"os"
 	"testing"
 
 	"github.com/elastic/otel-profiling-agent/testsupport"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func assertFileToVA(t *testing.T, mapper AddressMapper, fileAddress, virtualAddress uint64) {
@@ -32,3 +34,78 @@ func TestAddressMapper(t *testing.T) {
 	assertFileToVA(t, mapper, 0x1000, 0x401000)
 	assertFileToVA(t, mapper, 0x1010, 0x401010)
 }
+
+func TestVirtualAddressToFileOffset(t *testing.T) {
+	debugExePath, err := testsupport.WriteTestExecutable2()
+	require.NoError(t, err)
+	defer os.Remove(debugExePath)
+
+	ef, err := Open(debugExePath)
+	require.NoError(t, err)
+	defer ef.Close()
+
+	mapper := ef.GetAddressMapper()
+	offset, ok := mapper.VirtualAddressToFileOffset(0x401010)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0x1010), offset)
+	va, ok := mapper.FileOffsetToVirtualAddress(offset)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(0x401010), va)
+
+	segment, ok := mapper.Segment(0x401010)
+	assert.True(t, ok)
+	assert.Equal(t, Segment{Vaddr: 0x401000, Offset: 0x1000, Size: segment.Size}, segment)
+
+	// Not in an executable segment.
+	_, ok = mapper.VirtualAddressToFileOffset(0x400000)
+	assert.False(t, ok)
+}
+
+func TestVirtualAddressToFileOffsetLoadBias(t *testing.T) {
+	ef, err := Open("testdata/tls-shared.so")
+	require.NoError(t, err)
+	defer ef.Close()
+	mapper := ef.GetAddressMapper()
+
+	// The executable segment of the shared library as mapped by the dynamic loader,
+	// which places the library at an address chosen at run time.
+	const mappingVaddr, mappingFileOffset = 0x7f1234561000, 0x1000
+	elfSpaceVA, ok := mapper.FileOffsetToVirtualAddress(mappingFileOffset)
+	require.True(t, ok)
+	bias := uint64(mappingVaddr) - elfSpaceVA
+	assert.Equal(t, uint64(0x7f1234560000), bias)
+
+	// A sample at 0x20 bytes into the mapping is at the same offset in the file.
+	offset, ok := mapper.VirtualAddressToFileOffset(mappingVaddr + 0x20 - bias)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(mappingFileOffset+0x20), offset)
+}
+
+func TestLoadBiasPIE(t *testing.T) {
+	ef, err := Open("testdata/pie-interpreter")
+	require.NoError(t, err)
+	defer ef.Close()
+	require.Equal(t, elf.ET_DYN, ef.Type)
+	mapper := ef.GetAddressMapper()
+	sym, err := ef.LookupSymbol("interp_state")
+	require.NoError(t, err)
+
+	// The executable segment of the PIE binary as mapped by the kernel at a high random base.
+	const base = 0x7f0012340000
+	var segment Segment
+	for _, p := range ef.Progs {
+		if p.Type == elf.PT_LOAD && p.Flags&elf.PF_X != 0 {
+			segment = Segment{Vaddr: p.Vaddr, Offset: p.Off}
+		}
+	}
+	mappingFileOffset := segment.Offset &^ pageSizeMinusOne
+	mappingVaddr := base + segment.Vaddr - (segment.Offset - mappingFileOffset)
+	bias, ok := mapper.LoadBias(mappingVaddr, mappingFileOffset)
+	require.True(t, ok)
+	assert.Equal(t, uint64(base), bias)
+	// The state of the interpreter is at its symbol address plus the bias at run time.
+	assert.Equal(t, uint64(base)+uint64(sym.Address), uint64(sym.Address)+bias)
+
+	_, ok = mapper.LoadBias(mappingVaddr, 1<<40)
+	assert.False(t, ok)
+}
@@ -32,6 +32,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 
@@ -49,8 +50,71 @@ const (
 	// parsed sections (e.g. symbol tables and string tables; libxul
 	// has about 4MB .dynstr)
 	maxBytesLargeSection = 16 * 1024 * 1024
+
+	// DefaultMaxBufferSize is the default upper bound for the amount of data a single
+	// section or segment read may load into memory. It matches the largest per-operation
+	// limit used by the agent (Go pclntab).
+	DefaultMaxBufferSize = 128 * 1024 * 1024
+)
+
+// The dynamic tags of the table of packed relative relocations, which is emitted by linkers
+// for -z pack-relative-relocs, but not yet defined by debug/elf.
+const (
+	dtRELRSZ = elf.DynTag(35)
+	dtRELR   = elf.DynTag(36)
 )
 
+// maxBufferSize is the global cap on the data loaded by Section.Data and Prog.Data. It
+// applies in addition to the per-call limit.
+var maxBufferSize atomic.Uint64
+
+func init() {
+	maxBufferSize.Store(DefaultMaxBufferSize)
+}
+
+// SetMaxBufferSize sets the global cap on the amount of data a single section or segment
+// read may load into memory. Reads exceeding the cap fail with a SizeLimitError.
+// A value of 0 restores DefaultMaxBufferSize.
+func SetMaxBufferSize(size uint64) {
+	if size == 0 {
+		size = DefaultMaxBufferSize
+	}
+	maxBufferSize.Store(size)
+}
+
+// ErrSizeLimitExceeded is matched (using errors.Is) by all SizeLimitError instances
+var ErrSizeLimitExceeded = errors.New("size limit exceeded")
+
+// SizeLimitError is returned when loading ELF data would exceed the buffering limit
+// of the operation. Callers should treat the data as unavailable and skip it.
+type SizeLimitError struct {
+	// Kind describes what was being loaded (e.g. "section .debug_frame")
+	Kind string
+	// Size is the size of the data in bytes
+	Size uint64
+	// Limit is the limit in bytes that was exceeded
+	Limit uint64
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("%s size %d exceeds limit of %d bytes", e.Kind, e.Size, e.Limit)
+}
+
+// Is implements errors.Is support for matching against ErrSizeLimitExceeded.
+func (e *SizeLimitError) Is(target error) bool {
+	return target == ErrSizeLimitExceeded
+}
+
+// checkBufferSize verifies that size bytes can be loaded into memory with the given
+// per-call limit and the global buffer size cap.
+func checkBufferSize(kind string, size uint64, maxSize uint) error {
+	limit := min(uint64(maxSize), maxBufferSize.Load())
+	if size > limit {
+		return &SizeLimitError{Kind: kind, Size: size, Limit: limit}
+	}
+	return nil
+}
+
 // ErrSymbolNotFound is returned when requested symbol was not found
 var ErrSymbolNotFound = errors.New("symbol not found")
 
@@ -65,6 +129,10 @@ type File struct {
 	// elfReader is the ReadAt implementation used for this File
 	elfReader io.ReaderAt
 
+	// identity is the identity of the opened file on disk, if known
+	identity    FileIdentity
+	hasIdentity bool
+
 	// ehFrame is a pointer to the PT_GNU_EH_FRAME segment of the ELF
 	ehFrame *Prog
 
@@ -106,6 +174,21 @@ type File struct {
 	// symbolAddr is the virtual address for symbol table from the Dynamic section
 	symbolsAddr int64
 
+	// relaAddr and relaSize are the virtual address and size of the relocation table
+	// from the Dynamic section
+	relaAddr int64
+	relaSize int64
+
+	// relrAddr and relrSize are the virtual address and size of the table of packed
+	// relative relocations (RELR) from the Dynamic section
+	relrAddr int64
+	relrSize int64
+
+	// symtab caches the symbol table that LookupSymbol searches in files without dynamic
+	// symbols, and symtabErr the error reading it
+	symtab    *libpf.SymbolMap
+	symtabErr error
+
 	// bias is the load bias for ELF files inside core dump
 	bias libpf.Address
 
@@ -173,9 +256,16 @@ func Open(name string) (*File, error) {
 		f.Close()
 		return nil, err
 	}
+	ff.identity, ff.hasIdentity = fileIdentity(f)
 	return ff, nil
 }
 
+// Identity returns the identity of the file on disk this File was opened from. It is
+// only available for Files opened with Open.
+func (f *File) Identity() (FileIdentity, bool) {
+	return f.identity, f.hasIdentity
+}
+
 // Close closes the File.
 func (f *File) Close() (err error) {
 	if f.closer != nil {
@@ -305,6 +395,14 @@ func newFile(r io.ReaderAt, closer io.Closer, loadAddress uint64, hasMusl bool)
 					f.symbolsAddr = adjustedVal
 				case elf.DT_GNU_HASH:
 					f.gnuHash.addr = adjustedVal
+				case elf.DT_RELA:
+					f.relaAddr = adjustedVal
+				case elf.DT_RELASZ:
+					f.relaSize = int64(dyn.Val)
+				case dtRELR:
+					f.relrAddr = adjustedVal
+				case dtRELRSZ:
+					f.relrSize = int64(dyn.Val)
 				}
 			}
 		case elf.PT_GNU_EH_FRAME:
@@ -476,7 +574,7 @@ func (f *File) GetBuildID() (string, error) {
 		s = f.Section(".notes")
 	}
 	if s == nil {
-		return "", ErrNoBuildID
+		return f.getBuildIDFromProgs()
 	}
 	data, err := s.Data(maxBytesSmallSection)
 	if err != nil {
@@ -486,6 +584,25 @@ func (f *File) GetBuildID() (string, error) {
 	return getBuildIDFromNotes(data)
 }
 
+// getBuildIDFromProgs reads the build ID from the PT_NOTE segments. This is used when the
+// section headers are not available, e.g. for an ELF that is read from memory.
+func (f *File) getBuildIDFromProgs() (string, error) {
+	for i := range f.Progs {
+		p := &f.Progs[i]
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := p.Data(maxBytesSmallSection)
+		if err != nil {
+			continue
+		}
+		if buildID, err := getBuildIDFromNotes(data); err == nil {
+			return buildID, nil
+		}
+	}
+	return "", ErrNoBuildID
+}
+
 // GetDebugLink reads and parses the .gnu_debuglink section.
 // If the link does not exist then ErrNoDebugLink is returned.
 func (f *File) GetDebugLink() (linkName string, crc int32, err error) {
@@ -501,19 +618,41 @@ func (f *File) GetDebugLink() (linkName string, crc int32, err error) {
 	return ParseDebugLink(d)
 }
 
-// OpenDebugLink tries to locate and open the corresponding debug ELF for this DSO.
+// debugFileDirectory is the global directory in which separate debug packages install the
+// debug files.
+const debugFileDirectory = "/usr/lib/debug"
+
+// BuildIDDebugFile returns the path of the debug file for the given build ID in the
+// .build-id indexed layout of the global debug file directory, e.g.
+// /usr/lib/debug/.build-id/ab/cdef1234.debug.
+func BuildIDDebugFile(buildID string) (string, error) {
+	if len(buildID) < 3 {
+		return "", fmt.Errorf("build ID '%s' is too short", buildID)
+	}
+	return filepath.Join(debugFileDirectory, ".build-id", buildID[:2], buildID[2:]+".debug"),
+		nil
+}
+
+// OpenDebugLink tries to locate and open the corresponding debug ELF for this DSO. The debug
+// file is looked up by the build ID in the .build-id directory of the global debug file
+// directory first, as used by e.g. the Debian and Ubuntu dbgsym packages. Otherwise, it is
+// looked up by the name in the .gnu_debuglink section.
 func (f *File) OpenDebugLink(elfFilePath string, elfOpener ELFOpener) (
 	debugELF *File, debugFile string) {
+	if debugELF, debugFile = f.openBuildIDDebugFile(elfOpener); debugELF != nil {
+		return debugELF, debugFile
+	}
+
 	// Get the debug link
 	linkName, linkCRC32, err := f.GetDebugLink()
 	if err != nil {
 		// Treat missing or corrupt tag as soft error.
-		return
+		return nil, ""
 	}
 
 	// Try to find the debug file
 	executablePath := filepath.Dir(elfFilePath)
-	for _, debugPath := range []string{"/usr/lib/debug/"} {
+	for _, debugPath := range []string{debugFileDirectory} {
 		debugFile = filepath.Join(debugPath, executablePath, linkName)
 		debugELF, err = elfOpener.OpenELF(debugFile)
 		if err != nil {
@@ -530,7 +669,34 @@ func (f *File) OpenDebugLink(elfFilePath string, elfOpener ELFOpener) (
 		}
 		return debugELF, debugFile
 	}
-	return
+	return nil, ""
+}
+
+// openBuildIDDebugFile opens the debug file in the .build-id directory of the global debug
+// file directory, if it exists and has the same build ID as this DSO.
+func (f *File) openBuildIDDebugFile(elfOpener ELFOpener) (debugELF *File, debugFile string) {
+	buildID, err := f.GetBuildID()
+	if err != nil {
+		return nil, ""
+	}
+	if debugFile, err = BuildIDDebugFile(buildID); err != nil {
+		return nil, ""
+	}
+	debugELF, err = elfOpener.OpenELF(debugFile)
+	if err != nil {
+		return nil, ""
+	}
+	if debugELF.Section(".debug_frame") == nil {
+		debugELF.Close()
+		return nil, ""
+	}
+	// Like the CRC of debug links, the build ID of the file itself is verified, so that
+	// a debug file of another build is not used.
+	if debugBuildID, err := debugELF.GetBuildID(); err != nil || debugBuildID != buildID {
+		debugELF.Close()
+		return nil, ""
+	}
+	return debugELF, debugFile
 }
 
 // CRC32 calculates the .gnu_debuglink compatible CRC-32 of the ELF file
@@ -591,9 +757,11 @@ func (ph *Prog) Open() io.ReadSeeker {
 }
 
 // Data loads the whole program header referenced data, and returns it as slice.
+// A SizeLimitError is returned if the segment is larger than maxSize or the
+// global buffer size cap.
 func (ph *Prog) Data(maxSize uint) ([]byte, error) {
-	if ph.Filesz > uint64(maxSize) {
-		return nil, fmt.Errorf("segment size %d is too large", ph.Filesz)
+	if err := checkBufferSize("segment", ph.Filesz, maxSize); err != nil {
+		return nil, err
 	}
 	p := make([]byte, ph.Filesz)
 	_, err := ph.ReadAt(p, 0)
@@ -609,13 +777,21 @@ func (ph *Prog) DataReader(maxSize uint) (io.Reader, error) {
 	return bytes.NewReader(p), nil
 }
 
+// Open returns a new ReadSeeker reading the section body. This allows streaming
+// the section contents without loading all of it into memory.
+func (sh *Section) Open() io.ReadSeeker {
+	return io.NewSectionReader(sh.ReaderAt, 0, int64(sh.FileSize))
+}
+
 // Data loads the whole section header referenced data, and returns it as a slice.
+// A SizeLimitError is returned if the section is larger than maxSize or the
+// global buffer size cap.
 func (sh *Section) Data(maxSize uint) ([]byte, error) {
 	if sh.Flags&elf.SHF_COMPRESSED != 0 {
 		return nil, fmt.Errorf("compressed sections not supported")
 	}
-	if sh.FileSize > uint64(maxSize) {
-		return nil, fmt.Errorf("section size %d is too large", sh.FileSize)
+	if err := checkBufferSize("section "+sh.Name, sh.FileSize, maxSize); err != nil {
+		return nil, err
 	}
 	p := make([]byte, sh.FileSize)
 	_, err := sh.ReadAt(p, 0)
@@ -682,7 +858,32 @@ func calcSysvHash(s libpf.SymbolName) uint32 {
 	return h & 0xfffffff
 }
 
-// LookupSymbol searches for a given symbol in the ELF
+// HasDynamicSymbols returns true if the ELF has a hash table of dynamic symbols, which
+// statically linked executables lack.
+func (f *File) HasDynamicSymbols() bool {
+	return f.gnuHash.addr != 0 || f.sysvHash.addr != 0
+}
+
+// lookupStaticSymbol searches for a given symbol in the symbol table of an ELF without
+// dynamic symbols. The symbol table is read on first use and kept with the File.
+func (f *File) lookupStaticSymbol(symbol libpf.SymbolName) (*libpf.Symbol, error) {
+	if f.symtab == nil && f.symtabErr == nil {
+		if f.symtab, f.symtabErr = f.ReadSymbols(); f.symtabErr != nil {
+			f.symtabErr = fmt.Errorf("symbol hash not present: %v", f.symtabErr)
+		}
+	}
+	if f.symtabErr != nil {
+		return nil, f.symtabErr
+	}
+	sym, err := f.symtab.LookupSymbol(symbol)
+	if err != nil {
+		return nil, ErrSymbolNotFound
+	}
+	return sym, nil
+}
+
+// LookupSymbol searches for a given symbol in the ELF. ELFs without dynamic symbols, e.g.
+// statically linked executables, are searched in their symbol table instead.
 func (f *File) LookupSymbol(symbol libpf.SymbolName) (*libpf.Symbol, error) {
 	if f.gnuHash.addr != 0 {
 		// Standard DT_GNU_HASH lookup code follows. Please check the DT_GNU_HASH
@@ -773,7 +974,7 @@ func (f *File) LookupSymbol(symbol libpf.SymbolName) (*libpf.Symbol, error) {
 			}
 		}
 	} else {
-		return nil, errors.New("symbol hash not present")
+		return f.lookupStaticSymbol(symbol)
 	}
 
 	return nil, ErrSymbolNotFound
@@ -820,6 +1021,7 @@ func (f *File) loadSymbolTable(name string) (*libpf.SymbolMap, error) {
 			Name:    libpf.SymbolName(name),
 			Address: libpf.SymbolValue(sym.Value),
 			Size:    int(sym.Size),
+			Global:  isGlobalBinding(elf.ST_BIND(sym.Info)),
 		})
 	}
 	symMap.Finalize()
@@ -827,6 +1029,134 @@ func (f *File) loadSymbolTable(name string) (*libpf.SymbolMap, error) {
 	return &symMap, nil
 }
 
+// LookupTLSModuleIDSlot returns the address of the GOT entry which the dynamic linker sets
+// to the TLS module ID of the ELF for accessing the thread-local variable symbol with the
+// general dynamic TLS model, i.e. the target of its DTPMOD64 relocation.
+func (f *File) LookupTLSModuleIDSlot(symbol libpf.SymbolName) (libpf.Address, error) {
+	var relType uint32
+	switch f.Machine {
+	case elf.EM_X86_64:
+		relType = uint32(elf.R_X86_64_DTPMOD64)
+	case elf.EM_AARCH64:
+		relType = uint32(elf.R_AARCH64_TLS_DTPMOD64)
+	default:
+		return 0, fmt.Errorf("unsupported machine %v", f.Machine)
+	}
+
+	var slot libpf.Address
+	found := false
+	if err := f.forEachRela(func(rela *elf.Rela64) bool {
+		if elf.R_TYPE64(rela.Info) != relType {
+			return true
+		}
+		if _, ok := f.readAndMatchSymbol(elf.R_SYM64(rela.Info), symbol); ok {
+			slot = libpf.Address(rela.Off)
+			found = true
+		}
+		return !found
+	}); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, ErrSymbolNotFound
+	}
+	return slot, nil
+}
+
+// forEachRela calls fn for the entries of the relocation table from the Dynamic section
+// until it returns false.
+func (f *File) forEachRela(fn func(rela *elf.Rela64) bool) error {
+	var relas [64]elf.Rela64
+	relaSz := int64(unsafe.Sizeof(relas[0]))
+	for off := int64(0); off < f.relaSize; off += int64(len(relas)) * relaSz {
+		n := min(int64(len(relas)), (f.relaSize-off)/relaSz)
+		if _, err := f.ReadVirtualMemory(libpf.SliceFrom(relas[:n]),
+			f.relaAddr+off); err != nil {
+			return err
+		}
+		for i := range relas[:n] {
+			if !fn(&relas[i]) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// forEachRelr calls fn for the virtual addresses relocated by the table of packed relative
+// relocations (RELR) from the Dynamic section until it returns false. The table consists of
+// addresses, which have the lowest bit clear, and bitmaps, which have it set. An address
+// entry is relocated itself, and the word following it is the base of the next bitmap.
+// Bit n of a bitmap, starting at 1, stands for the word n-1 words from the base, and the
+// base of the next bitmap is 63 words further.
+func (f *File) forEachRelr(fn func(addr uint64) bool) error {
+	const wordSize = 8
+	if f.relrSize > maxBytesLargeSection {
+		return fmt.Errorf("RELR table of %d bytes is too large", f.relrSize)
+	}
+	entries := make([]uint64, f.relrSize/wordSize)
+	if _, err := f.ReadVirtualMemory(libpf.SliceFrom(entries), f.relrAddr); err != nil {
+		return err
+	}
+	base := uint64(0)
+	for _, entry := range entries {
+		if entry&1 == 0 {
+			if !fn(entry) {
+				return nil
+			}
+			base = entry + wordSize
+			continue
+		}
+		for i, bitmap := uint64(0), entry>>1; bitmap != 0; i, bitmap = i+1, bitmap>>1 {
+			if bitmap&1 != 0 && !fn(base+i*wordSize) {
+				return nil
+			}
+		}
+		base += 63 * wordSize
+	}
+	return nil
+}
+
+// ReadRelocatedPointer returns the pointer at the virtual address addr as set up by the
+// dynamic linker, and whether the load bias is added to it by a relative relocation. For
+// relocations in the RELA table the pointer is their addend, while the relocations packed
+// into the RELR table, as emitted for -z pack-relative-relocs, keep it in place. Pointers
+// without relative relocation are returned as stored in the file.
+func (f *File) ReadRelocatedPointer(addr libpf.Address) (ptr libpf.Address, relative bool,
+	err error) {
+	var relType uint32
+	switch f.Machine {
+	case elf.EM_X86_64:
+		relType = uint32(elf.R_X86_64_RELATIVE)
+	case elf.EM_AARCH64:
+		relType = uint32(elf.R_AARCH64_RELATIVE)
+	default:
+		return 0, false, fmt.Errorf("unsupported machine %v", f.Machine)
+	}
+
+	if err = f.forEachRela(func(rela *elf.Rela64) bool {
+		if elf.R_TYPE64(rela.Info) == relType && rela.Off == uint64(addr) {
+			ptr = libpf.Address(rela.Addend)
+			relative = true
+		}
+		return !relative
+	}); err != nil || relative {
+		return ptr, relative, err
+	}
+
+	if err = f.forEachRelr(func(relocated uint64) bool {
+		relative = relocated == uint64(addr)
+		return !relative
+	}); err != nil {
+		return 0, false, err
+	}
+	var value uint64
+	if _, err = f.ReadVirtualMemory(libpf.SliceFrom(&value), int64(addr)); err != nil {
+		return 0, false, err
+	}
+	return libpf.Address(value), relative, nil
+}
+
 // ReadSymbols reads the full dynamic symbol table from the ELF
 func (f *File) ReadSymbols() (*libpf.SymbolMap, error) {
 	return f.loadSymbolTable(".symtab")
@@ -863,5 +1193,24 @@ func (f *File) DynString(tag elf.DynTag) ([]string, error) {
 
 // IsGolang determines if this ELF is a Golang executable
 func (f *File) IsGolang() bool {
-	return f.Section(".go.buildinfo") != nil || f.Section(".gopclntab") != nil
+	if f.Section(".go.buildinfo") != nil || f.Section(".gopclntab") != nil {
+		return true
+	}
+	// The section headers are not available inside coredumps, and might have been
+	// stripped from statically linked executables. The Go linker always emits the
+	// Go build ID note in a PT_NOTE segment, so check the program headers too.
+	for i := range f.Progs {
+		p := &f.Progs[i]
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		data, err := p.Data(maxBytesSmallSection)
+		if err != nil {
+			continue
+		}
+		if hasNote(data, "Go", goBuildIDNoteType) {
+			return true
+		}
+	}
+	return false
 }
@@ -7,6 +7,8 @@
 package pfelf
 
 import (
+	"errors"
+	"io"
 	"os"
 	"testing"
 
@@ -75,6 +77,42 @@ func TestPFELFSections(t *testing.T) {
 	}
 }
 
+func TestSectionSizeLimit(t *testing.T) {
+	elfFile, err := Open("testdata/fixed-address")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer elfFile.Close()
+
+	sh := elfFile.Section(".coffee_section")
+	if !assert.NotNil(t, sh) || !assert.NotZero(t, sh.FileSize) {
+		return
+	}
+
+	data, err := sh.Data(uint(sh.FileSize))
+	assert.Nil(t, err)
+
+	// The section can be streamed independent of the limits
+	streamed, err := io.ReadAll(sh.Open())
+	assert.Nil(t, err)
+	assert.Equal(t, data, streamed)
+
+	// Per-call limit
+	_, err = sh.Data(uint(sh.FileSize - 1))
+	assert.True(t, errors.Is(err, ErrSizeLimitExceeded))
+	var limitErr *SizeLimitError
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, sh.FileSize, limitErr.Size)
+		assert.Equal(t, sh.FileSize-1, limitErr.Limit)
+	}
+
+	// Global limit
+	SetMaxBufferSize(sh.FileSize - 1)
+	defer SetMaxBufferSize(0)
+	_, err = sh.Data(maxBytesLargeSection)
+	assert.True(t, errors.Is(err, ErrSizeLimitExceeded))
+}
+
 func testPFELFIsGolang(t *testing.T, filename string, isGoExpected bool) {
 	ef := getPFELF(filename, t)
 	defer ef.Close()
@@ -85,3 +123,194 @@ func TestPFELFIsGolang(t *testing.T) {
 	testPFELFIsGolang(t, "testdata/go-binary", true)
 	testPFELFIsGolang(t, "testdata/without-debug-syms", false)
 }
+
+func TestPFELFIsGolangWithoutSections(t *testing.T) {
+	ef := getPFELF("../nativeunwind/elfunwindinfo/testdata/helloworld", t)
+	defer ef.Close()
+
+	// Section headers are unavailable, the Go build ID note needs to be used.
+	ef.InsideCore = true
+	assert.True(t, ef.IsGolang())
+}
+
+func TestHasNote(t *testing.T) {
+	// Go build ID note with the name padded like the Go linker does, followed by
+	// a GNU build ID note
+	notes := []byte{
+		4, 0, 0, 0, 4, 0, 0, 0, 4, 0, 0, 0, 'G', 'o', 0, 0, 'a', 'b', 'c', 'd',
+		4, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 'G', 'N', 'U', 0, 0x12, 0x34, 0, 0,
+	}
+	assert.True(t, hasNote(notes, "Go", goBuildIDNoteType))
+	assert.True(t, hasNote(notes, "GNU", 3))
+	assert.False(t, hasNote(notes, "GNU", goBuildIDNoteType))
+	assert.False(t, hasNote(notes, "G", 3))
+	assert.False(t, hasNote(notes[:30], "GNU", 3))
+	assert.False(t, hasNote(nil, "Go", goBuildIDNoteType))
+}
+
+func TestLookupByAddressICF(t *testing.T) {
+	ef := getPFELF("testdata/icf-symbols", t)
+	defer ef.Close()
+
+	symmap, err := ef.ReadSymbols()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// All three functions are folded to the same address, the lexicographically
+	// first global symbol is returned.
+	addr, err := symmap.LookupSymbolAddress("local_twice")
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, name := range []libpf.SymbolName{"global_twice_a", "global_twice_b"} {
+		folded, err := symmap.LookupSymbolAddress(name)
+		assert.NoError(t, err)
+		assert.Equal(t, addr, folded, name)
+	}
+
+	name, offs, ok := symmap.LookupByAddress(addr + 1)
+	assert.True(t, ok)
+	assert.Equal(t, libpf.SymbolName("global_twice_a"), name)
+	assert.Equal(t, libpf.Address(1), offs)
+}
+
+func TestLookupTLSModuleIDSlot(t *testing.T) {
+	ef := getPFELF("testdata/tls-shared.so", t)
+	defer ef.Close()
+
+	slot, err := ef.LookupTLSModuleIDSlot("current_context")
+	if assert.NoError(t, err) {
+		got := ef.Section(".got")
+		if assert.NotNil(t, got) {
+			assert.GreaterOrEqual(t, uint64(slot), got.Addr)
+			assert.Less(t, uint64(slot), got.Addr+got.Size)
+		}
+	}
+
+	_, err = ef.LookupTLSModuleIDSlot("get_current_context")
+	assert.ErrorIs(t, err, ErrSymbolNotFound)
+}
+
+func TestReadRelocatedPointer(t *testing.T) {
+	for _, name := range []string{"relative-relocs-rela", "relative-relocs-relr"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			ef := getPFELF("testdata/"+name, t)
+			defer ef.Close()
+
+			pointers, err := ef.LookupSymbol("pointers")
+			if !assert.NoError(t, err) {
+				return
+			}
+			targets, err := ef.LookupSymbol("targets")
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// The null pointer after the first 50 pointers is not relocated.
+			target := libpf.Address(targets.Address)
+			for i := 0; i <= 100; i++ {
+				ptr, relative, err := ef.ReadRelocatedPointer(
+					libpf.Address(pointers.Address) + libpf.Address(8*i))
+				if !assert.NoError(t, err) {
+					return
+				}
+				if i == 50 {
+					assert.Equal(t, libpf.Address(0), ptr, "pointer %d", i)
+					assert.False(t, relative, "pointer %d", i)
+					continue
+				}
+				assert.Equal(t, target, ptr, "pointer %d", i)
+				assert.True(t, relative, "pointer %d", i)
+				target += 4
+			}
+
+			ptr, relative, err := ef.ReadRelocatedPointer(libpf.Address(targets.Address))
+			if assert.NoError(t, err) {
+				assert.Equal(t, libpf.Address(0), ptr)
+				assert.False(t, relative)
+			}
+		})
+	}
+}
+
+// debugFileOpener is an ELFOpener that opens the files of a global debug file directory
+// from other paths.
+type debugFileOpener struct {
+	files map[string]string
+}
+
+func (o *debugFileOpener) OpenELF(name string) (*File, error) {
+	if path, ok := o.files[name]; ok {
+		return Open(path)
+	}
+	return nil, os.ErrNotExist
+}
+
+func TestBuildIDDebugFile(t *testing.T) {
+	debugFile, err := BuildIDDebugFile("faee9cd8d5a860c3cd0d8a3dcd399f14b7ffb079")
+	assert.NoError(t, err)
+	assert.Equal(t, "/usr/lib/debug/.build-id/fa/ee9cd8d5a860c3cd0d8a3dcd399f14b7ffb079.debug",
+		debugFile)
+
+	_, err = BuildIDDebugFile("ab")
+	assert.Error(t, err)
+}
+
+func TestOpenBuildIDDebugFile(t *testing.T) {
+	tests := map[string]struct {
+		// elf is the file whose debug file is opened.
+		elf string
+		// file is the file installed at the build ID path of the debug file.
+		file  string
+		found bool
+	}{
+		"matching build ID": {
+			elf:   "testdata/with-debug-frame",
+			file:  "testdata/separate-debug-frame-file",
+			found: true,
+		},
+		"other build ID": {
+			elf:  "testdata/without-debug-syms",
+			file: "testdata/separate-debug-frame-file",
+		},
+		"without debug frame": {
+			elf:  "testdata/with-debug-syms",
+			file: "testdata/separate-debug-file",
+		},
+		"not installed": {elf: "testdata/with-debug-frame"},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			ef := getPFELF(test.elf, t)
+			defer ef.Close()
+			buildID, err := ef.GetBuildID()
+			if !assert.NoError(t, err) {
+				return
+			}
+			debugFile, err := BuildIDDebugFile(buildID)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			opener := &debugFileOpener{files: map[string]string{}}
+			if test.file != "" {
+				opener.files[debugFile] = test.file
+			}
+			debugELF, path := ef.OpenDebugLink(test.elf, opener)
+			if !test.found {
+				assert.Nil(t, debugELF)
+				assert.Empty(t, path)
+				return
+			}
+			if assert.NotNil(t, debugELF) {
+				defer debugELF.Close()
+				assert.NotNil(t, debugELF.Section(".debug_frame"))
+			}
+			assert.Equal(t, debugFile, path)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package pfelf
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ErrFileChanged is returned when the file opened for a Reference is not the file that
+// was expected, e.g. because the file has been replaced in place after it got mapped.
+var ErrFileChanged = errors.New("file changed")
+
+// FileIdentity identifies a specific version of a file on disk. The change time is
+// updated by the kernel on every modification and can not be set by user space. It
+// thus detects files that are overwritten in place even if the modification time is
+// preserved, as done by e.g. 'cp -p' or container image builds.
+type FileIdentity struct {
+	Device     uint64
+	Inode      uint64
+	Size       int64
+	ModTime    int64
+	ChangeTime int64
+}
+
+func identityFromStat(st *syscall.Stat_t) FileIdentity {
+	return FileIdentity{
+		Device:     uint64(st.Dev),
+		Inode:      st.Ino,
+		Size:       st.Size,
+		ModTime:    st.Mtim.Nano(),
+		ChangeTime: st.Ctim.Nano(),
+	}
+}
+
+// StatIdentity returns the FileIdentity of the named file.
+func StatIdentity(name string) (FileIdentity, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(name, &st); err != nil {
+		return FileIdentity{}, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return identityFromStat(&st), nil
+}
+
+// fileIdentity returns the FileIdentity of an opened file.
+func fileIdentity(f *os.File) (FileIdentity, bool) {
+	info, err := f.Stat()
+	if err != nil {
+		return FileIdentity{}, false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileIdentity{}, false
+	}
+	return identityFromStat(st), true
+}
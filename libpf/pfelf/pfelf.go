@@ -37,6 +37,7 @@ var elfHeader = []byte{
 // *** WARNING ***
 // ANY CHANGE IN BEHAVIOR CAN EASILY BREAK OUR INFRASTRUCTURE, POSSIBLY MAKING THE ENTIRETY
 // OF THE DEBUG INDEX OR FRAME METADATA WORTHLESS (BREAKING BACKWARDS COMPATIBILITY).
+// IF IT IS UNAVOIDABLE, FileIDAlgorithm HAS TO BE CHANGED ALONG WITH IT.
 func IsELFReader(reader io.ReadSeeker) (bool, error) {
 	fileHeader := make([]byte, 4)
 	nbytes, err := reader.Read(fileHeader)
@@ -77,6 +78,13 @@ func IsELF(filePath string) (bool, error) {
 	return isELF, nil
 }
 
+// FileIDAlgorithm identifies the algorithm CalculateID and CalculateIDFromReader use to
+// derive the FileID of a file from its contents. Agents and backends only agree on the
+// FileIDs of executables if they use the same algorithm, so it is reported with the host
+// metadata, and data persisted by FileID records it to detect FileIDs of another algorithm.
+// It has to be changed along with any change in the behavior of fileHashReader.
+const FileIDAlgorithm = "sha256-head4k-tail4k-size/1"
+
 // fileHashReader hashes the contents of the reader in order to generate a system-independent
 // identifier.
 // ELF files are partially hashed to save CPU cycles: only the first 4K and last 4K of the files
@@ -85,6 +93,7 @@ func IsELF(filePath string) (bool, error) {
 // *** WARNING ***
 // ANY CHANGE IN BEHAVIOR CAN EASILY BREAK OUR INFRASTRUCTURE, POSSIBLY MAKING THE ENTIRETY
 // OF THE DEBUG INDEX OR FRAME METADATA WORTHLESS (BREAKING BACKWARDS COMPATIBILITY).
+// IF IT IS UNAVOIDABLE, FileIDAlgorithm HAS TO BE CHANGED ALONG WITH IT.
 func fileHashReader(reader io.ReadSeeker) ([]byte, error) {
 	isELF, err := IsELFReader(reader)
 	if err != nil {
@@ -416,6 +425,39 @@ func getNoteHexString(sectionBytes []byte, name string, noteType uint32) (
 	return hex.EncodeToString(sectionBytes[idxDataStart:idxDataEnd]), true, nil
 }
 
+// goBuildIDNoteType is the ELF note type of the Go build ID note.
+const goBuildIDNoteType = 4
+
+// hasNote returns true if the ELF notes data contains a note with the given name and type.
+func hasNote(notes []byte, name string, noteType uint32) bool {
+	// Each note consists of the 32-bit namesz, descsz and type fields followed
+	// by the name and desc fields, which are both padded to 4 bytes.
+	for len(notes) >= 12 {
+		nameSize := uint64(binary.LittleEndian.Uint32(notes[0:]))
+		descSize := uint64(binary.LittleEndian.Uint32(notes[4:]))
+		typ := binary.LittleEndian.Uint32(notes[8:])
+		nameEnd := 12 + (nameSize+3)&^3
+		descEnd := nameEnd + (descSize+3)&^3
+		if descEnd > uint64(len(notes)) {
+			return false
+		}
+		// The name is NUL terminated, and might be padded with further NUL bytes
+		// as is done by the Go linker.
+		if typ == noteType &&
+			string(bytes.TrimRight(notes[12:12+nameSize], "\x00")) == name {
+			return true
+		}
+		notes = notes[descEnd:]
+	}
+	return false
+}
+
+// isGlobalBinding reports whether a symbol with the given binding is visible outside of
+// its object file.
+func isGlobalBinding(bind elf.SymBind) bool {
+	return bind == elf.STB_GLOBAL || bind == elf.STB_WEAK
+}
+
 func symbolMapFromELFSymbols(syms []elf.Symbol) *libpf.SymbolMap {
 	symmap := &libpf.SymbolMap{}
 	for _, sym := range syms {
@@ -423,6 +465,7 @@ func symbolMapFromELFSymbols(syms []elf.Symbol) *libpf.SymbolMap {
 			Name:    libpf.SymbolName(sym.Name),
 			Address: libpf.SymbolValue(sym.Value),
 			Size:    int(sym.Size),
+			Global:  isGlobalBinding(elf.ST_BIND(sym.Info)),
 		})
 	}
 	symmap.Finalize()
@@ -450,6 +493,21 @@ func CalculateKernelFileID(buildID string) (fileID libpf.FileID) {
 	return fileID
 }
 
+// CalculateIDFromBuildID returns the FileID of an executable whose contents are not
+// available from disk, e.g. because it has been deleted while it is still mapped. It
+// consists of a hash of its GNU BuildID in hex string form, and is thus the same for
+// all processes mapping the executable.
+func CalculateIDFromBuildID(buildID string) (fileID libpf.FileID) {
+	h := fnv.New128a()
+	// The prefix ensures that the FileID differs from that of a kernel file with the
+	// same BuildID.
+	_, _ = h.Write([]byte("mapped:"))
+	_, _ = h.Write([]byte(buildID))
+	// Cannot fail, ignore error.
+	fileID, _ = libpf.FileIDFromBytes(h.Sum(nil))
+	return fileID
+}
+
 // KernelFileIDToggleDebug returns the FileID of a kernel debug file (image or module) based on the
 // FileID of its non-debug counterpart. This function is its own inverse, so it can be used for the
 // opposite operation.
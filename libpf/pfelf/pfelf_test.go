@@ -7,6 +7,7 @@
 package pfelf_test
 
 import (
+	"bytes"
 	"debug/elf"
 	"encoding/hex"
 	"os"
@@ -323,6 +324,39 @@ func TestFilehandling(t *testing.T) {
 	}
 }
 
+// TestFileIDAlgorithm locks the FileIDs calculated by FileIDAlgorithm. If it fails, the
+// FileIDs of executables changed, and FileIDAlgorithm has to be changed along with the
+// expected values below, so that FileIDs of different algorithms are not mixed up.
+func TestFileIDAlgorithm(t *testing.T) {
+	assert.Equal(t, "sha256-head4k-tail4k-size/1", pfelf.FileIDAlgorithm)
+
+	// An ELF file large enough for only its first and last 4 KiB to be hashed. The hash can
+	// be checked with python like:
+	//  d = bytearray((i * 31 // 7) & 0xff for i in range(3 * 4096 + 123)); d[0:4] = b"\x7fELF"
+	//  hashlib.sha256(d[:4096] + d[-4096:] + struct.pack(">Q", len(d))).hexdigest()
+	data := make([]byte, 3*4096+123)
+	for i := range data {
+		data[i] = byte(i * 31 / 7)
+	}
+	copy(data, []byte{0x7F, 'E', 'L', 'F'})
+	expected := libpf.NewFileID(0x10d4edbd6121ea27, 0x121cee9164d58e36)
+
+	fileID, err := pfelf.CalculateIDFromReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fileID)
+
+	// The contents between the first and last 4 KiB do not contribute to the FileID.
+	data[len(data)/2]++
+	fileID, err = pfelf.CalculateIDFromReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fileID)
+
+	// Yet the length does.
+	fileID, err = pfelf.CalculateIDFromReader(bytes.NewReader(data[:len(data)-1]))
+	assert.NoError(t, err)
+	assert.NotEqual(t, expected, fileID)
+}
+
 func assertSymbol(t *testing.T, symmap *libpf.SymbolMap, name libpf.SymbolName,
 	expectedAddress libpf.SymbolValue) {
 	sym, _ := symmap.LookupSymbol(name)
@@ -429,3 +463,11 @@ func TestKernelFileIDToggleDebug(t *testing.T) {
 	expectedFileID, _ := libpf.FileIDFromString("b8ec85adf2e76f4d026a2d6a60ee6b4e")
 	assert.Equal(t, expectedFileID, toggled)
 }
+
+func TestCalculateIDFromBuildID(t *testing.T) {
+	buildID := "f8e1cf0f60558098edaec164ac7749df"
+	fileID := pfelf.CalculateIDFromBuildID(buildID)
+	assert.Equal(t, fileID, pfelf.CalculateIDFromBuildID(buildID))
+	assert.NotEqual(t, pfelf.CalculateKernelFileID(buildID), fileID)
+	assert.NotEqual(t, fileID, pfelf.CalculateIDFromBuildID("0"+buildID[1:]))
+}
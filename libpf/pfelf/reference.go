@@ -9,6 +9,30 @@
 
 package pfelf
 
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// MaxTransientFailures is the number of consecutive transient failures to open the ELF
+// file, after which the failure is considered permanent.
+const MaxTransientFailures = 3
+
+// IsTransientError returns true if err is likely to go away when retrying the operation,
+// e.g. an I/O error of flaky storage or the exhaustion of file descriptors. Other errors,
+// like missing or corrupt files, are permanent.
+func IsTransientError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR,
+		syscall.ESTALE, syscall.EMFILE, syscall.ENFILE, syscall.ENOMEM, syscall.EBUSY,
+		syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
 // Reference is a reference to an ELF file which is loaded and cached on demand.
 type Reference struct {
 	// Interface to open ELF files as needed
@@ -19,6 +43,17 @@ type Reference struct {
 
 	// elfFile contains the cached ELF file
 	elfFile *File
+
+	// expected is the identity the opened file must have, if hasExpected is set
+	expected    FileIdentity
+	hasExpected bool
+
+	// err is the permanent error of opening the ELF file, which is returned without
+	// trying again
+	err error
+
+	// transientFailures is the number of consecutive transient failures to open the file
+	transientFailures int
 }
 
 // NewReference returns a new Reference
@@ -31,14 +66,70 @@ func (ref *Reference) FileName() string {
 	return ref.fileName
 }
 
+// SetExpectedIdentity configures the identity of the file this Reference is expected to
+// open, e.g. the identity of the file backing a memory mapping. A cached File that does
+// not match the new identity is closed, and earlier errors are forgotten.
+func (ref *Reference) SetExpectedIdentity(identity FileIdentity) {
+	ref.expected, ref.hasExpected = identity, true
+	ref.err, ref.transientFailures = nil, 0
+	if ref.elfFile != nil && !ref.matchesExpected(ref.elfFile) {
+		ref.Close()
+	}
+}
+
+// matchesExpected checks if the File has the expected identity. Files without a known
+// identity, e.g. extracted from memory, are accepted.
+func (ref *Reference) matchesExpected(ef *File) bool {
+	identity, ok := ef.Identity()
+	return !ref.hasExpected || !ok || identity == ref.expected
+}
+
 // GetELF returns the File to access this File and keeps it cached. The
 // caller of this functions must not Close the File.
+//
+// If an expected identity is set and the opened file does not match it, the file has
+// likely been replaced while it is being opened. The file is opened once more, and
+// ErrFileChanged is returned if it still does not match.
+//
+// Permanent errors are kept and returned by later calls without opening the file again.
+// After transient errors, as reported by IsTransientError, the file is opened again by the
+// next call, until MaxTransientFailures consecutive calls failed.
 func (ref *Reference) GetELF() (*File, error) {
-	var err error
-	if ref.elfFile == nil {
-		ref.elfFile, err = ref.OpenELF(ref.fileName)
+	if ref.elfFile != nil {
+		return ref.elfFile, nil
+	}
+	if ref.err != nil {
+		return nil, ref.err
+	}
+	ef, err := ref.openELF()
+	if err != nil {
+		if IsTransientError(err) {
+			ref.transientFailures++
+			if ref.transientFailures < MaxTransientFailures {
+				return nil, err
+			}
+		}
+		ref.err = err
+		return nil, err
+	}
+	ref.elfFile = ef
+	ref.transientFailures = 0
+	return ef, nil
+}
+
+// openELF opens the ELF file and checks that it has the expected identity.
+func (ref *Reference) openELF() (*File, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		ef, err := ref.OpenELF(ref.fileName)
+		if err != nil {
+			return nil, err
+		}
+		if ref.matchesExpected(ef) {
+			return ef, nil
+		}
+		ef.Close()
 	}
-	return ref.elfFile, err
+	return nil, fmt.Errorf("%s: %w", ref.fileName, ErrFileChanged)
 }
 
 // Close closes the File if it has been opened earlier.
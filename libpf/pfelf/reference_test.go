@@ -0,0 +1,130 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package pfelf
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/testsupport"
+)
+
+// countingOpener is an ELFOpener that counts the opened files.
+type countingOpener struct {
+	opened int
+}
+
+func (o *countingOpener) OpenELF(name string) (*File, error) {
+	o.opened++
+	return Open(name)
+}
+
+// failingOpener is an ELFOpener that fails with the queued errors before opening the files.
+type failingOpener struct {
+	countingOpener
+	errs []error
+}
+
+func (o *failingOpener) OpenELF(name string) (*File, error) {
+	if len(o.errs) > 0 {
+		o.opened++
+		err := o.errs[0]
+		o.errs = o.errs[1:]
+		return nil, err
+	}
+	return o.countingOpener.OpenELF(name)
+}
+
+func TestReferenceErrors(t *testing.T) {
+	exePath, err := testsupport.WriteSharedLibrary()
+	require.NoError(t, err)
+	defer os.Remove(exePath)
+
+	eio := &os.PathError{Op: "read", Path: exePath, Err: syscall.EIO}
+	assert.True(t, IsTransientError(fmt.Errorf("failed: %w", eio)))
+	assert.False(t, IsTransientError(os.ErrNotExist))
+	assert.False(t, IsTransientError(ErrNotELF))
+
+	// Transient errors are retried by the next call.
+	opener := &failingOpener{errs: []error{eio, eio}}
+	ref := NewReference(exePath, opener)
+	for i := 0; i < 2; i++ {
+		_, err = ref.GetELF()
+		assert.ErrorIs(t, err, syscall.EIO)
+	}
+	_, err = ref.GetELF()
+	require.NoError(t, err)
+	assert.Equal(t, 3, opener.opened)
+	ref.Close()
+
+	// Permanent errors are returned without opening the file again.
+	opener = &failingOpener{errs: []error{ErrNotELF}}
+	ref = NewReference(exePath, opener)
+	for i := 0; i < 2; i++ {
+		_, err = ref.GetELF()
+		assert.ErrorIs(t, err, ErrNotELF)
+	}
+	assert.Equal(t, 1, opener.opened)
+
+	// Transient errors become permanent after MaxTransientFailures attempts.
+	opener = &failingOpener{errs: []error{eio, eio, eio}}
+	ref = NewReference(exePath, opener)
+	for i := 0; i < MaxTransientFailures+1; i++ {
+		_, err = ref.GetELF()
+		assert.ErrorIs(t, err, syscall.EIO)
+	}
+	assert.Equal(t, MaxTransientFailures, opener.opened)
+}
+
+func TestReferenceIdentity(t *testing.T) {
+	exePath, err := testsupport.WriteSharedLibrary()
+	require.NoError(t, err)
+	defer os.Remove(exePath)
+
+	identity, err := StatIdentity(exePath)
+	require.NoError(t, err)
+
+	opener := &countingOpener{}
+	ref := NewReference(exePath, opener)
+	ref.SetExpectedIdentity(identity)
+	ef, err := ref.GetELF()
+	require.NoError(t, err)
+	fileIdentity, ok := ef.Identity()
+	assert.True(t, ok)
+	assert.Equal(t, identity, fileIdentity)
+	assert.Equal(t, 1, opener.opened)
+
+	// Replace the file at the same path, as done during a deployment.
+	replacement, err := testsupport.WriteSharedLibrary()
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(replacement, exePath))
+
+	// The cached File is the one that was opened before the replacement.
+	ef2, err := ref.GetELF()
+	require.NoError(t, err)
+	assert.Same(t, ef, ef2)
+	ref.Close()
+
+	// Opening again detects the replacement after retrying once.
+	_, err = ref.GetELF()
+	assert.ErrorIs(t, err, ErrFileChanged)
+	assert.Equal(t, 3, opener.opened)
+
+	// The Reference can be updated to the identity of the new file.
+	identity, err = StatIdentity(exePath)
+	require.NoError(t, err)
+	ref.SetExpectedIdentity(identity)
+	_, err = ref.GetELF()
+	require.NoError(t, err)
+	assert.Equal(t, 4, opener.opened)
+	ref.Close()
+}
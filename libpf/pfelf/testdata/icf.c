@@ -0,0 +1,27 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// The identical functions below are folded into one by the linker when building
+// with -ffunction-sections -Wl,--icf=all, so that their symbols share an address.
+
+__attribute__((noinline))
+static int local_twice(int x) {
+    return x * 2 + 1;
+}
+
+__attribute__((noinline))
+int global_twice_b(int x) {
+    return x * 2 + 1;
+}
+
+__attribute__((noinline))
+int global_twice_a(int x) {
+    return x * 2 + 1;
+}
+
+int main(int argc, char *argv[]) {
+    return local_twice(argc) + global_twice_a(argc) + global_twice_b(argc);
+}
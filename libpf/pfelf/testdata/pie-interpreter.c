@@ -0,0 +1,16 @@
+// A stand-in for an interpreter built as position independent executable. Like the
+// interpreters the agent supports, its state is found via the address of a symbol.
+#include <unistd.h>
+
+struct interp_state {
+  unsigned long magic;
+  unsigned long version;
+};
+
+struct interp_state interp_state = { 0x7265746572706e69UL, 3 };
+
+int main(void) {
+  // Wait to be inspected, until killed.
+  pause();
+  return (int)interp_state.version;
+}
@@ -0,0 +1,18 @@
+// A position independent executable with a table of pointers, which are set up by relative
+// relocations. It is built with the relocations packed into the RELR format, as done by
+// -z pack-relative-relocs, and with the traditional RELA format.
+#define TEN(i) &targets[i], &targets[i + 1], &targets[i + 2], &targets[i + 3], \
+  &targets[i + 4], &targets[i + 5], &targets[i + 6], &targets[i + 7], &targets[i + 8], \
+  &targets[i + 9]
+
+int targets[100];
+
+// Spans several RELR bitmaps. The null pointer leaves a gap in the relocated words.
+int *pointers[] = {
+  TEN(0), TEN(10), TEN(20), TEN(30), TEN(40), 0, TEN(50), TEN(60), TEN(70), TEN(80),
+  TEN(90),
+};
+
+int main(void) {
+  return *pointers[0];
+}
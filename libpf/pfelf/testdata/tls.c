@@ -0,0 +1,8 @@
+// Test source for a shared library accessing a thread-local variable with the
+// general dynamic TLS model, built without frame pointers.
+
+__thread void *current_context;
+
+void *get_current_context(void) {
+  return current_context;
+}
@@ -0,0 +1,24 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proc"
+)
+
+// ParentPID returns the PID of the parent of the process.
+func (sp *systemProcess) ParentPID() (libpf.PID, error) {
+	return proc.GetParentPID(sp.pid)
+}
+
+// SharesAddressSpace reports whether the process shares its address space with the process
+// pid, as it is the case for the child of vfork or of clone with CLONE_VM until it calls
+// execve.
+func (sp *systemProcess) SharesAddressSpace(pid libpf.PID) (bool, error) {
+	return proc.SharesAddressSpace(sp.pid, pid)
+}
@@ -16,6 +16,9 @@ import (
 
 const currentMachine = elf.EM_X86_64
 
+// compatMachine is the machine of the 32-bit executables the kernel runs natively.
+const compatMachine = elf.EM_386
+
 func (sp *ptraceProcess) getThreadInfo(tid int) (ThreadInfo, error) {
 	prStatus := make([]byte, 28*8)
 	if err := ptraceGetRegset(tid, int(elf.NT_PRSTATUS), prStatus); err != nil {
@@ -16,6 +16,9 @@ import (
 
 const currentMachine = elf.EM_AARCH64
 
+// compatMachine is the machine of the 32-bit executables the kernel runs natively.
+const compatMachine = elf.EM_ARM
+
 func (sp *ptraceProcess) GetMachineData() MachineData {
 	pacMask := make([]byte, 16)
 	_ = ptraceGetRegset(int(sp.pid), int(NT_ARM_PAC_MASK), pacMask)
@@ -0,0 +1,140 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+)
+
+// emulatorNames maps ELF machines to the architecture names used by the qemu-user
+// emulators, e.g. qemu-aarch64.
+var emulatorNames = map[elf.Machine]string{
+	elf.EM_386:     "i386",
+	elf.EM_X86_64:  "x86_64",
+	elf.EM_ARM:     "arm",
+	elf.EM_AARCH64: "aarch64",
+	elf.EM_PPC:     "ppc",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_S390:    "s390x",
+	elf.EM_MIPS:    "mips",
+	elf.EM_RISCV:   "riscv64",
+}
+
+// EmulatedArch returns the name of the architecture of machine as used by qemu-user.
+func EmulatedArch(machine elf.Machine) string {
+	if name, ok := emulatorNames[machine]; ok {
+		return name
+	}
+	return strings.ToLower(strings.TrimPrefix(machine.String(), "EM_"))
+}
+
+// EmulatedMachine returns the machine of the executables the process runs if it differs
+// from the machine of the host, which is the case for processes running under an emulator
+// like qemu-user. Emulators map the guest executables without execute permission, as the
+// guest code is translated before it runs, so all mappings are inspected. elf.EM_NONE is
+// returned for native processes, including 32-bit processes the kernel runs natively.
+func (sp *systemProcess) EmulatedMachine() (elf.Machine, error) {
+	mapsFile, err := os.Open(fmt.Sprintf("/proc/%d/maps", sp.pid))
+	if err != nil {
+		return elf.EM_NONE, err
+	}
+	defer mapsFile.Close()
+
+	mappings, err := parseMappingsFiltered(mapsFile, false)
+	if err != nil {
+		return elf.EM_NONE, err
+	}
+	return emulatedMachine(mappings, sp.remoteMemory), nil
+}
+
+// emulatedMachine returns the machine of the first ELF executable or shared library in
+// mappings that was built for a machine other than the host, or elf.EM_NONE if there is
+// none. The ELF headers are read from the memory rm of the process.
+func emulatedMachine(mappings []Mapping, rm remotememory.RemoteMemory) elf.Machine {
+	// Loaded ELF files have their segments mapped separately. Files mapped as a whole
+	// from their start, e.g. by linkers reading their input, are not loaded.
+	type fileKey struct{ device, inode uint64 }
+	loaded := make(map[fileKey]bool)
+	for i := range mappings {
+		if m := &mappings[i]; m.Inode != 0 && m.FileOffset != 0 {
+			loaded[fileKey{m.Device, m.Inode}] = true
+		}
+	}
+
+	// The header fields up to e_machine have the same layout in 32- and 64-bit ELF files.
+	var hdr [20]byte
+	for i := range mappings {
+		m := &mappings[i]
+		if m.FileOffset != 0 || !loaded[fileKey{m.Device, m.Inode}] ||
+			m.Length < uint64(len(hdr)) {
+			continue
+		}
+		if rm.Read(libpf.Address(m.Vaddr), hdr[:]) != nil ||
+			!bytes.Equal(hdr[:elf.EI_CLASS], []byte(elf.ELFMAG)) {
+			continue
+		}
+
+		var byteOrder binary.ByteOrder
+		switch elf.Data(hdr[elf.EI_DATA]) {
+		case elf.ELFDATA2LSB:
+			byteOrder = binary.LittleEndian
+		case elf.ELFDATA2MSB:
+			byteOrder = binary.BigEndian
+		default:
+			continue
+		}
+		fileType := elf.Type(byteOrder.Uint16(hdr[16:]))
+		if fileType != elf.ET_EXEC && fileType != elf.ET_DYN {
+			continue
+		}
+		if machine := elf.Machine(byteOrder.Uint16(hdr[18:])); machine != currentMachine &&
+			machine != compatMachine && machine != elf.EM_NONE {
+			return machine
+		}
+	}
+	return elf.EM_NONE
+}
+
+// CompatMachine returns the machine of the main executable of the process if it is a
+// 32-bit executable that the kernel runs natively in compat mode, e.g. an i386 executable
+// on x86_64. The register width and pointer size of such processes differ from those of
+// the host. elf.EM_NONE is returned for 64-bit processes.
+func (sp *systemProcess) CompatMachine() (elf.Machine, error) {
+	exe, err := os.Open(fmt.Sprintf("/proc/%d/exe", sp.pid))
+	if err != nil {
+		return elf.EM_NONE, err
+	}
+	defer exe.Close()
+	return readCompatMachine(exe)
+}
+
+// readCompatMachine returns the machine of the ELF file r if it is of the ELFCLASS32 class
+// and built for compatMachine, or elf.EM_NONE otherwise.
+func readCompatMachine(r io.ReaderAt) (elf.Machine, error) {
+	var hdr [20]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return elf.EM_NONE, err
+	}
+	if !bytes.Equal(hdr[:elf.EI_CLASS], []byte(elf.ELFMAG)) ||
+		elf.Class(hdr[elf.EI_CLASS]) != elf.ELFCLASS32 ||
+		elf.Data(hdr[elf.EI_DATA]) != elf.ELFDATA2LSB {
+		return elf.EM_NONE, nil
+	}
+	if machine := elf.Machine(binary.LittleEndian.Uint16(hdr[18:])); machine == compatMachine {
+		return machine, nil
+	}
+	return elf.EM_NONE, nil
+}
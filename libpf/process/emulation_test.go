@@ -0,0 +1,85 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+)
+
+// putELFHeader writes the start of an ELF header of fileType for machine to memory at off.
+func putELFHeader(memory []byte, off int, fileType elf.Type, machine elf.Machine) {
+	copy(memory[off:], elf.ELFMAG)
+	memory[off+elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	memory[off+elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	binary.LittleEndian.PutUint16(memory[off+16:], uint16(fileType))
+	binary.LittleEndian.PutUint16(memory[off+18:], uint16(machine))
+}
+
+func TestEmulatedMachine(t *testing.T) {
+	foreign := elf.EM_AARCH64
+	if currentMachine == elf.EM_AARCH64 {
+		foreign = elf.EM_X86_64
+	}
+
+	memory := make([]byte, 0x5000)
+	putELFHeader(memory, 0x1000, elf.ET_DYN, currentMachine)
+	putELFHeader(memory, 0x2000, elf.ET_REL, foreign)
+	putELFHeader(memory, 0x3000, elf.ET_DYN, foreign)
+	putELFHeader(memory, 0x4000, elf.ET_EXEC, foreign)
+	rm := remotememory.RemoteMemory{ReaderAt: bytes.NewReader(memory)}
+
+	native := []Mapping{
+		{Vaddr: 0x1000, Length: 0x1000, Device: 1, Inode: 1},
+		{Vaddr: 0x1800, Length: 0x800, Device: 1, Inode: 1, FileOffset: 0x1000},
+		// Object files are not loaded.
+		{Vaddr: 0x2000, Length: 0x1000, Device: 1, Inode: 2},
+		{Vaddr: 0x2800, Length: 0x800, Device: 1, Inode: 2, FileOffset: 0x1000},
+		// Files mapped as a whole are not loaded.
+		{Vaddr: 0x3000, Length: 0x1000, Device: 1, Inode: 3},
+	}
+	assert.Equal(t, elf.EM_NONE, emulatedMachine(native, rm))
+
+	emulated := append(native,
+		Mapping{Vaddr: 0x4000, Length: 0x800, Device: 1, Inode: 4},
+		Mapping{Vaddr: 0x4800, Length: 0x800, Device: 1, Inode: 4, FileOffset: 0x1000})
+	assert.Equal(t, foreign, emulatedMachine(emulated, rm))
+
+	// 32-bit executables of the host architecture are not emulated.
+	putELFHeader(memory, 0x4000, elf.ET_EXEC, compatMachine)
+	assert.Equal(t, elf.EM_NONE, emulatedMachine(emulated, rm))
+}
+
+func TestReadCompatMachine(t *testing.T) {
+	header := make([]byte, 64)
+	putELFHeader(header, 0, elf.ET_EXEC, compatMachine)
+	machine, err := readCompatMachine(bytes.NewReader(header))
+	require.NoError(t, err)
+	// 64-bit executables are native.
+	assert.Equal(t, elf.EM_NONE, machine)
+
+	header[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	machine, err = readCompatMachine(bytes.NewReader(header))
+	require.NoError(t, err)
+	assert.Equal(t, compatMachine, machine)
+
+	_, err = readCompatMachine(bytes.NewReader(header[:8]))
+	assert.Error(t, err)
+}
+
+func TestEmulatedArch(t *testing.T) {
+	assert.Equal(t, "aarch64", EmulatedArch(elf.EM_AARCH64))
+	assert.Equal(t, "x86_64", EmulatedArch(elf.EM_X86_64))
+	assert.Equal(t, "sparcv9", EmulatedArch(elf.EM_SPARCV9))
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/syndtr/gocapability/capability"
+)
+
+// HidePID modes of the proc file system, see proc(5).
+const (
+	// HidePIDOff gives everybody access to all /proc/<pid> directories.
+	HidePIDOff = 0
+	// HidePIDNoAccess denies access to the files in the /proc/<pid> directories of the
+	// processes of other users.
+	HidePIDNoAccess = 1
+	// HidePIDInvisible additionally hides the /proc/<pid> directories of the processes of
+	// other users.
+	HidePIDInvisible = 2
+	// HidePIDPtraceable hides the /proc/<pid> directories of the processes that can not be
+	// traced.
+	HidePIDPtraceable = 4
+)
+
+// hidePIDModes maps the names of the hidepid modes to their values.
+var hidePIDModes = map[string]int{
+	"off":        HidePIDOff,
+	"noaccess":   HidePIDNoAccess,
+	"invisible":  HidePIDInvisible,
+	"ptraceable": HidePIDPtraceable,
+}
+
+// ProcMountOptions holds the options of the /proc mount that restrict the access to the
+// /proc/<pid> directories of the processes of other users.
+type ProcMountOptions struct {
+	// HidePID is the hidepid mode of the mount.
+	HidePID int
+	// GID is the group whose members are exempt from the hidepid restrictions, or -1 if
+	// the mount has no gid option.
+	GID int
+}
+
+// parseProcMountOptions returns the options of the /proc mount from r, which lists the
+// mounts in the format of /proc/self/mounts.
+func parseProcMountOptions(r io.Reader) (ProcMountOptions, error) {
+	opts := ProcMountOptions{GID: -1}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Each line holds the source, mount point, type, options, dump and pass fields.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[1] != "/proc" || fields[2] != "proc" {
+			continue
+		}
+		// Later mounts on /proc hide the earlier ones, so the last one is used.
+		opts = ProcMountOptions{GID: -1}
+		for _, option := range strings.Split(fields[3], ",") {
+			name, value, _ := strings.Cut(option, "=")
+			switch name {
+			case "hidepid":
+				mode, ok := hidePIDModes[value]
+				if !ok {
+					var err error
+					if mode, err = strconv.Atoi(value); err != nil {
+						return ProcMountOptions{}, fmt.Errorf("invalid hidepid option %s",
+							value)
+					}
+				}
+				opts.HidePID = mode
+			case "gid":
+				gid, err := strconv.Atoi(value)
+				if err != nil {
+					return ProcMountOptions{}, fmt.Errorf("invalid gid option %s", value)
+				}
+				opts.GID = gid
+			}
+		}
+	}
+	return opts, scanner.Err()
+}
+
+// GetProcMountOptions returns the options of the /proc mount of the agent.
+func GetProcMountOptions() (ProcMountOptions, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return ProcMountOptions{}, err
+	}
+	defer f.Close()
+	return parseProcMountOptions(f)
+}
+
+// EnsureProcAccess checks that the hidepid option of the /proc mount does not keep the
+// agent from inspecting the processes of other users. Processes with CAP_SYS_PTRACE and,
+// unless the mode is HidePIDPtraceable, the members of the group of the gid option are
+// exempt from the restriction. If the agent is not, but has CAP_SETGID, it joins the group.
+// An error describing what the agent needs is returned if the restriction applies to it.
+func EnsureProcAccess() error {
+	opts, err := GetProcMountOptions()
+	if err != nil {
+		return fmt.Errorf("failed to read the options of /proc: %v", err)
+	}
+	if opts.HidePID == HidePIDOff {
+		return nil
+	}
+
+	caps, err := capability.NewPid2(0)
+	if err != nil {
+		return fmt.Errorf("failed to get capabilities: %v", err)
+	}
+	if err = caps.Load(); err != nil {
+		return fmt.Errorf("failed to load capabilities: %v", err)
+	}
+	if caps.Get(capability.EFFECTIVE, capability.CAP_SYS_PTRACE) {
+		return nil
+	}
+
+	if opts.GID >= 0 && opts.HidePID != HidePIDPtraceable {
+		groups, err := os.Getgroups()
+		if err != nil {
+			return fmt.Errorf("failed to get groups: %v", err)
+		}
+		if os.Getegid() == opts.GID || slices.Contains(groups, opts.GID) {
+			return nil
+		}
+		if caps.Get(capability.EFFECTIVE, capability.CAP_SETGID) {
+			if err = syscall.Setgroups(append(groups, opts.GID)); err == nil {
+				return nil
+			}
+		}
+	}
+
+	if opts.HidePID == HidePIDPtraceable {
+		return fmt.Errorf("/proc is mounted with hidepid=%d, which denies the agent access "+
+			"to the processes it can not trace, so that they can not be symbolized. Grant "+
+			"the agent CAP_SYS_PTRACE", opts.HidePID)
+	}
+	return fmt.Errorf("/proc is mounted with hidepid=%d, which denies the agent access to "+
+		"the processes of other users, so that they can not be symbolized. Grant the agent "+
+		"CAP_SYS_PTRACE, or mount /proc with the gid=<group> option and run the agent as "+
+		"member of that group", opts.HidePID)
+}
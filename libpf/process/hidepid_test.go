@@ -0,0 +1,53 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcMountOptions(t *testing.T) {
+	tests := map[string]struct {
+		mounts   string
+		expected ProcMountOptions
+	}{
+		"no restriction": {
+			mounts:   "proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\n",
+			expected: ProcMountOptions{HidePID: HidePIDOff, GID: -1},
+		},
+		"numeric mode": {
+			mounts: "sysfs /sys sysfs rw,nosuid 0 0\n" +
+				"proc /proc proc rw,nosuid,relatime,hidepid=2,gid=1001 0 0\n",
+			expected: ProcMountOptions{HidePID: HidePIDInvisible, GID: 1001},
+		},
+		"named mode": {
+			mounts:   "proc /proc proc rw,relatime,hidepid=ptraceable 0 0\n",
+			expected: ProcMountOptions{HidePID: HidePIDPtraceable, GID: -1},
+		},
+		"last mount": {
+			mounts: "proc /proc proc rw,hidepid=invisible 0 0\n" +
+				"proc /host/proc proc rw 0 0\n" +
+				"proc /proc proc rw,hidepid=noaccess 0 0\n",
+			expected: ProcMountOptions{HidePID: HidePIDNoAccess, GID: -1},
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			opts, err := parseProcMountOptions(strings.NewReader(test.mounts))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, opts)
+		})
+	}
+
+	_, err := parseProcMountOptions(strings.NewReader("proc /proc proc rw,hidepid=all 0 0\n"))
+	assert.Error(t, err)
+}
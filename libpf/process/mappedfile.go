@@ -0,0 +1,131 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+)
+
+// mappedFile implements io.ReaderAt to read the contents of a file from its memory
+// mappings in a process. Only the parts of the file that are mapped are available.
+// For private writable mappings, the data may have been modified by the process
+// (e.g. relocations).
+type mappedFile struct {
+	rm remotememory.RemoteMemory
+
+	// mappings are the mappings of the file sorted by file offset
+	mappings []Mapping
+}
+
+// ReadAt implements the io.ReaderAt interface. Reads spanning several consecutive
+// mappings are supported.
+func (mf *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := uint64(off) + uint64(n)
+		idx := sort.Search(len(mf.mappings), func(i int) bool {
+			m := &mf.mappings[i]
+			return m.FileOffset+m.Length > pos
+		})
+		if idx >= len(mf.mappings) || mf.mappings[idx].FileOffset > pos {
+			return n, fmt.Errorf("file offset %#x is not mapped", pos)
+		}
+		m := &mf.mappings[idx]
+		chunk := p[n:]
+		if avail := m.FileOffset + m.Length - pos; uint64(len(chunk)) > avail {
+			chunk = chunk[:avail]
+		}
+		if _, err := mf.rm.ReadAt(chunk, int64(m.Vaddr+pos-m.FileOffset)); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// fileMappings returns all mappings, executable or not, of the file backing m. If the
+// same file offset is mapped more than once, the mapping with the lowest address is used.
+func (sp *systemProcess) fileMappings(m *Mapping) (mapped []Mapping, hasMusl bool,
+	err error) {
+	mapsFile, err := os.Open(fmt.Sprintf("/proc/%d/maps", sp.pid))
+	if err != nil {
+		return nil, false, err
+	}
+	defer mapsFile.Close()
+
+	mappings, err := parseMappingsFiltered(mapsFile, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	offsets := make(map[uint64]struct{})
+	for i := range mappings {
+		fm := &mappings[i]
+		if strings.Contains(fm.Path, "/ld-musl-") {
+			hasMusl = true
+		}
+		if fm.Device != m.Device || fm.Inode != m.Inode || fm.Inode == 0 {
+			continue
+		}
+		if _, ok := offsets[fm.FileOffset]; ok {
+			continue
+		}
+		offsets[fm.FileOffset] = struct{}{}
+		mapped = append(mapped, *fm)
+	}
+	sort.Slice(mapped, func(i, j int) bool {
+		return mapped[i].FileOffset < mapped[j].FileOffset
+	})
+	return mapped, hasMusl, nil
+}
+
+// openMappedELF reconstructs the ELF file backing the mapping m from the memory mappings
+// of the file. This allows inspecting executables that have been deleted from disk
+// while they are still mapped, e.g. after an upgrade. The ELF header is validated
+// before the data is used. As the section headers are usually not mapped, only the
+// information available from the program headers can be accessed.
+func (sp *systemProcess) openMappedELF(m *Mapping) (*pfelf.File, error) {
+	mappings, hasMusl, err := sp.fileMappings(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 || mappings[0].FileOffset != 0 {
+		return nil, fmt.Errorf("ELF header of %s is not mapped", m.Path)
+	}
+
+	ef, err := pfelf.NewFile(&mappedFile{rm: sp.remoteMemory, mappings: mappings},
+		mappings[0].Vaddr, hasMusl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ELF mapped from %s: %v", m.Path, err)
+	}
+	if ef.Type != elf.ET_EXEC && ef.Type != elf.ET_DYN {
+		return nil, fmt.Errorf("unexpected ELF type %v mapped from %s", ef.Type, m.Path)
+	}
+	if ef.Machine != currentMachine {
+		return nil, fmt.Errorf("unexpected ELF machine %v mapped from %s",
+			ef.Machine, m.Path)
+	}
+	return ef, nil
+}
+
+// mappedBuildID returns the GNU build ID of the ELF file backing the mapping m as read
+// from the memory of the process.
+func (sp *systemProcess) mappedBuildID(m *Mapping) (string, error) {
+	ef, err := sp.openMappedELF(m)
+	if err != nil {
+		return "", err
+	}
+	defer ef.Close()
+	return ef.GetBuildID()
+}
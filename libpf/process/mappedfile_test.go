@@ -0,0 +1,90 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package process
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+)
+
+func TestMappedFile(t *testing.T) {
+	memory := make([]byte, 0x300)
+	for i := range memory {
+		memory[i] = byte(i)
+	}
+	mf := &mappedFile{
+		rm: remotememory.RemoteMemory{ReaderAt: bytes.NewReader(memory)},
+		mappings: []Mapping{
+			{Vaddr: 0x100, FileOffset: 0x00, Length: 0x10},
+			{Vaddr: 0x200, FileOffset: 0x10, Length: 0x10},
+		},
+	}
+
+	buf := make([]byte, 8)
+	n, err := mf.ReadAt(buf, 0x4)
+	require.NoError(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, memory[0x104:0x10c], buf)
+
+	// Reads spanning both mappings
+	buf = make([]byte, 0x10)
+	_, err = mf.ReadAt(buf, 0x8)
+	require.NoError(t, err)
+	assert.Equal(t, append(append([]byte{}, memory[0x108:0x110]...), memory[0x200:0x208]...),
+		buf)
+
+	// Reads beyond the mapped parts of the file
+	n, err = mf.ReadAt(buf, 0x18)
+	assert.Error(t, err)
+	assert.Equal(t, 8, n)
+	_, err = mf.ReadAt(buf, 0x40)
+	assert.Error(t, err)
+}
+
+func TestOpenMappedELFOfSelf(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	exe, err = filepath.EvalSymlinks(exe)
+	require.NoError(t, err)
+
+	pr := New(libpf.PID(os.Getpid())).(*systemProcess)
+	mappings, err := pr.GetMappings()
+	require.NoError(t, err)
+
+	var exeMapping *Mapping
+	for i := range mappings {
+		if mappings[i].Path == exe {
+			exeMapping = &mappings[i]
+			break
+		}
+	}
+	require.NotNil(t, exeMapping, "mapping of %s not found", exe)
+
+	memELF, err := pr.openMappedELF(exeMapping)
+	require.NoError(t, err)
+	defer memELF.Close()
+	diskELF, err := pfelf.Open(exe)
+	require.NoError(t, err)
+	defer diskELF.Close()
+
+	assert.True(t, memELF.InsideCore)
+	assert.Equal(t, diskELF.Type, memELF.Type)
+	assert.Equal(t, diskELF.Entry, memELF.Entry)
+	require.Equal(t, len(diskELF.Progs), len(memELF.Progs))
+	for i := range diskELF.Progs {
+		assert.Equal(t, diskELF.Progs[i].ProgHeader, memELF.Progs[i].ProgHeader)
+	}
+}
@@ -63,6 +63,12 @@ func trimMappingPath(path string) string {
 }
 
 func parseMappings(mapsFile io.Reader) ([]Mapping, error) {
+	return parseMappingsFiltered(mapsFile, true)
+}
+
+// parseMappingsFiltered parses the mappings from mapsFile. If executableOnly is set,
+// only the executable mappings are returned.
+func parseMappingsFiltered(mapsFile io.Reader, executableOnly bool) ([]Mapping, error) {
 	mappings := make([]Mapping, 0)
 	scanner := bufio.NewScanner(mapsFile)
 	buf := make([]byte, 512)
@@ -96,7 +102,7 @@ func parseMappings(mapsFile io.Reader) ([]Mapping, error) {
 		}
 
 		// Ignore non-executable mappings
-		if flags&elf.PF_X == 0 {
+		if executableOnly && flags&elf.PF_X == 0 {
 			continue
 		}
 		inode := libpf.DecToUint64(fields[4])
@@ -215,7 +221,15 @@ func (sp *systemProcess) CalculateMappingFileID(m *Mapping) (libpf.FileID, error
 		vdsoFileID, err = pfelf.CalculateIDFromReader(vdso)
 		return vdsoFileID, err
 	}
-	return pfelf.CalculateID(sp.GetMappingFile(m))
+	fileID, err := pfelf.CalculateID(sp.GetMappingFile(m))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// The mapped file is not accessible, e.g. due to missing permissions for
+		// map_files. Identify it by the build ID read from memory instead.
+		if buildID, buildIDErr := sp.mappedBuildID(m); buildIDErr == nil {
+			return pfelf.CalculateIDFromBuildID(buildID), nil
+		}
+	}
+	return fileID, err
 }
 
 func (sp *systemProcess) OpenELF(file string) (*pfelf.File, error) {
@@ -235,5 +249,15 @@ func (sp *systemProcess) OpenELF(file string) (*pfelf.File, error) {
 	}
 
 	// Fall back to opening the file using the process specific root
-	return pfelf.Open(fmt.Sprintf("/proc/%v/root/%s", sp.pid, file))
+	ef, err := pfelf.Open(fmt.Sprintf("/proc/%v/root/%s", sp.pid, file))
+	if err != nil && errors.Is(err, os.ErrNotExist) {
+		// The file might have been deleted while it is still mapped. Reconstruct it
+		// from the mapped parts of the file.
+		if m, ok := sp.fileToMapping[file]; ok {
+			if memELF, memErr := sp.openMappedELF(m); memErr == nil {
+				return memELF, nil
+			}
+		}
+	}
+	return ef, err
 }
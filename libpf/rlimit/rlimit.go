@@ -7,6 +7,7 @@
 package rlimit
 
 import (
+	"errors"
 	"fmt"
 
 	"golang.org/x/sys/unix"
@@ -16,6 +17,8 @@ import (
 
 // MaximizeMemlock updates the memlock resource limit to RLIM_INFINITY.
 // It returns a function to reset the resource limit to its original value or an error.
+// Raising the limit requires CAP_SYS_RESOURCE. Without it, the limit is kept on kernels
+// that account the memory of eBPF maps and programs to the memory cgroup instead.
 func MaximizeMemlock() (func(), error) {
 	var oldLimit unix.Rlimit
 	tmpLimit := unix.Rlimit{
@@ -24,6 +27,9 @@ func MaximizeMemlock() (func(), error) {
 	}
 
 	if err := unix.Prlimit(0, unix.RLIMIT_MEMLOCK, &tmpLimit, &oldLimit); err != nil {
+		if errors.Is(err, unix.EPERM) && memcgAccounting() {
+			return func() {}, nil
+		}
 		return nil, fmt.Errorf("failed to set temporary rlimit: %w", err)
 	}
 
@@ -33,3 +39,19 @@ func MaximizeMemlock() (func(), error) {
 		}
 	}, nil
 }
+
+// memcgAccounting checks whether the running kernel accounts the memory of eBPF maps and
+// programs to the memory cgroup, so that the memlock limit does not apply. This is the case
+// since Linux 5.11.
+func memcgAccounting() bool {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return false
+	}
+	var major, minor int
+	release := unix.ByteSliceToString(uname.Release[:])
+	if _, err := fmt.Sscanf(release, "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	return major > 5 || (major == 5 && minor >= 11)
+}
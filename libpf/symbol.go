@@ -36,6 +36,30 @@ type Symbol struct {
 	Name    SymbolName
 	Address SymbolValue
 	Size    int
+	// Global is set if the symbol is visible outside of its object file, e.g. for symbols
+	// with global or weak binding in ELF files.
+	Global bool
+}
+
+// preferredOver reports whether s is preferred over other, which is located at the same
+// address, when translating an address to a symbol. Identical code folding (ICF) and
+// aliases can place several symbols at the same address. To get deterministic results,
+// symbols with a size are preferred over zero-size symbols, then global symbols over
+// local ones, and finally the lexicographically smaller name.
+func (s *Symbol) preferredOver(other *Symbol) bool {
+	if (s.Size != 0) != (other.Size != 0) {
+		return s.Size != 0
+	}
+	if s.Global != other.Global {
+		return s.Global
+	}
+	return s.Name < other.Name
+}
+
+// contains reports whether val is covered by the symbol. Zero-size symbols extend up to
+// the next symbol.
+func (s *Symbol) contains(val SymbolValue) bool {
+	return val >= s.Address && (s.Size == 0 || val < s.Address+SymbolValue(s.Size))
 }
 
 var _ SymbolFinder = &SymbolMap{}
@@ -46,6 +70,17 @@ type SymbolMap struct {
 	addressToSymbol []Symbol
 }
 
+// NewSymbolMap returns a finalized SymbolMap holding the given symbols. This allows
+// constructing symbol data in memory, e.g. in tests.
+func NewSymbolMap(symbols ...Symbol) *SymbolMap {
+	symmap := &SymbolMap{}
+	for _, s := range symbols {
+		symmap.Add(s)
+	}
+	symmap.Finalize()
+	return symmap
+}
+
 // Add a symbol to the map
 func (symmap *SymbolMap) Add(s Symbol) {
 	symmap.addressToSymbol = append(symmap.addressToSymbol, s)
@@ -56,7 +91,11 @@ func (symmap *SymbolMap) Add(s Symbol) {
 func (symmap *SymbolMap) Finalize() {
 	sort.Slice(symmap.addressToSymbol,
 		func(i, j int) bool {
-			return symmap.addressToSymbol[i].Address > symmap.addressToSymbol[j].Address
+			a, b := &symmap.addressToSymbol[i], &symmap.addressToSymbol[j]
+			if a.Address != b.Address {
+				return a.Address > b.Address
+			}
+			return a.preferredOver(b)
 		})
 	symmap.nameToSymbol = make(map[SymbolName]*Symbol, len(symmap.addressToSymbol))
 	for i, s := range symmap.addressToSymbol {
@@ -82,19 +121,23 @@ func (symmap *SymbolMap) LookupSymbolAddress(symbolName SymbolName) (SymbolValue
 }
 
 // LookupByAddress translates the address to a symbolic information. Return empty string and
-// absolute address if it did not match any symbol.
+// absolute address if it did not match any symbol. If several symbols covering the address
+// start at the same address, the tie is broken as documented in Symbol.preferredOver.
 func (symmap *SymbolMap) LookupByAddress(val SymbolValue) (SymbolName, Address, bool) {
 	i := sort.Search(len(symmap.addressToSymbol),
 		func(i int) bool {
 			return val >= symmap.addressToSymbol[i].Address
 		})
-	if i < len(symmap.addressToSymbol) &&
-		(symmap.addressToSymbol[i].Size == 0 ||
-			val < symmap.addressToSymbol[i].Address+
-				SymbolValue(symmap.addressToSymbol[i].Size)) {
-		return symmap.addressToSymbol[i].Name,
-			Address(val - symmap.addressToSymbol[i].Address),
-			true
+	// Symbols at the same address are sorted by preference, so the first one covering
+	// the address is the preferred one.
+	for j := i; j < len(symmap.addressToSymbol); j++ {
+		sym := &symmap.addressToSymbol[j]
+		if sym.Address != symmap.addressToSymbol[i].Address {
+			break
+		}
+		if sym.contains(val) {
+			return sym.Name, Address(val - sym.Address), true
+		}
 	}
 	return SymbolNameUnknown, Address(val), false
 }
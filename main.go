@@ -9,15 +9,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/sys/unix"
 
+	debuglog "github.com/elastic/otel-profiling-agent/debug/log"
+	debugserver "github.com/elastic/otel-profiling-agent/debug/server"
 	"github.com/elastic/otel-profiling-agent/host"
 	hostmeta "github.com/elastic/otel-profiling-agent/hostmetadata/host"
+	"github.com/elastic/otel-profiling-agent/interpreter"
 	"github.com/elastic/otel-profiling-agent/tracehandler"
 
 	"github.com/elastic/otel-profiling-agent/hostmetadata"
@@ -32,8 +38,13 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/elastic/otel-profiling-agent/libpf"
 	"github.com/elastic/otel-profiling-agent/libpf/memorydebug"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/process"
 	"github.com/elastic/otel-profiling-agent/libpf/vc"
+	"github.com/elastic/otel-profiling-agent/pidfilter"
+	"github.com/elastic/otel-profiling-agent/rawdump"
 )
 
 // Short copyright / license text for eBPF code
@@ -65,15 +76,21 @@ const (
 	exitParseError exitCode = 2
 )
 
+// startTraceHandling starts the processing of traces. The returned channel is closed
+// once the traces sampled before ctx is done have been reported.
+// If dump is not nil, the traces are added to it before they are processed.
 func startTraceHandling(ctx context.Context, rep reporter.TraceReporter,
-	times *config.Times, trc *tracer.Tracer) error {
+	times *config.Times, trc *tracer.Tracer, dump *rawdump.Writer) (<-chan struct{}, error) {
 	// Spawn monitors for the various result maps
 	traceCh := make(chan *host.Trace)
 
 	if err := trc.StartMapMonitors(ctx, traceCh); err != nil {
-		return fmt.Errorf("failed to start map monitors: %v", err)
+		return nil, fmt.Errorf("failed to start map monitors: %v", err)
 	}
 
+	if dump != nil {
+		traceCh = dump.Tee(traceCh)
+	}
 	return tracehandler.Start(ctx, rep, trc, traceCh, times)
 }
 
@@ -109,6 +126,19 @@ func mainWithExitCode() exitCode {
 		return exitParseError
 	}
 
+	sampleWeights, sampleWeightFactor, err := config.ParseSampleWeights(argPIDSampleWeights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid pid-sample-weight: %v\n", err)
+		return exitParseError
+	}
+	if sampleWeightFactor > 1 {
+		// The samples of all processes are counted in samples of the raised frequency, so
+		// that it is used throughout, e.g. for the sampling period of the profiles.
+		argSamplesPerSecond *= int(sampleWeightFactor)
+		log.Infof("Raising the sampling frequency to %d Hz for the sample weights of %d "+
+			"processes", argSamplesPerSecond, len(sampleWeights))
+	}
+
 	// Context to drive main goroutine and the Tracer monitors.
 	mainCtx, mainCancel := signal.NotifyContext(context.Background(),
 		unix.SIGINT, unix.SIGTERM, unix.SIGABRT)
@@ -127,6 +157,121 @@ func mainWithExitCode() exitCode {
 		return exitParseError
 	}
 
+	if argSelfThrottleThreshold < 0 || argSelfThrottleThreshold > 1 {
+		fmt.Fprintf(os.Stderr, "Invalid argument for self-throttle-threshold: use "+
+			"a value between 0 and 1")
+		return exitParseError
+	}
+
+	if argELFMaxBufferSize == 0 {
+		fmt.Fprintf(os.Stderr, "Invalid argument for elf-max-buffer-size: use "+
+			"a value greater than 0")
+		return exitParseError
+	}
+	pfelf.SetMaxBufferSize(argELFMaxBufferSize)
+
+	var tpbaseBounds tracer.TPBaseOffsetBounds
+	if argTPBaseOffsetBounds != "" {
+		if tpbaseBounds, err = tracer.ParseTPBaseOffsetBounds(argTPBaseOffsetBounds); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid argument for tpbase-offset-bounds: %v", err)
+			return exitParseError
+		}
+	}
+
+	if argPID != 0 && argPIDFilter != "" {
+		fmt.Fprintf(os.Stderr, "Invalid argument for pid: can not be combined with pid-filter")
+		return exitParseError
+	}
+	if argPID != 0 && argPyroscopeURL != "" {
+		fmt.Fprintf(os.Stderr, "Invalid argument for pyroscope-url: can not be combined "+
+			"with pid")
+		return exitParseError
+	}
+	if argDuration != 0 {
+		if argPID == 0 || argDuration < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid argument for duration: requires pid and "+
+				"a positive duration")
+			return exitParseError
+		}
+		// Profiling stops when the duration elapsed as if the agent was interrupted.
+		mainCtx, mainCancel = context.WithTimeout(mainCtx, argDuration)
+		defer mainCancel()
+	}
+
+	perfEvent, err := tracer.ParsePerfEvent(argPerfEvent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid argument for perf-event: use one of %s",
+			strings.Join(tracer.PerfEventNames(), ", "))
+		return exitParseError
+	}
+	if argAlignedSampling && perfEvent != tracer.PerfEventCPUClock {
+		fmt.Fprintf(os.Stderr, "Invalid argument for aligned-sampling: requires "+
+			"perf-event %s", tracer.PerfEventCPUClock)
+		return exitParseError
+	}
+	if argCPUTimeWeights && perfEvent != tracer.PerfEventTaskClock {
+		fmt.Fprintf(os.Stderr, "Invalid argument for cpu-time-weights: requires "+
+			"perf-event %s", tracer.PerfEventTaskClock)
+		return exitParseError
+	}
+	if argIdleBackoff && perfEvent != tracer.PerfEventCPUClock {
+		// Only the CPU clock keeps interrupting idle CPUs, which is what is measured.
+		fmt.Fprintf(os.Stderr, "Invalid argument for idle-backoff: requires "+
+			"perf-event %s", tracer.PerfEventCPUClock)
+		return exitParseError
+	}
+	if argSelfThrottleThreshold > 0 && perfEvent == tracer.PerfEventMajorFaults {
+		// Major faults are sampled on each occurrence, so their frequency can not be lowered.
+		fmt.Fprintf(os.Stderr, "Invalid argument for self-throttle-threshold: not "+
+			"supported with perf-event %s", perfEvent)
+		return exitParseError
+	}
+	if argIdleBackoff && argSelfThrottleThreshold > 0 {
+		fmt.Fprintf(os.Stderr, "Invalid argument for idle-backoff: can not be combined "+
+			"with self-throttle-threshold")
+		return exitParseError
+	}
+	// eventSetTypes holds the sample types of the profiles of the event sets. It is only
+	// set if a secondary event set is sampled or major faults are sampled, so that a single
+	// CPU profile is reported as before otherwise.
+	var eventSetTypes []reporter.EventSetType
+	var secondaryPerfEvent tracer.PerfEvent
+	if argSecondaryPerfEvent != "" {
+		secondaryPerfEvent, err = tracer.ParsePerfEvent(argSecondaryPerfEvent)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid argument for secondary-perf-event: use one "+
+				"of %s", strings.Join(tracer.PerfEventNames(), ", "))
+			return exitParseError
+		}
+		if argSecondarySamplesPerSec <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid argument for secondary-samples-per-second: "+
+				"requires a positive frequency")
+			return exitParseError
+		}
+		if argPID != 0 {
+			fmt.Fprintf(os.Stderr, "Invalid argument for secondary-perf-event: not "+
+				"supported when profiling a single process")
+			return exitParseError
+		}
+		secondaryType := eventSetType(secondaryPerfEvent)
+		if secondaryPerfEvent == perfEvent {
+			secondaryType.Type += "-secondary"
+		}
+		eventSetTypes = []reporter.EventSetType{eventSetType(perfEvent), secondaryType}
+	} else if perfEvent == tracer.PerfEventMajorFaults {
+		// The samples of major faults are not CPU samples, which their type has to tell.
+		eventSetTypes = []reporter.EventSetType{eventSetType(perfEvent)}
+	}
+
+	switch argLogFormat {
+	case "text":
+	case "json":
+		debuglog.SetJSONFormatter(log.JSONFormatter{}, "")
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid argument for log-format: use 'text' or 'json'")
+		return exitParseError
+	}
+
 	if argVerboseMode {
 		log.SetLevel(log.DebugLevel)
 		// Dump the arguments in debug mode.
@@ -142,6 +287,24 @@ func mainWithExitCode() exitCode {
 	// 250m (only in debug builds, go build -tags debug).
 	memorydebug.Init(1024*1024*250, 1024*1024*150)
 
+	release, err := tracer.GetCurrentKernelRelease()
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get kernel version: %v", err)
+		log.Error(msg)
+		return exitFailure
+	}
+	entry, err := tracer.MatchKernelDenylist(release, argKernelDenylist)
+	if err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+	if entry != "" {
+		msg := fmt.Sprintf("Kernel %s matches '%s' of the kernel denylist, refusing to load "+
+			"eBPF programs. Use -kernel-denylist to override.", release, entry)
+		log.Error(msg)
+		return exitFailure
+	}
+
 	if !argNoKernelVersionCheck {
 		var major, minor, patch uint32
 		major, minor, patch, err = tracer.GetCurrentKernelVersion()
@@ -179,12 +342,24 @@ func mainWithExitCode() exitCode {
 		return exitFailure
 	}
 
+	if err = tracer.CheckCapabilities(); err != nil {
+		msg := fmt.Sprintf("Insufficient capabilities: %v", err)
+		log.Error(msg)
+		return exitFailure
+	}
+
 	if err = tracer.ProbeTracepoint(); err != nil {
 		msg := fmt.Sprintf("Failed to probe tracepoint: %v", err)
 		log.Error(msg)
 		return exitFailure
 	}
 
+	// The processes of other users can not be symbolized if /proc hides them, but the
+	// agent is still useful for its own processes and kernel frames.
+	if err = process.EnsureProcAccess(); err != nil {
+		log.Warn(err)
+	}
+
 	validatedTags := hostmeta.ValidateTags(argTags)
 	log.Debugf("Validated tags: %s", validatedTags)
 
@@ -232,6 +407,7 @@ func mainWithExitCode() exitCode {
 		UploadSymbols:          false,
 		BpfVerifierLogLevel:    argBpfVerifierLogLevel,
 		BpfVerifierLogSize:     argBpfVerifierLogSize,
+		BTFFile:                argBTFFile,
 		MonitorInterval:        argMonitorInterval,
 		ReportInterval:         argReporterInterval,
 		SamplesPerSecond:       uint16(argSamplesPerSecond),
@@ -246,6 +422,24 @@ func mainWithExitCode() exitCode {
 		KernelVersion:          hostMetadataMap[hostmeta.KeyKernelVersion],
 		ProbabilisticInterval:  argProbabilisticInterval,
 		ProbabilisticThreshold: argProbabilisticThreshold,
+		LabelCoreType:          argLabelCoreType,
+		LabelSyscall:           argLabelSyscall,
+		GroupByThread:          argGroupByThread,
+		StackDeltasDir:         argStackDeltasDir,
+		ProcessLabelEnvPrefix:  argProcessLabelEnvPrefix,
+		CommandLineLabelLen:    uint32(argCommandLineLabel),
+		CommandLineRedact:      splitPatterns(argCommandLineRedact),
+		MaxTrackedProcesses:    uint32(argMaxTrackedProcesses),
+		PIDSampleWeights:       sampleWeights,
+		KernelSymbolCacheSize:  uint32(argKernelSymbolCacheSize),
+		TPBaseMinOffset:        tpbaseBounds.Min,
+		TPBaseMaxOffset:        tpbaseBounds.Max,
+		ExcludeThreads:         splitPatterns(argExcludeThreads),
+		FramesOnly:             argFramesOnly,
+		UnsymbolizedFrames:     argUnsymbolizedFrames,
+		VerifyUnwindRate:       uint32(argVerifyUnwindRate),
+		MaxStackDepth:          uint32(argMaxStackDepth),
+		SymbolizationTimeout:   argSymbolizationTimeout,
 	}
 	if err = config.SetConfiguration(&conf); err != nil {
 		msg := fmt.Sprintf("Failed to set configuration: %s", err)
@@ -263,6 +457,14 @@ func mainWithExitCode() exitCode {
 		log.Error(msg)
 		return exitFailure
 	}
+	if argInterpreterOffsetsFile != "" {
+		offsetOverrides, err := interpreter.LoadOffsetOverrides(argInterpreterOffsetsFile)
+		if err != nil {
+			log.Errorf("Failed to load the interpreter offsets: %v", err)
+			return exitFailure
+		}
+		interpreter.SetOffsetOverrides(offsetOverrides)
+	}
 
 	if err = config.GenerateNewHostIDIfNecessary(); err != nil {
 		msg := fmt.Sprintf("Failed to generate new host ID: %s", err)
@@ -272,6 +474,10 @@ func mainWithExitCode() exitCode {
 
 	log.Infof("Assigned ProjectID: %d HostID: %d", config.ProjectID(), config.HostID())
 
+	// The session ID identifies this run of the agent in the exported profiles.
+	sessionID := uuid.New().String()
+	log.Infof("Session ID: %s", sessionID)
+
 	// Scale the queues that report traces or information related to traces
 	// with the number of CPUs, the reporting interval and the sample frequencies.
 	tracesQSize := max(1024,
@@ -289,10 +495,7 @@ func mainWithExitCode() exitCode {
 		}
 	}
 
-	// Network operations to CA start here
-	var rep reporter.Reporter
-	// Connect to the collection agent
-	rep, err = reporter.StartOTLP(mainCtx, &reporter.Config{
+	reporterConfig := &reporter.Config{
 		CollAgentAddr:           argCollAgentAddr,
 		MaxRPCMsgSize:           33554432, // 32 MiB
 		ExecMetadataMaxQueue:    1024,
@@ -303,14 +506,89 @@ func mainWithExitCode() exitCode {
 		HostMetadataMaxQueue:    2,
 		FallbackSymbolsMaxQueue: 1024,
 		DisableTLS:              argDisableTLS,
+		Headers:                 argOTLPHeaders,
+		Proxy:                   argReporterProxy,
 		MaxGRPCRetries:          5,
+		TrimFrames:              splitPatterns(argTrimFrames),
+		SessionID:               sessionID,
+		RootFrame:               argRootFrame,
+		LostSamplesFrame:        argLostSamplesFrame,
+		EventSetTypes:           eventSetTypes,
+		CPUTimeWeights:          argCPUTimeWeights,
+		PyroscopeURL:            argPyroscopeURL,
+		PyroscopeAppName:        argPyroscopeAppName,
+		FlameGraphDir:           argFlameGraphDir,
 		Times:                   times,
-	})
+	}
+
+	redactor, err := reporter.NewRedactor(splitRedactionRules(argRedactSymbols))
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse the redaction rules: %v", err)
+		log.Error(msg)
+		return exitFailure
+	}
+	sampleFilter, err := reporter.NewSampleFilter(splitPatterns(argSampleFilter))
+	if err != nil {
+		log.Errorf("Failed to parse the sample filter: %v", err)
+		return exitFailure
+	}
+
+	var mainRep reporter.Reporter
+	var pprofRep *reporter.PprofReporter
+	if argPID != 0 {
+		// Profiling a single process writes a pprof profile instead of
+		// sending the data to the collection agent.
+		if _, err = os.Stat(fmt.Sprintf("/proc/%d", argPID)); err != nil {
+			log.Errorf("Failed to find process %d: %v", argPID, err)
+			return exitFailure
+		}
+		pprofRep, err = reporter.NewPprofReporter(reporterConfig, argSamplesPerSecond)
+		mainRep = pprofRep
+	} else if argFlameGraphDir != "" {
+		mainRep, err = reporter.StartFlameGraph(mainCtx, reporterConfig)
+	} else if argPyroscopeURL != "" {
+		mainRep, err = reporter.StartPyroscope(mainCtx, reporterConfig, argSamplesPerSecond)
+	} else {
+		// Network operations to CA start here
+		// Connect to the collection agent
+		mainRep, err = reporter.StartOTLP(mainCtx, reporterConfig)
+	}
 	if err != nil {
 		msg := fmt.Sprintf("Failed to start reporting: %v", err)
 		log.Error(msg)
 		return exitFailure
 	}
+	// Additional sinks are added to the fan-out here.
+	reporters := []reporter.Reporter{mainRep}
+	var rawDump *rawdump.Writer
+	if argRawDump != "" {
+		if rawDump, err = rawdump.Create(argRawDump); err != nil {
+			log.Error(err)
+			return exitFailure
+		}
+		defer func() {
+			if err := rawDump.Close(); err != nil {
+				log.Errorf("Failed to close raw dump: %v", err)
+			}
+		}()
+		rawDumpFilter, err := reporter.NewSampleFilter(splitPatterns(argRawDumpSampleFilter))
+		if err == nil {
+			err = rawDump.SetFilter(rawDumpFilter)
+		}
+		if err != nil {
+			log.Errorf("Failed to set the raw dump sample filter: %v", err)
+			return exitFailure
+		}
+		// The dump records the executables reported by the process manager.
+		reporters = append(reporters, rawDump)
+	}
+	rep := reporter.NewRedactingMulti(redactor, reporters...)
+	rep.SetFilter(mainRep, sampleFilter)
+
+	if argDebugAddress != "" && pprofRep != nil {
+		log.Error("The debug endpoint requires reporting to a collection agent")
+		return exitFailure
+	}
 
 	metrics.SetReporter(rep)
 
@@ -349,14 +627,62 @@ func mainWithExitCode() exitCode {
 	metrics.Add(metrics.IDProcPIDStartupMs, metrics.MetricValue(time.Since(now).Milliseconds()))
 	log.Debug("Completed initial PID listing")
 
+	if argPID != 0 {
+		if err = trc.SetPIDFilter(libpf.Set[libpf.PID]{libpf.PID(argPID): {}}); err != nil {
+			msg := fmt.Sprintf("Failed to set PID filter: %v", err)
+			log.Error(msg)
+			return exitFailure
+		}
+		log.Infof("Profiling PID %d", argPID)
+	}
+
+	if argPIDFilter != "" {
+		err = pidfilter.Start(mainCtx, argPIDFilter, times.MonitorInterval(),
+			func(pids libpf.Set[libpf.PID]) {
+				if err := trc.SetPIDFilter(pids); err != nil {
+					log.Errorf("Failed to update PID filter: %v", err)
+					return
+				}
+				log.Infof("Updated PID filter with %d PIDs", len(pids))
+			})
+		if err != nil {
+			msg := fmt.Sprintf("Failed to start PID filter: %v", err)
+			log.Error(msg)
+			return exitFailure
+		}
+	}
+
 	// Attach our tracer to the perf event
-	if err := trc.AttachTracer(argSamplesPerSecond); err != nil {
+	if err := trc.AttachTracer(argSamplesPerSecond, perfEvent, argAlignedSampling); err != nil {
 		msg := fmt.Sprintf("Failed to attach to perf event: %v", err)
 		log.Error(msg)
 		return exitFailure
 	}
 	log.Info("Attached tracer program")
 
+	if argSecondaryPerfEvent != "" {
+		if err := trc.AttachSecondaryTracer(argSecondarySamplesPerSec,
+			secondaryPerfEvent); err != nil {
+			msg := fmt.Sprintf("Failed to attach to secondary perf event: %v", err)
+			log.Error(msg)
+			return exitFailure
+		}
+		log.Infof("Attached tracer program to secondary perf event %s", secondaryPerfEvent)
+	}
+
+	if argDebugAddress != "" {
+		// The debug endpoint reconfigures the perf events, so it is started once they exist.
+		modes := map[string]libpf.EventSet{"primary": libpf.PrimaryEventSet}
+		if argSecondaryPerfEvent != "" {
+			modes["secondary"] = libpf.SecondaryEventSet
+		}
+		ctrl := debugserver.NewController(trc, modes, newEffectiveConfig(&conf))
+		if err = debugserver.Start(mainCtx, argDebugAddress, rep, ctrl); err != nil {
+			log.Error(err)
+			return exitFailure
+		}
+	}
+
 	if argProbabilisticThreshold < tracer.ProbabilisticThresholdMax {
 		trc.StartProbabilisticProfiling(mainCtx,
 			argProbabilisticInterval, argProbabilisticThreshold)
@@ -369,6 +695,32 @@ func mainWithExitCode() exitCode {
 		}
 	}
 
+	if argBpfStats {
+		if err := trc.StartBPFStats(mainCtx, times.MonitorInterval()); err != nil {
+			log.Errorf("Failed to start eBPF statistics: %v", err)
+		}
+	}
+
+	if argSelfThrottleThreshold > 0 {
+		if err := trc.StartSelfThrottle(mainCtx, times.MonitorInterval(),
+			argSelfThrottleThreshold); err != nil {
+			log.Errorf("Failed to start self-throttling: %v", err)
+		}
+	}
+
+	if argIdleBackoff {
+		if err := trc.StartIdleBackoff(mainCtx, times.MonitorInterval()); err != nil {
+			log.Errorf("Failed to start idle backoff: %v", err)
+		}
+	}
+
+	if argSamplerWatchdog > 0 {
+		if err := trc.StartSamplerWatchdog(mainCtx, times.MonitorInterval(),
+			int(argSamplerWatchdog)); err != nil {
+			log.Errorf("Failed to start sampler watchdog: %v", err)
+		}
+	}
+
 	if err := trc.AttachSchedMonitor(); err != nil {
 		msg := fmt.Sprintf("Failed to attach scheduler monitor: %v", err)
 		log.Error(msg)
@@ -379,7 +731,8 @@ func mainWithExitCode() exitCode {
 	// change this log line update also the system test.
 	log.Printf("Attached sched monitor")
 
-	if err := startTraceHandling(mainCtx, rep, times, trc); err != nil {
+	traceHandlingDone, err := startTraceHandling(mainCtx, rep, times, trc, rawDump)
+	if err != nil {
 		msg := fmt.Sprintf("Failed to start trace handling: %v", err)
 		log.Error(msg)
 		return exitFailure
@@ -389,8 +742,91 @@ func mainWithExitCode() exitCode {
 	<-mainCtx.Done()
 
 	log.Info("Stop processing ...")
+	// Wait for the traces left in the perf event buffers to be reported.
+	<-traceHandlingDone
+	if pprofRep != nil {
+		if err = writePprofProfile(pprofRep, argPprofOutput); err != nil {
+			log.Errorf("Failed to write pprof profile: %v", err)
+			rep.Stop()
+			return exitFailure
+		}
+		log.Infof("Wrote pprof profile to %s", argPprofOutput)
+	}
 	rep.Stop()
 
 	log.Info("Exiting ...")
 	return exitSuccess
 }
+
+// eventSetType returns the sample type of the profiles of the event set sampled by event.
+func eventSetType(event tracer.PerfEvent) reporter.EventSetType {
+	sampleType, unit := event.SampleType()
+	return reporter.EventSetType{Type: sampleType, Unit: unit, CPUTime: event.IsClock()}
+}
+
+// writePprofProfile writes the profile collected by rep to the file at path.
+func writePprofProfile(rep *reporter.PprofReporter, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = rep.WriteProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// effectiveConfig is the configuration the agent resolved from its flags, the environment
+// and the defaults, as shown by the debug endpoint.
+type effectiveConfig struct {
+	*config.Config
+	Reporter                  string  `json:"reporter"`
+	PyroscopeURL              string  `json:"pyroscope_url,omitempty"`
+	PprofOutput               string  `json:"pprof_output,omitempty"`
+	PID                       uint    `json:"pid,omitempty"`
+	PIDFilter                 string  `json:"pid_filter,omitempty"`
+	PerfEvent                 string  `json:"perf_event"`
+	AlignedSampling           bool    `json:"aligned_sampling"`
+	SecondaryPerfEvent        string  `json:"secondary_perf_event,omitempty"`
+	SecondarySamplesPerSecond int     `json:"secondary_samples_per_second,omitempty"`
+	CPUTimeWeights            bool    `json:"cpu_time_weights"`
+	IdleBackoff               bool    `json:"idle_backoff"`
+	SelfThrottleThreshold     float64 `json:"self_throttle_threshold"`
+	SamplerWatchdogIntervals  uint    `json:"sampler_watchdog_intervals"`
+	RawDump                   string  `json:"raw_dump,omitempty"`
+}
+
+// newEffectiveConfig returns the effective configuration of the agent, which extends conf
+// with the settings that are not part of it.
+func newEffectiveConfig(conf *config.Config) *effectiveConfig {
+	ec := &effectiveConfig{
+		Config:                   conf,
+		Reporter:                 "otlp",
+		PIDFilter:                argPIDFilter,
+		PerfEvent:                argPerfEvent,
+		AlignedSampling:          argAlignedSampling,
+		SecondaryPerfEvent:       argSecondaryPerfEvent,
+		CPUTimeWeights:           argCPUTimeWeights,
+		IdleBackoff:              argIdleBackoff,
+		SelfThrottleThreshold:    argSelfThrottleThreshold,
+		SamplerWatchdogIntervals: argSamplerWatchdog,
+		RawDump:                  argRawDump,
+	}
+	if argSecondaryPerfEvent != "" {
+		ec.SecondarySamplesPerSecond = argSecondarySamplesPerSec
+	}
+	switch {
+	case argPID != 0:
+		ec.Reporter = "pprof"
+		ec.PID = argPID
+		ec.PprofOutput = argPprofOutput
+	case argPyroscopeURL != "":
+		ec.Reporter = "pyroscope"
+		// The URL may carry the credentials of the server.
+		if u, err := url.Parse(argPyroscopeURL); err == nil {
+			ec.PyroscopeURL = u.Redacted()
+		}
+	}
+	return ec
+}
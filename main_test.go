@@ -7,6 +7,9 @@
 package main
 
 import (
+	"encoding/json"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/elastic/otel-profiling-agent/config"
@@ -66,3 +69,34 @@ func TestParseTracers(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitPatterns(t *testing.T) {
+	tests := map[string][]string{
+		"":                  nil,
+		"libc.so*":          {"libc.so*"},
+		"libc.so*, libjvm*": {"libc.so*", "libjvm*"},
+		",libc.so*,,":       {"libc.so*"},
+	}
+	for in, expected := range tests {
+		if got := splitPatterns(in); !slices.Equal(got, expected) {
+			t.Errorf("splitPatterns(%q) = %v, expected %v", in, got, expected)
+		}
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	conf := &config.Config{SecretToken: "s3cr3t", Tracers: "all", SamplesPerSecond: 20}
+	data, err := json.Marshal(newEffectiveConfig(conf))
+	if err != nil {
+		t.Fatalf("failed to marshal effective config: %v", err)
+	}
+	for _, expected := range []string{`"tracers":"all"`, `"samples_per_second":20`,
+		`"reporter":"otlp"`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("effective config %s does not contain %s", data, expected)
+		}
+	}
+	if strings.Contains(string(data), conf.SecretToken) {
+		t.Errorf("effective config %s contains the secret token", data)
+	}
+}
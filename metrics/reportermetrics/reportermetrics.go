@@ -65,6 +65,18 @@ func report(forReporter reporter.Reporter) {
 			ID:    metrics.IDWireBytesInCount,
 			Value: metrics.MetricValue(reporterMetrics.WireBytesInCount),
 		},
+		{
+			ID:    metrics.IDLastExportTimestamp,
+			Value: metrics.MetricValue(reporterMetrics.LastExportTimestamp),
+		},
+		{
+			ID:    metrics.IDLiveSamplesDropped,
+			Value: metrics.MetricValue(reporterMetrics.LiveSamplesDropped),
+		},
+		{
+			ID:    metrics.IDLostSamples,
+			Value: metrics.MetricValue(reporterMetrics.LostSamples),
+		},
 	})
 }
 
@@ -0,0 +1,166 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Package pidfilter provides external sources for the set of PIDs that the agent is
+// allowed to profile. The set is maintained by an external controller, either in a file
+// that is watched for changes or by sending it over a Unix socket.
+package pidfilter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
+)
+
+// unixPrefix is the prefix of a source string that selects a Unix socket as source.
+const unixPrefix = "unix:"
+
+// unixReadTimeout is the time a client has to transfer the PIDs after connecting to the
+// Unix socket. Connections are handled one after another, so that the updates are applied
+// in order, and a stalled client would otherwise block all further updates.
+var unixReadTimeout = 10 * time.Second
+
+// UpdateFunc is called with the complete set of allowed PIDs whenever it changes.
+type UpdateFunc func(pids libpf.Set[libpf.PID])
+
+// Parse reads a set of PIDs separated by whitespace. Anything following a '#' until the
+// end of the line is ignored.
+func Parse(r io.Reader) (libpf.Set[libpf.PID], error) {
+	pids := make(libpf.Set[libpf.PID])
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		for _, field := range strings.Fields(line) {
+			pid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil || pid == 0 {
+				return nil, fmt.Errorf("invalid PID '%s'", field)
+			}
+			pids[libpf.PID(pid)] = libpf.Void{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pids, nil
+}
+
+// Start starts reading the allowed PIDs from source. If source has the prefix 'unix:' the
+// remainder is used as path of a Unix socket (see ListenUnix), otherwise source is the
+// path of a file that is watched for changes every interval (see WatchFile).
+func Start(ctx context.Context, source string, interval time.Duration,
+	update UpdateFunc) error {
+	if path, ok := strings.CutPrefix(source, unixPrefix); ok {
+		return ListenUnix(ctx, path, update)
+	}
+	return WatchFile(ctx, source, interval, update)
+}
+
+// readFile reads the set of PIDs from the file at path.
+func readFile(path string) (libpf.Set[libpf.PID], error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// WatchFile reads the set of allowed PIDs from the file at path and calls update with
+// it. The file is checked for modifications every interval, and update is called again
+// with the new content if it changed. The initial read happens synchronously.
+func WatchFile(ctx context.Context, path string, interval time.Duration,
+	update UpdateFunc) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	pids, err := readFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	update(pids)
+
+	lastModTime, lastSize := info.ModTime(), info.Size()
+	periodiccaller.Start(ctx, interval, func() {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Errorf("Failed to stat PID filter file %s: %v", path, err)
+			return
+		}
+		if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+			return
+		}
+		pids, err := readFile(path)
+		if err != nil {
+			log.Errorf("Failed to read PID filter file %s: %v", path, err)
+			return
+		}
+		lastModTime, lastSize = info.ModTime(), info.Size()
+		update(pids)
+	})
+
+	return nil
+}
+
+// ListenUnix creates a Unix socket at path and accepts connections on it. Each connection
+// transfers the complete set of allowed PIDs, terminated by closing the connection, which
+// then is passed to update. Connections that do not complete the transfer within
+// unixReadTimeout are dropped. The socket is removed when ctx is canceled.
+func ListenUnix(ctx context.Context, path string, update UpdateFunc) error {
+	// Remove a stale socket from a previous run.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %v", path, err)
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", path, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("Failed to accept PID filter connection: %v", err)
+				}
+				return
+			}
+			if err = conn.SetReadDeadline(time.Now().Add(unixReadTimeout)); err != nil {
+				log.Errorf("Failed to set PID filter connection deadline: %v", err)
+				conn.Close()
+				continue
+			}
+			pids, err := Parse(conn)
+			conn.Close()
+			if err != nil {
+				log.Errorf("Failed to read PID filter from %s: %v", path, err)
+				continue
+			}
+			update(pids)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package pidfilter
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]struct {
+		input    string
+		expected []libpf.PID
+		err      bool
+	}{
+		"empty":    {input: "", expected: []libpf.PID{}},
+		"newlines": {input: "1\n22\n333\n", expected: []libpf.PID{1, 22, 333}},
+		"mixed":    {input: "1 2\t3\n\n4", expected: []libpf.PID{1, 2, 3, 4}},
+		"comments": {input: "# workload a\n10 # main\n11\n", expected: []libpf.PID{10, 11}},
+		"invalid":  {input: "10\nfoo\n", err: true},
+		"zero":     {input: "0\n", err: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			pids, err := Parse(strings.NewReader(tc.input))
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, libpf.SliceToSet(tc.expected), pids)
+		})
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "pids")
+	require.NoError(t, os.WriteFile(path, []byte("1\n2\n"), 0o600))
+
+	updates := make(chan libpf.Set[libpf.PID], 2)
+	err := WatchFile(ctx, path, 10*time.Millisecond, func(pids libpf.Set[libpf.PID]) {
+		updates <- pids
+	})
+	require.NoError(t, err)
+	assert.Equal(t, libpf.SliceToSet([]libpf.PID{1, 2}), <-updates)
+
+	require.NoError(t, os.WriteFile(path, []byte("3\n"), 0o600))
+	select {
+	case pids := <-updates:
+		assert.Equal(t, libpf.SliceToSet([]libpf.PID{3}), pids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for PID filter update")
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "pids.sock")
+	updates := make(chan libpf.Set[libpf.PID], 1)
+	err := Start(ctx, unixPrefix+path, time.Second, func(pids libpf.Set[libpf.PID]) {
+		updates <- pids
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("42 43\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case pids := <-updates:
+		assert.Equal(t, libpf.SliceToSet([]libpf.PID{42, 43}), pids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for PID filter update")
+	}
+}
+
+func TestListenUnixStalledClient(t *testing.T) {
+	timeout := unixReadTimeout
+	unixReadTimeout = 100 * time.Millisecond
+	defer func() { unixReadTimeout = timeout }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "pids.sock")
+	updates := make(chan libpf.Set[libpf.PID], 1)
+	err := ListenUnix(ctx, path, func(pids libpf.Set[libpf.PID]) {
+		updates <- pids
+	})
+	require.NoError(t, err)
+
+	// The stalled client never closes its connection.
+	stalled, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer stalled.Close()
+	_, err = stalled.Write([]byte("1 2"))
+	require.NoError(t, err)
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("42\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case pids := <-updates:
+		assert.Equal(t, libpf.SliceToSet([]libpf.PID{42}), pids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for PID filter update")
+	}
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package proc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultCgroupMountPoint is the location where the cgroup hierarchies are mounted
+// on practically all distributions.
+const DefaultCgroupMountPoint = "/sys/fs/cgroup"
+
+// CgroupVersion identifies the cgroup interface version of a hierarchy.
+type CgroupVersion int
+
+const (
+	// CgroupV1 is the legacy interface with one hierarchy per set of controllers.
+	CgroupV1 CgroupVersion = 1
+	// CgroupV2 is the unified hierarchy.
+	CgroupV2 CgroupVersion = 2
+)
+
+// Cgroup describes the cgroup a process belongs to.
+type Cgroup struct {
+	// Version is the version of the hierarchy the cgroup was resolved in.
+	Version CgroupVersion
+	// Path is the path of the cgroup relative to the root of its hierarchy, as listed
+	// in /proc/<pid>/cgroup.
+	Path string
+	// Dir is the directory of the cgroup in the mounted hierarchy.
+	Dir string
+}
+
+// ID returns the inode number of Dir. For cgroup v2 this is the value that is returned
+// by the bpf_get_current_cgroup_id eBPF helper.
+func (c *Cgroup) ID() (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(c.Dir, &st); err != nil {
+		return 0, fmt.Errorf("failed to stat cgroup %s: %v", c.Dir, err)
+	}
+	return st.Ino, nil
+}
+
+// cgroupEntry is a single line of /proc/<pid>/cgroup.
+type cgroupEntry struct {
+	// controllers is empty for the cgroup v2 unified hierarchy. Named cgroup v1
+	// hierarchies are listed as 'name=<name>'.
+	controllers []string
+	path        string
+}
+
+// parseCgroupFile parses the cgroup membership of a process from cgroupFile
+// (e.g. /proc/self/cgroup).
+func parseCgroupFile(cgroupFile string) ([]cgroupEntry, error) {
+	f, err := os.Open(cgroupFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []cgroupEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line has the format 'hierarchy-ID:controller-list:cgroup-path'.
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		entry := cgroupEntry{path: fields[2]}
+		if fields[1] != "" {
+			entry.controllers = strings.Split(fields[1], ",")
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", cgroupFile, err)
+	}
+	return entries, nil
+}
+
+// hierarchy returns the directory below mountPoint where the cgroup v1 hierarchy of the
+// entry is mounted.
+func (e *cgroupEntry) hierarchy(mountPoint string) string {
+	name := strings.Join(e.controllers, ",")
+	return filepath.Join(mountPoint, strings.TrimPrefix(name, "name="))
+}
+
+// unifiedMountPoint returns the mount point of the cgroup v2 hierarchy below mountPoint.
+// In the unified layout it is mounted at mountPoint itself, in the hybrid layout (cgroup
+// v1 hierarchies alongside an empty v2 hierarchy) at mountPoint/unified. An empty string
+// is returned for the legacy layout.
+func unifiedMountPoint(mountPoint string) string {
+	for _, dir := range []string{mountPoint, filepath.Join(mountPoint, "unified")} {
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// ReadCgroup returns the cgroup of the process described by cgroupFile (e.g.
+// /proc/<pid>/cgroup) in the hierarchies mounted at mountPoint. The cgroup v2 hierarchy
+// is used if it is mounted, both in the unified and the hybrid layout. In the hybrid
+// layout container runtimes may leave the v2 hierarchy unused, so the root v2 cgroup
+// is only used if the process is not in another v1 cgroup either. Otherwise the cgroup is
+// resolved in the cgroup v1 hierarchy named 'systemd', if present, or else in the first
+// listed v1 hierarchy. The v2 hierarchy is also used if it is the only one listed but not
+// mounted at mountPoint, e.g. if the agent runs with a private mount namespace.
+func ReadCgroup(cgroupFile, mountPoint string) (Cgroup, error) {
+	entries, err := parseCgroupFile(cgroupFile)
+	if err != nil {
+		return Cgroup{}, err
+	}
+
+	var v1Entry, v2Entry *cgroupEntry
+	for i := range entries {
+		entry := &entries[i]
+		if len(entry.controllers) == 0 {
+			v2Entry = entry
+			continue
+		}
+		if v1Entry == nil || slices.Contains(entry.controllers, "name=systemd") {
+			v1Entry = entry
+		}
+	}
+
+	unified := unifiedMountPoint(mountPoint)
+	switch {
+	case v2Entry != nil && unified != "" &&
+		(v1Entry == nil || v2Entry.path != "/" || v1Entry.path == "/"):
+		return newCgroup(CgroupV2, v2Entry.path, unified), nil
+	case v1Entry != nil:
+		return newCgroup(CgroupV1, v1Entry.path, v1Entry.hierarchy(mountPoint)), nil
+	case v2Entry != nil:
+		return newCgroup(CgroupV2, v2Entry.path, mountPoint), nil
+	}
+	return Cgroup{}, fmt.Errorf("no cgroup hierarchy found in %s", cgroupFile)
+}
+
+func newCgroup(version CgroupVersion, cgroupPath, hierarchy string) Cgroup {
+	return Cgroup{
+		Version: version,
+		Path:    cgroupPath,
+		Dir:     filepath.Join(hierarchy, cgroupPath),
+	}
+}
+
+// GetCPUQuota returns the CPU bandwidth limit, expressed as a number of CPUs, that applies
+// to the cgroup of the process described by cgroupFile (e.g. /proc/self/cgroup). Both the
+// cgroup v2 (cpu.max) and v1 (cpu.cfs_quota_us / cpu.cfs_period_us) interfaces below
+// mountPoint are supported. A return value of 0 indicates that no quota is configured.
+func GetCPUQuota(cgroupFile, mountPoint string) (float64, error) {
+	entries, err := parseCgroupFile(cgroupFile)
+	if err != nil {
+		return 0, err
+	}
+
+	// In the hybrid layout the cpu controller is bound to a v1 hierarchy, so that one
+	// takes precedence over the unified hierarchy.
+	var unified *cgroupEntry
+	for i := range entries {
+		entry := &entries[i]
+		if len(entry.controllers) == 0 {
+			unified = entry
+			continue
+		}
+		if slices.Contains(entry.controllers, "cpu") {
+			return readCgroupV1Quota(entry.hierarchy(mountPoint), entry.path)
+		}
+	}
+	if unified != nil {
+		return readCgroupV2Quota(mountPoint, unified.path)
+	}
+
+	return 0, fmt.Errorf("no cpu cgroup controller found in %s", cgroupFile)
+}
+
+// openCgroupFile opens the named file in the cgroup directory for cgroupPath. If the
+// cgroup directory is not visible, e.g. because the agent runs in its own cgroup
+// namespace, the file at the root of the hierarchy is used instead.
+func openCgroupFile(hierarchy, cgroupPath, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(hierarchy, cgroupPath, name))
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = os.ReadFile(filepath.Join(hierarchy, name))
+	}
+	return data, err
+}
+
+// readCgroupV2Quota parses cpu.max which holds '$MAX $PERIOD' where $MAX may be 'max'.
+func readCgroupV2Quota(hierarchy, cgroupPath string) (float64, error) {
+	data, err := openCgroupFile(hierarchy, cgroupPath, "cpu.max")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unexpected cpu.max format: '%s'", data)
+	}
+	if fields[0] == "max" {
+		return 0, nil
+	}
+	return quotaToCPUs(fields[0], fields[1])
+}
+
+// readCgroupV1Quota parses cpu.cfs_quota_us and cpu.cfs_period_us. A quota of -1
+// indicates that no limit is set.
+func readCgroupV1Quota(hierarchy, cgroupPath string) (float64, error) {
+	quota, err := openCgroupFile(hierarchy, cgroupPath, "cpu.cfs_quota_us")
+	if err != nil {
+		return 0, err
+	}
+	period, err := openCgroupFile(hierarchy, cgroupPath, "cpu.cfs_period_us")
+	if err != nil {
+		return 0, err
+	}
+
+	quotaStr := strings.TrimSpace(string(quota))
+	if quotaStr == "-1" {
+		return 0, nil
+	}
+	return quotaToCPUs(quotaStr, strings.TrimSpace(string(period)))
+}
+
+func quotaToCPUs(quotaStr, periodStr string) (float64, error) {
+	quota, err := strconv.ParseUint(quotaStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU quota '%s': %v", quotaStr, err)
+	}
+	period, err := strconv.ParseUint(periodStr, 10, 64)
+	if err != nil || period == 0 {
+		return 0, fmt.Errorf("invalid CPU period '%s': %v", periodStr, err)
+	}
+	return float64(quota) / float64(period), nil
+}
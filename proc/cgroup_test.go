@@ -0,0 +1,227 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package proc
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestGetCPUQuota(t *testing.T) {
+	tests := map[string]struct {
+		cgroup   string
+		files    map[string]string
+		expected float64
+	}{
+		"v2 limited": {
+			cgroup: "0::/kubepods/agent\n",
+			files: map[string]string{
+				"kubepods/agent/cpu.max": "50000 100000\n",
+			},
+			expected: 0.5,
+		},
+		"v2 unlimited": {
+			cgroup: "0::/kubepods/agent\n",
+			files: map[string]string{
+				"kubepods/agent/cpu.max": "max 100000\n",
+			},
+			expected: 0,
+		},
+		"v2 namespaced": {
+			cgroup: "0::/kubepods/agent\n",
+			files: map[string]string{
+				"cpu.max": "200000 100000\n",
+			},
+			expected: 2,
+		},
+		"v1 limited": {
+			cgroup: "5:memory:/agent\n4:cpu,cpuacct:/agent\n",
+			files: map[string]string{
+				"cpu,cpuacct/agent/cpu.cfs_quota_us":  "25000\n",
+				"cpu,cpuacct/agent/cpu.cfs_period_us": "100000\n",
+			},
+			expected: 0.25,
+		},
+		"v1 hybrid": {
+			cgroup: "4:cpu,cpuacct:/agent\n0::/agent\n",
+			files: map[string]string{
+				"cpu,cpuacct/agent/cpu.cfs_quota_us":  "150000\n",
+				"cpu,cpuacct/agent/cpu.cfs_period_us": "100000\n",
+			},
+			expected: 1.5,
+		},
+		"v1 unlimited": {
+			cgroup: "4:cpu,cpuacct:/agent\n",
+			files: map[string]string{
+				"cpu,cpuacct/agent/cpu.cfs_quota_us":  "-1\n",
+				"cpu,cpuacct/agent/cpu.cfs_period_us": "100000\n",
+			},
+			expected: 0,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cgroupFile := filepath.Join(dir, "cgroup")
+			writeTestFile(t, cgroupFile, tc.cgroup)
+			mountPoint := filepath.Join(dir, "fs")
+			for path, content := range tc.files {
+				writeTestFile(t, filepath.Join(mountPoint, path), content)
+			}
+
+			quota, err := GetCPUQuota(cgroupFile, mountPoint)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if quota != tc.expected {
+				t.Fatalf("expected quota %v, got %v", tc.expected, quota)
+			}
+		})
+	}
+}
+
+func TestReadCgroup(t *testing.T) {
+	tests := map[string]struct {
+		cgroup   string
+		dirs     []string
+		unified  string
+		version  CgroupVersion
+		path     string
+		dir      string
+		hasError bool
+	}{
+		"v2 unified": {
+			cgroup:  "0::/system.slice/docker-abc.scope\n",
+			dirs:    []string{"system.slice/docker-abc.scope"},
+			unified: ".",
+			version: CgroupV2,
+			path:    "/system.slice/docker-abc.scope",
+			dir:     "system.slice/docker-abc.scope",
+		},
+		"v2 hybrid": {
+			cgroup: "4:cpu,cpuacct:/agent\n1:name=systemd:/agent\n" +
+				"0::/agent\n",
+			dirs:    []string{"unified/agent", "cpu,cpuacct/agent", "systemd/agent"},
+			unified: "unified",
+			version: CgroupV2,
+			path:    "/agent",
+			dir:     "unified/agent",
+		},
+		"v1 systemd": {
+			cgroup:  "5:memory:/agent\n4:cpu,cpuacct:/agent\n1:name=systemd:/agent\n",
+			dirs:    []string{"memory/agent", "cpu,cpuacct/agent", "systemd/agent"},
+			version: CgroupV1,
+			path:    "/agent",
+			dir:     "systemd/agent",
+		},
+		"v1 without systemd": {
+			cgroup:  "5:memory:/agent\n4:cpu,cpuacct:/agent\n",
+			dirs:    []string{"memory/agent", "cpu,cpuacct/agent"},
+			version: CgroupV1,
+			path:    "/agent",
+			dir:     "memory/agent",
+		},
+		"v1 with unused v2 hierarchy": {
+			cgroup:  "4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n0::/\n",
+			dirs:    []string{"unified", "cpu,cpuacct/docker/abc", "systemd/docker/abc"},
+			unified: "unified",
+			version: CgroupV1,
+			path:    "/docker/abc",
+			dir:     "systemd/docker/abc",
+		},
+		"v2 root in hybrid layout": {
+			cgroup:  "1:name=systemd:/\n0::/\n",
+			dirs:    []string{"unified", "systemd"},
+			unified: "unified",
+			version: CgroupV2,
+			path:    "/",
+			dir:     "unified",
+		},
+		"v2 not mounted": {
+			cgroup:  "0::/agent\n",
+			dirs:    []string{"agent"},
+			version: CgroupV2,
+			path:    "/agent",
+			dir:     "agent",
+		},
+		"missing directory": {
+			cgroup:   "1:name=systemd:/agent\n",
+			hasError: true,
+		},
+		"no hierarchy": {
+			cgroup:   "",
+			hasError: true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cgroupFile := filepath.Join(dir, "cgroup")
+			writeTestFile(t, cgroupFile, tc.cgroup)
+			mountPoint := filepath.Join(dir, "fs")
+			for _, d := range tc.dirs {
+				if err := os.MkdirAll(filepath.Join(mountPoint, d), 0o755); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+			}
+			if tc.unified != "" {
+				writeTestFile(t, filepath.Join(mountPoint, tc.unified, "cgroup.controllers"), "")
+			}
+
+			cgroup, err := ReadCgroup(cgroupFile, mountPoint)
+			if err == nil {
+				// The ID can only be read if the cgroup directory exists.
+				var id uint64
+				if id, err = cgroup.ID(); err == nil && id == 0 {
+					t.Fatalf("unexpected cgroup ID 0")
+				}
+			}
+			if tc.hasError {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", cgroup)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedDir := filepath.Join(mountPoint, tc.dir)
+			info, err := os.Stat(expectedDir)
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", expectedDir, err)
+			}
+			expected := Cgroup{
+				Version: tc.version,
+				Path:    tc.path,
+				Dir:     expectedDir,
+			}
+			if cgroup != expected {
+				t.Fatalf("expected %+v, got %+v", expected, cgroup)
+			}
+			if id, _ := cgroup.ID(); id != info.Sys().(*syscall.Stat_t).Ino {
+				t.Fatalf("expected cgroup ID %d, got %d", info.Sys().(*syscall.Stat_t).Ino, id)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elastic/otel-profiling-agent/libpf/stringutil"
 	"golang.org/x/sys/unix"
@@ -25,7 +26,18 @@ import (
 
 const defaultMountPoint = "/proc"
 
-// GetKallsyms returns SymbolMap for kernel symbols from /proc/kallsyms.
+// ErrKernelAddressesHidden is returned by GetKallsyms if all addresses are zero, as it is the
+// case for processes that are not allowed to see them, e.g. due to kptr_restrict.
+var ErrKernelAddressesHidden = errors.New(
+	"all addresses from kallsyms are zero - reading them requires CAP_SYSLOG")
+
+// bpfSymbolPrefixes are the prefixes of the kallsyms names of JITed BPF programs,
+// trampolines and dispatchers.
+var bpfSymbolPrefixes = []string{"bpf_prog_", "bpf_trampoline_", "bpf_dispatcher_"}
+
+// GetKallsyms returns SymbolMap for kernel symbols from /proc/kallsyms. This includes the
+// symbols of the loaded modules and of the JITed BPF programs. Files in the same format,
+// e.g. System.map, can be read as well.
 func GetKallsyms(kallsymsPath string) (*libpf.SymbolMap, error) {
 	var address uint64
 	var symbol string
@@ -67,19 +79,25 @@ func GetKallsyms(kallsymsPath string) (*libpf.SymbolMap, error) {
 		symmap.Add(libpf.Symbol{
 			Name:    libpf.SymbolName(symbol),
 			Address: libpf.SymbolValue(address),
+			// Upper case symbol types denote global symbols.
+			Global: fields[1][0] >= 'A' && fields[1][0] <= 'Z',
 		})
 	}
 	symmap.Finalize()
 
 	if noSymbols {
-		return nil, fmt.Errorf(
-			"all addresses from kallsyms are zero - check process permissions")
+		return nil, ErrKernelAddressesHidden
 	}
 
 	return &symmap, nil
 }
 
-// GetKernelModules returns SymbolMap for kernel modules from /proc/modules.
+// GetKernelModules returns SymbolMap for kernel modules from /proc/modules. Each module
+// is a symbol spanning its address range, as is the kernel image named vmlinux. The JITed
+// BPF programs, trampolines and dispatchers are added from kernelSymbols, as they are no
+// modules but only listed in kallsyms. They are sized up to the next module or program,
+// as kallsyms does not tell their sizes. Modules whose addresses are hidden, e.g. due to
+// kptr_restrict, are left out.
 func GetKernelModules(modulesPath string,
 	kernelSymbols *libpf.SymbolMap) (*libpf.SymbolMap, error) {
 	symmap := libpf.SymbolMap{}
@@ -99,11 +117,12 @@ func GetKernelModules(modulesPath string,
 		return nil, fmt.Errorf("unable to find kernel text section end: %v", err)
 	}
 	log.Debugf("Found KERNEL TEXT at %x-%x", stext.Address, etext.Address)
-	symmap.Add(libpf.Symbol{
+	modules := []libpf.Symbol{{
 		Name:    "vmlinux",
 		Address: stext.Address,
 		Size:    int(etext.Address - stext.Address),
-	})
+	}}
+	hidden := 0
 
 	var scanner = bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -118,22 +137,58 @@ func GetKernelModules(modulesPath string,
 			return nil, fmt.Errorf("unexpected line in modules: '%s'", line)
 		}
 		if address == 0 {
-			return nil, fmt.Errorf(
-				"addresses from modules is zero - "+
-					"check process permissions: '%s'", line)
+			hidden++
+			continue
 		}
 
-		symmap.Add(libpf.Symbol{
+		modules = append(modules, libpf.Symbol{
 			Name:    libpf.SymbolName(name),
 			Address: libpf.SymbolValue(address),
 			Size:    int(size),
 		})
 	}
+	if hidden != 0 {
+		log.Warnf("Addresses of %d kernel modules are hidden - check process permissions, "+
+			"frames in these modules are not symbolized", hidden)
+	}
+
+	for _, module := range modules {
+		symmap.Add(module)
+	}
+	// Functions of the kernel image and modules can have the same prefixes, e.g.
+	// bpf_prog_alloc, so only symbols outside of them are taken as BPF programs.
+	kernelSymbols.ScanAllNames(func(name libpf.SymbolName) {
+		if !isBPFSymbol(name) {
+			return
+		}
+		sym, err := kernelSymbols.LookupSymbol(name)
+		if err != nil || sym.Address == 0 {
+			return
+		}
+		for i := range modules {
+			if sym.Address >= modules[i].Address &&
+				sym.Address < modules[i].Address+libpf.SymbolValue(modules[i].Size) {
+				return
+			}
+		}
+		symmap.Add(libpf.Symbol{Name: name, Address: sym.Address})
+	})
 	symmap.Finalize()
 
 	return &symmap, nil
 }
 
+// isBPFSymbol checks whether the kallsyms name belongs to a JITed BPF program, trampoline
+// or dispatcher.
+func isBPFSymbol(name libpf.SymbolName) bool {
+	for _, prefix := range bpfSymbolPrefixes {
+		if strings.HasPrefix(string(name), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // ListPIDs from the proc filesystem mount point and return a list of libpf.PID to be processed
 func ListPIDs() ([]libpf.PID, error) {
 	pids := make([]libpf.PID, 0)
@@ -187,3 +242,113 @@ func IsPIDLive(pid libpf.PID) (bool, error) {
 
 	return true, err
 }
+
+// parseParentPID extracts the parent PID from the content of /proc/PID/stat.
+func parseParentPID(stat string) (libpf.PID, error) {
+	// The command name in the second field is enclosed in parentheses and can contain
+	// any character, so the remaining fields are located after its closing parenthesis.
+	idx := strings.LastIndexByte(stat, ')')
+	if idx < 0 {
+		return 0, errors.New("malformed stat: missing command name")
+	}
+	// The fields after the command name are the state and the parent PID.
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 2 {
+		return 0, errors.New("malformed stat: missing parent PID")
+	}
+	ppid, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("malformed stat: invalid parent PID: %v", err)
+	}
+	return libpf.PID(ppid), nil
+}
+
+// GetParentPID returns the PID of the parent of the process with the given PID.
+func GetParentPID(pid libpf.PID) (libpf.PID, error) {
+	file, err := os.Open(fmt.Sprintf("%s/%d/stat", defaultMountPoint, pid))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// The parent PID follows the PID, the command name of at most 64 characters and the
+	// state, so a single read of a small buffer suffices.
+	var buf [256]byte
+	n, err := file.Read(buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return parseParentPID(string(buf[:n]))
+}
+
+// userHZ is the frequency of the clock ticks in which /proc/stat reports CPU times, which
+// is 100 on all architectures supported by the agent.
+const userHZ = 100
+
+// parseBusyCPUTime extracts the CPU time all CPUs spent executing tasks from the content
+// of /proc/stat.
+func parseBusyCPUTime(stat string) (time.Duration, error) {
+	line, _, _ := strings.Cut(stat, "\n")
+	fields := strings.Fields(line)
+	// The summary line lists the user, nice, system, idle, iowait, irq, softirq and steal
+	// times. Idle and iowait times are not counted as busy.
+	if len(fields) < 9 || fields[0] != "cpu" {
+		return 0, errors.New("malformed stat: missing CPU summary")
+	}
+	var ticks uint64
+	for i, field := range fields[1:9] {
+		if i == 3 || i == 4 {
+			continue
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed stat: invalid CPU time: %v", err)
+		}
+		ticks += value
+	}
+	return time.Duration(ticks) * time.Second / userHZ, nil
+}
+
+// GetBusyCPUTime returns the CPU time all CPUs of the host spent executing tasks since
+// boot.
+func GetBusyCPUTime() (time.Duration, error) {
+	stat, err := os.ReadFile(defaultMountPoint + "/stat")
+	if err != nil {
+		return 0, err
+	}
+	return parseBusyCPUTime(string(stat))
+}
+
+// GetEnvironment returns the initial environment variables of the process with the given
+// PID in the form "key=value".
+func GetEnvironment(pid libpf.PID) ([]string, error) {
+	environ, err := os.ReadFile(fmt.Sprintf("%s/%d/environ", defaultMountPoint, pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(environ), func(r rune) bool { return r == 0 }), nil
+}
+
+// GetCommandLine returns the arguments of the command line of the given PID. Kernel threads
+// have no arguments.
+func GetCommandLine(pid libpf.PID) ([]string, error) {
+	cmdline, err := os.ReadFile(fmt.Sprintf("%s/%d/cmdline", defaultMountPoint, pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(cmdline), func(r rune) bool { return r == 0 }), nil
+}
+
+// kcmpVM is the kcmp(2) type comparing the address spaces of two processes.
+const kcmpVM = 1
+
+// SharesAddressSpace reports whether the two processes share their address space, as it
+// is the case for the child of vfork or of clone with CLONE_VM until it calls execve.
+func SharesAddressSpace(pid1, pid2 libpf.PID) (bool, error) {
+	ret, _, errno := unix.Syscall6(unix.SYS_KCMP, uintptr(pid1), uintptr(pid2), kcmpVM,
+		0, 0, 0)
+	if errno != 0 {
+		return false, errno
+	}
+	return ret == 0, nil
+}
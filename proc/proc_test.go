@@ -7,7 +7,9 @@
 package proc
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/elastic/otel-profiling-agent/libpf"
 )
@@ -27,7 +29,7 @@ func assertSymbol(t *testing.T, symmap *libpf.SymbolMap, name libpf.SymbolName,
 func TestParseKallSyms(t *testing.T) {
 	// Check parsing as if we were non-root
 	symmap, err := GetKallsyms("testdata/kallsyms_0")
-	if symmap != nil || err == nil {
+	if symmap != nil || !errors.Is(err, ErrKernelAddressesHidden) {
 		t.Fatalf("expected an error because symbol address is 0")
 	}
 
@@ -46,3 +48,113 @@ func TestParseKallSyms(t *testing.T) {
 	assertSymbol(t, symmap, "cpu_tss_rw", 0x6000)
 	assertSymbol(t, symmap, "hid_add_device", 0xffffffffc033e550)
 }
+
+func TestGetKernelModules(t *testing.T) {
+	kernelSymbols, err := GetKallsyms("testdata/kallsyms_bpf")
+	if err != nil {
+		t.Fatalf("error parsing kallsyms: %v", err)
+	}
+	modules, err := GetKernelModules("testdata/modules", kernelSymbols)
+	if err != nil {
+		t.Fatalf("error parsing modules: %v", err)
+	}
+
+	tests := map[libpf.SymbolValue]struct {
+		module libpf.SymbolName
+		offset libpf.Address
+	}{
+		0xffffffff81001010: {module: "vmlinux", offset: 0x1010},
+		0xffffffffc0180010: {module: "nvme", offset: 0x80010},
+		0xffffffffc0200810: {module: "bpf_prog_6deef7357e7b4530_sd_fw_egress", offset: 0x10},
+		0xffffffffc0201020: {module: "bpf_trampoline_6442453466", offset: 0x20},
+		// Between the end of nvme and the first BPF program.
+		0xffffffffc0200000: {},
+	}
+	for addr, expected := range tests {
+		module, offset, ok := modules.LookupByAddress(addr)
+		if expected.module == "" {
+			if ok {
+				t.Errorf("0x%x: expected no module, got %s", addr, module)
+			}
+			continue
+		}
+		if module != expected.module || offset != expected.offset {
+			t.Errorf("0x%x: expected %s+0x%x, got %s+0x%x", addr, expected.module,
+				expected.offset, module, offset)
+		}
+	}
+	if _, err = modules.LookupSymbol("hidden"); err == nil {
+		t.Errorf("expected module with hidden address to be left out")
+	}
+	if _, err = modules.LookupSymbol("bpf_prog_alloc"); err == nil {
+		t.Errorf("expected function of the kernel image not to be taken as BPF program")
+	}
+}
+
+func TestParseParentPID(t *testing.T) {
+	tests := map[string]struct {
+		stat     string
+		ppid     libpf.PID
+		hasError bool
+	}{
+		"plain":           {stat: "1234 (bash) S 1000 1234 1234 34816", ppid: 1000},
+		"comm with space": {stat: "42 (tmux: server) S 1 42 42 0 -1", ppid: 1},
+		"comm with paren": {stat: "7 (a) b) R 3 7 7 0", ppid: 3},
+		"truncated":       {stat: "7 (a) R", hasError: true},
+		"no comm":         {stat: "7 a R 3", hasError: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			ppid, err := parseParentPID(test.stat)
+			if test.hasError {
+				if err == nil {
+					t.Fatalf("expected an error, got parent PID %d", ppid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ppid != test.ppid {
+				t.Fatalf("expected parent PID %d, got %d", test.ppid, ppid)
+			}
+		})
+	}
+}
+
+func TestParseBusyCPUTime(t *testing.T) {
+	tests := map[string]struct {
+		stat     string
+		busy     time.Duration
+		hasError bool
+	}{
+		"summary": {
+			stat: "cpu  100 20 30 5000 70 1 2 3 0 0\ncpu0 50 10 15 2500 35 1 1 2 0 0\n",
+			busy: 1560 * time.Millisecond,
+		},
+		"no summary": {stat: "cpu0 100 20 30 5000 70 1 2 3 0 0\n", hasError: true},
+		"truncated":  {stat: "cpu  100 20 30\n", hasError: true},
+		"invalid":    {stat: "cpu  100 x 30 5000 70 1 2 3 0 0\n", hasError: true},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			busy, err := parseBusyCPUTime(test.stat)
+			if test.hasError {
+				if err == nil {
+					t.Fatalf("expected an error, got busy time %v", busy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if busy != test.busy {
+				t.Fatalf("expected busy time %v, got %v", test.busy, busy)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proc"
+)
+
+const (
+	// commandLineLabel is the name of the label holding the command line of a process.
+	commandLineLabel = "process.command_line"
+	// redactedValue replaces the values of redacted arguments.
+	redactedValue = "[REDACTED]"
+)
+
+// redactURL returns s with the password replaced if it is a URL with password.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return u.Redacted()
+		}
+	}
+	return s
+}
+
+// redactArguments returns a copy of args in which the values of the options whose names
+// match one of the shell patterns are replaced by redactedValue, as well as the passwords
+// of URLs. Patterns are matched against the lower case option name without leading dashes.
+// Values are taken from the same argument for '--name=value' and 'NAME=value', and from the
+// next argument for '--name value', unless that is an option itself.
+func redactArguments(args, patterns []string) []string {
+	matches := func(name string) bool {
+		name = strings.ToLower(strings.TrimLeft(name, "-"))
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := make([]string, len(args))
+	redactNext := false
+	for i, arg := range args {
+		if redactNext && !strings.HasPrefix(arg, "-") {
+			result[i] = redactedValue
+			continue
+		}
+		redactNext = false
+
+		// URLs are checked as a whole first, as their queries can contain '='.
+		if redacted := redactURL(arg); redacted != arg {
+			result[i] = redacted
+			continue
+		}
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			if matches(name) {
+				value = redactedValue
+			}
+			result[i] = name + "=" + redactURL(value)
+			continue
+		}
+		redactNext = strings.HasPrefix(arg, "-") && matches(arg)
+		result[i] = arg
+	}
+	return result
+}
+
+// commandLine returns the arguments args, with secrets redacted according to patterns, as a
+// space separated string of at most maxLength bytes. The redaction is done before the
+// truncation, so that a truncated argument never reveals a part of a secret.
+func commandLine(args, patterns []string, maxLength int) string {
+	cmdline := strings.Join(redactArguments(args, patterns), " ")
+	if len(cmdline) <= maxLength {
+		return cmdline
+	}
+	// Drop a multi-byte character that was cut in the middle.
+	return strings.ToValidUTF8(cmdline[:maxLength], "")
+}
+
+// readCommandLineLabel returns the label holding the command line of the process pid, see
+// commandLine. Processes without command line, e.g. kernel threads, or whose command line can
+// not be read have no label.
+func readCommandLineLabel(pid libpf.PID, patterns []string,
+	maxLength int) map[string]string {
+	args, err := proc.GetCommandLine(pid)
+	if err != nil {
+		log.Debugf("Failed to read command line of PID %d: %v", pid, err)
+		return nil
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return map[string]string{commandLineLabel: commandLine(args, patterns, maxLength)}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/otel-profiling-agent/libpf/remotememory"
+)
+
+// errSymbolizationTimeout is returned by the reads of process memory of the interpreter
+// instances once the symbolization deadline of the trace being converted has passed.
+var errSymbolizationTimeout = errors.New("symbolization timed out")
+
+// symbolizationDeadline holds the deadline of the symbolization of an interpreter frame,
+// which is shared by the memory readers of all interpreter instances.
+type symbolizationDeadline struct {
+	// unixNano is the deadline in nanoseconds since the epoch, or 0 if there is none.
+	unixNano atomic.Int64
+}
+
+func (d *symbolizationDeadline) set(deadline time.Time) {
+	if deadline.IsZero() {
+		d.unixNano.Store(0)
+		return
+	}
+	d.unixNano.Store(deadline.UnixNano())
+}
+
+// passed returns true if there is a deadline and it has passed.
+func (d *symbolizationDeadline) passed() bool {
+	deadline := d.unixNano.Load()
+	return deadline != 0 && time.Now().UnixNano() > deadline
+}
+
+// deadlineReader reads process memory until the symbolization deadline has passed, so that
+// the walk of corrupt or adversarial interpreter state in the memory of a process can not
+// exceed the symbolization timeout, however many reads it takes.
+type deadlineReader struct {
+	io.ReaderAt
+	deadline *symbolizationDeadline
+}
+
+func (r deadlineReader) ReadAt(p []byte, off int64) (int, error) {
+	if r.deadline.passed() {
+		return 0, errSymbolizationTimeout
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// boundedRemoteMemory returns rm with reads that fail once the symbolization deadline of
+// the process manager has passed. The interpreter instances are attached with it.
+func (pm *ProcessManager) boundedRemoteMemory(
+	rm remotememory.RemoteMemory) remotememory.RemoteMemory {
+	if !rm.Valid() {
+		return rm
+	}
+	rm.ReaderAt = deadlineReader{ReaderAt: rm.ReaderAt, deadline: &pm.symbolizationDeadline}
+	return rm
+}
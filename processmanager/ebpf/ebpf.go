@@ -92,6 +92,7 @@ type ebpfMapsImpl struct {
 	phpJITProcs        *cebpf.Map
 	rubyProcs          *cebpf.Map
 	v8Procs            *cebpf.Map
+	beamProcs          *cebpf.Map
 
 	// Stackdelta and process related eBPF maps
 	exeIDToStackDeltaMaps []*cebpf.Map
@@ -181,6 +182,12 @@ func LoadMaps(maps map[string]*cebpf.Map) (EbpfHandler, error) {
 	}
 	impl.v8Procs = v8Procs
 
+	beamProcs, ok := maps["beam_procs"]
+	if !ok {
+		log.Fatalf("Map beam_procs is not available")
+	}
+	impl.beamProcs = beamProcs
+
 	impl.stackDeltaPageToInfo, ok = maps["stack_delta_page_to_info"]
 	if !ok {
 		log.Fatalf("Map stack_delta_page_to_info is not available")
@@ -268,6 +275,8 @@ func (impl *ebpfMapsImpl) getInterpreterTypeMap(typ libpf.InterpType) (*cebpf.Ma
 		return impl.rubyProcs, nil
 	case libpf.V8:
 		return impl.v8Procs, nil
+	case libpf.BEAM:
+		return impl.beamProcs, nil
 	default:
 		return nil, fmt.Errorf("type %d is not (yet) supported", typ)
 	}
@@ -10,10 +10,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/elastic/go-freelru"
 
 	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/host"
 	"github.com/elastic/otel-profiling-agent/interpreter"
+	"github.com/elastic/otel-profiling-agent/interpreter/beam"
 	"github.com/elastic/otel-profiling-agent/interpreter/hotspot"
 	"github.com/elastic/otel-profiling-agent/interpreter/nodev8"
 	"github.com/elastic/otel-profiling-agent/interpreter/perl"
@@ -39,6 +44,22 @@ const (
 	// recorded. Currently reflects the V8 binary blob size, in which
 	// the gap size is >= 512kB.
 	minimumMemoizableGapSize = 512 * 1024
+
+	// unsupportedVersionWarnInterval is the minimum interval between two warnings about
+	// the same unsupported interpreter version.
+	unsupportedVersionWarnInterval = 1 * time.Hour
+
+	// maxExtractionFailures is the number of consecutive failures to extract the stack
+	// deltas of an executable after which it is blacklisted.
+	maxExtractionFailures = 3
+
+	// extractionFailureCacheSize is the maximum number of executables whose extraction
+	// failures are counted, and of blacklisted executables.
+	extractionFailureCacheSize = 4096
+
+	// maxInterpDetectionRetries is the number of times the interpreter detection of an
+	// executable is retried when it failed due to a transient error, e.g. of I/O.
+	maxInterpDetectionRetries = 3
 )
 
 // ExecutableInfo stores information about an executable (ELF file).
@@ -74,6 +95,10 @@ type ExecutableInfoManager struct {
 
 	// state bundles up all mutable state of the manager.
 	state xsync.RWMutex[executableInfoManagerState]
+
+	// unsupportedCount counts the executables detected as an interpreter of an
+	// unsupported version.
+	unsupportedCount atomic.Uint64
 }
 
 // NewExecutableInfoManager creates a new instance of the executable info manager.
@@ -81,7 +106,7 @@ func NewExecutableInfoManager(
 	sdp nativeunwind.StackDeltaProvider,
 	ebpf pmebpf.EbpfHandler,
 	includeTracers []bool,
-) *ExecutableInfoManager {
+) (*ExecutableInfoManager, error) {
 	// Initialize interpreter loaders.
 	interpreterLoaders := make([]interpreter.Loader, 0)
 	if includeTracers[config.PerlTracer] {
@@ -102,6 +127,22 @@ func NewExecutableInfoManager(
 	if includeTracers[config.V8Tracer] {
 		interpreterLoaders = append(interpreterLoaders, nodev8.Loader)
 	}
+	if includeTracers[config.BEAMTracer] {
+		interpreterLoaders = append(interpreterLoaders, beam.Loader)
+	}
+	interpreterLoaders = append(interpreterLoaders, interpreter.RegisteredLoaders()...)
+
+	fileIDHash := func(k host.FileID) uint32 { return uint32(k) }
+	extractFailures, err := lru.New[host.FileID, uint32](extractionFailureCacheSize,
+		fileIDHash)
+	if err != nil {
+		return nil, err
+	}
+	blacklisted, err := lru.New[host.FileID, libpf.Void](extractionFailureCacheSize,
+		fileIDHash)
+	if err != nil {
+		return nil, err
+	}
 
 	return &ExecutableInfoManager{
 		sdp: sdp,
@@ -109,9 +150,13 @@ func NewExecutableInfoManager(
 			interpreterLoaders: interpreterLoaders,
 			executables:        map[host.FileID]*entry{},
 			unwindInfoIndex:    map[sdtypes.UnwindInfo]uint16{},
+			unsupportedWarned:  map[string]time.Time{},
+			interpDisabled:     map[host.FileID]libpf.Void{},
+			extractFailures:    extractFailures,
+			blacklisted:        blacklisted,
 			ebpf:               ebpf,
 		}),
-	}
+	}, nil
 }
 
 // AddOrIncRef either adds information about an executable to the internal cache (when first
@@ -135,21 +180,48 @@ func (mgr *ExecutableInfoManager) AddOrIncRef(fileID host.FileID,
 	if ok {
 		defer mgr.state.WUnlock(&state)
 		info.rc++
+		if info.Data == nil && info.interpRetries > 0 {
+			// The interpreter detection failed transiently when the executable was added,
+			// retry it with the reference of this encounter.
+			info.interpRetries--
+			var transient bool
+			info.Data, transient = mgr.detectAndLoadInterpData(state,
+				interpreter.NewLoaderInfo(fileID, elfRef, info.gaps))
+			if !transient {
+				info.interpRetries = 0
+			}
+		}
 		return info.ExecutableInfo, nil
 	}
+	if state.blacklisted.Contains(fileID) {
+		defer mgr.state.WUnlock(&state)
+		state.executables[fileID] = &entry{mapRef: mapRef{MapID: 0}, rc: 1}
+		return ExecutableInfo{}, nil
+	}
 
 	// Otherwise, gather interval data via SDP. This can take a while,
 	// so we release the lock before doing this.
 	mgr.state.WUnlock(&state)
 
 	if err = mgr.sdp.GetIntervalStructuresForFile(fileID, elfRef, &intervalData); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			// The file not being found is common for exited processes, and not a
+			// problem of the file.
+			mgr.recordExtractionFailure(fileID, elfRef.FileName())
+		}
 		return ExecutableInfo{}, fmt.Errorf("failed to extract interval data: %w", err)
 	}
 
-	// Also gather TSD info if applicable.
-	if tpbase.IsPotentialTSDDSO(elfRef.FileName()) {
+	// Also gather TSD info if applicable. Statically linked executables carry their own
+	// pthread code, which interpreters linked into them use.
+	if tpbase.IsPotentialTSDDSO(elfRef.FileName()) || isStaticExecutable(elfRef) {
 		if ef, errx := elfRef.GetELF(); errx == nil {
-			tsdInfo, _ = tpbase.ExtractTSDInfo(ef)
+			if tsdInfo, errx = tpbase.ExtractTSDInfo(ef); errx != nil {
+				log.WithFields(log.Fields{
+					"fileID": fmt.Sprintf("%#016x", fileID),
+					"file":   elfRef.FileName(),
+				}).Debugf("Failed to extract TSD info: %v", errx)
+			}
 		}
 	}
 
@@ -157,6 +229,7 @@ func (mgr *ExecutableInfoManager) AddOrIncRef(fileID host.FileID,
 	// inserting the data while we were waiting for the write lock.
 	state = mgr.state.WLock()
 	defer mgr.state.WUnlock(&state)
+	state.extractFailures.Remove(fileID)
 	if info, ok = state.executables[fileID]; ok {
 		info.rc++
 		return info.ExecutableInfo, nil
@@ -172,19 +245,52 @@ func (mgr *ExecutableInfoManager) AddOrIncRef(fileID host.FileID,
 	loaderInfo := interpreter.NewLoaderInfo(fileID, elfRef, gaps)
 
 	// Insert a corresponding record into our map.
+	data, transient := mgr.detectAndLoadInterpData(state, loaderInfo)
 	info = &entry{
 		ExecutableInfo: ExecutableInfo{
-			Data:    state.detectAndLoadInterpData(loaderInfo),
+			Data:    data,
 			TSDInfo: tsdInfo,
 		},
 		mapRef: ref,
 		rc:     1,
 	}
+	if transient {
+		info.gaps = gaps
+		info.interpRetries = maxInterpDetectionRetries
+	}
 	state.executables[fileID] = info
 
 	return info.ExecutableInfo, nil
 }
 
+// isStaticExecutable returns true if the ELF has no dynamic symbols and is not a Go
+// executable, whose thread specific data is not used by interpreters.
+func isStaticExecutable(elfRef *pfelf.Reference) bool {
+	ef, err := elfRef.GetELF()
+	return err == nil && !ef.HasDynamicSymbols() && !ef.IsGolang()
+}
+
+// recordExtractionFailure counts a failure to extract the stack deltas of the executable
+// and blacklists it once the failures reach maxExtractionFailures.
+func (mgr *ExecutableInfoManager) recordExtractionFailure(fileID host.FileID, fileName string) {
+	state := mgr.state.WLock()
+	defer mgr.state.WUnlock(&state)
+
+	failures, _ := state.extractFailures.Get(fileID)
+	failures++
+	if failures < maxExtractionFailures {
+		state.extractFailures.Add(fileID, failures)
+		return
+	}
+	state.extractFailures.Remove(fileID)
+	state.blacklisted.Add(fileID, libpf.Void{})
+	log.WithFields(log.Fields{
+		"fileID": fmt.Sprintf("%#016x", fileID),
+		"file":   fileName,
+	}).Warnf("Failed to extract stack deltas %d times, reporting raw native frames only",
+		maxExtractionFailures)
+}
+
 // AddSynthIntervalData should only be called once for a given file ID. It will error if it or
 // AddOrIncRef has been previously called for the same file ID. Interpreter detection is skipped.
 func (mgr *ExecutableInfoManager) AddSynthIntervalData(
@@ -257,8 +363,13 @@ func (mgr *ExecutableInfoManager) UpdateMetricSummary(summary metrics.Summary) {
 		metrics.MetricValue(len(state.unwindInfoIndex))
 	summary[metrics.IDHashmapNumStackDeltaPages] =
 		metrics.MetricValue(state.numStackDeltaMapPages)
+	summary[metrics.IDNumBlacklistedExecutables] =
+		metrics.MetricValue(state.blacklisted.Len())
 	mgr.state.RUnlock(&state)
 
+	summary[metrics.IDInterpreterUnsupportedVersion] =
+		metrics.MetricValue(mgr.unsupportedCount.Swap(0))
+
 	deltaProviderStatistics := mgr.sdp.GetAndResetStatistics()
 	summary[metrics.IDStackDeltaProviderCacheHit] =
 		metrics.MetricValue(deltaProviderStatistics.Hit)
@@ -290,37 +401,104 @@ type executableInfoManagerState struct {
 
 	// numStackDeltaMapPages tracks the current size of the corresponding eBPF map.
 	numStackDeltaMapPages uint64
+
+	// unsupportedWarned records, per interpreter runtime and version, when a warning
+	// about the version not being supported was last logged.
+	unsupportedWarned map[string]time.Time
+
+	// interpDisabled holds the executables whose interpreter support failed to load
+	// permanently, so that they are not probed by the loaders again when they are re-added.
+	interpDisabled map[host.FileID]libpf.Void
+
+	// extractFailures counts the consecutive failures to extract the stack deltas of the
+	// executables that are not blacklisted yet.
+	extractFailures *lru.LRU[host.FileID, uint32]
+
+	// blacklisted holds the executables whose stack deltas failed to be extracted
+	// maxExtractionFailures times in a row. They are added without stack deltas and
+	// interpreter data, so that their frames are reported as raw native frames without
+	// retrying the extraction. FileIDs are content hashes, so a changed file is not affected.
+	// Executables evicted from the LRU are retried.
+	blacklisted *lru.LRU[host.FileID, libpf.Void]
 }
 
 // detectAndLoadInterpData attempts to detect the given executable as an interpreter. If detection
 // succeeds, it then loads additional per-interpreter data into the BPF maps and returns the
-// interpreter data.
-func (state *executableInfoManagerState) detectAndLoadInterpData(
-	loaderInfo *interpreter.LoaderInfo) interpreter.Data {
+// interpreter data. Otherwise, the returned bool reports whether the detection failed due to
+// a transient error, so that it may succeed when retried.
+func (mgr *ExecutableInfoManager) detectAndLoadInterpData(state *executableInfoManagerState,
+	loaderInfo *interpreter.LoaderInfo) (interpreter.Data, bool) {
+	if _, disabled := state.interpDisabled[loaderInfo.FileID()]; disabled {
+		return nil, false
+	}
+
 	// Ask all interpreter loaders whether they want to handle this executable.
 	for _, loader := range state.interpreterLoaders {
-		data, err := loader(state.ebpf, loaderInfo)
+		if !loader.Detect(loaderInfo) {
+			continue
+		}
+		data, err := loader.New(state.ebpf, loaderInfo)
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
+			logger := log.WithFields(log.Fields{
+				"fileID": fmt.Sprintf("%#016x", loaderInfo.FileID()),
+				"file":   loaderInfo.FileName(),
+			})
+			if errors.Is(err, interpreter.ErrInterpreterVersionUnsupported) ||
+				errors.Is(err, interpreter.ErrOffsetsUnavailable) {
+				// Retrying does not help, the executable will never be supported.
+				state.interpDisabled[loaderInfo.FileID()] = libpf.Void{}
+			}
+			var versionErr *interpreter.UnsupportedVersionError
+			if errors.As(err, &versionErr) {
+				mgr.unsupportedCount.Add(1)
+				if state.shouldWarnUnsupported(versionErr, time.Now()) {
+					logger.Warnf("%s %s is not supported by this version of the agent "+
+						"(supported: %s), its frames are reported as native frames. "+
+						"Please upgrade the agent to profile %s %s.",
+						versionErr.Runtime, versionErr.Version, versionErr.Supported,
+						versionErr.Runtime, versionErr.Version)
+				} else {
+					logger.Debugf("Failed to load interpreter data: %v", err)
+				}
+			} else if errors.Is(err, os.ErrNotExist) {
 				// Very common if the process exited when we tried to analyze it.
-				log.Debugf("Failed to load %v (%#016x): file not found",
-					loaderInfo.FileName(), loaderInfo.FileID())
+				logger.Debugf("Failed to load interpreter data: file not found")
+			} else if errors.Is(err, interpreter.ErrMemoryReadFailed) {
+				logger.Debugf("Failed to load interpreter data: %v", err)
+			} else if pfelf.IsTransientError(err) {
+				logger.Debugf("Failed to load interpreter data, retrying later: %v", err)
+				return nil, true
 			} else {
-				log.Errorf("Failed to load %v (%#016x): %v",
-					loaderInfo.FileName(), loaderInfo.FileID(), err)
+				logger.Errorf("Failed to load interpreter data: %v", err)
 			}
-			return nil
+			return nil, false
 		}
 		if data == nil {
 			continue
 		}
 
-		log.Debugf("Interpreter data %v for %v (%#016x)",
-			data, loaderInfo.FileName(), loaderInfo.FileID())
-		return data
+		log.WithFields(log.Fields{
+			"fileID":      fmt.Sprintf("%#016x", loaderInfo.FileID()),
+			"file":        loaderInfo.FileName(),
+			"interpreter": fmt.Sprint(data),
+		}).Debugf("Loaded interpreter data")
+		return data, false
 	}
 
-	return nil
+	return nil, false
+}
+
+// shouldWarnUnsupported reports whether a warning about the unsupported interpreter version
+// described by err is due at time now, and records the warning if so.
+func (state *executableInfoManagerState) shouldWarnUnsupported(
+	err *interpreter.UnsupportedVersionError, now time.Time) bool {
+	key := err.Runtime + " " + err.Version
+	if last, ok := state.unsupportedWarned[key]; ok &&
+		now.Sub(last) < unsupportedVersionWarnInterval {
+		return false
+	}
+	state.unsupportedWarned[key] = now
+	return true
 }
 
 // loadDeltas converts the sdtypes.StackDelta to StackDeltaEBPF and passes that to
@@ -483,6 +661,11 @@ type entry struct {
 	mapRef mapRef
 	// rc determines in how many processes this executable is currently loaded.
 	rc uint64
+	// interpRetries is the number of remaining retries of the interpreter detection, which
+	// failed due to a transient error.
+	interpRetries int
+	// gaps are the gaps in the stack deltas, which are needed to retry the detection.
+	gaps []libpf.Range
 }
 
 // mapRef stores all info required to identify and remove
@@ -0,0 +1,238 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package execinfomanager
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/config"
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/interpreter"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind"
+	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/metrics"
+	pmebpf "github.com/elastic/otel-profiling-agent/processmanager/ebpf"
+)
+
+// stackDeltaProviderMock returns the next of results for each extraction, and a single
+// stack delta for successful extractions.
+type stackDeltaProviderMock struct {
+	results []error
+	calls   int
+}
+
+var _ nativeunwind.StackDeltaProvider = (*stackDeltaProviderMock)(nil)
+
+func (m *stackDeltaProviderMock) GetIntervalStructuresForFile(_ host.FileID,
+	_ *pfelf.Reference, result *sdtypes.IntervalData) error {
+	err := m.results[m.calls]
+	m.calls++
+	if err != nil {
+		return err
+	}
+	result.Deltas.Add(sdtypes.StackDelta{
+		Address: 0x1000,
+		Info:    sdtypes.UnwindInfo{Opcode: sdtypes.UnwindOpcodeBaseSP, Param: 8},
+	})
+	return nil
+}
+
+func (m *stackDeltaProviderMock) GetAndResetStatistics() nativeunwind.Statistics {
+	return nativeunwind.Statistics{}
+}
+
+// elfOpenerMock fails to open any ELF file.
+type elfOpenerMock struct{}
+
+func (elfOpenerMock) OpenELF(string) (*pfelf.File, error) { return nil, os.ErrNotExist }
+
+// ebpfMock implements the parts of the eBPF handler used to load and unload stack deltas.
+type ebpfMock struct {
+	pmebpf.EbpfHandler
+}
+
+func (m *ebpfMock) UpdateUnwindInfo(uint16, sdtypes.UnwindInfo) error { return nil }
+
+func (m *ebpfMock) UpdateExeIDToStackDeltas(host.FileID, []pmebpf.StackDeltaEBPF) (uint16,
+	error) {
+	return 1, nil
+}
+
+func (m *ebpfMock) DeleteExeIDToStackDeltas(host.FileID, uint16) error { return nil }
+
+func (m *ebpfMock) UpdateStackDeltaPages(host.FileID, []uint16, uint16, uint64) error {
+	return nil
+}
+
+func (m *ebpfMock) DeleteStackDeltaPage(host.FileID, uint64) error { return nil }
+
+func TestRecordExtractionFailure(t *testing.T) {
+	const fileID = host.FileID(0x1234)
+	errBroken := errors.New("broken executable")
+
+	tests := map[string]struct {
+		// results are the results of the consecutive extractions of the stack deltas.
+		// The executable is removed again after each successful extraction.
+		results []error
+		// failures is the expected number of counted extraction failures.
+		failures uint32
+		// blacklisted is set if the executable is expected to be blacklisted.
+		blacklisted bool
+	}{
+		"single failure": {
+			results:  []error{errBroken},
+			failures: 1,
+		},
+		"blacklisted after max failures": {
+			results:     []error{errBroken, errBroken, errBroken},
+			blacklisted: true,
+		},
+		"success resets the failures": {
+			results:  []error{errBroken, errBroken, nil, errBroken},
+			failures: 1,
+		},
+		"missing files are not counted": {
+			results: []error{os.ErrNotExist, os.ErrNotExist, os.ErrNotExist},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			sdp := &stackDeltaProviderMock{results: test.results}
+			mgr, err := NewExecutableInfoManager(sdp, &ebpfMock{},
+				make([]bool, config.MaxTracers))
+			require.NoError(t, err)
+			elfRef := pfelf.NewReference("/usr/bin/broken", elfOpenerMock{})
+
+			for _, result := range test.results {
+				_, err = mgr.AddOrIncRef(fileID, elfRef)
+				if result != nil {
+					require.ErrorIs(t, err, result)
+					continue
+				}
+				require.NoError(t, err)
+				require.NoError(t, mgr.RemoveOrDecRef(fileID))
+			}
+
+			state := mgr.state.RLock()
+			failures, _ := state.extractFailures.Get(fileID)
+			blacklisted := state.blacklisted.Contains(fileID)
+			mgr.state.RUnlock(&state)
+			assert.Equal(t, test.failures, failures)
+			assert.Equal(t, test.blacklisted, blacklisted)
+
+			summary := metrics.Summary{}
+			mgr.UpdateMetricSummary(summary)
+			numBlacklisted := metrics.MetricValue(0)
+			if test.blacklisted {
+				numBlacklisted = 1
+			}
+			assert.Equal(t, numBlacklisted, summary[metrics.IDNumBlacklistedExecutables])
+
+			if !test.blacklisted {
+				return
+			}
+			// Blacklisted executables are added without stack deltas and without
+			// extracting them again.
+			info, err := mgr.AddOrIncRef(fileID, elfRef)
+			require.NoError(t, err)
+			assert.Equal(t, ExecutableInfo{}, info)
+			assert.Equal(t, len(test.results), sdp.calls)
+
+			state = mgr.state.RLock()
+			mapID := state.executables[fileID].mapRef.MapID
+			mgr.state.RUnlock(&state)
+			assert.Equal(t, uint16(0), mapID)
+			require.NoError(t, mgr.RemoveOrDecRef(fileID))
+		})
+	}
+}
+
+// flakyLoaderMock is an interpreter loader that detects all executables, and whose loading
+// of the interpreter data fails with the next of results until they are exhausted.
+type flakyLoaderMock struct {
+	results []error
+	calls   int
+}
+
+func (m *flakyLoaderMock) Detect(*interpreter.LoaderInfo) bool { return true }
+
+func (m *flakyLoaderMock) New(interpreter.EbpfHandler, *interpreter.LoaderInfo) (
+	interpreter.Data, error) {
+	m.calls++
+	if len(m.results) > 0 {
+		err := m.results[0]
+		m.results = m.results[1:]
+		return nil, err
+	}
+	return &interpreterDataMock{}, nil
+}
+
+// interpreterDataMock is the interpreter data loaded by flakyLoaderMock.
+type interpreterDataMock struct {
+	interpreter.Data
+}
+
+func TestInterpreterDetectionRetry(t *testing.T) {
+	const fileID = host.FileID(0x1234)
+	errIO := &os.PathError{Op: "read", Path: "/usr/bin/python3", Err: syscall.EIO}
+
+	tests := map[string]struct {
+		// results are the results of the consecutive loads of the interpreter data.
+		results []error
+		// loaded is set if the interpreter data is expected to be loaded eventually.
+		loaded bool
+		// calls is the expected number of loads of the interpreter data.
+		calls int
+	}{
+		"transient error is retried": {
+			results: []error{errIO},
+			loaded:  true,
+			calls:   2,
+		},
+		"retries are bounded": {
+			results: []error{errIO, errIO, errIO, errIO},
+			calls:   1 + maxInterpDetectionRetries,
+		},
+		"permanent error is not retried": {
+			results: []error{errors.New("broken interpreter")},
+			calls:   1,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			sdp := &stackDeltaProviderMock{results: []error{nil}}
+			mgr, err := NewExecutableInfoManager(sdp, &ebpfMock{},
+				make([]bool, config.MaxTracers))
+			require.NoError(t, err)
+			loader := &flakyLoaderMock{results: test.results}
+			state := mgr.state.WLock()
+			state.interpreterLoaders = []interpreter.Loader{loader}
+			mgr.state.WUnlock(&state)
+			elfRef := pfelf.NewReference("/usr/bin/python3", elfOpenerMock{})
+
+			var info ExecutableInfo
+			for i := 0; i < 2+maxInterpDetectionRetries; i++ {
+				info, err = mgr.AddOrIncRef(fileID, elfRef)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.loaded, info.Data != nil)
+			assert.Equal(t, test.calls, loader.calls)
+			assert.Equal(t, 1, sdp.calls)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/elfunwindinfo"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/metrics"
+)
+
+// Upper bounds of the .gopclntab sizes of the buckets symbolization statistics are
+// accounted to. Larger symbol tables are accounted to the last bucket.
+const (
+	goSymbolsSmallSize  = 1 << 20
+	goSymbolsMediumSize = 16 << 20
+
+	numGoSymbolsSizeBuckets = 3
+)
+
+// symbolizationStats records the number of symbolized frames and the time spent in
+// symbolization, bucketed by the .gopclntab size of the executable.
+type symbolizationStats struct {
+	frames [numGoSymbolsSizeBuckets]atomic.Uint64
+	usec   [numGoSymbolsSizeBuckets]atomic.Uint64
+}
+
+// goSymbolsSizeBucket returns the bucket index of a .gopclntab of the given size.
+func goSymbolsSizeBucket(size uint64) int {
+	switch {
+	case size < goSymbolsSmallSize:
+		return 0
+	case size < goSymbolsMediumSize:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// addDuration accounts the time since start to the bucket of the symbol table size.
+func (s *symbolizationStats) addDuration(size uint64, start time.Time) {
+	s.usec[goSymbolsSizeBucket(size)].Add(uint64(time.Since(start).Microseconds()))
+}
+
+// updateMetricSummary adds the statistics to summary and resets them.
+func (s *symbolizationStats) updateMetricSummary(summary metrics.Summary) {
+	frameIDs := [numGoSymbolsSizeBuckets]metrics.MetricID{
+		metrics.IDGoSymbolizationSmall,
+		metrics.IDGoSymbolizationMedium,
+		metrics.IDGoSymbolizationLarge,
+	}
+	usecIDs := [numGoSymbolsSizeBuckets]metrics.MetricID{
+		metrics.IDGoSymbolizationSmallUsec,
+		metrics.IDGoSymbolizationMediumUsec,
+		metrics.IDGoSymbolizationLargeUsec,
+	}
+	for i := range frameIDs {
+		summary[frameIDs[i]] = metrics.MetricValue(s.frames[i].Swap(0))
+		summary[usecIDs[i]] = metrics.MetricValue(s.usec[i].Swap(0))
+	}
+}
+
+// addGoSymbolTable creates and caches the symbol table of the Go executable ef, unless
+// it is cached already. The .gopclntab based symbol table works without DWARF data,
+// an ELF symbol table or a dynamic loader, and thus also for statically linked and
+// stripped executables.
+func (pm *ProcessManager) addGoSymbolTable(fileID host.FileID, ef *pfelf.File) {
+	if pm.goSymbolTables.Contains(fileID) {
+		return
+	}
+	start := time.Now()
+	symbols, err := elfunwindinfo.NewGoSymbolTable(ef)
+	if err != nil {
+		log.WithFields(log.Fields{"fileID": fmt.Sprintf("%#016x", fileID)}).Debugf(
+			"Failed to create Go symbol table: %v", err)
+		return
+	}
+	pm.goSymbolStats.addDuration(symbols.Size(), start)
+	pm.goSymbolTables.Add(fileID, symbols)
+}
+
+// goFrame is a native frame of a Go executable that is queued for symbolization.
+type goFrame struct {
+	symbols *elfunwindinfo.GoSymbolTable
+	fileID  libpf.FileID
+	addr    libpf.AddressOrLineno
+}
+
+// symbolizeGoFrame queues a native frame for symbolization, if the frame belongs to a Go
+// executable. Each frame is reported once while it is cached. It is called when converting
+// traces, so the symbolization itself is left to symbolizeGoFrames.
+func (pm *ProcessManager) symbolizeGoFrame(hostFileID host.FileID, fileID libpf.FileID,
+	addr libpf.AddressOrLineno) {
+	symbols, ok := pm.goSymbolTables.Get(hostFileID)
+	if !ok {
+		return
+	}
+	frameID := libpf.NewFrameID(fileID, addr)
+	if pm.reportedGoFrames.Contains(frameID) {
+		return
+	}
+
+	select {
+	case pm.goFrames <- goFrame{symbols: symbols, fileID: fileID, addr: addr}:
+		pm.reportedGoFrames.Add(frameID, libpf.Void{})
+	default:
+		// The queue is full. The frame is queued again when it is seen the next time.
+	}
+}
+
+// symbolizeGoFrames symbolizes and reports the queued Go frames until ctx is canceled.
+func (pm *ProcessManager) symbolizeGoFrames(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame := <-pm.goFrames:
+			pm.reportGoFrame(&frame)
+		}
+	}
+}
+
+// reportGoFrame reports the function name and source line of frame.
+func (pm *ProcessManager) reportGoFrame(frame *goFrame) {
+	symbols := frame.symbols
+	start := time.Now()
+	info, ok := symbols.Lookup(uint64(frame.addr))
+	pm.goSymbolStats.addDuration(symbols.Size(), start)
+	if !ok {
+		return
+	}
+	pm.goSymbolStats.frames[goSymbolsSizeBucket(symbols.Size())].Add(1)
+	// The .gopclntab does not record the first line of a function. Report the offset
+	// from the function entry in bytes instead.
+	pm.reporter.FrameMetadata(frame.fileID, frame.addr, libpf.SourceLineno(info.Line),
+		uint32(uint64(frame.addr)-info.FunctionStart), info.FunctionName, info.FileName)
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/config"
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/metrics"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+// frameMetadata holds the arguments of a SymbolReporter.FrameMetadata call.
+type frameMetadata struct {
+	addressOrLine  libpf.AddressOrLineno
+	lineNumber     libpf.SourceLineno
+	functionOffset uint32
+	functionName   string
+	filePath       string
+}
+
+// frameMetadataRecorder is a reporter.SymbolReporter that records reported frames.
+type frameMetadataRecorder struct {
+	mu     sync.Mutex
+	frames []frameMetadata
+}
+
+// reported returns a copy of the recorded frames.
+func (r *frameMetadataRecorder) reported() []frameMetadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.frames)
+}
+
+var _ reporter.SymbolReporter = (*frameMetadataRecorder)(nil)
+
+func (r *frameMetadataRecorder) ReportFallbackSymbol(libpf.FrameID, string) {}
+
+func (r *frameMetadataRecorder) ExecutableMetadata(context.Context, libpf.FileID, string,
+	string, uint64, uint64, pfelf.AddressMapper) {
+}
+
+func (r *frameMetadataRecorder) FrameMetadata(_ libpf.FileID,
+	addressOrLine libpf.AddressOrLineno, lineNumber libpf.SourceLineno,
+	functionOffset uint32, functionName, filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, frameMetadata{
+		addressOrLine:  addressOrLine,
+		lineNumber:     lineNumber,
+		functionOffset: functionOffset,
+		functionName:   functionName,
+		filePath:       filePath,
+	})
+}
+
+func TestSymbolizeGoFrame(t *testing.T) {
+	// helloworld is statically linked, it has no PT_INTERP and no .dynsym.
+	for _, name := range []string{"helloworld", "helloworld.pie"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			ef, err := pfelf.Open(filepath.Join("..", "libpf", "nativeunwind",
+				"elfunwindinfo", "testdata", name))
+			require.NoError(t, err)
+			defer ef.Close()
+			require.True(t, ef.IsGolang())
+
+			symbols, err := ef.ReadSymbols()
+			require.NoError(t, err)
+			mainAddr, err := symbols.LookupSymbolAddress("main.main")
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			recorder := &frameMetadataRecorder{}
+			pm, err := New(ctx, make([]bool, config.MaxTracers), time.Hour, nil, nil,
+				recorder, nil, true)
+			require.NoError(t, err)
+
+			hostFileID := host.FileID(42)
+			fileID := libpf.NewFileID(42, 42)
+			pm.addGoSymbolTable(hostFileID, ef)
+
+			addr := libpf.AddressOrLineno(mainAddr + 1)
+			pm.symbolizeGoFrame(hostFileID, fileID, addr)
+			// Frames are reported only once.
+			pm.symbolizeGoFrame(hostFileID, fileID, addr)
+			// Addresses outside of Go functions and unknown files are not reported.
+			pm.symbolizeGoFrame(hostFileID, fileID, 0)
+			pm.symbolizeGoFrame(host.FileID(43), fileID, addr)
+			// The frames are symbolized in the background, in the order they were
+			// queued. Once the last frame is reported, all others are processed.
+			lastAddr := addr + 1
+			pm.symbolizeGoFrame(hostFileID, fileID, lastAddr)
+
+			require.Eventually(t, func() bool {
+				return len(recorder.reported()) == 2
+			}, 5*time.Second, 10*time.Millisecond)
+			frames := recorder.reported()
+			assert.Equal(t, lastAddr, frames[1].addressOrLine)
+			frame := frames[0]
+			assert.Equal(t, addr, frame.addressOrLine)
+			assert.Equal(t, "main.main", frame.functionName)
+			assert.Equal(t, "helloworld.go", filepath.Base(frame.filePath))
+			assert.Equal(t, libpf.SourceLineno(15), frame.lineNumber)
+			assert.Equal(t, uint32(1), frame.functionOffset)
+
+			// Frames are accounted to the bucket of the .gopclntab size.
+			summary := make(metrics.Summary)
+			pm.goSymbolStats.updateMetricSummary(summary)
+			assert.Equal(t, metrics.MetricValue(2), summary[metrics.IDGoSymbolizationSmall])
+			assert.Equal(t, metrics.MetricValue(0), summary[metrics.IDGoSymbolizationLarge])
+		})
+	}
+}
+
+func TestGoSymbolsSizeBucket(t *testing.T) {
+	assert.Equal(t, 0, goSymbolsSizeBucket(0))
+	assert.Equal(t, 0, goSymbolsSizeBucket(goSymbolsSmallSize-1))
+	assert.Equal(t, 1, goSymbolsSizeBucket(goSymbolsSmallSize))
+	assert.Equal(t, 2, goSymbolsSizeBucket(goSymbolsMediumSize))
+}
@@ -7,6 +7,8 @@
 package processmanager
 
 import (
+	"fmt"
+
 	lru "github.com/elastic/go-freelru"
 	log "github.com/sirupsen/logrus"
 
@@ -37,7 +39,8 @@ func (fm *lruFileIDMapper) Get(key host.FileID) (libpf.FileID, bool) {
 		return fileID, true
 	}
 
-	log.Warnf("Failed to lookup file ID %#x", key)
+	log.WithFields(log.Fields{"fileID": fmt.Sprintf("%#016x", key)}).Warnf(
+		"Failed to lookup file ID")
 	return libpf.FileID{}, false
 }
 
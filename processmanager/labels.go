@@ -0,0 +1,91 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proc"
+)
+
+const (
+	// maxProcessLabels is the maximum number of labels taken from the environment of
+	// a process. Further labels are ignored.
+	maxProcessLabels = 8
+	// maxProcessLabelLength is the maximum length of label names and values. Longer
+	// ones are truncated.
+	maxProcessLabelLength = 128
+)
+
+// labelsFromEnvironment returns the labels defined by the environment variables env that
+// start with prefix. The label name is the lower case remainder of the variable name, e.g.
+// PREFIX_TENANT=acme defines the label tenant with value acme. nil is returned if there
+// are no labels.
+func labelsFromEnvironment(env []string, prefix string) map[string]string {
+	var labels map[string]string
+	for _, kv := range env {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || value == "" {
+			continue
+		}
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		name = truncate(strings.ToLower(name), maxProcessLabelLength)
+		if _, exists := labels[name]; exists {
+			continue
+		}
+		if len(labels) == maxProcessLabels {
+			break
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[name] = truncate(value, maxProcessLabelLength)
+	}
+	return labels
+}
+
+// truncate returns s shortened to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// mergeLabels returns the union of labels and other, taking the values of other for labels
+// defined in both. A new map is returned, as the labels of a process are handed out to
+// the reporter and must not be modified.
+func mergeLabels(labels, other map[string]string) map[string]string {
+	if len(labels) == 0 && len(other) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(labels)+len(other))
+	for name, value := range labels {
+		result[name] = value
+	}
+	for name, value := range other {
+		result[name] = value
+	}
+	return result
+}
+
+// readProcessLabels returns the labels defined by the environment of the process pid.
+// Processes whose environment can not be read have no labels.
+func readProcessLabels(pid libpf.PID, prefix string) map[string]string {
+	env, err := proc.GetEnvironment(pid)
+	if err != nil {
+		log.Debugf("Failed to read environment of PID %d: %v", pid, err)
+		return nil
+	}
+	return labelsFromEnvironment(env, prefix)
+}
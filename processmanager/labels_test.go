@@ -0,0 +1,57 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsFromEnvironment(t *testing.T) {
+	const prefix = "OTEL_PROFILE_LABEL_"
+
+	assert.Nil(t, labelsFromEnvironment(nil, prefix))
+	assert.Nil(t, labelsFromEnvironment([]string{"PATH=/bin", "HOME=/root"}, prefix))
+
+	env := []string{
+		"PATH=/bin",
+		"OTEL_PROFILE_LABEL_TENANT=acme",
+		"OTEL_PROFILE_LABEL_Request_ID=1234=5678",
+		"OTEL_PROFILE_LABEL_EMPTY=",
+		"OTEL_PROFILE_LABEL_=nameless",
+		"OTEL_PROFILE_LABEL_NOVALUE",
+		"OTEL_PROFILE_LABEL_TENANT=other",
+	}
+	assert.Equal(t, map[string]string{
+		"tenant":     "acme",
+		"request_id": "1234=5678",
+	}, labelsFromEnvironment(env, prefix))
+
+	env = nil
+	for i := 0; i < maxProcessLabels+2; i++ {
+		env = append(env, fmt.Sprintf("%sL%d=%s", prefix, i, strings.Repeat("x", 200)))
+	}
+	labels := labelsFromEnvironment(env, prefix)
+	assert.Len(t, labels, maxProcessLabels)
+	assert.Len(t, labels["l0"], maxProcessLabelLength)
+	assert.NotContains(t, labels, fmt.Sprintf("l%d", maxProcessLabels))
+}
+
+func TestMergeLabels(t *testing.T) {
+	assert.Nil(t, mergeLabels(nil, nil))
+
+	labels := map[string]string{"tenant": "acme", "python.version": "3.11"}
+	version := map[string]string{"python.version": "3.12"}
+	merged := mergeLabels(labels, version)
+	assert.Equal(t, map[string]string{"tenant": "acme", "python.version": "3.12"}, merged)
+	assert.Equal(t, "3.11", labels["python.version"])
+
+	assert.Equal(t, version, mergeLabels(nil, version))
+}
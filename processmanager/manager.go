@@ -11,15 +11,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
 	"time"
 
 	lru "github.com/elastic/go-freelru"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/host"
 	"github.com/elastic/otel-profiling-agent/interpreter"
 	"github.com/elastic/otel-profiling-agent/libpf"
 	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/elfunwindinfo"
 	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
 	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
 	"github.com/elastic/otel-profiling-agent/libpf/traceutil"
@@ -40,6 +43,16 @@ const (
 
 	// TTL of entries in the LRU cache holding the executables' ELF information.
 	elfInfoCacheTTL = 6 * time.Hour
+
+	// Maximum number of Go executables for which the parsed .gopclntab is cached.
+	// Each entry holds the full .gopclntab, so this is kept small.
+	goSymbolTableCacheSize = 16
+
+	// Maximum number of Go frames for which symbolization results were reported.
+	reportedGoFramesCacheSize = 65536
+
+	// Maximum number of Go frames queued for symbolization.
+	goFrameQueueSize = 4096
 )
 
 var (
@@ -72,6 +85,13 @@ func New(ctx context.Context, includeTracers []bool, monitorInterval time.Durati
 		}
 	}
 
+	for _, pattern := range config.CommandLineRedact() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid command line redaction pattern '%s': %v",
+				pattern, err)
+		}
+	}
+
 	elfInfoCache, err := lru.New[libpf.OnDiskFileIdentifier, elfInfo](elfInfoCacheSize,
 		libpf.OnDiskFileIdentifier.Hash32)
 	if err != nil {
@@ -79,7 +99,22 @@ func New(ctx context.Context, includeTracers []bool, monitorInterval time.Durati
 	}
 	elfInfoCache.SetLifetime(elfInfoCacheTTL)
 
-	em := eim.NewExecutableInfoManager(sdp, ebpf, includeTracers)
+	goSymbolTables, err := lru.NewSynced[host.FileID, *elfunwindinfo.GoSymbolTable](
+		goSymbolTableCacheSize, func(k host.FileID) uint32 { return uint32(k) })
+	if err != nil {
+		return nil, fmt.Errorf("unable to create goSymbolTables: %v", err)
+	}
+
+	reportedGoFrames, err := lru.NewSynced[libpf.FrameID, libpf.Void](
+		reportedGoFramesCacheSize, libpf.FrameID.Hash32)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create reportedGoFrames: %v", err)
+	}
+
+	em, err := eim.NewExecutableInfoManager(sdp, ebpf, includeTracers)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create ExecutableInfoManager: %v", err)
+	}
 
 	interpreters := make(map[libpf.PID]map[libpf.OnDiskFileIdentifier]interpreter.Instance)
 
@@ -89,15 +124,22 @@ func New(ctx context.Context, includeTracers []bool, monitorInterval time.Durati
 		interpreters:             interpreters,
 		exitEvents:               make(map[libpf.PID]libpf.KTime),
 		pidToProcessInfo:         make(map[libpf.PID]*processInfo),
+		sharedAddressSpace:       make(map[libpf.PID]*sharedProcess),
 		ebpf:                     ebpf,
 		FileIDMapper:             fileIDMapper,
 		elfInfoCache:             elfInfoCache,
+		goSymbolTables:           goSymbolTables,
+		reportedGoFrames:         reportedGoFrames,
+		goFrames:                 make(chan goFrame, goFrameQueueSize),
 		reporter:                 symbolReporter,
 		metricsAddSlice:          metrics.AddSlice,
 		filterErrorFrames:        filterErrorFrames,
+		reportedSyntheticFrames:  make(map[libpf.FileID]time.Time),
+		maxProcesses:             int(config.MaxTrackedProcesses()),
 	}
 
 	collectInterpreterMetrics(ctx, pm, monitorInterval)
+	go pm.symbolizeGoFrames(ctx)
 
 	return pm, nil
 }
@@ -140,14 +182,20 @@ func collectInterpreterMetrics(ctx context.Context, pm *ProcessManager,
 		for pid := range pm.interpreters {
 			for addr := range pm.interpreters[pid] {
 				if err := updateMetricSummary(pm.interpreters[pid][addr], summary); err != nil {
-					log.Errorf("Failed to get/reset metrics for PID %d at 0x%x: %v",
-						pid, addr, err)
+					log.WithFields(log.Fields{"pid": pid}).Errorf(
+						"Failed to get/reset metrics at 0x%x: %v", addr, err)
 				}
 			}
 		}
 
 		summary[metrics.IDHashmapPidPageToMappingInfo] =
 			metrics.MetricValue(pm.pidPageToMappingInfoSize)
+		summary[metrics.IDTrackedProcesses] =
+			metrics.MetricValue(pm.numTrackedProcesses())
+		summary[metrics.IDEvictedProcesses] =
+			metrics.MetricValue(pm.evictedProcesses.Swap(0))
+		summary[metrics.IDSymbolizationTimeouts] =
+			metrics.MetricValue(pm.symbolizationTimeouts.Swap(0))
 
 		summary[metrics.IDELFInfoCacheHit] =
 			metrics.MetricValue(pm.elfInfoCacheHit.Swap(0))
@@ -167,6 +215,8 @@ func collectInterpreterMetrics(ctx context.Context, pm *ProcessManager,
 		summary[metrics.IDTotalProcParseUsec] =
 			metrics.MetricValue(pm.mappingStats.totalProcParseUsec.Swap(0))
 
+		pm.goSymbolStats.updateMetricSummary(summary)
+
 		mapsMetrics := pm.ebpf.CollectMetrics()
 		for _, metric := range mapsMetrics {
 			summary[metric.ID] = metric.Value
@@ -180,16 +230,25 @@ func collectInterpreterMetrics(ctx context.Context, pm *ProcessManager,
 func (pm *ProcessManager) Close() {
 }
 
+// symbolizeFrame symbolizes the interpreter frame of trace with the given index into
+// newTrace. The memory reads of the interpreter instances fail after deadline, unless it
+// is zero, so that the symbolization ends by the deadline.
 func (pm *ProcessManager) symbolizeFrame(frame int, trace *host.Trace,
-	newTrace *libpf.Trace) error {
+	newTrace *libpf.Trace, deadline time.Time) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
+	pm.symbolizationDeadline.set(deadline)
+	defer pm.symbolizationDeadline.set(time.Time{})
 
-	if len(pm.interpreters[trace.PID]) == 0 {
+	pid := trace.PID
+	if shared, ok := pm.sharedAddressSpace[pid]; ok {
+		pid = shared.ppid
+	}
+	if len(pm.interpreters[pid]) == 0 {
 		return fmt.Errorf("interpreter process gone")
 	}
 
-	for _, instance := range pm.interpreters[trace.PID] {
+	for _, instance := range pm.interpreters[pid] {
 		if err := instance.Symbolize(pm.reporter, &trace.Frames[frame], newTrace); err != nil {
 			if errors.Is(err, interpreter.ErrMismatchInterpreterType) {
 				// The interpreter type of instance did not match the type of frame.
@@ -202,7 +261,17 @@ func (pm *ProcessManager) symbolizeFrame(frame int, trace *host.Trace,
 	}
 
 	return fmt.Errorf("no matching interpreter instance (of len %d): %w",
-		len(pm.interpreters[trace.PID]), errSymbolizationNotSupported)
+		len(pm.interpreters[pid]), errSymbolizationNotSupported)
+}
+
+// AttachInterpreterInstance registers an interpreter instance that was created outside of
+// the process manager for the given PID. This allows driving the symbolization of
+// interpreter frames with injected instances, e.g. from benchmarks.
+func (pm *ProcessManager) AttachInterpreterInstance(pid libpf.PID,
+	key libpf.OnDiskFileIdentifier, instance interpreter.Instance) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.assignInterpreter(pid, key, instance)
 }
 
 func (pm *ProcessManager) ConvertTrace(trace *host.Trace) (newTrace *libpf.Trace) {
@@ -214,8 +283,40 @@ func (pm *ProcessManager) ConvertTrace(trace *host.Trace) (newTrace *libpf.Trace
 		FrameTypes: make([]libpf.FrameType, 0, traceLen),
 	}
 
+	if pm.maxProcesses > 0 {
+		pm.markSampled(trace.PID, trace.KTime)
+	}
+
+	// The user mode frames of emulated processes belong to the emulator and not to the
+	// program it runs, so they are replaced with a single frame naming the emulator. The
+	// same applies to 32-bit processes, whose stacks are not unwound.
+	singleFileID, singleFrame := pm.userFramesFileID(trace.PID)
+	maxDepth := int(config.MaxStackDepth())
+
+	// The symbolization of interpreter frames reads the memory of the process, which can
+	// take long for corrupt or adversarial stacks. Once the deadline has passed, the reads
+	// fail and the remaining frames are dropped, so that a single trace does not stall the
+	// pipeline.
+	var deadline time.Time
+	if timeout := config.SymbolizationTimeout(); timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	timedOut := false
+
 	for i := 0; i < traceLen; i++ {
+		if maxDepth != 0 && len(newTrace.FrameTypes) > maxDepth {
+			// The remaining frames are collapsed into the deeper frame below.
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			// The remaining frames are replaced with the timeout frame below.
+			timedOut = true
+			break
+		}
 		frame := &trace.Frames[i]
+		if singleFrame && frame.Type != libpf.KernelFrame {
+			continue
+		}
 
 		if frame.Type.IsError() {
 			if !pm.filterErrorFrames {
@@ -268,30 +369,131 @@ func (pm *ProcessManager) ConvertTrace(trace *host.Trace) (newTrace *libpf.Trace
 			}
 			fileID, ok := pm.FileIDMapper.Get(frame.File)
 			if !ok {
-				log.Debugf(
-					"file ID lookup failed for PID %d, frame %d/%d, frame type %d",
-					trace.PID, i, traceLen, frame.Type)
+				log.WithFields(log.Fields{"pid": trace.PID}).Debugf(
+					"file ID lookup failed for frame %d/%d, frame type %d",
+					i, traceLen, frame.Type)
 
 				newTrace.AppendFrame(frame.Type, libpf.UnsymbolizedFileID,
 					libpf.AddressOrLineno(0))
 				continue
 			}
 			newTrace.AppendFrame(frame.Type, fileID, relativeRIP)
+			if frame.Type == libpf.NativeFrame {
+				pm.symbolizeGoFrame(frame.File, fileID, relativeRIP)
+			}
 		default:
-			err := pm.symbolizeFrame(i, trace, newTrace)
+			if config.FramesOnly() {
+				// The symbolization of interpreter frames is left to the backend.
+				appendRawFrame(newTrace, frame)
+				continue
+			}
+			err := pm.symbolizeFrame(i, trace, newTrace, deadline)
+			if err != nil && !deadline.IsZero() && time.Now().After(deadline) {
+				// The frame is replaced with the timeout frame below, and the deadline
+				// check above ends the loop.
+				timedOut = true
+				continue
+			}
 			if err != nil {
-				log.Debugf(
-					"symbolization failed for PID %d, frame %d/%d, frame type %d: %v",
-					trace.PID, i, traceLen, frame.Type, err)
+				log.WithFields(log.Fields{
+					"pid":         trace.PID,
+					"interpreter": frame.Type.Interpreter().String(),
+				}).Debugf("symbolization failed for frame %d/%d, frame type %d: %v",
+					i, traceLen, frame.Type, err)
 
-				newTrace.AppendFrame(frame.Type, libpf.UnsymbolizedFileID, libpf.AddressOrLineno(0))
+				pm.appendUnsymbolizedFrame(newTrace, frame)
 			}
 		}
 	}
+	if maxDepth != 0 && len(newTrace.FrameTypes) > maxDepth {
+		pm.collapseDeeperFrames(newTrace, maxDepth)
+	}
+	if timedOut {
+		pm.symbolizationTimeouts.Add(1)
+		log.WithFields(log.Fields{"pid": trace.PID}).Debugf(
+			"symbolization timed out after %d/%d frames", len(newTrace.FrameTypes), traceLen)
+		pm.appendSyntheticFrame(newTrace, symbolizationTimeoutFileID,
+			"[symbolization-timeout]")
+	}
+	if singleFrame {
+		newTrace.AppendFrame(libpf.NativeFrame, singleFileID, 0)
+	}
 	newTrace.Hash = traceutil.HashTrace(newTrace)
 	return newTrace
 }
 
+// deeperFileID is the synthetic file ID of the frame replacing the frames beyond the
+// maximum stack depth.
+var deeperFileID = libpf.NewFileID(0x6465657065720000, 0) // "deeper"
+
+// collapseDeeperFrames replaces the frames of trace beyond maxDepth, counted from the
+// innermost frame, with a single frame named [deeper]. As the trace hash is computed from
+// the frames, traces that only differ in the collapsed frames are aggregated. Like the
+// placeholder frames of the frame trimming of the reporter, the frame is a synthetic frame.
+func (pm *ProcessManager) collapseDeeperFrames(trace *libpf.Trace, maxDepth int) {
+	trace.FrameTypes = trace.FrameTypes[:maxDepth]
+	trace.Files = trace.Files[:maxDepth]
+	trace.Linenos = trace.Linenos[:maxDepth]
+	pm.appendSyntheticFrame(trace, deeperFileID, "[deeper]")
+}
+
+// symbolizationTimeoutFileID is the synthetic file ID of the frame replacing the frames
+// that were not symbolized within the symbolization timeout.
+var symbolizationTimeoutFileID = libpf.NewFileID(0x74696d656f757400, 0) // "timeout"
+
+// syntheticFrameReportInterval is the interval in which the metadata of synthetic frames is
+// reported again while they are used. The reporter keeps frame metadata in a size bounded
+// cache, from which it may have been evicted since.
+const syntheticFrameReportInterval = 10 * time.Second
+
+// appendSyntheticFrame appends a synthetic frame with the given file ID to trace. It is a
+// native frame whose name is reported as frame metadata.
+func (pm *ProcessManager) appendSyntheticFrame(trace *libpf.Trace, fileID libpf.FileID,
+	name string) {
+	pm.reportSyntheticFrame(fileID, name)
+	trace.AppendFrame(libpf.NativeFrame, fileID, 0)
+}
+
+// reportSyntheticFrame reports name as the frame metadata of the synthetic frame with the
+// given file ID, unless it was reported within syntheticFrameReportInterval.
+func (pm *ProcessManager) reportSyntheticFrame(fileID libpf.FileID, name string) {
+	now := time.Now()
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if now.Sub(pm.reportedSyntheticFrames[fileID]) < syntheticFrameReportInterval {
+		return
+	}
+	pm.reportedSyntheticFrames[fileID] = now
+	pm.reporter.FrameMetadata(fileID, 0, 0, 0, name, "")
+}
+
+// placeholderFileIDHi is the upper half of the synthetic file IDs of the placeholder frames
+// of interpreter frames that can not be symbolized.
+const placeholderFileIDHi = 0x756e6b6e6f776e00 // "unknown"
+
+// appendUnsymbolizedFrame appends the interpreter frame that failed to be symbolized to
+// trace as configured by config.UnsymbolizedFrames.
+func (pm *ProcessManager) appendUnsymbolizedFrame(trace *libpf.Trace, frame *host.Frame) {
+	switch config.UnsymbolizedFrames() {
+	case config.UnsymbolizedDrop:
+	case config.UnsymbolizedPlaceholder:
+		interp := frame.Type.Interpreter()
+		fileID := libpf.NewFileID(placeholderFileIDHi, uint64(interp))
+		pm.reportSyntheticFrame(fileID, fmt.Sprintf("[%s unknown]", interp))
+		trace.AppendFrame(frame.Type, fileID, 0)
+	case config.UnsymbolizedRawAddress:
+		appendRawFrame(trace, frame)
+	default:
+		trace.AppendFrame(frame.Type, libpf.UnsymbolizedFileID, libpf.AddressOrLineno(0))
+	}
+}
+
+// appendRawFrame appends the interpreter frame to trace as unsymbolized frame with the raw
+// value the eBPF unwinder recorded for it.
+func appendRawFrame(trace *libpf.Trace, frame *host.Frame) {
+	trace.AppendFrame(frame.Type, libpf.UnsymbolizedFileID, frame.Lineno)
+}
+
 func (pm *ProcessManager) SymbolizationComplete(traceCaptureKTime libpf.KTime) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -304,8 +506,8 @@ func (pm *ProcessManager) SymbolizationComplete(traceCaptureKTime libpf.KTime) {
 		}
 		for _, instance := range pm.interpreters[pid] {
 			if err := instance.Detach(pm.ebpf, pid); err != nil {
-				log.Errorf("Failed to handle interpreted process exit for PID %d: %v",
-					pid, err)
+				log.WithFields(log.Fields{"pid": pid}).Errorf(
+					"Failed to handle interpreted process exit: %v", err)
 			}
 		}
 		delete(pm.interpreters, pid)
@@ -10,6 +10,7 @@ package processmanager
 
 import (
 	"context"
+	"debug/elf"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -32,6 +33,7 @@ import (
 	"github.com/elastic/otel-profiling-agent/lpm"
 	"github.com/elastic/otel-profiling-agent/metrics"
 	pmebpf "github.com/elastic/otel-profiling-agent/processmanager/ebpf"
+	"github.com/elastic/otel-profiling-agent/reporter"
 )
 
 // dummyProcess implements pfelf.Process for testing purposes
@@ -168,6 +170,8 @@ type ebpfMapsMockup struct {
 	deletePidPageMappingCount uint8
 	// expectedBias value for updatedPidPageToExeIDOffset calls
 	expectedBias uint64
+	// pidPageMappings is the number of pid_page_to_mapping_info entries of each PID.
+	pidPageMappings map[libpf.PID]int
 }
 
 var _ interpreter.EbpfHandler = &ebpfMapsMockup{}
@@ -225,6 +229,10 @@ func (mockup *ebpfMapsMockup) DeleteStackDeltaPage(host.FileID, uint64) error {
 
 func (mockup *ebpfMapsMockup) UpdatePidPageMappingInfo(pid libpf.PID, prefix lpm.Prefix,
 	fileID uint64, bias uint64) error {
+	if mockup.pidPageMappings == nil {
+		mockup.pidPageMappings = make(map[libpf.PID]int)
+	}
+	mockup.pidPageMappings[pid]++
 	if prefix.Key == 0 && fileID == 0 && bias == 0 {
 		// If all provided values are 0 the hook was called to create
 		// a dummy entry.
@@ -241,9 +249,10 @@ func (mockup *ebpfMapsMockup) setExpectedBias(expected uint64) {
 	mockup.expectedBias = expected
 }
 
-func (mockup *ebpfMapsMockup) DeletePidPageMappingInfo(_ libpf.PID, prefixes []lpm.Prefix) (int,
+func (mockup *ebpfMapsMockup) DeletePidPageMappingInfo(pid libpf.PID, prefixes []lpm.Prefix) (int,
 	error) {
 	mockup.deletePidPageMappingCount += uint8(len(prefixes))
+	mockup.pidPageMappings[pid] -= len(prefixes)
 	return len(prefixes), nil
 }
 
@@ -317,6 +326,426 @@ func TestInterpreterConvertTrace(t *testing.T) {
 	}
 }
 
+func TestConvertTraceEmulated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper := NewMapFileIDMapper()
+	manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, nil, mapper,
+		nil, nil, true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+	kernelFileID := libpf.NewFileID(1, 0)
+	mapper.Set(host.FileID(1), kernelFileID)
+	mapper.Set(host.FileID(2), libpf.NewFileID(2, 0))
+	manager.pidToProcessInfo[42] = &processInfo{emulatedMachine: elf.EM_AARCH64}
+
+	// The user mode frames of the emulator are replaced with a single frame.
+	newTrace := manager.ConvertTrace(&host.Trace{
+		PID: 42,
+		Frames: []host.Frame{
+			{File: host.FileID(1), Lineno: 0x100, Type: libpf.KernelFrame},
+			{File: host.FileID(2), Lineno: 0x200, Type: libpf.NativeFrame},
+			{File: host.FileID(2), Lineno: 0x300, Type: libpf.NativeFrame},
+		},
+	})
+	expected := []libpf.FileID{kernelFileID, emulatorFileID(elf.EM_AARCH64)}
+	if !reflect.DeepEqual(expected, newTrace.Files) {
+		t.Fatalf("Expected files %v but got %v", expected, newTrace.Files)
+	}
+	expectedTypes := []libpf.FrameType{libpf.KernelFrame, libpf.NativeFrame}
+	if !reflect.DeepEqual(expectedTypes, newTrace.FrameTypes) {
+		t.Fatalf("Expected frame types %v but got %v", expectedTypes, newTrace.FrameTypes)
+	}
+}
+
+func TestConvertTraceUnsymbolized(t *testing.T) {
+	nativeFileID := libpf.NewFileID(1, 0)
+	pythonLineno := libpf.AddressOrLineno(0x13e1bb8e)
+	placeholderFileID := libpf.NewFileID(placeholderFileIDHi, uint64(libpf.Python))
+
+	tests := map[string]struct {
+		mode    string
+		files   []libpf.FileID
+		linenos []libpf.AddressOrLineno
+		// placeholder is the reported name of the placeholder frame, if any.
+		placeholder string
+	}{
+		"default": {
+			files:   []libpf.FileID{nativeFileID, libpf.UnsymbolizedFileID},
+			linenos: []libpf.AddressOrLineno{0x1000, 0},
+		},
+		"no-address": {
+			mode:    "no-address",
+			files:   []libpf.FileID{nativeFileID, libpf.UnsymbolizedFileID},
+			linenos: []libpf.AddressOrLineno{0x1000, 0},
+		},
+		"raw-address": {
+			mode:    "raw-address",
+			files:   []libpf.FileID{nativeFileID, libpf.UnsymbolizedFileID},
+			linenos: []libpf.AddressOrLineno{0x1000, pythonLineno},
+		},
+		"drop": {
+			mode:    "drop",
+			files:   []libpf.FileID{nativeFileID},
+			linenos: []libpf.AddressOrLineno{0x1000},
+		},
+		"placeholder": {
+			mode:        "placeholder",
+			files:       []libpf.FileID{nativeFileID, placeholderFileID},
+			linenos:     []libpf.AddressOrLineno{0x1000, 0},
+			placeholder: "[python unknown]",
+		},
+	}
+
+	t.Cleanup(func() {
+		_ = config.SetConfiguration(&config.Config{ProjectID: 42,
+			CacheDirectory: t.TempDir(), SecretToken: "secret"})
+	})
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if err := config.SetConfiguration(&config.Config{ProjectID: 42,
+				CacheDirectory: t.TempDir(), SecretToken: "secret",
+				UnsymbolizedFrames: test.mode}); err != nil {
+				t.Fatalf("Failed to set configuration: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mapper := NewMapFileIDMapper()
+			mapper.Set(host.FileID(1), nativeFileID)
+			recorder := &frameMetadataRecorder{}
+			manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, nil,
+				mapper, recorder, nil, true)
+			if err != nil {
+				t.Fatalf("Failed to initialize new process manager: %v", err)
+			}
+
+			// The process has no interpreter attached, so the Python frames can not be
+			// symbolized.
+			trace := &host.Trace{
+				Frames: []host.Frame{
+					{File: host.FileID(1), Lineno: 0x1000, Type: libpf.NativeFrame},
+					{File: host.FileID(42), Lineno: pythonLineno, Type: libpf.PythonFrame},
+				},
+			}
+			for i := 0; i < 2; i++ {
+				newTrace := manager.ConvertTrace(trace)
+				if !reflect.DeepEqual(test.files, newTrace.Files) {
+					t.Fatalf("Expected files %v but got %v", test.files, newTrace.Files)
+				}
+				if !reflect.DeepEqual(test.linenos, newTrace.Linenos) {
+					t.Fatalf("Expected linenos %v but got %v", test.linenos,
+						newTrace.Linenos)
+				}
+			}
+
+			// The placeholder frame is reported once, and again once the reporter may have
+			// evicted it.
+			var expected []string
+			if test.placeholder != "" {
+				expected = []string{test.placeholder}
+			}
+			assertReported := func() {
+				var names []string
+				for _, frame := range recorder.reported() {
+					names = append(names, frame.functionName)
+				}
+				if !reflect.DeepEqual(expected, names) {
+					t.Fatalf("Expected reported frames %v but got %v", expected, names)
+				}
+			}
+			assertReported()
+			expireReportedSyntheticFrames(manager)
+			manager.ConvertTrace(trace)
+			if test.placeholder != "" {
+				expected = append(expected, test.placeholder)
+			}
+			assertReported()
+		})
+	}
+}
+
+// countingInstance is an interpreter instance that counts the frames it symbolizes.
+type countingInstance struct {
+	interpreter.InstanceStubs
+	symbolized int
+}
+
+func (i *countingInstance) Detach(interpreter.EbpfHandler, libpf.PID) error {
+	return nil
+}
+
+func (i *countingInstance) Symbolize(_ reporter.SymbolReporter, frame *host.Frame,
+	trace *libpf.Trace) error {
+	i.symbolized++
+	trace.AppendFrame(frame.Type, libpf.NewFileID(2, 0), 1)
+	return nil
+}
+
+func TestConvertTraceFramesOnly(t *testing.T) {
+	t.Cleanup(func() {
+		_ = config.SetConfiguration(&config.Config{ProjectID: 42,
+			CacheDirectory: t.TempDir(), SecretToken: "secret"})
+	})
+	if err := config.SetConfiguration(&config.Config{ProjectID: 42,
+		CacheDirectory: t.TempDir(), SecretToken: "secret", FramesOnly: true}); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper := NewMapFileIDMapper()
+	mapper.Set(host.FileID(1), libpf.NewFileID(1, 0))
+	manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, nil,
+		mapper, &frameMetadataRecorder{}, nil, true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+	instance := &countingInstance{}
+	manager.AttachInterpreterInstance(1, libpf.OnDiskFileIdentifier{}, instance)
+
+	// The Python frame unwound by the eBPF unwinder is reported with its raw address,
+	// without being symbolized on the host.
+	pythonLineno := libpf.AddressOrLineno(0x13e1bb8e)
+	converted := manager.ConvertTrace(&host.Trace{PID: 1, Frames: []host.Frame{
+		{File: 2, Lineno: pythonLineno, Type: libpf.PythonFrame},
+		{File: 1, Lineno: 0x1001, Type: libpf.NativeFrame},
+	}})
+	expectedTypes := []libpf.FrameType{libpf.PythonFrame, libpf.NativeFrame}
+	if !reflect.DeepEqual(expectedTypes, converted.FrameTypes) {
+		t.Fatalf("Expected frame types %v but got %v", expectedTypes, converted.FrameTypes)
+	}
+	expectedFiles := []libpf.FileID{libpf.UnsymbolizedFileID, libpf.NewFileID(1, 0)}
+	if !reflect.DeepEqual(expectedFiles, converted.Files) {
+		t.Fatalf("Expected files %v but got %v", expectedFiles, converted.Files)
+	}
+	expectedLinenos := []libpf.AddressOrLineno{pythonLineno, 0x1000}
+	if !reflect.DeepEqual(expectedLinenos, converted.Linenos) {
+		t.Fatalf("Expected linenos %v but got %v", expectedLinenos, converted.Linenos)
+	}
+	if instance.symbolized != 0 {
+		t.Fatalf("Expected no symbolized frames but got %d", instance.symbolized)
+	}
+}
+
+func TestConvertTraceMaxStackDepth(t *testing.T) {
+	t.Cleanup(func() {
+		_ = config.SetConfiguration(&config.Config{ProjectID: 42,
+			CacheDirectory: t.TempDir(), SecretToken: "secret"})
+	})
+	if err := config.SetConfiguration(&config.Config{ProjectID: 42,
+		CacheDirectory: t.TempDir(), SecretToken: "secret", MaxStackDepth: 2}); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper := NewMapFileIDMapper()
+	for i := 1; i <= 4; i++ {
+		mapper.Set(host.FileID(i), libpf.NewFileID(uint64(i), 0))
+	}
+	recorder := &frameMetadataRecorder{}
+	manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, nil,
+		mapper, recorder, nil, true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+
+	nativeTrace := func(files ...host.FileID) *host.Trace {
+		trace := &host.Trace{}
+		for _, file := range files {
+			trace.Frames = append(trace.Frames, host.Frame{File: file, Lineno: 0x1001,
+				Type: libpf.NativeFrame})
+		}
+		return trace
+	}
+
+	// Stacks that only differ beyond the maximum depth are aggregated.
+	deep := manager.ConvertTrace(nativeTrace(1, 2, 3))
+	deeper := manager.ConvertTrace(nativeTrace(1, 2, 4, 3))
+	expected := []libpf.FileID{libpf.NewFileID(1, 0), libpf.NewFileID(2, 0), deeperFileID}
+	if !reflect.DeepEqual(expected, deep.Files) {
+		t.Fatalf("Expected files %v but got %v", expected, deep.Files)
+	}
+	if !reflect.DeepEqual(expected, deeper.Files) {
+		t.Fatalf("Expected files %v but got %v", expected, deeper.Files)
+	}
+	if deep.Hash != deeper.Hash {
+		t.Fatalf("Expected hash %v but got %v", deep.Hash, deeper.Hash)
+	}
+
+	// Stacks within the maximum depth are kept as they are.
+	shallow := manager.ConvertTrace(nativeTrace(1, 2))
+	expected = []libpf.FileID{libpf.NewFileID(1, 0), libpf.NewFileID(2, 0)}
+	if !reflect.DeepEqual(expected, shallow.Files) {
+		t.Fatalf("Expected files %v but got %v", expected, shallow.Files)
+	}
+
+	// The deeper frame is reported once, and again once the reporter may have evicted it.
+	var names []string
+	for _, frame := range recorder.reported() {
+		names = append(names, frame.functionName)
+	}
+	if !reflect.DeepEqual([]string{"[deeper]"}, names) {
+		t.Fatalf("Expected reported frames [[deeper]] but got %v", names)
+	}
+	expireReportedSyntheticFrames(manager)
+	manager.ConvertTrace(nativeTrace(1, 2, 3))
+	names = names[:0]
+	for _, frame := range recorder.reported() {
+		names = append(names, frame.functionName)
+	}
+	if !reflect.DeepEqual([]string{"[deeper]", "[deeper]"}, names) {
+		t.Fatalf("Expected reported frames [[deeper] [deeper]] but got %v", names)
+	}
+}
+
+// expireReportedSyntheticFrames makes the process manager report the synthetic frames it
+// reported already again.
+func expireReportedSyntheticFrames(pm *ProcessManager) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	for fileID := range pm.reportedSyntheticFrames {
+		pm.reportedSyntheticFrames[fileID] = time.Now().Add(-syntheticFrameReportInterval)
+	}
+}
+
+func TestConvertTraceSymbolizationTimeout(t *testing.T) {
+	t.Cleanup(func() {
+		_ = config.SetConfiguration(&config.Config{ProjectID: 42,
+			CacheDirectory: t.TempDir(), SecretToken: "secret"})
+	})
+	// The deadline has passed by the time the first frame is converted.
+	if err := config.SetConfiguration(&config.Config{ProjectID: 42,
+		CacheDirectory: t.TempDir(), SecretToken: "secret",
+		SymbolizationTimeout: time.Nanosecond}); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper := NewMapFileIDMapper()
+	mapper.Set(host.FileID(1), libpf.NewFileID(1, 0))
+	recorder := &frameMetadataRecorder{}
+	manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, nil,
+		mapper, recorder, nil, true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+
+	trace := &host.Trace{Frames: []host.Frame{
+		{File: 1, Lineno: 0x1001, Type: libpf.NativeFrame},
+		{File: 1, Lineno: 0x2001, Type: libpf.NativeFrame},
+	}}
+	for i := 0; i < 2; i++ {
+		converted := manager.ConvertTrace(trace)
+		expected := []libpf.FileID{symbolizationTimeoutFileID}
+		if !reflect.DeepEqual(expected, converted.Files) {
+			t.Fatalf("Expected files %v but got %v", expected, converted.Files)
+		}
+	}
+	if timeouts := manager.symbolizationTimeouts.Load(); timeouts != 2 {
+		t.Fatalf("Expected 2 timeouts but got %d", timeouts)
+	}
+
+	// The timeout frame is reported once, and again once the reporter may have evicted it.
+	var names []string
+	for _, frame := range recorder.reported() {
+		names = append(names, frame.functionName)
+	}
+	if !reflect.DeepEqual([]string{"[symbolization-timeout]"}, names) {
+		t.Fatalf("Expected reported frames [[symbolization-timeout]] but got %v", names)
+	}
+	expireReportedSyntheticFrames(manager)
+	manager.ConvertTrace(trace)
+	if reported := len(recorder.reported()); reported != 2 {
+		t.Fatalf("Expected 2 reported frames but got %d", reported)
+	}
+}
+
+// slowMemory is process memory whose reads take a millisecond each.
+type slowMemory struct{}
+
+func (slowMemory) ReadAt(p []byte, _ int64) (int, error) {
+	time.Sleep(time.Millisecond)
+	return len(p), nil
+}
+
+// stallingInstance is an interpreter instance whose symbolization walks process memory
+// until a read fails, like the walk of a cyclic list would.
+type stallingInstance struct {
+	interpreter.InstanceStubs
+	rm remotememory.RemoteMemory
+}
+
+func (i *stallingInstance) Detach(interpreter.EbpfHandler, libpf.PID) error {
+	return nil
+}
+
+func (i *stallingInstance) Symbolize(_ reporter.SymbolReporter, _ *host.Frame,
+	_ *libpf.Trace) error {
+	for addr := libpf.Address(0); ; addr += 8 {
+		if err := i.rm.Read(addr, make([]byte, 8)); err != nil {
+			return err
+		}
+	}
+}
+
+func TestConvertTraceStallingInstance(t *testing.T) {
+	t.Cleanup(func() {
+		_ = config.SetConfiguration(&config.Config{ProjectID: 42,
+			CacheDirectory: t.TempDir(), SecretToken: "secret"})
+	})
+	if err := config.SetConfiguration(&config.Config{ProjectID: 42,
+		CacheDirectory: t.TempDir(), SecretToken: "secret",
+		SymbolizationTimeout: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("Failed to set configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper := NewMapFileIDMapper()
+	mapper.Set(host.FileID(1), libpf.NewFileID(1, 0))
+	manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, nil,
+		mapper, &frameMetadataRecorder{}, nil, true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+	manager.AttachInterpreterInstance(1, libpf.OnDiskFileIdentifier{}, &stallingInstance{
+		rm: manager.boundedRemoteMemory(remotememory.RemoteMemory{ReaderAt: slowMemory{}}),
+	})
+
+	trace := &host.Trace{PID: 1, Frames: []host.Frame{
+		{File: 1, Lineno: 0x1001, Type: libpf.NativeFrame},
+		{File: 2, Lineno: 0x2001, Type: libpf.PythonFrame},
+		{File: 1, Lineno: 0x3001, Type: libpf.NativeFrame},
+	}}
+	start := time.Now()
+	converted := manager.ConvertTrace(trace)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the conversion to end by the timeout but it took %v", elapsed)
+	}
+	expected := []libpf.FileID{libpf.NewFileID(1, 0), symbolizationTimeoutFileID}
+	if !reflect.DeepEqual(expected, converted.Files) {
+		t.Fatalf("Expected files %v but got %v", expected, converted.Files)
+	}
+	if timeouts := manager.symbolizationTimeouts.Load(); timeouts != 1 {
+		t.Fatalf("Expected 1 timeout but got %d", timeouts)
+	}
+	// The memory of the processes can be read again once the symbolization has ended.
+	if manager.symbolizationDeadline.passed() {
+		t.Fatalf("Expected the symbolization deadline to be cleared")
+	}
+}
+
 // getExpectedTrace returns a new libpf trace that is based on the provided host trace, but
 // with the linenos replaced by the provided values. This function is for generating an expected
 // trace for tests below.
@@ -634,3 +1063,207 @@ func TestProcExit(t *testing.T) {
 		})
 	}
 }
+
+func TestEvictProcesses(t *testing.T) {
+	ebpfMockup := &ebpfMapsMockup{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager, err := New(ctx,
+		make([]bool, config.MaxTracers),
+		1*time.Second,
+		ebpfMockup,
+		NewMapFileIDMapper(),
+		nil,
+		&dummyStackDeltaProvider{},
+		true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+	manager.metricsAddSlice = func([]metrics.Metric) {}
+
+	populateManager(t, manager)
+	manager.maxProcesses = 3
+
+	now := libpf.GetKTime()
+	manager.markSampled(1, now+1)
+	manager.markSampled(921, now+2)
+
+	// PID 3 was just discovered and is kept even though it was never sampled.
+	manager.evictProcesses(3)
+
+	tracked := make(libpf.Set[libpf.PID])
+	for pid := range manager.pidToProcessInfo {
+		tracked[pid] = libpf.Void{}
+	}
+	expected := libpf.Set[libpf.PID]{1: libpf.Void{}, 3: libpf.Void{}, 921: libpf.Void{}}
+	if !reflect.DeepEqual(expected, tracked) {
+		t.Fatalf("Expected tracked processes %v but got %v", expected, tracked)
+	}
+	if evicted := manager.evictedProcesses.Load(); evicted != 2 {
+		t.Fatalf("Expected 2 evicted processes but got %d", evicted)
+	}
+
+	// Staying within the limit does not evict processes.
+	manager.evictProcesses(3)
+	if n := manager.numTrackedProcesses(); n != 3 {
+		t.Fatalf("Expected 3 tracked processes but got %d", n)
+	}
+}
+
+// sharingProcess is a process that shares the address space of its parent while shared is
+// set.
+type sharingProcess struct {
+	dummyProcess
+	ppid   libpf.PID
+	shared bool
+}
+
+func (s *sharingProcess) ParentPID() (libpf.PID, error) {
+	return s.ppid, nil
+}
+
+func (s *sharingProcess) SharesAddressSpace(pid libpf.PID) (bool, error) {
+	return s.shared && pid == s.ppid, nil
+}
+
+func TestSharedAddressSpace(t *testing.T) {
+	ebpfMockup := &ebpfMapsMockup{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager, err := New(ctx,
+		make([]bool, config.MaxTracers),
+		1*time.Second,
+		ebpfMockup,
+		NewMapFileIDMapper(),
+		nil,
+		&dummyStackDeltaProvider{},
+		true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+	manager.metricsAddSlice = func([]metrics.Metric) {}
+
+	populateManager(t, manager)
+	// The dummy entry and the 7 prefixes of the single mapping of PID 1.
+	const parentEntries = 8
+	if n := ebpfMockup.pidPageMappings[1]; n != parentEntries {
+		t.Fatalf("Expected %d entries of the parent but got %d", parentEntries, n)
+	}
+	manager.pidToProcessInfo[1].executable = "parent"
+	ebpfMockup.setExpectedBias(0x1000)
+
+	child := &sharingProcess{dummyProcess: dummyProcess{pid: 1000}, ppid: 1, shared: true}
+	synchronize := func() {
+		t.Helper()
+		manager.SynchronizeProcess(child)
+		if n := ebpfMockup.pidPageMappings[child.pid]; n != parentEntries {
+			t.Fatalf("Expected %d mirrored entries but got %d", parentEntries, n)
+		}
+		if manager.numTrackedProcesses() != 5 {
+			t.Fatalf("Expected the child not to be tracked")
+		}
+		if name := manager.ExecutableName(child.pid); name != "parent" {
+			t.Fatalf("Expected the executable of the parent but got %q", name)
+		}
+	}
+
+	// Synchronizing the child again replaces the mirrored entries.
+	synchronize()
+	synchronize()
+
+	// The exit of the child removes its entries, but not those of the parent.
+	manager.ProcessPIDExit(child.pid)
+	if n := ebpfMockup.pidPageMappings[child.pid]; n != 0 {
+		t.Fatalf("Expected no entries of the exited child but got %d", n)
+	}
+	if n := ebpfMockup.pidPageMappings[1]; n != parentEntries {
+		t.Fatalf("Expected %d entries of the parent but got %d", parentEntries, n)
+	}
+	if ebpfMockup.deleteStackDeltaPage != 0 {
+		t.Fatalf("Expected the executables of the parent to stay loaded")
+	}
+
+	// The exit of the parent removes the entries of the child.
+	synchronize()
+	manager.ProcessPIDExit(1)
+	if n := ebpfMockup.pidPageMappings[child.pid]; n != 0 {
+		t.Fatalf("Expected no entries of the child after the parent exited but got %d", n)
+	}
+	if len(manager.sharedAddressSpace) != 0 {
+		t.Fatalf("Expected no processes sharing an address space")
+	}
+
+	// Processes that do not share the address space of their parent are synchronized
+	// from their own mappings.
+	child.ppid, child.shared = 2, false
+	manager.SynchronizeProcess(child)
+	if n := ebpfMockup.pidPageMappings[child.pid]; n != 0 {
+		t.Fatalf("Expected no mirrored entries but got %d", n)
+	}
+	if len(manager.sharedAddressSpace) != 0 {
+		t.Fatalf("Expected no processes sharing an address space")
+	}
+}
+
+// compatProcess is a 32-bit process running in compat mode.
+type compatProcess struct {
+	dummyProcess
+}
+
+func (c *compatProcess) GetMappings() ([]process.Mapping, error) {
+	return []process.Mapping{{Vaddr: 0x10000, Length: 0x1000, Flags: elf.PF_R | elf.PF_X,
+		FileOffset: 0x1000, Device: 1, Inode: 1, Path: "/usr/bin/app32"}}, nil
+}
+
+func (c *compatProcess) CompatMachine() (elf.Machine, error) {
+	return elf.EM_386, nil
+}
+
+func TestCompatProcess(t *testing.T) {
+	ebpfMockup := &ebpfMapsMockup{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mapper := NewMapFileIDMapper()
+	kernelFileID := libpf.NewFileID(1, 0)
+	mapper.Set(host.FileID(1), kernelFileID)
+	recorder := &frameMetadataRecorder{}
+	manager, err := New(ctx, make([]bool, config.MaxTracers), 1*time.Second, ebpfMockup,
+		mapper, recorder, &dummyStackDeltaProvider{}, true)
+	if err != nil {
+		t.Fatalf("Failed to initialize new process manager: %v", err)
+	}
+	manager.metricsAddSlice = func([]metrics.Metric) {}
+
+	// The executables are not mapped, only the dummy entry of the process is added.
+	pr := &compatProcess{dummyProcess: dummyProcess{pid: 42}}
+	for i := 0; i < 2; i++ {
+		manager.SynchronizeProcess(pr)
+		if n := ebpfMockup.pidPageMappings[pr.pid]; n != 1 {
+			t.Fatalf("Expected only the dummy entry but got %d entries", n)
+		}
+	}
+	if name := manager.ExecutableName(pr.pid); name != "app32" {
+		t.Fatalf("Expected executable app32 but got %q", name)
+	}
+	reported := recorder.reported()
+	if len(reported) != 1 || reported[0].functionName != "[32-bit i386]" {
+		t.Fatalf("Expected the 32-bit frame to be reported once but got %v", reported)
+	}
+
+	// The user mode frames, including errors of the unwinder, are replaced with a single
+	// frame.
+	newTrace := manager.ConvertTrace(&host.Trace{
+		PID: pr.pid,
+		Frames: []host.Frame{
+			{File: host.FileID(1), Lineno: 0x100, Type: libpf.KernelFrame},
+			{Lineno: 0x1, Type: libpf.NativeFrame.Error()},
+		},
+	})
+	expected := []libpf.FileID{kernelFileID, compatFileID(elf.EM_386)}
+	if !reflect.DeepEqual(expected, newTrace.Files) {
+		t.Fatalf("Expected files %v but got %v", expected, newTrace.Files)
+	}
+}
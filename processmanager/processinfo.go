@@ -16,15 +16,16 @@ package processmanager
 
 import (
 	"context"
+	"debug/elf"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"syscall"
 	"time"
 
-	"golang.org/x/sys/unix"
-
+	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/host"
 	"github.com/elastic/otel-profiling-agent/interpreter"
 	"github.com/elastic/otel-profiling-agent/libpf"
@@ -60,8 +61,8 @@ func (pm *ProcessManager) assignTSDInfo(pid libpf.PID, tsdInfo *tpbase.TSDInfo)
 	// Update the tsdInfo to interpreters that are already attached
 	for _, instance := range pm.interpreters[pid] {
 		if err := instance.UpdateTSDInfo(pm.ebpf, pid, *tsdInfo); err != nil {
-			log.Errorf("Failed to update PID %v TSDInfo: %v",
-				pid, err)
+			log.WithFields(log.Fields{"pid": pid}).Errorf(
+				"Failed to update TSDInfo: %v", err)
 		}
 	}
 }
@@ -86,20 +87,10 @@ func (pm *ProcessManager) updatePidInformation(pid libpf.PID, m *Mapping) (bool,
 	if !ok {
 		// We don't have information for this pid, so we first need to
 		// allocate the embedded map for this process.
-		info = &processInfo{
-			mappings: make(map[libpf.Address]Mapping),
-			tsdInfo:  nil,
-		}
-		pm.pidToProcessInfo[pid] = info
-
-		// Insert a dummy page into the eBPF map pid_page_to_mapping_info that provides the eBPF
-		// a quick way to check if we know something about this particular process.
-		if err := pm.ebpf.UpdatePidPageMappingInfo(pid, dummyPrefix, 0, 0); err != nil {
-			return false, fmt.Errorf(
-				"failed to update pid_page_to_mapping_info dummy entry for PID %d: %v",
-				pid, err)
+		var err error
+		if info, err = pm.addProcessInfo(pid); err != nil {
+			return false, err
 		}
-		pm.pidPageToMappingInfoSize++
 	} else if mf, ok := info.mappings[m.Vaddr]; ok {
 		if *m == mf {
 			// We try to update our information about a particular mapping we already know about.
@@ -130,6 +121,29 @@ func (pm *ProcessManager) updatePidInformation(pid libpf.PID, m *Mapping) (bool,
 	return false, err
 }
 
+// addProcessInfo adds the information of the new process pid without any mappings.
+//
+// Caller must hold pm.mu write lock.
+func (pm *ProcessManager) addProcessInfo(pid libpf.PID) (*processInfo, error) {
+	info := &processInfo{
+		mappings: make(map[libpf.Address]Mapping),
+		tsdInfo:  nil,
+	}
+	// New processes count as sampled, so that they are not evicted right away.
+	info.lastSampled.Store(int64(libpf.GetKTime()))
+	pm.pidToProcessInfo[pid] = info
+
+	// Insert a dummy page into the eBPF map pid_page_to_mapping_info that provides the eBPF
+	// a quick way to check if we know something about this particular process.
+	if err := pm.ebpf.UpdatePidPageMappingInfo(pid, dummyPrefix, 0, 0); err != nil {
+		return info, fmt.Errorf(
+			"failed to update pid_page_to_mapping_info dummy entry for PID %d: %v",
+			pid, err)
+	}
+	pm.pidPageToMappingInfoSize++
+	return info, nil
+}
+
 // deletePIDAddress removes the mapping at addr from pid from the internal structure of the
 // process manager instance as well as from the eBPF maps.
 // Caller must hold pm.mu write lock.
@@ -152,7 +166,7 @@ func (pm *ProcessManager) deletePIDAddress(pid libpf.PID, addr libpf.Address) er
 
 	deleted, err := pm.ebpf.DeletePidPageMappingInfo(pid, prefixes)
 	if err != nil {
-		log.Errorf("Failed to delete mappings for PID %d: %v", pid, err)
+		log.WithFields(log.Fields{"pid": pid}).Errorf("Failed to delete mappings: %v", err)
 	}
 
 	pm.pidPageToMappingInfoSize -= uint64(deleted)
@@ -194,19 +208,35 @@ func (pm *ProcessManager) handleNewInterpreter(pr process.Process, m *Mapping,
 		}
 	}
 	// Slow path: Interpreter detection or attachment needed
-	instance, err := ei.Data.Attach(pm.ebpf, pid, libpf.Address(m.Bias), pr.GetRemoteMemory())
+	instance, err := ei.Data.Attach(pm.ebpf, pid, libpf.Address(m.Bias),
+		pm.boundedRemoteMemory(pr.GetRemoteMemory()))
 	if err != nil {
 		return fmt.Errorf("failed to attach to %v in PID %v: %w",
 			ei.Data, pid, err)
 	}
 
-	log.Debugf("Attached to %v interpreter in PID %v", ei.Data, pid)
+	log.WithFields(log.Fields{
+		"pid":         pid,
+		"fileID":      fmt.Sprintf("%#016x", m.FileID),
+		"interpreter": fmt.Sprint(ei.Data),
+	}).Debugf("Attached to interpreter")
 	pm.assignInterpreter(pid, key, instance)
 
+	if labeler, ok := ei.Data.(interpreter.VersionLabeler); ok {
+		if name, value := labeler.VersionLabel(); name != "" {
+			if info, ok := pm.pidToProcessInfo[pid]; ok {
+				info.labels = mergeLabels(info.labels, map[string]string{name: value})
+			}
+		}
+	}
+
 	if tsdInfo := pm.getTSDInfo(pid); tsdInfo != nil {
 		err = instance.UpdateTSDInfo(pm.ebpf, pid, *tsdInfo)
 		if err != nil {
-			log.Errorf("Failed to update PID %v TSDInfo: %v", pid, err)
+			log.WithFields(log.Fields{
+				"pid":         pid,
+				"interpreter": fmt.Sprint(ei.Data),
+			}).Errorf("Failed to update TSDInfo: %v", err)
 		}
 	}
 
@@ -247,30 +277,44 @@ func (pm *ProcessManager) handleNewMapping(pr process.Process, m *Mapping,
 
 func (pm *ProcessManager) getELFInfo(pr process.Process, mapping *process.Mapping,
 	elfRef *pfelf.Reference) elfInfo {
-	var lastModified int64
+	var identity pfelf.FileIdentity
 
 	mappingFile := pr.GetMappingFile(mapping)
 	if mappingFile != "" {
-		var st unix.Stat_t
-		if err := unix.Stat(mappingFile, &st); err != nil {
+		var err error
+		identity, err = pfelf.StatIdentity(mappingFile)
+		switch {
+		case err == nil:
+			// Make sure that the ELF opened for this mapping is the mapped file, and not
+			// a replacement that has been installed at the same path.
+			elfRef.SetExpectedIdentity(identity)
+		case errors.Is(err, os.ErrPermission):
+			// Accessing map_files requires CAP_SYS_ADMIN. The process will open the
+			// file via its root directory, or from memory if it has been deleted.
+		default:
 			return elfInfo{err: err}
 		}
-		lastModified = st.Mtim.Nano()
 	}
 
 	key := mapping.GetOnDiskFileIdentifier()
 
-	if info, ok := pm.elfInfoCache.Get(key); ok && info.lastModified == lastModified {
-		// Cached data ok
-		pm.elfInfoCacheHit.Add(1)
-		return info
+	transientFailures := 0
+	if info, ok := pm.elfInfoCache.Get(key); ok && info.identity == identity {
+		if info.transientFailures == 0 ||
+			info.transientFailures >= pfelf.MaxTransientFailures {
+			// Cached data ok
+			pm.elfInfoCacheHit.Add(1)
+			return info
+		}
+		// The file failed to open due to a transient error, try again.
+		transientFailures = info.transientFailures
 	}
 
 	// Slow path, calculate all the data and update cache
 	pm.elfInfoCacheMiss.Add(1)
 
 	info := elfInfo{
-		lastModified: lastModified,
+		identity: identity,
 	}
 
 	var fileID libpf.FileID
@@ -280,9 +324,15 @@ func (pm *ProcessManager) getELFInfo(pr process.Process, mapping *process.Mappin
 	}
 	if err != nil {
 		info.err = err
+		if pfelf.IsTransientError(err) {
+			// Only a bounded number of retries for transient errors, like I/O errors,
+			// after which the error is cached like the permanent ones.
+			info.transientFailures = transientFailures + 1
+		}
 		// It is possible that the process has exited, and the mapping
-		// file cannot be opened. Do not cache these errors.
-		if !errors.Is(err, os.ErrNotExist) {
+		// file cannot be opened, or that the file got replaced while it
+		// was opened. Do not cache these errors.
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, pfelf.ErrFileChanged) {
 			// Cache the other errors: not an ELF, ELF corrupt, etc.
 			// to reduce opening it again and again.
 			pm.elfInfoCache.Add(key, info)
@@ -302,6 +352,9 @@ func (pm *ProcessManager) getELFInfo(pr process.Process, mapping *process.Mappin
 		pm.elfInfoCache.Add(key, info)
 	}
 	pm.FileIDMapper.Set(hostFileID, fileID)
+	if ef.IsGolang() && !config.FramesOnly() {
+		pm.addGoSymbolTable(hostFileID, ef)
+	}
 
 	baseName := path.Base(mapping.Path)
 	if baseName == "/" {
@@ -313,7 +366,8 @@ func (pm *ProcessManager) getELFInfo(pr process.Process, mapping *process.Mappin
 	}
 
 	buildID, _ := ef.GetBuildID()
-	pm.reporter.ExecutableMetadata(context.TODO(), fileID, baseName, buildID)
+	pm.reporter.ExecutableMetadata(context.TODO(), fileID, baseName, buildID,
+		mapping.Device, mapping.Inode, info.addressMapper)
 
 	return info
 }
@@ -335,17 +389,21 @@ func (pm *ProcessManager) processNewExecMapping(pr process.Process, mapping *pro
 		// process has exited already and the mapping file is unavailable
 		// or it is not an ELF file. Ignore these errors silently.
 		if !errors.Is(info.err, os.ErrNotExist) && !errors.Is(info.err, pfelf.ErrNotELF) {
-			log.Debugf("Failed to get ELF info for PID %d file %v: %v",
-				pr.PID(), mapping.Path, info.err)
+			log.WithFields(log.Fields{"pid": pr.PID(), "file": mapping.Path}).Debugf(
+				"Failed to get ELF info: %v", info.err)
 		}
 		return
 	}
 
-	// Get the virtual addresses for this mapping
-	elfSpaceVA, ok := info.addressMapper.FileOffsetToVirtualAddress(mapping.FileOffset)
+	// Get the load bias of the ELF file from this mapping. All loaders, and the address
+	// translation of the interpreters, rely on it to find the ELF virtual addresses at run time.
+	bias, ok := info.addressMapper.LoadBias(mapping.Vaddr, mapping.FileOffset)
 	if !ok {
-		log.Debugf("Failed to map file offset of PID %d, file %s, offset %d",
-			pr.PID(), mapping.Path, mapping.FileOffset)
+		log.WithFields(log.Fields{
+			"pid":    pr.PID(),
+			"fileID": fmt.Sprintf("%#016x", info.fileID),
+			"file":   mapping.Path,
+		}).Debugf("Failed to map file offset %d", mapping.FileOffset)
 		return
 	}
 
@@ -353,14 +411,23 @@ func (pm *ProcessManager) processNewExecMapping(pr process.Process, mapping *pro
 		&Mapping{
 			FileID:     info.fileID,
 			Vaddr:      libpf.Address(mapping.Vaddr),
-			Bias:       mapping.Vaddr - elfSpaceVA,
+			Bias:       bias,
 			Length:     mapping.Length,
 			Device:     mapping.Device,
 			Inode:      mapping.Inode,
 			FileOffset: mapping.FileOffset,
 		}, elfRef); err != nil {
-		log.Errorf("Failed to handle mapping for PID %d, file %s: %v",
-			pr.PID(), mapping.Path, err)
+		logger := log.WithFields(log.Fields{
+			"pid":    pr.PID(),
+			"fileID": fmt.Sprintf("%#016x", info.fileID),
+			"file":   mapping.Path,
+		})
+		if errors.Is(err, pfelf.ErrSizeLimitExceeded) {
+			// Frames in this mapping will be reported without unwinding information.
+			logger.Warnf("Skipping too large mapping: %v", err)
+			return
+		}
+		logger.Errorf("Failed to handle mapping: %v", err)
 	}
 }
 
@@ -373,8 +440,8 @@ func (pm *ProcessManager) processRemovedMappings(pid libpf.PID, mappings []libpf
 
 	for _, addr := range mappings {
 		if err := pm.deletePIDAddress(pid, addr); err != nil {
-			log.Debugf("Failed to handle native unmapping of 0x%x in PID %d: %v",
-				addr, pid, err)
+			log.WithFields(log.Fields{"pid": pid}).Debugf(
+				"Failed to handle native unmapping of 0x%x: %v", addr, err)
 		}
 	}
 
@@ -392,8 +459,8 @@ func (pm *ProcessManager) processRemovedMappings(pid libpf.PID, mappings []libpf
 			continue
 		}
 		if err := instance.Detach(pm.ebpf, pid); err != nil {
-			log.Errorf("Failed to unload interpreter for PID %d: %v",
-				pid, err)
+			log.WithFields(log.Fields{"pid": pid}).Errorf(
+				"Failed to unload interpreter: %v", err)
 		}
 		delete(pm.interpreters[pid], key)
 	}
@@ -424,19 +491,27 @@ func (pm *ProcessManager) synchronizeMappings(pr process.Process,
 	mpRemove := make([]libpf.Address, 0)
 
 	interpretersValid := make(libpf.Set[libpf.OnDiskFileIdentifier])
+	// The main executable is mapped by the kernel before the dynamic loader and the
+	// libraries, so it is the file backed mapping with the lowest address.
+	var executable *process.Mapping
 	for idx := range mappings {
 		m := &mappings[idx]
 		if !m.IsExecutable() || m.IsAnonymous() {
 			continue
 		}
+		if !m.IsVDSO() && (executable == nil || m.Vaddr < executable.Vaddr) {
+			executable = m
+		}
 		mpAdd[libpf.Address(m.Vaddr)] = m
 		key := m.GetOnDiskFileIdentifier()
 		interpretersValid[key] = libpf.Void{}
 	}
 
 	// Generate the list of added and removed mappings.
+	compat := elf.EM_NONE
 	pm.mu.RLock()
 	if info, ok := pm.pidToProcessInfo[pid]; ok {
+		compat = info.compatMachine
 		// Iterate over cached executable mappings, if any, and collect mappings
 		// that have changed so that they are later batch-removed.
 		for addr, existingMapping := range info.mappings {
@@ -458,6 +533,22 @@ func (pm *ProcessManager) synchronizeMappings(pr process.Process,
 	}
 	pm.mu.RUnlock()
 
+	// The executables of 32-bit processes can not be parsed and their stacks not be
+	// unwound, as the pointer size and registers differ from those of the host. Their user
+	// mode stacks are reported as a single frame instead of mapping their executables.
+	if cd, ok := pr.(compatDetector); ok && newProcess {
+		if machine, err := cd.CompatMachine(); err == nil && machine != elf.EM_NONE {
+			compat = machine
+			log.WithFields(log.Fields{"pid": pid}).Debugf(
+				"Process runs %v executables in 32-bit compat mode", machine)
+			pm.reporter.FrameMetadata(compatFileID(machine), 0, 0, 0,
+				fmt.Sprintf("[32-bit %s]", process.EmulatedArch(machine)), "")
+		}
+	}
+	if compat != elf.EM_NONE {
+		clear(mpAdd)
+	}
+
 	// First, remove mappings that have changed
 	pm.processRemovedMappings(pid, mpRemove, interpretersValid)
 
@@ -468,6 +559,48 @@ func (pm *ProcessManager) synchronizeMappings(pr process.Process,
 		pm.processNewExecMapping(pr, mapping)
 	}
 
+	// Labels are read once, when the process is discovered.
+	var labels map[string]string
+	if prefix := config.ProcessLabelEnvPrefix(); newProcess && prefix != "" {
+		labels = readProcessLabels(pid, prefix)
+	}
+	if maxLength := config.CommandLineLabelLen(); newProcess && maxLength != 0 {
+		labels = mergeLabels(labels, readCommandLineLabel(pid, config.CommandLineRedact(),
+			int(maxLength)))
+	}
+	// The traces of processes running under an emulator show the emulator, whose
+	// stacks are reported as a single frame.
+	emulated := elf.EM_NONE
+	if ed, ok := pr.(emulationDetector); ok && newProcess {
+		if machine, err := ed.EmulatedMachine(); err == nil && machine != elf.EM_NONE {
+			emulated = machine
+			log.WithFields(log.Fields{"pid": pid}).Debugf(
+				"Process runs %v executables under an emulator", machine)
+			pm.reporter.FrameMetadata(emulatorFileID(machine), 0, 0, 0,
+				fmt.Sprintf("[qemu-%s]", process.EmulatedArch(machine)), "")
+		}
+	}
+	pm.mu.Lock()
+	if _, ok := pm.pidToProcessInfo[pid]; !ok && compat != elf.EM_NONE {
+		// Without mappings, the process is added here so that its traces are reported.
+		if _, err := pm.addProcessInfo(pid); err != nil {
+			log.WithFields(log.Fields{"pid": pid}).Errorf(
+				"Failed to add 32-bit process: %v", err)
+		}
+	}
+	if info, ok := pm.pidToProcessInfo[pid]; ok {
+		if executable != nil {
+			info.executable = path.Base(executable.Path)
+		}
+		if newProcess {
+			// Keep the version labels of the interpreters attached in the meantime.
+			info.labels = mergeLabels(labels, info.labels)
+			info.emulatedMachine = emulated
+			info.compatMachine = compat
+		}
+	}
+	pm.mu.Unlock()
+
 	// Update interpreter plugins about the changed mappings
 	if pm.interpreterTracerEnabled {
 		pm.mu.Lock()
@@ -475,10 +608,11 @@ func (pm *ProcessManager) synchronizeMappings(pr process.Process,
 			err := instance.SynchronizeMappings(pm.ebpf, pm.reporter, pr, mappings)
 			if err != nil {
 				if alive, _ := proc.IsPIDLive(pid); alive {
-					log.Errorf("Failed to handle new anonymous mapping for PID %d: %v", pid, err)
+					log.WithFields(log.Fields{"pid": pid}).Errorf(
+						"Failed to handle new anonymous mapping: %v", err)
 				} else {
-					log.Debugf("Failed to handle new anonymous mapping for PID %d: process exited",
-						pid)
+					log.WithFields(log.Fields{"pid": pid}).Debugf(
+						"Failed to handle new anonymous mapping: process exited")
 				}
 			}
 		}
@@ -514,35 +648,320 @@ func (pm *ProcessManager) ProcessPIDExit(pid libpf.PID) bool {
 		}
 	}
 
+	pm.removeSharedAddressSpace(pid)
+
+	if !pm.removeProcessInfo(pid) {
+		log.Debugf("Skip process exit handling for unknown PID %d", pid)
+	}
+	return symbolize
+}
+
+// removeProcessInfo removes the mappings of pid from the internal structures and the eBPF
+// maps, releasing the references to the mapped executables. It returns false if the process
+// is not known.
+// Caller must hold pm.mu write lock.
+func (pm *ProcessManager) removeProcessInfo(pid libpf.PID) bool {
 	info, ok := pm.pidToProcessInfo[pid]
 	if !ok {
-		log.Debugf("Skip process exit handling for unknown PID %d", pid)
-		return symbolize
+		return false
+	}
+
+	// The processes sharing the address space of pid lose the mirrored mappings, as the
+	// mapped executables are no longer referenced.
+	for child, shared := range pm.sharedAddressSpace {
+		if shared.ppid == pid {
+			pm.removeSharedAddressSpace(child)
+		}
 	}
 
 	// Delete all entries we have for this particular PID from pid_page_to_mapping_info.
 	deleted, err := pm.ebpf.DeletePidPageMappingInfo(pid, []lpm.Prefix{dummyPrefix})
 	if err != nil {
-		log.Errorf("Failed to delete dummy prefix for PID %d: %v",
-			pid, err)
+		log.WithFields(log.Fields{"pid": pid}).Errorf(
+			"Failed to delete dummy prefix: %v", err)
 	}
 	pm.pidPageToMappingInfoSize -= uint64(deleted)
 
 	for address := range info.mappings {
 		if err := pm.deletePIDAddress(pid, address); err != nil {
-			log.Errorf("Failed to delete address 0x%x for PID %d: %v",
-				address, pid, err)
+			log.WithFields(log.Fields{"pid": pid}).Errorf(
+				"Failed to delete address 0x%x: %v", address, err)
 		}
 	}
 	delete(pm.pidToProcessInfo, pid)
+	return true
+}
 
-	return symbolize
+// markSampled records that a trace of pid was captured at ktime, which protects the process
+// from eviction.
+func (pm *ProcessManager) markSampled(pid libpf.PID, ktime libpf.KTime) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if shared, ok := pm.sharedAddressSpace[pid]; ok {
+		pid = shared.ppid
+	}
+	if info, ok := pm.pidToProcessInfo[pid]; ok && int64(ktime) > info.lastSampled.Load() {
+		info.lastSampled.Store(int64(ktime))
+	}
+}
+
+// numTrackedProcesses returns the number of processes whose mappings are tracked.
+// Caller must hold pm.mu read lock.
+func (pm *ProcessManager) numTrackedProcesses() int {
+	return len(pm.pidToProcessInfo)
+}
+
+// evictProcesses evicts the least recently sampled processes, except keep, until no more
+// than maxProcesses processes are tracked. The state of evicted processes is released as if
+// they exited. If they are sampled again, the eBPF code reports them as new processes and
+// they are synchronized from scratch.
+func (pm *ProcessManager) evictProcesses(keep libpf.PID) {
+	if pm.maxProcesses <= 0 {
+		return
+	}
+
+	pm.mu.RLock()
+	excess := len(pm.pidToProcessInfo) - pm.maxProcesses
+	var candidates []libpf.PID
+	if excess > 0 {
+		candidates = make([]libpf.PID, 0, len(pm.pidToProcessInfo))
+		for pid := range pm.pidToProcessInfo {
+			if pid != keep {
+				candidates = append(candidates, pid)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return pm.pidToProcessInfo[candidates[i]].lastSampled.Load() <
+				pm.pidToProcessInfo[candidates[j]].lastSampled.Load()
+		})
+	}
+	pm.mu.RUnlock()
+
+	if excess > len(candidates) {
+		excess = len(candidates)
+	}
+	for _, pid := range candidates[:excess] {
+		pm.evictProcess(pid)
+	}
+	if excess > 0 {
+		log.Debugf("Evicted %d least recently sampled processes", excess)
+	}
+}
+
+// evictProcess releases the state of the process pid. Unlike for process exits, the
+// interpreter state is released immediately, as the process is still running and the state
+// must be set up again if it is sampled again.
+func (pm *ProcessManager) evictProcess(pid libpf.PID) {
+	log.Debugf("Evicting PID %v", pid)
+	defer pm.ebpf.RemoveReportedPID(pid)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, instance := range pm.interpreters[pid] {
+		if err := instance.Detach(pm.ebpf, pid); err != nil {
+			log.WithFields(log.Fields{"pid": pid}).Errorf(
+				"Failed to unload interpreter: %v", err)
+		}
+	}
+	delete(pm.interpreters, pid)
+	delete(pm.exitEvents, pid)
+
+	if pm.removeProcessInfo(pid) {
+		pm.evictedProcesses.Add(1)
+	}
+}
+
+// synchronizeSharedAddressSpace checks whether the process pr, which is not tracked, shares
+// the address space of its tracked parent, as it is the case for children of vfork or of
+// clone with CLONE_VM until they call execve. The mappings of such children are not parsed,
+// which would register the mappings of the parent a second time and attach a second set of
+// interpreter state to them. Instead, the entries of the parent in pid_page_to_mapping_info
+// are mirrored for the child, so that the eBPF code unwinds its native frames with the stack
+// deltas of the parent. It returns true if pr shares the address space of its parent.
+//
+// Tracked processes return early, so the parent PID is read only once when a process is
+// discovered, and kcmp is only called if the parent is tracked.
+func (pm *ProcessManager) synchronizeSharedAddressSpace(pr process.Process) bool {
+	asd, ok := pr.(addressSpaceDetector)
+	if !ok {
+		return false
+	}
+	pid := pr.PID()
+	pm.mu.RLock()
+	_, tracked := pm.pidToProcessInfo[pid]
+	pm.mu.RUnlock()
+	if tracked {
+		return false
+	}
+
+	shared := false
+	ppid, err := asd.ParentPID()
+	if err == nil {
+		pm.mu.RLock()
+		_, parentTracked := pm.pidToProcessInfo[ppid]
+		pm.mu.RUnlock()
+		if parentTracked {
+			shared, _ = asd.SharesAddressSpace(ppid)
+		}
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	// The mirrored entries are replaced, as the process might have called execve or the
+	// mappings of the parent might have changed since the process was last synchronized.
+	_, wasShared := pm.sharedAddressSpace[pid]
+	pm.removeSharedAddressSpace(pid)
+	if !shared {
+		return false
+	}
+	if _, ok := pm.pidToProcessInfo[ppid]; !ok {
+		// The parent exited in the meantime.
+		return false
+	}
+	if !wasShared {
+		log.Debugf("PID %d shares the address space of its parent %d", pid, ppid)
+	}
+	pm.mirrorParentMappings(pid, ppid)
+	return true
+}
+
+// mirrorParentMappings inserts the mappings of the tracked process ppid into the eBPF map
+// pid_page_to_mapping_info for pid. The mapped executables stay referenced by the parent
+// only, so the mirrored entries are removed together with the parent.
+// Caller must hold pm.mu write lock.
+func (pm *ProcessManager) mirrorParentMappings(pid, ppid libpf.PID) {
+	shared := &sharedProcess{ppid: ppid}
+	pm.sharedAddressSpace[pid] = shared
+
+	if err := pm.ebpf.UpdatePidPageMappingInfo(pid, dummyPrefix, 0, 0); err != nil {
+		log.WithFields(log.Fields{"pid": pid}).Errorf(
+			"Failed to update pid_page_to_mapping_info dummy entry: %v", err)
+		return
+	}
+	shared.prefixes = append(shared.prefixes, dummyPrefix)
+
+	for _, m := range pm.pidToProcessInfo[ppid].mappings {
+		prefixes, err := lpm.CalculatePrefixList(uint64(m.Vaddr), uint64(m.Vaddr)+m.Length)
+		if err != nil {
+			log.WithFields(log.Fields{"pid": pid}).Errorf(
+				"Failed to create LPM entries: %v", err)
+			continue
+		}
+		for _, prefix := range prefixes {
+			if err = pm.ebpf.UpdatePidPageMappingInfo(pid, prefix, uint64(m.FileID),
+				m.Bias); err != nil {
+				log.WithFields(log.Fields{"pid": pid}).Errorf(
+					"Failed to update pid_page_to_mapping_info (page: 0x%x/%d): %v",
+					prefix.Key, prefix.Length, err)
+				break
+			}
+			shared.prefixes = append(shared.prefixes, prefix)
+		}
+	}
+	pm.pidPageToMappingInfoSize += uint64(len(shared.prefixes))
+}
+
+// removeSharedAddressSpace removes the entries mirrored for pid by mirrorParentMappings
+// from the eBPF maps, if there are any.
+// Caller must hold pm.mu write lock.
+func (pm *ProcessManager) removeSharedAddressSpace(pid libpf.PID) {
+	shared, ok := pm.sharedAddressSpace[pid]
+	if !ok {
+		return
+	}
+	delete(pm.sharedAddressSpace, pid)
+	if len(shared.prefixes) == 0 {
+		return
+	}
+
+	deleted, err := pm.ebpf.DeletePidPageMappingInfo(pid, shared.prefixes)
+	if err != nil {
+		log.WithFields(log.Fields{"pid": pid}).Errorf(
+			"Failed to delete mirrored mappings: %v", err)
+	}
+	pm.pidPageToMappingInfoSize -= uint64(deleted)
+}
+
+// ExecutableName returns the base name of the main executable of the process pid, or an
+// empty string if the process is not known.
+func (pm *ProcessManager) ExecutableName(pid libpf.PID) string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if shared, ok := pm.sharedAddressSpace[pid]; ok {
+		pid = shared.ppid
+	}
+	if info, ok := pm.pidToProcessInfo[pid]; ok {
+		return info.executable
+	}
+	return ""
+}
+
+// emulatorFileIDHi is the upper half of the synthetic file IDs of the frames standing for
+// the stacks of emulated processes.
+const emulatorFileIDHi = 0x656d756c61746f72 // "emulator"
+
+// emulatorFileID returns the synthetic file ID of the frame standing for the stacks of
+// processes emulating machine.
+func emulatorFileID(machine elf.Machine) libpf.FileID {
+	return libpf.NewFileID(emulatorFileIDHi, uint64(machine))
+}
+
+// compatFileIDHi is the upper half of the synthetic file IDs of the frames standing for
+// the stacks of 32-bit processes.
+const compatFileIDHi = 0x33322d6269740000 // "32-bit"
+
+// compatFileID returns the synthetic file ID of the frame standing for the stacks of
+// 32-bit processes of machine.
+func compatFileID(machine elf.Machine) libpf.FileID {
+	return libpf.NewFileID(compatFileIDHi, uint64(machine))
+}
+
+// userFramesFileID returns the synthetic file ID of the single frame the user mode frames
+// of pid are replaced with, if it runs its executables under an emulator or is a 32-bit
+// process. Otherwise, false is returned.
+func (pm *ProcessManager) userFramesFileID(pid libpf.PID) (libpf.FileID, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if shared, ok := pm.sharedAddressSpace[pid]; ok {
+		pid = shared.ppid
+	}
+	info, ok := pm.pidToProcessInfo[pid]
+	switch {
+	case !ok:
+		return libpf.FileID{}, false
+	case info.emulatedMachine != elf.EM_NONE:
+		return emulatorFileID(info.emulatedMachine), true
+	case info.compatMachine != elf.EM_NONE:
+		return compatFileID(info.compatMachine), true
+	}
+	return libpf.FileID{}, false
+}
+
+// ProcessLabels returns the labels the process pid defined in its environment, together
+// with the version labels of its interpreters, or nil if there are none.
+func (pm *ProcessManager) ProcessLabels(pid libpf.PID) map[string]string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if shared, ok := pm.sharedAddressSpace[pid]; ok {
+		pid = shared.ppid
+	}
+	if info, ok := pm.pidToProcessInfo[pid]; ok {
+		return info.labels
+	}
+	return nil
 }
 
 func (pm *ProcessManager) SynchronizeProcess(pr process.Process) {
 	pid := pr.PID()
 	log.Debugf("= PID: %v", pid)
 
+	if pm.synchronizeSharedAddressSpace(pr) {
+		// Keep the PID in reported_pids, so that it is synchronized again after the
+		// timeout, e.g. after it called execve, and does not cause an event flood.
+		return
+	}
+
 	pm.mappingStats.numProcAttempts.Add(1)
 	start := time.Now()
 	mappings, err := pr.GetMappings()
@@ -555,6 +974,12 @@ func (pm *ProcessManager) SynchronizeProcess(pr process.Process) {
 			// inspect it. Exiting here keeps the PID in the eBPF maps so
 			// we avoid a notification flood to resynchronize.
 			pm.mappingStats.errProcPerm.Add(1)
+			if !pm.procPermWarned.Swap(true) {
+				log.Warnf("Failed to read the mappings of PID %d: %v. Processes whose "+
+					"mappings can not be read are not symbolized, and further errors are "+
+					"not logged. Check the capabilities of the agent and the hidepid "+
+					"option of /proc.", pid, err)
+			}
 			return
 		}
 
@@ -607,6 +1032,8 @@ func (pm *ProcessManager) SynchronizeProcess(pr process.Process) {
 		// additional code (e.g. plugins, Asterisk).
 		// Also see: Unified PID Events design doc
 		pm.ebpf.RemoveReportedPID(pid)
+
+		pm.evictProcesses(pid)
 	}
 }
 
@@ -621,6 +1048,11 @@ func (pm *ProcessManager) CleanupPIDs() {
 			deadPids = append(deadPids, pid)
 		}
 	}
+	for pid := range pm.sharedAddressSpace {
+		if live, _ := proc.IsPIDLive(pid); !live {
+			deadPids = append(deadPids, pid)
+		}
+	}
 	pm.mu.RUnlock()
 
 	for _, pid := range deadPids {
@@ -0,0 +1,61 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package processmanager
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/process"
+)
+
+// TestLoadBiasPIE checks that the load bias derived from the mappings of a running PIE
+// binary resolves the ELF virtual addresses, that the interpreter loaders work with, to
+// the addresses of the process.
+func TestLoadBiasPIE(t *testing.T) {
+	path, err := filepath.Abs("../libpf/pfelf/testdata/pie-interpreter")
+	require.NoError(t, err)
+	cmd := exec.Command(path)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	pr := process.New(libpf.PID(cmd.Process.Pid))
+	mappings, err := pr.GetMappings()
+	require.NoError(t, err)
+	ef, err := pr.OpenELF(path)
+	require.NoError(t, err)
+	defer ef.Close()
+	mapper := ef.GetAddressMapper()
+	sym, err := ef.LookupSymbol("interp_state")
+	require.NoError(t, err)
+
+	found := false
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Path != path || !m.IsExecutable() {
+			continue
+		}
+		found = true
+		bias, ok := mapper.LoadBias(m.Vaddr, m.FileOffset)
+		require.True(t, ok)
+		assert.NotZero(t, bias)
+
+		rm := pr.GetRemoteMemory()
+		addr := libpf.Address(bias) + libpf.Address(sym.Address)
+		assert.Equal(t, uint64(0x7265746572706e69), rm.Uint64(addr))
+		assert.Equal(t, uint64(3), rm.Uint64(addr+8))
+	}
+	assert.True(t, found, "no executable mapping of %s", path)
+}
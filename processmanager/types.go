@@ -7,15 +7,19 @@
 package processmanager
 
 import (
+	"debug/elf"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	lru "github.com/elastic/go-freelru"
 
 	"github.com/elastic/otel-profiling-agent/host"
 	"github.com/elastic/otel-profiling-agent/interpreter"
 	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/elfunwindinfo"
 	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/lpm"
 	"github.com/elastic/otel-profiling-agent/metrics"
 	pmebpf "github.com/elastic/otel-profiling-agent/processmanager/ebpf"
 	eim "github.com/elastic/otel-profiling-agent/processmanager/execinfomanager"
@@ -28,9 +32,11 @@ import (
 // error. This avoids inspection of non-ELF or corrupted files again and again.
 type elfInfo struct {
 	err           error
-	lastModified  int64
+	identity      pfelf.FileIdentity
 	fileID        host.FileID
 	addressMapper pfelf.AddressMapper
+	// transientFailures is the number of consecutive transient failures to open the file
+	transientFailures int
 }
 
 // ProcessManager is responsible for managing the events happening throughout the lifespan of a
@@ -58,6 +64,11 @@ type ProcessManager struct {
 	// exitEvents records the pid exit time and is a list of pending exit events to be handled.
 	exitEvents map[libpf.PID]libpf.KTime
 
+	// sharedAddressSpace records the processes sharing the address space of their parent,
+	// e.g. children of vfork. The mappings and interpreter state of such processes are those
+	// of the parent and are not tracked separately.
+	sharedAddressSpace map[libpf.PID]*sharedProcess
+
 	// ebpf contains the interface to manipulate ebpf maps
 	ebpf pmebpf.EbpfHandler
 
@@ -82,10 +93,28 @@ type ProcessManager struct {
 		totalProcParseUsec atomic.Uint32
 	}
 
+	// procPermWarned is set once a permission error reading the mappings of a process was
+	// logged. Further permission errors are only counted, to avoid flooding the log.
+	procPermWarned atomic.Bool
+
 	// elfInfoCache provides a cache to quickly retrieve the ELF info and fileID for a particular
 	// executable. It caches results based on iNode number and device ID. Locked LRU.
 	elfInfoCache *lru.LRU[libpf.OnDiskFileIdentifier, elfInfo]
 
+	// goSymbolTables caches the symbol tables of Go executables by file ID. Locked LRU, as
+	// it is written when processing mappings and read when converting traces.
+	goSymbolTables *lru.SyncedLRU[host.FileID, *elfunwindinfo.GoSymbolTable]
+
+	// goSymbolStats are statistics for the symbolization of Go frames
+	goSymbolStats symbolizationStats
+
+	// reportedGoFrames tracks the Go frames that were symbolized and reported already.
+	reportedGoFrames *lru.SyncedLRU[libpf.FrameID, libpf.Void]
+
+	// goFrames queues the Go frames to be symbolized, so that this is done outside of
+	// the trace conversion.
+	goFrames chan goFrame
+
 	// reporter is the interface to report symbolization information
 	reporter reporter.SymbolReporter
 
@@ -98,6 +127,26 @@ type ProcessManager struct {
 
 	// filterErrorFrames determines whether error frames are dropped by `ConvertTrace`.
 	filterErrorFrames bool
+
+	// reportedSyntheticFrames holds the time the synthetic frames, like the one replacing
+	// the frames beyond the maximum stack depth or the placeholder frames of interpreter
+	// frames that can not be symbolized, were last reported by their file ID.
+	reportedSyntheticFrames map[libpf.FileID]time.Time
+
+	// maxProcesses is the maximum number of tracked processes, or 0 if unlimited. If more
+	// processes are tracked, the least recently sampled ones are evicted.
+	maxProcesses int
+
+	// evictedProcesses counts the processes evicted to stay within maxProcesses.
+	evictedProcesses atomic.Uint64
+
+	// symbolizationDeadline is the deadline of the symbolization of the interpreter frame
+	// being symbolized, after which the memory reads of the interpreter instances fail.
+	symbolizationDeadline symbolizationDeadline
+
+	// symbolizationTimeouts counts the traces whose conversion exceeded the symbolization
+	// timeout.
+	symbolizationTimeouts atomic.Uint64
 }
 
 // Mapping represents an executable memory mapping of a process.
@@ -148,4 +197,46 @@ type processInfo struct {
 	mappings addressSpace
 	// C-library Thread Specific Data information
 	tsdInfo *tpbase.TSDInfo
+	// executable is the base name of the main executable
+	executable string
+	// labels holds the labels defined in the environment of the process and the version
+	// labels of its interpreters
+	labels map[string]string
+	// lastSampled is the KTime of the most recent trace of the process, or the time it was
+	// discovered at if there is none yet
+	lastSampled atomic.Int64
+	// emulatedMachine is the machine of the executables of a process running under an
+	// emulator like qemu-user, or elf.EM_NONE for native processes
+	emulatedMachine elf.Machine
+	// compatMachine is the machine of a 32-bit process the kernel runs in compat mode, or
+	// elf.EM_NONE for 64-bit processes
+	compatMachine elf.Machine
+}
+
+// sharedProcess is a process sharing the address space of its parent.
+type sharedProcess struct {
+	// ppid is the PID of the parent.
+	ppid libpf.PID
+	// prefixes are the entries of pid_page_to_mapping_info that mirror the mappings of
+	// the parent for the process.
+	prefixes []lpm.Prefix
+}
+
+// addressSpaceDetector is implemented by processes that can be checked for sharing the
+// address space of their parent.
+type addressSpaceDetector interface {
+	ParentPID() (libpf.PID, error)
+	SharesAddressSpace(pid libpf.PID) (bool, error)
+}
+
+// emulationDetector is implemented by processes that can be checked for running
+// foreign-architecture executables under an emulator.
+type emulationDetector interface {
+	EmulatedMachine() (elf.Machine, error)
+}
+
+// compatDetector is implemented by processes that can be checked for being 32-bit
+// processes running in compat mode.
+type compatDetector interface {
+	CompatMachine() (elf.Machine, error)
 }
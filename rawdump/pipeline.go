@@ -0,0 +1,116 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package rawdump
+
+import (
+	"context"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/traceutil"
+	"github.com/elastic/otel-profiling-agent/reporter"
+	"github.com/elastic/otel-profiling-agent/tracehandler"
+)
+
+// traceProcessor is a TraceProcessor that converts the traces of a dump with a Symbolizer
+// instead of the process manager, which requires access to the profiled processes.
+type traceProcessor struct {
+	symbolizer *Symbolizer
+	reporter   reporter.SymbolReporter
+	// reported holds the frames whose metadata was reported already.
+	reported libpf.Set[libpf.FrameID]
+}
+
+// Compile time check to make sure traceProcessor satisfies the interface.
+var _ tracehandler.TraceProcessor = (*traceProcessor)(nil)
+
+// fileID returns the file ID of the executable with the given host file ID. The host file
+// ID is derived from the upper 64 bits of the file ID, which is used to reconstruct it.
+func fileID(id FileID) libpf.FileID {
+	return libpf.NewFileID(uint64(id), 0)
+}
+
+// ConvertTrace implements the TraceProcessor interface. Native and kernel frames are
+// reported with the symbols found by the Symbolizer. Interpreter frames are reported as
+// unsymbolized frames, like frames of processes the agent could not symbolize.
+func (p *traceProcessor) ConvertTrace(trace *host.Trace) *libpf.Trace {
+	frames := p.symbolizer.Symbolize(newTrace(trace))
+	newTrace := &libpf.Trace{
+		Files:      make([]libpf.FileID, 0, len(frames)),
+		Linenos:    make([]libpf.AddressOrLineno, 0, len(frames)),
+		FrameTypes: make([]libpf.FrameType, 0, len(frames)),
+	}
+	for i := range frames {
+		frame := &frames[i]
+		interp := frame.Type.Interpreter()
+		switch {
+		case frame.Type.IsError():
+			newTrace.AppendFrame(frame.Type, libpf.UnsymbolizedFileID, frame.Address)
+		case interp == libpf.Native || interp == libpf.Kernel:
+			id := fileID(frame.FileID)
+			newTrace.AppendFrame(frame.Type, id, frame.Address)
+			p.reportFrame(id, frame)
+		default:
+			newTrace.AppendFrame(frame.Type, libpf.UnsymbolizedFileID, 0)
+		}
+	}
+	newTrace.Hash = traceutil.HashTrace(newTrace)
+	return newTrace
+}
+
+// reportFrame reports the symbolic information of frame, if any, once.
+func (p *traceProcessor) reportFrame(id libpf.FileID, frame *SymbolizedFrame) {
+	if frame.Function == "" {
+		return
+	}
+	frameID := libpf.NewFrameID(id, frame.Address)
+	if _, ok := p.reported[frameID]; ok {
+		return
+	}
+	p.reported[frameID] = libpf.Void{}
+	p.reporter.FrameMetadata(id, frame.Address, libpf.SourceLineno(frame.SourceLine),
+		uint32(frame.Offset), frame.Function, frame.SourceFile)
+}
+
+// SymbolizationComplete implements the TraceProcessor interface.
+func (p *traceProcessor) SymbolizationComplete(libpf.KTime) {}
+
+// ExecutableName implements the TraceProcessor interface. The main executables of the
+// processes are not recorded in dumps.
+func (p *traceProcessor) ExecutableName(libpf.PID) string {
+	return ""
+}
+
+// ProcessLabels implements the TraceProcessor interface. The labels of the processes are
+// not recorded in dumps.
+func (p *traceProcessor) ProcessLabels(libpf.PID) map[string]string {
+	return nil
+}
+
+// Replay feeds the traces of dump through the symbolization and reporting pipeline of the
+// agent, using symbolizer for the native frames, and reports the results to rep. Together
+// with reporter.PprofReporter this turns a dump into a profile.
+func Replay(dump *Dump, symbolizer *Symbolizer, rep reporter.Reporter) error {
+	for _, exe := range dump.Executables {
+		rep.ExecutableMetadata(context.Background(), fileID(exe.FileID), exe.FileName,
+			exe.BuildID, 0, 0, pfelf.AddressMapper{})
+	}
+
+	traces := make(chan *host.Trace)
+	go func() {
+		defer close(traces)
+		for i := range dump.Traces {
+			traces <- dump.Traces[i].hostTrace()
+		}
+	}()
+	return tracehandler.Run(rep, &traceProcessor{
+		symbolizer: symbolizer,
+		reporter:   rep,
+		reported:   make(libpf.Set[libpf.FrameID]),
+	}, traces)
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package rawdump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+// serveDebuginfod returns the URL of a debuginfod server holding the given file, and
+// counts the requests for it in requests.
+func serveDebuginfod(t *testing.T, buildID, path string, requests *int) string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/buildid/"+buildID+"/debuginfo" {
+			http.NotFound(w, r)
+			return
+		}
+		*requests++
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestReplay(t *testing.T) {
+	addr, buildID := lookupSymbol(t, testGoExecutable, "main.main")
+
+	var requests int
+	url := serveDebuginfod(t, buildID, testGoExecutable, &requests)
+	dump := &Dump{
+		Executables: map[FileID]Executable{
+			1: {FileID: 1, FileName: "app", BuildID: buildID},
+		},
+		Traces: []Trace{
+			{Hash: 1, PID: 10, Comm: "app", Frames: []Frame{
+				{FileID: 1, Address: addr + 4, Type: libpf.NativeFrame},
+			}},
+			{Hash: 1, PID: 10, Comm: "app", Frames: []Frame{
+				{FileID: 1, Address: addr + 4, Type: libpf.NativeFrame},
+			}},
+			{Hash: 2, PID: 10, Comm: "app", Frames: []Frame{
+				{FileID: 42, Address: 17, Type: libpf.PythonFrame},
+				{FileID: 1, Address: addr + 8, Type: libpf.NativeFrame},
+			}},
+		},
+	}
+	symbolizer, err := NewSymbolizer(dump, SymbolRoots{
+		DebuginfodURLs: []string{url},
+		CacheDir:       t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	rep, err := reporter.NewPprofReporter(&reporter.Config{}, 20)
+	require.NoError(t, err)
+	require.NoError(t, Replay(dump, symbolizer, rep))
+	assert.Equal(t, 1, requests)
+
+	var buf bytes.Buffer
+	require.NoError(t, rep.WriteProfile(&buf))
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	var profile pprofextended.Profile
+	require.NoError(t, proto.Unmarshal(data, &profile))
+
+	var samples int64
+	for _, sample := range profile.Sample {
+		samples += sample.Value[0]
+	}
+	assert.Equal(t, int64(3), samples)
+	assert.Len(t, profile.Sample, 2)
+
+	var functions []string
+	for _, fn := range profile.Function {
+		functions = append(functions, profile.StringTable[fn.Name])
+	}
+	assert.Contains(t, functions, "main.main")
+	var mappings []string
+	for _, m := range profile.Mapping {
+		mappings = append(mappings, profile.StringTable[m.Filename])
+	}
+	assert.Contains(t, mappings, "app")
+}
+
+func TestFetchDebuginfod(t *testing.T) {
+	var requests int
+	url := serveDebuginfod(t, "abcd", testCExecutable, &requests)
+	cacheDir := t.TempDir()
+
+	path, err := fetchDebuginfod(url, "abcd", cacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheDir, "abcd.debug"), path)
+	// Downloaded files are cached.
+	_, err = fetchDebuginfod(url+"/", "abcd", cacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+
+	_, err = fetchDebuginfod(url, "0123", cacheDir)
+	assert.ErrorContains(t, err, "404")
+	_, err = fetchDebuginfod(url, "../etc", cacheDir)
+	assert.Error(t, err)
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
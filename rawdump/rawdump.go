@@ -0,0 +1,352 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Package rawdump records the traces received from the eBPF unwinder before they are
+// symbolized, so that they can be replayed offline against copies of the executables
+// of the profiled host, e.g. to reproduce symbolization bugs seen in the field.
+//
+// A dump is a file in JSON Lines format: each line holds one JSON encoded Record.
+// Records holding an Executable describe the executables the agent has seen, records
+// holding a Trace describe a sampled trace. The frames of a trace refer to executables
+// by their host file ID, in the same encoding as the file_id of the Executable. The
+// executable of a frame may be recorded after the trace that refers to it.
+//
+// The format of the fields is documented in docs/raw-dump.md.
+package rawdump
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+// FileID is a host file ID that is encoded as hexadecimal string in JSON, as JSON
+// numbers can not represent all 64-bit values in many decoders.
+type FileID host.FileID
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (f FileID) MarshalText() ([]byte, error) {
+	return marshalHex(uint64(f)), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (f *FileID) UnmarshalText(text []byte) error {
+	v, err := unmarshalHex(text)
+	if err != nil {
+		return fmt.Errorf("invalid file ID '%s': %v", text, err)
+	}
+	*f = FileID(v)
+	return nil
+}
+
+// TraceHash is the hash of a trace as computed by the eBPF unwinder. It is encoded as
+// hexadecimal string in JSON like FileID.
+type TraceHash host.TraceHash
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (h TraceHash) MarshalText() ([]byte, error) {
+	return marshalHex(uint64(h)), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (h *TraceHash) UnmarshalText(text []byte) error {
+	v, err := unmarshalHex(text)
+	if err != nil {
+		return fmt.Errorf("invalid trace hash '%s': %v", text, err)
+	}
+	*h = TraceHash(v)
+	return nil
+}
+
+func marshalHex(v uint64) []byte {
+	return []byte(fmt.Sprintf("%016x", v))
+}
+
+func unmarshalHex(text []byte) (uint64, error) {
+	return strconv.ParseUint(string(text), 16, 64)
+}
+
+// Frame is a frame of a trace as recorded by the eBPF unwinder.
+type Frame struct {
+	// FileID is the host file ID of the executable for native and kernel frames. For
+	// interpreter frames its meaning depends on the interpreter.
+	FileID FileID `json:"file_id"`
+	// Address is the address of a native or kernel frame within the executable, or the
+	// line or bytecode offset for interpreter frames.
+	Address libpf.AddressOrLineno `json:"address"`
+	// Type is the frame type (libpf.FrameType).
+	Type libpf.FrameType `json:"type"`
+}
+
+// Trace is a trace as recorded by the eBPF unwinder.
+type Trace struct {
+	// Time is the time in nanoseconds since the Unix epoch the agent received the trace.
+	Time int64 `json:"time"`
+	// KTime is the monotonic time in nanoseconds the trace was sampled at.
+	KTime libpf.KTime `json:"ktime"`
+	// Hash identifies the frames of the trace.
+	Hash TraceHash `json:"hash"`
+	PID  libpf.PID `json:"pid"`
+	TID  libpf.PID `json:"tid"`
+	CPU  int       `json:"cpu"`
+	Comm string    `json:"comm"`
+	// Frames holds the frames of the trace, starting with the innermost frame.
+	Frames []Frame `json:"frames"`
+}
+
+// Executable describes an executable the agent has seen.
+type Executable struct {
+	FileID FileID `json:"file_id"`
+	// FileName is the base name of the executable.
+	FileName string `json:"file_name"`
+	// BuildID is the GNU build ID of the executable, or empty if it has none.
+	BuildID string `json:"build_id,omitempty"`
+}
+
+// Record is a single line of a dump. Exactly one of its fields is set.
+type Record struct {
+	Trace      *Trace      `json:"trace,omitempty"`
+	Executable *Executable `json:"executable,omitempty"`
+}
+
+// Writer writes a dump. It is a Reporter, so that it records the executables reported
+// by the process manager when added to a reporter.Multi. Except for ExecutableMetadata,
+// its Reporter methods do nothing.
+type Writer struct {
+	mu      sync.Mutex
+	file    *os.File
+	out     *bufio.Writer
+	encoder *json.Encoder
+	// executables holds the file IDs of the executables recorded so far.
+	executables libpf.Set[host.FileID]
+	// filter selects the traces that are recorded, see SetFilter.
+	filter *reporter.SampleFilter
+}
+
+// FilterKeys are the keys of the sample filters the traces of a dump can be selected with,
+// as the traces are recorded before the metadata of their processes is known.
+var FilterKeys = []string{"comm", "pid"}
+
+// Assert that we implement the full Reporter interface.
+var _ reporter.Reporter = (*Writer)(nil)
+
+// Create creates the dump file at path, and returns a Writer for it.
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raw dump: %v", err)
+	}
+	w := newWriter(file)
+	w.file = file
+	return w, nil
+}
+
+// newWriter returns a Writer writing to out.
+func newWriter(out io.Writer) *Writer {
+	w := &Writer{
+		out:         bufio.NewWriter(out),
+		executables: make(libpf.Set[host.FileID]),
+	}
+	w.encoder = json.NewEncoder(w.out)
+	return w
+}
+
+// write adds record to the dump.
+func (w *Writer) write(record *Record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.encoder.Encode(record); err != nil {
+		log.Errorf("Failed to write raw dump record: %v", err)
+	}
+}
+
+// newTrace returns the dump representation of trace.
+func newTrace(trace *host.Trace) *Trace {
+	frames := make([]Frame, 0, len(trace.Frames))
+	for _, frame := range trace.Frames {
+		frames = append(frames, Frame{
+			FileID:  FileID(frame.File),
+			Address: frame.Lineno,
+			Type:    frame.Type,
+		})
+	}
+	return &Trace{
+		KTime:  trace.KTime,
+		Hash:   TraceHash(trace.Hash),
+		PID:    trace.PID,
+		TID:    trace.TID,
+		CPU:    trace.CPU,
+		Comm:   trace.Comm,
+		Frames: frames,
+	}
+}
+
+// hostTrace returns the trace in the representation of the eBPF unwinder.
+func (t *Trace) hostTrace() *host.Trace {
+	frames := make([]host.Frame, 0, len(t.Frames))
+	for _, frame := range t.Frames {
+		frames = append(frames, host.Frame{
+			File:   host.FileID(frame.FileID),
+			Lineno: frame.Address,
+			Type:   frame.Type,
+		})
+	}
+	return &host.Trace{
+		Comm:   t.Comm,
+		Frames: frames,
+		Hash:   host.TraceHash(t.Hash),
+		KTime:  t.KTime,
+		PID:    t.PID,
+		TID:    t.TID,
+		CPU:    t.CPU,
+	}
+}
+
+// SetFilter sets the filter selecting the traces that are added to the dump, whose
+// conditions may only apply to FilterKeys. By default, all traces are added. It must be
+// called before traces are written.
+func (w *Writer) SetFilter(filter *reporter.SampleFilter) error {
+	if err := filter.CheckKeys(FilterKeys...); err != nil {
+		return err
+	}
+	w.filter = filter
+	return nil
+}
+
+// WriteTrace adds trace to the dump, if it is selected by the filter of w.
+func (w *Writer) WriteTrace(trace *host.Trace) {
+	if !w.filter.Selects(&reporter.TraceEventMeta{Comm: trace.Comm, PID: trace.PID}) {
+		return
+	}
+	record := newTrace(trace)
+	record.Time = time.Now().UnixNano()
+	w.write(&Record{Trace: record})
+}
+
+// Tee returns a channel that receives the traces sent to in, after adding them to the
+// dump. The returned channel is closed once in is closed.
+func (w *Writer) Tee(in <-chan *host.Trace) chan *host.Trace {
+	out := make(chan *host.Trace)
+	go func() {
+		defer close(out)
+		for trace := range in {
+			w.WriteTrace(trace)
+			out <- trace
+		}
+	}()
+	return out
+}
+
+// Close flushes the dump and closes the underlying file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write raw dump: %v", err)
+	}
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// ReportFramesForTrace implements the TraceReporter interface.
+func (w *Writer) ReportFramesForTrace(*libpf.Trace) {}
+
+// ReportCountForTrace implements the TraceReporter interface.
+func (w *Writer) ReportCountForTrace(libpf.TraceHash, *reporter.TraceEventMeta) {}
+
+// ReportFallbackSymbol implements the SymbolReporter interface.
+func (w *Writer) ReportFallbackSymbol(libpf.FrameID, string) {}
+
+// ExecutableMetadata implements the SymbolReporter interface. Each executable is
+// added to the dump once.
+func (w *Writer) ExecutableMetadata(_ context.Context, fileID libpf.FileID,
+	fileName, buildID string, _, _ uint64, _ pfelf.AddressMapper) {
+	hostFileID := host.CalculateKernelFileID(fileID)
+	w.mu.Lock()
+	_, known := w.executables[hostFileID]
+	w.executables[hostFileID] = libpf.Void{}
+	w.mu.Unlock()
+	if known {
+		return
+	}
+	w.write(&Record{Executable: &Executable{
+		FileID:   FileID(hostFileID),
+		FileName: fileName,
+		BuildID:  buildID,
+	}})
+}
+
+// FrameMetadata implements the SymbolReporter interface.
+func (w *Writer) FrameMetadata(libpf.FileID, libpf.AddressOrLineno, libpf.SourceLineno,
+	uint32, string, string) {
+}
+
+// ReportHostMetadata implements the HostMetadataReporter interface.
+func (w *Writer) ReportHostMetadata(map[string]string) {}
+
+// ReportHostMetadataBlocking implements the HostMetadataReporter interface.
+func (w *Writer) ReportHostMetadataBlocking(context.Context, map[string]string, int,
+	time.Duration) error {
+	return nil
+}
+
+// ReportMetrics implements the MetricsReporter interface.
+func (w *Writer) ReportMetrics(uint32, []uint32, []int64) {}
+
+// Stop implements the Reporter interface. The dump is only completed by Close.
+func (w *Writer) Stop() {}
+
+// GetMetrics implements the Reporter interface.
+func (w *Writer) GetMetrics() reporter.Metrics {
+	return reporter.Metrics{}
+}
+
+// Dump holds the records read from a dump.
+type Dump struct {
+	// Traces holds the traces in the order they were recorded.
+	Traces []Trace
+	// Executables maps host file IDs to the executables they belong to.
+	Executables map[FileID]Executable
+}
+
+// Read reads a dump from r.
+func Read(r io.Reader) (*Dump, error) {
+	dump := &Dump{Executables: make(map[FileID]Executable)}
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record Record
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return dump, nil
+			}
+			return nil, fmt.Errorf("invalid record %d: %v", line, err)
+		}
+		switch {
+		case record.Trace != nil:
+			dump.Traces = append(dump.Traces, *record.Trace)
+		case record.Executable != nil:
+			dump.Executables[record.Executable.FileID] = *record.Executable
+		default:
+			return nil, fmt.Errorf("record %d is empty", line)
+		}
+	}
+}
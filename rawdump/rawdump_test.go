@@ -0,0 +1,182 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package rawdump
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+const (
+	testGoExecutable = "../libpf/nativeunwind/elfunwindinfo/testdata/helloworld"
+	testCExecutable  = "../libpf/nativeunwind/elfunwindinfo/testdata/sframe"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+
+	fileID := libpf.NewFileID(0xfedcba9876543210, 0x1234)
+	w.ExecutableMetadata(context.Background(), fileID, "libc.so.6", "abcd", 1, 2,
+		pfelf.AddressMapper{})
+	w.ExecutableMetadata(context.Background(), fileID, "libc.so.6", "abcd", 1, 2,
+		pfelf.AddressMapper{})
+
+	in := make(chan *host.Trace)
+	out := w.Tee(in)
+	trace := &host.Trace{
+		Comm:  "bash",
+		KTime: 1234,
+		Hash:  0xabcdef,
+		PID:   100,
+		TID:   101,
+		CPU:   3,
+		Frames: []host.Frame{
+			{File: host.CalculateKernelFileID(fileID), Lineno: 0x1000, Type: libpf.NativeFrame},
+			{File: 42, Lineno: 17, Type: libpf.PythonFrame},
+		},
+	}
+	go func() {
+		in <- trace
+		close(in)
+	}()
+	assert.Same(t, trace, <-out)
+	_, ok := <-out
+	assert.False(t, ok)
+	require.NoError(t, w.Close())
+
+	// Each executable is recorded once.
+	assert.Equal(t, 1, strings.Count(buf.String(), `"executable"`))
+	assert.Contains(t, buf.String(), `"file_id":"fedcba9876543210"`)
+
+	dump, err := Read(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, map[FileID]Executable{
+		0xfedcba9876543210: {FileID: 0xfedcba9876543210, FileName: "libc.so.6", BuildID: "abcd"},
+	}, dump.Executables)
+	require.Len(t, dump.Traces, 1)
+	got := dump.Traces[0]
+	assert.NotZero(t, got.Time)
+	got.Time = 0
+	assert.Equal(t, Trace{
+		KTime: 1234,
+		Hash:  0xabcdef,
+		PID:   100,
+		TID:   101,
+		CPU:   3,
+		Comm:  "bash",
+		Frames: []Frame{
+			{FileID: 0xfedcba9876543210, Address: 0x1000, Type: libpf.NativeFrame},
+			{FileID: 42, Address: 17, Type: libpf.PythonFrame},
+		},
+	}, got)
+}
+
+func TestWriteFilter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+
+	cgroupFilter, err := reporter.NewSampleFilter([]string{"cgroup=/system.slice/*"})
+	require.NoError(t, err)
+	assert.Error(t, w.SetFilter(cgroupFilter))
+	filter, err := reporter.NewSampleFilter([]string{"comm=bash", "pid!=100"})
+	require.NoError(t, err)
+	require.NoError(t, w.SetFilter(filter))
+
+	w.WriteTrace(&host.Trace{Comm: "bash", PID: 100, Hash: 1})
+	w.WriteTrace(&host.Trace{Comm: "bash", PID: 200, Hash: 2})
+	w.WriteTrace(&host.Trace{Comm: "zsh", PID: 300, Hash: 3})
+	require.NoError(t, w.Close())
+
+	dump, err := Read(&buf)
+	require.NoError(t, err)
+	require.Len(t, dump.Traces, 1)
+	assert.Equal(t, libpf.PID(200), dump.Traces[0].PID)
+}
+
+func TestReadInvalid(t *testing.T) {
+	_, err := Read(strings.NewReader("{}\n"))
+	assert.ErrorContains(t, err, "record 1 is empty")
+	_, err = Read(strings.NewReader(`{"executable":{"file_id":"xyz"}}`))
+	assert.ErrorContains(t, err, "invalid record 1")
+}
+
+// lookupSymbol returns the address and build ID of the symbol name in the ELF file at path.
+func lookupSymbol(t *testing.T, path, name string) (addr libpf.AddressOrLineno, buildID string) {
+	ef, err := pfelf.Open(path)
+	require.NoError(t, err)
+	defer ef.Close()
+	buildID, err = ef.GetBuildID()
+	require.NoError(t, err)
+	symbols, err := ef.ReadSymbols()
+	require.NoError(t, err)
+	value, err := symbols.LookupSymbolAddress(libpf.SymbolName(name))
+	require.NoError(t, err)
+	return libpf.AddressOrLineno(value), buildID
+}
+
+func TestSymbolize(t *testing.T) {
+	// helloworld is a Go executable, sframe a C executable.
+	goAddr, goBuildID := lookupSymbol(t, testGoExecutable, "main.main")
+	cAddr, cBuildID := lookupSymbol(t, testCExecutable, "main")
+
+	dir := t.TempDir()
+	for _, path := range []string{testGoExecutable, testCExecutable} {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, filepath.Base(path)), data, 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("text"), 0o644))
+
+	dump := &Dump{Executables: map[FileID]Executable{
+		1: {FileID: 1, FileName: "app", BuildID: goBuildID},
+		2: {FileID: 2, FileName: "c-app", BuildID: cBuildID},
+		3: {FileID: 3, FileName: "sframe"},
+	}}
+	s, err := NewSymbolizer(dump, SymbolRoots{Dirs: []string{dir}})
+	require.NoError(t, err)
+
+	frames := s.Symbolize(&Trace{Frames: []Frame{
+		{FileID: 1, Address: goAddr + 4, Type: libpf.NativeFrame},
+		{FileID: 1, Address: goAddr + 4, Type: libpf.NativeFrame},
+		{FileID: 2, Address: cAddr + 4, Type: libpf.NativeFrame},
+		{FileID: 3, Address: cAddr + 4, Type: libpf.NativeFrame},
+		{FileID: 4, Address: 0x1000, Type: libpf.NativeFrame},
+		{FileID: 42, Address: 17, Type: libpf.PythonFrame},
+	}})
+	require.Len(t, frames, 6)
+	assert.Equal(t, "main.main", frames[0].Function)
+	assert.Equal(t, uint64(4), frames[0].Offset)
+	assert.Equal(t, "app", frames[0].FileName)
+	assert.Equal(t, "helloworld.go", filepath.Base(frames[0].SourceFile))
+	// Return addresses are adjusted to point into the call instruction.
+	assert.Equal(t, "main.main", frames[1].Function)
+	assert.Equal(t, uint64(3), frames[1].Offset)
+	// Executables without build ID are matched by name.
+	for _, frame := range frames[2:4] {
+		assert.Equal(t, "main", frame.Function)
+		assert.Equal(t, uint64(3), frame.Offset)
+		assert.Empty(t, frame.SourceFile)
+	}
+	// Frames of unknown executables and interpreter frames are left unsymbolized.
+	assert.Empty(t, frames[4].Function)
+	assert.Equal(t, libpf.AddressOrLineno(0xfff), frames[4].Address)
+	assert.Empty(t, frames[5].Function)
+	assert.Equal(t, libpf.AddressOrLineno(17), frames[5].Address)
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package rawdump
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/elfunwindinfo"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// SymbolizedFrame is a frame of a trace together with the symbolic information found
+// for it in the local executables.
+type SymbolizedFrame struct {
+	Frame
+	// FileName is the base name of the executable as recorded in the dump, or empty if
+	// the dump has no executable for the frame.
+	FileName string
+	// Function is the name of the function, or empty if it is not known.
+	Function string
+	// Offset is the offset of Address from the start of Function.
+	Offset uint64
+	// SourceFile and SourceLine are the source code location, if known. They are only
+	// available for Go executables.
+	SourceFile string
+	SourceLine int
+}
+
+// executableSymbols holds the symbol tables of a local executable.
+type executableSymbols struct {
+	symbols   *libpf.SymbolMap
+	goSymbols *elfunwindinfo.GoSymbolTable
+}
+
+// SymbolRoots are the sources of the executables a Symbolizer uses.
+type SymbolRoots struct {
+	// Dirs are the directories that are searched for executables, including their
+	// subdirectories.
+	Dirs []string
+	// DebuginfodURLs are the base URLs of debuginfod servers, from which executables
+	// that are not found in Dirs are downloaded by build ID.
+	DebuginfodURLs []string
+	// CacheDir is the directory downloaded files are stored in. It is required if
+	// DebuginfodURLs is set.
+	CacheDir string
+}
+
+// Symbolizer resolves the native frames of the traces of a dump to function names, using
+// local copies of the executables of the profiled host. Interpreter frames can not be
+// symbolized offline, as that requires access to the memory of the profiled process.
+type Symbolizer struct {
+	dump  *Dump
+	roots SymbolRoots
+	// byBuildID and byName map the build IDs and base names of the local executables
+	// to their paths.
+	byBuildID map[string]string
+	byName    map[string]string
+	// symbols caches the symbol tables by host file ID. A nil entry marks executables
+	// without local copy or symbols.
+	symbols map[FileID]*executableSymbols
+}
+
+// NewSymbolizer returns a Symbolizer for the traces of dump, using the ELF files found in
+// roots. Executables are matched by build ID, and by base name if the dump records no
+// build ID for them.
+func NewSymbolizer(dump *Dump, roots SymbolRoots) (*Symbolizer, error) {
+	if len(roots.DebuginfodURLs) > 0 && roots.CacheDir == "" {
+		return nil, errors.New("debuginfod requires a cache directory")
+	}
+	s := &Symbolizer{
+		dump:      dump,
+		roots:     roots,
+		byBuildID: make(map[string]string),
+		byName:    make(map[string]string),
+		symbols:   make(map[FileID]*executableSymbols),
+	}
+	for _, dir := range roots.Dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			ef, err := pfelf.Open(path)
+			if err != nil {
+				// Not an ELF file.
+				return nil
+			}
+			defer ef.Close()
+			if buildID, _ := ef.GetBuildID(); buildID != "" {
+				s.byBuildID[buildID] = path
+			}
+			s.byName[d.Name()] = path
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %v", dir, err)
+		}
+	}
+	return s, nil
+}
+
+// localPath returns the path of the local copy of exe, or an empty string if there is none.
+func (s *Symbolizer) localPath(exe *Executable) string {
+	if exe.BuildID == "" {
+		return s.byName[exe.FileName]
+	}
+	if path, ok := s.byBuildID[exe.BuildID]; ok {
+		return path
+	}
+	for _, url := range s.roots.DebuginfodURLs {
+		path, err := fetchDebuginfod(url, exe.BuildID, s.roots.CacheDir)
+		if err != nil {
+			log.Debugf("Failed to fetch %s from %s: %v", exe.BuildID, url, err)
+			continue
+		}
+		return path
+	}
+	return ""
+}
+
+// debuginfodTimeout bounds the time a download from a debuginfod server may take.
+const debuginfodTimeout = time.Minute
+
+// fetchDebuginfod downloads the file with the symbols of the executable with the given
+// build ID from the debuginfod server at baseURL to cacheDir, and returns its path. The
+// separate debug information is preferred, as it holds the symbol table also for
+// stripped executables.
+func fetchDebuginfod(baseURL, buildID, cacheDir string) (string, error) {
+	if strings.ContainsAny(buildID, "/.") {
+		return "", fmt.Errorf("invalid build ID %s", buildID)
+	}
+	path := filepath.Join(cacheDir, buildID+".debug")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	client := http.Client{Timeout: debuginfodTimeout}
+	var err error
+	for _, kind := range []string{"debuginfo", "executable"} {
+		url := fmt.Sprintf("%s/buildid/%s/%s", strings.TrimSuffix(baseURL, "/"), buildID, kind)
+		if err = download(&client, url, path); err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+// download stores the content at url in the file at path.
+func download(client *http.Client, url, path string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	// Write to a temporary file first, so that incomplete downloads are not cached.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSymbols returns the symbol tables for the executable with the given file ID.
+func (s *Symbolizer) loadSymbols(fileID FileID) *executableSymbols {
+	if symbols, ok := s.symbols[fileID]; ok {
+		return symbols
+	}
+	s.symbols[fileID] = nil
+
+	exe, ok := s.dump.Executables[fileID]
+	if !ok {
+		return nil
+	}
+	path := s.localPath(&exe)
+	if path == "" {
+		log.Debugf("No local copy of %s (build ID %s)", exe.FileName, exe.BuildID)
+		return nil
+	}
+	ef, err := pfelf.Open(path)
+	if err != nil {
+		log.Warnf("Failed to open %s: %v", path, err)
+		return nil
+	}
+	defer ef.Close()
+
+	symbols := &executableSymbols{}
+	if ef.IsGolang() {
+		if symbols.goSymbols, err = elfunwindinfo.NewGoSymbolTable(ef); err != nil {
+			log.Debugf("Failed to read Go symbols of %s: %v", path, err)
+		}
+	}
+	if symbols.symbols, err = ef.ReadSymbols(); err != nil {
+		if symbols.symbols, err = ef.ReadDynamicSymbols(); err != nil {
+			log.Debugf("Failed to read symbols of %s: %v", path, err)
+		}
+	}
+	s.symbols[fileID] = symbols
+	return symbols
+}
+
+// Symbolize returns the frames of trace with the symbolic information found for them.
+// Like in the agent, the addresses of native frames which are return addresses are
+// decremented by one, so that they point into the call instruction.
+func (s *Symbolizer) Symbolize(trace *Trace) []SymbolizedFrame {
+	frames := make([]SymbolizedFrame, 0, len(trace.Frames))
+	for i, frame := range trace.Frames {
+		sf := SymbolizedFrame{Frame: frame}
+		interp := frame.Type.Interpreter()
+		if frame.Type.IsError() || (interp != libpf.Native && interp != libpf.Kernel) {
+			frames = append(frames, sf)
+			continue
+		}
+		if i > 0 || interp == libpf.Kernel {
+			sf.Address--
+		}
+		sf.FileName = s.dump.Executables[frame.FileID].FileName
+		s.symbolizeNative(&sf)
+		frames = append(frames, sf)
+	}
+	return frames
+}
+
+// symbolizeNative adds the symbolic information of the native frame sf.
+func (s *Symbolizer) symbolizeNative(sf *SymbolizedFrame) {
+	symbols := s.loadSymbols(sf.FileID)
+	if symbols == nil {
+		return
+	}
+	if symbols.goSymbols != nil {
+		if info, ok := symbols.goSymbols.Lookup(uint64(sf.Address)); ok {
+			sf.Function = info.FunctionName
+			sf.Offset = uint64(sf.Address) - info.FunctionStart
+			sf.SourceFile = info.FileName
+			sf.SourceLine = info.Line
+			return
+		}
+	}
+	if symbols.symbols != nil {
+		name, offset, ok := symbols.symbols.LookupByAddress(libpf.SymbolValue(sf.Address))
+		if ok {
+			sf.Function = string(name)
+			sf.Offset = uint64(offset)
+		}
+	}
+}
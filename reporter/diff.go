@@ -0,0 +1,164 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+// ErrCaptureInProgress is returned if a differential profile is requested while another
+// one is captured.
+var ErrCaptureInProgress = errors.New("another differential profile is being captured")
+
+// sampleCapture collects a copy of the samples reported while a differential profile is
+// captured, so that the regular reporting of the samples is not affected.
+type sampleCapture struct {
+	// active is set while samples are captured. It avoids taking mu for each sample
+	// otherwise.
+	active atomic.Bool
+
+	mu      sync.Mutex
+	samples map[sampleKey]sample
+}
+
+// start starts capturing samples.
+func (c *sampleCapture) start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples != nil {
+		return ErrCaptureInProgress
+	}
+	c.samples = make(map[sampleKey]sample)
+	c.active.Store(true)
+	return nil
+}
+
+// take returns the samples captured so far and continues capturing into a new set.
+func (c *sampleCapture) take() map[sampleKey]sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	samples := c.samples
+	c.samples = make(map[sampleKey]sample)
+	return samples
+}
+
+// stop stops capturing samples and drops the samples captured since the last take.
+func (c *sampleCapture) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active.Store(false)
+	c.samples = nil
+}
+
+// add records a sample of key with meta if samples are captured.
+func (c *sampleCapture) add(key sampleKey, meta *TraceEventMeta) {
+	if !c.active.Load() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.samples == nil {
+		return
+	}
+	v := c.samples[key]
+	v.count += uint32(meta.Count)
+	v.weight += meta.Weight
+	v.timestamps = append(v.timestamps, uint64(meta.Timestamp))
+	v.labels = meta.Labels
+	c.samples[key] = v
+}
+
+// WriteDiffProfile captures the samples of two consecutive intervals of length interval,
+// A and B, and writes a differential profile of them as gzip compressed pprof profile to
+// w. The samples of the profile have the sample counts of A and B, and the difference of
+// B to A as last value, which pprof shows by default. Stacks that grew from A to B have
+// positive differences and stacks that shrank have negative ones. The samples are still
+// reported as usual.
+func (r *OTLPReporter) WriteDiffProfile(ctx context.Context, interval time.Duration,
+	w io.Writer) error {
+	if err := r.capture.start(); err != nil {
+		return err
+	}
+	defer r.capture.stop()
+
+	start := time.Now()
+	var intervals [2]map[sampleKey]sample
+	for i := range intervals {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		intervals[i] = r.capture.take()
+	}
+
+	profile := r.diffProfile(intervals[0], intervals[1], start, time.Since(start))
+	data, err := proto.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %v", err)
+	}
+	zw := gzip.NewWriter(w)
+	if _, err = zw.Write(data); err != nil {
+		return fmt.Errorf("failed to compress profile: %v", err)
+	}
+	return zw.Close()
+}
+
+// diffProfile returns the differential pprof profile of the samples a and b, which were
+// collected in the time span of duration from start.
+func (r *OTLPReporter) diffProfile(a, b map[sampleKey]sample, start time.Time,
+	duration time.Duration) *pprofextended.Profile {
+	// The stacks of both intervals are put into one profile, so that they share the
+	// locations and functions.
+	keys := make([]sampleKey, 0, len(a)+len(b))
+	union := make(map[sampleKey]sample, len(a)+len(b))
+	for _, samples := range []map[sampleKey]sample{a, b} {
+		for key, v := range samples {
+			// Samples whose traces are unknown can not be shown.
+			if _, ok := r.traces.Peek(key.traceHash); !ok {
+				continue
+			}
+			u, ok := union[key]
+			if !ok {
+				keys = append(keys, key)
+			}
+			u.count += v.count
+			u.timestamps = append(u.timestamps, v.timestamps...)
+			u.labels = v.labels
+			union[key] = u
+		}
+	}
+
+	profile, _, _ := r.buildProfileOf(keys, union, cpuSamplesType)
+	out := toPprof(profile, start, duration, 0)
+	addString := func(s string) int64 {
+		out.StringTable = append(out.StringTable, s)
+		return int64(len(out.StringTable) - 1)
+	}
+	count := addString("count")
+	out.SampleType = []*pprofextended.ValueType{
+		{Type: addString("samples_a"), Unit: count},
+		{Type: addString("samples_b"), Unit: count},
+		{Type: addString("samples_diff"), Unit: count},
+	}
+	for i, key := range keys {
+		countA, countB := int64(a[key].count), int64(b[key].count)
+		out.Sample[i].Value = []int64{countA, countB, countB - countA}
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+func TestDiffProfile(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+
+	grown, shrunk := libpf.NewTraceHash(1, 2), libpf.NewTraceHash(3, 4)
+	for i, hash := range []libpf.TraceHash{grown, shrunk} {
+		trace := &libpf.Trace{Hash: hash}
+		trace.AppendFrame(libpf.KernelFrame, libpf.NewFileID(5, 6), libpf.AddressOrLineno(i))
+		r.ReportFramesForTrace(trace)
+	}
+	report := func(hash libpf.TraceHash, n int) {
+		for i := 0; i < n; i++ {
+			r.ReportCountForTrace(hash, &TraceEventMeta{
+				Timestamp: 1700000000,
+				Count:     1,
+				Comm:      "worker",
+				EventSet:  libpf.PrimaryEventSet,
+			})
+		}
+	}
+
+	// Samples reported while no profile is captured are not part of it.
+	report(grown, 5)
+	require.NoError(t, r.capture.start())
+	report(grown, 1)
+	report(shrunk, 3)
+	a := r.capture.take()
+	report(grown, 4)
+	report(shrunk, 1)
+	b := r.capture.take()
+	assert.ErrorIs(t, r.capture.start(), ErrCaptureInProgress)
+	r.capture.stop()
+
+	profile := r.diffProfile(a, b, time.Unix(1700000000, 0), 2*time.Second)
+	types := make([]string, 0, len(profile.SampleType))
+	for _, st := range profile.SampleType {
+		types = append(types, profile.StringTable[st.Type])
+	}
+	assert.Equal(t, []string{"samples_a", "samples_b", "samples_diff"}, types)
+
+	values := make(map[uint64][]int64)
+	for _, s := range profile.Sample {
+		require.Len(t, s.LocationIndex, 1)
+		loc := profile.Location[s.LocationIndex[0]-1]
+		values[loc.Address] = s.Value
+	}
+	assert.Equal(t, map[uint64][]int64{
+		0: {1, 4, 3},
+		1: {3, 1, -2},
+	}, values)
+
+	// The samples are still reported as usual.
+	profiles, _, _ := r.getProfiles()
+	require.Len(t, profiles, 1)
+	var count int
+	for _, s := range profiles[0].Sample {
+		count += len(s.Timestamps)
+	}
+	assert.Equal(t, 14, count)
+}
+
+func TestWriteDiffProfile(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteDiffProfile(context.Background(), time.Millisecond, &buf))
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	profile := &pprofextended.Profile{}
+	require.NoError(t, proto.Unmarshal(data, profile))
+	assert.Len(t, profile.SampleType, 3)
+	assert.Empty(t, profile.Sample)
+
+	// The capture is stopped once the profile is written.
+	assert.False(t, r.capture.active.Load())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, r.WriteDiffProfile(ctx, time.Hour, &buf), context.Canceled)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exportStallIntervals is the number of report intervals without a successful export after
+// which a warning is logged.
+const exportStallIntervals = 5
+
+// exportTracker keeps track of the last successful export of a reporter. This allows
+// detecting a reporter that keeps running but fails to deliver any data.
+type exportTracker struct {
+	// startTime is the time the tracking started, used as reference until the first
+	// successful export.
+	startTime time.Time
+
+	// lastExport holds the time of the last successful export in Unix nanoseconds, or
+	// 0 if there was none yet.
+	lastExport atomic.Int64
+
+	// stalled is set while the warning about missing exports has been logged.
+	stalled atomic.Bool
+}
+
+func newExportTracker(now time.Time) *exportTracker {
+	return &exportTracker{startTime: now}
+}
+
+// succeeded records a successful export at the given time.
+func (e *exportTracker) succeeded(now time.Time) {
+	e.lastExport.Store(now.UnixNano())
+	if e.stalled.Swap(false) {
+		log.Infof("Exporting profiling data succeeded again")
+	}
+}
+
+// lastExportTime returns the time of the last successful export. The zero time is
+// returned if there was none yet.
+func (e *exportTracker) lastExportTime() time.Time {
+	if ns := e.lastExport.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// lastExportUnix returns the Unix timestamp of the last successful export, or 0 if there
+// was none yet.
+func (e *exportTracker) lastExportUnix() int64 {
+	if last := e.lastExportTime(); !last.IsZero() {
+		return last.Unix()
+	}
+	return 0
+}
+
+// check logs a warning if there was no successful export within the last
+// exportStallIntervals report intervals. It returns true if the exports are stalled.
+func (e *exportTracker) check(now time.Time, interval time.Duration) bool {
+	last := e.lastExportTime()
+	if last.IsZero() {
+		last = e.startTime
+	}
+	if now.Sub(last) < exportStallIntervals*interval {
+		return false
+	}
+	if !e.stalled.Swap(true) {
+		if e.lastExport.Load() == 0 {
+			log.Warnf("No profiling data has been exported successfully since the start "+
+				"%v ago", now.Sub(last).Truncate(time.Second))
+		} else {
+			log.Warnf("No profiling data has been exported successfully since %v (%v ago)",
+				last.Format(time.RFC3339), now.Sub(last).Truncate(time.Second))
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportTracker(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	interval := 5 * time.Second
+	e := newExportTracker(start)
+
+	assert.Equal(t, int64(0), e.lastExportUnix())
+	assert.False(t, e.check(start.Add(interval), interval))
+	// No export since the start
+	assert.True(t, e.check(start.Add(exportStallIntervals*interval), interval))
+
+	e.succeeded(start.Add(42 * time.Second))
+	assert.Equal(t, start.Unix()+42, e.lastExportUnix())
+	assert.False(t, e.stalled.Load())
+	assert.False(t, e.check(start.Add(50*time.Second), interval))
+
+	// No export for exportStallIntervals intervals after the last success
+	stalledAt := start.Add(42*time.Second + exportStallIntervals*interval)
+	assert.True(t, e.check(stalledAt, interval))
+	assert.True(t, e.check(stalledAt.Add(interval), interval))
+	assert.True(t, e.stalled.Load())
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// filterLabelPrefix is the prefix of the filter keys that match the value of a label.
+const filterLabelPrefix = "label."
+
+// filterCondition matches the value of key of the samples against a shell pattern.
+type filterCondition struct {
+	// key is the property of the samples the condition applies to, e.g. "pod".
+	key string
+	// pattern is a shell pattern as understood by path.Match.
+	pattern string
+	// negate is set if the value must not match pattern.
+	negate bool
+}
+
+// SampleFilter selects the samples that Multi forwards to one of its reporters, by the
+// process and container they were taken of, e.g. to send only the profiles of production
+// pods to a central backend. A nil SampleFilter selects all samples.
+type SampleFilter struct {
+	conditions []filterCondition
+}
+
+// NewSampleFilter creates a SampleFilter from conditions in the format KEY=PATTERN or
+// KEY!=PATTERN, where PATTERN is a shell pattern as understood by path.Match. A sample is
+// selected if it satisfies all conditions. The keys are comm, executable, pid, cgroup (the
+// path of the cgroup of the process), pod, container, containerized (true or false) and
+// label.NAME for the value of the label NAME. Missing values are empty. Without conditions,
+// nil is returned.
+func NewSampleFilter(conditions []string) (*SampleFilter, error) {
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+	f := &SampleFilter{conditions: make([]filterCondition, 0, len(conditions))}
+	for _, condition := range conditions {
+		key, pattern, ok := strings.Cut(condition, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid sample filter '%s', expected KEY=PATTERN",
+				condition)
+		}
+		key, negate := strings.CutSuffix(key, "!")
+		switch key {
+		case "comm", "executable", "pid", "cgroup", "pod", "container", "containerized":
+		default:
+			if name, isLabel := strings.CutPrefix(key, filterLabelPrefix); !isLabel ||
+				name == "" {
+				return nil, fmt.Errorf("invalid key '%s' of sample filter '%s'", key,
+					condition)
+			}
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern of sample filter '%s': %v", condition,
+				err)
+		}
+		f.conditions = append(f.conditions, filterCondition{
+			key:     key,
+			pattern: pattern,
+			negate:  negate,
+		})
+	}
+	return f, nil
+}
+
+// CheckKeys returns an error if a condition of f applies to a key other than keys, for the
+// sinks that only know some of the properties of the samples.
+func (f *SampleFilter) CheckKeys(keys ...string) error {
+	if f == nil {
+		return nil
+	}
+	for i := range f.conditions {
+		if key := f.conditions[i].key; !slices.Contains(keys, key) {
+			return fmt.Errorf("sample filter key '%s' is not supported, supported are %s",
+				key, strings.Join(keys, ", "))
+		}
+	}
+	return nil
+}
+
+// value returns the value of key of the samples described by meta.
+func (c *filterCondition) value(meta *TraceEventMeta) string {
+	switch c.key {
+	case "comm":
+		return meta.Comm
+	case "executable":
+		return meta.Executable
+	case "pid":
+		return strconv.Itoa(int(meta.PID))
+	case "cgroup":
+		return meta.Cgroup
+	case "pod":
+		return meta.PodName
+	case "container":
+		return meta.ContainerName
+	case "containerized":
+		return strconv.FormatBool(meta.Containerized)
+	default:
+		return meta.Labels[strings.TrimPrefix(c.key, filterLabelPrefix)]
+	}
+}
+
+// Selects reports whether the samples described by meta satisfy all conditions of f.
+func (f *SampleFilter) Selects(meta *TraceEventMeta) bool {
+	if f == nil {
+		return true
+	}
+	for i := range f.conditions {
+		c := &f.conditions[i]
+		if matched, _ := path.Match(c.pattern, c.value(meta)); matched == c.negate {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleFilter(t *testing.T) {
+	meta := &TraceEventMeta{
+		Comm:          "worker",
+		Executable:    "python3.12",
+		PodName:       "prod-billing-7d9f",
+		ContainerName: "billing",
+		Containerized: true,
+		PID:           4242,
+		Cgroup:        "/kubepods.slice/kubepods-pod7d9f.slice/cri-containerd-0a1b.scope",
+		Labels:        map[string]string{"env": "production"},
+	}
+
+	tests := map[string]struct {
+		conditions []string
+		selected   bool
+		fail       bool
+	}{
+		"no conditions": {
+			selected: true,
+		},
+		"pod pattern": {
+			conditions: []string{"pod=prod-*"},
+			selected:   true,
+		},
+		"all conditions": {
+			conditions: []string{"pod=prod-*", "executable=python*", "container=other"},
+		},
+		"negated": {
+			conditions: []string{"comm!=worker"},
+		},
+		"label": {
+			conditions: []string{"label.env=production", "containerized=true"},
+			selected:   true,
+		},
+		"missing label": {
+			conditions: []string{"label.team!=?*"},
+			selected:   true,
+		},
+		"process": {
+			conditions: []string{"pid=42*", "cgroup=/kubepods.slice/*/*"},
+			selected:   true,
+		},
+		"other process": {
+			conditions: []string{"pid=42"},
+		},
+		"missing pattern": {
+			conditions: []string{"pod"},
+			fail:       true,
+		},
+		"unknown key": {
+			conditions: []string{"namespace=x"},
+			fail:       true,
+		},
+		"empty label name": {
+			conditions: []string{"label.=x"},
+			fail:       true,
+		},
+		"invalid pattern": {
+			conditions: []string{"pod=prod-["},
+			fail:       true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			filter, err := NewSampleFilter(test.conditions)
+			if test.fail {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.selected, filter.Selects(meta))
+		})
+	}
+}
+
+func TestSampleFilterCheckKeys(t *testing.T) {
+	var none *SampleFilter
+	assert.NoError(t, none.CheckKeys("comm"))
+
+	filter, err := NewSampleFilter([]string{"comm=worker", "pid!=1"})
+	require.NoError(t, err)
+	assert.NoError(t, filter.CheckKeys("comm", "pid"))
+	assert.ErrorContains(t, filter.CheckKeys("comm"), "key 'pid' is not supported")
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+// Layout of the flame graph SVGs, following the defaults of flamegraph.pl.
+const (
+	flameGraphWidth       = 1200
+	flameGraphFrameHeight = 16
+	flameGraphFontSize    = 12
+	flameGraphPadTop      = 50
+	flameGraphPadBottom   = 20
+	flameGraphPadSide     = 10
+	// flameGraphMinWidth is the width in pixels below which frames are left out.
+	flameGraphMinWidth = 0.1
+	// flameGraphCharWidth is the approximate width of a character relative to the font size.
+	flameGraphCharWidth = 0.59
+)
+
+// flameNode is a frame of a flame graph, with the number of samples it is on the stack of.
+type flameNode struct {
+	name      string
+	frameType string
+	value     int64
+	children  map[flameNodeKey]*flameNode
+}
+
+// flameNodeKey identifies the children of a flameNode.
+type flameNodeKey struct {
+	name      string
+	frameType string
+}
+
+// child returns the child of n with name and frameType, which is added if needed.
+func (n *flameNode) child(name, frameType string) *flameNode {
+	key := flameNodeKey{name: name, frameType: frameType}
+	c, ok := n.children[key]
+	if !ok {
+		c = &flameNode{name: name, frameType: frameType}
+		if n.children == nil {
+			n.children = make(map[flameNodeKey]*flameNode)
+		}
+		n.children[key] = c
+	}
+	return c
+}
+
+// sortedChildren returns the children of n ordered by name, like flamegraph.pl does.
+func (n *flameNode) sortedChildren() []*flameNode {
+	children := make([]*flameNode, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].name != children[j].name {
+			return children[i].name < children[j].name
+		}
+		return children[i].frameType < children[j].frameType
+	})
+	return children
+}
+
+// depth returns the number of frames of the deepest stack below n.
+func (n *flameNode) depth() int {
+	maxDepth := 0
+	for _, c := range n.children {
+		maxDepth = max(maxDepth, c.depth()+1)
+	}
+	return maxDepth
+}
+
+// flameGraphFrameName returns the name a location of profile is shown with. Locations
+// without source information, e.g. native frames that are symbolized in the backend, are
+// shown by the file name of their executable and their address.
+func flameGraphFrameName(profile *pprofextended.Profile, loc *pprofextended.Location) string {
+	for _, line := range loc.Line {
+		if name := profile.StringTable[profile.Function[line.FunctionIndex].Name]; name != "" {
+			return name
+		}
+	}
+	fileName := "UNKNOWN"
+	if loc.MappingIndex < uint64(len(profile.Mapping)) {
+		fileName = filepath.Base(profile.StringTable[profile.Mapping[loc.MappingIndex].Filename])
+	}
+	return fmt.Sprintf("%s+0x%x", fileName, loc.Address)
+}
+
+// flameGraphTree aggregates the stacks of the samples of profile, as returned by
+// getProfile, into a tree whose root stands for all samples.
+func flameGraphTree(profile *pprofextended.Profile) *flameNode {
+	root := &flameNode{name: "all"}
+	for _, sample := range profile.Sample {
+		count := int64(len(sample.Timestamps))
+		root.value += count
+		node := root
+		// The locations are ordered from the innermost to the outermost frame.
+		for i := sample.LocationsLength; i > 0; i-- {
+			idx := profile.LocationIndices[sample.LocationsStartIndex+i-1]
+			loc := profile.Location[idx]
+			node = node.child(flameGraphFrameName(profile, loc),
+				profile.StringTable[loc.TypeIndex])
+			node.value += count
+		}
+	}
+	return root
+}
+
+// flameGraphColor returns the fill color of a frame, by its type as in the Java palette of
+// flamegraph.pl: kernel frames are orange, native frames red and interpreted frames green.
+// The shade is derived from the name, so that a function keeps its color across graphs.
+func flameGraphColor(name, frameType string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	v := int(h.Sum32() % 64)
+	switch frameType {
+	case "":
+		// The root of all samples.
+		return "rgb(200,200,200)"
+	case rootFrameType:
+		return fmt.Sprintf("rgb(%d,%d,%d)", 160+v/2, 160+v/2, 190+v/2)
+	case libpf.KernelFrame.String():
+		return fmt.Sprintf("rgb(%d,%d,%d)", 200+v/2, 110+v, 0)
+	case libpf.NativeFrame.String():
+		return fmt.Sprintf("rgb(%d,%d,%d)", 200+v/2, 50+v, 50+v)
+	default:
+		return fmt.Sprintf("rgb(%d,%d,%d)", 50+v, 180+v, 50+v/2)
+	}
+}
+
+// flameGraphLabel returns name shortened to fit into a frame of width pixels, or an empty
+// string if not even a few characters fit.
+func flameGraphLabel(name string, width float64) string {
+	chars := int(width / (flameGraphFontSize * flameGraphCharWidth))
+	if chars < 3 {
+		return ""
+	}
+	runes := []rune(name)
+	if len(runes) <= chars {
+		return name
+	}
+	return string(runes[:chars-2]) + ".."
+}
+
+// flameGraphScript implements zooming into a frame by clicking it, and resetting the zoom
+// by clicking the background or the reset link. The frames carry their unzoomed position
+// and depth as data attributes. It is a format string for the width of the graph, its side
+// padding and the width of a character.
+const flameGraphScript = `
+var frames, reset, width = %d, pad = %d, charWidth = %g;
+function init() {
+	frames = document.getElementsByClassName("frame");
+	reset = document.getElementById("reset");
+	for (var i = 0; i < frames.length; i++) {
+		frames[i].addEventListener("click", function(e) { zoom(this); e.stopPropagation(); });
+	}
+	document.getElementById("background").addEventListener("click", unzoom);
+	reset.addEventListener("click", unzoom);
+}
+function place(g, x, w, opacity) {
+	var rect = g.getElementsByTagName("rect")[0], text = g.getElementsByTagName("text")[0];
+	var name = g.getAttribute("data-name"), chars = Math.floor(w / charWidth);
+	g.style.display = "";
+	g.style.opacity = opacity;
+	rect.setAttribute("x", x);
+	rect.setAttribute("width", w);
+	text.setAttribute("x", x + 3);
+	text.textContent = chars < 3 ? "" : (name.length <= chars ? name :
+		name.substring(0, chars - 2) + "..");
+}
+function zoom(target) {
+	var x0 = +target.getAttribute("data-x"), w0 = +target.getAttribute("data-w");
+	var d0 = +target.getAttribute("data-depth"), scale = (width - 2 * pad) / w0;
+	for (var i = 0; i < frames.length; i++) {
+		var g = frames[i], x = +g.getAttribute("data-x"), w = +g.getAttribute("data-w");
+		var d = +g.getAttribute("data-depth");
+		if (d < d0 && x <= x0 + 1e-6 && x + w >= x0 + w0 - 1e-6) {
+			place(g, pad, width - 2 * pad, 0.5);
+		} else if (d >= d0 && x >= x0 - 1e-6 && x + w <= x0 + w0 + 1e-6) {
+			place(g, pad + (x - x0) * scale, w * scale, 1);
+		} else {
+			g.style.display = "none";
+		}
+	}
+	reset.style.display = "";
+}
+function unzoom() {
+	for (var i = 0; i < frames.length; i++) {
+		var g = frames[i];
+		place(g, +g.getAttribute("data-x"), +g.getAttribute("data-w"), 1);
+	}
+	reset.style.display = "none";
+}
+`
+
+// writeFlameGraph writes the samples of profile as self-contained, interactive flame graph
+// SVG with the given title to w. The outermost frames are at the bottom, and the width of
+// each frame is proportional to the number of samples it is on the stack of.
+func writeFlameGraph(w io.Writer, profile *pprofextended.Profile, title string) error {
+	root := flameGraphTree(profile)
+	depth := root.depth()
+	height := flameGraphPadTop + (depth+1)*flameGraphFrameHeight + flameGraphPadBottom
+	charWidth := flameGraphFontSize * flameGraphCharWidth
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" onload="init()" viewBox="0 0 %d %d" `+
+		`xmlns="http://www.w3.org/2000/svg">
+<style type="text/css">
+text { font-family: Verdana, sans-serif; font-size: %dpx; fill: rgb(0,0,0); }
+.frame { cursor: pointer; }
+.frame:hover rect { stroke: rgb(0,0,0); stroke-width: 0.5; }
+#title { font-size: 17px; }
+#reset { cursor: pointer; }
+</style>
+<script type="text/ecmascript"><![CDATA[%s]]></script>
+<rect id="background" x="0" y="0" width="100%%" height="100%%" fill="rgb(245,245,235)"/>
+<text id="title" x="%d" y="24" text-anchor="middle">%s</text>
+<text id="reset" x="%d" y="24" style="display: none">Reset Zoom</text>
+`, flameGraphWidth, height, flameGraphWidth, height, flameGraphFontSize,
+		fmt.Sprintf(flameGraphScript, flameGraphWidth, flameGraphPadSide, charWidth),
+		flameGraphWidth/2, html.EscapeString(title), flameGraphPadSide)
+
+	if root.value != 0 {
+		scale := float64(flameGraphWidth-2*flameGraphPadSide) / float64(root.value)
+		writeFlameNode(bw, root, root.value, flameGraphPadSide, 0, height, scale)
+	}
+
+	fmt.Fprint(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// writeFlameNode writes the frame of node at the horizontal position x and the given depth
+// to w, followed by its children. total is the number of samples of the graph, and scale
+// the width of each sample in pixels.
+func writeFlameNode(w io.Writer, node *flameNode, total int64, x float64, depth, height int,
+	scale float64) {
+	width := float64(node.value) * scale
+	if width < flameGraphMinWidth {
+		return
+	}
+	y := height - flameGraphPadBottom - (depth+1)*flameGraphFrameHeight
+	name := html.EscapeString(node.name)
+	samples := "samples"
+	if node.value == 1 {
+		samples = "sample"
+	}
+	fmt.Fprintf(w, `<g class="frame" data-name="%s" data-x="%.2f" data-w="%.2f" `+
+		`data-depth="%d"><title>%s (%d %s, %.2f%%)</title>`+
+		`<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="%s" rx="2" ry="2"/>`+
+		`<text x="%.2f" y="%d">%s</text></g>`+"\n",
+		name, x, width, depth, name, node.value, samples,
+		float64(node.value)*100/float64(total),
+		x, y, width, flameGraphFrameHeight-1, flameGraphColor(node.name, node.frameType),
+		x+3, y+flameGraphFrameHeight-4, html.EscapeString(flameGraphLabel(node.name, width)))
+
+	for _, child := range node.sortedChildren() {
+		writeFlameNode(w, child, total, x, depth+1, height, scale)
+		x += float64(child.value) * scale
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elastic/otel-profiling-agent/debug/log"
+	"github.com/elastic/otel-profiling-agent/hostmetadata/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// FlameGraphReporter collects profiling data like the OTLPReporter, but instead of sending
+// it to a backend, it writes the samples of each reporting interval as flame graph SVG to a
+// local directory. This is meant for quick local inspection without a backend, e.g. on the
+// laptop of a developer. The SVGs can be opened with a web browser.
+//
+// Native frames are not symbolized by the agent, so they show the executable and address.
+type FlameGraphReporter struct {
+	*OTLPReporter
+
+	// dir is the directory the flame graphs are written to.
+	dir string
+}
+
+// StartFlameGraph sets up and manages the writing of flame graphs of the collected data to
+// the directory c.FlameGraphDir, which is created if needed.
+func StartFlameGraph(mainCtx context.Context, c *Config) (*FlameGraphReporter, error) {
+	if err := os.MkdirAll(c.FlameGraphDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create flame graph directory: %v", err)
+	}
+
+	r, err := NewOTLPReporter()
+	if err != nil {
+		return nil, err
+	}
+	if r.trimmer, err = newFrameTrimmer(c.TrimFrames); err != nil {
+		close(r.stopSignal)
+		return nil, err
+	}
+	r.rootFrame = c.RootFrame
+	r.lostSamplesFrame = c.LostSamplesFrame
+	r.eventSetTypes = c.EventSetTypes
+
+	fr := &FlameGraphReporter{
+		OTLPReporter: r,
+		dir:          c.FlameGraphDir,
+	}
+
+	ctx, cancelReporting := context.WithCancel(mainCtx)
+	go func() {
+		defer cancelReporting()
+		tick := time.NewTicker(c.Times.ReportInterval())
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopSignal:
+				return
+			case <-tick.C:
+				if _, err := fr.export(); err != nil {
+					log.Errorf("Failed to write flame graph: %v", err)
+				}
+				tick.Reset(libpf.AddJitter(c.Times.ReportInterval(), 0.2))
+			}
+		}
+	}()
+
+	return fr, nil
+}
+
+// Flush immediately writes the flame graphs of the samples collected so far instead of
+// waiting for the reporting interval, and returns the number of samples written.
+func (r *FlameGraphReporter) Flush(_ context.Context) (int, error) {
+	return r.export()
+}
+
+// export writes a flame graph of the samples collected so far for each event set, and
+// returns the number of samples written.
+func (r *FlameGraphReporter) export() (int, error) {
+	r.exportMu.Lock()
+	defer r.exportMu.Unlock()
+
+	eventSetProfiles, startTS, endTS := r.getProfiles()
+	if len(eventSetProfiles) == 0 {
+		log.Debugf("Skip writing of flame graph with no samples")
+		r.exports.succeeded(time.Now())
+		return 0, nil
+	}
+
+	now := time.Now()
+	start := time.Unix(int64(startTS), 0)
+	until := time.Unix(int64(endTS), 0)
+	hostname, _ := r.hostmetadata.Get(host.KeyHostname)
+	numSamples := 0
+	for _, profile := range eventSetProfiles {
+		eventType := ""
+		if len(r.eventSetTypes) > 1 && len(profile.SampleType) > 0 {
+			eventType = profile.StringTable[profile.SampleType[0].Type]
+		}
+		samples := 0
+		for _, sample := range profile.Sample {
+			samples += len(sample.Timestamps)
+		}
+
+		title := flameGraphTitle(hostname, eventType, start, until, samples)
+		path := filepath.Join(r.dir, flameGraphFileName(now, eventType))
+		if err := r.write(path, func(f *os.File) error {
+			return writeFlameGraph(f, profile, title)
+		}); err != nil {
+			return numSamples, err
+		}
+		log.Infof("Wrote flame graph of %d samples to %s", samples, path)
+		numSamples += samples
+	}
+	r.exports.succeeded(now)
+	return numSamples, nil
+}
+
+// write creates the file at path with the content written by writeContent. The content is
+// written to a temporary file that is renamed once complete, so that incomplete flame
+// graphs are never seen.
+func (r *FlameGraphReporter) write(path string, writeContent func(*os.File) error) error {
+	f, err := os.CreateTemp(r.dir, ".flamegraph-*")
+	if err != nil {
+		return fmt.Errorf("failed to create flame graph: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err = writeContent(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write flame graph: %v", err)
+	}
+	if err = f.Chmod(0o644); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write flame graph: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to write flame graph: %v", err)
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write flame graph: %v", err)
+	}
+	return nil
+}
+
+// flameGraphFileName returns the name of the flame graph written at now for the samples
+// of eventType, which is empty if there is only one event set.
+func flameGraphFileName(now time.Time, eventType string) string {
+	name := "flamegraph-" + now.Format("20060102-150405.000")
+	if eventType != "" {
+		name += "-" + strings.Map(func(r rune) rune {
+			if r == '/' || r == ':' {
+				return '_'
+			}
+			return r
+		}, eventType)
+	}
+	return name + ".svg"
+}
+
+// flameGraphTitle returns the title of a flame graph of samples from start to until.
+func flameGraphTitle(hostname, eventType string, start, until time.Time, samples int) string {
+	title := "Flame Graph"
+	if eventType != "" {
+		title += " of " + eventType
+	}
+	if hostname != "" {
+		title += " on " + hostname
+	}
+	return fmt.Sprintf("%s: %d samples from %s to %s", title, samples,
+		start.Format(time.DateTime), until.Format(time.DateTime))
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// reportFlameGraphTraces reports two traces that share their outermost frames.
+func reportFlameGraphTraces(r Reporter) {
+	pythonID, nativeID := libpf.NewFileID(1, 1), libpf.NewFileID(2, 2)
+	r.ExecutableMetadata(context.Background(), nativeID, "/usr/lib/libc.so.6", "", 0, 0,
+		pfelf.AddressMapper{})
+	r.FrameMetadata(pythonID, 1, 10, 0, "main", "app.py")
+	r.FrameMetadata(pythonID, 2, 20, 0, "handle<Request>", "app.py")
+	r.FrameMetadata(pythonID, 3, 30, 0, "idle", "app.py")
+
+	busy := &libpf.Trace{Hash: libpf.NewTraceHash(1, 1)}
+	busy.AppendFrame(libpf.NativeFrame, nativeID, 0x1234)
+	busy.AppendFrame(libpf.PythonFrame, pythonID, 2)
+	busy.AppendFrame(libpf.PythonFrame, pythonID, 1)
+	idle := &libpf.Trace{Hash: libpf.NewTraceHash(2, 2)}
+	idle.AppendFrame(libpf.PythonFrame, pythonID, 3)
+	idle.AppendFrame(libpf.PythonFrame, pythonID, 1)
+	for trace, count := range map[*libpf.Trace]uint16{busy: 3, idle: 1} {
+		r.ReportFramesForTrace(trace)
+		for i := uint16(0); i < count; i++ {
+			r.ReportCountForTrace(trace.Hash, &TraceEventMeta{
+				Timestamp: 1700000000,
+				Count:     1,
+				Comm:      "python3",
+				EventSet:  libpf.PrimaryEventSet,
+			})
+		}
+	}
+}
+
+func TestFlameGraphTree(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+	reportFlameGraphTraces(r)
+	profile, _, _ := r.getProfile()
+
+	// stacks maps the stacks of the tree, from the outermost frame, to their values.
+	stacks := make(map[string]int64)
+	var walk func(node *flameNode, stack string)
+	walk = func(node *flameNode, stack string) {
+		stack += node.name + ";"
+		stacks[stack] = node.value
+		for _, child := range node.sortedChildren() {
+			walk(child, stack)
+		}
+	}
+	root := flameGraphTree(profile)
+	walk(root, "")
+	assert.Equal(t, map[string]int64{
+		"all;":                      4,
+		"all;main;":                 4,
+		"all;main;handle<Request>;": 3,
+		"all;main;handle<Request>;libc.so.6+0x1234;": 3,
+		"all;main;idle;": 1,
+	}, stacks)
+	assert.Equal(t, 3, root.depth())
+}
+
+func TestFlameGraphLabel(t *testing.T) {
+	assert.Equal(t, "", flameGraphLabel("main", 10))
+	assert.Equal(t, "main", flameGraphLabel("main", 100))
+	assert.Equal(t, "handle..", flameGraphLabel("handle_request", 60))
+}
+
+func TestFlameGraphReporterFlush(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	fr := &FlameGraphReporter{OTLPReporter: r, dir: dir}
+	reportFlameGraphTraces(fr)
+
+	n, err := fr.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.True(t, strings.HasPrefix(files[0].Name(), "flamegraph-"))
+	assert.Equal(t, ".svg", filepath.Ext(files[0].Name()))
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.NoError(t, err)
+
+	// The SVG has to be well-formed XML, also with names that need escaping.
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	for {
+		_, err = decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+	}
+	svg := string(data)
+	assert.Contains(t, svg, "Flame Graph: 4 samples")
+	assert.Contains(t, svg, "<title>handle&lt;Request&gt; (3 samples, 75.00%)</title>")
+	assert.Contains(t, svg, "<title>libc.so.6+0x1234 (3 samples, 75.00%)</title>")
+	assert.Contains(t, svg, "<title>idle (1 sample, 25.00%)</title>")
+
+	// Without new samples, no flame graph is written.
+	n, err = fr.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestFlameGraphFileName(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 45, 123000000, time.UTC)
+	assert.Equal(t, "flamegraph-20240301-123045.123.svg", flameGraphFileName(now, ""))
+	assert.Equal(t, "flamegraph-20240301-123045.123-sched_sched_switch.svg",
+		flameGraphFileName(now, "sched:sched_switch"))
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// frameTrimmer collapses runs of consecutive native frames of libraries matching one of
+// a set of patterns, e.g. the frames of libc or of an interpreter, into a single
+// placeholder frame. This reduces the noise of framework and runtime frames in profiles.
+type frameTrimmer struct {
+	// patterns holds shell patterns as understood by path.Match, that are matched against
+	// the base name of the executable of a native frame.
+	patterns []string
+}
+
+// collapsedRun describes consecutive frames that are replaced by a placeholder frame.
+type collapsedRun struct {
+	// last is the index of the last frame that is replaced.
+	last int
+	// placeholder is the function name of the frame replacing the run.
+	placeholder string
+}
+
+// newFrameTrimmer returns a frameTrimmer for the given patterns or nil if no patterns
+// are given.
+func newFrameTrimmer(patterns []string) (*frameTrimmer, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid frame trim pattern '%s': %v", pattern, err)
+		}
+	}
+	return &frameTrimmer{patterns: patterns}, nil
+}
+
+// placeholderName returns the function name of the placeholder frame for the executable
+// fileName, e.g. [libc] for libc.so.6.
+func placeholderName(fileName string) string {
+	name, _, _ := strings.Cut(fileName, ".so")
+	return "[" + name + "]"
+}
+
+// matches reports whether the executable fileName matches one of the patterns.
+func (ft *frameTrimmer) matches(fileName string) bool {
+	for _, pattern := range ft.patterns {
+		if matched, _ := path.Match(pattern, fileName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// collapse returns the runs of frames of trace that are to be replaced by a placeholder,
+// keyed by the index of the first replaced frame. The outermost and innermost frame of
+// each run of matching frames are kept, so that the frames calling into the library and
+// called by it remain attached to a frame of the library. fileName returns the name of
+// the executable with the given FileID, if known.
+func (ft *frameTrimmer) collapse(trace *traceInfo,
+	fileName func(libpf.FileID) (string, bool)) map[int]collapsedRun {
+	var runs map[int]collapsedRun
+
+	for start := 0; start < len(trace.frameTypes); {
+		end := start + 1
+		if trace.frameTypes[start] == libpf.NativeFrame {
+			if name, ok := fileName(trace.files[start]); ok && ft.matches(name) {
+				for end < len(trace.frameTypes) &&
+					trace.frameTypes[end] == libpf.NativeFrame &&
+					trace.files[end] == trace.files[start] {
+					end++
+				}
+				// Runs of up to three frames do not get shorter by collapsing them.
+				if end-start > 3 {
+					if runs == nil {
+						runs = make(map[int]collapsedRun)
+					}
+					runs[start+1] = collapsedRun{
+						last:        end - 2,
+						placeholder: placeholderName(name),
+					}
+				}
+			}
+		}
+		start = end
+	}
+	return runs
+}
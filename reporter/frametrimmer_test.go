@@ -0,0 +1,86 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestNewFrameTrimmer(t *testing.T) {
+	trimmer, err := newFrameTrimmer(nil)
+	require.NoError(t, err)
+	assert.Nil(t, trimmer)
+
+	_, err = newFrameTrimmer([]string{"libc.so*", "[libjvm"})
+	assert.Error(t, err)
+}
+
+func TestPlaceholderName(t *testing.T) {
+	assert.Equal(t, "[libc]", placeholderName("libc.so.6"))
+	assert.Equal(t, "[libpython3.11]", placeholderName("libpython3.11.so.1.0"))
+	assert.Equal(t, "[python3.11]", placeholderName("python3.11"))
+}
+
+func TestFrameTrimmerCollapse(t *testing.T) {
+	libc := libpf.NewFileID(1, 1)
+	app := libpf.NewFileID(2, 2)
+	fileNames := map[libpf.FileID]string{libc: "libc.so.6", app: "app"}
+	fileName := func(fileID libpf.FileID) (string, bool) {
+		name, ok := fileNames[fileID]
+		return name, ok
+	}
+
+	trimmer, err := newFrameTrimmer([]string{"libc.so*"})
+	require.NoError(t, err)
+
+	newTrace := func(files ...libpf.FileID) *traceInfo {
+		trace := &traceInfo{}
+		for _, file := range files {
+			trace.files = append(trace.files, file)
+			trace.linenos = append(trace.linenos, 0)
+			trace.frameTypes = append(trace.frameTypes, libpf.NativeFrame)
+		}
+		return trace
+	}
+
+	tests := map[string]struct {
+		trace    *traceInfo
+		expected map[int]collapsedRun
+	}{
+		"short run": {
+			trace: newTrace(libc, libc, libc, app),
+		},
+		"long run": {
+			trace: newTrace(libc, libc, libc, libc, libc, app, app),
+			expected: map[int]collapsedRun{
+				1: {last: 3, placeholder: "[libc]"},
+			},
+		},
+		"non-matching run": {
+			trace: newTrace(app, app, app, app, app),
+		},
+		"two runs": {
+			trace: newTrace(app, libc, libc, libc, libc, app, libc, libc, libc, libc, libc),
+			expected: map[int]collapsedRun{
+				2: {last: 3, placeholder: "[libc]"},
+				7: {last: 9, placeholder: "[libc]"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, trimmer.collapse(test.trace, fileName))
+		})
+	}
+}
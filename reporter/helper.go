@@ -10,6 +10,7 @@ import (
 	"context"
 	"crypto/tls"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/elastic/otel-profiling-agent/libpf"
@@ -18,17 +19,27 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 // setupGrpcConnection sets up a gRPC connection instrumented with our auth interceptor
 func setupGrpcConnection(parent context.Context, c *Config,
 	statsHandler *statsHandlerImpl) (*grpc.ClientConn, error) {
+	// gRPC metadata keys are lowercase.
+	headers := make([]string, 0, 2*len(c.Headers))
+	for key, value := range c.Headers {
+		headers = append(headers, strings.ToLower(key), value)
+	}
+
 	// authGrpcInterceptor intercepts gRPC operations, adds metadata to each operation and
 	// checks for authentication errors. If an authentication error is encountered, a
 	// process exit is triggered.
 	authGrpcInterceptor := func(ctx context.Context, method string, req, reply any,
 		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if len(headers) != 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, headers...)
+		}
 		err := invoker(ctx, method, req, reply, cc, opts...)
 		if err != nil {
 			if st, ok := status.FromError(err); ok {
@@ -46,7 +57,13 @@ func setupGrpcConnection(parent context.Context, c *Config,
 		return err
 	}
 
+	dialer, err := newProxyDialer(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
 	opts := []grpc.DialOption{grpc.WithBlock(),
+		grpc.WithContextDialer(dialer.DialContext),
 		grpc.WithStatsHandler(statsHandler),
 		grpc.WithUnaryInterceptor(authGrpcInterceptor),
 		grpc.WithDefaultCallOptions(
@@ -8,10 +8,12 @@ package reporter
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 )
 
 // Compile time check to make sure config.Times satisfies the interfaces.
@@ -40,24 +42,93 @@ type Reporter interface {
 	GetMetrics() Metrics
 }
 
+// Flusher is implemented by reporters that can send out the collected data on demand.
+type Flusher interface {
+	// Flush immediately sends out the data collected so far and returns the number of
+	// samples sent.
+	Flush(ctx context.Context) (int, error)
+}
+
+// DiffProfiler is implemented by reporters that can compare the samples of two
+// consecutive intervals.
+type DiffProfiler interface {
+	// WriteDiffProfile captures the samples of two consecutive intervals of length
+	// interval and writes a gzip compressed pprof profile of their difference to w.
+	WriteDiffProfile(ctx context.Context, interval time.Duration, w io.Writer) error
+}
+
+// SampleSubscriber is implemented by reporters that stream the reported samples.
+type SampleSubscriber interface {
+	// Subscribe returns a subscription to the samples reported from now on, which
+	// buffers up to buffer samples.
+	Subscribe(buffer int) *Subscription
+}
+
+// LostSamplesReporter is implemented by reporters that account for the samples that were
+// lost before they reached the agent.
+type LostSamplesReporter interface {
+	// ReportLostSamples reports that count samples of the given CPU were lost, as the
+	// kernel could not write them to the full perf event buffer.
+	ReportLostSamples(cpu int, count uint64)
+}
+
 type TraceReporter interface {
 	// ReportFramesForTrace accepts a trace with the corresponding frames
 	// and caches this information before a periodic reporting to the backend.
 	ReportFramesForTrace(trace *libpf.Trace)
 
-	// ReportCountForTrace accepts a hash of a trace with a corresponding count and
+	// ReportCountForTrace accepts a hash of a trace with the metadata of its samples and
 	// caches this information before a periodic reporting to the backend.
-	ReportCountForTrace(traceHash libpf.TraceHash, timestamp libpf.UnixTime32,
-		count uint16, comm, podName, containerName string)
+	ReportCountForTrace(traceHash libpf.TraceHash, meta *TraceEventMeta)
+}
+
+// TraceEventMeta holds the metadata of the samples of a trace that are reported with
+// ReportCountForTrace.
+type TraceEventMeta struct {
+	// Timestamp is the time the samples were taken at.
+	Timestamp libpf.UnixTime32
+	// Count is the number of samples.
+	Count uint16
+	// Weight is the period of the perf event of the samples, e.g. the nanoseconds of CPU
+	// time they stand for, or 0 if not known.
+	Weight uint64
+	// Comm is the command name of the thread the samples were taken on.
+	Comm string
+	// Executable is the base name of the main executable of the process, or empty if not
+	// known.
+	Executable string
+	// PodName and ContainerName identify the container of the process, if any.
+	PodName       string
+	ContainerName string
+	// Containerized is set if the process runs in a container.
+	Containerized bool
+	// PID is the process the samples were taken of, and Cgroup the path of its cgroup, or
+	// empty if not known.
+	PID    libpf.PID
+	Cgroup string
+	// CoreType is the type of the CPU core the samples were taken on, or empty if not
+	// known.
+	CoreType string
+	// TID is the thread the samples were taken on, or 0 if samples are not grouped by
+	// thread.
+	TID libpf.PID
+	// EventSet is the set of perf events that triggered the sampling.
+	EventSet libpf.EventSet
+	// Labels are the labels the process defined for its samples, if any.
+	Labels map[string]string
 }
 
 type SymbolReporter interface {
 	// ReportFallbackSymbol enqueues a fallback symbol for reporting, for a given frame.
 	ReportFallbackSymbol(frameID libpf.FrameID, symbol string)
 
-	// ExecutableMetadata accepts a fileID with the corresponding filename
-	// and caches this information before a periodic reporting to the backend.
-	ExecutableMetadata(ctx context.Context, fileID libpf.FileID, fileName, buildID string)
+	// ExecutableMetadata accepts a fileID with the corresponding filename, build ID and
+	// the device and inode numbers of the file the executable was loaded from, and caches
+	// this information before a periodic reporting to the backend. The device and inode
+	// numbers are 0 if they are not known. addressMapper converts the addresses of the
+	// frames of the executable to file offsets, and is empty if they are not known.
+	ExecutableMetadata(ctx context.Context, fileID libpf.FileID, fileName, buildID string,
+		device, inode uint64, addressMapper pfelf.AddressMapper)
 
 	// FrameMetadata accepts metadata associated with a frame and caches this information before
 	// a periodic reporting to the backend.
@@ -193,6 +193,15 @@ type Metrics struct {
 	RPCBytesInCount               int64
 	WireBytesOutCount             int64
 	WireBytesInCount              int64
+	// LastExportTimestamp is the Unix timestamp of the last successful export, or 0 if
+	// there was none yet.
+	LastExportTimestamp int64
+	// LiveSamplesDropped is the number of samples that were not streamed to subscriptions,
+	// as their consumers did not keep up.
+	LiveSamplesDropped int64
+	// LostSamples is the number of samples that were lost in the kernel, as the perf event
+	// buffer was full, and are thus missing from the exported profiles.
+	LostSamples int64
 }
 
 func (r *GRPCReporter) GetMetrics() Metrics {
@@ -0,0 +1,240 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// ErrNotSupported is returned by Multi if none of its reporters supports an operation.
+var ErrNotSupported = errors.New("not supported by any reporter")
+
+// Multi is a Reporter that forwards all reported data to several reporters, e.g. to send
+// profiles to a remote backend and to a local sink at the same time. The reporters are
+// called in the order they were passed to NewMulti. The data is shared between the
+// reporters and must not be modified by them.
+type Multi struct {
+	reporters []Reporter
+	// filters select the samples forwarded to the reporter of the same index. A nil
+	// filter selects all samples.
+	filters []*SampleFilter
+	// redactor rewrites the symbol names and file paths before they are forwarded.
+	redactor *Redactor
+}
+
+// Assert that we implement the full Reporter interface.
+var _ Reporter = (*Multi)(nil)
+var _ Flusher = (*Multi)(nil)
+var _ DiffProfiler = (*Multi)(nil)
+var _ SampleSubscriber = (*Multi)(nil)
+var _ LostSamplesReporter = (*Multi)(nil)
+
+// NewMulti creates a Multi reporter that forwards to the given reporters.
+func NewMulti(reporters ...Reporter) *Multi {
+	return &Multi{reporters: reporters, filters: make([]*SampleFilter, len(reporters))}
+}
+
+// NewRedactingMulti creates a Multi reporter that applies redactor to the symbol names and
+// file paths before forwarding them to the given reporters, so that none of them sees the
+// original values.
+func NewRedactingMulti(redactor *Redactor, reporters ...Reporter) *Multi {
+	m := NewMulti(reporters...)
+	m.redactor = redactor
+	return m
+}
+
+// SetFilter sets the filter selecting the samples that are forwarded to r, which must be
+// one of the reporters of m. By default, all samples are forwarded. The traces, symbols and
+// metadata are forwarded regardless of the filter. It must be called before data is
+// reported.
+func (m *Multi) SetFilter(r Reporter, filter *SampleFilter) {
+	for i := range m.reporters {
+		if m.reporters[i] == r {
+			m.filters[i] = filter
+			return
+		}
+	}
+	panic("SetFilter called with a reporter that is not part of Multi")
+}
+
+// ReportFramesForTrace implements the TraceReporter interface.
+func (m *Multi) ReportFramesForTrace(trace *libpf.Trace) {
+	for _, r := range m.reporters {
+		r.ReportFramesForTrace(trace)
+	}
+}
+
+// ReportCountForTrace implements the TraceReporter interface.
+// The filters are evaluated on the samples before redaction.
+func (m *Multi) ReportCountForTrace(traceHash libpf.TraceHash, meta *TraceEventMeta) {
+	redacted := m.redactor.redactMeta(meta)
+	for i, r := range m.reporters {
+		if m.filters[i].Selects(meta) {
+			r.ReportCountForTrace(traceHash, redacted)
+		}
+	}
+}
+
+// ReportFallbackSymbol implements the SymbolReporter interface.
+func (m *Multi) ReportFallbackSymbol(frameID libpf.FrameID, symbol string) {
+	symbol = m.redactor.Redact(symbol)
+	for _, r := range m.reporters {
+		r.ReportFallbackSymbol(frameID, symbol)
+	}
+}
+
+// ExecutableMetadata implements the SymbolReporter interface.
+func (m *Multi) ExecutableMetadata(ctx context.Context, fileID libpf.FileID,
+	fileName, buildID string, device, inode uint64, addressMapper pfelf.AddressMapper) {
+	fileName = m.redactor.Redact(fileName)
+	for _, r := range m.reporters {
+		r.ExecutableMetadata(ctx, fileID, fileName, buildID, device, inode, addressMapper)
+	}
+}
+
+// FrameMetadata implements the SymbolReporter interface.
+func (m *Multi) FrameMetadata(fileID libpf.FileID, addressOrLine libpf.AddressOrLineno,
+	lineNumber libpf.SourceLineno, functionOffset uint32, functionName, filePath string) {
+	functionName = m.redactor.Redact(functionName)
+	filePath = m.redactor.Redact(filePath)
+	for _, r := range m.reporters {
+		r.FrameMetadata(fileID, addressOrLine, lineNumber, functionOffset, functionName,
+			filePath)
+	}
+}
+
+// ReportHostMetadata implements the HostMetadataReporter interface.
+func (m *Multi) ReportHostMetadata(metadataMap map[string]string) {
+	for _, r := range m.reporters {
+		r.ReportHostMetadata(metadataMap)
+	}
+}
+
+// ReportHostMetadataBlocking implements the HostMetadataReporter interface. The reporters
+// are called concurrently, so that a reporter that is retrying does not delay the others.
+// The errors of all failing reporters are returned.
+func (m *Multi) ReportHostMetadataBlocking(ctx context.Context,
+	metadataMap map[string]string, maxRetries int, waitRetry time.Duration) error {
+	errs := make([]error, len(m.reporters))
+	var wg sync.WaitGroup
+	for i, r := range m.reporters {
+		wg.Add(1)
+		go func(i int, r Reporter) {
+			defer wg.Done()
+			errs[i] = r.ReportHostMetadataBlocking(ctx, metadataMap, maxRetries, waitRetry)
+		}(i, r)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// ReportMetrics implements the MetricsReporter interface.
+func (m *Multi) ReportMetrics(timestamp uint32, ids []uint32, values []int64) {
+	for _, r := range m.reporters {
+		r.ReportMetrics(timestamp, ids, values)
+	}
+}
+
+// Flush implements the Flusher interface by flushing all reporters that support it, and
+// returns the total number of samples sent. ErrNotSupported is returned if no reporter
+// supports flushing.
+func (m *Multi) Flush(ctx context.Context) (int, error) {
+	var samples int
+	var errs []error
+	supported := false
+	for _, r := range m.reporters {
+		flusher, ok := r.(Flusher)
+		if !ok {
+			continue
+		}
+		supported = true
+		n, err := flusher.Flush(ctx)
+		samples += n
+		errs = append(errs, err)
+	}
+	if !supported {
+		return 0, ErrNotSupported
+	}
+	return samples, errors.Join(errs...)
+}
+
+// WriteDiffProfile implements the DiffProfiler interface with the first reporter that
+// supports it. ErrNotSupported is returned if there is no such reporter.
+func (m *Multi) WriteDiffProfile(ctx context.Context, interval time.Duration,
+	w io.Writer) error {
+	for _, r := range m.reporters {
+		if differ, ok := r.(DiffProfiler); ok {
+			return differ.WriteDiffProfile(ctx, interval, w)
+		}
+	}
+	return ErrNotSupported
+}
+
+// Subscribe implements the SampleSubscriber interface by subscribing to the samples of the
+// first reporter that streams them. They are all reported the same, so one stream suffices.
+// Without such a reporter, the subscription receives no samples.
+func (m *Multi) Subscribe(buffer int) *Subscription {
+	for _, r := range m.reporters {
+		if subscriber, ok := r.(SampleSubscriber); ok {
+			return subscriber.Subscribe(buffer)
+		}
+	}
+	return (&sampleStream{}).subscribe(buffer)
+}
+
+// ReportLostSamples implements the LostSamplesReporter interface by forwarding to all
+// reporters that account for lost samples.
+func (m *Multi) ReportLostSamples(cpu int, count uint64) {
+	for _, r := range m.reporters {
+		if lost, ok := r.(LostSamplesReporter); ok {
+			lost.ReportLostSamples(cpu, count)
+		}
+	}
+}
+
+// Stop triggers a graceful shutdown of all reporters.
+func (m *Multi) Stop() {
+	for _, r := range m.reporters {
+		r.Stop()
+	}
+}
+
+// GetMetrics returns the sum of the internal metrics of all reporters. The last export
+// timestamp is the oldest one of all reporters, so that a single stalled reporter is
+// visible. Reporters that do not export, and thus report no timestamp, are skipped. The
+// lost samples are reported to all reporters, so their maximum is returned.
+func (m *Multi) GetMetrics() Metrics {
+	var sum Metrics
+	for _, r := range m.reporters {
+		metrics := r.GetMetrics()
+		if metrics.LastExportTimestamp != 0 && (sum.LastExportTimestamp == 0 ||
+			metrics.LastExportTimestamp < sum.LastExportTimestamp) {
+			sum.LastExportTimestamp = metrics.LastExportTimestamp
+		}
+		sum.CountsForTracesOverwriteCount += metrics.CountsForTracesOverwriteCount
+		sum.ExeMetadataOverwriteCount += metrics.ExeMetadataOverwriteCount
+		sum.FrameMetadataOverwriteCount += metrics.FrameMetadataOverwriteCount
+		sum.FramesForTracesOverwriteCount += metrics.FramesForTracesOverwriteCount
+		sum.HostMetadataOverwriteCount += metrics.HostMetadataOverwriteCount
+		sum.MetricsOverwriteCount += metrics.MetricsOverwriteCount
+		sum.FallbackSymbolsOverwriteCount += metrics.FallbackSymbolsOverwriteCount
+		sum.RPCBytesOutCount += metrics.RPCBytesOutCount
+		sum.RPCBytesInCount += metrics.RPCBytesInCount
+		sum.WireBytesOutCount += metrics.WireBytesOutCount
+		sum.WireBytesInCount += metrics.WireBytesInCount
+		sum.LiveSamplesDropped += metrics.LiveSamplesDropped
+		sum.LostSamples = max(sum.LostSamples, metrics.LostSamples)
+	}
+	return sum
+}
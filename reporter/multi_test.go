@@ -0,0 +1,240 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// countingReporter is a Reporter that counts the calls of each method.
+type countingReporter struct {
+	calls   map[string]int
+	err     error
+	metrics Metrics
+}
+
+var _ Reporter = (*countingReporter)(nil)
+
+func newCountingReporter() *countingReporter {
+	return &countingReporter{calls: make(map[string]int)}
+}
+
+func (c *countingReporter) ReportFramesForTrace(*libpf.Trace) {
+	c.calls["ReportFramesForTrace"]++
+}
+
+func (c *countingReporter) ReportCountForTrace(libpf.TraceHash, *TraceEventMeta) {
+	c.calls["ReportCountForTrace"]++
+}
+
+func (c *countingReporter) ReportFallbackSymbol(libpf.FrameID, string) {
+	c.calls["ReportFallbackSymbol"]++
+}
+
+func (c *countingReporter) ExecutableMetadata(context.Context, libpf.FileID, string, string,
+	uint64, uint64, pfelf.AddressMapper) {
+	c.calls["ExecutableMetadata"]++
+}
+
+func (c *countingReporter) FrameMetadata(libpf.FileID, libpf.AddressOrLineno,
+	libpf.SourceLineno, uint32, string, string) {
+	c.calls["FrameMetadata"]++
+}
+
+func (c *countingReporter) ReportHostMetadata(map[string]string) {
+	c.calls["ReportHostMetadata"]++
+}
+
+func (c *countingReporter) ReportHostMetadataBlocking(context.Context, map[string]string, int,
+	time.Duration) error {
+	c.calls["ReportHostMetadataBlocking"]++
+	return c.err
+}
+
+func (c *countingReporter) ReportMetrics(uint32, []uint32, []int64) {
+	c.calls["ReportMetrics"]++
+}
+
+func (c *countingReporter) Stop() {
+	c.calls["Stop"]++
+}
+
+func (c *countingReporter) GetMetrics() Metrics {
+	return c.metrics
+}
+
+func TestMulti(t *testing.T) {
+	ctx := context.Background()
+	first, second := newCountingReporter(), newCountingReporter()
+	multi := NewMulti(first, second)
+
+	multi.ReportFramesForTrace(&libpf.Trace{})
+	multi.ReportCountForTrace(libpf.TraceHash{}, &TraceEventMeta{
+		Count:    1,
+		EventSet: libpf.PrimaryEventSet,
+	})
+	multi.ReportFallbackSymbol(libpf.FrameID{}, "")
+	multi.ExecutableMetadata(ctx, libpf.FileID{}, "", "", 0, 0, pfelf.AddressMapper{})
+	multi.FrameMetadata(libpf.FileID{}, 0, 0, 0, "", "")
+	multi.ReportHostMetadata(nil)
+	require.NoError(t, multi.ReportHostMetadataBlocking(ctx, nil, 1, time.Second))
+	multi.ReportMetrics(0, nil, nil)
+	multi.Stop()
+
+	expected := map[string]int{
+		"ReportFramesForTrace":       1,
+		"ReportCountForTrace":        1,
+		"ReportFallbackSymbol":       1,
+		"ExecutableMetadata":         1,
+		"FrameMetadata":              1,
+		"ReportHostMetadata":         1,
+		"ReportHostMetadataBlocking": 1,
+		"ReportMetrics":              1,
+		"Stop":                       1,
+	}
+	assert.Equal(t, expected, first.calls)
+	assert.Equal(t, expected, second.calls)
+}
+
+func TestMultiFilter(t *testing.T) {
+	central, debug := newCountingReporter(), newCountingReporter()
+	redactor, err := NewRedactor([]string{`prod=redacted`})
+	require.NoError(t, err)
+	multi := NewRedactingMulti(redactor, central, debug)
+	filter, err := NewSampleFilter([]string{"executable=prod*"})
+	require.NoError(t, err)
+	multi.SetFilter(central, filter)
+
+	for _, executable := range []string{"prod-api", "test-api"} {
+		multi.ReportFramesForTrace(&libpf.Trace{})
+		multi.ReportCountForTrace(libpf.TraceHash{}, &TraceEventMeta{
+			Count:      1,
+			Executable: executable,
+			EventSet:   libpf.PrimaryEventSet,
+		})
+	}
+	// The filter applies to the original executable name, and only to the samples.
+	assert.Equal(t, 1, central.calls["ReportCountForTrace"])
+	assert.Equal(t, 2, central.calls["ReportFramesForTrace"])
+	assert.Equal(t, 2, debug.calls["ReportCountForTrace"])
+
+	assert.Panics(t, func() { multi.SetFilter(newCountingReporter(), filter) })
+}
+
+func TestMultiErrors(t *testing.T) {
+	errFirst, errThird := errors.New("first failed"), errors.New("third failed")
+	reporters := []*countingReporter{
+		newCountingReporter(), newCountingReporter(), newCountingReporter(),
+	}
+	reporters[0].err = errFirst
+	reporters[2].err = errThird
+	multi := NewMulti(reporters[0], reporters[1], reporters[2])
+
+	err := multi.ReportHostMetadataBlocking(context.Background(), nil, 1, time.Second)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errFirst)
+	assert.ErrorIs(t, err, errThird)
+	// A failing reporter does not prevent the others from being called.
+	for _, r := range reporters {
+		assert.Equal(t, 1, r.calls["ReportHostMetadataBlocking"])
+	}
+}
+
+func TestMultiGetMetrics(t *testing.T) {
+	first, second := newCountingReporter(), newCountingReporter()
+	first.metrics = Metrics{CountsForTracesOverwriteCount: 1, RPCBytesOutCount: 100}
+	second.metrics = Metrics{CountsForTracesOverwriteCount: 2, WireBytesInCount: 42}
+
+	assert.Equal(t, Metrics{
+		CountsForTracesOverwriteCount: 3,
+		RPCBytesOutCount:              100,
+		WireBytesInCount:              42,
+	}, NewMulti(first, second).GetMetrics())
+}
+
+func TestMultiLastExportTimestamp(t *testing.T) {
+	first, second := newCountingReporter(), newCountingReporter()
+	first.metrics = Metrics{LastExportTimestamp: 1700000100}
+	second.metrics = Metrics{LastExportTimestamp: 1700000000}
+	assert.Equal(t, int64(1700000000),
+		NewMulti(first, second).GetMetrics().LastExportTimestamp)
+
+	// Reporters without a timestamp do not export, e.g. the raw dump writer.
+	second.metrics = Metrics{}
+	assert.Equal(t, int64(1700000100),
+		NewMulti(first, second).GetMetrics().LastExportTimestamp)
+
+	first.metrics = Metrics{}
+	assert.Equal(t, int64(0), NewMulti(first, second).GetMetrics().LastExportTimestamp)
+}
+
+// flushingReporter is a countingReporter that supports flushing.
+type flushingReporter struct {
+	*countingReporter
+	samples int
+}
+
+func (f *flushingReporter) Flush(context.Context) (int, error) {
+	f.calls["Flush"]++
+	return f.samples, f.err
+}
+
+func TestMultiFlush(t *testing.T) {
+	_, err := NewMulti(newCountingReporter()).Flush(context.Background())
+	require.ErrorIs(t, err, ErrNotSupported)
+	err = NewMulti(newCountingReporter()).WriteDiffProfile(context.Background(), time.Second,
+		io.Discard)
+	require.ErrorIs(t, err, ErrNotSupported)
+
+	first := &flushingReporter{countingReporter: newCountingReporter(), samples: 2}
+	second := &flushingReporter{countingReporter: newCountingReporter(), samples: 3}
+	other := newCountingReporter()
+	samples, err := NewMulti(first, other, second).Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 5, samples)
+	assert.Equal(t, 1, first.calls["Flush"])
+	assert.Equal(t, 1, second.calls["Flush"])
+
+	second.err = errors.New("second failed")
+	samples, err = NewMulti(first, second).Flush(context.Background())
+	require.ErrorIs(t, err, second.err)
+	assert.Equal(t, 5, samples)
+}
+
+func TestMultiSubscribe(t *testing.T) {
+	// Without a reporter that streams samples, the subscription receives none.
+	sub := NewMulti(newCountingReporter()).Subscribe(1)
+	sub.Close()
+	_, ok := <-sub.C
+	assert.False(t, ok)
+
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+	m := NewMulti(newCountingReporter(), r)
+	sub = m.Subscribe(1)
+	defer sub.Close()
+	m.ReportCountForTrace(traceHash, &TraceEventMeta{
+		Timestamp: 1700000000,
+		Count:     1,
+		Comm:      "worker",
+		EventSet:  libpf.PrimaryEventSet,
+	})
+	sample := <-sub.C
+	assert.Equal(t, "worker", sample.Comm)
+}
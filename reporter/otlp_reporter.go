@@ -8,7 +8,15 @@ package reporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/otel-profiling-agent/config"
@@ -19,6 +27,7 @@ import (
 
 	"github.com/elastic/otel-profiling-agent/debug/log"
 	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 
 	common "go.opentelemetry.io/proto/otlp/common/v1"
 	resource "go.opentelemetry.io/proto/otlp/resource/v1"
@@ -30,29 +39,116 @@ import (
 // Assert that we implement the full Reporter interface.
 var _ Reporter = (*OTLPReporter)(nil)
 
+// Assert that OTLPReporter can be flushed on demand.
+var _ Flusher = (*OTLPReporter)(nil)
+
+// Assert that OTLPReporter accounts for lost samples.
+var _ LostSamplesReporter = (*OTLPReporter)(nil)
+
+const (
+	// mappingDeviceAttr and mappingInodeAttr are the keys of the mapping attributes that
+	// hold the device and inode numbers of the file backing a native mapping.
+	mappingDeviceAttr = "file.device"
+	mappingInodeAttr  = "file.inode"
+
+	// pythonModuleAttr is the key of the location attribute that holds the Python extension
+	// module a native frame of a Python trace belongs to.
+	pythonModuleAttr = "python.module"
+
+	// sessionIDAttr is the resource attribute holding the ID of the agent run that
+	// produced the profiles.
+	sessionIDAttr = "telemetry.agent.session_id"
+)
+
+// pythonExtensionRegex matches the file names of compiled Python extension modules, e.g.
+// _ssl.cpython-311-x86_64-linux-gnu.so, _cffi_backend.abi3.so or
+// _cffi_backend.pypy39-pp73-x86_64-linux-gnu.so, and captures the module name.
+var pythonExtensionRegex = regexp.MustCompile(
+	`^([A-Za-z_][A-Za-z0-9_]*)\.(?:cpython-[0-9]+[a-z]*|abi3|pypy[0-9]+-pp[0-9]+)` +
+		`(?:-[A-Za-z0-9_]+)*\.so$`)
+
 // traceInfo holds static information about a trace.
 type traceInfo struct {
 	files          []libpf.FileID
 	linenos        []libpf.AddressOrLineno
 	frameTypes     []libpf.FrameType
 	comm           string
+	executable     string
 	podName        string
 	containerName  string
+	containerized  bool
+	coreType       string
 	apmServiceName string
 }
 
+// sampleKey identifies the samples of a trace. If samples are grouped by thread, the
+// samples of each thread are kept apart. Samples of processes with different labels or of
+// different event sets are kept apart as well.
+type sampleKey struct {
+	traceHash libpf.TraceHash
+	tid       libpf.PID
+	eventSet  libpf.EventSet
+	// labels is the canonical encoding of the process labels as returned by labelsKey.
+	labels string
+}
+
+// hash32 is a helper function for LRUs that use sampleKey as a key.
+func (k sampleKey) hash32() uint32 {
+	h := k.traceHash.Hash32() ^ uint32(k.tid) ^ uint32(k.eventSet)<<24
+	if k.labels != "" {
+		h ^= hashString(k.labels)
+	}
+	return h
+}
+
+// sortedLabelNames returns the names of labels in ascending order.
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// labelsKey returns a string that is equal for equal sets of labels.
+func labelsKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := sortedLabelNames(labels)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
 // sample holds dynamic information about traces.
 type sample struct {
 	// In most cases OTEP/profiles requests timestamps in a uint64 format
 	// and use nanosecond precision - https://github.com/open-telemetry/oteps/issues/253
 	timestamps []uint64
 	count      uint32
+	// weight is the sum of the weights of the samples.
+	weight uint64
+	// labels are the labels the process defined for its samples.
+	labels map[string]string
 }
 
 // execInfo enriches an executable with additional metadata.
 type execInfo struct {
 	fileName string
 	buildID  string
+	// device and inode identify the file the executable was loaded from.
+	// They allow locating build-id-less binaries for offline symbolization.
+	device uint64
+	inode  uint64
+	// addressMapper converts the ELF virtual addresses of frames to file offsets.
+	addressMapper pfelf.AddressMapper
 }
 
 // sourceInfo allows to map a frame to its source origin.
@@ -80,6 +176,13 @@ type OTLPReporter struct {
 	// rpcStats stores gRPC related statistics.
 	rpcStats *statsHandlerImpl
 
+	// exports tracks the last successful export to detect a stalled reporter.
+	exports *exportTracker
+
+	// exportMu serializes exports, which can be triggered by the reporting interval and by
+	// Flush at the same time. Otherwise concurrent exports could report samples twice.
+	exportMu sync.Mutex
+
 	// To fill in the OTLP/profiles signal with the relevant information,
 	// this structure holds in long term storage information that might
 	// be duplicated in other places but not accessible for OTLPReporter.
@@ -91,7 +194,7 @@ type OTLPReporter struct {
 	traces *lru.SyncedLRU[libpf.TraceHash, traceInfo]
 
 	// samples holds a map of currently encountered traces.
-	samples *lru.SyncedLRU[libpf.TraceHash, sample]
+	samples *lru.SyncedLRU[sampleKey, sample]
 
 	// fallbackSymbols keeps track of FrameID to their symbol.
 	fallbackSymbols *lru.SyncedLRU[libpf.FrameID, string]
@@ -101,6 +204,39 @@ type OTLPReporter struct {
 
 	// frames maps frame information to its source location.
 	frames *lru.SyncedLRU[libpf.FileID, map[libpf.AddressOrLineno]sourceInfo]
+
+	// trimmer collapses frames of configured libraries. It is nil if no frames are trimmed.
+	trimmer *frameTrimmer
+
+	// sessionID identifies this run of the agent.
+	sessionID string
+
+	// rootFrame is set if a synthetic root frame for the process is added to each stack.
+	rootFrame bool
+
+	// lostSamplesFrame is set if the lost samples are reported as synthetic samples.
+	lostSamplesFrame bool
+
+	// lostSamples is the number of samples lost since the last export.
+	lostSamples atomic.Uint64
+
+	// exportedLostSamples is the number of lost samples accounted to exports since the
+	// last call to GetMetrics.
+	exportedLostSamples atomic.Int64
+
+	// eventSetTypes holds the sample types of the profiles of each event set, indexed by
+	// libpf.EventSet. Event sets without entry have cpuSamplesType.
+	eventSetTypes []EventSetType
+
+	// cpuTimeWeights is set if the samples carry the CPU time they stand for, which is
+	// reported as additional value of the samples.
+	cpuTimeWeights bool
+
+	// capture collects the samples of differential profiles while they are captured.
+	capture sampleCapture
+
+	// stream distributes the reported samples to the subscriptions of Subscribe.
+	stream sampleStream
 }
 
 // hashString is a helper function for LRUs that use string as a key.
@@ -132,37 +268,95 @@ func (r *OTLPReporter) ReportFramesForTrace(trace *libpf.Trace) {
 	}
 }
 
-// ReportCountForTrace accepts a hash of a trace with a corresponding count and
+// ReportCountForTrace accepts a hash of a trace with the metadata of its samples and
 // caches this information.
-func (r *OTLPReporter) ReportCountForTrace(traceHash libpf.TraceHash, timestamp libpf.UnixTime32,
-	count uint16, comm, podName, containerName string) {
+func (r *OTLPReporter) ReportCountForTrace(traceHash libpf.TraceHash, meta *TraceEventMeta) {
 	if v, exists := r.traces.Peek(traceHash); exists {
 		// As traces is filled from two different API endpoints,
 		// some information for the trace might be available already.
 		// For simplicty, the just received information overwrites the
 		// the existing one.
-		v.comm = comm
-		v.podName = podName
-		v.containerName = containerName
+		v.comm = meta.Comm
+		v.executable = meta.Executable
+		v.podName = meta.PodName
+		v.containerName = meta.ContainerName
+		v.containerized = meta.Containerized
+		v.coreType = meta.CoreType
 
 		r.traces.Add(traceHash, v)
 	} else {
 		r.traces.Add(traceHash, traceInfo{
-			comm:          comm,
-			podName:       podName,
-			containerName: containerName,
+			comm:          meta.Comm,
+			executable:    meta.Executable,
+			podName:       meta.PodName,
+			containerName: meta.ContainerName,
+			containerized: meta.Containerized,
+			coreType:      meta.CoreType,
 		})
 	}
 
-	if v, ok := r.samples.Peek(traceHash); ok {
-		v.count += uint32(count)
-		v.timestamps = append(v.timestamps, uint64(timestamp))
+	key := sampleKey{traceHash: traceHash, tid: meta.TID, eventSet: meta.EventSet,
+		labels: labelsKey(meta.Labels)}
+	if v, ok := r.samples.Peek(key); ok {
+		v.count += uint32(meta.Count)
+		v.weight += meta.Weight
+		v.timestamps = append(v.timestamps, uint64(meta.Timestamp))
+
+		r.samples.Add(key, v)
+	} else {
+		r.samples.Add(key, sample{
+			count:      uint32(meta.Count),
+			weight:     meta.Weight,
+			timestamps: []uint64{uint64(meta.Timestamp)},
+			labels:     meta.Labels,
+		})
+	}
+	r.capture.add(key, meta)
+
+	if r.stream.hasSubscriptions() {
+		trace, _ := r.traces.Peek(traceHash)
+		r.stream.publish(r.liveSample(&trace, meta))
+	}
+}
+
+// lostSamplesFileID is the file ID of the synthetic frame of the samples reported for lost
+// samples. The hashes of their traces are made up of lostSamplesTraceHashHi and the CPU.
+var lostSamplesFileID = libpf.NewFileID(lostSamplesTraceHashHi, 0)
+
+const lostSamplesTraceHashHi = 0x6c6f73742d736d70
+
+// ReportLostSamples implements the LostSamplesReporter interface. The lost samples are
+// accounted to the next export. If enabled, they are also reported as a sample of their
+// CPU with a single frame named [lost N samples], so that the profile shows how many
+// samples it misses.
+func (r *OTLPReporter) ReportLostSamples(cpu int, count uint64) {
+	r.lostSamples.Add(count)
+	if !r.lostSamplesFrame {
+		return
+	}
+
+	traceHash := libpf.NewTraceHash(lostSamplesTraceHashHi, uint64(cpu))
+	if _, exists := r.traces.Peek(traceHash); !exists {
+		r.traces.Add(traceHash, traceInfo{
+			files:      []libpf.FileID{lostSamplesFileID},
+			linenos:    []libpf.AddressOrLineno{0},
+			frameTypes: []libpf.FrameType{libpf.AbortFrame},
+		})
+	}
 
-		r.samples.Add(traceHash, v)
+	labels := map[string]string{"cpu": strconv.Itoa(cpu)}
+	key := sampleKey{traceHash: traceHash, eventSet: libpf.PrimaryEventSet,
+		labels: labelsKey(labels)}
+	timestamp := uint64(time.Now().Unix())
+	if v, ok := r.samples.Peek(key); ok {
+		v.count += uint32(count)
+		v.timestamps = append(v.timestamps, timestamp)
+		r.samples.Add(key, v)
 	} else {
-		r.samples.Add(traceHash, sample{
+		r.samples.Add(key, sample{
 			count:      uint32(count),
-			timestamps: []uint64{uint64(timestamp)},
+			timestamps: []uint64{timestamp},
+			labels:     labels,
 		})
 	}
 }
@@ -178,10 +372,14 @@ func (r *OTLPReporter) ReportFallbackSymbol(frameID libpf.FrameID, symbol string
 // ExecutableMetadata accepts a fileID with the corresponding filename
 // and caches this information.
 func (r *OTLPReporter) ExecutableMetadata(_ context.Context,
-	fileID libpf.FileID, fileName, buildID string) {
+	fileID libpf.FileID, fileName, buildID string, device, inode uint64,
+	addressMapper pfelf.AddressMapper) {
 	r.executables.Add(fileID, execInfo{
-		fileName: fileName,
-		buildID:  buildID,
+		fileName:      fileName,
+		buildID:       buildID,
+		device:        device,
+		inode:         inode,
+		addressMapper: addressMapper,
 	})
 }
 
@@ -245,15 +443,20 @@ func (r *OTLPReporter) Stop() {
 // GetMetrics returns internal metrics of OTLPReporter.
 func (r *OTLPReporter) GetMetrics() Metrics {
 	return Metrics{
-		RPCBytesOutCount:  r.rpcStats.getRPCBytesOut(),
-		RPCBytesInCount:   r.rpcStats.getRPCBytesIn(),
-		WireBytesOutCount: r.rpcStats.getWireBytesOut(),
-		WireBytesInCount:  r.rpcStats.getWireBytesIn(),
+		RPCBytesOutCount:    r.rpcStats.getRPCBytesOut(),
+		RPCBytesInCount:     r.rpcStats.getRPCBytesIn(),
+		WireBytesOutCount:   r.rpcStats.getWireBytesOut(),
+		WireBytesInCount:    r.rpcStats.getWireBytesIn(),
+		LastExportTimestamp: r.exports.lastExportUnix(),
+		LiveSamplesDropped:  r.stream.dropped.Swap(0),
+		LostSamples:         r.exportedLostSamples.Swap(0),
 	}
 }
 
-// StartOTLP sets up and manages the reporting connection to a OTLP backend.
-func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
+// NewOTLPReporter creates an OTLPReporter that caches the reported information, but
+// is not connected to an OTLP backend. This allows driving the reporter with injected
+// data, e.g. from benchmarks. Use StartOTLP to report to a backend.
+func NewOTLPReporter() (*OTLPReporter, error) {
 	cacheSize := config.TraceCacheEntries()
 
 	traces, err := lru.NewSynced[libpf.TraceHash, traceInfo](cacheSize, libpf.TraceHash.Hash32)
@@ -261,7 +464,7 @@ func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
 		return nil, err
 	}
 
-	samples, err := lru.NewSynced[libpf.TraceHash, sample](cacheSize, libpf.TraceHash.Hash32)
+	samples, err := lru.NewSynced[sampleKey, sample](cacheSize, sampleKey.hash32)
 	if err != nil {
 		return nil, err
 	}
@@ -290,16 +493,25 @@ func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
 		return nil, err
 	}
 
-	r := &OTLPReporter{
+	return &OTLPReporter{
 		stopSignal:      make(chan libpf.Void),
 		client:          nil,
 		rpcStats:        newStatsHandler(),
+		exports:         newExportTracker(time.Now()),
 		traces:          traces,
 		samples:         samples,
 		fallbackSymbols: fallbackSymbols,
 		executables:     executables,
 		frames:          frames,
 		hostmetadata:    hostmetadata,
+	}, nil
+}
+
+// StartOTLP sets up and manages the reporting connection to a OTLP backend.
+func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
+	r, err := NewOTLPReporter()
+	if err != nil {
+		return nil, err
 	}
 
 	// Create a child context for reporting features
@@ -315,6 +527,17 @@ func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
 		return nil, err
 	}
 	r.client = otlpcollector.NewProfilesServiceClient(otlpGrpcConn)
+	r.sessionID = c.SessionID
+	r.rootFrame = c.RootFrame
+	r.lostSamplesFrame = c.LostSamplesFrame
+	r.eventSetTypes = c.EventSetTypes
+	r.cpuTimeWeights = c.CPUTimeWeights
+
+	if r.trimmer, err = newFrameTrimmer(c.TrimFrames); err != nil {
+		cancelReporting()
+		close(r.stopSignal)
+		return nil, err
+	}
 
 	go func() {
 		tick := time.NewTicker(c.Times.ReportInterval())
@@ -326,7 +549,7 @@ func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
 			case <-r.stopSignal:
 				return
 			case <-tick.C:
-				if err := r.reportOTLPProfile(ctx); err != nil {
+				if _, err := r.export(ctx); err != nil {
 					log.Errorf("Request failed: %v", err)
 				}
 				tick.Reset(libpf.AddJitter(c.Times.ReportInterval(), 0.2))
@@ -334,6 +557,23 @@ func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
 		}
 	}()
 
+	// Check for stalled exports independently of the reporting loop, which might be
+	// blocked itself.
+	go func() {
+		tick := time.NewTicker(c.Times.ReportInterval())
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopSignal:
+				return
+			case <-tick.C:
+				r.exports.check(time.Now(), c.Times.ReportInterval())
+			}
+		}
+	}()
+
 	// When Stop() is called and a signal to 'stop' is received, then:
 	// - cancel the reporting functions currently running (using context)
 	// - close the gRPC connection with collection-agent
@@ -348,29 +588,58 @@ func StartOTLP(mainCtx context.Context, c *Config) (Reporter, error) {
 	return r, nil
 }
 
-// reportOTLPProfile creates and sends out an OTLP profile.
-func (r *OTLPReporter) reportOTLPProfile(ctx context.Context) error {
-	profile, startTS, endTS := r.getProfile()
+// Flush immediately sends out the samples collected so far instead of waiting for the
+// reporting interval, and returns the number of samples sent.
+func (r *OTLPReporter) Flush(ctx context.Context) (int, error) {
+	if r.client == nil {
+		return 0, errors.New("reporter is not connected to a backend")
+	}
+	return r.export(ctx)
+}
+
+// export sends out an OTLP profile with the samples collected so far and returns the
+// number of samples sent.
+func (r *OTLPReporter) export(ctx context.Context) (int, error) {
+	r.exportMu.Lock()
+	defer r.exportMu.Unlock()
 
-	if len(profile.Sample) == 0 {
+	numSamples, err := r.reportOTLPProfile(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// Rounds without samples to send also count as successful, as they show that the
+	// reporter is operational.
+	r.exports.succeeded(time.Now())
+	return numSamples, nil
+}
+
+// reportOTLPProfile creates and sends out the OTLP profiles of the event sets, and returns
+// the number of samples they hold.
+func (r *OTLPReporter) reportOTLPProfile(ctx context.Context) (int, error) {
+	eventSetProfiles, startTS, endTS := r.getProfiles()
+
+	if len(eventSetProfiles) == 0 {
 		log.Debugf("Skip sending of OTLP profile with no samples")
-		return nil
+		return 0, nil
 	}
 
-	pc := []*profiles.ProfileContainer{{
-		// Next step: not sure about the value of ProfileId
-		// Discussion around this field and its requirements started with
-		// https://github.com/open-telemetry/oteps/pull/239#discussion_r1491546899
-		// As an ID with all zeros is considered invalid, we write ELASTIC here.
-		ProfileId:         []byte("ELASTIC"),
-		StartTimeUnixNano: uint64(time.Unix(int64(startTS), 0).UnixNano()),
-		EndTimeUnixNano:   uint64(time.Unix(int64(endTS), 0).UnixNano()),
-		// Attributes - Optional element we do not use.
-		// DroppedAttributesCount - Optional element we do not use.
-		// OriginalPayloadFormat - Optional element we do not use.
-		// OriginalPayload - Optional element we do not use.
-		Profile: profile,
-	}}
+	pc := make([]*profiles.ProfileContainer, 0, len(eventSetProfiles))
+	for _, profile := range eventSetProfiles {
+		pc = append(pc, &profiles.ProfileContainer{
+			// Next step: not sure about the value of ProfileId
+			// Discussion around this field and its requirements started with
+			// https://github.com/open-telemetry/oteps/pull/239#discussion_r1491546899
+			// As an ID with all zeros is considered invalid, we write ELASTIC here.
+			ProfileId:         []byte("ELASTIC"),
+			StartTimeUnixNano: uint64(time.Unix(int64(startTS), 0).UnixNano()),
+			EndTimeUnixNano:   uint64(time.Unix(int64(endTS), 0).UnixNano()),
+			// Attributes - Optional element we do not use.
+			// DroppedAttributesCount - Optional element we do not use.
+			// OriginalPayloadFormat - Optional element we do not use.
+			// OriginalPayload - Optional element we do not use.
+			Profile: profile,
+		})
+	}
 
 	scopeProfiles := []*profiles.ScopeProfiles{{
 		Profiles: pc,
@@ -391,8 +660,16 @@ func (r *OTLPReporter) reportOTLPProfile(ctx context.Context) error {
 		ResourceProfiles: resourceProfiles,
 	}
 
-	_, err := r.client.Export(ctx, &req)
-	return err
+	if _, err := r.client.Export(ctx, &req); err != nil {
+		return 0, err
+	}
+	numSamples := 0
+	for _, profile := range eventSetProfiles {
+		for _, sample := range profile.Sample {
+			numSamples += len(sample.Timestamps)
+		}
+	}
+	return numSamples, nil
 }
 
 // getResource returns the OTLP resource information of the origin of the profiles.
@@ -400,7 +677,7 @@ func (r *OTLPReporter) reportOTLPProfile(ctx context.Context) error {
 func (r *OTLPReporter) getResource() *resource.Resource {
 	keys := r.hostmetadata.Keys()
 
-	attributes := make([]*common.KeyValue, len(keys))
+	attributes := make([]*common.KeyValue, len(keys), len(keys)+1)
 	i := 0
 	for _, k := range keys {
 		v, ok := r.hostmetadata.Get(k)
@@ -413,17 +690,79 @@ func (r *OTLPReporter) getResource() *resource.Resource {
 		}
 		i++
 	}
+	attributes = attributes[:i]
+	if r.sessionID != "" {
+		attributes = append(attributes, &common.KeyValue{
+			Key: sessionIDAttr,
+			Value: &common.AnyValue{Value: &common.AnyValue_StringValue{
+				StringValue: r.sessionID}},
+		})
+	}
 	origin := &resource.Resource{
 		Attributes: attributes,
 	}
 	return origin
 }
 
+// cpuSamplesType is the sample type of the profiles of CPU samples whose sample type is
+// left unset, as it is without event sets.
+var cpuSamplesType = EventSetType{CPUTime: true}
+
 // getProfile returns an OTLP profile containing all collected samples up to this moment.
 func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uint64, endTS uint64) {
+	return r.buildProfile(r.takeSamples(), cpuSamplesType)
+}
+
+// getProfiles returns an OTLP profile for each event set with samples, containing the
+// samples of the set collected up to this moment. The profiles are ordered by event set.
+func (r *OTLPReporter) getProfiles() (eventSetProfiles []*pprofextended.Profile,
+	startTS uint64, endTS uint64) {
+	if lost := r.lostSamples.Swap(0); lost != 0 {
+		log.Warnf("%d samples were lost in the kernel since the last export, the profile "+
+			"does not include them", lost)
+		r.exportedLostSamples.Add(int64(lost))
+	}
+
+	samplesByEventSet := make(map[libpf.EventSet]map[sampleKey]sample)
+	for key, sampleInfo := range r.takeSamples() {
+		eventSetSamples, ok := samplesByEventSet[key.eventSet]
+		if !ok {
+			eventSetSamples = make(map[sampleKey]sample)
+			samplesByEventSet[key.eventSet] = eventSetSamples
+		}
+		eventSetSamples[key] = sampleInfo
+	}
+
+	eventSets := make([]libpf.EventSet, 0, len(samplesByEventSet))
+	for eventSet := range samplesByEventSet {
+		eventSets = append(eventSets, eventSet)
+	}
+	slices.Sort(eventSets)
+
+	for _, eventSet := range eventSets {
+		sampleType := cpuSamplesType
+		if int(eventSet) < len(r.eventSetTypes) {
+			sampleType = r.eventSetTypes[eventSet]
+		}
+		profile, profileStartTS, profileEndTS := r.buildProfile(
+			samplesByEventSet[eventSet], sampleType)
+		eventSetProfiles = append(eventSetProfiles, profile)
+		if startTS == 0 || profileStartTS < startTS {
+			startTS = profileStartTS
+		}
+		if profileEndTS > endTS {
+			endTS = profileEndTS
+		}
+	}
+	return eventSetProfiles, startTS, endTS
+}
+
+// takeSamples removes the samples collected up to this moment whose trace information is
+// available and returns them.
+func (r *OTLPReporter) takeSamples() map[sampleKey]sample {
 	// Avoid overlapping locks by copying its content.
 	sampleKeys := r.samples.Keys()
-	samplesCpy := make(map[libpf.TraceHash]sample, len(sampleKeys))
+	samplesCpy := make(map[sampleKey]sample, len(sampleKeys))
 	for _, k := range sampleKeys {
 		v, ok := r.samples.Get(k)
 		if !ok {
@@ -433,23 +772,41 @@ func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uin
 		r.samples.Remove(k)
 	}
 
-	var samplesWoTraceinfo []libpf.TraceHash
+	var samplesWoTraceinfo []sampleKey
 
-	for trace := range samplesCpy {
-		if _, exists := r.traces.Peek(trace); !exists {
-			samplesWoTraceinfo = append(samplesWoTraceinfo, trace)
+	for key := range samplesCpy {
+		if _, exists := r.traces.Peek(key.traceHash); !exists {
+			samplesWoTraceinfo = append(samplesWoTraceinfo, key)
 		}
 	}
 
 	if len(samplesWoTraceinfo) != 0 {
 		log.Debugf("Missing trace information for %d samples", len(samplesWoTraceinfo))
 		// Return samples for which relevant information is not available yet.
-		for _, trace := range samplesWoTraceinfo {
-			r.samples.Add(trace, samplesCpy[trace])
-			delete(samplesCpy, trace)
+		for _, key := range samplesWoTraceinfo {
+			r.samples.Add(key, samplesCpy[key])
+			delete(samplesCpy, key)
 		}
 	}
+	return samplesCpy
+}
+
+// buildProfile returns an OTLP profile containing samplesCpy. If the type of sampleType is
+// not empty, it is the type of the values of the samples, which count the samples. If CPU
+// time weights are enabled and the samples stand for CPU time, the CPU time of the samples
+// follows as second value.
+func (r *OTLPReporter) buildProfile(samplesCpy map[sampleKey]sample,
+	sampleType EventSetType) (profile *pprofextended.Profile, startTS uint64, endTS uint64) {
+	keys := make([]sampleKey, 0, len(samplesCpy))
+	for key := range samplesCpy {
+		keys = append(keys, key)
+	}
+	return r.buildProfileOf(keys, samplesCpy, sampleType)
+}
 
+// buildProfileOf is buildProfile for the samples of keys, in this order.
+func (r *OTLPReporter) buildProfileOf(keys []sampleKey, samplesCpy map[sampleKey]sample,
+	sampleType EventSetType) (profile *pprofextended.Profile, startTS uint64, endTS uint64) {
 	// stringMap is a temporary helper that will build the StringTable.
 	// By specification, the first element should be empty.
 	stringMap := make(map[string]uint32)
@@ -462,10 +819,9 @@ func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uin
 
 	numSamples := len(samplesCpy)
 	profile = &pprofextended.Profile{
-		// SampleType - Next step: Figure out the correct SampleType.
 		Sample: make([]*pprofextended.Sample, 0, numSamples),
 		// LocationIndices - Optional element we do not use.
-		// AttributeTable - Optional element we do not use.
+		// AttributeTable - Populated with mapping attributes below.
 		// AttributeUnits - Optional element we do not use.
 		// LinkTable - Optional element we do not use.
 		// DropFrames - Optional element we do not use.
@@ -477,22 +833,58 @@ func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uin
 		// Comment - Optional element we do not use.
 		// DefaultSampleType - Optional element we do not use.
 	}
+	cpuTimeWeights := r.cpuTimeWeights && sampleType.CPUTime
+	if sampleType.Type == "" && cpuTimeWeights {
+		sampleType.Type = "samples"
+	}
+	if sampleType.Unit == "" {
+		sampleType.Unit = "count"
+	}
+	if sampleType.Type != "" {
+		profile.SampleType = []*pprofextended.ValueType{{
+			Type: int64(getStringMapIndex(stringMap, sampleType.Type)),
+			Unit: int64(getStringMapIndex(stringMap, sampleType.Unit)),
+		}}
+	}
+	if cpuTimeWeights {
+		profile.SampleType = append(profile.SampleType, &pprofextended.ValueType{
+			Type: int64(getStringMapIndex(stringMap, "cpu")),
+			Unit: int64(getStringMapIndex(stringMap, "nanoseconds")),
+		})
+	}
 
 	locationIndex := uint64(0)
 
 	// Temporary lookup to reference existing Mappings.
 	fileIDtoMapping := make(map[libpf.FileID]uint64)
+	nativeMappings := make(map[nativeMappingKey]uint64)
 	frameIDtoFunction := make(map[libpf.FrameID]uint64)
+	fileIDtoPythonModule := make(map[libpf.FileID][]uint64)
 
-	for traceHash, sampleInfo := range samplesCpy {
+	for _, key := range keys {
+		sampleInfo := samplesCpy[key]
+		traceHash := key.traceHash
 		sample := &pprofextended.Sample{}
 		sample.LocationsStartIndex = locationIndex
 
 		// Earlier we peeked into traces for traceHash and know it exists.
 		trace, _ := r.traces.Get(traceHash)
+		isPythonTrace := slices.Contains(trace.frameTypes, libpf.PythonFrame)
+		isLostTrace := len(trace.files) == 1 && trace.files[0] == lostSamplesFileID
+
+		var collapsed map[int]collapsedRun
+		if r.trimmer != nil {
+			collapsed = r.trimmer.collapse(&trace, r.executableName)
+		}
 
 		sample.StacktraceIdIndex = getStringMapIndex(stringMap,
 			traceHash.StringNoQuotes())
+		if sampleType.Type != "" {
+			sample.Value = []int64{int64(sampleInfo.count)}
+		}
+		if cpuTimeWeights {
+			sample.Value = append(sample.Value, int64(sampleInfo.weight))
+		}
 
 		sample.Timestamps = make([]uint64, 0, len(sampleInfo.timestamps))
 		for _, ts := range sampleInfo.timestamps {
@@ -508,14 +900,43 @@ func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uin
 		}
 
 		// Walk every frame of the trace.
-		for i := range trace.frameTypes {
+		for i := 0; i < len(trace.frameTypes); i++ {
+			if isLostTrace {
+				// The lost samples frame does not belong to an executable. To be
+				// compliant with the protocol generate a dummy mapping entry.
+				profile.Location = append(profile.Location, &pprofextended.Location{
+					TypeIndex: getStringMapIndex(stringMap, lostFrameType),
+					MappingIndex: getDummyMappingIndex(fileIDtoMapping, stringMap,
+						profile, trace.files[i]),
+					Line: []*pprofextended.Line{{
+						FunctionIndex: createFunctionEntry(funcMap,
+							lostFrameName(sampleInfo.count), ""),
+					}},
+				})
+				continue
+			}
+			if run, exists := collapsed[i]; exists {
+				// The preceding frame belongs to the same executable, so its mapping
+				// already exists.
+				profile.Location = append(profile.Location, &pprofextended.Location{
+					TypeIndex: getStringMapIndex(stringMap,
+						libpf.NativeFrame.String()),
+					MappingIndex: fileIDtoMapping[trace.files[i]],
+					Line: []*pprofextended.Line{{
+						FunctionIndex: createFunctionEntry(funcMap, run.placeholder, ""),
+					}},
+				})
+				i = run.last
+				continue
+			}
+
 			loc := &pprofextended.Location{
 				// Id - Optional element we do not use.
 				TypeIndex: getStringMapIndex(stringMap,
 					trace.frameTypes[i].String()),
 				Address: uint64(trace.linenos[i]),
 				// IsFolded - Optional element we do not use.
-				// Attributes - Optional element we do not use.
+				// Attributes - Populated for native frames of Python extensions below.
 			}
 
 			switch frameKind := trace.frameTypes[i]; frameKind {
@@ -523,40 +944,27 @@ func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uin
 				// As native frames are resolved in the backend, we use Mapping to
 				// report these frames.
 
-				var locationMappingIndex uint64
-				if tmpMappingIndex, exists := fileIDtoMapping[trace.files[i]]; exists {
-					locationMappingIndex = tmpMappingIndex
-				} else {
-					idx := uint64(len(fileIDtoMapping))
-					fileIDtoMapping[trace.files[i]] = idx
-					locationMappingIndex = idx
+				loc.MappingIndex = r.getNativeMappingIndex(nativeMappings, fileIDtoMapping,
+					stringMap, profile, trace.files[i], trace.linenos[i])
 
-					execInfo, exists := r.executables.Get(trace.files[i])
+				// Attribute native frames called from Python code to the extension
+				// module they belong to.
+				if isPythonTrace {
+					loc.Attributes = r.getPythonModuleAttributes(profile,
+						fileIDtoPythonModule, trace.files[i])
+				}
 
-					// Next step: Select a proper default value,
-					// if the name of the executable is not known yet.
-					var fileName = "UNKNOWN"
-					if exists {
-						fileName = execInfo.fileName
+				// Native frames of Go executables are symbolized by the agent
+				// from .gopclntab, so their source information might be known.
+				if fileIDInfo, exists := r.frames.Get(trace.files[i]); exists {
+					if si, exists := fileIDInfo[trace.linenos[i]]; exists {
+						loc.Line = append(loc.Line, &pprofextended.Line{
+							Line: int64(si.lineNumber),
+							FunctionIndex: createFunctionEntry(funcMap,
+								si.functionName, si.filePath),
+						})
 					}
-
-					profile.Mapping = append(profile.Mapping, &pprofextended.Mapping{
-						// Id - Optional element we do not use.
-						// MemoryStart - Optional element we do not use.
-						// MemoryLImit - Optional element we do not use.
-						FileOffset: uint64(trace.linenos[i]),
-						Filename:   int64(getStringMapIndex(stringMap, fileName)),
-						BuildId: int64(getStringMapIndex(stringMap,
-							trace.files[i].StringNoQuotes())),
-						BuildIdKind: *pprofextended.BuildIdKind_BUILD_ID_BINARY_HASH.Enum(),
-						// Attributes - Optional element we do not use.
-						// HasFunctions - Optional element we do not use.
-						// HasFilenames - Optional element we do not use.
-						// HasLineNumbers - Optional element we do not use.
-						// HasInlinedFrames - Optional element we do not use.
-					})
 				}
-				loc.MappingIndex = locationMappingIndex
 			case libpf.KernelFrame:
 				// Reconstruct frameID
 				frameID := libpf.NewFrameID(trace.files[i], trace.linenos[i])
@@ -621,8 +1029,34 @@ func (r *OTLPReporter) getProfile() (profile *pprofextended.Profile, startTS uin
 			profile.Location = append(profile.Location, loc)
 		}
 
+		if r.rootFrame && !isLostTrace {
+			// The root frame does not belong to an executable. To be compliant with the
+			// protocol generate a dummy mapping entry.
+			profile.Location = append(profile.Location, &pprofextended.Location{
+				TypeIndex: getStringMapIndex(stringMap, rootFrameType),
+				MappingIndex: getDummyMappingIndex(fileIDtoMapping, stringMap,
+					profile, libpf.FileID{}),
+				Line: []*pprofextended.Line{{
+					FunctionIndex: createFunctionEntry(funcMap,
+						rootFrameName(trace.comm, trace.executable), ""),
+				}},
+			})
+		}
+
 		sample.Label = getTraceLabels(stringMap, trace)
-		sample.LocationsLength = uint64(len(trace.frameTypes))
+		if key.tid != 0 {
+			sample.Label = append(sample.Label, &pprofextended.Label{
+				Key: int64(getStringMapIndex(stringMap, "thread.id")),
+				Num: int64(key.tid),
+			})
+		}
+		for _, name := range sortedLabelNames(sampleInfo.labels) {
+			sample.Label = append(sample.Label, &pprofextended.Label{
+				Key: int64(getStringMapIndex(stringMap, name)),
+				Str: int64(getStringMapIndex(stringMap, sampleInfo.labels[name])),
+			})
+		}
+		sample.LocationsLength = uint64(len(profile.Location)) - sample.LocationsStartIndex
 		locationIndex += sample.LocationsLength
 
 		profile.Sample = append(profile.Sample, sample)
@@ -721,6 +1155,23 @@ func getTraceLabels(stringMap map[string]uint32, i traceInfo) []*pprofextended.L
 		})
 	}
 
+	containerizedIdx := getStringMapIndex(stringMap, "process.containerized")
+	containerizedValueIdx := getStringMapIndex(stringMap, strconv.FormatBool(i.containerized))
+	labels = append(labels, &pprofextended.Label{
+		Key: int64(containerizedIdx),
+		Str: int64(containerizedValueIdx),
+	})
+
+	if i.coreType != "" {
+		coreTypeIdx := getStringMapIndex(stringMap, "cpu.core_type")
+		coreTypeValueIdx := getStringMapIndex(stringMap, i.coreType)
+
+		labels = append(labels, &pprofextended.Label{
+			Key: int64(coreTypeIdx),
+			Str: int64(coreTypeValueIdx),
+		})
+	}
+
 	if i.apmServiceName != "" {
 		apmServiceNameIdx := getStringMapIndex(stringMap, "apmServiceName")
 		apmServiceNameValueIdx := getStringMapIndex(stringMap, i.apmServiceName)
@@ -734,7 +1185,149 @@ func getTraceLabels(stringMap map[string]uint32, i traceInfo) []*pprofextended.L
 	return labels
 }
 
+// getMappingAttributes adds the device and inode numbers of the executable to the
+// AttributeTable of profile and returns the indices of the added attributes.
+func getMappingAttributes(profile *pprofextended.Profile, info execInfo) []uint64 {
+	if info.inode == 0 {
+		return nil
+	}
+
+	idx := uint64(len(profile.AttributeTable))
+	profile.AttributeTable = append(profile.AttributeTable,
+		&common.KeyValue{
+			Key: mappingDeviceAttr,
+			Value: &common.AnyValue{Value: &common.AnyValue_IntValue{
+				IntValue: int64(info.device)}},
+		},
+		&common.KeyValue{
+			Key: mappingInodeAttr,
+			Value: &common.AnyValue{Value: &common.AnyValue_IntValue{
+				IntValue: int64(info.inode)}},
+		})
+	return []uint64{idx, idx + 1}
+}
+
+// executableName returns the file name of the executable with the given FileID, if known.
+func (r *OTLPReporter) executableName(fileID libpf.FileID) (string, bool) {
+	execInfo, exists := r.executables.Get(fileID)
+	return execInfo.fileName, exists
+}
+
+// pythonExtensionModule returns the name of the Python extension module implemented by
+// the shared library with the given file name.
+func pythonExtensionModule(fileName string) (string, bool) {
+	matches := pythonExtensionRegex.FindStringSubmatch(fileName)
+	if matches == nil {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// getPythonModuleAttributes adds the name of the Python extension module implemented by the
+// executable fileID to the AttributeTable of profile and returns the index of the added
+// attribute. No attribute is added if the executable is not a Python extension module. The
+// result is cached in moduleAttributes for the profile being built.
+func (r *OTLPReporter) getPythonModuleAttributes(profile *pprofextended.Profile,
+	moduleAttributes map[libpf.FileID][]uint64, fileID libpf.FileID) []uint64 {
+	if indices, exists := moduleAttributes[fileID]; exists {
+		return indices
+	}
+
+	var indices []uint64
+	if execInfo, exists := r.executables.Get(fileID); exists {
+		if module, ok := pythonExtensionModule(execInfo.fileName); ok {
+			indices = []uint64{uint64(len(profile.AttributeTable))}
+			profile.AttributeTable = append(profile.AttributeTable, &common.KeyValue{
+				Key: pythonModuleAttr,
+				Value: &common.AnyValue{Value: &common.AnyValue_StringValue{
+					StringValue: module}},
+			})
+		}
+	}
+	moduleAttributes[fileID] = indices
+	return indices
+}
+
 // getDummyMappingIndex inserts or looks up a dummy entry for interpreted FileIDs.
+// lostFrameType is the frame type of the synthetic frames of lost samples.
+const lostFrameType = "lost"
+
+// lostFrameName returns the function name of the synthetic frame of count lost samples.
+func lostFrameName(count uint32) string {
+	return fmt.Sprintf("[lost %d samples]", count)
+}
+
+// rootFrameType is the frame type of the synthetic root frames.
+const rootFrameType = "root"
+
+// rootFrameName returns the function name of the synthetic root frame of the stacks of a
+// process, e.g. [nginx (nginx)].
+func rootFrameName(comm, executable string) string {
+	if executable == "" {
+		return "[" + comm + "]"
+	}
+	return "[" + comm + " (" + executable + ")]"
+}
+
+// nativeMappingKey identifies the Mapping of an executable segment of a native executable.
+type nativeMappingKey struct {
+	fileID libpf.FileID
+	// segment is the ELF virtual address of the segment start.
+	segment uint64
+}
+
+// getNativeMappingIndex returns the index of the Mapping for the native frame at the given
+// ELF virtual address of the executable with the given file ID, creating it if needed.
+// A Mapping is created for each executable segment, so that the file offset of a frame is
+// Location.Address - Mapping.MemoryStart + Mapping.FileOffset, like in pprof. This allows
+// symbolizing the frame by build ID and file offset, without knowing the ELF layout. The
+// first Mapping of each file is recorded in fileIDtoMapping.
+func (r *OTLPReporter) getNativeMappingIndex(nativeMappings map[nativeMappingKey]uint64,
+	fileIDtoMapping map[libpf.FileID]uint64, stringMap map[string]uint32,
+	profile *pprofextended.Profile, fileID libpf.FileID, address libpf.AddressOrLineno) uint64 {
+	execInfo, exists := r.executables.Get(fileID)
+	segment, inSegment := execInfo.addressMapper.Segment(uint64(address))
+
+	key := nativeMappingKey{fileID: fileID, segment: segment.Vaddr}
+	if idx, exists := nativeMappings[key]; exists {
+		return idx
+	}
+	idx := uint64(len(profile.Mapping))
+	nativeMappings[key] = idx
+	if _, exists := fileIDtoMapping[fileID]; !exists {
+		fileIDtoMapping[fileID] = idx
+	}
+
+	// Next step: Select a proper default value,
+	// if the name of the executable is not known yet.
+	var fileName = "UNKNOWN"
+	var attributes []uint64
+	if exists {
+		fileName = execInfo.fileName
+		attributes = getMappingAttributes(profile, execInfo)
+	}
+
+	mapping := &pprofextended.Mapping{
+		// Id - Optional element we do not use.
+		Filename: int64(getStringMapIndex(stringMap, fileName)),
+		BuildId: int64(getStringMapIndex(stringMap,
+			fileID.StringNoQuotes())),
+		BuildIdKind: *pprofextended.BuildIdKind_BUILD_ID_BINARY_HASH.Enum(),
+		Attributes:  attributes,
+		// HasFunctions - Optional element we do not use.
+		// HasFilenames - Optional element we do not use.
+		// HasLineNumbers - Optional element we do not use.
+		// HasInlinedFrames - Optional element we do not use.
+	}
+	if inSegment {
+		mapping.MemoryStart = segment.Vaddr
+		mapping.MemoryLimit = segment.Vaddr + segment.Size
+		mapping.FileOffset = segment.Offset
+	}
+	profile.Mapping = append(profile.Mapping, mapping)
+	return idx
+}
+
 func getDummyMappingIndex(fileIDtoMapping map[libpf.FileID]uint64,
 	stringMap map[string]uint32, profile *pprofextended.Profile,
 	fileID libpf.FileID) uint64 {
@@ -742,7 +1335,7 @@ func getDummyMappingIndex(fileIDtoMapping map[libpf.FileID]uint64,
 	if tmpMappingIndex, exists := fileIDtoMapping[fileID]; exists {
 		locationMappingIndex = tmpMappingIndex
 	} else {
-		idx := uint64(len(fileIDtoMapping))
+		idx := uint64(len(profile.Mapping))
 		fileIDtoMapping[fileID] = idx
 		locationMappingIndex = idx
 
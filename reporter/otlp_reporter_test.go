@@ -0,0 +1,351 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+	"github.com/elastic/otel-profiling-agent/testsupport"
+)
+
+func TestGetMappingAttributes(t *testing.T) {
+	profile := &pprofextended.Profile{}
+
+	// Without an inode no attributes are generated.
+	assert.Empty(t, getMappingAttributes(profile, execInfo{fileName: "foo"}))
+	assert.Empty(t, profile.AttributeTable)
+
+	indices := getMappingAttributes(profile, execInfo{device: 0xfd01, inode: 1234})
+	assert.Equal(t, []uint64{0, 1}, indices)
+	indices = getMappingAttributes(profile, execInfo{device: 0x801, inode: 42})
+	assert.Equal(t, []uint64{2, 3}, indices)
+
+	expected := []struct {
+		key   string
+		value int64
+	}{
+		{mappingDeviceAttr, 0xfd01},
+		{mappingInodeAttr, 1234},
+		{mappingDeviceAttr, 0x801},
+		{mappingInodeAttr, 42},
+	}
+	if assert.Len(t, profile.AttributeTable, len(expected)) {
+		for i, e := range expected {
+			assert.Equal(t, e.key, profile.AttributeTable[i].Key)
+			assert.Equal(t, e.value, profile.AttributeTable[i].Value.GetIntValue())
+		}
+	}
+}
+
+func TestPythonExtensionModule(t *testing.T) {
+	tests := map[string]struct {
+		module string
+		ok     bool
+	}{
+		"_ssl.cpython-311-x86_64-linux-gnu.so":          {"_ssl", true},
+		"_multiarray_umath.cpython-39-darwin.so":        {"_multiarray_umath", true},
+		"_cffi_backend.abi3.so":                         {"_cffi_backend", true},
+		"_sqlite3.cpython-38d-aarch64-linux-gnu.so":     {"_sqlite3", true},
+		"_cffi_backend.pypy39-pp73-x86_64-linux-gnu.so": {"_cffi_backend", true},
+		"libpython3.11.so.1.0":                          {"", false},
+		"libc.so.6":                                     {"", false},
+		"python3.11":                                    {"", false},
+		"foo.so":                                        {"", false},
+	}
+
+	for fileName, test := range tests {
+		module, ok := pythonExtensionModule(fileName)
+		assert.Equal(t, test.ok, ok, fileName)
+		assert.Equal(t, test.module, module, fileName)
+	}
+}
+
+func TestGetProfileGroupByThread(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+	for _, tid := range []libpf.PID{10, 11, 10} {
+		r.ReportCountForTrace(traceHash, &TraceEventMeta{
+			Timestamp: 1700000000,
+			Count:     1,
+			Comm:      "worker",
+			TID:       tid,
+			EventSet:  libpf.PrimaryEventSet,
+		})
+	}
+
+	profile, _, _ := r.getProfile()
+	threads := make(map[int64]int)
+	for _, s := range profile.Sample {
+		for _, label := range s.Label {
+			if profile.StringTable[label.Key] == "thread.id" {
+				threads[label.Num] += len(s.Timestamps)
+			}
+		}
+	}
+	assert.Equal(t, map[int64]int{10: 2, 11: 1}, threads)
+}
+
+func TestGetProfilesEventSets(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.eventSetTypes = []EventSetType{{Type: "cpu-clock", CPUTime: true},
+		{Type: "count", Unit: "faults"}}
+	r.cpuTimeWeights = true
+
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+	for _, eventSet := range []libpf.EventSet{libpf.SecondaryEventSet,
+		libpf.PrimaryEventSet, libpf.PrimaryEventSet} {
+		r.ReportCountForTrace(traceHash, &TraceEventMeta{
+			Timestamp: 1700000000,
+			Count:     1,
+			Comm:      "worker",
+			EventSet:  eventSet,
+			Weight:    1_000_000,
+		})
+	}
+
+	// The samples of each event set are reported as a separate profile.
+	profiles, _, _ := r.getProfiles()
+	require.Len(t, profiles, 2)
+	// Only the samples that stand for CPU time carry it.
+	for i, expected := range []struct {
+		sampleType, unit string
+		values           []int64
+	}{{"cpu-clock", "count", []int64{2, 2_000_000}}, {"count", "faults", []int64{1}}} {
+		profile := profiles[i]
+		require.Len(t, profile.SampleType, len(expected.values))
+		assert.Equal(t, expected.sampleType, profile.StringTable[profile.SampleType[0].Type])
+		assert.Equal(t, expected.unit, profile.StringTable[profile.SampleType[0].Unit])
+		require.Len(t, profile.Sample, 1)
+		assert.Equal(t, expected.values, profile.Sample[0].Value)
+	}
+}
+
+func TestGetProfileCPUTimeWeights(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.cpuTimeWeights = true
+
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+	for _, weight := range []uint64{10_000_000, 2_500_000} {
+		r.ReportCountForTrace(traceHash, &TraceEventMeta{
+			Timestamp: 1700000000,
+			Count:     1,
+			Weight:    weight,
+			Comm:      "worker",
+			EventSet:  libpf.PrimaryEventSet,
+		})
+	}
+
+	profile, _, _ := r.getProfile()
+	require.Len(t, profile.SampleType, 2)
+	assert.Equal(t, "samples", profile.StringTable[profile.SampleType[0].Type])
+	assert.Equal(t, "cpu", profile.StringTable[profile.SampleType[1].Type])
+	assert.Equal(t, "nanoseconds", profile.StringTable[profile.SampleType[1].Unit])
+	require.Len(t, profile.Sample, 1)
+	assert.Equal(t, []int64{2, 12_500_000}, profile.Sample[0].Value)
+}
+
+func TestGetResourceSessionID(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.addHostmetadata(map[string]string{"host:name": "test"})
+
+	attributes := make(map[string]string)
+	for _, kv := range r.getResource().Attributes {
+		attributes[kv.Key] = kv.Value.GetStringValue()
+	}
+	assert.Equal(t, map[string]string{"host:name": "test"}, attributes)
+
+	r.sessionID = "4d4e3f6e-3c0d-4d2c-9b4b-0d5c7e8ab1f2"
+	attributes = make(map[string]string)
+	for _, kv := range r.getResource().Attributes {
+		attributes[kv.Key] = kv.Value.GetStringValue()
+	}
+	assert.Equal(t, r.sessionID, attributes[sessionIDAttr])
+}
+
+func TestGetProfileRootFrame(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.rootFrame = true
+
+	trace := &libpf.Trace{Hash: libpf.NewTraceHash(1, 2)}
+	trace.AppendFrame(libpf.KernelFrame, libpf.NewFileID(3, 4), 0x1000)
+	r.ReportFramesForTrace(trace)
+	r.ReportCountForTrace(trace.Hash, &TraceEventMeta{
+		Timestamp:  1700000000,
+		Count:      1,
+		Comm:       "nginx: worker",
+		Executable: "nginx",
+		EventSet:   libpf.PrimaryEventSet,
+	})
+
+	profile, _, _ := r.getProfile()
+	if !assert.Len(t, profile.Sample, 1) || !assert.Len(t, profile.Location, 2) {
+		return
+	}
+	sample := profile.Sample[0]
+	assert.Equal(t, uint64(2), sample.LocationsLength)
+	// The root frame is the outermost frame of the stack.
+	root := profile.Location[sample.LocationsStartIndex+1]
+	assert.Equal(t, rootFrameType, profile.StringTable[root.TypeIndex])
+	fn := profile.Function[root.Line[0].FunctionIndex]
+	assert.Equal(t, "[nginx: worker (nginx)]", profile.StringTable[fn.Name])
+}
+
+func TestRootFrameName(t *testing.T) {
+	assert.Equal(t, "[bash (bash)]", rootFrameName("bash", "bash"))
+	assert.Equal(t, "[kworker/0:1]", rootFrameName("kworker/0:1", ""))
+}
+
+func TestReportLostSamples(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+	r.rootFrame = true
+	r.lostSamplesFrame = true
+
+	r.ReportLostSamples(1, 3)
+	r.ReportLostSamples(1, 4)
+	r.ReportLostSamples(2, 5)
+
+	profiles, _, _ := r.getProfiles()
+	if !assert.Len(t, profiles, 1) {
+		return
+	}
+	profile := profiles[0]
+	names := make(map[string]string)
+	for _, sample := range profile.Sample {
+		// The lost samples are not attributed to a process, so they have no root frame.
+		if !assert.Equal(t, uint64(1), sample.LocationsLength) {
+			return
+		}
+		loc := profile.Location[sample.LocationsStartIndex]
+		assert.Equal(t, lostFrameType, profile.StringTable[loc.TypeIndex])
+		fn := profile.Function[loc.Line[0].FunctionIndex]
+		for _, label := range sample.Label {
+			if profile.StringTable[label.Key] == "cpu" {
+				names[profile.StringTable[label.Str]] = profile.StringTable[fn.Name]
+			}
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"1": "[lost 7 samples]",
+		"2": "[lost 5 samples]",
+	}, names)
+
+	// The lost samples are accounted to the export they were lost before.
+	assert.Equal(t, int64(12), r.GetMetrics().LostSamples)
+	assert.Equal(t, int64(0), r.GetMetrics().LostSamples)
+}
+
+func TestGetProfileProcessLabels(t *testing.T) {
+	r, err := NewOTLPReporter()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+	for _, labels := range []map[string]string{
+		{"tenant": "acme", "team": "a"},
+		nil,
+		{"team": "a", "tenant": "acme"},
+	} {
+		r.ReportCountForTrace(traceHash, &TraceEventMeta{
+			Timestamp: 1700000000,
+			Count:     1,
+			Comm:      "worker",
+			EventSet:  libpf.PrimaryEventSet,
+			Labels:    labels,
+		})
+	}
+
+	// Samples of processes with different labels are kept apart.
+	profile, _, _ := r.getProfile()
+	counts := make(map[string]int)
+	for _, s := range profile.Sample {
+		key := ""
+		for _, label := range s.Label {
+			switch name := profile.StringTable[label.Key]; name {
+			case "tenant", "team":
+				key += name + "=" + profile.StringTable[label.Str] + ";"
+			}
+		}
+		counts[key] += len(s.Timestamps)
+	}
+	assert.Equal(t, map[string]int{"": 1, "team=a;tenant=acme;": 2}, counts)
+}
+
+func TestGetProfileNativeMappings(t *testing.T) {
+	exePath, err := testsupport.WriteTestExecutable2()
+	require.NoError(t, err)
+	defer os.Remove(exePath)
+	ef, err := pfelf.Open(exePath)
+	require.NoError(t, err)
+	defer ef.Close()
+
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+	exe, unknown := libpf.NewFileID(3, 4), libpf.NewFileID(5, 6)
+	r.ExecutableMetadata(context.Background(), exe, "exe", "", 0, 0, ef.GetAddressMapper())
+
+	trace := &libpf.Trace{Hash: libpf.NewTraceHash(1, 2)}
+	trace.AppendFrame(libpf.NativeFrame, exe, 0x401010)
+	trace.AppendFrame(libpf.NativeFrame, exe, 0x401020)
+	trace.AppendFrame(libpf.NativeFrame, unknown, 0x1234)
+	r.ReportFramesForTrace(trace)
+	r.ReportCountForTrace(trace.Hash, &TraceEventMeta{
+		Timestamp:  1700000000,
+		Count:      1,
+		Comm:       "exe",
+		Executable: "exe",
+		EventSet:   libpf.PrimaryEventSet,
+	})
+
+	profile, _, _ := r.getProfile()
+	require.Len(t, profile.Location, 3)
+	require.Len(t, profile.Mapping, 2)
+	// The file offset of a frame follows from its address and its mapping.
+	for i, offset := range []uint64{0x1010, 0x1020} {
+		loc := profile.Location[i]
+		m := profile.Mapping[loc.MappingIndex]
+		assert.Equal(t, "exe", profile.StringTable[m.Filename])
+		assert.Equal(t, offset, loc.Address-m.MemoryStart+m.FileOffset)
+		assert.True(t, loc.Address < m.MemoryLimit)
+	}
+	// Without the layout of the executable, the file offsets are not known.
+	m := profile.Mapping[profile.Location[2].MappingIndex]
+	assert.Equal(t, "UNKNOWN", profile.StringTable[m.Filename])
+	assert.Zero(t, m.MemoryStart)
+	assert.Zero(t, m.MemoryLimit)
+}
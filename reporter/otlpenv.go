@@ -0,0 +1,146 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// otlpEnvPrefix is the prefix of the standard OTLP exporter environment variables.
+	otlpEnvPrefix = "OTEL_EXPORTER_OTLP_"
+
+	// defaultOTLPGRPCPort is the port of endpoints given as URL without a port.
+	defaultOTLPGRPCPort = "4317"
+)
+
+// OTLPEnv is the configuration of the OTLP exporter by the standard OTEL_EXPORTER_OTLP_*
+// environment variables. The variables specific to profiles, e.g.
+// OTEL_EXPORTER_OTLP_PROFILES_ENDPOINT, take precedence over the generic ones, e.g.
+// OTEL_EXPORTER_OTLP_ENDPOINT.
+type OTLPEnv struct {
+	// Endpoint is the address of the collection agent in the format of host:port, or empty
+	// if it is not configured.
+	Endpoint string
+	// Insecure is set if TLS is disabled for the connection, and nil if this is not
+	// configured. Endpoints given as URL configure it by their scheme, otherwise it is taken
+	// from OTEL_EXPORTER_OTLP_INSECURE.
+	Insecure *bool
+	// Headers holds the headers that are sent with each request, if configured.
+	Headers map[string]string
+}
+
+// ParseOTLPEnv returns the configuration of the OTLP exporter by the environment variables,
+// which are looked up with lookup, e.g. os.LookupEnv. Variables with empty values are
+// treated as unset. Only the grpc protocol is supported.
+func ParseOTLPEnv(lookup func(string) (string, bool)) (*OTLPEnv, error) {
+	env := &OTLPEnv{}
+
+	if protocol, variable, ok := otlpEnvValue(lookup, "PROTOCOL"); ok && protocol != "grpc" {
+		return nil, fmt.Errorf("%s: unsupported protocol '%s', only grpc is supported",
+			variable, protocol)
+	}
+
+	if endpoint, variable, ok := otlpEnvValue(lookup, "ENDPOINT"); ok {
+		var err error
+		if env.Endpoint, env.Insecure, err = parseOTLPEndpoint(endpoint); err != nil {
+			return nil, fmt.Errorf("%s: %v", variable, err)
+		}
+	}
+
+	// The insecure setting only applies to endpoints that are given without scheme.
+	if insecure, variable, ok := otlpEnvValue(lookup, "INSECURE"); ok && env.Insecure == nil {
+		value, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value '%s'", variable, insecure)
+		}
+		env.Insecure = &value
+	}
+
+	if headers, variable, ok := otlpEnvValue(lookup, "HEADERS"); ok {
+		var err error
+		if env.Headers, err = parseOTLPHeaders(headers); err != nil {
+			return nil, fmt.Errorf("%s: %v", variable, err)
+		}
+	}
+
+	return env, nil
+}
+
+// otlpEnvValue returns the value of the profiles specific variable of the option name, or
+// otherwise of the generic one, together with the name of the variable it was taken from.
+func otlpEnvValue(lookup func(string) (string, bool),
+	name string) (value, variable string, ok bool) {
+	for _, variable = range []string{otlpEnvPrefix + "PROFILES_" + name, otlpEnvPrefix + name} {
+		if value, ok = lookup(variable); ok {
+			if value = strings.TrimSpace(value); value != "" {
+				return value, variable, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// parseOTLPEndpoint returns the host:port address of an endpoint. Endpoints given as URL
+// use TLS for the https scheme and disable it for the http scheme. The path of URLs is
+// ignored, as it has no meaning for gRPC. Endpoints without scheme are taken as host:port.
+func parseOTLPEndpoint(endpoint string) (addr string, insecure *bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		if _, _, err = net.SplitHostPort(endpoint); err != nil {
+			return "", nil, fmt.Errorf("invalid endpoint '%s': %v", endpoint, err)
+		}
+		return endpoint, nil, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid endpoint '%s': %v", endpoint, err)
+	}
+	var disableTLS bool
+	switch u.Scheme {
+	case "https":
+	case "http":
+		disableTLS = true
+	default:
+		return "", nil, fmt.Errorf("unsupported scheme '%s' of endpoint '%s'",
+			u.Scheme, endpoint)
+	}
+	if u.Hostname() == "" {
+		return "", nil, fmt.Errorf("endpoint '%s' has no host", endpoint)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultOTLPGRPCPort
+	}
+	return net.JoinHostPort(u.Hostname(), port), &disableTLS, nil
+}
+
+// parseOTLPHeaders parses headers in the format of a comma separated list of key=value
+// pairs, whose values may be percent-encoded, as in the W3C Baggage format.
+func parseOTLPHeaders(headers string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(headers, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header '%s'", pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of header '%s': %v", key, err)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOTLPEnv(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := map[string]struct {
+		env      map[string]string
+		expected OTLPEnv
+		hasError bool
+	}{
+		"unset": {},
+		"empty values": {
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "",
+				"OTEL_EXPORTER_OTLP_HEADERS":  " ",
+			},
+		},
+		"host and port": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4317"},
+			expected: OTLPEnv{Endpoint: "collector:4317"},
+		},
+		"host and port with insecure": {
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4317",
+				"OTEL_EXPORTER_OTLP_INSECURE": "true",
+			},
+			expected: OTLPEnv{Endpoint: "collector:4317", Insecure: &enabled},
+		},
+		"http URL": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector"},
+			expected: OTLPEnv{Endpoint: "collector:4317", Insecure: &enabled},
+		},
+		"https URL ignores insecure": {
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "https://[::1]:8443/v1/profiles",
+				"OTEL_EXPORTER_OTLP_INSECURE": "true",
+			},
+			expected: OTLPEnv{Endpoint: "[::1]:8443", Insecure: &disabled},
+		},
+		"profiles variables take precedence": {
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT":          "https://generic:4317",
+				"OTEL_EXPORTER_OTLP_PROFILES_ENDPOINT": "http://profiles:4317",
+				"OTEL_EXPORTER_OTLP_HEADERS":           "a=1,b=2",
+				"OTEL_EXPORTER_OTLP_PROFILES_HEADERS":  "c=3",
+				"OTEL_EXPORTER_OTLP_PROTOCOL":          "http/protobuf",
+				"OTEL_EXPORTER_OTLP_PROFILES_PROTOCOL": "grpc",
+			},
+			expected: OTLPEnv{
+				Endpoint: "profiles:4317",
+				Insecure: &enabled,
+				Headers:  map[string]string{"c": "3"},
+			},
+		},
+		"headers": {
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_HEADERS": " Authorization = Bearer%20abc , x-tenant=a%2Cb,,",
+			},
+			expected: OTLPEnv{Headers: map[string]string{
+				"Authorization": "Bearer abc",
+				"x-tenant":      "a,b",
+			}},
+		},
+		"header without value": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_HEADERS": "a=1,b"},
+			hasError: true,
+		},
+		"header without key": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_HEADERS": "=1"},
+			hasError: true,
+		},
+		"invalid header encoding": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_HEADERS": "a=%zz"},
+			hasError: true,
+		},
+		"unsupported protocol": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"},
+			hasError: true,
+		},
+		"unsupported scheme": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "unix:///run/otel.sock"},
+			hasError: true,
+		},
+		"missing port": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "collector"},
+			hasError: true,
+		},
+		"invalid insecure": {
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_INSECURE": "maybe"},
+			hasError: true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			env, err := ParseOTLPEnv(func(key string) (string, bool) {
+				value, ok := test.env[key]
+				return value, ok
+			})
+			if test.hasError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, *env)
+		})
+	}
+}
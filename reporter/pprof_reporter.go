@@ -0,0 +1,162 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+// PprofReporter collects profiling data like the OTLPReporter, but instead of sending it
+// to a backend, it writes all collected samples as a single profile in pprof format. This
+// is meant for ad-hoc profiling, e.g. of a single process for a fixed duration.
+//
+// Native frames are not symbolized by the agent, so they only carry the address and the
+// executable they belong to.
+type PprofReporter struct {
+	*OTLPReporter
+
+	// start is the time the profile starts at.
+	start time.Time
+	// period is the sampling period in nanoseconds.
+	period int64
+}
+
+// NewPprofReporter returns a PprofReporter for data sampled samplesPerSecond times a
+// second.
+func NewPprofReporter(c *Config, samplesPerSecond int) (*PprofReporter, error) {
+	r, err := NewOTLPReporter()
+	if err != nil {
+		return nil, err
+	}
+	if r.trimmer, err = newFrameTrimmer(c.TrimFrames); err != nil {
+		return nil, err
+	}
+	r.rootFrame = c.RootFrame
+	r.lostSamplesFrame = c.LostSamplesFrame
+	r.cpuTimeWeights = c.CPUTimeWeights
+	return &PprofReporter{
+		OTLPReporter: r,
+		start:        time.Now(),
+		period:       int64(time.Second) / int64(samplesPerSecond),
+	}, nil
+}
+
+// WriteProfile writes the samples collected so far as gzip compressed pprof profile to w.
+// Samples whose frames have not been reported yet are left out.
+func (r *PprofReporter) WriteProfile(w io.Writer) error {
+	profile, _, _ := r.getProfile()
+	data, err := proto.Marshal(toPprof(profile, r.start, time.Since(r.start), r.period))
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %v", err)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err = zw.Write(data); err != nil {
+		return fmt.Errorf("failed to compress profile: %v", err)
+	}
+	return zw.Close()
+}
+
+// toPprof converts an OTLP profile as returned by getProfile to a profile in pprof format.
+// The pprof format (perftools.profiles.Profile) is the subset of the OTLP profile messages
+// with the same field numbers, so the conversion only keeps the fields known to pprof and
+// turns sample location ranges and table indices into the IDs pprof uses.
+func toPprof(profile *pprofextended.Profile, start time.Time, duration time.Duration,
+	period int64) *pprofextended.Profile {
+	stringTable := append([]string(nil), profile.StringTable...)
+	addString := func(s string) int64 {
+		stringTable = append(stringTable, s)
+		return int64(len(stringTable) - 1)
+	}
+
+	// The samples carry their CPU time as last value, if the profile has a type for it.
+	weighted := len(profile.SampleType) == 2
+
+	out := &pprofextended.Profile{
+		SampleType: []*pprofextended.ValueType{{
+			Type: addString("samples"),
+			Unit: addString("count"),
+		}},
+		PeriodType: &pprofextended.ValueType{
+			Type: addString("cpu"),
+			Unit: addString("nanoseconds"),
+		},
+		Period:        period,
+		TimeNanos:     start.UnixNano(),
+		DurationNanos: duration.Nanoseconds(),
+		Sample:        make([]*pprofextended.Sample, 0, len(profile.Sample)),
+		Mapping:       make([]*pprofextended.Mapping, 0, len(profile.Mapping)),
+		Location:      make([]*pprofextended.Location, 0, len(profile.Location)),
+		Function:      make([]*pprofextended.Function, 0, len(profile.Function)),
+	}
+	if weighted {
+		out.SampleType = append(out.SampleType, &pprofextended.ValueType{
+			Type: addString("cpu"),
+			Unit: addString("nanoseconds"),
+		})
+	}
+
+	// IDs in pprof start at 1, as 0 marks an unset reference.
+	for _, s := range profile.Sample {
+		sample := &pprofextended.Sample{
+			LocationIndex: make([]uint64, 0, s.LocationsLength),
+			Value:         []int64{int64(len(s.Timestamps))},
+			Label:         s.Label,
+		}
+		if weighted {
+			sample.Value = append(sample.Value, s.Value[1])
+		}
+		for i := s.LocationsStartIndex; i < s.LocationsStartIndex+s.LocationsLength; i++ {
+			sample.LocationIndex = append(sample.LocationIndex,
+				uint64(profile.LocationIndices[i])+1)
+		}
+		out.Sample = append(out.Sample, sample)
+	}
+	for i, m := range profile.Mapping {
+		out.Mapping = append(out.Mapping, &pprofextended.Mapping{
+			Id:          uint64(i) + 1,
+			MemoryStart: m.MemoryStart,
+			MemoryLimit: m.MemoryLimit,
+			FileOffset:  m.FileOffset,
+			Filename:    m.Filename,
+			BuildId:     m.BuildId,
+		})
+	}
+	for i, loc := range profile.Location {
+		location := &pprofextended.Location{
+			Id:           uint64(i) + 1,
+			MappingIndex: loc.MappingIndex + 1,
+			Address:      loc.Address,
+			Line:         make([]*pprofextended.Line, 0, len(loc.Line)),
+		}
+		for _, line := range loc.Line {
+			location.Line = append(location.Line, &pprofextended.Line{
+				FunctionIndex: line.FunctionIndex + 1,
+				Line:          line.Line,
+			})
+		}
+		out.Location = append(out.Location, location)
+	}
+	for i, fn := range profile.Function {
+		out.Function = append(out.Function, &pprofextended.Function{
+			Id:         uint64(i) + 1,
+			Name:       fn.Name,
+			SystemName: fn.Name,
+			Filename:   fn.Filename,
+		})
+	}
+	out.StringTable = stringTable
+
+	return out
+}
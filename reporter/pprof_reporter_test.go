@@ -0,0 +1,82 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+func TestPprofReporterWriteProfile(t *testing.T) {
+	r, err := NewPprofReporter(&Config{}, 20)
+	require.NoError(t, err)
+
+	kernel, app := libpf.NewFileID(1, 1), libpf.NewFileID(2, 2)
+	trace := &libpf.Trace{Hash: libpf.NewTraceHash(1, 2)}
+	trace.AppendFrame(libpf.KernelFrame, kernel, 0x10)
+	trace.AppendFrame(libpf.NativeFrame, app, 0x1234)
+	r.ReportFramesForTrace(trace)
+	r.ReportFallbackSymbol(libpf.NewFrameID(kernel, 0x10), "do_syscall_64")
+	r.ExecutableMetadata(context.Background(), app, "app", "", 0, 0, pfelf.AddressMapper{})
+	for i := 0; i < 3; i++ {
+		r.ReportCountForTrace(trace.Hash, &TraceEventMeta{
+			Timestamp: 1700000000,
+			Count:     1,
+			Comm:      "app",
+			EventSet:  libpf.PrimaryEventSet,
+		})
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteProfile(&buf))
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	var profile pprofextended.Profile
+	require.NoError(t, proto.Unmarshal(data, &profile))
+
+	str := func(idx int64) string { return profile.StringTable[idx] }
+	require.Len(t, profile.SampleType, 1)
+	assert.Equal(t, "samples", str(profile.SampleType[0].Type))
+	assert.Equal(t, int64(50000000), profile.Period)
+
+	require.Len(t, profile.Sample, 1)
+	sample := profile.Sample[0]
+	assert.Equal(t, []int64{3}, sample.Value)
+	require.Len(t, sample.LocationIndex, 2)
+
+	// Location, mapping and function references are IDs starting at 1.
+	location := func(id uint64) *pprofextended.Location {
+		require.NotZero(t, id)
+		loc := profile.Location[id-1]
+		require.Equal(t, id, loc.Id)
+		return loc
+	}
+	leaf := location(sample.LocationIndex[0])
+	require.Len(t, leaf.Line, 1)
+	fn := profile.Function[leaf.Line[0].FunctionIndex-1]
+	assert.Equal(t, leaf.Line[0].FunctionIndex, fn.Id)
+	assert.Equal(t, "do_syscall_64", str(fn.Name))
+
+	native := location(sample.LocationIndex[1])
+	assert.Equal(t, uint64(0x1234), native.Address)
+	mapping := profile.Mapping[native.MappingIndex-1]
+	assert.Equal(t, native.MappingIndex, mapping.Id)
+	assert.Equal(t, "app", str(mapping.Filename))
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// proxyDialer dials connections to the collection agent, through the proxy selected for
+// the address of the collection agent.
+type proxyDialer struct {
+	proxyFunc func(*url.URL) (*url.URL, error)
+	dialer    net.Dialer
+}
+
+// newProxyDialer returns a proxyDialer using the proxy configured with the HTTPS_PROXY
+// and NO_PROXY environment variables. A non-empty override replaces HTTPS_PROXY.
+func newProxyDialer(override string) (*proxyDialer, error) {
+	config := httpproxy.FromEnvironment()
+	if override != "" {
+		u, err := url.Parse(override)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %v", override, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+		}
+		config.HTTPSProxy = override
+	}
+	return &proxyDialer{proxyFunc: config.ProxyFunc()}, nil
+}
+
+// DialContext connects to addr directly or through the proxy, if one applies to it. The
+// connection carries the gRPC traffic, which is encrypted end-to-end if TLS is enabled.
+func (d *proxyDialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	// gRPC connections are treated like HTTPS requests to select the proxy, so that
+	// NO_PROXY and the localhost exemption apply to them.
+	proxyURL, err := d.proxyFunc(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select proxy for %s: %v", addr, err)
+	}
+	if proxyURL == nil {
+		return d.dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		socks, err := proxy.FromURL(proxyURL, &d.dialer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS proxy %s: %v", proxyURL.Redacted(), err)
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	case "http", "https":
+		return d.dialConnect(ctx, proxyURL, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s", proxyURL.Scheme)
+	}
+}
+
+// dialConnect opens a tunnel to addr through the HTTP proxy with a CONNECT request.
+func (d *proxyDialer) dialConnect(ctx context.Context, proxyURL *url.URL,
+	addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := d.dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %v", proxyAddr, err)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: proxyURL.Hostname(),
+		})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed TLS handshake with proxy %s: %v", proxyAddr, err)
+		}
+		conn = tlsConn
+	}
+
+	// Abort the CONNECT request if the context is done before the tunnel is set up.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString(
+			[]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy %s: %v", proxyAddr, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy %s: %v",
+			proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s",
+			proxyAddr, addr, resp.Status)
+	}
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn is a net.Conn whose reads are served first from the data the proxy sent
+// after the CONNECT response, which is buffered in r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
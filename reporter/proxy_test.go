@@ -0,0 +1,115 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startConnectProxy starts an HTTP proxy which answers CONNECT requests with status, and
+// then greets and echoes the client. The requests it received are sent to requests.
+func startConnectProxy(t *testing.T, status int) (addr string, requests <-chan *http.Request) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		reqs <- req
+		// The greeting is sent together with the response, to check that data
+		// buffered while reading the response is not lost.
+		_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\nhello", status,
+			http.StatusText(status))
+		_, _ = io.Copy(conn, br)
+	}()
+	return l.Addr().String(), reqs
+}
+
+func TestProxyDialerConnect(t *testing.T) {
+	proxyAddr, requests := startConnectProxy(t, http.StatusOK)
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+
+	d, err := newProxyDialer("http://user:secret@" + proxyAddr)
+	require.NoError(t, err)
+	conn, err := d.DialContext(context.Background(), "collector.example:4317")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := <-requests
+	assert.Equal(t, http.MethodConnect, req.Method)
+	assert.Equal(t, "collector.example:4317", req.Host)
+	assert.Equal(t, "Basic dXNlcjpzZWNyZXQ=", req.Header.Get("Proxy-Authorization"))
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf = buf[:4]
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestProxyDialerEnvironment(t *testing.T) {
+	proxyAddr, requests := startConnectProxy(t, http.StatusForbidden)
+	t.Setenv("HTTPS_PROXY", "http://"+proxyAddr)
+	t.Setenv("NO_PROXY", "")
+
+	d, err := newProxyDialer("")
+	require.NoError(t, err)
+	_, err = d.DialContext(context.Background(), "collector.example:4317")
+	assert.ErrorContains(t, err, "refused CONNECT")
+	assert.Equal(t, "collector.example:4317", (<-requests).Host)
+}
+
+func TestProxyDialerNoProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	// The proxy is not reachable, so the connection only succeeds if it is not used.
+	t.Setenv("NO_PROXY", "127.0.0.1")
+	d, err := newProxyDialer("http://proxy.invalid:3128")
+	require.NoError(t, err)
+	conn, err := d.DialContext(context.Background(), l.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestNewProxyDialerInvalid(t *testing.T) {
+	_, err := newProxyDialer("ftp://proxy:21")
+	assert.Error(t, err)
+	_, err = newProxyDialer("socks5://proxy:1080")
+	assert.NoError(t, err)
+}
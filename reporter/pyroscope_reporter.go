@@ -0,0 +1,298 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/debug/log"
+	"github.com/elastic/otel-profiling-agent/hostmetadata/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+// pyroscopeSeriesLabels maps the host metadata that is added to the labels of the series
+// of the profiles sent to Pyroscope to the names of these labels. Other host metadata is
+// left out, as each distinct set of labels forms a new series.
+var pyroscopeSeriesLabels = map[string]string{
+	host.KeyHostname:      "hostname",
+	host.KeyMachine:       "machine",
+	host.KeyKernelVersion: "kernel_version",
+}
+
+// PyroscopeReporter collects profiling data like the OTLPReporter, but sends it to the
+// ingest API of Pyroscope (or Grafana Phlare) in pprof format instead of to an OTLP
+// collector. Each reporting interval the samples of each event set are sent as separate
+// profile.
+type PyroscopeReporter struct {
+	*OTLPReporter
+
+	// client sends the requests to Pyroscope.
+	client *http.Client
+	// ingestURL is the URL of the ingest endpoint, without query parameters.
+	ingestURL *url.URL
+	// appName is the application name of the series the profiles are sent to.
+	appName string
+	// period is the sampling period in nanoseconds.
+	period int64
+}
+
+// StartPyroscope sets up and manages the reporting of data sampled samplesPerSecond times
+// a second to the Pyroscope server at c.PyroscopeURL.
+func StartPyroscope(mainCtx context.Context, c *Config,
+	samplesPerSecond int) (*PyroscopeReporter, error) {
+	ingestURL, err := url.Parse(c.PyroscopeURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pyroscope URL %s: %v", c.PyroscopeURL, err)
+	}
+	if ingestURL.Scheme != "http" && ingestURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme of Pyroscope URL %s", c.PyroscopeURL)
+	}
+	ingestURL = ingestURL.JoinPath("ingest")
+
+	dialer, err := newProxyDialer(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewOTLPReporter()
+	if err != nil {
+		return nil, err
+	}
+	if r.trimmer, err = newFrameTrimmer(c.TrimFrames); err != nil {
+		close(r.stopSignal)
+		return nil, err
+	}
+	r.sessionID = c.SessionID
+	r.rootFrame = c.RootFrame
+	r.lostSamplesFrame = c.LostSamplesFrame
+	r.eventSetTypes = c.EventSetTypes
+	r.cpuTimeWeights = c.CPUTimeWeights
+
+	appName := c.PyroscopeAppName
+	if appName == "" {
+		appName = "otel-profiling-agent"
+	}
+	pr := &PyroscopeReporter{
+		OTLPReporter: r,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+					return dialer.DialContext(ctx, addr)
+				},
+			},
+			Timeout: c.Times.GRPCOperationTimeout(),
+		},
+		ingestURL: ingestURL,
+		appName:   appName,
+		period:    int64(time.Second) / int64(samplesPerSecond),
+	}
+
+	ctx, cancelReporting := context.WithCancel(mainCtx)
+	go func() {
+		defer cancelReporting()
+		tick := time.NewTicker(c.Times.ReportInterval())
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.stopSignal:
+				return
+			case <-tick.C:
+				if _, err := pr.export(ctx); err != nil {
+					log.Errorf("Request failed: %v", err)
+				}
+				tick.Reset(libpf.AddJitter(c.Times.ReportInterval(), 0.2))
+			}
+		}
+	}()
+
+	return pr, nil
+}
+
+// Flush immediately sends out the samples collected so far instead of waiting for the
+// reporting interval, and returns the number of samples sent.
+func (r *PyroscopeReporter) Flush(ctx context.Context) (int, error) {
+	return r.export(ctx)
+}
+
+// export sends out the profiles of the samples collected so far and returns the number
+// of samples sent.
+func (r *PyroscopeReporter) export(ctx context.Context) (int, error) {
+	r.exportMu.Lock()
+	defer r.exportMu.Unlock()
+
+	eventSetProfiles, startTS, endTS := r.getProfiles()
+	if len(eventSetProfiles) == 0 {
+		log.Debugf("Skip sending of Pyroscope profile with no samples")
+		r.exports.succeeded(time.Now())
+		return 0, nil
+	}
+
+	start := time.Unix(int64(startTS), 0)
+	// The timestamps have a resolution of seconds, so the profile spans at least one.
+	until := time.Unix(int64(endTS)+1, 0)
+	numSamples := 0
+	for _, profile := range eventSetProfiles {
+		eventType := ""
+		if len(r.eventSetTypes) > 1 && len(profile.SampleType) > 0 {
+			eventType = profile.StringTable[profile.SampleType[0].Type]
+		}
+		out := toPprof(profile, start, until.Sub(start), r.period)
+		sanitizeLabelKeys(out)
+		if err := r.send(ctx, out, r.seriesName(eventType), start, until); err != nil {
+			return numSamples, err
+		}
+		for _, sample := range profile.Sample {
+			numSamples += len(sample.Timestamps)
+		}
+	}
+	r.exports.succeeded(time.Now())
+	return numSamples, nil
+}
+
+// send posts profile as series name for the time span from start to until to the ingest
+// endpoint.
+func (r *PyroscopeReporter) send(ctx context.Context, profile *pprofextended.Profile,
+	name string, start, until time.Time) error {
+	data, err := proto.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %v", err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(part)
+	if _, err = zw.Write(data); err != nil {
+		return fmt.Errorf("failed to compress profile: %v", err)
+	}
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress profile: %v", err)
+	}
+	if err = mw.Close(); err != nil {
+		return err
+	}
+
+	u := *r.ingestURL
+	u.User = nil
+	u.RawQuery = url.Values{
+		"name":   {name},
+		"from":   {strconv.FormatInt(start.Unix(), 10)},
+		"until":  {strconv.FormatInt(until.Unix(), 10)},
+		"format": {"pprof"},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if user := r.ingestURL.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pyroscope responded with %s: %s", resp.Status,
+			strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// seriesName returns the name of the series in Pyroscope syntax, app{label=value,...},
+// the profiles of eventType are sent to. The labels are taken from the host metadata, and
+// an event label is added if eventType is not empty.
+func (r *PyroscopeReporter) seriesName(eventType string) string {
+	labels := make([]string, 0, len(pyroscopeSeriesLabels)+1)
+	for key, label := range pyroscopeSeriesLabels {
+		if value, ok := r.hostmetadata.Get(key); ok && value != "" {
+			labels = append(labels, label+"="+sanitizeLabelValue(value))
+		}
+	}
+	if eventType != "" {
+		labels = append(labels, "event="+sanitizeLabelValue(eventType))
+	}
+	sort.Strings(labels)
+	return r.appName + "{" + strings.Join(labels, ",") + "}"
+}
+
+// sanitizeLabelKey replaces the characters of key that are not allowed in the names of
+// Pyroscope labels with underscores.
+func sanitizeLabelKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// sanitizeLabelValue replaces the characters of value that delimit labels in the
+// Pyroscope series syntax with underscores.
+func sanitizeLabelValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '{', '}', ',', '=':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// sanitizeLabelKeys renames the labels of the samples of profile, as returned by toPprof,
+// whose keys are not valid names of Pyroscope labels.
+func sanitizeLabelKeys(profile *pprofextended.Profile) {
+	renamed := make(map[int64]int64)
+	for _, sample := range profile.Sample {
+		labels := make([]*pprofextended.Label, 0, len(sample.Label))
+		for _, label := range sample.Label {
+			key, ok := renamed[label.Key]
+			if !ok {
+				key = label.Key
+				name := profile.StringTable[label.Key]
+				if sanitized := sanitizeLabelKey(name); sanitized != name {
+					profile.StringTable = append(profile.StringTable, sanitized)
+					key = int64(len(profile.StringTable) - 1)
+				}
+				renamed[label.Key] = key
+			}
+			// The labels are shared with the profile the pprof profile was created from,
+			// so they are copied instead of being modified.
+			labels = append(labels, &pprofextended.Label{
+				Key:     key,
+				Str:     label.Str,
+				Num:     label.Num,
+				NumUnit: label.NumUnit,
+			})
+		}
+		sample.Label = labels
+	}
+}
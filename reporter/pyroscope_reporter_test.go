@@ -0,0 +1,150 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/profiles/v1/alternatives/pprofextended"
+)
+
+type testTimes struct{}
+
+func (testTimes) ReportInterval() time.Duration         { return time.Hour }
+func (testTimes) ReportMetricsInterval() time.Duration  { return time.Hour }
+func (testTimes) GRPCConnectionTimeout() time.Duration  { return time.Second }
+func (testTimes) GRPCOperationTimeout() time.Duration   { return 5 * time.Second }
+func (testTimes) GRPCStartupBackoffTime() time.Duration { return time.Second }
+func (testTimes) GRPCAuthErrorDelay() time.Duration     { return time.Second }
+
+func TestPyroscopeReporterExport(t *testing.T) {
+	type request struct {
+		query    url.Values
+		user     string
+		password string
+		profile  *pprofextended.Profile
+	}
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{query: r.URL.Query(), profile: &pprofextended.Profile{}}
+		req.user, req.password, _ = r.BasicAuth()
+		if r.URL.Path != "/pyroscope/ingest" {
+			http.NotFound(w, r)
+			return
+		}
+		f, _, err := r.FormFile("profile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(zr)
+		if err == nil {
+			err = proto.Unmarshal(data, req.profile)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- req
+	}))
+	defer server.Close()
+	t.Setenv("HTTPS_PROXY", "")
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("user", "secret")
+	u.Path = "/pyroscope"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := StartPyroscope(ctx, &Config{
+		PyroscopeURL:     u.String(),
+		PyroscopeAppName: "test",
+		Times:            testTimes{},
+	}, 20)
+	require.NoError(t, err)
+	defer r.Stop()
+	r.addHostmetadata(map[string]string{
+		"host:hostname": "node-1",
+		"host:machine":  "x86_64",
+		"host:cpu/0":    "ignored",
+	})
+
+	traceHash := libpf.NewTraceHash(1, 2)
+	r.ReportFramesForTrace(&libpf.Trace{Hash: traceHash})
+	for _, ts := range []libpf.UnixTime32{1700000000, 1700000002} {
+		r.ReportCountForTrace(traceHash, &TraceEventMeta{
+			Timestamp: ts,
+			Count:     1,
+			Comm:      "worker",
+			TID:       10,
+			EventSet:  libpf.PrimaryEventSet,
+			Labels:    map[string]string{"app.tier": "web"},
+		})
+	}
+
+	n, err := r.Flush(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	req := <-requests
+	assert.Equal(t, "test{hostname=node-1,machine=x86_64}", req.query.Get("name"))
+	assert.Equal(t, "1700000000", req.query.Get("from"))
+	assert.Equal(t, "1700000003", req.query.Get("until"))
+	assert.Equal(t, "pprof", req.query.Get("format"))
+	assert.Equal(t, "user", req.user)
+	assert.Equal(t, "secret", req.password)
+
+	profile := req.profile
+	assert.Equal(t, int64(time.Second/20), profile.Period)
+	require.Len(t, profile.Sample, 1)
+	labels := make(map[string]string)
+	for _, label := range profile.Sample[0].Label {
+		if label.Str != 0 {
+			labels[profile.StringTable[label.Key]] = profile.StringTable[label.Str]
+		} else {
+			labels[profile.StringTable[label.Key]] = "num"
+		}
+	}
+	assert.Equal(t, "web", labels["app_tier"])
+	assert.Contains(t, labels, "thread_id")
+	assert.NotContains(t, labels, "thread.id")
+}
+
+func TestPyroscopeSeriesName(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+	pr := &PyroscopeReporter{OTLPReporter: r, appName: "app"}
+	assert.Equal(t, "app{}", pr.seriesName(""))
+
+	r.addHostmetadata(map[string]string{"host:kernel_version": "6.1.0{x=1,y}"})
+	assert.Equal(t, "app{event=cache-misses,kernel_version=6.1.0_x_1_y_}",
+		pr.seriesName("cache-misses"))
+}
+
+func TestSanitizeLabelKey(t *testing.T) {
+	assert.Equal(t, "thread_id", sanitizeLabelKey("thread.id"))
+	assert.Equal(t, "k8s_pod_name", sanitizeLabelKey("k8s-pod/name"))
+	assert.Equal(t, "tenant_1", sanitizeLabelKey("tenant_1"))
+}
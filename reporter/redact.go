@@ -0,0 +1,74 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redactionRule replaces the matches of pattern with replacement.
+type redactionRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Redactor rewrites symbol names and file paths with regular expression based replacements
+// before they are handed to the reporters, e.g. to remove internal project names or user
+// directories from the exported profiles. A nil Redactor leaves all values unchanged.
+type Redactor struct {
+	rules []redactionRule
+}
+
+// NewRedactor creates a Redactor from rules in the format REGEX=REPLACEMENT. The
+// replacement may refer to submatches of the regular expression like $1, as described for
+// regexp.Regexp.Expand, and may be empty to remove the matches. The rules are applied in
+// order, each one to the result of the previous one. Without rules, nil is returned.
+func NewRedactor(rules []string) (*Redactor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{rules: make([]redactionRule, 0, len(rules))}
+	for _, rule := range rules {
+		expr, replacement, ok := strings.Cut(rule, "=")
+		if !ok || expr == "" {
+			return nil, fmt.Errorf("invalid redaction rule '%s', expected REGEX=REPLACEMENT",
+				rule)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression of redaction rule '%s': %v",
+				rule, err)
+		}
+		r.rules = append(r.rules, redactionRule{pattern: pattern, replacement: replacement})
+	}
+	return r, nil
+}
+
+// Redact returns s with all rules applied.
+func (r *Redactor) Redact(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, rule := range r.rules {
+		s = rule.pattern.ReplaceAllString(s, rule.replacement)
+	}
+	return s
+}
+
+// redactMeta returns meta with the executable name redacted. meta is shared with the
+// caller, so it is copied if the name changes.
+func (r *Redactor) redactMeta(meta *TraceEventMeta) *TraceEventMeta {
+	executable := r.Redact(meta.Executable)
+	if executable == meta.Executable {
+		return meta
+	}
+	redacted := *meta
+	redacted.Executable = executable
+	return &redacted
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	otlpcollector "github.com/elastic/otel-profiling-agent/proto/experiments/opentelemetry/proto/collector/profiles/v1"
+)
+
+// recordingClient is a ProfilesServiceClient that records the encoded export requests.
+type recordingClient struct {
+	requests [][]byte
+}
+
+func (c *recordingClient) Export(_ context.Context,
+	in *otlpcollector.ExportProfilesServiceRequest,
+	_ ...grpc.CallOption) (*otlpcollector.ExportProfilesServiceResponse, error) {
+	data, err := proto.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	c.requests = append(c.requests, data)
+	return &otlpcollector.ExportProfilesServiceResponse{}, nil
+}
+
+func TestNewRedactor(t *testing.T) {
+	tests := map[string]struct {
+		rules    []string
+		input    string
+		expected string
+		fail     bool
+	}{
+		"no rules": {
+			input:    "acme::Billing",
+			expected: "acme::Billing",
+		},
+		"replacement": {
+			rules:    []string{`acme[A-Za-z]*=[redacted]`},
+			input:    "acmeCorp::Billing::charge",
+			expected: "[redacted]::Billing::charge",
+		},
+		"submatch": {
+			rules:    []string{`^/home/[^/]+/(.*)$=/home/user/$1`},
+			input:    "/home/jdoe/src/app.py",
+			expected: "/home/user/src/app.py",
+		},
+		"empty replacement": {
+			rules:    []string{`\.internal=`},
+			input:    "billing.internal.charge",
+			expected: "billing.charge",
+		},
+		"rules in order": {
+			rules:    []string{`secret=hidden`, `hidden=gone`},
+			input:    "secret_fn",
+			expected: "gone_fn",
+		},
+		"missing replacement": {
+			rules: []string{`acme`},
+			fail:  true,
+		},
+		"empty regex": {
+			rules: []string{`=x`},
+			fail:  true,
+		},
+		"invalid regex": {
+			rules: []string{`acme(=x`},
+			fail:  true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			redactor, err := NewRedactor(test.rules)
+			if test.fail {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, redactor.Redact(test.input))
+		})
+	}
+}
+
+func TestRedactingMultiExport(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+	r.rootFrame = true
+	client := &recordingClient{}
+	r.client = client
+
+	redactor, err := NewRedactor([]string{`acme[A-Za-z]*=[redacted]`,
+		`/home/[^/]+/=/home/user/`})
+	require.NoError(t, err)
+	multi := NewRedactingMulti(redactor, r)
+
+	nativeID := libpf.NewFileID(1, 1)
+	pythonID := libpf.NewFileID(2, 2)
+	kernelID := libpf.NewFileID(3, 3)
+	multi.ExecutableMetadata(context.Background(), nativeID, "libacmecrypto.so", "build",
+		0, 0, pfelf.AddressMapper{})
+	multi.FrameMetadata(pythonID, 0x10, 42, 0, "acmeBilling.charge",
+		"/home/jdoe/acme/billing.py")
+	multi.ReportFallbackSymbol(libpf.NewFrameID(kernelID, 0x20), "acme_driver_ioctl")
+
+	trace := &libpf.Trace{Hash: libpf.NewTraceHash(1, 2)}
+	trace.AppendFrame(libpf.KernelFrame, kernelID, 0x20)
+	trace.AppendFrame(libpf.PythonFrame, pythonID, 0x10)
+	trace.AppendFrame(libpf.NativeFrame, nativeID, 0x30)
+	multi.ReportFramesForTrace(trace)
+	meta := &TraceEventMeta{
+		Timestamp:  1700000000,
+		Count:      1,
+		Comm:       "worker",
+		Executable: "acmed",
+		EventSet:   libpf.PrimaryEventSet,
+	}
+	multi.ReportCountForTrace(trace.Hash, meta)
+	// The metadata is shared with the caller and must not be modified.
+	assert.Equal(t, "acmed", meta.Executable)
+
+	n, err := r.reportOTLPProfile(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, client.requests, 1)
+	wire := client.requests[0]
+	for _, original := range []string{"acme", "jdoe"} {
+		assert.False(t, bytes.Contains(wire, []byte(original)),
+			"%s was exported", original)
+	}
+	for _, redacted := range []string{"lib[redacted].so", "[redacted].charge",
+		"/home/user/[redacted]/billing.py", "[redacted]_driver_ioctl",
+		"[worker ([redacted])]"} {
+		assert.True(t, bytes.Contains(wire, []byte(redacted)),
+			"%s was not exported", redacted)
+	}
+}
@@ -23,8 +23,18 @@ import (
 	"time"
 
 	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 )
 
+// EventSetType is the sample type of the profiles of a perf event set.
+type EventSetType struct {
+	// Type and Unit are those of the sample counts, e.g. cpu-clock and count.
+	Type, Unit string
+	// CPUTime is set if the samples of the set stand for CPU time, e.g. those of a clock
+	// event, which is then reported with CPUTimeWeights.
+	CPUTime bool
+}
+
 // HostMetadata holds metadata about the host.
 type HostMetadata struct {
 	Metadata  map[string]string
@@ -61,8 +71,45 @@ type Config struct {
 	FallbackSymbolsMaxQueue uint32
 	// Disable secure communication with Collection Agent
 	DisableTLS bool
+	// Headers holds the headers that are sent as gRPC metadata with each request to the
+	// collection agent, e.g. for authentication.
+	Headers map[string]string
+	// Proxy is the URL of the proxy the connection to the collection agent is made
+	// through. If empty, the proxy is taken from the HTTPS_PROXY environment variable.
+	// NO_PROXY applies in both cases.
+	Proxy string
 	// Number of connection attempts to the collector after which we give up retrying
 	MaxGRPCRetries uint32
+	// TrimFrames holds patterns for the file names of libraries whose consecutive native
+	// frames are collapsed into a single placeholder frame. Only used by the OTLP reporter.
+	TrimFrames []string
+	// SessionID identifies this run of the agent and is reported as resource attribute
+	// of the exported profiles. Only used by the OTLP reporter.
+	SessionID string
+	// RootFrame enables adding a synthetic root frame with the name of the process to each
+	// stack, so that all stacks of a process share a common root. Only used by the OTLP
+	// reporter.
+	RootFrame bool
+	// EventSetTypes holds the sample types of the profiles of each perf event set, indexed
+	// by libpf.EventSet. Only used by the OTLP reporter, which reports the samples of each
+	// set as separate profile.
+	EventSetTypes []EventSetType
+	// CPUTimeWeights enables reporting the CPU time the samples stand for, as given by
+	// their weight, in addition to their count. Only used by the OTLP reporter.
+	CPUTimeWeights bool
+	// LostSamplesFrame enables reporting the samples lost in the kernel as a synthetic
+	// sample of each CPU, with a single frame named [lost N samples]. Only used by the OTLP
+	// reporter.
+	LostSamplesFrame bool
+	// PyroscopeURL is the base URL of the Pyroscope server the profiles are sent to
+	// instead of the collection agent. Only used by the Pyroscope reporter.
+	PyroscopeURL string
+	// PyroscopeAppName is the application name of the series the profiles are sent to in
+	// Pyroscope. Only used by the Pyroscope reporter.
+	PyroscopeAppName string
+	// FlameGraphDir is the directory flame graphs of the profiles are written to instead of
+	// sending them to the collection agent. Only used by the flame graph reporter.
+	FlameGraphDir string
 
 	Times Times
 }
@@ -103,11 +150,13 @@ type executableMetadata struct {
 	fileID   libpf.FileID
 	filename string
 	buildID  string
+	device   uint64
+	inode    uint64
 }
 
 // ExecutableMetadata implements the SymbolReporter interface.
 func (r *GRPCReporter) ExecutableMetadata(ctx context.Context, fileID libpf.FileID,
-	fileName, buildID string) {
+	fileName, buildID string, device, inode uint64, _ pfelf.AddressMapper) {
 	select {
 	case <-ctx.Done():
 		return
@@ -116,6 +165,8 @@ func (r *GRPCReporter) ExecutableMetadata(ctx context.Context, fileID libpf.File
 			fileID:   fileID,
 			filename: fileName,
 			buildID:  buildID,
+			device:   device,
+			inode:    inode,
 		})
 	}
 }
@@ -135,15 +186,22 @@ func (r *GRPCReporter) FrameMetadata(fileID libpf.FileID,
 }
 
 // ReportCountForTrace implements the TraceReporter interface.
-func (r *GRPCReporter) ReportCountForTrace(traceHash libpf.TraceHash, timestamp libpf.UnixTime32,
-	count uint16, comm, podName, containerName string) {
+func (r *GRPCReporter) ReportCountForTrace(traceHash libpf.TraceHash, meta *TraceEventMeta) {
+	if meta.EventSet != libpf.PrimaryEventSet {
+		// The protocol of the collection agent has no notion of profile types, so the
+		// samples of the secondary event set would be counted as CPU samples.
+		return
+	}
 	r.countsForTracesQueue.append(&libpf.TraceAndCounts{
 		Hash:          traceHash,
-		Timestamp:     timestamp,
-		Count:         count,
-		Comm:          comm,
-		PodName:       podName,
-		ContainerName: containerName,
+		Timestamp:     meta.Timestamp,
+		Count:         meta.Count,
+		Comm:          meta.Comm,
+		PodName:       meta.PodName,
+		ContainerName: meta.ContainerName,
+		Containerized: meta.Containerized,
+		CoreType:      meta.CoreType,
+		TID:           meta.TID,
 	})
 }
 
@@ -0,0 +1,176 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// LiveFrame is a frame of a LiveSample. Native frames are symbolized in the backend, so
+// they are identified by their executable and address, unless the agent knows their
+// source information, e.g. from .gopclntab.
+type LiveFrame struct {
+	// Type is the type of the frame, e.g. native or python.
+	Type string `json:"type"`
+	// Function is the name of the function of the frame, if known.
+	Function string `json:"function,omitempty"`
+	// File is the source file of the function, if known.
+	File string `json:"file,omitempty"`
+	// Line is the source line of the frame, if known.
+	Line int64 `json:"line,omitempty"`
+	// Executable is the file name of the executable of native frames, if known.
+	Executable string `json:"executable,omitempty"`
+	// Address is the address of native and kernel frames.
+	Address uint64 `json:"address,omitempty"`
+}
+
+// LiveSample is a sample as it is streamed to subscribers, see OTLPReporter.Subscribe.
+// The frames are ordered from the innermost to the outermost one.
+type LiveSample struct {
+	Timestamp     time.Time         `json:"timestamp"`
+	Count         uint16            `json:"count"`
+	Comm          string            `json:"comm"`
+	Executable    string            `json:"executable,omitempty"`
+	PodName       string            `json:"pod_name,omitempty"`
+	ContainerName string            `json:"container_name,omitempty"`
+	TID           libpf.PID         `json:"tid,omitempty"`
+	EventSet      libpf.EventSet    `json:"event_set"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Frames        []LiveFrame       `json:"frames"`
+}
+
+// Subscription receives the samples reported after it was created, until it is closed.
+type Subscription struct {
+	// C receives the samples. It is closed by Close.
+	C <-chan LiveSample
+
+	samples chan LiveSample
+	stream  *sampleStream
+	// dropped counts the samples that were dropped as C was full.
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of samples that were dropped, because the consumer did not
+// keep up with receiving them.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close ends the subscription and closes C.
+func (s *Subscription) Close() {
+	s.stream.unsubscribe(s)
+}
+
+// sampleStream distributes the reported samples to the subscriptions.
+type sampleStream struct {
+	// numSubscriptions is the number of subscriptions. It avoids building samples for
+	// each reported sample if there are none.
+	numSubscriptions atomic.Int32
+	// dropped counts the samples dropped for all subscriptions since it was last reset.
+	dropped atomic.Int64
+
+	mu            sync.RWMutex
+	subscriptions libpf.Set[*Subscription]
+}
+
+// subscribe adds a subscription whose channel buffers up to buffer samples.
+func (s *sampleStream) subscribe(buffer int) *Subscription {
+	samples := make(chan LiveSample, buffer)
+	sub := &Subscription{C: samples, samples: samples, stream: s}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions == nil {
+		s.subscriptions = make(libpf.Set[*Subscription])
+	}
+	s.subscriptions[sub] = libpf.Void{}
+	s.numSubscriptions.Store(int32(len(s.subscriptions)))
+	return sub
+}
+
+// unsubscribe removes sub and closes its channel, unless this already happened.
+func (s *sampleStream) unsubscribe(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[sub]; !ok {
+		return
+	}
+	delete(s.subscriptions, sub)
+	s.numSubscriptions.Store(int32(len(s.subscriptions)))
+	close(sub.samples)
+}
+
+// hasSubscriptions returns true if there are subscriptions to publish samples to.
+func (s *sampleStream) hasSubscriptions() bool {
+	return s.numSubscriptions.Load() != 0
+}
+
+// publish sends sample to all subscriptions. Samples are dropped for subscriptions whose
+// channel is full, so that slow consumers do not hold up the reporting.
+func (s *sampleStream) publish(sample LiveSample) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for sub := range s.subscriptions {
+		select {
+		case sub.samples <- sample:
+		default:
+			sub.dropped.Add(1)
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe returns a subscription that receives the samples reported from now on, with
+// their frames symbolized as far as known to the agent. Up to buffer samples are buffered
+// for the subscription, and further samples are dropped until the consumer catches up.
+// The subscription has to be closed once it is no longer used.
+func (r *OTLPReporter) Subscribe(buffer int) *Subscription {
+	return r.stream.subscribe(buffer)
+}
+
+// liveSample returns the sample of trace with meta as it is streamed to subscriptions.
+func (r *OTLPReporter) liveSample(trace *traceInfo, meta *TraceEventMeta) LiveSample {
+	sample := LiveSample{
+		Timestamp:     time.Unix(int64(meta.Timestamp), 0),
+		Count:         meta.Count,
+		Comm:          trace.comm,
+		Executable:    trace.executable,
+		PodName:       trace.podName,
+		ContainerName: trace.containerName,
+		TID:           meta.TID,
+		EventSet:      meta.EventSet,
+		Labels:        meta.Labels,
+		Frames:        make([]LiveFrame, 0, len(trace.frameTypes)),
+	}
+
+	for i, frameType := range trace.frameTypes {
+		frame := LiveFrame{Type: frameType.String()}
+		fileID, lineno := trace.files[i], trace.linenos[i]
+		switch frameType {
+		case libpf.NativeFrame:
+			frame.Address = uint64(lineno)
+			frame.Executable, _ = r.executableName(fileID)
+		case libpf.KernelFrame:
+			frame.Address = uint64(lineno)
+			frame.File = "vmlinux"
+			frame.Function, _ = r.fallbackSymbols.Get(libpf.NewFrameID(fileID, lineno))
+		}
+		if fileIDInfo, exists := r.frames.Get(fileID); exists {
+			if si, exists := fileIDInfo[lineno]; exists {
+				frame.Function = si.functionName
+				frame.File = si.filePath
+				frame.Line = int64(si.lineNumber)
+			}
+		}
+		sample.Frames = append(sample.Frames, frame)
+	}
+	return sample
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestSubscribe(t *testing.T) {
+	r, err := NewOTLPReporter()
+	require.NoError(t, err)
+
+	kernel, python := libpf.NewFileID(1, 2), libpf.NewFileID(3, 4)
+	r.ReportFallbackSymbol(libpf.NewFrameID(kernel, 0x10), "do_syscall_64")
+	r.FrameMetadata(python, 7, 42, 0, "handle", "app.py")
+	trace := &libpf.Trace{Hash: libpf.NewTraceHash(5, 6)}
+	trace.AppendFrame(libpf.KernelFrame, kernel, 0x10)
+	trace.AppendFrame(libpf.PythonFrame, python, 7)
+	trace.AppendFrame(libpf.NativeFrame, libpf.NewFileID(7, 8), 0x1234)
+	r.ReportFramesForTrace(trace)
+
+	// Samples reported before the subscription are not streamed.
+	r.ReportCountForTrace(trace.Hash, &TraceEventMeta{
+		Timestamp:  1700000000,
+		Count:      1,
+		Comm:       "python3",
+		Executable: "python3",
+		TID:        10,
+		EventSet:   libpf.PrimaryEventSet,
+	})
+	sub := r.Subscribe(1)
+	for i := 0; i < 3; i++ {
+		r.ReportCountForTrace(trace.Hash, &TraceEventMeta{
+			Timestamp:  1700000001,
+			Count:      1,
+			Comm:       "python3",
+			Executable: "python3",
+			TID:        10,
+			EventSet:   libpf.PrimaryEventSet,
+			Labels:     map[string]string{"team": "a"},
+		})
+	}
+
+	// The samples that do not fit into the buffer are dropped.
+	sample := <-sub.C
+	assert.Equal(t, uint64(2), sub.Dropped())
+	assert.Equal(t, int64(2), r.GetMetrics().LiveSamplesDropped)
+	assert.Equal(t, int64(1700000001), sample.Timestamp.Unix())
+	assert.Equal(t, "python3", sample.Comm)
+	assert.Equal(t, libpf.PID(10), sample.TID)
+	assert.Equal(t, map[string]string{"team": "a"}, sample.Labels)
+	assert.Equal(t, []LiveFrame{
+		{Type: "kernel", Function: "do_syscall_64", File: "vmlinux", Address: 0x10},
+		{Type: "python", Function: "handle", File: "app.py", Line: 42},
+		{Type: "native", Address: 0x1234},
+	}, sample.Frames)
+
+	sub.Close()
+	_, ok := <-sub.C
+	assert.False(t, ok)
+	sub.Close()
+	assert.False(t, r.stream.hasSubscriptions())
+}
@@ -0,0 +1,211 @@
+// This file contains the code and map definitions for the BEAM (Erlang) tracer
+
+#include "bpfdefs.h"
+#include "tracemgmt.h"
+#include "tsd.h"
+#include "types.h"
+
+// Map from BEAM process IDs to a structure containing addresses of variables
+// we require in order to build the stack trace
+bpf_map_def SEC("maps") beam_procs = {
+  .type = BPF_MAP_TYPE_HASH,
+  .key_size = sizeof(pid_t),
+  .value_size = sizeof(BEAMProcInfo),
+  .max_entries = 1024,
+};
+
+// The number of Erlang stack words to inspect per frame-unwinding eBPF program.
+// If we start running out of instructions in the walk_beam_stack program, one
+// option is to adjust this number downwards.
+#define WORDS_PER_WALK_BEAM_STACK 96
+
+// The primary tag of an Erlang term is held in its lowest two bits. Continuation
+// pointers (return addresses) pushed to the Erlang stack are word aligned pointers
+// into the loaded code, and thus carry the header tag 0.
+// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/erl_term.h#L67
+#define BEAM_TAG_PRIMARY_MASK   0x3
+#define BEAM_TAG_PRIMARY_HEADER 0x0
+
+// Record a BEAM frame
+static inline __attribute__((__always_inline__))
+ErrorCode push_beam(Trace *trace, u64 code_ptr) {
+  return _push(trace, 0, code_ptr, FRAME_MARKER_BEAM);
+}
+
+// get_current_process retrieves the Process struct of the Erlang process executed by
+// the scheduler running on the current thread.
+//
+// ERTS stores the ErtsSchedulerData of the scheduler threads with pthread_setspecific
+// using the key stored in the global variable erts_esd_key.
+static inline __attribute__((__always_inline__))
+ErrorCode get_current_process(struct pt_regs *ctx, const BEAMProcInfo *beaminfo,
+                              const void **process) {
+  void *tsd_base;
+  if (tsd_get_base(ctx, &tsd_base)) {
+    DEBUG_PRINT("beam: failed to get TSD base address");
+    increment_metric(metricID_UnwindBEAMErrReadTsdBase);
+    return ERR_BEAM_READ_TSD_BASE;
+  }
+
+  int key;
+  if (bpf_probe_read(&key, sizeof(key), (void *)beaminfo->esd_key_addr)) {
+    DEBUG_PRINT("beam: failed to read erts_esd_key from 0x%lx",
+      (unsigned long) beaminfo->esd_key_addr);
+    increment_metric(metricID_UnwindBEAMErrBadEsdKeyAddr);
+    return ERR_BEAM_BAD_ESD_KEY_ADDR;
+  }
+
+  void *esdp;
+  if (tsd_read(&beaminfo->tsdInfo, tsd_base, key, &esdp)) {
+    increment_metric(metricID_UnwindBEAMErrReadSchedulerData);
+    return ERR_BEAM_READ_SCHEDULER_DATA;
+  }
+
+  *process = NULL;
+  if (!esdp) {
+    // Not a scheduler thread.
+    return ERR_OK;
+  }
+
+  if (bpf_probe_read(process, sizeof(*process), esdp + beaminfo->current_process)) {
+    DEBUG_PRINT("beam: failed to read current process");
+    increment_metric(metricID_UnwindBEAMErrReadSchedulerData);
+    return ERR_BEAM_READ_SCHEDULER_DATA;
+  }
+  return ERR_OK;
+}
+
+// walk_beam_stack pushes the code pointers found on the Erlang stack of a process to
+// user space for symbolization.
+//
+// BEAM unwinder workflow:
+// The ErtsSchedulerData [0] of the scheduler running the sampled thread points to the
+// Process [1] it currently executes. The Process holds the instruction pointer of the
+// emulator (i) and the Erlang stack (stop to hend), on which the emulator pushes the
+// continuation pointers of the calling functions. Both point into the loaded code and
+// are mapped to module:function/arity in user space.
+//
+// The emulator keeps its registers in machine registers while it executes Erlang code,
+// and only saves them to the Process struct when it leaves the emulator loop, e.g. to
+// call a BIF, to garbage collect or to schedule out. The frames thus reflect the state
+// of the most recent of these events.
+//
+// [0] ErtsSchedulerData
+// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/erl_process.h#L650
+//
+// [1] Process
+// https://github.com/erlang/otp/blob/OTP-26.2/erts/emulator/beam/erl_process.h#L1009
+static inline __attribute__((__always_inline__))
+ErrorCode walk_beam_stack(PerCPURecord *record, int *next_unwinder) {
+  Trace *trace = &record->trace;
+  const u64 *stack_ptr = record->beamUnwindState.stack_ptr;
+  const u64 *stack_end = record->beamUnwindState.stack_end;
+
+  *next_unwinder = get_next_unwinder_after_interpreter(record);
+
+#pragma unroll
+  for (u32 i = 0; i < WORDS_PER_WALK_BEAM_STACK; ++i) {
+    if (stack_ptr >= stack_end) {
+      // We have processed the whole Erlang stack and can stop here.
+      unwinder_mark_done(record, PROG_UNWIND_BEAM);
+      return ERR_OK;
+    }
+
+    u64 word;
+    if (bpf_probe_read(&word, sizeof(word), stack_ptr)) {
+      DEBUG_PRINT("beam: failed to read stack at 0x%lx", (unsigned long) stack_ptr);
+      increment_metric(metricID_UnwindBEAMErrReadProcess);
+      return ERR_BEAM_READ_PROCESS;
+    }
+    stack_ptr++;
+
+    if (word == 0 || (word & BEAM_TAG_PRIMARY_MASK) != BEAM_TAG_PRIMARY_HEADER) {
+      // Not a continuation pointer, but e.g. a saved variable.
+      continue;
+    }
+
+    ErrorCode error = push_beam(trace, word);
+    if (error) {
+      DEBUG_PRINT("beam: failed to push frame");
+      return error;
+    }
+    increment_metric(metricID_UnwindBEAMFrames);
+  }
+  *next_unwinder = PROG_UNWIND_BEAM;
+
+  // Store the current progress in the BEAM unwind state so we can continue walking the
+  // stack after the tail call.
+  record->beamUnwindState.stack_ptr = stack_ptr;
+  record->beamUnwindState.stack_end = stack_end;
+
+  return ERR_OK;
+}
+
+SEC("perf_event/unwind_beam")
+int unwind_beam(struct pt_regs *ctx) {
+  PerCPURecord *record = get_per_cpu_record();
+  if (!record)
+    return -1;
+
+  int unwinder = get_next_unwinder_after_interpreter(record);
+  ErrorCode error = ERR_OK;
+  Trace *trace = &record->trace;
+  u32 pid = trace->pid;
+  BEAMProcInfo *beaminfo = bpf_map_lookup_elem(&beam_procs, &pid);
+  if (!beaminfo) {
+    DEBUG_PRINT("No BEAM introspection data");
+    error = ERR_BEAM_NO_PROC_INFO;
+    increment_metric(metricID_UnwindBEAMErrNoProcInfo);
+    goto exit;
+  }
+
+  if (!record->beamUnwindState.stack_ptr) {
+    increment_metric(metricID_UnwindBEAMAttempts);
+
+    const void *process;
+    error = get_current_process(ctx, beaminfo, &process);
+    if (error) {
+      goto exit;
+    }
+    if (!process) {
+      DEBUG_PRINT("beam: no current process");
+      unwinder_mark_done(record, PROG_UNWIND_BEAM);
+      goto exit;
+    }
+
+    u64 i;
+    if (bpf_probe_read(&record->beamUnwindState.stack_ptr,
+          sizeof(record->beamUnwindState.stack_ptr), process + beaminfo->process_stop) ||
+        bpf_probe_read(&record->beamUnwindState.stack_end,
+          sizeof(record->beamUnwindState.stack_end), process + beaminfo->process_hend) ||
+        bpf_probe_read(&i, sizeof(i), process + beaminfo->process_i)) {
+      DEBUG_PRINT("beam: failed to read process");
+      increment_metric(metricID_UnwindBEAMErrReadProcess);
+      error = ERR_BEAM_READ_PROCESS;
+      goto exit;
+    }
+
+    if (!record->beamUnwindState.stack_ptr) {
+      // The process has no Erlang stack (yet).
+      unwinder_mark_done(record, PROG_UNWIND_BEAM);
+      goto exit;
+    }
+
+    // The instruction pointer is the innermost frame, the continuation pointers on
+    // the stack are its callers.
+    if (i) {
+      error = push_beam(trace, i);
+      if (error) {
+        goto exit;
+      }
+      increment_metric(metricID_UnwindBEAMFrames);
+    }
+  }
+
+  error = walk_beam_stack(record, &unwinder);
+
+exit:
+  record->state.unwind_error = error;
+  tail_call(ctx, unwinder);
+  return -1;
+}
@@ -33,7 +33,7 @@
   }
 
   static inline u64 bpf_get_current_pid_tgid(void) {
-    return __cgo_ctx->id;
+    return __cgo_ctx->id | __cgo_ctx->tid;
   }
 
   static inline void *bpf_map_lookup_elem(bpf_map_def *map, const void *key) {
@@ -59,6 +59,10 @@
     return -1;
   }
 
+  static inline u32 bpf_get_prandom_u32(void) {
+    return 0;
+  }
+
 #else // TESTING_COREDUMP
 
 // Native eBPF build
@@ -88,6 +92,8 @@ static int (*bpf_perf_event_output)(void *ctx, void *map, unsigned long long fla
     (void *)BPF_FUNC_perf_event_output;
 static int (*bpf_get_stackid)(void *ctx, void *map, u64 flags) =
     (void *)BPF_FUNC_get_stackid;
+static u32 (*bpf_get_prandom_u32)(void) =
+    (void *)BPF_FUNC_get_prandom_u32;
 
 __attribute__ ((format (printf, 1, 3)))
 static int (*bpf_trace_printk)(const char *fmt, int fmt_size, ...) =
@@ -64,6 +64,9 @@ typedef enum ErrorCode {
   // Python: Unable to determine the base address for thread-specific data
   ERR_PYTHON_READ_TSD_BASE = 2008,
 
+  // Python: Unable to read the thread states of the interpreter
+  ERR_PYTHON_BAD_THREAD_STATE_LIST_ADDR = 2009,
+
   // Ruby: No entry for this process exists in the Ruby process info array
   ERR_RUBY_NO_PROC_INFO = 3000,
 
@@ -88,6 +91,12 @@ typedef enum ErrorCode {
   // Ruby: Unable to read the instruction sequence size
   ERR_RUBY_READ_ISEQ_SIZE = 3007,
 
+  // Ruby: Unable to determine the base address for thread-local data
+  ERR_RUBY_READ_TSD_BASE = 3008,
+
+  // Ruby: Unable to read the execution context from thread-local data
+  ERR_RUBY_READ_TLS = 3009,
+
   // Native: Unable to find the code section in the stack delta page info map
   ERR_NATIVE_LOOKUP_TEXT_SECTION = 4000,
 
@@ -139,6 +148,9 @@ typedef enum ErrorCode {
   // Native: Code is running in ARM 32-bit compat mode.
   ERR_NATIVE_AARCH64_32BIT_COMPAT_MODE = 4016,
 
+  // Native: Unwind instructions requested RBX based unwinding mid-trace
+  ERR_NATIVE_RBX_UNWINDING_MID_TRACE = 4018,
+
   // V8: Encountered a bad frame pointer during V8 unwinding
   ERR_V8_BAD_FP = 5000,
 
@@ -146,7 +158,22 @@ typedef enum ErrorCode {
   ERR_V8_BAD_JS_FUNC = 5001,
 
   // V8: No entry for this process exists in the V8 process info array
-  ERR_V8_NO_PROC_INFO = 5002
+  ERR_V8_NO_PROC_INFO = 5002,
+
+  // BEAM: No entry for this process exists in the BEAM process info array
+  ERR_BEAM_NO_PROC_INFO = 6000,
+
+  // BEAM: Unable to determine the base address for thread-specific data
+  ERR_BEAM_READ_TSD_BASE = 6001,
+
+  // BEAM: Unable to read erts_esd_key
+  ERR_BEAM_BAD_ESD_KEY_ADDR = 6002,
+
+  // BEAM: Unable to read the scheduler data of the current thread
+  ERR_BEAM_READ_SCHEDULER_DATA = 6003,
+
+  // BEAM: Unable to read the current process or its stack
+  ERR_BEAM_READ_PROCESS = 6004
 } ErrorCode;
 
 #endif // OPTI_ERRORS_H
@@ -22,6 +22,7 @@ extern bpf_map_def system_config;
 // References to maps in alphabetical order that
 // are needed only for testing.
 
+extern bpf_map_def beam_procs;
 extern bpf_map_def exe_id_to_8_stack_deltas;
 extern bpf_map_def exe_id_to_9_stack_deltas;
 extern bpf_map_def exe_id_to_10_stack_deltas;
@@ -41,6 +42,8 @@ extern bpf_map_def kernel_stackmap;
 extern bpf_map_def perl_procs;
 extern bpf_map_def php_procs;
 extern bpf_map_def php_jit_procs;
+extern bpf_map_def pid_filter;
+extern bpf_map_def pid_sample_weights;
 extern bpf_map_def ptregs_size;
 extern bpf_map_def py_procs;
 extern bpf_map_def ruby_procs;
@@ -31,6 +31,8 @@
 #define FRAME_MARKER_V8            0x8
 // Indicates a PHP JIT frame
 #define FRAME_MARKER_PHP_JIT       0x9
+// Indicates a BEAM (Erlang) frame
+#define FRAME_MARKER_BEAM          0xA
 
 // Indicates a frame containing information about a critical unwinding error
 // that caused further unwinding to be aborted.
@@ -260,7 +260,7 @@ ErrorCode hotspot_handle_interpreter(UnwindState *state,Trace *trace,
   u64 method = regs[FP_OFFS - 3];
   ui->sp = regs[FP_OFFS - 1];
   ui->fp = regs[FP_OFFS];
-  ui->pc = regs[FP_OFFS + 1];
+  ui->pc = normalize_pac_ptr(regs[FP_OFFS + 1]);
 
   // Convert Byte Code Pointer (BCP) to Byte Code Index (BCI); that is, convert the pointer to
   // be offset of the byte code. Mainly to reduce the amount needed for this data from 64-bits
@@ -683,7 +683,7 @@ ErrorCode hotspot_execute_unwind_action(CodeBlobInfo *cbi, HotspotUnwindAction a
     case UA_UNWIND_REGS: {
       u64 frame[2];
       bpf_probe_read(frame, sizeof(frame), (void *) (ui->sp - sizeof(frame)));
-      ui->pc = frame[1];
+      ui->pc = normalize_pac_ptr(frame[1]);
       if (cbi->frame_size >= sizeof(frame)) {
         DEBUG_PRINT("jvm:  -> recover fp");
         ui->fp = frame[0];
@@ -115,8 +115,21 @@ struct task_struct;
 # error "Unsupported architecture"
 #endif
 
+// Defined in include/uapi/linux/bpf_perf_event.h. The registers are the user_pt_regs of
+// the architecture, which on arm64 are only the first fields of struct pt_regs.
 struct bpf_perf_event_data {
+#if defined(__x86_64)
   struct pt_regs regs;
+#elif defined(__aarch64__)
+  struct {
+    u64 regs[31];
+    u64 sp;
+    u64 pc;
+    u64 pstate;
+  } regs;
+#endif
+  u64 sample_period;
+  u64 addr;
 };
 
 // The following works with clang and gcc.
@@ -78,6 +78,25 @@ bpf_map_def SEC("maps") kernel_stackmap = {
   .max_entries = 16*1024,
 };
 
+// pid_filter contains the PIDs that are allowed to be profiled. Filtering is only active
+// if the map contains the key 0, which never is a valid PID for a user space process.
+bpf_map_def SEC("maps") pid_filter = {
+  .type = BPF_MAP_TYPE_HASH,
+  .key_size = sizeof(u32),
+  .value_size = sizeof(bool),
+  .max_entries = 65536,
+};
+
+// pid_sample_weights contains the sample weights of the PIDs that are sampled more often
+// than other processes. The key 0 holds the factor the sampling frequency is raised by,
+// and is only present if there are sample weights.
+bpf_map_def SEC("maps") pid_sample_weights = {
+  .type = BPF_MAP_TYPE_HASH,
+  .key_size = sizeof(u32),
+  .value_size = sizeof(u32),
+  .max_entries = 1024,
+};
+
 #if defined(__aarch64__)
 // This contains the cached value of the pt_regs size structure as established by the
 // get_arm64_ptregs_size function
@@ -95,30 +114,6 @@ ErrorCode push_native(Trace *trace, u64 file, u64 line) {
   return _push(trace, file, line, FRAME_MARKER_NATIVE);
 }
 
-#ifdef __aarch64__
-// Strips the PAC tag from a pointer.
-//
-// While all pointers can contain PAC tags, we only apply this function to code pointers, because
-// that's where normalization is required to make the stack delta lookups work. Note that if that
-// should ever change, we'd need a different mask for the data pointers, because it might diverge
-// from the mask for code pointers.
-static inline u64 normalize_pac_ptr(u64 ptr) {
-  // Retrieve PAC mask from the system config.
-  u32 key = 0;
-  SystemConfig* syscfg = bpf_map_lookup_elem(&system_config, &key);
-  if (!syscfg) {
-    // Unreachable: array maps are always fully initialized.
-    return ptr;
-  }
-
-  // Mask off PAC bits. Since we're always applying this to usermode pointers that should have all
-  // the high bits set to 0, we don't need to consider the case of having to fill up the resulting
-  // hole with 1s (like we'd have to for kernel ptrs).
-  ptr &= syscfg->inverse_pac_mask;
-  return ptr;
-}
-#endif
-
 // A single step for the bsearch into the big_stack_deltas array. This is really a textbook bsearch
 // step, built in a way to update the value of *lo and *hi. This function will be called repeatedly
 // (since we cannot do loops). The return value signals whether the bsearch came to an end / found
@@ -308,6 +303,10 @@ u64 unwind_register_address(UnwindState *state, u64 cfa, u8 opcode, s32 param) {
     }
 
     return state->lr;
+#elif defined(__x86_64__)
+  case UNWIND_OPCODE_BASE_RBX:
+    addr = state->rbx;
+    break;
 #endif
   default:
     return 0;
@@ -333,6 +332,9 @@ u64 unwind_register_address(UnwindState *state, u64 cfa, u8 opcode, s32 param) {
   case UNWIND_OPCODE_BASE_SP | UNWIND_OPCODEF_DEREF:
     DEBUG_PRINT("unwind: *(sp+%d)+%d", preDeref, postDeref);
     break;
+  case UNWIND_OPCODE_BASE_RBX:
+    DEBUG_PRINT("unwind: rbx+%d", preDeref);
+    break;
   }
 #endif
 
@@ -368,6 +370,25 @@ u64 unwind_register_address(UnwindState *state, u64 cfa, u8 opcode, s32 param) {
 // is marked with UNWIND_COMMAND_STOP which marks entry points (main function,
 // thread spawn function, signal handlers, ...).
 #if defined(__x86_64__)
+// verify_frame_pointer checks the CFA that the stack delta of a frame resolved to against the
+// frame pointer. It is only called for frames whose stack delta restores the caller's FP from
+// the standard frame record below the return address, where a frame pointer unwind would find
+// the CFA at FP+16. The topmost frame and frames interrupted by signals are skipped, as their PC
+// may be in a prologue or epilogue that has not set up FP yet.
+//
+// Mismatches point to wrong stack deltas, but can also come from code that saves RBP as general
+// purpose register in the same slot, so this is only a hint to look at the stack deltas of the
+// executable.
+static inline void verify_frame_pointer(const UnwindState *state, u64 cfa) {
+  increment_metric(metricID_UnwindNativeVerifiedFrames);
+  if (state->fp + 16 != cfa) {
+    DEBUG_PRINT("Stack delta mismatch at 0x%lx: cfa=0x%lx fp=0x%lx",
+                (unsigned long) state->text_section_offset,
+                (unsigned long) cfa, (unsigned long) state->fp);
+    increment_metric(metricID_UnwindNativeVerifyMismatches);
+  }
+}
+
 static ErrorCode unwind_one_frame(u64 pid, u32 frame_idx, UnwindState *state, bool* stop) {
   *stop = false;
 
@@ -404,6 +425,8 @@ static ErrorCode unwind_one_frame(u64 pid, u32 frame_idx, UnwindState *state, bo
       }
       state->r13 = rt_regs[5];
       state->fp = rt_regs[10];
+      state->rbx = rt_regs[11];
+      state->rbx_valid = true;
       state->sp = rt_regs[15];
       state->pc = rt_regs[16];
       goto frame_ok;
@@ -429,11 +452,24 @@ static ErrorCode unwind_one_frame(u64 pid, u32 frame_idx, UnwindState *state, bo
       }
     }
 
+    if (info->opcode == UNWIND_OPCODE_BASE_RBX && !state->rbx_valid) {
+      // The CFA of the lazy binding trampoline of the dynamic linker is based on RBX.
+      // Callee-saved registers other than RBP are not recovered during unwinding, so
+      // the value of RBX is only known in the topmost frame or after a signal frame.
+      increment_metric(metricID_UnwindNativeErrRbxUnwindingMidTrace);
+      return ERR_NATIVE_RBX_UNWINDING_MID_TRACE;
+    }
+
     // Resolve the frame's CFA (previous PC is fixed to CFA) address, and
     // the previous FP address if any.
     cfa = unwind_register_address(state, 0, info->opcode, param);
     u64 fpa = unwind_register_address(state, cfa, info->fpOpcode, info->fpParam);
 
+    // RBX is only valid in the topmost frame and after signal frames, whose FP is not checked.
+    if (state->verify_unwind && !state->rbx_valid && cfa && fpa == cfa - 16) {
+      verify_frame_pointer(state, cfa);
+    }
+
     if (fpa) {
       bpf_probe_read(&state->fp, sizeof(state->fp), (void*)fpa);
     } else if (info->opcode == UNWIND_OPCODE_BASE_FP) {
@@ -448,6 +484,7 @@ static ErrorCode unwind_one_frame(u64 pid, u32 frame_idx, UnwindState *state, bo
     return ERR_NATIVE_PC_READ;
   }
   state->sp = cfa;
+  state->rbx_valid = false;
 frame_ok:
   increment_metric(metricID_UnwindNativeFrames);
   return ERR_OK;
@@ -585,6 +622,8 @@ static inline void copy_state_regs(UnwindState *state, struct pt_regs *regs)
   state->sp = regs->sp;
   state->fp = regs->bp;
   state->r13 = regs->r13;
+  state->rbx = regs->bx;
+  state->rbx_valid = true;
 #elif defined(__aarch64__)
   state->pc = normalize_pac_ptr(regs->pc);
   state->sp = regs->sp;
@@ -796,13 +835,61 @@ int unwind_native(struct pt_regs *ctx) {
   return -1;
 }
 
+// pid_allowed checks whether the given PID passes the optional PID filter.
+static inline __attribute__((__always_inline__))
+bool pid_allowed(u32 pid) {
+  u32 active_key = 0;
+  if (!bpf_map_lookup_elem(&pid_filter, &active_key)) {
+    return true;
+  }
+  return bpf_map_lookup_elem(&pid_filter, &pid) != NULL;
+}
+
+// sample_kept decides whether the sample of the given PID is kept. If the sampling frequency
+// is raised for sample weights, the samples of a PID are kept with the probability of its
+// weight, or 1 for PIDs without weight, divided by the factor the frequency is raised by.
+static inline __attribute__((__always_inline__))
+bool sample_kept(u32 pid) {
+  u32 factor_key = 0;
+  u32 *factor = bpf_map_lookup_elem(&pid_sample_weights, &factor_key);
+  if (!factor) {
+    return true;
+  }
+  u32 weight = 1;
+  u32 *pid_weight = bpf_map_lookup_elem(&pid_sample_weights, &pid);
+  if (pid_weight) {
+    weight = *pid_weight;
+  }
+  if (weight >= *factor) {
+    return true;
+  }
+  return bpf_get_prandom_u32() % *factor < weight;
+}
+
+// unwind_verification_selected randomly selects the traces whose stack deltas are checked
+// against the frame pointers, see SystemConfig.verify_unwind_rate.
+static inline __attribute__((__always_inline__))
+bool unwind_verification_selected(void) {
+  u32 key = 0;
+  SystemConfig* syscfg = bpf_map_lookup_elem(&system_config, &key);
+  if (!syscfg || !syscfg->verify_unwind_rate) {
+    return false;
+  }
+  return bpf_get_prandom_u32() % syscfg->verify_unwind_rate == 0;
+}
+
 static inline
-int collect_trace(struct pt_regs *ctx) {
+int collect_trace(struct pt_regs *ctx, u64 period, u32 event_set) {
   // Get the PID and TGID register.
   u64 id = bpf_get_current_pid_tgid();
   u64 pid = id >> 32;
 
   if (pid == 0) {
+    increment_metric(metricID_PerfEventsIdle);
+    return 0;
+  }
+  increment_metric(metricID_PerfEventsActive);
+  if (!pid_allowed(pid) || !sample_kept(pid)) {
     return 0;
   }
 
@@ -818,7 +905,11 @@ int collect_trace(struct pt_regs *ctx) {
 
   Trace *trace = &record->trace;
   trace->pid = pid;
+  trace->tid = (u32)id;
+  trace->event_set = event_set;
+  trace->period = period;
   trace->ktime = bpf_ktime_get_ns();
+  record->state.verify_unwind = unwind_verification_selected();
   if (bpf_get_current_comm(&(trace->comm), sizeof(trace->comm)) < 0) {
     increment_metric(metricID_ErrBPFCurrentComm);
   }
@@ -853,5 +944,11 @@ exit:
 
 SEC("perf_event/native_tracer_entry")
 int native_tracer_entry(struct bpf_perf_event_data *ctx) {
-  return collect_trace((struct pt_regs*) &ctx->regs);
+  return collect_trace((struct pt_regs*) &ctx->regs, ctx->sample_period, EVENT_SET_PRIMARY);
+}
+
+SEC("perf_event/native_tracer_entry_secondary")
+int native_tracer_entry_secondary(struct bpf_perf_event_data *ctx) {
+  return collect_trace((struct pt_regs*) &ctx->regs, ctx->sample_period,
+                       EVENT_SET_SECONDARY);
 }
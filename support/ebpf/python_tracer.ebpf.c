@@ -11,6 +11,10 @@
 // option is to adjust this number downwards.
 #define FRAMES_PER_WALK_PYTHON_STACK 12
 
+// The maximum number of interpreters and thread states that are searched for the thread
+// state of the current thread, if it is not stored in TSD.
+#define MAX_PYTHON_THREAD_STATES 32
+
 // Forward declaration to avoid warnings like
 // "declaration of 'struct pt_regs' will not be visible outside of this function [-Wvisibility]".
 struct pt_regs;
@@ -49,10 +53,10 @@ ErrorCode process_python_frame(PerCPURecord *record, const PyProcInfo *pyinfo,
   PythonUnwindScratchSpace *pss = &record->pythonUnwindScratch;
 
   // Make verifier happy for PyFrameObject offsets
-  if (pyinfo->PyFrameObject_f_code     > sizeof(pss->frame) - sizeof(void*) ||
-      pyinfo->PyFrameObject_f_back     > sizeof(pss->frame) - sizeof(void*) ||
-      pyinfo->PyFrameObject_f_lasti    > sizeof(pss->frame) - sizeof(int) ||
-      pyinfo->PyFrameObject_f_is_entry > sizeof(pss->frame) - sizeof(bool)) {
+  if (pyinfo->PyFrameObject_f_code       > sizeof(pss->frame) - sizeof(void*) ||
+      pyinfo->PyFrameObject_f_back       > sizeof(pss->frame) - sizeof(void*) ||
+      pyinfo->PyFrameObject_f_lasti      > sizeof(pss->frame) - sizeof(int) ||
+      pyinfo->PyFrameObject_entry_member > sizeof(pss->frame) - sizeof(u8)) {
     return ERR_UNREACHABLE;
   }
 
@@ -87,7 +91,7 @@ ErrorCode process_python_frame(PerCPURecord *record, const PyProcInfo *pyinfo,
     // With Python 3.11 the element f_lasti not only got renamed but also its
     // type changed from int to uint16.
     py_f_lasti &= 0xffff;
-    if (*(bool*)(&pss->frame[pyinfo->PyFrameObject_f_is_entry])) {
+    if (pss->frame[pyinfo->PyFrameObject_entry_member] == pyinfo->PyFrameObject_entry_value) {
       *continue_with_next = true;
     }
   }
@@ -195,8 +199,77 @@ ErrorCode get_PyThreadState(const PyProcInfo *pyinfo, void *tsd_base, void *auto
   return ERR_OK;
 }
 
+// find_PyThreadState searches the thread states of all interpreters for the one of the
+// current thread. This finds the thread states of threads that are not stored in TSD, like
+// the ones of threads created in C that attached with PyThreadState_New instead of
+// PyGILState_Ensure, and the ones of sub-interpreters, which PyGILState does not support.
+// The thread states are matched by their thread ID instead of taking the thread state of
+// the GIL holder, as the current thread may run without holding the GIL, or hold the GIL
+// of another interpreter. Python 3.11+ records the native thread ID. Older versions only
+// record the pthread_t, which equals the thread pointer on x86_64 and is only used if
+// thread_id_offset is set.
 static inline __attribute__((__always_inline__))
-ErrorCode get_PyFrame(struct pt_regs *ctx, const PyProcInfo *pyinfo, void **frame) {
+ErrorCode find_PyThreadState(const PyProcInfo *pyinfo, u32 tid, void *tsd_base,
+                             void **thread_state) {
+  *thread_state = NULL;
+  u64 thread_id = (u64) tsd_base;
+  u8 thread_id_offset = pyinfo->PyThreadState_thread_id;
+  if (pyinfo->PyThreadState_native_thread_id) {
+    thread_id = tid;
+    thread_id_offset = pyinfo->PyThreadState_native_thread_id;
+  }
+  if (!pyinfo->interpHeadAddr || !thread_id_offset) {
+    return ERR_OK;
+  }
+
+  void *interp;
+  void *tstate = NULL;
+  if (bpf_probe_read(&interp, sizeof(interp), (void *) pyinfo->interpHeadAddr)) {
+    DEBUG_PRINT("Failed to read the first interpreter");
+    increment_metric(metricID_UnwindPythonErrBadThreadStateListAddr);
+    return ERR_PYTHON_BAD_THREAD_STATE_LIST_ADDR;
+  }
+
+  // Each iteration either visits a thread state, or advances to the thread states of the
+  // next interpreter once the ones of the current interpreter are exhausted.
+#pragma unroll
+  for (int i = 0; i < MAX_PYTHON_THREAD_STATES; i++) {
+    if (!tstate) {
+      if (!interp) {
+        return ERR_OK;
+      }
+      if (bpf_probe_read(&tstate, sizeof(tstate),
+              interp + pyinfo->PyInterpreterState_tstate_head) ||
+          bpf_probe_read(&interp, sizeof(interp), interp + pyinfo->PyInterpreterState_next)) {
+        DEBUG_PRINT("Failed to read the thread states of the interpreter");
+        increment_metric(metricID_UnwindPythonErrBadThreadStateListAddr);
+        return ERR_PYTHON_BAD_THREAD_STATE_LIST_ADDR;
+      }
+      continue;
+    }
+    u64 id;
+    if (bpf_probe_read(&id, sizeof(id), tstate + thread_id_offset)) {
+      DEBUG_PRINT("Failed to read the thread ID of PyThreadState at 0x%lx",
+          (unsigned long) tstate);
+      increment_metric(metricID_UnwindPythonErrBadThreadStateListAddr);
+      return ERR_PYTHON_BAD_THREAD_STATE_LIST_ADDR;
+    }
+    if (id == thread_id) {
+      increment_metric(metricID_UnwindPythonThreadStateFromList);
+      *thread_state = tstate;
+      return ERR_OK;
+    }
+    if (bpf_probe_read(&tstate, sizeof(tstate), tstate + pyinfo->PyThreadState_next)) {
+      DEBUG_PRINT("Failed to read PyThreadState.next at 0x%lx", (unsigned long) tstate);
+      increment_metric(metricID_UnwindPythonErrBadThreadStateListAddr);
+      return ERR_PYTHON_BAD_THREAD_STATE_LIST_ADDR;
+    }
+  }
+  return ERR_OK;
+}
+
+static inline __attribute__((__always_inline__))
+ErrorCode get_PyFrame(struct pt_regs *ctx, const PyProcInfo *pyinfo, u32 tid, void **frame) {
   void *tsd_base;
   if (tsd_get_base(ctx, &tsd_base)) {
     DEBUG_PRINT("Failed to get TSD base address");
@@ -215,6 +288,13 @@ ErrorCode get_PyFrame(struct pt_regs *ctx, const PyProcInfo *pyinfo, void **fram
     return error;
   }
 
+  if (!py_tsd_thread_state) {
+    error = find_PyThreadState(pyinfo, tid, tsd_base, &py_tsd_thread_state);
+    if (error) {
+      return error;
+    }
+  }
+
   if (!py_tsd_thread_state) {
     DEBUG_PRINT("PyThreadState is 0x0");
     increment_metric(metricID_UnwindPythonErrZeroThreadState);
@@ -287,7 +367,7 @@ int unwind_python(struct pt_regs *ctx) {
   DEBUG_PRINT("Building Python stack for 0x%x", pyinfo->version);
   if (!record->pythonUnwindState.py_frame) {
     increment_metric(metricID_UnwindPythonAttempts);
-    error = get_PyFrame(ctx, pyinfo, &record->pythonUnwindState.py_frame);
+    error = get_PyFrame(ctx, pyinfo, trace->tid, &record->pythonUnwindState.py_frame);
     if (error) {
       goto exit;
     }
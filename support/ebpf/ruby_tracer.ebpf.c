@@ -2,6 +2,7 @@
 
 #include "bpfdefs.h"
 #include "tracemgmt.h"
+#include "tsd.h"
 #include "types.h"
 
 // Map from Ruby process IDs to a structure containing addresses of variables
@@ -30,6 +31,40 @@ ErrorCode push_ruby(Trace *trace, u64 file, u64 line) {
   return _push(trace, file, line, FRAME_MARKER_RUBY);
 }
 
+// get_ruby_current_ec retrieves the rb_execution_context_struct of the current thread.
+//
+// With Ruby 3.x, libruby keeps it in the thread-local variable ruby_current_ec. Its
+// address is found from the thread pointer via the dynamic thread vector (DTV), which
+// holds the TLS block of each module indexed by the module ID the dynamic linker
+// assigned to libruby.
+static inline __attribute__((__always_inline__))
+ErrorCode get_ruby_current_ec(struct pt_regs *ctx, const RubyProcInfo *rubyinfo,
+                              void **current_ctx_addr) {
+  void *tsd_base;
+  if (tsd_get_base(ctx, &tsd_base)) {
+    DEBUG_PRINT("ruby: failed to get TSD base address");
+    increment_metric(metricID_UnwindRubyErrReadTsdBase);
+    return ERR_RUBY_READ_TSD_BASE;
+  }
+
+  u64 module_id;
+  if (bpf_probe_read(&module_id, sizeof(module_id), (void *)rubyinfo->tls_module_id_addr)) {
+    DEBUG_PRINT("ruby: failed to read TLS module ID");
+    increment_metric(metricID_UnwindRubyErrReadTLS);
+    return ERR_RUBY_READ_TLS;
+  }
+
+  void *tls_block;
+  if (tsd_read(&rubyinfo->dtvInfo, tsd_base, module_id, &tls_block) ||
+      bpf_probe_read(current_ctx_addr, sizeof(*current_ctx_addr),
+        tls_block + rubyinfo->current_ec_tls_offset)) {
+    DEBUG_PRINT("ruby: failed to read ruby_current_ec");
+    increment_metric(metricID_UnwindRubyErrReadTLS);
+    return ERR_RUBY_READ_TLS;
+  }
+  return ERR_OK;
+}
+
 // walk_ruby_stack processes a Ruby VM stack, extracts information from the individual frames and
 // pushes this information to user space for symbolization of these frames.
 //
@@ -236,7 +271,12 @@ int unwind_ruby(struct pt_regs *ctx) {
   // Pointer for an address to a rb_execution_context_struct struct.
   void *current_ctx_addr = NULL;
 
-  if (rubyinfo->version >= 0x30000) {
+  if (rubyinfo->tls_module_id_addr) {
+    error = get_ruby_current_ec(ctx, rubyinfo, &current_ctx_addr);
+    if (error) {
+      goto exit;
+    }
+  } else if (rubyinfo->version >= 0x30000) {
     // With Ruby 3.x and its internal change of the execution model, we can no longer
     // access rb_execution_context_struct directly. Therefore we have to first lookup
     // ruby_single_main_ractor and get access to the current execution context via
@@ -11,6 +11,8 @@
 #define UNWIND_OPCODE_BASE_FP   0x03
 // Expression with base value being the Link Register (ARM64)
 #define UNWIND_OPCODE_BASE_LR	0x04
+// Expression with base value being the RBX register (x86_64)
+#define UNWIND_OPCODE_BASE_RBX  0x05
 // An opcode flag to indicate that the value should be dereferenced
 #define UNWIND_OPCODEF_DEREF    0x80
 
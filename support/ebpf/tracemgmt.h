@@ -47,6 +47,8 @@ static inline PerCPURecord *get_pristine_per_cpu_record()
   record->state.fp = 0;
 #if defined(__x86_64__)
   record->state.r13 = 0;
+  record->state.rbx = 0;
+  record->state.rbx_valid = false;
 #elif defined(__aarch64__)
   record->state.lr = 0;
   record->state.r22 = 0;
@@ -54,12 +56,15 @@ static inline PerCPURecord *get_pristine_per_cpu_record()
 #endif
   record->state.error_metric = -1;
   record->state.unwind_error = ERR_OK;
+  record->state.verify_unwind = false;
   record->perlUnwindState.stackinfo = 0;
   record->perlUnwindState.cop = 0;
   record->pythonUnwindState.py_frame = 0;
   record->phpUnwindState.zend_execute_data = 0;
   record->rubyUnwindState.stack_ptr = 0;
   record->rubyUnwindState.last_stack_frame = 0;
+  record->beamUnwindState.stack_ptr = 0;
+  record->beamUnwindState.stack_end = 0;
   record->unwindersDone = 0;
   record->tailCalls = 0;
 
@@ -67,6 +72,10 @@ static inline PerCPURecord *get_pristine_per_cpu_record()
   trace->kernel_stack_id = -1;
   trace->stack_len = 0;
   trace->pid = 0;
+  trace->tid = 0;
+  trace->event_set = EVENT_SET_PRIMARY;
+  trace->pad = 0;
+  trace->period = 0;
 
   // TODO: memset trace to all-zero here?
 
@@ -244,7 +253,38 @@ bool report_pid(void *ctx, int pid, bool inhibit) {
 
 // is_kernel_address checks if the given address looks like virtual address to kernel memory.
 static bool is_kernel_address(u64 addr) {
-  return addr & 0xFF00000000000000UL;
+  u32 key = 0;
+  SystemConfig* syscfg = bpf_map_lookup_elem(&system_config, &key);
+  if (!syscfg || !syscfg->kernel_address_start) {
+    // Fall back to a heuristic that holds for all supported paging modes.
+    return addr & 0xFF00000000000000UL;
+  }
+  return addr >= syscfg->kernel_address_start;
+}
+
+// Strips the PAC tag from a code pointer, e.g. a return address read from the stack. On other
+// architectures than ARM64, the pointer is returned as is.
+//
+// While all pointers can contain PAC tags, we only apply this function to code pointers, because
+// that's where normalization is required to make the stack delta lookups work. Note that if that
+// should ever change, we'd need a different mask for the data pointers, because it might diverge
+// from the mask for code pointers.
+static inline u64 normalize_pac_ptr(u64 ptr) {
+#if defined(__aarch64__)
+  // Retrieve PAC mask from the system config.
+  u32 key = 0;
+  SystemConfig* syscfg = bpf_map_lookup_elem(&system_config, &key);
+  if (!syscfg) {
+    // Unreachable: array maps are always fully initialized.
+    return ptr;
+  }
+
+  // Mask off PAC bits. Since we're always applying this to usermode pointers that should have all
+  // the high bits set to 0, we don't need to consider the case of having to fill up the resulting
+  // hole with 1s (like we'd have to for kernel ptrs).
+  ptr &= syscfg->inverse_pac_mask;
+#endif
+  return ptr;
 }
 
 // resolve_unwind_mapping decodes the current PC's mapping and prepares unwinding information.
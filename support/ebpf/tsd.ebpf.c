@@ -55,3 +55,42 @@ int tracepoint__sys_enter_bpf(struct pt_regs *ctx) {
 
   return 0;
 }
+
+// tpbase_check holds the tpbase offset to validate and the value read through it, see
+// tracepoint__sys_enter_bpf_tpbase.
+bpf_map_def SEC("maps") tpbase_check = {
+  .type = BPF_MAP_TYPE_ARRAY,
+  .key_size = sizeof(u32),
+  .value_size = sizeof(TPBaseCheck),
+  .max_entries = 1,
+};
+
+// tracepoint__sys_enter_bpf_tpbase reads the thread pointer base of the thread
+// tpbase_check[0].tid through the offset tpbase_check[0].offset in its task_struct, if
+// called from that thread. Userspace compares the value with the thread pointer it actually
+// uses, to validate the offset.
+SEC("tracepoint/syscalls/sys_enter_bpf")
+int tracepoint__sys_enter_bpf_tpbase(struct pt_regs *ctx) {
+  u32 key0 = 0;
+  int ret;
+
+  TPBaseCheck *check = bpf_map_lookup_elem(&tpbase_check, &key0);
+  if (!check) {
+    DEBUG_PRINT("Failed to look up tpbase_check");
+    return -1;
+  }
+
+  // Only the thread requesting the check knows its thread pointer.
+  if ((u32)bpf_get_current_pid_tgid() != check->tid) {
+    return 0;
+  }
+
+  void *tpbase_ptr = ((char *)bpf_get_current_task()) + check->offset;
+  ret = bpf_probe_read(&check->tpbase, sizeof(check->tpbase), tpbase_ptr);
+  if (ret) {
+    DEBUG_PRINT("Failed to read tpbase value: error code %d", ret);
+    return -1;
+  }
+
+  return 0;
+}
@@ -271,6 +271,54 @@ enum {
   // number of times an unwind_info_array index was invalid
   metricID_UnwindNativeErrBadUnwindInfoIndex,
 
+  // number of attempted BEAM unwinds
+  metricID_UnwindBEAMAttempts,
+
+  // number of unwound BEAM frames
+  metricID_UnwindBEAMFrames,
+
+  // number of times no entry for a process exists in the BEAM process info array
+  metricID_UnwindBEAMErrNoProcInfo,
+
+  // number of failures to determine the base address for thread-specific data
+  metricID_UnwindBEAMErrReadTsdBase,
+
+  // number of failures to read erts_esd_key
+  metricID_UnwindBEAMErrBadEsdKeyAddr,
+
+  // number of failures to read the scheduler data of the current thread
+  metricID_UnwindBEAMErrReadSchedulerData,
+
+  // number of failures to read the current process or its stack
+  metricID_UnwindBEAMErrReadProcess,
+
+  // number of failures to determine the base address for thread-local data in the Ruby unwinder
+  metricID_UnwindRubyErrReadTsdBase,
+
+  // number of failures to read the execution context from thread-local data
+  metricID_UnwindRubyErrReadTLS,
+
+  // number of perf events that interrupted an idle CPU
+  metricID_PerfEventsIdle,
+
+  // number of perf events that interrupted a CPU running a process
+  metricID_PerfEventsActive,
+
+  // number of times the unwind instructions requested RBX based unwinding mid-trace
+  metricID_UnwindNativeErrRbxUnwindingMidTrace,
+
+  // number of PyThreadState found in the thread states of the interpreter instead of TSD
+  metricID_UnwindPythonThreadStateFromList,
+
+  // number of failures to read the thread states of the Python interpreter
+  metricID_UnwindPythonErrBadThreadStateListAddr,
+
+  // number of native frames whose stack delta was checked against the frame pointer
+  metricID_UnwindNativeVerifiedFrames,
+
+  // number of native frames whose stack delta disagreed with the frame pointer
+  metricID_UnwindNativeVerifyMismatches,
+
   //
   // Metric IDs above are for counters (cumulative values)
   //
@@ -297,6 +345,7 @@ typedef enum TracePrograms {
   PROG_UNWIND_PHP,
   PROG_UNWIND_RUBY,
   PROG_UNWIND_V8,
+  PROG_UNWIND_BEAM,
   NUM_TRACER_PROGS,
 } TracePrograms;
 
@@ -358,12 +407,20 @@ typedef struct PerlProcInfo {
 typedef struct PyProcInfo {
   // The address of the autoTLSkey variable
   u64 autoTLSKeyAddr;
+  // The address of the pointer to the first PyInterpreterState, or zero if the thread states
+  // can not be searched for threads that have no PyThreadState in TSD
+  u64 interpHeadAddr;
   u16 version;
   TSDInfo tsdInfo;
   // The Python object member offsets
+  u8 PyInterpreterState_next, PyInterpreterState_tstate_head;
+  u8 PyThreadState_next, PyThreadState_thread_id, PyThreadState_native_thread_id;
   u8 PyThreadState_frame;
   u8 PyCFrame_current_frame;
-  u8 PyFrameObject_f_back, PyFrameObject_f_code, PyFrameObject_f_lasti, PyFrameObject_f_is_entry;
+  u8 PyFrameObject_f_back, PyFrameObject_f_code, PyFrameObject_f_lasti;
+  // The offset of the member of the frames that are the first of an evaluation, and its
+  // value in these frames, Python 3.11+
+  u8 PyFrameObject_entry_member, PyFrameObject_entry_value;
   u8 PyCodeObject_co_argcount, PyCodeObject_co_kwonlyargcount;
   u8 PyCodeObject_co_flags, PyCodeObject_co_firstlineno;
 } PyProcInfo;
@@ -409,6 +466,15 @@ typedef struct RubyProcInfo {
   // current_ctx_ptr holds the address of the symbol ruby_current_execution_context_ptr.
   u64 current_ctx_ptr;
 
+  // tls_module_id_addr holds the address of the GOT entry with the TLS module ID of libruby
+  // and current_ec_tls_offset the offset of ruby_current_ec in its TLS block. They are 0 if
+  // the execution context is not looked up via the thread pointer.
+  u64 tls_module_id_addr;
+  u64 current_ec_tls_offset;
+
+  // dtvInfo describes how to find the TLS block of a module from the thread pointer.
+  TSDInfo dtvInfo;
+
   // Offsets and sizes of Ruby internal structs
 
   // rb_execution_context_struct offsets:
@@ -443,13 +509,32 @@ typedef struct V8ProcInfo {
   u8 codekind_shift, codekind_mask, codekind_baseline;
 } V8ProcInfo;
 
+// BEAMProcInfo is a container for the data needed to build a stack trace for a BEAM process.
+typedef struct BEAMProcInfo {
+  // The address of the erts_esd_key variable
+  u64 esd_key_addr;
+  u32 version;
+  TSDInfo tsdInfo;
+  // ErtsSchedulerData offsets
+  u16 current_process;
+  // Process offsets
+  u16 process_stop, process_hend, process_i;
+} BEAMProcInfo;
+
 // COMM_LEN defines the maximum length we will receive for the comm of a task.
 #define COMM_LEN 16
 
+// Perf event sets whose events trigger the collection of traces. The secondary set is
+// optional and sampled with its own settings, and its traces are reported separately.
+#define EVENT_SET_PRIMARY   0
+#define EVENT_SET_SECONDARY 1
+
 // Container for a stack trace
 typedef struct Trace {
   // The process ID
   u32 pid;
+  // The thread ID
+  u32 tid;
   // Monotonic kernel time in nanosecond precision.
   u64 ktime;
   // The current COMM of the thread of this Trace.
@@ -458,6 +543,14 @@ typedef struct Trace {
   s32 kernel_stack_id;
   // The number of frames in the stack.
   u32 stack_len;
+  // The perf event set (EVENT_SET_xxx) whose event triggered the collection of the trace.
+  u32 event_set;
+  // Explicit padding bytes that the compiler would have inserted anyway, so that
+  // they are included in the trace hash with a defined value.
+  u32 pad;
+  // The period of the perf event sample. For clock events, this is the time in nanoseconds
+  // that was counted since the previous sample on the CPU.
+  u64 period;
   // The frames of the stack trace.
   Frame frames[MAX_FRAME_UNWINDS];
 
@@ -478,6 +571,8 @@ typedef struct UnwindState {
 #if defined(__x86_64__)
   // Current register value for r13
   u64 r13;
+  // Current register value for rbx
+  u64 rbx;
 #elif defined(__aarch64__)
   // Current register value for lr
   u64 lr;
@@ -496,8 +591,14 @@ typedef struct UnwindState {
   s32 error_metric;
   // If unwinding was aborted due to an error, this contains the reason why.
   ErrorCode unwind_error;
+  // If the stack deltas of the frames are checked against the frame pointers, see
+  // SystemConfig.verify_unwind_rate
+  bool verify_unwind;
 
-#if defined(__aarch64__)
+#if defined(__x86_64__)
+  // If the value of rbx is known (top frame or after signal handler)
+  bool rbx_valid;
+#elif defined(__aarch64__)
   // If unwinding on LR register can be used (top frame or after signal handler)
   bool lr_valid;
 #endif
@@ -538,6 +639,14 @@ typedef struct RubyUnwindState {
   void *last_stack_frame;
 } RubyUnwindState;
 
+// Container for unwinding state needed by the BEAM unwinder.
+typedef struct BEAMUnwindState {
+  // Pointer to the next word of the Erlang stack we want to inspect.
+  const u64 *stack_ptr;
+  // Pointer to the end of the Erlang stack.
+  const u64 *stack_end;
+} BEAMUnwindState;
+
 // Container for additional scratch space needed by the HotSpot unwinder.
 typedef struct HotspotUnwindScratchSpace {
   // Read buffer for storing the codeblob. It's not needed across calls, but the buffer is too
@@ -584,6 +693,8 @@ typedef struct PerCPURecord {
   PHPUnwindState phpUnwindState;
   // The current Ruby unwinder state.
   RubyUnwindState rubyUnwindState;
+  // The current BEAM unwinder state.
+  BEAMUnwindState beamUnwindState;
   union {
     // Scratch space for the HotSpot unwinder.
     HotspotUnwindScratchSpace hotspotUnwindScratch;
@@ -666,6 +777,17 @@ typedef struct OffsetRange {
 // Needed for tpbase offset calculations.
 #define CODEDUMP_BYTES 128
 
+// TPBaseCheck is used to validate a tpbase offset by reading the thread pointer base of
+// the thread with the given TID through it.
+typedef struct TPBaseCheck {
+  // offset is the tpbase offset to validate.
+  u64 offset;
+  // tpbase is populated with the value read through offset.
+  u64 tpbase;
+  // tid is the thread whose thread pointer base is read.
+  u32 tid;
+} TPBaseCheck;
+
 // Event is the header for all events sent through the report_events
 // perf event output channel (event_send_trigger).
 typedef struct Event {
@@ -742,15 +864,25 @@ void decode_bias_and_unwind_program(u64 bias_and_unwind_program, u64* bias, int*
 // determined and set by the host agent.
 typedef struct SystemConfig {
   // PAC mask that is determined by user-space and used in `normalize_pac_ptr`.
-  // ARM64 specific, `MAX_U64` otherwise.
+  // On ARM64, it also clears all bits above the user address space, `MAX_U64`
+  // otherwise.
   u64 inverse_pac_mask;
 
   // The offset of the Thread Pointer Base variable in `task_struct`. It is
   // populated by the host agent based on kernel code analysis.
   u64 tpbase_offset;
 
+  // Start of the kernel half of the virtual address space. It depends on the number of
+  // virtual address bits used by the running kernel (e.g. 5-level paging on x86_64) and is
+  // determined by user-space. Zero if unknown.
+  u64 kernel_address_start;
+
   // Enables the temporary hack that drops pure errors frames in unwind_stop.
   bool drop_error_only_traces;
+
+  // Checks the stack deltas against the frame pointers in one of this many traces, to detect
+  // wrong stack deltas. Zero disables the checks.
+  u32 verify_unwind_rate;
 } SystemConfig;
 
 // Avoid including all of arch/arm64/include/uapi/asm/ptrace.h by copying the
@@ -263,7 +263,7 @@ frame_done:
   }
   state->sp = fp + sizeof(regs);
   state->fp = regs[0];
-  state->pc = regs[1];
+  state->pc = normalize_pac_ptr(regs[1]);
 
   ErrorCode error = push_v8(trace, pointer_and_type, delta_or_marker);
   if (error) {
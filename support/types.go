@@ -26,6 +26,7 @@ const (
 	FrameMarkerRuby     = C.FRAME_MARKER_RUBY
 	FrameMarkerPerl     = C.FRAME_MARKER_PERL
 	FrameMarkerV8       = C.FRAME_MARKER_V8
+	FrameMarkerBEAM     = C.FRAME_MARKER_BEAM
 	FrameMarkerAbort    = C.FRAME_MARKER_ABORT
 )
 
@@ -38,6 +39,7 @@ const (
 	ProgUnwindRuby    = C.PROG_UNWIND_RUBY
 	ProgUnwindPerl    = C.PROG_UNWIND_PERL
 	ProgUnwindV8      = C.PROG_UNWIND_V8
+	ProgUnwindBEAM    = C.PROG_UNWIND_BEAM
 )
 
 const (
@@ -50,10 +52,17 @@ const (
 	EventTypeGenericPID = C.EVENT_TYPE_GENERIC_PID
 )
 
+const (
+	EventSetPrimary   = C.EVENT_SET_PRIMARY
+	EventSetSecondary = C.EVENT_SET_SECONDARY
+)
+
 const MaxFrameUnwinds = C.MAX_FRAME_UNWINDS
 
 const (
-	MetricIDBeginCumulative = C.metricID_BeginCumulative
+	MetricIDBeginCumulative  = C.metricID_BeginCumulative
+	MetricIDPerfEventsIdle   = C.metricID_PerfEventsIdle
+	MetricIDPerfEventsActive = C.metricID_PerfEventsActive
 )
 
 const (
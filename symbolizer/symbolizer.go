@@ -0,0 +1,154 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Package symbolizer resolves addresses of native code in live processes to symbolized
+// frames, for tools that embed the agent's libraries. It uses the same mapping lookup and
+// ELF handling as the trace pipeline of the agent.
+//
+// The package is scoped to the executable code of ELF files, and does not replace the
+// symbolization of the trace pipeline. The frames of interpreted code are not identified
+// by an address, but by interpreter state the eBPF unwinders read from the stack, and
+// they are symbolized by the interpreter support of the process manager instead.
+package symbolizer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/elfunwindinfo"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/libpf/process"
+)
+
+// ErrNotMapped is returned if the address is not mapped in the process.
+var ErrNotMapped = errors.New("address not mapped")
+
+// ErrAnonymous is returned if the address is in memory that is not backed by a file, e.g.
+// code that was compiled just in time.
+var ErrAnonymous = errors.New("address in anonymous memory")
+
+// NativeFrame is a frame of the executable code of an ELF file.
+type NativeFrame struct {
+	// FileID is the file ID the agent reports the executable with.
+	FileID libpf.FileID
+	// FileName is the path of the executable as mapped by the process, and BuildID its
+	// GNU build ID, or empty if it has none.
+	FileName string
+	BuildID  string
+	// Address is the ELF virtual address within the executable.
+	Address libpf.Address
+	// FunctionName is the name of the function, or empty if it is unknown. FunctionOffset
+	// is the offset of the address from the start of the function in bytes.
+	FunctionName   string
+	FunctionOffset uint64
+	// SourceFile and SourceLine are the source code location, if known. They are only
+	// available for Go executables, whose .gopclntab records them.
+	SourceFile string
+	SourceLine libpf.SourceLineno
+}
+
+// SymbolizeNative resolves the address addr of native code in the process pid to the
+// frames of the functions it is part of, from the innermost inlined function to the
+// function containing it. The function names are taken from the .gopclntab of Go
+// executables, and from the ELF symbol tables otherwise. Inlined functions are not
+// resolved by either, so a single frame is returned for now. For stack trace addresses other than the innermost one, which are
+// return addresses, callers should pass the address minus one to resolve the call.
+func SymbolizeNative(pid libpf.PID, addr libpf.Address) ([]NativeFrame, error) {
+	pr := process.New(pid)
+	defer pr.Close()
+	return symbolizeNative(pr, addr)
+}
+
+// symbolizeNative implements SymbolizeNative for the process pr.
+func symbolizeNative(pr process.Process, addr libpf.Address) ([]NativeFrame, error) {
+	mappings, err := pr.GetMappings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mappings: %v", err)
+	}
+	var mapping *process.Mapping
+	for i := range mappings {
+		m := &mappings[i]
+		if uint64(addr) >= m.Vaddr && uint64(addr) < m.Vaddr+m.Length {
+			mapping = m
+			break
+		}
+	}
+	if mapping == nil {
+		return nil, fmt.Errorf("0x%x: %w", addr, ErrNotMapped)
+	}
+	if mapping.IsAnonymous() {
+		return nil, fmt.Errorf("0x%x: %w", addr, ErrAnonymous)
+	}
+
+	elfRef := pfelf.NewReference(mapping.Path, pr)
+	defer elfRef.Close()
+	ef, err := elfRef.GetELF()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", mapping.Path, err)
+	}
+	fileID, err := pr.CalculateMappingFileID(mapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate file ID of %s: %v", mapping.Path, err)
+	}
+	addressMapper := ef.GetAddressMapper()
+	vaddr, ok := addressMapper.FileOffsetToVirtualAddress(
+		uint64(addr) - mapping.Vaddr + mapping.FileOffset)
+	if !ok {
+		return nil, fmt.Errorf("0x%x is not part of a segment of %s", addr, mapping.Path)
+	}
+
+	frame := NativeFrame{
+		FileID:   fileID,
+		FileName: mapping.Path,
+		Address:  libpf.Address(vaddr),
+	}
+	frame.BuildID, _ = ef.GetBuildID()
+	if !symbolizeGo(ef, &frame) {
+		symbolizeELF(ef, &frame)
+	}
+	return []NativeFrame{frame}, nil
+}
+
+// symbolizeGo sets the function and source code location of frame from the .gopclntab,
+// if ef is a Go executable. It returns false if the address could not be resolved.
+func symbolizeGo(ef *pfelf.File, frame *NativeFrame) bool {
+	if !ef.IsGolang() {
+		return false
+	}
+	symbols, err := elfunwindinfo.NewGoSymbolTable(ef)
+	if err != nil {
+		return false
+	}
+	info, ok := symbols.Lookup(uint64(frame.Address))
+	if !ok {
+		return false
+	}
+	frame.FunctionName = info.FunctionName
+	frame.FunctionOffset = uint64(frame.Address) - info.FunctionStart
+	frame.SourceFile = info.FileName
+	frame.SourceLine = libpf.SourceLineno(info.Line)
+	return true
+}
+
+// symbolizeELF sets the function of frame from the symbol table of ef, or from its dynamic
+// symbol table if the file is stripped.
+func symbolizeELF(ef *pfelf.File, frame *NativeFrame) {
+	for _, readSymbols := range []func() (*libpf.SymbolMap, error){
+		ef.ReadSymbols, ef.ReadDynamicSymbols,
+	} {
+		symbols, err := readSymbols()
+		if err != nil {
+			continue
+		}
+		name, offset, ok := symbols.LookupByAddress(libpf.SymbolValue(frame.Address))
+		if ok {
+			frame.FunctionName = string(name)
+			frame.FunctionOffset = uint64(offset)
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package symbolizer
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/process"
+)
+
+func TestSymbolizeGo(t *testing.T) {
+	addr := libpf.Address(reflect.ValueOf(TestSymbolizeGo).Pointer()) + 1
+	frames, err := SymbolizeNative(libpf.PID(os.Getpid()), addr)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	frame := frames[0]
+	assert.Equal(t, "github.com/elastic/otel-profiling-agent/symbolizer.TestSymbolizeGo",
+		frame.FunctionName)
+	assert.True(t, strings.HasSuffix(frame.SourceFile, "symbolizer_test.go"),
+		"unexpected source file %s", frame.SourceFile)
+	assert.NotZero(t, frame.SourceLine)
+}
+
+func TestSymbolizeNative(t *testing.T) {
+	path, err := filepath.Abs("../libpf/pfelf/testdata/pie-interpreter")
+	require.NoError(t, err)
+	cmd := exec.Command(path)
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	pid := libpf.PID(cmd.Process.Pid)
+
+	pr := process.New(pid)
+	defer pr.Close()
+	ef, err := pr.OpenELF(path)
+	require.NoError(t, err)
+	defer ef.Close()
+	sym, err := ef.LookupSymbol("main")
+	require.NoError(t, err)
+
+	// The executable is mapped by the kernel after Start returned, so it may take a moment
+	// until its mappings appear.
+	var addr libpf.Address
+	mapper := ef.GetAddressMapper()
+	require.Eventually(t, func() bool {
+		mappings, err := pr.GetMappings()
+		if err != nil {
+			return false
+		}
+		for i := range mappings {
+			m := &mappings[i]
+			if m.Path != path || !m.IsExecutable() {
+				continue
+			}
+			if bias, ok := mapper.LoadBias(m.Vaddr, m.FileOffset); ok {
+				addr = libpf.Address(bias) + libpf.Address(sym.Address) + 2
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "no executable mapping of %s", path)
+
+	frames, err := SymbolizeNative(pid, addr)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	frame := frames[0]
+	assert.Equal(t, path, frame.FileName)
+	assert.Equal(t, libpf.Address(sym.Address)+2, frame.Address)
+	assert.Equal(t, "main", frame.FunctionName)
+	assert.Equal(t, uint64(2), frame.FunctionOffset)
+	assert.NotEmpty(t, frame.BuildID)
+
+	_, err = SymbolizeNative(pid, 0x10)
+	assert.ErrorIs(t, err, ErrNotMapped)
+}
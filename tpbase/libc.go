@@ -351,3 +351,62 @@ func ExtractTSDInfoARM64(code []byte) (TSDInfo, error) {
 		Indirect:   indirect,
 	}, nil
 }
+
+// Libc identifies a C-library implementation.
+type Libc int
+
+const (
+	Glibc Libc = iota
+	Musl
+)
+
+// Libc returns the C-library whose thread specific data layout is described by info.
+// Only musl references the TSD array through a pointer.
+func (info *TSDInfo) Libc() Libc {
+	if info.Indirect != 0 {
+		return Musl
+	}
+	return Glibc
+}
+
+// This code describes how the dynamic thread vector (DTV), which holds the addresses of the
+// TLS blocks of the modules, is reached from "tpbase". The DTV is indexed by the module ID
+// of a DSO, which the dynamic linker stores in the DSO's GOT.
+//
+// glibc:
+// https://sourceware.org/git/?p=glibc.git;a=blob;f=sysdeps/x86_64/nptl/tls.h;hb=c804cd1c00ad#l42
+// https://sourceware.org/git/?p=glibc.git;a=blob;f=sysdeps/aarch64/nptl/tls.h;hb=c804cd1c00ad#l45
+//
+// The thread control block tcbhead_t starts with "void *tcb; dtv_t *dtv;" on x86_64 and
+// with "dtv_t *dtv;" on arm64. The dtv_t entries are unions of a generation counter and
+// a struct whose first member is the pointer to the TLS block. The struct changed from
+// "{ void *val; bool is_static; }" to "{ void *val; void *to_free; }" in glibc 2.26, but
+// both variants are 16 bytes on 64-bit platforms.
+//
+// musl:
+// http://git.musl-libc.org/cgit/musl/tree/src/internal/pthread_impl.h?h=v1.2.3#n18
+//
+// The DTV of struct pthread follows "struct pthread *self;" on x86_64. On arm64, where the
+// TLS is above the thread pointer, it is the last member of struct pthread, just before the
+// thread pointer. The DTV entries are plain pointers to the TLS blocks.
+
+// DTVInfo returns how to find the TLS block of a module from "tpbase" for the C-library
+// libc on the architecture arch (in GOARCH notation). The DTV pointer is at "tpbase + Offset"
+// and the TLS block pointer of a module is read from "DTV + module ID * Multiplier".
+func DTVInfo(libc Libc, arch string) (TSDInfo, error) {
+	info := TSDInfo{Indirect: 1, Multiplier: 16}
+	if libc == Musl {
+		info.Multiplier = 8
+	}
+	switch arch {
+	case "amd64":
+		info.Offset = 8
+	case "arm64":
+		if libc == Musl {
+			info.Offset = -8
+		}
+	default:
+		return TSDInfo{}, errArchNotImplemented
+	}
+	return info, nil
+}
@@ -236,3 +236,34 @@ func TestExtractTSDInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestDTVInfo(t *testing.T) {
+	testCases := map[string]struct {
+		libc Libc
+		arch string
+		info TSDInfo
+	}{
+		"glibc / amd64": {Glibc, "amd64", TSDInfo{Offset: 8, Multiplier: 16, Indirect: 1}},
+		"glibc / arm64": {Glibc, "arm64", TSDInfo{Offset: 0, Multiplier: 16, Indirect: 1}},
+		"musl / amd64":  {Musl, "amd64", TSDInfo{Offset: 8, Multiplier: 8, Indirect: 1}},
+		"musl / arm64":  {Musl, "arm64", TSDInfo{Offset: -8, Multiplier: 8, Indirect: 1}},
+	}
+
+	for name, test := range testCases {
+		info, err := DTVInfo(test.libc, test.arch)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, test.info, info, name)
+		}
+	}
+
+	_, err := DTVInfo(Glibc, "riscv64")
+	assert.ErrorIs(t, err, errArchNotImplemented)
+}
+
+func TestTSDInfoLibc(t *testing.T) {
+	// Values as extracted in TestExtractTSDInfo.
+	musl := TSDInfo{Offset: -88, Multiplier: 8, Indirect: 1}
+	glibc := TSDInfo{Offset: -0x6b0 + 8, Multiplier: 16}
+	assert.Equal(t, Musl, musl.Libc())
+	assert.Equal(t, Glibc, glibc.Libc())
+}
@@ -26,10 +26,15 @@ func (m *traceHandler) collectMetrics() {
 			ID:    metrics.IDKnownTracesMiss,
 			Value: metrics.MetricValue(m.bpfTraceCacheMiss),
 		},
+		{
+			ID:    metrics.IDExcludedThreadSamples,
+			Value: metrics.MetricValue(m.excludedThreadSamples),
+		},
 	})
 
 	m.umTraceCacheHit = 0
 	m.umTraceCacheMiss = 0
 	m.bpfTraceCacheHit = 0
 	m.bpfTraceCacheMiss = 0
+	m.excludedThreadSamples = 0
 }
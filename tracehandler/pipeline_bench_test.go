@@ -0,0 +1,254 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracehandler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	lru "github.com/elastic/go-freelru"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/config"
+	"github.com/elastic/otel-profiling-agent/host"
+	"github.com/elastic/otel-profiling-agent/interpreter"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/process"
+	"github.com/elastic/otel-profiling-agent/metrics"
+	"github.com/elastic/otel-profiling-agent/processmanager"
+	"github.com/elastic/otel-profiling-agent/reporter"
+	"github.com/elastic/otel-profiling-agent/tpbase"
+)
+
+const (
+	// pipelinePID is the PID of the synthetic process all traces belong to.
+	pipelinePID = libpf.PID(4242)
+	// pipelineTraces is the number of distinct synthetic raw traces.
+	pipelineTraces = 1024
+	// pipelineNativeFiles / pipelinePythonFiles are the number of distinct files
+	// referenced by native and Python frames.
+	pipelineNativeFiles = 16
+	pipelinePythonFiles = 64
+	// pipelineNativeDepth / pipelinePythonDepth are the number of frames per trace.
+	pipelineNativeDepth = 24
+	pipelinePythonDepth = 16
+)
+
+// fakePythonInstance is an interpreter.Instance that symbolizes Python frames
+// without accessing the memory of a real process. Each frame is reported as a
+// line in the function identified by the frame's file.
+type fakePythonInstance struct {
+	// reported tracks the frames for which metadata was already reported.
+	reported map[host.Frame]libpf.Void
+}
+
+var _ interpreter.Instance = (*fakePythonInstance)(nil)
+
+func (f *fakePythonInstance) Detach(interpreter.EbpfHandler, libpf.PID) error {
+	return nil
+}
+
+func (f *fakePythonInstance) SynchronizeMappings(interpreter.EbpfHandler,
+	reporter.SymbolReporter, process.Process, []process.Mapping) error {
+	return nil
+}
+
+func (f *fakePythonInstance) UpdateTSDInfo(interpreter.EbpfHandler, libpf.PID,
+	tpbase.TSDInfo) error {
+	return nil
+}
+
+func (f *fakePythonInstance) GetAndResetMetrics() ([]metrics.Metric, error) {
+	return nil, nil
+}
+
+func (f *fakePythonInstance) Symbolize(symbolReporter reporter.SymbolReporter,
+	frame *host.Frame, trace *libpf.Trace) error {
+	if !frame.Type.IsInterpType(libpf.Python) {
+		return interpreter.ErrMismatchInterpreterType
+	}
+
+	fileID := libpf.NewFileID(uint64(frame.File), uint64(frame.File))
+	trace.AppendFrame(libpf.PythonFrame, fileID, frame.Lineno)
+
+	if _, ok := f.reported[*frame]; !ok {
+		f.reported[*frame] = libpf.Void{}
+		symbolReporter.FrameMetadata(fileID, frame.Lineno, libpf.SourceLineno(frame.Lineno),
+			0, fmt.Sprintf("func_%x", uint64(frame.File)),
+			fmt.Sprintf("/app/module_%x.py", uint64(frame.File)))
+	}
+	return nil
+}
+
+// pipeline holds the components of the symbolization and reporting pipeline.
+type pipeline struct {
+	handler *traceHandler
+	traces  []host.Trace
+	// samples counts the handled samples, used to generate unique hashes.
+	samples uint64
+}
+
+// newPipeline creates a trace handler that is backed by a real process manager and
+// OTLP reporter, and a synthetic stream of raw traces for it to handle.
+func newPipeline(tb testing.TB) *pipeline {
+	tb.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.Cleanup(cancel)
+
+	rep, err := reporter.NewOTLPReporter()
+	require.NoError(tb, err)
+
+	mapper := processmanager.NewMapFileIDMapper()
+	pm, err := processmanager.New(ctx, make([]bool, config.MaxTracers), time.Hour, nil,
+		mapper, rep, nil, true)
+	require.NoError(tb, err)
+	pm.AttachInterpreterInstance(pipelinePID, libpf.OnDiskFileIdentifier{},
+		&fakePythonInstance{reported: make(map[host.Frame]libpf.Void)})
+
+	for i := 0; i < pipelineNativeFiles; i++ {
+		fileID := host.FileID(0x1000 + i)
+		mapper.Set(fileID, libpf.NewFileID(uint64(fileID), 0))
+	}
+
+	cacheSize := config.TraceCacheEntries()
+	bpfTraceCache, err := lru.New[host.TraceHash, libpf.TraceHash](
+		cacheSize, func(k host.TraceHash) uint32 { return uint32(k) })
+	require.NoError(tb, err)
+	umTraceCache, err := lru.New[libpf.TraceHash, libpf.Void](
+		cacheSize, libpf.TraceHash.Hash32)
+	require.NoError(tb, err)
+
+	return &pipeline{
+		handler: &traceHandler{
+			traceProcessor:           pm,
+			bpfTraceCache:            bpfTraceCache,
+			umTraceCache:             umTraceCache,
+			reporter:                 rep,
+			times:                    defaultTimes(),
+			containerMetadataHandler: noContainerMetadata{},
+		},
+		traces: generateTraces(pipelineTraces),
+	}
+}
+
+// generateTraces returns n raw traces with a Python stack on top of a native stack,
+// as the eBPF unwinder would produce them. A fixed seed keeps runs comparable.
+func generateTraces(n int) []host.Trace {
+	rnd := rand.New(rand.NewSource(42)) //nolint:gosec
+	traces := make([]host.Trace, n)
+	for i := range traces {
+		frames := make([]host.Frame, 0, pipelinePythonDepth+pipelineNativeDepth)
+		for j := 0; j < pipelinePythonDepth; j++ {
+			frames = append(frames, host.Frame{
+				File:   host.FileID(0x2000 + rnd.Intn(pipelinePythonFiles)),
+				Lineno: libpf.AddressOrLineno(1 + rnd.Intn(1024)),
+				Type:   libpf.PythonFrame,
+			})
+		}
+		for j := 0; j < pipelineNativeDepth; j++ {
+			frames = append(frames, host.Frame{
+				File:   host.FileID(0x1000 + rnd.Intn(pipelineNativeFiles)),
+				Lineno: libpf.AddressOrLineno(0x1000 + rnd.Intn(1<<20)),
+				Type:   libpf.NativeFrame,
+			})
+		}
+		traces[i] = host.Trace{
+			Comm:   "python3",
+			Frames: frames,
+			Hash:   host.TraceHash(i + 1),
+			KTime:  libpf.KTime(i),
+			PID:    pipelinePID,
+		}
+	}
+	return traces
+}
+
+// run handles n samples. If cold is set, each sample gets a new hash so that it
+// misses the eBPF trace cache and needs to be converted.
+func (p *pipeline) run(n int, cold bool) {
+	for i := 0; i < n; i++ {
+		trace := &p.traces[p.samples%uint64(len(p.traces))]
+		p.samples++
+		if cold {
+			// Offset the hash to not collide with the initial hashes.
+			trace.Hash = host.TraceHash(p.samples << 32)
+		}
+		p.handler.HandleTrace(trace)
+	}
+}
+
+// BenchmarkPipeline measures the per-sample cost of the symbolization and reporting
+// pipeline. Each operation is one sample. Run with:
+//
+//	go test -run=^$ -bench=Pipeline ./tracehandler/
+func BenchmarkPipeline(b *testing.B) {
+	b.Cleanup(silenceLogs())
+
+	for _, cold := range []bool{true, false} {
+		name := "warm"
+		if cold {
+			name = "cold"
+		}
+		cold := cold
+		b.Run(name, func(b *testing.B) {
+			p := newPipeline(b)
+			// Prime the caches, so that the warm case measures only cache hits.
+			p.run(len(p.traces), false)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			p.run(b.N, cold)
+			b.StopTimer()
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N), "ns/sample")
+		})
+	}
+}
+
+// TestPipelineAllocations guards against regressions of the allocations per sample.
+// The limits leave some headroom over the measured values.
+func TestPipelineAllocations(t *testing.T) {
+	t.Cleanup(silenceLogs())
+
+	tests := map[string]struct {
+		cold      bool
+		maxAllocs float64
+	}{
+		"cold": {cold: true, maxAllocs: 12},
+		"warm": {cold: false, maxAllocs: 3},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			p := newPipeline(t)
+			p.run(len(p.traces), false)
+
+			allocs := testing.AllocsPerRun(pipelineTraces, func() {
+				p.run(1, tc.cold)
+			})
+			t.Logf("%.1f allocations per sample", allocs)
+			if allocs > tc.maxAllocs {
+				t.Errorf("Got %.1f allocations per sample, expected at most %.0f",
+					allocs, tc.maxAllocs)
+			}
+		})
+	}
+}
+
+// silenceLogs raises the log level to avoid measuring per-frame debug logging and
+// returns a function restoring the previous level.
+func silenceLogs() func() {
+	level := log.GetLevel()
+	log.SetLevel(log.ErrorLevel)
+	return func() { log.SetLevel(level) }
+}
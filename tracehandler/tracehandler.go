@@ -11,12 +11,14 @@ package tracehandler
 import (
 	"context"
 	"fmt"
+	"path"
 	"time"
 
 	lru "github.com/elastic/go-freelru"
 	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/containermetadata"
 	"github.com/elastic/otel-profiling-agent/host"
+	hostcpu "github.com/elastic/otel-profiling-agent/hostmetadata/host"
 	"github.com/elastic/otel-profiling-agent/libpf"
 	"github.com/elastic/otel-profiling-agent/libpf/memorydebug"
 	"github.com/elastic/otel-profiling-agent/reporter"
@@ -28,6 +30,9 @@ import (
 // about failure to obtain metadata for a single PID.
 const metadataWarnInhibDuration = 1 * time.Minute
 
+// syscallLabel is the name of the label holding the system call a sample was taken in.
+const syscallLabel = "syscall.name"
+
 // Compile time check to make sure config.Times satisfies the interfaces.
 var _ Times = (*config.Times)(nil)
 
@@ -50,11 +55,28 @@ type TraceProcessor interface {
 	// is in essence an indicator that all Traces until that time have been now processed,
 	// and any events up to this time can be processed.
 	SymbolizationComplete(traceCaptureKTime libpf.KTime)
+
+	// ExecutableName returns the base name of the main executable of the process pid, or
+	// an empty string if it is not known.
+	ExecutableName(pid libpf.PID) string
+
+	// ProcessLabels returns the labels the process pid defined for its samples, or nil
+	// if there are none.
+	ProcessLabels(pid libpf.PID) map[string]string
 }
 
 // Compile time check to make sure Tracer satisfies the interfaces.
 var _ TraceProcessor = (*tracer.Tracer)(nil)
 
+// containerMetadataProvider retrieves the metadata of the pod or container a process
+// belongs to.
+type containerMetadataProvider interface {
+	GetContainerMetadata(pid libpf.PID) (containermetadata.ContainerMetadata, error)
+}
+
+// Compile time check to make sure containermetadata.Handler satisfies the interface.
+var _ containerMetadataProvider = (*containermetadata.Handler)(nil)
+
 // traceHandler provides functions for handling new traces and trace count updates
 // from the eBPF components.
 type traceHandler struct {
@@ -63,6 +85,8 @@ type traceHandler struct {
 	umTraceCacheMiss  uint64
 	bpfTraceCacheHit  uint64
 	bpfTraceCacheMiss uint64
+	// excludedThreadSamples counts the samples dropped by excludeThreads.
+	excludedThreadSamples uint64
 
 	traceProcessor TraceProcessor
 
@@ -79,18 +103,39 @@ type traceHandler struct {
 	reporter reporter.TraceReporter
 
 	// containerMetadataHandler retrieves the metadata associated with the pod or container.
-	containerMetadataHandler *containermetadata.Handler
+	containerMetadataHandler containerMetadataProvider
 
 	// metadataWarnInhib tracks inhibitions for warnings printed about failure to
 	// update container metadata (rate-limiting).
 	metadataWarnInhib *lru.LRU[libpf.PID, libpf.Void]
 
+	// coreTypes maps CPU numbers to the type of the core on hybrid CPUs. It is nil if
+	// samples are not labeled with the core type.
+	coreTypes map[int]hostcpu.CoreType
+
+	// excludeThreads holds shell patterns for the names of threads whose samples are
+	// dropped.
+	excludeThreads []string
+
+	// clock converts the sampling times of the traces to wall-clock times. If it is nil,
+	// the traces are stamped with the time they are handled at.
+	clock *wallClock
+
 	times Times
 }
 
+// noContainerMetadata is a containerMetadataProvider for processes that are not
+// running on this host, e.g. the processes of a raw dump.
+type noContainerMetadata struct{}
+
+func (noContainerMetadata) GetContainerMetadata(libpf.PID) (
+	containermetadata.ContainerMetadata, error) {
+	return containermetadata.ContainerMetadata{}, nil
+}
+
 // newTraceHandler creates a new traceHandler
-func newTraceHandler(ctx context.Context, rep reporter.TraceReporter,
-	traceProcessor TraceProcessor, times Times) (
+func newTraceHandler(rep reporter.TraceReporter, traceProcessor TraceProcessor,
+	containerMetadataHandler containerMetadataProvider, times Times) (
 	*traceHandler, error) {
 	cacheSize := config.TraceCacheEntries()
 
@@ -113,9 +158,21 @@ func newTraceHandler(ctx context.Context, rep reporter.TraceReporter,
 	}
 	metadataWarnInhib.SetLifetime(metadataWarnInhibDuration)
 
-	containerMetadataHandler, err := containermetadata.GetHandler(ctx, times.MonitorInterval())
-	if err != nil {
-		return nil, fmt.Errorf("failed to create container metadata handler: %v", err)
+	excludeThreads := config.ExcludeThreads()
+	for _, pattern := range excludeThreads {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid thread exclusion pattern '%s': %v", pattern, err)
+		}
+	}
+
+	var coreTypes map[int]hostcpu.CoreType
+	if config.LabelCoreType() {
+		if coreTypes, err = hostcpu.CoreTypes(); err != nil {
+			return nil, fmt.Errorf("failed to read CPU core types: %v", err)
+		}
+		if coreTypes == nil {
+			log.Infof("All CPU cores are of the same type, not labeling samples")
+		}
 	}
 
 	t := &traceHandler{
@@ -126,26 +183,83 @@ func newTraceHandler(ctx context.Context, rep reporter.TraceReporter,
 		times:                    times,
 		containerMetadataHandler: containerMetadataHandler,
 		metadataWarnInhib:        metadataWarnInhib,
+		coreTypes:                coreTypes,
+		excludeThreads:           excludeThreads,
 	}
 
 	return t, nil
 }
 
+// withLabel returns a copy of labels that additionally holds the label name with value.
+// The labels of the trace processor are shared between samples and must not be modified.
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
+
+// isExcluded reports whether the samples of the thread comm are dropped.
+func (m *traceHandler) isExcluded(comm string) bool {
+	for _, pattern := range m.excludeThreads {
+		if matched, _ := path.Match(pattern, comm); matched {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *traceHandler) HandleTrace(bpfTrace *host.Trace) {
 	timestamp := libpf.UnixTime32(libpf.NowAsUInt32())
+	if m.clock != nil {
+		timestamp = m.clock.unixTime(bpfTrace.KTime)
+	}
 	defer m.traceProcessor.SymbolizationComplete(bpfTrace.KTime)
 
+	if m.isExcluded(bpfTrace.Comm) {
+		m.excludedThreadSamples++
+		return
+	}
+
 	meta, err := m.containerMetadataHandler.GetContainerMetadata(bpfTrace.PID)
 	if err != nil {
 		log.Warnf("Failed to determine container info for trace: %v", err)
 	}
+	coreType := string(m.coreTypes[bpfTrace.CPU])
+	executable := m.traceProcessor.ExecutableName(bpfTrace.PID)
+	labels := m.traceProcessor.ProcessLabels(bpfTrace.PID)
+	if bpfTrace.Syscall != "" {
+		labels = withLabel(labels, syscallLabel, bpfTrace.Syscall)
+	}
+	// With sample weights, each kept sample stands for several samples of the raised
+	// sampling frequency, see config.SampleCount.
+	count := config.SampleCount(bpfTrace.PID)
+	eventMeta := &reporter.TraceEventMeta{
+		Timestamp:     timestamp,
+		Count:         count,
+		Weight:        bpfTrace.Period * uint64(count),
+		Comm:          bpfTrace.Comm,
+		Executable:    executable,
+		PodName:       meta.PodName,
+		ContainerName: meta.ContainerName,
+		Containerized: meta.Containerized,
+		PID:           bpfTrace.PID,
+		Cgroup:        meta.Cgroup,
+		CoreType:      coreType,
+		EventSet:      bpfTrace.EventSet,
+		Labels:        labels,
+	}
+	if config.GroupByThread() {
+		eventMeta.TID = bpfTrace.TID
+	}
 
 	// Fast path: if the trace is already known remotely, we just send a counter update.
 	postConvHash, traceKnown := m.bpfTraceCache.Get(bpfTrace.Hash)
 	if traceKnown {
 		m.bpfTraceCacheHit++
-		m.reporter.ReportCountForTrace(postConvHash, timestamp, 1,
-			bpfTrace.Comm, meta.PodName, meta.ContainerName)
+		m.reporter.ReportCountForTrace(postConvHash, eventMeta)
 		return
 	}
 	m.bpfTraceCacheMiss++
@@ -154,8 +268,7 @@ func (m *traceHandler) HandleTrace(bpfTrace *host.Trace) {
 	umTrace := m.traceProcessor.ConvertTrace(bpfTrace)
 	log.Debugf("Trace hash remap 0x%x -> 0x%x", bpfTrace.Hash, umTrace.Hash)
 	m.bpfTraceCache.Add(bpfTrace.Hash, umTrace.Hash)
-	m.reporter.ReportCountForTrace(umTrace.Hash, timestamp, 1,
-		bpfTrace.Comm, meta.PodName, meta.ContainerName)
+	m.reporter.ReportCountForTrace(umTrace.Hash, eventMeta)
 
 	// Trace already known to collector by UM hash?
 	if _, known := m.umTraceCache.Get(umTrace.Hash); known {
@@ -171,32 +284,61 @@ func (m *traceHandler) HandleTrace(bpfTrace *host.Trace) {
 
 // Start starts a goroutine that receives and processes trace updates over
 // the given channel. Updates are sent periodically to the collection agent.
+// The goroutine keeps processing trace updates until traceInChan is closed, so
+// that the traces still in flight on shutdown are reported. The returned channel
+// is closed once the goroutine has finished.
 func Start(ctx context.Context, rep reporter.TraceReporter, traceProcessor TraceProcessor,
 	traceInChan <-chan *host.Trace, times Times,
-) error {
-	handler, err := newTraceHandler(ctx, rep, traceProcessor, times)
+) (<-chan struct{}, error) {
+	containerMetadataHandler, err := containermetadata.GetHandler(ctx, times.MonitorInterval())
 	if err != nil {
-		return fmt.Errorf("failed to create traceHandler: %v", err)
+		return nil, fmt.Errorf("failed to create container metadata handler: %v", err)
 	}
+	handler, err := newTraceHandler(rep, traceProcessor, containerMetadataHandler, times)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create traceHandler: %v", err)
+	}
+	handler.clock = newWallClock(readClocks)
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		metricsTicker := time.NewTicker(times.MonitorInterval())
 		defer metricsTicker.Stop()
 
 		// Poll the output channels
 		for {
 			select {
-			case traceUpdate := <-traceInChan:
+			case traceUpdate, ok := <-traceInChan:
+				if !ok {
+					return
+				}
 				handler.HandleTrace(traceUpdate)
 			case <-metricsTicker.C:
 				handler.collectMetrics()
-			case <-ctx.Done():
-				return
 			}
 			// Output memory usage in debug builds.
 			memorydebug.DebugLogMemoryUsage()
 		}
 	}()
 
+	return done, nil
+}
+
+// Run processes the trace updates received over traceInChan until it is closed. Unlike
+// Start, it processes the traces in the calling goroutine and does not look up container
+// metadata. This allows feeding traces that were not sampled on this host, e.g. from a
+// raw dump, through the symbolization and reporting pipeline. As the monotonic sampling
+// times of such traces do not relate to the clocks of this host, the traces are stamped
+// with the time they are handled at.
+func Run(rep reporter.TraceReporter, traceProcessor TraceProcessor,
+	traceInChan <-chan *host.Trace) error {
+	handler, err := newTraceHandler(rep, traceProcessor, noContainerMetadata{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create traceHandler: %v", err)
+	}
+	for traceUpdate := range traceInChan {
+		handler.HandleTrace(traceUpdate)
+	}
 	return nil
 }
@@ -11,10 +11,12 @@ import (
 	"time"
 
 	"github.com/elastic/go-freelru"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/otel-profiling-agent/host"
 	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/reporter"
 )
 
 type fakeTimes struct {
@@ -42,6 +44,14 @@ func (f *fakeTraceProcessor) ConvertTrace(trace *host.Trace) *libpf.Trace {
 func (f *fakeTraceProcessor) SymbolizationComplete(libpf.KTime) {
 }
 
+func (f *fakeTraceProcessor) ExecutableName(libpf.PID) string {
+	return ""
+}
+
+func (f *fakeTraceProcessor) ProcessLabels(libpf.PID) map[string]string {
+	return nil
+}
+
 // arguments holds the inputs to test the appropriate functions.
 type arguments struct {
 	// trace holds the arguments for the function HandleTrace().
@@ -73,12 +83,12 @@ func (m *mockReporter) ReportFramesForTrace(trace *libpf.Trace) {
 }
 
 func (m *mockReporter) ReportCountForTrace(traceHash libpf.TraceHash,
-	_ libpf.UnixTime32, count uint16, _, _, _ string) {
+	meta *reporter.TraceEventMeta) {
 	m.reportedCounts = append(m.reportedCounts, reportedCount{
 		traceHash: traceHash,
-		count:     count,
+		count:     meta.Count,
 	})
-	m.t.Logf("reportCountForTrace: 0x%x count: %d", traceHash, count)
+	m.t.Logf("reportCountForTrace: 0x%x count: %d", traceHash, meta.Count)
 }
 
 func TestTraceHandler(t *testing.T) {
@@ -87,6 +97,7 @@ func TestTraceHandler(t *testing.T) {
 		expectedCounts []reportedCount
 		expectedTraces []reportedTrace
 		expireTimeout  time.Duration
+		excludeThreads []string
 	}{
 		// no input simulates a case where no data is provided as input
 		// to the functions of traceHandler.
@@ -113,6 +124,18 @@ func TestTraceHandler(t *testing.T) {
 				{traceHash: libpf.NewTraceHash(4, 4), count: 1},
 			},
 		},
+
+		// excluded thread simulates traces of which one is dropped by thread name.
+		"excluded thread": {input: []arguments{
+			{trace: &host.Trace{Hash: host.TraceHash(5), Comm: "GC Thread#0"}},
+			{trace: &host.Trace{Hash: host.TraceHash(6), Comm: "java"}},
+		},
+			excludeThreads: []string{"gc*", "GC Thread*"},
+			expectedTraces: []reportedTrace{{traceHash: libpf.NewTraceHash(6, 6)}},
+			expectedCounts: []reportedCount{
+				{traceHash: libpf.NewTraceHash(6, 6), count: 1},
+			},
+		},
 	}
 
 	for name, test := range tests {
@@ -132,11 +155,13 @@ func TestTraceHandler(t *testing.T) {
 			require.NotNil(t, t, umTraceCache)
 
 			tuh := &traceHandler{
-				traceProcessor: &fakeTraceProcessor{},
-				bpfTraceCache:  bpfTraceCache,
-				umTraceCache:   umTraceCache,
-				reporter:       r,
-				times:          defaultTimes(),
+				traceProcessor:           &fakeTraceProcessor{},
+				bpfTraceCache:            bpfTraceCache,
+				umTraceCache:             umTraceCache,
+				reporter:                 r,
+				times:                    defaultTimes(),
+				containerMetadataHandler: noContainerMetadata{},
+				excludeThreads:           test.excludeThreads,
 			}
 
 			for _, input := range test.input {
@@ -177,3 +202,13 @@ func TestTraceHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestWithLabel(t *testing.T) {
+	labels := map[string]string{"tenant": "acme"}
+	result := withLabel(labels, syscallLabel, "read")
+	assert.Equal(t, map[string]string{"tenant": "acme", "syscall.name": "read"}, result)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, labels)
+
+	assert.Equal(t, map[string]string{"syscall.name": "read"},
+		withLabel(nil, syscallLabel, "read"))
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracehandler
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// clockRefreshInterval is the interval, in monotonic time, at which the mapping from
+// monotonic to wall-clock time is measured again. This keeps up with adjustments of the
+// wall clock, e.g. by NTP.
+const clockRefreshInterval = libpf.KTime(time.Second)
+
+// minSuspendTime is the minimum growth of the total suspend time that is treated as
+// suspend. Smaller differences result from the clocks not being read at the same instant.
+const minSuspendTime = int64(10 * time.Millisecond)
+
+// clockReading holds the values of the clocks involved in the mapping, in nanoseconds.
+type clockReading struct {
+	// ktime is the monotonic time, as returned by bpf_ktime_get_ns.
+	ktime libpf.KTime
+	// wall is the wall-clock time since the Unix epoch.
+	wall int64
+	// boot is the monotonic time including the time the system was suspended, or 0 if
+	// it is not known.
+	boot int64
+}
+
+// readClocks reads the current values of the clocks.
+func readClocks() clockReading {
+	var boot unix.Timespec
+	ktime := libpf.GetKTime()
+	wall := time.Now().UnixNano()
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &boot); err != nil {
+		// Without a boot time suspends are not detected, but the mapping is still
+		// refreshed periodically.
+		return clockReading{ktime: ktime, wall: wall}
+	}
+	return clockReading{ktime: ktime, wall: wall, boot: boot.Nano()}
+}
+
+// clockMapping maps monotonic time to wall-clock time.
+type clockMapping struct {
+	// measured is the monotonic time the mapping was measured at.
+	measured libpf.KTime
+	// offset is the difference of the wall-clock time to the monotonic time.
+	offset int64
+	// suspended is the total time the system was suspended for.
+	suspended int64
+}
+
+func newClockMapping(r clockReading) clockMapping {
+	m := clockMapping{
+		measured: r.ktime,
+		offset:   r.wall - int64(r.ktime),
+	}
+	if r.boot != 0 {
+		m.suspended = r.boot - int64(r.ktime)
+	}
+	return m
+}
+
+// wallClock converts the monotonic times traces are sampled at to wall-clock times.
+//
+// A fixed offset between the clocks drifts as the wall clock is adjusted, so the offset is
+// measured again periodically. The monotonic clock does not advance while the system
+// is suspended, which moves the offset by the time of the suspend. Traces sampled before a
+// suspend, but converted after it, keep the offset from before the suspend.
+type wallClock struct {
+	read func() clockReading
+
+	current clockMapping
+	// previous is the mapping from before the most recent suspend. It applies to the
+	// traces sampled until previousUntil, the last time it was known to be valid.
+	previous      clockMapping
+	previousUntil libpf.KTime
+}
+
+func newWallClock(read func() clockReading) *wallClock {
+	return &wallClock{
+		read:    read,
+		current: newClockMapping(read()),
+	}
+}
+
+// refresh measures the mapping again.
+func (c *wallClock) refresh() {
+	m := newClockMapping(c.read())
+	if m.suspended-c.current.suspended >= minSuspendTime {
+		c.previous = c.current
+		c.previousUntil = c.current.measured
+	}
+	c.current = m
+}
+
+// unixTime returns the wall-clock time of the monotonic time ktime.
+func (c *wallClock) unixTime(ktime libpf.KTime) libpf.UnixTime32 {
+	if ktime-c.current.measured >= clockRefreshInterval {
+		c.refresh()
+	}
+	offset := c.current.offset
+	if ktime <= c.previousUntil {
+		offset = c.previous.offset
+	}
+	return libpf.UnixTime32((int64(ktime) + offset) / int64(time.Second))
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracehandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// fakeClocks simulates the clocks of a system booted at the Unix time boot.
+type fakeClocks struct {
+	boot      int64
+	ktime     libpf.KTime
+	suspended int64
+	// wallAdjust is the adjustment of the wall clock, e.g. by NTP.
+	wallAdjust int64
+}
+
+func (f *fakeClocks) read() clockReading {
+	return clockReading{
+		ktime: f.ktime,
+		wall:  f.boot + int64(f.ktime) + f.suspended + f.wallAdjust,
+		boot:  int64(f.ktime) + f.suspended,
+	}
+}
+
+func (f *fakeClocks) advance(d time.Duration) {
+	f.ktime += libpf.KTime(d)
+}
+
+func TestWallClock(t *testing.T) {
+	const boot = int64(1700000000 * time.Second)
+	clocks := &fakeClocks{boot: boot, ktime: libpf.KTime(100 * time.Second)}
+	c := newWallClock(clocks.read)
+
+	assert.Equal(t, libpf.UnixTime32(1700000100), c.unixTime(clocks.ktime))
+
+	// Adjustments of the wall clock are picked up with the next refresh.
+	clocks.wallAdjust = int64(3 * time.Second)
+	clocks.advance(2 * time.Second)
+	assert.Equal(t, libpf.UnixTime32(1700000105), c.unixTime(clocks.ktime))
+
+	// The monotonic clock does not advance during a suspend of one hour.
+	beforeSuspend := clocks.ktime
+	clocks.suspended = int64(time.Hour)
+	clocks.advance(2 * time.Second)
+	afterSuspend := clocks.ktime
+	assert.Equal(t, libpf.UnixTime32(1700003707), c.unixTime(afterSuspend))
+	// Traces sampled before the suspend keep their time.
+	assert.Equal(t, libpf.UnixTime32(1700000105), c.unixTime(beforeSuspend))
+}
+
+func TestWallClockWithoutBootTime(t *testing.T) {
+	clocks := &fakeClocks{ktime: libpf.KTime(100 * time.Second)}
+	c := newWallClock(func() clockReading {
+		r := clocks.read()
+		r.boot = 0
+		return r
+	})
+	for i := 0; i < 3; i++ {
+		clocks.advance(2 * time.Second)
+		assert.Equal(t, libpf.UnixTime32(102+2*i), c.unixTime(clocks.ktime))
+	}
+	assert.Equal(t, libpf.KTime(0), c.previousUntil)
+}
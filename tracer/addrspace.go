@@ -0,0 +1,70 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// candidateVABits lists the supported sizes in bits of the user virtual address space, in
+// descending order:
+//   - 56: x86_64 with 5-level paging (la57)
+//   - 52: ARM64 with 52-bit virtual addresses (LVA)
+//   - 48: ARM64 with 4 level page tables and 4K or 64K pages
+//   - 47: x86_64 with 4-level paging, ARM64 with 16K pages
+//   - 42, 39, 36: ARM64 with fewer page table levels
+var candidateVABits = []uint{56, 52, 48, 47, 42, 39, 36}
+
+// defaultVABits returns the size in bits of the user virtual address space that is used if
+// it can not be determined.
+func defaultVABits() uint {
+	if runtime.GOARCH == "arm64" {
+		return 48
+	}
+	return 47
+}
+
+// probeUserVABits determines the size in bits of the user virtual address space of the
+// running kernel. It tries to map a page at the start of the upper half of each candidate
+// address space size. The kernel honors such a hint only if the address is part of the
+// user address space. Both the x86_64 5-level paging and the ARM64 52-bit address space
+// require such a hint to map any memory above the default 47 or 48 bits.
+func probeUserVABits() uint {
+	pageSize := uintptr(os.Getpagesize())
+	for _, bits := range candidateVABits {
+		hint := uintptr(1) << (bits - 1)
+		addr, _, errno := unix.Syscall6(unix.SYS_MMAP, hint, pageSize, unix.PROT_NONE,
+			unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_NORESERVE, ^uintptr(0), 0)
+		if errno != 0 {
+			continue
+		}
+		_, _, _ = unix.Syscall(unix.SYS_MUNMAP, addr, pageSize, 0)
+		if addr >= hint {
+			return bits
+		}
+	}
+	return defaultVABits()
+}
+
+// kernelAddressStart returns the start of the kernel half of the virtual address space for
+// a user address space of vaBits bits. Canonical kernel addresses have all bits above the
+// user address bits set.
+func kernelAddressStart(vaBits uint) uint64 {
+	return ^uint64(0) << vaBits
+}
+
+// pacStripMask returns the mask of the bits that are stripped from ARM64 code pointers, given
+// the PAC mask detected at runtime and the size of the user address space. As user space code
+// addresses never have bits above the user address bits set, these are stripped as well. This
+// keeps return addresses symbolizable if the PAC mask could not be determined, e.g. because
+// the kernel does not expose it, and also removes tags in the top byte (TBI).
+func pacStripMask(pacMask uint64, vaBits uint) uint64 {
+	return pacMask | kernelAddressStart(vaBits)
+}
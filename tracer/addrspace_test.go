@@ -0,0 +1,48 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKernelAddressStart(t *testing.T) {
+	tests := map[uint]uint64{
+		47: 0xffff800000000000,
+		48: 0xffff000000000000,
+		52: 0xfff0000000000000,
+		56: 0xff00000000000000,
+	}
+	for vaBits, expected := range tests {
+		assert.Equal(t, expected, kernelAddressStart(vaBits), "%d bits", vaBits)
+	}
+}
+
+func TestPACStripMask(t *testing.T) {
+	// Without PAC mask, all bits outside the user address space are stripped.
+	assert.Equal(t, uint64(0xffff000000000000), pacStripMask(0, 48))
+	// The detected PAC mask is kept, even if it covers bits of the user address space.
+	assert.Equal(t, uint64(0xff7f000000000000), pacStripMask(0x007f000000000000, 56))
+	assert.Equal(t, uint64(0xffff000000000000), pacStripMask(0x007f000000000000, 48))
+
+	ret := uint64(0x003c0000004005d4)
+	assert.Equal(t, uint64(0x4005d4), ret&^pacStripMask(0, 48))
+}
+
+func TestProbeUserVABits(t *testing.T) {
+	vaBits := probeUserVABits()
+	assert.Contains(t, candidateVABits, vaBits)
+
+	// Addresses of this process are part of the user address space.
+	var local int
+	addr := uint64(uintptr(unsafe.Pointer(&local)))
+	assert.Less(t, addr, uint64(1)<<vaBits)
+	assert.Less(t, addr, kernelAddressStart(vaBits))
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	cebpf "github.com/cilium/ebpf"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/elastic/otel-profiling-agent/config"
+	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
+)
+
+// verifierLogOptions returns the options to load eBPF programs with the verifier log level
+// and size configured with -bpf-log-level and -bpf-log-size.
+func verifierLogOptions() cebpf.ProgramOptions {
+	logLevel, logSize := config.BpfVerifierLogSetting()
+	return cebpf.ProgramOptions{
+		LogLevel: cebpf.LogLevel(logLevel),
+		LogSize:  logSize,
+	}
+}
+
+// logVerifierLog logs the complete verifier log of a failed eBPF program load at debug
+// level. The error itself only contains a summary of the last lines of the log.
+func logVerifierLog(progName string, err error) {
+	var verifierErr *cebpf.VerifierError
+	if !errors.As(err, &verifierErr) {
+		return
+	}
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		log.Infof("Enable verbose mode to log the eBPF verifier output for %s", progName)
+		return
+	}
+
+	// These logs tend to have hundreds of lines, so we print each line individually.
+	log.Debugf("eBPF verifier log for %s:", progName)
+	scanner := bufio.NewScanner(strings.NewReader(fmt.Sprintf("%+v", verifierErr)))
+	for scanner.Scan() {
+		log.Debug(scanner.Text())
+	}
+}
+
+// programLimitMessages are the messages of the verifier log that report a program exceeding
+// the size or complexity limits of the kernel.
+var programLimitMessages = []string{
+	"BPF program is too large",
+	"jumps is too complex",
+	"too many states",
+	"combined stack size",
+}
+
+// exceedsProgramLimits returns true if err reports that an eBPF program failed to load as
+// it exceeds the instruction or complexity limits of the kernel. Older kernels limit
+// programs to 4096 instructions and reject larger programs with E2BIG.
+func exceedsProgramLimits(err error) bool {
+	if errors.Is(err, unix.E2BIG) {
+		return true
+	}
+	var verifierErr *cebpf.VerifierError
+	if !errors.As(err, &verifierErr) {
+		return false
+	}
+	for _, line := range verifierErr.Log {
+		for _, message := range programLimitMessages {
+			if strings.Contains(line, message) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// programStats holds the cumulative statistics of an eBPF program as reported by the
+// kernel.
+type programStats struct {
+	runCount uint64
+	runTime  time.Duration
+}
+
+// programStatsDelta is the change of the statistics of a named eBPF program between two
+// readings.
+type programStatsDelta struct {
+	name string
+	programStats
+}
+
+// readProgramStats returns the statistics of the given eBPF programs. Programs for which
+// no statistics are available are omitted.
+func readProgramStats(progs map[string]*cebpf.Program) map[string]programStats {
+	stats := make(map[string]programStats, len(progs))
+	for name, prog := range progs {
+		info, err := prog.Info()
+		if err != nil {
+			log.Debugf("Failed to get info of eBPF program %s: %v", name, err)
+			continue
+		}
+		runCount, ok := info.RunCount()
+		if !ok {
+			continue
+		}
+		runTime, _ := info.Runtime()
+		stats[name] = programStats{runCount: runCount, runTime: runTime}
+	}
+	return stats
+}
+
+// diffProgramStats returns the change of the statistics from prev to cur, ordered by
+// descending run time. Programs that did not run are omitted.
+func diffProgramStats(prev, cur map[string]programStats) []programStatsDelta {
+	deltas := make([]programStatsDelta, 0, len(cur))
+	for name, stats := range cur {
+		old := prev[name]
+		if stats.runCount <= old.runCount {
+			continue
+		}
+		deltas = append(deltas, programStatsDelta{
+			name: name,
+			programStats: programStats{
+				runCount: stats.runCount - old.runCount,
+				runTime:  stats.runTime - old.runTime,
+			},
+		})
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].runTime != deltas[j].runTime {
+			return deltas[i].runTime > deltas[j].runTime
+		}
+		return deltas[i].name < deltas[j].name
+	})
+	return deltas
+}
+
+// StartBPFStats enables the collection of run time statistics for eBPF programs in the
+// kernel and logs the run count and run time of each loaded program every interval.
+// Collecting the statistics adds a small overhead to each program invocation. The
+// collection is disabled again when ctx is canceled. Requires Linux 5.8 or newer.
+func (t *Tracer) StartBPFStats(ctx context.Context, interval time.Duration) error {
+	stats, err := cebpf.EnableStats(unix.BPF_STATS_RUN_TIME)
+	if err != nil {
+		return fmt.Errorf("failed to enable eBPF statistics: %v",
+			capabilityError(opBPFStats, err))
+	}
+	go func() {
+		<-ctx.Done()
+		if err := stats.Close(); err != nil {
+			log.Errorf("Failed to disable eBPF statistics: %v", err)
+		}
+	}()
+
+	prev := readProgramStats(t.ebpfProgs)
+	periodiccaller.Start(ctx, interval, func() {
+		cur := readProgramStats(t.ebpfProgs)
+		for _, delta := range diffProgramStats(prev, cur) {
+			log.WithFields(log.Fields{
+				"program":  delta.name,
+				"runCount": delta.runCount,
+				"runTime":  delta.runTime,
+				"avgTime":  delta.runTime / time.Duration(delta.runCount),
+			}).Infof("eBPF program statistics for the last %v", interval)
+		}
+		prev = cur
+	})
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	cebpf "github.com/cilium/ebpf"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestDiffProgramStats(t *testing.T) {
+	prev := map[string]programStats{
+		"unwind_native":       {runCount: 100, runTime: 10 * time.Millisecond},
+		"unwind_python":       {runCount: 10, runTime: 5 * time.Millisecond},
+		"native_tracer_entry": {runCount: 50, runTime: time.Millisecond},
+	}
+	cur := map[string]programStats{
+		"unwind_native":       {runCount: 150, runTime: 12 * time.Millisecond},
+		"unwind_python":       {runCount: 20, runTime: 15 * time.Millisecond},
+		"native_tracer_entry": {runCount: 50, runTime: time.Millisecond},
+		"unwind_stop":         {runCount: 5, runTime: 2 * time.Millisecond},
+	}
+
+	assert.Equal(t, []programStatsDelta{
+		{name: "unwind_python", programStats: programStats{10, 10 * time.Millisecond}},
+		{name: "unwind_native", programStats: programStats{50, 2 * time.Millisecond}},
+		{name: "unwind_stop", programStats: programStats{5, 2 * time.Millisecond}},
+	}, diffProgramStats(prev, cur))
+
+	assert.Empty(t, diffProgramStats(cur, cur))
+}
+
+func TestExceedsProgramLimits(t *testing.T) {
+	tests := map[string]struct {
+		err     error
+		exceeds bool
+	}{
+		"too many instructions": {
+			err:     fmt.Errorf("load program: %w", unix.E2BIG),
+			exceeds: true,
+		},
+		"too large": {
+			err: &cebpf.VerifierError{
+				Cause: unix.E2BIG,
+				Log: []string{"BPF program is too large. Processed 131073 insn",
+					"processed 131073 insns (limit 131072)"},
+			},
+			exceeds: true,
+		},
+		"too complex": {
+			err: &cebpf.VerifierError{
+				Cause: unix.EINVAL,
+				Log:   []string{"The sequence of 8193 jumps is too complex."},
+			},
+			exceeds: true,
+		},
+		"invalid access": {
+			err: &cebpf.VerifierError{
+				Cause: unix.EACCES,
+				Log:   []string{"R1 invalid mem access 'scalar'"},
+			},
+		},
+		"other error": {
+			err: errors.New("permission denied"),
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.exceeds, exceedsProgramLimits(test.err))
+		})
+	}
+}
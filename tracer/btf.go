@@ -0,0 +1,44 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadKernelTypes returns the BTF type information of the running kernel that is used to
+// apply the CO-RE relocations of the eBPF programs. If btfFile is set, the types are read
+// from this file, which allows shipping BTF for kernels that do not provide it. Otherwise,
+// the types are read from /sys/kernel/btf/vmlinux. A nil Spec without an error is returned
+// if the kernel does not provide BTF, in which case features depending on CO-RE are
+// disabled and kernel offsets are discovered at runtime.
+func loadKernelTypes(btfFile string) (*btf.Spec, error) {
+	if btfFile != "" {
+		spec, err := btf.LoadSpec(btfFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load BTF from %s: %v", btfFile, err)
+		}
+		log.Infof("Using kernel BTF from %s", btfFile)
+		return spec, nil
+	}
+
+	spec, err := btf.LoadKernelSpec()
+	if err != nil {
+		if errors.Is(err, btf.ErrNotSupported) {
+			log.Warnf("Kernel does not provide BTF: CO-RE dependent features are disabled " +
+				"and kernel offsets are discovered at runtime. Use -btf-file to provide " +
+				"BTF for this kernel.")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load kernel BTF: %v", err)
+	}
+	return spec, nil
+}
@@ -0,0 +1,26 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadKernelTypesFromFile(t *testing.T) {
+	_, err := loadKernelTypes(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	invalid := filepath.Join(t.TempDir(), "vmlinux.btf")
+	require.NoError(t, os.WriteFile(invalid, []byte("not BTF"), 0o644))
+	_, err = loadKernelTypes(invalid)
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/syndtr/gocapability/capability"
+)
+
+// privilegedOperation is an operation of the agent that requires capabilities.
+type privilegedOperation struct {
+	// description is what the operation does, e.g. "load the eBPF maps and programs".
+	description string
+	// caps are the capabilities the operation requires since Linux 5.8, which split
+	// CAP_BPF and CAP_PERFMON off CAP_SYS_ADMIN. Older kernels require CAP_SYS_ADMIN
+	// instead of them.
+	caps []capability.Cap
+}
+
+var (
+	opLoadEBPF = privilegedOperation{
+		description: "load the eBPF maps and programs",
+		caps:        []capability.Cap{capability.CAP_BPF, capability.CAP_PERFMON},
+	}
+	opPerfEvents = privilegedOperation{
+		description: "open the perf events and tracepoints the eBPF programs are attached to",
+		caps:        []capability.Cap{capability.CAP_PERFMON},
+	}
+	opReadProcesses = privilegedOperation{
+		description: "read the mappings and memory of other processes",
+		caps:        []capability.Cap{capability.CAP_SYS_PTRACE},
+	}
+	// Enabling the eBPF statistics is restricted to CAP_SYS_ADMIN on all kernels.
+	opBPFStats = privilegedOperation{
+		description: "enable the eBPF statistics",
+		caps:        []capability.Cap{capability.CAP_SYS_ADMIN},
+	}
+)
+
+// requiredOperations are the operations the agent can not profile without.
+var requiredOperations = []privilegedOperation{opLoadEBPF, opPerfEvents, opReadProcesses}
+
+// missingCapabilities returns the capabilities op requires on a kernel of the given version,
+// which are not available according to has.
+func (op privilegedOperation) missingCapabilities(major, minor uint32,
+	has func(capability.Cap) bool) []capability.Cap {
+	splitCaps := major > 5 || (major == 5 && minor >= 8)
+	var missing []capability.Cap
+	for _, c := range op.caps {
+		if c == capability.CAP_BPF || c == capability.CAP_PERFMON {
+			// CAP_SYS_ADMIN still grants what CAP_BPF and CAP_PERFMON allow.
+			if has(capability.CAP_SYS_ADMIN) {
+				continue
+			}
+			if !splitCaps {
+				c = capability.CAP_SYS_ADMIN
+			}
+		}
+		if !has(c) && !slices.Contains(missing, c) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// capabilityNames returns the names of caps as used in the documentation, e.g. "CAP_BPF".
+func capabilityNames(caps []capability.Cap) string {
+	names := make([]string, 0, len(caps))
+	for _, c := range caps {
+		names = append(names, "CAP_"+strings.ToUpper(c.String()))
+	}
+	return strings.Join(names, " and ")
+}
+
+// effectiveCapabilities returns a function reporting whether a capability is in the
+// effective set of the agent.
+func effectiveCapabilities() (func(capability.Cap) bool, error) {
+	caps, err := capability.NewPid2(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get capabilities: %v", err)
+	}
+	if err = caps.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load capabilities: %v", err)
+	}
+	return func(c capability.Cap) bool {
+		return caps.Get(capability.EFFECTIVE, c)
+	}, nil
+}
+
+// CheckCapabilities checks that the agent has the capabilities it needs for profiling. Since
+// Linux 5.8, these are CAP_BPF, CAP_PERFMON and CAP_SYS_PTRACE, so that full root privileges
+// are not required. Older kernels require CAP_SYS_ADMIN and CAP_SYS_PTRACE. The returned
+// error lists each missing capability with the operation that needs it.
+func CheckCapabilities() error {
+	major, minor, _, err := GetCurrentKernelVersion()
+	if err != nil {
+		return err
+	}
+	has, err := effectiveCapabilities()
+	if err != nil {
+		return err
+	}
+	return checkCapabilities(major, minor, has)
+}
+
+// checkCapabilities implements CheckCapabilities for a kernel of the given version and the
+// capabilities reported by has.
+func checkCapabilities(major, minor uint32, has func(capability.Cap) bool) error {
+	var missing []string
+	for _, op := range requiredOperations {
+		if caps := op.missingCapabilities(major, minor, has); len(caps) > 0 {
+			missing = append(missing, fmt.Sprintf("%s to %s", capabilityNames(caps),
+				op.description))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("the agent lacks capabilities, it needs %s", strings.Join(missing, ", "))
+}
+
+// capabilityError adds the capabilities op requires to err, if err is due to missing
+// permissions and the agent lacks any of them. Otherwise, err is returned as is.
+func capabilityError(op privilegedOperation, err error) error {
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	major, minor, _, versionErr := GetCurrentKernelVersion()
+	has, capsErr := effectiveCapabilities()
+	if versionErr != nil || capsErr != nil {
+		return err
+	}
+	missing := op.missingCapabilities(major, minor, has)
+	if len(missing) == 0 {
+		return err
+	}
+	verb := "is"
+	if len(missing) > 1 {
+		verb = "are"
+	}
+	return fmt.Errorf("%w: %s %s needed to %s", err, capabilityNames(missing), verb,
+		op.description)
+}
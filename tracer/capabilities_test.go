@@ -0,0 +1,100 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/gocapability/capability"
+)
+
+// withCapabilities returns a function reporting caps as available.
+func withCapabilities(caps ...capability.Cap) func(capability.Cap) bool {
+	return func(c capability.Cap) bool {
+		for _, available := range caps {
+			if c == available {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	tests := map[string]struct {
+		major, minor uint32
+		caps         []capability.Cap
+		expected     string
+	}{
+		"minimal": {
+			major: 5, minor: 8,
+			caps: []capability.Cap{capability.CAP_BPF, capability.CAP_PERFMON,
+				capability.CAP_SYS_PTRACE},
+		},
+		"root": {
+			major: 6, minor: 1,
+			caps: []capability.Cap{capability.CAP_SYS_ADMIN, capability.CAP_SYS_PTRACE},
+		},
+		"old kernel with admin": {
+			major: 5, minor: 4,
+			caps: []capability.Cap{capability.CAP_SYS_ADMIN, capability.CAP_SYS_PTRACE},
+		},
+		"no perfmon": {
+			major: 5, minor: 15,
+			caps: []capability.Cap{capability.CAP_BPF, capability.CAP_SYS_PTRACE},
+			expected: "the agent lacks capabilities, it needs CAP_PERFMON to load the eBPF " +
+				"maps and programs, CAP_PERFMON to open the perf events and tracepoints " +
+				"the eBPF programs are attached to",
+		},
+		"no ptrace": {
+			major: 6, minor: 8,
+			caps: []capability.Cap{capability.CAP_BPF, capability.CAP_PERFMON},
+			expected: "the agent lacks capabilities, it needs CAP_SYS_PTRACE to read the " +
+				"mappings and memory of other processes",
+		},
+		"old kernel without admin": {
+			major: 5, minor: 4,
+			caps: []capability.Cap{capability.CAP_BPF, capability.CAP_PERFMON,
+				capability.CAP_SYS_PTRACE},
+			expected: "the agent lacks capabilities, it needs CAP_SYS_ADMIN to load the " +
+				"eBPF maps and programs, CAP_SYS_ADMIN to open the perf events and " +
+				"tracepoints the eBPF programs are attached to",
+		},
+		"none": {
+			major: 5, minor: 10,
+			expected: "the agent lacks capabilities, it needs CAP_BPF and CAP_PERFMON to " +
+				"load the eBPF maps and programs, CAP_PERFMON to open the perf events and " +
+				"tracepoints the eBPF programs are attached to, CAP_SYS_PTRACE to read " +
+				"the mappings and memory of other processes",
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			err := checkCapabilities(test.major, test.minor, withCapabilities(test.caps...))
+			if test.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expected)
+		})
+	}
+}
+
+func TestMissingCapabilities(t *testing.T) {
+	has := withCapabilities(capability.CAP_PERFMON)
+	assert.Equal(t, []capability.Cap{capability.CAP_BPF},
+		opLoadEBPF.missingCapabilities(5, 8, has))
+	assert.Equal(t, []capability.Cap{capability.CAP_SYS_ADMIN},
+		opLoadEBPF.missingCapabilities(5, 7, has))
+	assert.Equal(t, []capability.Cap{capability.CAP_SYS_ADMIN},
+		opBPFStats.missingCapabilities(6, 1, has))
+	assert.Empty(t, opBPFStats.missingCapabilities(6, 1,
+		withCapabilities(capability.CAP_SYS_ADMIN)))
+}
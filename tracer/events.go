@@ -35,6 +35,10 @@ const (
 	// so that the hostagent startup phase can wait on most PID notifications
 	// to be processed before starting the tracer.
 	pidEventBufferSize = 10
+
+	// perfDrainTimeout bounds the time spent reading the events left in a perf
+	// event buffer on shutdown.
+	perfDrainTimeout = 2 * time.Second
 )
 
 // StartPIDEventProcessor spawns a goroutine to process PID events.
@@ -85,7 +89,8 @@ func (t *Tracer) triggerPidEvent(data []byte) {
 // perf event map by waiting for events the kernel. Every event in the buffer
 // will wake up user-land.
 //
-// For each received event, triggerFunc is called. triggerFunc may NOT store
+// For each received event, triggerFunc is called with the number of the CPU
+// that wrote the event and the event data. triggerFunc may NOT store
 // references into the buffer that it is given: the buffer is re-used across
 // calls. Returns a function that can be called to retrieve perf event array
 // error counts.
@@ -133,13 +138,21 @@ func startPerfEventMonitor(ctx context.Context, perfEventMap *ebpf.Map,
 // the given perf event map by periodically polling the perf event buffer.
 // Events written to the perf event buffer do not wake user-land immediately.
 //
-// For each received event, triggerFunc is called. triggerFunc may NOT store
+// For each received event, triggerFunc is called with the number of the CPU
+// that wrote the event and the event data. triggerFunc may NOT store
 // references into the buffer that it is given: the buffer is re-used across
-// calls. Returns a function that can be called to retrieve perf event array
-// error counts.
+// calls. For each record of lost events, lostFunc is called with the number of
+// the CPU whose events were lost and their count.
+//
+// Once ctx is done, stopFunc is called to stop the producers of the events,
+// and the events left in the buffer are read for up to perfDrainTimeout before
+// the reader is closed. Returns a function that can be called to retrieve perf
+// event array error counts, and a channel that is closed when the goroutine
+// has finished.
 func startPollingPerfEventMonitor(ctx context.Context, perfEventMap *ebpf.Map,
-	pollFrequency time.Duration, perCPUBufferSize int, triggerFunc func([]byte),
-) func() (lost, noData, readError uint64) {
+	pollFrequency time.Duration, perCPUBufferSize int, triggerFunc func(cpu int, raw []byte),
+	lostFunc func(cpu int, lost uint64), stopFunc func(),
+) (getCounts func() (lost, noData, readError uint64), done <-chan struct{}) {
 	eventReader, err := perf.NewReader(perfEventMap, perCPUBufferSize)
 	if err != nil {
 		log.Fatalf("Failed to setup perf reporting via %s: %v", perfEventMap, err)
@@ -153,37 +166,57 @@ func startPollingPerfEventMonitor(ctx context.Context, perfEventMap *ebpf.Map,
 	pollTicker := time.NewTicker(pollFrequency)
 
 	var lostEventsCount, readErrorCount, noDataCount atomic.Uint64
+	doneChan := make(chan struct{})
+
+	// readEvents eagerly reads events until the buffer is exhausted or the
+	// deadline has passed. It returns false in the latter case.
+	readEvents := func(data *perf.Record, deadline time.Time) bool {
+		for {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return false
+			}
+			if err := eventReader.ReadInto(data); err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					readErrorCount.Add(1)
+				}
+				return true
+			}
+			if data.LostSamples != 0 {
+				lostEventsCount.Add(data.LostSamples)
+				lostFunc(data.CPU, data.LostSamples)
+				continue
+			}
+			if len(data.RawSample) == 0 {
+				noDataCount.Add(1)
+				continue
+			}
+			triggerFunc(data.CPU, data.RawSample)
+		}
+	}
+
 	go func() {
+		defer close(doneChan)
+		defer pollTicker.Stop()
 		var data perf.Record
 
 	PollLoop:
 		for {
 			select {
 			case <-pollTicker.C:
-				// Continue execution below.
+				readEvents(&data, time.Time{})
 			case <-ctx.Done():
 				break PollLoop
 			}
+		}
 
-			// Eagerly read events until the buffer is exhausted.
-			for {
-				if err = eventReader.ReadInto(&data); err != nil {
-					if !errors.Is(err, os.ErrDeadlineExceeded) {
-						readErrorCount.Add(1)
-					}
-
-					break
-				}
-				if data.LostSamples != 0 {
-					lostEventsCount.Add(data.LostSamples)
-					continue
-				}
-				if len(data.RawSample) == 0 {
-					noDataCount.Add(1)
-					continue
-				}
-				triggerFunc(data.RawSample)
-			}
+		// Stop new events from being written before draining the buffer, so
+		// that the events recorded up to this point are not lost on shutdown.
+		stopFunc()
+		if !readEvents(&data, time.Now().Add(perfDrainTimeout)) {
+			log.Warnf("Timed out draining %s after %v", perfEventMap, perfDrainTimeout)
+		}
+		if err := eventReader.Close(); err != nil {
+			log.Errorf("Failed to close perf event reader for %s: %v", perfEventMap, err)
 		}
 	}()
 
@@ -192,7 +225,7 @@ func startPollingPerfEventMonitor(ctx context.Context, perfEventMap *ebpf.Map,
 		noData = noDataCount.Swap(0)
 		readError = readErrorCount.Swap(0)
 		return
-	}
+	}, doneChan
 }
 
 // startEventMonitor spawns a goroutine that receives events from the
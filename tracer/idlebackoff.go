@@ -0,0 +1,142 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
+	"github.com/elastic/otel-profiling-agent/metrics"
+	"github.com/elastic/otel-profiling-agent/support"
+)
+
+const (
+	// idleBackoffThreshold is the fraction of perf events interrupting idle CPUs at or
+	// above which an interval counts as idle.
+	idleBackoffThreshold = 0.95
+
+	// idleBackoffIntervals is the number of consecutive idle intervals after which the
+	// sampling frequency is lowered one step, so that only sustained idleness backs off.
+	idleBackoffIntervals = 6
+
+	// idleBackoffMaxFactor is the factor by which the sampling frequency is lowered at most.
+	idleBackoffMaxFactor = 8
+)
+
+// idleBackoff keeps the state for lowering the sampling frequency while the host is idle.
+type idleBackoff struct {
+	// divisor is the factor by which the configured sampling frequency is lowered.
+	divisor int
+
+	// idleIntervals counts the consecutive idle intervals since the last frequency change.
+	idleIntervals int
+
+	lastIdle   metrics.MetricValue
+	lastActive metrics.MetricValue
+}
+
+func newIdleBackoff(idle, active metrics.MetricValue) *idleBackoff {
+	return &idleBackoff{
+		divisor:    1,
+		lastIdle:   idle,
+		lastActive: active,
+	}
+}
+
+// update takes the cumulative numbers of perf events that interrupted idle and active CPUs
+// and returns the fraction of idle events since the last call, and the factor by which the
+// configured sampling frequency should be lowered from now on. The frequency is halved
+// after idleBackoffIntervals idle intervals, and the configured one is restored by the
+// first interval that is not idle.
+func (b *idleBackoff) update(idle, active metrics.MetricValue) (idleFraction float64,
+	divisor int) {
+	idleDelta := idle - b.lastIdle
+	activeDelta := active - b.lastActive
+	b.lastIdle = idle
+	b.lastActive = active
+
+	if idleDelta+activeDelta == 0 {
+		// Without samples, e.g. while profiling is disabled, nothing is known.
+		return 0, b.divisor
+	}
+	idleFraction = float64(idleDelta) / float64(idleDelta+activeDelta)
+
+	if idleFraction < idleBackoffThreshold {
+		b.idleIntervals = 0
+		b.divisor = 1
+		return idleFraction, b.divisor
+	}
+	b.idleIntervals++
+	if b.idleIntervals >= idleBackoffIntervals && b.divisor < idleBackoffMaxFactor {
+		b.idleIntervals = 0
+		b.divisor *= 2
+	}
+	return idleFraction, b.divisor
+}
+
+// readIdleCounts returns the cumulative numbers of perf events that interrupted idle and
+// active CPUs.
+func (t *Tracer) readIdleCounts() (idle, active metrics.MetricValue, err error) {
+	if idle, err = t.readEBPFMetric(support.MetricIDPerfEventsIdle); err != nil {
+		return 0, 0, err
+	}
+	if active, err = t.readEBPFMetric(support.MetricIDPerfEventsActive); err != nil {
+		return 0, 0, err
+	}
+	return idle, active, nil
+}
+
+// StartIdleBackoff periodically determines the fraction of perf events that interrupted an
+// idle CPU. While it stays above idleBackoffThreshold for a sustained period, the sampling
+// frequency is lowered step by step. Once the host becomes active again, the configured
+// frequency is restored immediately, so that bursts of activity are not missed.
+func (t *Tracer) StartIdleBackoff(ctx context.Context, interval time.Duration) error {
+	idle, active, err := t.readIdleCounts()
+	if err != nil {
+		return err
+	}
+	backoff := newIdleBackoff(idle, active)
+	log.Infof("Idle backoff enabled, sampling frequency may be lowered by a factor of %d",
+		idleBackoffMaxFactor)
+
+	periodiccaller.Start(ctx, interval, func() {
+		idle, active, err := t.readIdleCounts()
+		if err != nil {
+			log.Errorf("Failed to read idle perf event counts: %v", err)
+			return
+		}
+
+		prevDivisor := backoff.divisor
+		idleFraction, divisor := backoff.update(idle, active)
+		if divisor == prevDivisor {
+			return
+		}
+
+		prevFreq, freq, err := t.setSampleFreqDivisor(reducerIdleBackoff, divisor)
+		if err != nil {
+			log.Errorf("Failed to lower sampling frequency by a factor of %d: %v",
+				divisor, err)
+			// Keep the state in sync with the actual frequency of the perf events.
+			backoff.divisor = prevDivisor
+			return
+		}
+		if freq == prevFreq {
+			return
+		}
+		if freq < prevFreq {
+			log.Infof("Host idle %.0f%% of the time, lowering sampling frequency "+
+				"from %d Hz to %d Hz", idleFraction*100, prevFreq, freq)
+		} else {
+			log.Infof("Host active again, restoring sampling frequency of %d Hz", freq)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/otel-profiling-agent/metrics"
+)
+
+func TestIdleBackoff(t *testing.T) {
+	backoff := newIdleBackoff(0, 0)
+
+	var idle, active metrics.MetricValue
+	step := func(idleDelta, activeDelta metrics.MetricValue) int {
+		idle += idleDelta
+		active += activeDelta
+		_, divisor := backoff.update(idle, active)
+		return 20 / divisor
+	}
+
+	// Mostly idle, but below the threshold.
+	assert.Equal(t, 20, step(90, 10))
+	// Sustained idleness lowers the frequency step by step.
+	for i := 1; i < idleBackoffIntervals; i++ {
+		assert.Equal(t, 20, step(99, 1), "interval %d", i)
+	}
+	assert.Equal(t, 10, step(99, 1))
+	for i := 0; i < 3*idleBackoffIntervals; i++ {
+		step(99, 1)
+	}
+	assert.Equal(t, idleBackoffMaxFactor, backoff.divisor)
+	// Intervals without samples do not change anything.
+	assert.Equal(t, 20/idleBackoffMaxFactor, step(0, 0))
+	// A single active interval restores the configured frequency.
+	assert.Equal(t, 20, step(50, 50))
+	assert.Equal(t, 20, step(99, 1))
+}
+
+func TestIdleBackoffMaxFactor(t *testing.T) {
+	backoff := newIdleBackoff(0, 0)
+	for i := 1; i <= 8*idleBackoffIntervals; i++ {
+		_, divisor := backoff.update(metrics.MetricValue(i*100), 0)
+		assert.LessOrEqual(t, divisor, idleBackoffMaxFactor)
+	}
+	assert.Equal(t, idleBackoffMaxFactor, backoff.divisor)
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultKernelDenylist is the built-in list of kernel releases the agent does not load
+// its eBPF programs on, in the format accepted by MatchKernelDenylist.
+//
+// RHEL 7 kernels only carry a partial backport of eBPF as technology preview, which lacks
+// features the eBPF programs rely on. They report a 3.10 base version, so they are caught
+// by the kernel version check unless it is disabled.
+const DefaultKernelDenylist = "3.10.0-*.el7*"
+
+// ParseKernelRelease returns the major, minor and patch version of a kernel release as
+// reported by uname, e.g. "5.15.0-1019-aws" or "4.18.0-305.el8.x86_64". Distribution
+// suffixes are ignored, and missing minor or patch versions are returned as 0.
+func ParseKernelRelease(release string) (major, minor, patch uint32, err error) {
+	var version [3]uint32
+	rest := release
+	for i := range version {
+		end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' })
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			if i == 0 {
+				return 0, 0, 0, fmt.Errorf("invalid kernel release '%s'", release)
+			}
+			break
+		}
+		v, err := strconv.ParseUint(rest[:end], 10, 32)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid kernel release '%s': %v", release, err)
+		}
+		version[i] = uint32(v)
+		rest = rest[end:]
+		if !strings.HasPrefix(rest, ".") {
+			break
+		}
+		rest = rest[1:]
+	}
+	return version[0], version[1], version[2], nil
+}
+
+// MatchKernelDenylist returns the entry of the comma-separated denylist matching the
+// kernel release, or an empty string if none matches. An entry matches a release equal
+// to it or starting with it followed by a non-digit, e.g. "5.15" matches "5.15.0-91" but
+// not "5.150.1". Entries containing shell pattern characters are matched against the
+// complete release.
+func MatchKernelDenylist(release, denylist string) (string, error) {
+	for _, entry := range strings.Split(denylist, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.ContainsAny(entry, "*?[\\") {
+			matched, err := path.Match(entry, release)
+			if err != nil {
+				return "", fmt.Errorf("invalid kernel denylist entry '%s': %v", entry, err)
+			}
+			if matched {
+				return entry, nil
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(release, entry); ok {
+			if rest == "" || rest[0] < '0' || rest[0] > '9' {
+				return entry, nil
+			}
+		}
+	}
+	return "", nil
+}
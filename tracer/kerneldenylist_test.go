@@ -0,0 +1,60 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKernelRelease(t *testing.T) {
+	tests := map[string][3]uint32{
+		"5.15.0-1019-aws":         {5, 15, 0},
+		"4.18.0-305.el8.x86_64":   {4, 18, 0},
+		"6.8.12":                  {6, 8, 12},
+		"6.1":                     {6, 1, 0},
+		"6.7.0-rc1+":              {6, 7, 0},
+		"5.10.209-198.858.amzn2":  {5, 10, 209},
+		"3.10.0-1160.el7.x86_64":  {3, 10, 0},
+		"6.5.0-0.deb12.4-cloud-a": {6, 5, 0},
+	}
+	for release, expected := range tests {
+		major, minor, patch, err := ParseKernelRelease(release)
+		require.NoError(t, err, release)
+		assert.Equal(t, expected, [3]uint32{major, minor, patch}, release)
+	}
+
+	for _, release := range []string{"", "linux", "-5.4"} {
+		_, _, _, err := ParseKernelRelease(release)
+		assert.Error(t, err, release)
+	}
+}
+
+func TestMatchKernelDenylist(t *testing.T) {
+	tests := []struct {
+		release, denylist, expected string
+	}{
+		{"5.15.0-1019-aws", "5.15", "5.15"},
+		{"5.15.0-1019-aws", "5.1", ""},
+		{"5.150.1", "5.15", ""},
+		{"5.15.0-1019-aws", "4.19, 5.15.0-1019-aws", "5.15.0-1019-aws"},
+		{"5.15.0-1019-aws", "5.15.0-1019", "5.15.0-1019"},
+		{"5.15.0-1019-aws", "", ""},
+		{"3.10.0-1160.el7.x86_64", DefaultKernelDenylist, DefaultKernelDenylist},
+		{"4.18.0-305.el8.x86_64", DefaultKernelDenylist, ""},
+	}
+	for _, test := range tests {
+		entry, err := MatchKernelDenylist(test.release, test.denylist)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, entry, "%s in %s", test.release, test.denylist)
+	}
+
+	_, err := MatchKernelDenylist("5.15.0", "5.[")
+	assert.Error(t, err)
+}
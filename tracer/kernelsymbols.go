@@ -0,0 +1,98 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+	"github.com/elastic/otel-profiling-agent/proc"
+)
+
+// systemMapPaths are the locations of the System.map of a kernel release, which are tried
+// if kallsyms hides the kernel addresses.
+var systemMapPaths = []string{"/boot/System.map-%s", "/lib/modules/%s/build/System.map"}
+
+// readKernelSymbols returns the symbols of the running kernel. They are read from kallsyms,
+// which lists the symbols of the kernel image, the loaded modules and the JITed BPF programs.
+// If kallsyms hides the addresses, e.g. due to kptr_restrict, the System.map of the running
+// kernel is used instead. It only holds the symbols of the kernel image, and can only be used
+// if the kernel image is not randomized by KASLR.
+func readKernelSymbols() (*libpf.SymbolMap, error) {
+	kernelSymbols, err := proc.GetKallsyms("/proc/kallsyms")
+	if !errors.Is(err, proc.ErrKernelAddressesHidden) {
+		return kernelSymbols, err
+	}
+
+	release, releaseErr := GetCurrentKernelRelease()
+	if releaseErr != nil {
+		return nil, fmt.Errorf("%v, and %v", err, releaseErr)
+	}
+	// Missing files leave the randomization unknown, which is treated as randomized.
+	cmdline, _ := os.ReadFile("/proc/cmdline")
+	kernelConfig, _ := os.ReadFile(fmt.Sprintf("/boot/config-%s", release))
+	if kernelImageRandomized(string(cmdline), string(kernelConfig)) {
+		return nil, fmt.Errorf("%v, and System.map can not be used as the kernel "+
+			"image may be randomized", err)
+	}
+	for _, pattern := range systemMapPaths {
+		path := fmt.Sprintf(pattern, release)
+		if kernelSymbols, mapErr := proc.GetKallsyms(path); mapErr == nil {
+			log.Warnf("Kernel addresses are hidden in kallsyms, using the symbols of %s. "+
+				"Frames in kernel modules and BPF programs are not symbolized.", path)
+			return kernelSymbols, nil
+		}
+	}
+	return nil, fmt.Errorf("%v, and no System.map of kernel %s was found", err, release)
+}
+
+// kernelImageRandomized checks whether the kernel image may be placed at a random address
+// by KASLR, given the kernel command line and the kernel build configuration. An
+// unknown configuration counts as randomized.
+func kernelImageRandomized(cmdline, kernelConfig string) bool {
+	for _, param := range strings.Fields(cmdline) {
+		if param == "nokaslr" {
+			return false
+		}
+	}
+	if kernelConfig == "" {
+		return true
+	}
+	for _, line := range strings.Split(kernelConfig, "\n") {
+		if strings.TrimSpace(line) == "CONFIG_RANDOMIZE_BASE=y" {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleFileIDFromName returns the FileID of the kernel module with the given name, for
+// modules without GNU BuildID. As the name does not identify the module binary, it is
+// combined with the kernel release.
+func moduleFileIDFromName(name, release string) libpf.FileID {
+	return pfelf.CalculateKernelFileID("module:" + name + "@" + release)
+}
+
+// lookupKernelSymbol returns the kallsyms symbol of the kernel address addr, which belongs
+// to the kernel image or module starting at moduleStart. kallsyms holds the symbols of
+// the kernel image and of all loaded modules, but not their sizes, so the symbol preceding
+// an address in a module without symbols belongs to another module or the kernel image.
+// Such symbols are not returned.
+func lookupKernelSymbol(kernelSymbols *libpf.SymbolMap, addr,
+	moduleStart libpf.SymbolValue) (libpf.SymbolName, libpf.Address, bool) {
+	symbol, offset, ok := kernelSymbols.LookupByAddress(addr)
+	if !ok || addr-libpf.SymbolValue(offset) < moduleStart {
+		return "", 0, false
+	}
+	return symbol, offset, true
+}
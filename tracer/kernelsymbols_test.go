@@ -0,0 +1,64 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestLookupKernelSymbol(t *testing.T) {
+	symbols := &libpf.SymbolMap{}
+	symbols.Add(libpf.Symbol{Name: "do_syscall_64", Address: 0xffffffff81000100})
+	// The symbols of the module starting at 0xffffffffc0100000.
+	symbols.Add(libpf.Symbol{Name: "nvme_queue_rq", Address: 0xffffffffc0100200})
+	symbols.Finalize()
+
+	tests := map[string]struct {
+		addr, moduleStart libpf.SymbolValue
+		symbol            libpf.SymbolName
+		offset            libpf.Address
+	}{
+		"kernel image": {addr: 0xffffffff81000110, moduleStart: 0xffffffff81000000,
+			symbol: "do_syscall_64", offset: 0x10},
+		"module": {addr: 0xffffffffc0100240, moduleStart: 0xffffffffc0100000,
+			symbol: "nvme_queue_rq", offset: 0x40},
+		// A module without symbols, loaded after the one with symbols.
+		"module without symbols": {addr: 0xffffffffc0200040,
+			moduleStart: 0xffffffffc0200000},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			symbol, offset, ok := lookupKernelSymbol(symbols, test.addr, test.moduleStart)
+			assert.Equal(t, test.symbol != "", ok)
+			assert.Equal(t, test.symbol, symbol)
+			assert.Equal(t, test.offset, offset)
+		})
+	}
+}
+
+func TestModuleFileIDFromName(t *testing.T) {
+	id := moduleFileIDFromName("nvme", "6.1.0-18-amd64")
+	assert.Equal(t, id, moduleFileIDFromName("nvme", "6.1.0-18-amd64"))
+	assert.NotEqual(t, id, moduleFileIDFromName("nvme", "6.1.0-20-amd64"))
+	assert.NotEqual(t, id, moduleFileIDFromName("nvme_core", "6.1.0-18-amd64"))
+}
+
+func TestKernelImageRandomized(t *testing.T) {
+	const randomized = "CONFIG_RELOCATABLE=y\nCONFIG_RANDOMIZE_BASE=y\n"
+	const fixed = "CONFIG_RELOCATABLE=y\n# CONFIG_RANDOMIZE_BASE is not set\n"
+	const cmdline = "BOOT_IMAGE=/vmlinuz root=/dev/sda1 ro"
+
+	assert.True(t, kernelImageRandomized(cmdline, randomized))
+	assert.False(t, kernelImageRandomized(cmdline, fixed))
+	assert.False(t, kernelImageRandomized(cmdline+" nokaslr", randomized))
+	assert.True(t, kernelImageRandomized(cmdline, ""))
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/go-perf"
+)
+
+// PerfEvent selects the perf event that triggers the collection of stack traces.
+type PerfEvent int
+
+const (
+	// PerfEventCPUClock is the software CPU clock. It is available everywhere,
+	// including virtual machines without a virtualized PMU.
+	PerfEventCPUClock PerfEvent = iota
+	// PerfEventTaskClock is the software clock of the running task.
+	PerfEventTaskClock
+	// PerfEventCycles is the hardware CPU cycles counter.
+	PerfEventCycles
+	// PerfEventMajorFaults is the software event of major page faults, which wait for the
+	// page to be read from disk, e.g. from swap or a mapped file. Its samples show the
+	// memory latency hotspots of swap or mmap heavy workloads.
+	PerfEventMajorFaults
+)
+
+// perfEventNames maps the PerfEvent values to their command line names.
+var perfEventNames = map[PerfEvent]string{
+	PerfEventCPUClock:    "cpu-clock",
+	PerfEventTaskClock:   "task-clock",
+	PerfEventCycles:      "cycles",
+	PerfEventMajorFaults: "major-faults",
+}
+
+// PerfEventNames returns the names of all supported perf events.
+func PerfEventNames() []string {
+	return []string{
+		perfEventNames[PerfEventCPUClock],
+		perfEventNames[PerfEventTaskClock],
+		perfEventNames[PerfEventCycles],
+		perfEventNames[PerfEventMajorFaults],
+	}
+}
+
+// ParsePerfEvent returns the PerfEvent for its name as returned by PerfEvent.String.
+func ParsePerfEvent(name string) (PerfEvent, error) {
+	for event, eventName := range perfEventNames {
+		if strings.EqualFold(name, eventName) {
+			return event, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown perf event '%s'", name)
+}
+
+// String returns the name of the perf event.
+func (e PerfEvent) String() string {
+	if name, ok := perfEventNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(e))
+}
+
+// SampleType returns the type and the unit of the samples of the event in the reported
+// profiles, e.g. count and faults for major faults.
+func (e PerfEvent) SampleType() (sampleType, unit string) {
+	if e == PerfEventMajorFaults {
+		return "count", "faults"
+	}
+	return e.String(), "count"
+}
+
+// IsClock returns true if the event is a software clock, whose sample periods are the
+// nanoseconds of CPU time the samples stand for.
+func (e PerfEvent) IsClock() bool {
+	return e == PerfEventCPUClock || e == PerfEventTaskClock
+}
+
+// samplesEachEvent returns true if the event is sampled on each of its occurrences, i.e.
+// with a period of 1 instead of a frequency, so that each sample stands for one event.
+func (e PerfEvent) samplesEachEvent() bool {
+	return e == PerfEventMajorFaults
+}
+
+// IsHardware returns true if the event is generated by the PMU and thus might not be
+// supported by the CPU or hypervisor.
+func (e PerfEvent) IsHardware() bool {
+	return e == PerfEventCycles
+}
+
+// triggersWhileBusy returns true if the event triggers samples whenever the CPUs are busy,
+// unlike major faults, which may not occur for a long time.
+func (e PerfEvent) triggersWhileBusy() bool {
+	return e != PerfEventMajorFaults
+}
+
+// configurator returns the go-perf configurator for the event.
+func (e PerfEvent) configurator() perf.Configurator {
+	switch e {
+	case PerfEventTaskClock:
+		return perf.TaskClock
+	case PerfEventCycles:
+		return perf.CPUCycles
+	case PerfEventMajorFaults:
+		return perf.MajorPageFaults
+	default:
+		return perf.CPUClock
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePerfEvent(t *testing.T) {
+	for _, name := range PerfEventNames() {
+		event, err := ParsePerfEvent(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, event.String())
+	}
+
+	event, err := ParsePerfEvent("Task-Clock")
+	require.NoError(t, err)
+	assert.Equal(t, PerfEventTaskClock, event)
+	assert.False(t, event.IsHardware())
+
+	event, err = ParsePerfEvent("cycles")
+	require.NoError(t, err)
+	assert.True(t, event.IsHardware())
+	sampleType, unit := event.SampleType()
+	assert.Equal(t, "cycles", sampleType)
+	assert.Equal(t, "count", unit)
+	assert.False(t, event.IsClock())
+	assert.True(t, PerfEventTaskClock.IsClock())
+
+	event, err = ParsePerfEvent("major-faults")
+	require.NoError(t, err)
+	assert.Equal(t, PerfEventMajorFaults, event)
+	assert.False(t, event.IsHardware())
+	sampleType, unit = event.SampleType()
+	assert.Equal(t, "count", sampleType)
+	assert.Equal(t, "faults", unit)
+	assert.False(t, event.IsClock())
+	assert.True(t, event.samplesEachEvent())
+	assert.False(t, PerfEventCPUClock.samplesEachEvent())
+	assert.False(t, event.triggersWhileBusy())
+	assert.True(t, PerfEventCPUClock.triggersWhileBusy())
+
+	_, err = ParsePerfEvent("instructions")
+	assert.Error(t, err)
+	_, err = ParsePerfEvent("")
+	assert.Error(t, err)
+}
+
+func TestSamplePeriod(t *testing.T) {
+	assert.Equal(t, uint64(50_000_000), samplePeriod(20))
+	assert.Equal(t, uint64(1_000_000), samplePeriod(1000))
+	assert.Equal(t, uint64(1_000_000_000), samplePeriod(1))
+}
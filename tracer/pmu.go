@@ -0,0 +1,63 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/elastic/go-perf"
+)
+
+// pmuProbeIterations is the number of iterations of the busy loop that a hardware perf
+// event has to count while it is probed.
+const pmuProbeIterations = 1_000_000
+
+// errPMUNotCounting is returned by probeHardwareEvent if the event could be opened, but
+// did not count.
+var errPMUNotCounting = errors.New("event did not count")
+
+// pmuProbeSink receives the result of the busy loop, so that the loop is not optimized away.
+var pmuProbeSink uint64
+
+// probeHardwareEvent checks that the hardware perf event can be used to sample. Virtual
+// machines without a virtualized PMU do not support opening the event. Virtual machines
+// with a partially virtualized PMU may support opening it, but the event never counts and
+// never triggers a sample. So the event is also checked to count while the calling thread
+// runs a busy loop.
+func probeHardwareEvent(event PerfEvent) error {
+	attr := new(perf.Attr)
+	if err := event.configurator().Configure(attr); err != nil {
+		return err
+	}
+	attr.Options.Disabled = true
+
+	// The event measures the calling thread, which thus has to stay the same.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	perfEvent, err := perf.Open(attr, perf.CallingThread, perf.AnyCPU, nil)
+	if err != nil {
+		return err
+	}
+	defer perfEvent.Close()
+
+	count, err := perfEvent.Measure(func() {
+		var v uint64
+		for i := uint64(0); i < pmuProbeIterations; i++ {
+			v = v*31 + i
+		}
+		pmuProbeSink = v
+	})
+	if err != nil {
+		return err
+	}
+	if count.Value == 0 {
+		return errPMUNotCounting
+	}
+	return nil
+}
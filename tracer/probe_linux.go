@@ -10,7 +10,6 @@
 package tracer
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"strings"
@@ -34,9 +33,21 @@ func ProbeBPFSyscall() error {
 	return nil
 }
 
+// tracingPaths are the locations of the tracing file system. tracefs is preferred, as it is
+// also mounted in restricted environments that hide debugfs, whose mount point is usually
+// only accessible with CAP_SYS_ADMIN or CAP_DAC_OVERRIDE.
+var tracingPaths = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
 // getTracepointID returns the system specific tracepoint ID for a given tracepoint.
 func getTracepointID(tracepoint string) (uint64, error) {
-	id, err := os.ReadFile("/sys/kernel/debug/tracing/events/syscalls/" + tracepoint + "/id")
+	var id []byte
+	var err error
+	for _, path := range tracingPaths {
+		id, err = os.ReadFile(path + "/events/syscalls/" + tracepoint + "/id")
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to read tracepoint ID for %s: %v", tracepoint, err)
 	}
@@ -44,15 +55,24 @@ func getTracepointID(tracepoint string) (uint64, error) {
 	return tid, nil
 }
 
+// GetCurrentKernelRelease returns the release of the kernel of the host from the utsname
+// struct, e.g. "5.15.0-1019-aws".
+func GetCurrentKernelRelease() (string, error) {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return "", fmt.Errorf("could not get Kernel Version: %v", err)
+	}
+	return unix.ByteSliceToString(uname.Release[:]), nil
+}
+
 // GetCurrentKernelVersion returns the major, minor and patch version of the kernel of the host
 // from the utsname struct.
 func GetCurrentKernelVersion() (major, minor, patch uint32, err error) {
-	var uname unix.Utsname
-	if err := unix.Uname(&uname); err != nil {
-		return 0, 0, 0, fmt.Errorf("could not get Kernel Version: %v", err)
+	release, err := GetCurrentKernelRelease()
+	if err != nil {
+		return 0, 0, 0, err
 	}
-	fmt.Fscanf(bytes.NewReader(uname.Release[:]), "%d.%d.%d", &major, &minor, &patch)
-	return major, minor, patch, nil
+	return ParseKernelRelease(release)
 }
 
 // ProbeTracepoint checks if tracepoints are available on the system, so we can attach
@@ -78,14 +98,16 @@ func ProbeTracepoint() error {
 	}
 	defer restoreRlimit()
 
-	prog, err := cebpf.NewProgram(&cebpf.ProgramSpec{
+	prog, err := cebpf.NewProgramWithOptions(&cebpf.ProgramSpec{
 		Type:          cebpf.TracePoint,
 		License:       "GPL",
 		Instructions:  ins,
 		KernelVersion: kernelVersion,
-	})
+	}, verifierLogOptions())
 	if err != nil {
-		return fmt.Errorf("failed to create tracepoint_probe: %v", err)
+		logVerifierLog("tracepoint_probe", err)
+		return fmt.Errorf("failed to create tracepoint_probe: %v",
+			capabilityError(opLoadEBPF, err))
 	}
 	defer prog.Close()
 
@@ -106,7 +128,7 @@ func ProbeTracepoint() error {
 
 	pfd, err := unix.PerfEventOpen(&attr, -1, 0, -1, unix.PERF_FLAG_FD_CLOEXEC)
 	if err != nil {
-		return fmt.Errorf("unable to open perf events: %v", err)
+		return fmt.Errorf("unable to open perf events: %v", capabilityError(opPerfEvents, err))
 	}
 	defer func() {
 		if err = unix.Close(pfd); err != nil {
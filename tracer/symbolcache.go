@@ -0,0 +1,74 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"sync/atomic"
+
+	lru "github.com/elastic/go-freelru"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// cachedSymbol is the result of a symbol lookup of an address.
+type cachedSymbol struct {
+	name   libpf.SymbolName
+	offset libpf.Address
+	found  bool
+}
+
+// symbolCache caches the symbols of hot addresses, so that the addresses sampled over and
+// over again, e.g. in loops, are not resolved again for each sample. The entries are keyed
+// by the FileID of the executable and the address in it. As the FileID identifies the
+// contents of the executable, an executable that changed gets a new FileID, so that the
+// entries of the old contents are not hit again and age out. A nil symbolCache resolves
+// each address.
+type symbolCache struct {
+	symbols *lru.SyncedLRU[libpf.FrameID, cachedSymbol]
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// newSymbolCache returns a symbolCache holding up to size entries, or nil if size is 0.
+func newSymbolCache(size uint32) (*symbolCache, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	symbols, err := lru.NewSynced[libpf.FrameID, cachedSymbol](size, libpf.FrameID.Hash32)
+	if err != nil {
+		return nil, err
+	}
+	return &symbolCache{symbols: symbols}, nil
+}
+
+// lookup returns the symbol of addr in the executable fileID and the offset of addr from
+// the symbol, calling resolve if they are not cached yet.
+func (c *symbolCache) lookup(fileID libpf.FileID, addr libpf.AddressOrLineno,
+	resolve func() (libpf.SymbolName, libpf.Address, bool)) (libpf.SymbolName,
+	libpf.Address, bool) {
+	if c == nil {
+		return resolve()
+	}
+	frameID := libpf.NewFrameID(fileID, addr)
+	if sym, ok := c.symbols.Get(frameID); ok {
+		c.hits.Add(1)
+		return sym.name, sym.offset, sym.found
+	}
+	c.misses.Add(1)
+	name, offset, found := resolve()
+	c.symbols.Add(frameID, cachedSymbol{name: name, offset: offset, found: found})
+	return name, offset, found
+}
+
+// stats returns and resets the number of cache hits and misses.
+func (c *symbolCache) stats() (hits, misses uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Swap(0), c.misses.Swap(0)
+}
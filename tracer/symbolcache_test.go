@@ -0,0 +1,69 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestSymbolCache(t *testing.T) {
+	resolved := 0
+	resolver := func(name libpf.SymbolName, found bool) func() (libpf.SymbolName,
+		libpf.Address, bool) {
+		return func() (libpf.SymbolName, libpf.Address, bool) {
+			resolved++
+			return name, 0x10, found
+		}
+	}
+
+	c, err := newSymbolCache(16)
+	require.NoError(t, err)
+	fileID := libpf.NewFileID(1, 2)
+	for i := 0; i < 3; i++ {
+		name, offset, found := c.lookup(fileID, 0x1000, resolver("do_sys_open", true))
+		assert.Equal(t, libpf.SymbolName("do_sys_open"), name)
+		assert.Equal(t, libpf.Address(0x10), offset)
+		assert.True(t, found)
+	}
+	// Failed lookups are cached as well.
+	for i := 0; i < 2; i++ {
+		_, _, found := c.lookup(fileID, 0x2000, resolver("", false))
+		assert.False(t, found)
+	}
+	// The same address in another executable is resolved separately.
+	name, _, _ := c.lookup(libpf.NewFileID(3, 4), 0x1000, resolver("ext4_read", true))
+	assert.Equal(t, libpf.SymbolName("ext4_read"), name)
+
+	assert.Equal(t, 3, resolved)
+	hits, misses := c.stats()
+	assert.Equal(t, uint64(3), hits)
+	assert.Equal(t, uint64(3), misses)
+	hits, misses = c.stats()
+	assert.Zero(t, hits)
+	assert.Zero(t, misses)
+}
+
+func TestSymbolCacheDisabled(t *testing.T) {
+	c, err := newSymbolCache(0)
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	resolved := 0
+	for i := 0; i < 2; i++ {
+		c.lookup(libpf.NewFileID(1, 2), 0x1000, func() (libpf.SymbolName, libpf.Address,
+			bool) {
+			resolved++
+			return "do_sys_open", 0, true
+		})
+	}
+	assert.Equal(t, 2, resolved)
+}
@@ -0,0 +1,27 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"strings"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+// syscallSymbolPrefixes are the prefixes of the kernel functions that system calls enter
+// on x86_64 and arm64, e.g. __x64_sys_read for read(2).
+var syscallSymbolPrefixes = []string{"__x64_sys_", "__arm64_sys_"}
+
+// syscallName returns the name of the system call if symbol is the entry function of one.
+func syscallName(symbol libpf.SymbolName) (string, bool) {
+	for _, prefix := range syscallSymbolPrefixes {
+		if name, ok := strings.CutPrefix(string(symbol), prefix); ok && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+)
+
+func TestSyscallName(t *testing.T) {
+	tests := map[libpf.SymbolName]string{
+		"__x64_sys_read":         "read",
+		"__arm64_sys_epoll_wait": "epoll_wait",
+		"__x64_sys_":             "",
+		"__ia32_sys_read":        "",
+		"do_syscall_64":          "",
+		"vfs_read":               "",
+	}
+	for symbol, expected := range tests {
+		name, ok := syscallName(symbol)
+		assert.Equal(t, expected != "", ok, symbol)
+		assert.Equal(t, expected, name, symbol)
+	}
+}
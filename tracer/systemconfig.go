@@ -10,6 +10,8 @@ package tracer
 import "C"
 
 import (
+	"fmt"
+	"runtime"
 	"unsafe"
 
 	"github.com/elastic/otel-profiling-agent/config"
@@ -22,6 +24,11 @@ import (
 
 func loadSystemConfig(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 	kernelSymbols *libpf.SymbolMap, includeTracers []bool) error {
+	vaBits := probeUserVABits()
+	kernelStart := kernelAddressStart(vaBits)
+	log.Debugf("Determined %d bit user address space, kernel addresses start at 0x%016X",
+		vaBits, kernelStart)
+
 	pacMask := pacmask.GetPACMask()
 
 	if pacMask != uint64(0) {
@@ -29,12 +36,18 @@ func loadSystemConfig(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 	} else {
 		log.Debug("PAC is not enabled on the system.")
 	}
+	if runtime.GOARCH == "arm64" {
+		// Return addresses may carry PAC bits even if the mask could not be determined,
+		// so additionally strip all bits outside of the user address space.
+		pacMask = pacStripMask(pacMask, vaBits)
+	}
 
 	// In eBPF, we need the mask to AND off the PAC bits, so we invert it.
 	invPacMask := ^pacMask
 
 	var tpbaseOffset uint64
-	if includeTracers[config.PerlTracer] || includeTracers[config.PythonTracer] {
+	if includeTracers[config.PerlTracer] || includeTracers[config.PythonTracer] ||
+		includeTracers[config.RubyTracer] || includeTracers[config.BEAMTracer] {
 		var err error
 		tpbaseOffset, err = loadTPBaseOffset(coll, maps, kernelSymbols)
 		if err != nil {
@@ -42,13 +55,45 @@ func loadSystemConfig(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 		}
 	}
 
+	if config.VerifyUnwindRate() != 0 && runtime.GOARCH != "amd64" {
+		log.Warnf("Checking stack deltas against frame pointers is not supported on %s",
+			runtime.GOARCH)
+	}
+
 	cfg := C.SystemConfig{
 		inverse_pac_mask:       C.u64(invPacMask),
 		tpbase_offset:          C.u64(tpbaseOffset),
+		kernel_address_start:   C.u64(kernelStart),
 		drop_error_only_traces: C.bool(true),
+		verify_unwind_rate:     C.u32(config.VerifyUnwindRate()),
 	}
 
 	key0 := uint32(0)
 	return maps["system_config"].Update(unsafe.Pointer(&key0), unsafe.Pointer(&cfg),
 		cebpf.UpdateAny)
 }
+
+// loadSampleWeights fills pid_sample_weights with the configured sample weights. The key 0
+// holds the factor the sampling frequency is raised by, and is only set if there are
+// weights, so that all samples are kept otherwise.
+func loadSampleWeights(maps map[string]*cebpf.Map) error {
+	weights := config.PIDSampleWeights()
+	if len(weights) == 0 {
+		return nil
+	}
+	weightsMap := maps["pid_sample_weights"]
+	for pid, weight := range weights {
+		key := uint32(pid)
+		if err := weightsMap.Update(unsafe.Pointer(&key), unsafe.Pointer(&weight),
+			cebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to set sample weight of PID %d: %v", pid, err)
+		}
+	}
+	key0 := uint32(0)
+	factor := config.SampleWeightFactor()
+	if err := weightsMap.Update(unsafe.Pointer(&key0), unsafe.Pointer(&factor),
+		cebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to set sample weight factor: %v", err)
+	}
+	return nil
+}
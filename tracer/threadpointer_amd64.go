@@ -0,0 +1,29 @@
+//go:build amd64
+
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// archGetFS is the arch_prctl code to get the FS base of the calling thread.
+const archGetFS = 0x1003
+
+// currentThreadPointer returns the thread pointer of the calling thread, which is the FS
+// base on x86_64, or 0 if it could not be read. The goroutine has to be locked to its thread.
+func currentThreadPointer() uint64 {
+	var fsBase uint64
+	if _, _, errno := unix.RawSyscall(unix.SYS_ARCH_PRCTL, archGetFS,
+		uintptr(unsafe.Pointer(&fsBase)), 0); errno != 0 {
+		return 0
+	}
+	return fsBase
+}
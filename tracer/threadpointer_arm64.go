@@ -0,0 +1,18 @@
+//go:build arm64
+
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+// readTPIDR returns the value of the TPIDR_EL0 register.
+func readTPIDR() uint64
+
+// currentThreadPointer returns the thread pointer of the calling thread, which is held in
+// TPIDR_EL0 on arm64. The goroutine has to be locked to its thread.
+func currentThreadPointer() uint64 {
+	return readTPIDR()
+}
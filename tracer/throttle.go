@@ -0,0 +1,147 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
+	"github.com/elastic/otel-profiling-agent/proc"
+)
+
+const (
+	// selfThrottleMinFrequency is the lowest sampling frequency the self-throttle
+	// will reduce the sampling frequency to.
+	selfThrottleMinFrequency = 1
+
+	// selfThrottleRestoreFactor defines, relative to the throttle threshold, the CPU
+	// usage below which the sampling frequency is no longer lowered. Using a lower
+	// value than the threshold avoids flapping between the two states.
+	selfThrottleRestoreFactor = 0.5
+)
+
+// selfThrottle keeps the state for lowering the sampling frequency when the CPU usage of
+// the agent approaches the CPU quota of its cgroup.
+type selfThrottle struct {
+	// quota is the CPU quota of the agent cgroup expressed as a number of CPUs.
+	quota float64
+	// threshold is the fraction of quota at which the sampling frequency is lowered.
+	threshold float64
+
+	// divisor is the factor by which the configured sampling frequency is lowered.
+	divisor int
+
+	lastCPUTime  time.Duration
+	lastWallTime time.Time
+}
+
+func newSelfThrottle(quota, threshold float64, cpuTime time.Duration,
+	now time.Time) *selfThrottle {
+	return &selfThrottle{
+		quota:        quota,
+		threshold:    threshold,
+		divisor:      1,
+		lastCPUTime:  cpuTime,
+		lastWallTime: now,
+	}
+}
+
+// update calculates the fraction of the CPU quota used since the last call and returns
+// the factor by which the configured sampling frequency sampleFreq should be lowered from
+// now on.
+func (s *selfThrottle) update(cpuTime time.Duration, now time.Time, sampleFreq int) (
+	usage float64, divisor int) {
+	wallDelta := now.Sub(s.lastWallTime)
+	cpuDelta := cpuTime - s.lastCPUTime
+	s.lastWallTime = now
+	s.lastCPUTime = cpuTime
+
+	if wallDelta <= 0 {
+		return 0, s.divisor
+	}
+	usage = float64(cpuDelta) / float64(wallDelta) / s.quota
+
+	switch {
+	case usage >= s.threshold && sampleFreq/s.divisor > selfThrottleMinFrequency:
+		s.divisor *= 2
+	case usage < s.threshold*selfThrottleRestoreFactor && s.divisor > 1:
+		s.divisor = 1
+	}
+	return usage, s.divisor
+}
+
+// getSelfCPUTime returns the user and system CPU time consumed by the agent.
+func getSelfCPUTime() (time.Duration, error) {
+	var rusage unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &rusage); err != nil {
+		return 0, err
+	}
+	return time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano()), nil
+}
+
+// StartSelfThrottle periodically compares the CPU usage of the agent with the CPU quota
+// of its cgroup. If the usage exceeds threshold (a fraction of the quota), the sampling
+// frequency is lowered. Once the CPU pressure drops, the configured frequency is restored.
+func (t *Tracer) StartSelfThrottle(ctx context.Context, interval time.Duration,
+	threshold float64) error {
+	quota, err := proc.GetCPUQuota("/proc/self/cgroup", proc.DefaultCgroupMountPoint)
+	if err != nil {
+		return fmt.Errorf("failed to read cgroup CPU quota: %v", err)
+	}
+	if quota == 0 {
+		log.Infof("No cgroup CPU quota configured, self-throttling is inactive")
+		return nil
+	}
+
+	cpuTime, err := getSelfCPUTime()
+	if err != nil {
+		return fmt.Errorf("failed to fetch Rusage: %v", err)
+	}
+	throttle := newSelfThrottle(quota, threshold, cpuTime, time.Now())
+	log.Infof("Self-throttling enabled at %.0f%% of a CPU quota of %.2f CPUs",
+		threshold*100, quota)
+
+	periodiccaller.Start(ctx, interval, func() {
+		cpuTime, err := getSelfCPUTime()
+		if err != nil {
+			log.Errorf("Failed to fetch Rusage: %v", err)
+			return
+		}
+
+		prevDivisor := throttle.divisor
+		usage, divisor := throttle.update(cpuTime, time.Now(), t.SampleFrequency())
+		if divisor == prevDivisor {
+			return
+		}
+
+		prevFreq, freq, err := t.setSampleFreqDivisor(reducerSelfThrottle, divisor)
+		if err != nil {
+			log.Errorf("Failed to lower sampling frequency by a factor of %d: %v",
+				divisor, err)
+			// Keep the state in sync with the actual frequency of the perf events.
+			throttle.divisor = prevDivisor
+			return
+		}
+		if freq == prevFreq {
+			return
+		}
+		if divisor > prevDivisor {
+			log.Warnf("Agent CPU usage at %.0f%% of cgroup quota, throttling sampling "+
+				"frequency from %d Hz to %d Hz", usage*100, prevFreq, freq)
+		} else {
+			log.Infof("Agent CPU usage at %.0f%% of cgroup quota, restoring sampling "+
+				"frequency of %d Hz", usage*100, freq)
+		}
+	})
+
+	return nil
+}
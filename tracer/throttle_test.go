@@ -0,0 +1,57 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfThrottle(t *testing.T) {
+	start := time.Unix(1000, 0)
+	// Quota of half a CPU, throttle at 80% of that.
+	throttle := newSelfThrottle(0.5, 0.8, 0, start)
+
+	steps := []struct {
+		cpuDelta time.Duration
+		usage    float64
+		freq     int
+	}{
+		// 0.1 CPU -> 20% of the quota
+		{cpuDelta: 100 * time.Millisecond, usage: 0.2, freq: 20},
+		// 0.45 CPU -> 90% of the quota
+		{cpuDelta: 450 * time.Millisecond, usage: 0.9, freq: 10},
+		{cpuDelta: 450 * time.Millisecond, usage: 0.9, freq: 5},
+		// 0.3 CPU -> 60% of the quota, above the restore level
+		{cpuDelta: 300 * time.Millisecond, usage: 0.6, freq: 5},
+		// 0.15 CPU -> 30% of the quota, below the restore level
+		{cpuDelta: 150 * time.Millisecond, usage: 0.3, freq: 20},
+	}
+
+	now := start
+	cpuTime := time.Duration(0)
+	for i, step := range steps {
+		now = now.Add(time.Second)
+		cpuTime += step.cpuDelta
+		usage, divisor := throttle.update(cpuTime, now, 20)
+		assert.InDelta(t, step.usage, usage, 0.001, "step %d", i)
+		assert.Equal(t, step.freq, 20/divisor, "step %d", i)
+	}
+}
+
+func TestSelfThrottleMinFrequency(t *testing.T) {
+	start := time.Unix(1000, 0)
+	throttle := newSelfThrottle(1, 0.5, 0, start)
+
+	for i := 1; i <= 3; i++ {
+		_, divisor := throttle.update(time.Duration(i)*time.Second, start.Add(
+			time.Duration(i)*time.Second), 2)
+		assert.Equal(t, selfThrottleMinFrequency, 2/divisor)
+	}
+}
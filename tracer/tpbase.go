@@ -6,15 +6,24 @@
 
 package tracer
 
+// #include "../support/ebpf/types.h"
+import "C"
+
 import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"unsafe"
 
 	cebpf "github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
+	"golang.org/x/sys/unix"
 
+	"github.com/elastic/otel-profiling-agent/config"
 	"github.com/elastic/otel-profiling-agent/libpf/rlimit"
 	"github.com/elastic/otel-profiling-agent/support"
 	"github.com/elastic/otel-profiling-agent/tpbase"
@@ -39,6 +48,87 @@ import (
 //    address=$(cat /boot/System.map-5.6.11 | grep "T aout_dump_debugregs" | awk '{print $1}')
 // 3) Disassemble the kernel ELF starting at that address:
 //    objdump -S --start-address=0x$address kernel.elf | head -20
+//
+// The extracted offset is validated by reading the thread pointer of the agent itself
+// through it, as offsets that are within the sane range can still be wrong, e.g. for the
+// different task_struct layout of PREEMPT_RT kernels.
+
+// realtimeKernelFile holds 1 on kernels built with PREEMPT_RT.
+const realtimeKernelFile = "/sys/kernel/realtime"
+
+// TPBaseOffsetBounds is the range of tpbase offsets that are accepted as sane.
+type TPBaseOffsetBounds struct {
+	Min, Max uint32
+}
+
+// String returns the bounds in the format accepted by ParseTPBaseOffsetBounds.
+func (b TPBaseOffsetBounds) String() string {
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+// ParseTPBaseOffsetBounds parses bounds in the format MIN-MAX, e.g. "500-20000".
+func ParseTPBaseOffsetBounds(s string) (TPBaseOffsetBounds, error) {
+	minStr, maxStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return TPBaseOffsetBounds{}, fmt.Errorf("invalid tpbase offset bounds '%s'", s)
+	}
+	minVal, err := strconv.ParseUint(minStr, 10, 32)
+	if err != nil {
+		return TPBaseOffsetBounds{}, fmt.Errorf("invalid minimum tpbase offset: %v", err)
+	}
+	maxVal, err := strconv.ParseUint(maxStr, 10, 32)
+	if err != nil {
+		return TPBaseOffsetBounds{}, fmt.Errorf("invalid maximum tpbase offset: %v", err)
+	}
+	if minVal == 0 || minVal > maxVal {
+		return TPBaseOffsetBounds{}, fmt.Errorf("invalid tpbase offset bounds '%s'", s)
+	}
+	return TPBaseOffsetBounds{Min: uint32(minVal), Max: uint32(maxVal)}, nil
+}
+
+// defaultTPBaseOffsetBounds returns the bounds for the tpbase offsets found on arch. We
+// expect something in the ~2000-10000 range, but allow for some additional slack on top of
+// that. On arm64 the thread pointer is kept in thread_struct at the end of task_struct,
+// which grows considerably with debugging options enabled in the kernel.
+func defaultTPBaseOffsetBounds(arch string) TPBaseOffsetBounds {
+	if arch == "arm64" {
+		return TPBaseOffsetBounds{Min: 500, Max: 40000}
+	}
+	return TPBaseOffsetBounds{Min: 500, Max: 20000}
+}
+
+// attachSysEnterBPF loads the eBPF program progName and attaches it to the sys_enter_bpf
+// tracepoint, so that it runs on each bpf syscall, e.g. the map lookup that reads its result.
+// The returned function detaches and unloads the program again.
+func attachSysEnterBPF(coll *cebpf.CollectionSpec, progName string) (func(), error) {
+	restoreRlimit, err := rlimit.MaximizeMemlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to adjust rlimit: %v", err)
+	}
+	defer restoreRlimit()
+
+	progSpec, ok := coll.Programs[progName]
+	if !ok {
+		return nil, fmt.Errorf("program %s is not available", progName)
+	}
+	prog, err := cebpf.NewProgramWithOptions(progSpec, verifierLogOptions())
+	if err != nil {
+		logVerifierLog(progName, err)
+		return nil, fmt.Errorf("failed to load %s: %v", progName,
+			capabilityError(opLoadEBPF, err))
+	}
+
+	perfEvent, err := link.Tracepoint("syscalls", "sys_enter_bpf", prog, nil)
+	if err != nil {
+		prog.Close()
+		return nil, fmt.Errorf("failed to configure tracepoint: %v",
+			capabilityError(opPerfEvents, err))
+	}
+	return func() {
+		perfEvent.Close()
+		prog.Close()
+	}, nil
+}
 
 // loadKernelCode will request the ebpf code read the first X bytes from given address.
 func loadKernelCode(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
@@ -55,26 +145,14 @@ func loadKernelCode(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 			functionAddress, err)
 	}
 
-	restoreRlimit, err := rlimit.MaximizeMemlock()
-	if err != nil {
-		return nil, fmt.Errorf("failed to adjust rlimit: %v", err)
-	}
-	defer restoreRlimit()
-
 	// Load a BPF program to load the function code in functionCode.
 	// Trigger it via a sys_enter_bpf tracepoint so we can easily ensure the code is run at
 	// least once before we read the map for the result. Hacky? Maybe...
-	prog, err := cebpf.NewProgram(coll.Programs["tracepoint__sys_enter_bpf"])
+	detach, err := attachSysEnterBPF(coll, "tracepoint__sys_enter_bpf")
 	if err != nil {
-		return nil, fmt.Errorf("failed to load tracepoint__sys_enter_bpf: %v", err)
+		return nil, err
 	}
-	defer prog.Close()
-
-	perfEvent, err := link.Tracepoint("syscalls", "sys_enter_bpf", prog, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to configure tracepoint: %v", err)
-	}
-	defer perfEvent.Close()
+	defer detach()
 
 	codeDump := make([]byte, support.CodedumpBytes)
 
@@ -92,12 +170,118 @@ func loadKernelCode(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 	return codeDump, nil
 }
 
+// readTPBase reads the thread pointer base of the calling thread from its task_struct
+// through offset, and returns it together with the thread pointer the thread actually uses,
+// which is 0 if it is not known.
+func readTPBase(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
+	offset uint32) (tpbase, threadPointer uint64, err error) {
+	// The eBPF program reads the task_struct of the thread that issues the bpf syscalls,
+	// so all of them have to be made from this thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	detach, err := attachSysEnterBPF(coll, "tracepoint__sys_enter_bpf_tpbase")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer detach()
+
+	tpbaseCheck := maps["tpbase_check"]
+	key0 := uint32(0)
+	check := C.TPBaseCheck{offset: C.u64(offset), tid: C.u32(unix.Gettid())}
+	if err = tpbaseCheck.Update(unsafe.Pointer(&key0), unsafe.Pointer(&check),
+		cebpf.UpdateAny); err != nil {
+		return 0, 0, fmt.Errorf("failed to write tpbase_check: %v", err)
+	}
+	// The lookup triggers the eBPF program before the value is read.
+	if err = tpbaseCheck.Lookup(unsafe.Pointer(&key0), unsafe.Pointer(&check)); err != nil {
+		return 0, 0, fmt.Errorf("failed to get tpbase_check: %v", err)
+	}
+	return uint64(check.tpbase), currentThreadPointer(), nil
+}
+
+// isRealtimeKernel returns true if the running kernel is built with PREEMPT_RT.
+func isRealtimeKernel() bool {
+	var version string
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		version = unix.ByteSliceToString(uname.Version[:])
+	}
+	realtime, _ := os.ReadFile(realtimeKernelFile)
+	return realtimeKernel(version, string(realtime))
+}
+
+// realtimeKernel returns true if the kernel version string from uname, e.g.
+// "#1 SMP PREEMPT_RT Fri Feb 2 18:26:41 UTC 2024", or the content of realtimeKernelFile
+// show a PREEMPT_RT kernel. Older realtime kernels show "PREEMPT RT" in the version.
+func realtimeKernel(version, realtime string) bool {
+	if strings.TrimSpace(realtime) == "1" {
+		return true
+	}
+	version = " " + version + " "
+	return strings.Contains(version, " PREEMPT_RT ") || strings.Contains(version, " PREEMPT RT ")
+}
+
+// tpbaseCandidate is a tpbase offset found by the analyzer of a kernel function.
+type tpbaseCandidate struct {
+	offset       uint32
+	functionName string
+}
+
+// tpbaseReader reads the thread pointer base of the calling thread through offset, and
+// returns it with the thread pointer the thread actually uses, or 0 if it is not known.
+type tpbaseReader func(offset uint32) (tpbase, threadPointer uint64, err error)
+
+// selectTPBaseOffset returns the first of the candidates that is within bounds and whose
+// thread pointer base read with read matches the actual thread pointer. If the thread
+// pointer is not known, offsets are accepted by their bounds, except on realtime kernels,
+// where analyzers were seen returning offsets that are within bounds but wrong.
+func selectTPBaseOffset(candidates []tpbaseCandidate, bounds TPBaseOffsetBounds,
+	source string, realtime bool, read tpbaseReader) (uint32, error) {
+	var errs []error
+	for _, candidate := range candidates {
+		offset := candidate.offset
+		if offset < bounds.Min || offset > bounds.Max {
+			errs = append(errs, fmt.Errorf("tpbase offset %v via %s doesn't look sane "+
+				"(%s bounds %v)", offset, candidate.functionName, source, bounds))
+			continue
+		}
+
+		tpbase, threadPointer, err := read(offset)
+		if err != nil {
+			return 0, fmt.Errorf("failed to validate tpbase offset %v: %v", offset, err)
+		}
+		switch {
+		case threadPointer == 0 && realtime:
+			errs = append(errs, fmt.Errorf("tpbase offset %v via %s can not be validated "+
+				"on a realtime kernel", offset, candidate.functionName))
+			continue
+		case threadPointer == 0:
+			log.Warnf("Unable to validate tpbase offset %v, the thread pointer is not known",
+				offset)
+		case tpbase != threadPointer:
+			errs = append(errs, fmt.Errorf("tpbase offset %v via %s reads 0x%x instead of "+
+				"the thread pointer 0x%x", offset, candidate.functionName, tpbase,
+				threadPointer))
+			continue
+		default:
+			log.Infof("Validated tpbase offset %v by reading the thread pointer 0x%x",
+				offset, threadPointer)
+		}
+		log.Infof("Accepted tpbase offset %v within %s bounds %v", offset, source, bounds)
+		return offset, nil
+	}
+	return 0, errors.Join(errs...)
+}
+
 // loadTPBaseOffset extracts the offset of the thread pointer base variable in the `task_struct`
 // kernel struct. This offset varies depending on kernel configuration, so we have to learn
-// it dynamically at runtime.
+// it dynamically at runtime. The offsets found by all analyzers are tried in order, and the
+// first one that is confirmed by reading the thread pointer of the agent through it is used.
 func loadTPBaseOffset(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 	kernelSymbols *libpf.SymbolMap) (uint64, error) {
-	var tpbaseOffset uint32
+	var candidates []tpbaseCandidate
+	var analyzeErr error
 	for _, analyzer := range tpbase.GetAnalyzers() {
 		sym, err := kernelSymbols.LookupSymbol(libpf.SymbolName(analyzer.FunctionName))
 		if err != nil {
@@ -109,23 +293,42 @@ func loadTPBaseOffset(coll *cebpf.CollectionSpec, maps map[string]*cebpf.Map,
 			return 0, err
 		}
 
-		tpbaseOffset, err = analyzer.Analyze(code)
+		offset, err := analyzer.Analyze(code)
 		if err != nil {
-			return 0, fmt.Errorf("%w: %s", err, hex.Dump(code))
+			// The next analyzer may still find the offset.
+			analyzeErr = fmt.Errorf("%w: %s", err, hex.Dump(code))
+			continue
 		}
-		log.Infof("Found tpbase offset: %v (via %s)", tpbaseOffset, analyzer.FunctionName)
-		break
+		log.Infof("Found tpbase offset: %v (via %s)", offset, analyzer.FunctionName)
+		candidates = append(candidates, tpbaseCandidate{
+			offset:       offset,
+			functionName: analyzer.FunctionName,
+		})
 	}
 
-	if tpbaseOffset == 0 {
+	if len(candidates) == 0 {
+		if analyzeErr != nil {
+			return 0, analyzeErr
+		}
 		return 0, errors.New("no supported symbol found")
 	}
 
-	// Sanity-check against reasonable values. We expect something in the ~2000-10000 range,
-	// but allow for some additional slack on top of that.
-	if tpbaseOffset < 500 || tpbaseOffset > 20000 {
-		return 0, fmt.Errorf("tpbase offset %v doesn't look sane", tpbaseOffset)
+	realtime := isRealtimeKernel()
+	if realtime {
+		log.Infof("Running on a PREEMPT_RT kernel, tpbase offsets have to be validated")
 	}
 
+	// Sanity-check against reasonable values.
+	bounds, source := defaultTPBaseOffsetBounds(runtime.GOARCH), runtime.GOARCH+" default"
+	if minOffset, maxOffset := config.TPBaseOffsetBounds(); maxOffset != 0 {
+		bounds, source = TPBaseOffsetBounds{Min: minOffset, Max: maxOffset}, "configured"
+	}
+	tpbaseOffset, err := selectTPBaseOffset(candidates, bounds, source, realtime,
+		func(offset uint32) (uint64, uint64, error) {
+			return readTPBase(coll, maps, offset)
+		})
+	if err != nil {
+		return 0, err
+	}
 	return uint64(tpbaseOffset), nil
 }
@@ -0,0 +1,107 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTPBaseOffsetBounds(t *testing.T) {
+	bounds, err := ParseTPBaseOffsetBounds("500-20000")
+	require.NoError(t, err)
+	assert.Equal(t, TPBaseOffsetBounds{Min: 500, Max: 20000}, bounds)
+	assert.Equal(t, "500-20000", bounds.String())
+
+	for _, s := range []string{"", "500", "0-20000", "20000-500", "-20000", "500-", "a-b",
+		"500-5000000000"} {
+		_, err := ParseTPBaseOffsetBounds(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDefaultTPBaseOffsetBounds(t *testing.T) {
+	assert.Equal(t, TPBaseOffsetBounds{Min: 500, Max: 20000}, defaultTPBaseOffsetBounds("amd64"))
+	assert.Equal(t, TPBaseOffsetBounds{Min: 500, Max: 40000}, defaultTPBaseOffsetBounds("arm64"))
+}
+
+func TestRealtimeKernel(t *testing.T) {
+	assert.True(t, realtimeKernel("#1 SMP PREEMPT_RT Fri Feb  2 18:26:41 UTC 2024", ""))
+	assert.True(t, realtimeKernel("#1 SMP PREEMPT RT Tue Mar 3 10:00:00 UTC 2020", ""))
+	assert.True(t, realtimeKernel("#1 SMP PREEMPT_DYNAMIC Fri Feb  2 18:26:41 UTC 2024", "1\n"))
+	assert.False(t, realtimeKernel("#1 SMP PREEMPT_DYNAMIC Fri Feb  2 18:26:41 UTC 2024", ""))
+	assert.False(t, realtimeKernel("#1 SMP PREEMPT_RTX", "0\n"))
+}
+
+func TestSelectTPBaseOffset(t *testing.T) {
+	const threadPointer = 0x7f0000001000
+	bounds := TPBaseOffsetBounds{Min: 500, Max: 20000}
+	// On PREEMPT_RT kernels the first analyzer finds an offset within the bounds, which
+	// reads a different field of the task_struct.
+	candidates := []tpbaseCandidate{
+		{offset: 100, functionName: "too_small"},
+		{offset: 5896, functionName: "aout_dump_debugregs"},
+		{offset: 6016, functionName: "x86_fsbase_write_task"},
+	}
+	exact := func(offset uint32) (uint64, uint64, error) {
+		if offset == 6016 {
+			return threadPointer, threadPointer, nil
+		}
+		return 0xffff888100000000, threadPointer, nil
+	}
+	unknown := func(uint32) (uint64, uint64, error) {
+		return threadPointer, 0, nil
+	}
+
+	tests := map[string]struct {
+		read     tpbaseReader
+		realtime bool
+		expected uint32
+		fail     bool
+	}{
+		"validated": {
+			read:     exact,
+			expected: 6016,
+		},
+		"validated on realtime kernel": {
+			read:     exact,
+			realtime: true,
+			expected: 6016,
+		},
+		"unknown thread pointer": {
+			read:     unknown,
+			expected: 5896,
+		},
+		"unknown thread pointer on realtime kernel": {
+			read:     unknown,
+			realtime: true,
+			fail:     true,
+		},
+		"mismatch": {
+			read: func(uint32) (uint64, uint64, error) {
+				return 0, threadPointer, nil
+			},
+			fail: true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			offset, err := selectTPBaseOffset(candidates, bounds, "test", test.realtime,
+				test.read)
+			if test.fail {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, offset)
+		})
+	}
+}
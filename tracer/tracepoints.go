@@ -25,7 +25,8 @@ func (t *Tracer) attachToTracepoint(group, name string, prog *ebpf.Program) erro
 	}
 	hook, err := link.Tracepoint(hp.group, hp.name, prog, nil)
 	if err != nil {
-		return fmt.Errorf("failed to configure tracepoint on %#v: %v", hp, err)
+		return fmt.Errorf("failed to configure tracepoint on %#v: %v", hp,
+			capabilityError(opPerfEvents, err))
 	}
 	t.hooks[hp] = hook
 	return nil
@@ -8,17 +8,16 @@
 package tracer
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"math/rand"
-	"strings"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	cebpf "github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
 	"github.com/cilium/ebpf/link"
 	lru "github.com/elastic/go-freelru"
 	"github.com/elastic/go-perf"
@@ -32,6 +31,7 @@ import (
 	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind"
 	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/localintervalcache"
 	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/localstackdeltaprovider"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/precomputed"
 	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
 	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
 	"github.com/elastic/otel-profiling-agent/libpf/rlimit"
@@ -86,12 +86,54 @@ type Tracer struct {
 	// kernelSymbols is used to hold the kernel symbol addresses we are tracking
 	kernelSymbols *libpf.SymbolMap
 
+	// kernelSymbolCache caches the symbols of hot kernel addresses
+	kernelSymbolCache *symbolCache
+
 	// kernelModules holds symbols/addresses for the kernel module address space
 	kernelModules *libpf.SymbolMap
 
 	// perfEntrypoints holds a list of frequency based perf events that are opened on the system.
 	perfEntrypoints xsync.RWMutex[[]*perf.Event]
 
+	// secondaryEvents holds the perf events of perfEntrypoints that belong to the optional
+	// secondary event set. Their sampling frequency is not changed by SetSampleFrequency.
+	// It is guarded by the lock of perfEntrypoints.
+	secondaryEvents libpf.Set[*perf.Event]
+
+	// disabledEventSets holds the event sets whose sampling was disabled with
+	// SetEventSetEnabled. It is guarded by the lock of perfEntrypoints.
+	disabledEventSets libpf.Set[libpf.EventSet]
+
+	// alignedSampling is set if the perf events use a fixed period and are enabled together,
+	// so that the samples of all CPUs are taken at about the same time.
+	alignedSampling bool
+
+	// primaryPerfEvent is the perf event sampling the primary event set, which may differ
+	// from the requested one if the hardware PMU is not usable. It is nil until
+	// AttachTracer succeeded.
+	primaryPerfEvent atomic.Pointer[PerfEvent]
+
+	// primaryAttachment and secondaryAttachment hold the parameters the perf events of the
+	// event sets were opened with, so that they can be re-created by restartSampling. They
+	// are guarded by the lock of perfEntrypoints.
+	primaryAttachment, secondaryAttachment *perfAttachment
+
+	// samplingEnabled is set while the perf events are enabled by EnableProfiling or by
+	// probabilistic profiling. It is guarded by the lock of perfEntrypoints.
+	samplingEnabled bool
+
+	// sampleFreq is the configured sampling frequency of the primary event set, and
+	// sampleFreqDivisors hold the factors by which the self-throttle and the idle backoff
+	// lower it. They are guarded by the lock of perfEntrypoints.
+	sampleFreq         int
+	sampleFreqDivisors [numSampleFreqReducers]int
+
+	// pidFilterActive is set while profiling is restricted to the PIDs of SetPIDFilter.
+	pidFilterActive atomic.Bool
+
+	// tracesReceived counts the traces read from the trace perf event buffer.
+	tracesReceived atomic.Uint64
+
 	// hooks holds references to loaded eBPF hooks.
 	hooks map[hookPoint]link.Link
 
@@ -136,6 +178,10 @@ type hookPoint struct {
 func processKernelModulesMetadata(ctx context.Context,
 	rep reporter.SymbolReporter, kernelModules *libpf.SymbolMap) (map[string]libpf.FileID, error) {
 	result := make(map[string]libpf.FileID, kernelModules.Len())
+	release, err := GetCurrentKernelRelease()
+	if err != nil {
+		log.Warnf("Failed to get kernel release: %v", err)
+	}
 	kernelModules.ScanAllNames(func(name libpf.SymbolName) {
 		nameStr := string(name)
 		if !libpf.IsValidString(nameStr) {
@@ -159,12 +205,20 @@ func processKernelModulesMetadata(ctx context.Context,
 		// kernel.
 		if err == nil && len(buildID) >= 16 {
 			fileID = pfelf.CalculateKernelFileID(buildID)
-			result[nameStr] = fileID
-			rep.ExecutableMetadata(ctx, fileID, nameStr, buildID)
+		} else if release != "" && nameStr != "vmlinux" {
+			// Modules built without build ID are still told apart by name, so that
+			// their frames are attributed to them and symbolized with kallsyms.
+			log.Debugf("Failed to get GNU BuildID for kernel module %s: '%s' (%v)",
+				nameStr, buildID, err)
+			fileID = moduleFileIDFromName(nameStr, release)
+			buildID = ""
 		} else {
 			log.Errorf("Failed to get GNU BuildID for kernel module %s: '%s' (%v)",
 				nameStr, buildID, err)
+			return
 		}
+		result[nameStr] = fileID
+		rep.ExecutableMetadata(ctx, fileID, nameStr, buildID, 0, 0, pfelf.AddressMapper{})
 	})
 
 	return result, nil
@@ -202,7 +256,7 @@ func collectIntervalCacheMetrics(ctx context.Context, cache nativeunwind.Interva
 // path.
 func NewTracer(ctx context.Context, rep reporter.SymbolReporter, intervals Intervals,
 	includeTracers []bool, filterErrorFrames bool) (*Tracer, error) {
-	kernelSymbols, err := proc.GetKallsyms("/proc/kallsyms")
+	kernelSymbols, err := readKernelSymbols()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read kernel symbols: %v", err)
 	}
@@ -225,8 +279,16 @@ func NewTracer(ctx context.Context, rep reporter.SymbolReporter, intervals Inter
 	collectIntervalCacheMetrics(ctx, intervalStructureCache, intervals.MonitorInterval())
 
 	// Create a stack delta provider which is used by the process manager to extract
-	// stack deltas from the executables.
-	localStackDeltaProvider := localstackdeltaprovider.New(intervalStructureCache)
+	// stack deltas from the executables. Precomputed stack deltas take precedence if
+	// configured.
+	var stackDeltaProvider nativeunwind.StackDeltaProvider = localstackdeltaprovider.New(
+		intervalStructureCache)
+	if dir := config.StackDeltasDir(); dir != "" {
+		if stackDeltaProvider, err = precomputed.NewProvider(dir,
+			stackDeltaProvider); err != nil {
+			return nil, err
+		}
+	}
 
 	ebpfHandler, err := pmebpf.LoadMaps(ebpfMaps)
 	if err != nil {
@@ -236,7 +298,7 @@ func NewTracer(ctx context.Context, rep reporter.SymbolReporter, intervals Inter
 	hasBatchOperations := ebpfHandler.SupportsGenericBatchOperations()
 
 	processManager, err := pm.New(ctx, includeTracers, intervals.MonitorInterval(), ebpfHandler,
-		nil, rep, localStackDeltaProvider, filterErrorFrames)
+		nil, rep, stackDeltaProvider, filterErrorFrames)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create processManager: %v", err)
 	}
@@ -259,12 +321,18 @@ func NewTracer(ctx context.Context, rep reporter.SymbolReporter, intervals Inter
 		return nil, fmt.Errorf("failed to extract kernel modules metadata: %v", err)
 	}
 
+	kernelSymbolCache, err := newSymbolCache(config.KernelSymbolCacheSize())
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate kernel symbol cache: %v", err)
+	}
+
 	perfEventList := []*perf.Event{}
 
 	return &Tracer{
 		processManager:             processManager,
 		kernelSymbols:              kernelSymbols,
 		kernelModules:              kernelModules,
+		kernelSymbolCache:          kernelSymbolCache,
 		transmittedFallbackSymbols: transmittedFallbackSymbols,
 		triggerPIDProcessing:       make(chan bool, 1),
 		pidEvents:                  make(chan libpf.PID, pidEventBufferSize),
@@ -292,6 +360,7 @@ func (t *Tracer) Close() {
 		}
 	}
 	*events = nil
+	t.secondaryEvents = nil
 	t.perfEntrypoints.WUnlock(&events)
 
 	// Avoid resource leakage by closing all kernel hooks.
@@ -377,8 +446,13 @@ func initializeMapsAndPrograms(includeTracers []bool, kernelSymbols *libpf.Symbo
 		}
 	}
 
+	kernelTypes, err := loadKernelTypes(config.BTFFile())
+	if err != nil {
+		return nil, nil, err
+	}
+
 	if err = loadUnwinders(coll, ebpfProgs, ebpfMaps["progs"],
-		includeTracers); err != nil {
+		includeTracers, kernelTypes); err != nil {
 		return nil, nil, fmt.Errorf("failed to load eBPF programs: %v", err)
 	}
 
@@ -386,6 +460,10 @@ func initializeMapsAndPrograms(includeTracers []bool, kernelSymbols *libpf.Symbo
 		return nil, nil, fmt.Errorf("failed to load system config: %v", err)
 	}
 
+	if err = loadSampleWeights(ebpfMaps); err != nil {
+		return nil, nil, err
+	}
+
 	if err = removeTemporaryMaps(ebpfMaps); err != nil {
 		return nil, nil, fmt.Errorf("failed to remove temporary maps: %v", err)
 	}
@@ -407,6 +485,11 @@ func removeTemporaryMaps(ebpfMaps map[string]*cebpf.Map) error {
 		log.Errorf("Failed to close codedump_code: %v", err)
 	}
 	delete(ebpfMaps, "codedump_code")
+	tpbaseCheck := ebpfMaps["tpbase_check"]
+	if err := tpbaseCheck.Close(); err != nil {
+		log.Errorf("Failed to close tpbase_check: %v", err)
+	}
+	delete(ebpfMaps, "tpbase_check")
 	return nil
 }
 
@@ -448,7 +531,8 @@ func loadAllMaps(coll *cebpf.CollectionSpec, ebpfMaps map[string]*cebpf.Map) err
 		}
 		ebpfMap, err := cebpf.NewMap(mapSpec)
 		if err != nil {
-			return fmt.Errorf("failed to load %s: %v", mapName, err)
+			return fmt.Errorf("failed to load %s: %v", mapName,
+				capabilityError(opLoadEBPF, err))
 		}
 		ebpfMaps[mapName] = ebpfMap
 	}
@@ -466,9 +550,11 @@ func isProgramEnabled(includeTracers []bool, enable []config.TracerType) bool {
 	return false
 }
 
-// loadUnwinders just satisfies the proof of concept and loads all eBPF programs
+// loadUnwinders just satisfies the proof of concept and loads all eBPF programs.
+// Interpreter unwinders that exceed the limits of the verifier are skipped and their
+// tracers are disabled in includeTracers.
 func loadUnwinders(coll *cebpf.CollectionSpec, ebpfProgs map[string]*cebpf.Program,
-	tailcallMap *cebpf.Map, includeTracers []bool) error {
+	tailcallMap *cebpf.Map, includeTracers []bool, kernelTypes *btf.Spec) error {
 	restoreRlimit, err := rlimit.MaximizeMemlock()
 	if err != nil {
 		return fmt.Errorf("failed to adjust rlimit: %v", err)
@@ -487,11 +573,9 @@ func loadUnwinders(coll *cebpf.CollectionSpec, ebpfProgs map[string]*cebpf.Progr
 		noTailCallTarget bool
 	}
 
-	logLevel, logSize := config.BpfVerifierLogSetting()
-	programOptions := cebpf.ProgramOptions{
-		LogLevel: cebpf.LogLevel(logLevel),
-		LogSize:  logSize,
-	}
+	programOptions := verifierLogOptions()
+	// If nil, the kernel types are only looked up if a program needs them.
+	programOptions.KernelTypes = kernelTypes
 
 	for _, unwindProg := range []prog{
 		{
@@ -532,6 +616,11 @@ func loadUnwinders(coll *cebpf.CollectionSpec, ebpfProgs map[string]*cebpf.Progr
 			name:   "unwind_v8",
 			enable: []config.TracerType{config.V8Tracer},
 		},
+		{
+			progID: uint32(support.ProgUnwindBEAM),
+			name:   "unwind_beam",
+			enable: []config.TracerType{config.BEAMTracer},
+		},
 		{
 			name:             "tracepoint__sched_process_exit",
 			noTailCallTarget: true,
@@ -540,6 +629,10 @@ func loadUnwinders(coll *cebpf.CollectionSpec, ebpfProgs map[string]*cebpf.Progr
 			name:             "native_tracer_entry",
 			noTailCallTarget: true,
 		},
+		{
+			name:             "native_tracer_entry_secondary",
+			noTailCallTarget: true,
+		},
 	} {
 		if len(unwindProg.enable) > 0 && !isProgramEnabled(includeTracers, unwindProg.enable) {
 			continue
@@ -550,12 +643,25 @@ func loadUnwinders(coll *cebpf.CollectionSpec, ebpfProgs map[string]*cebpf.Progr
 		unwinder, err := cebpf.NewProgramWithOptions(coll.Programs[unwindProg.name],
 			programOptions)
 		if err != nil {
-			// These errors tend to have hundreds of lines, so we print each line individually.
-			scanner := bufio.NewScanner(strings.NewReader(err.Error()))
-			for scanner.Scan() {
-				log.Error(scanner.Text())
+			logVerifierLog(unwindProg.name, err)
+			if !exceedsProgramLimits(err) {
+				return fmt.Errorf("failed to load %s: %v", unwindProg.name,
+					capabilityError(opLoadEBPF, err))
+			}
+			if len(unwindProg.enable) == 0 {
+				return fmt.Errorf("failed to load %s: the program exceeds the size or "+
+					"complexity limits of the eBPF verifier of this kernel, upgrading to "+
+					"a newer kernel is recommended: %v", unwindProg.name, err)
+			}
+			// Interpreter unwinders are optional. Without them, the frames of the
+			// interpreters are unwound as native frames.
+			log.Warnf("Disabling %s, as it exceeds the size or complexity limits of the "+
+				"eBPF verifier of this kernel, upgrading to a newer kernel is recommended: %v",
+				unwindProg.name, err)
+			for _, tracer := range unwindProg.enable {
+				includeTracers[tracer] = false
 			}
-			return fmt.Errorf("failed to load %s", unwindProg.name)
+			continue
 		}
 
 		ebpfProgs[unwindProg.name] = unwinder
@@ -634,19 +740,32 @@ func (t *Tracer) insertKernelFrames(trace *host.Trace, ustackLen uint32,
 		//  - main image should have .text section at start of the code segment
 		//  - modules are ELF object files (.o) without program headers and
 		//    LOAD segments. the address is relative to the .text section
-		mod, addr, _ := t.kernelModules.LookupByAddress(
-			libpf.SymbolValue(kstackVal[i]))
-		symbol, offs, foundSymbol := t.kernelSymbols.LookupByAddress(
-			libpf.SymbolValue(kstackVal[i]))
+		pc := libpf.SymbolValue(kstackVal[i])
+		mod, addr, _ := t.kernelModules.LookupByAddress(pc)
+		resolve := func() (libpf.SymbolName, libpf.Address, bool) {
+			return lookupKernelSymbol(t.kernelSymbols, pc, pc-libpf.SymbolValue(addr))
+		}
 
+		var symbol libpf.SymbolName
+		var offs libpf.Address
+		var foundSymbol bool
 		fileID, foundFileID := t.moduleFileIDs[string(mod)]
-
-		if !foundFileID {
+		if foundFileID {
+			symbol, offs, foundSymbol = t.kernelSymbolCache.lookup(fileID,
+				libpf.AddressOrLineno(addr), resolve)
+		} else {
+			// Addresses outside of known modules can not be told apart by their offset.
 			fileID = libpf.UnknownKernelFileID
+			symbol, offs, foundSymbol = resolve()
 		}
 
 		log.Debugf(" kstack[%d] = %v+%x (%v+%x)", i, string(mod), addr, symbol, offs)
 
+		// The innermost system call entry function names the system call.
+		if foundSymbol && trace.Syscall == "" && config.LabelSyscall() {
+			trace.Syscall, _ = syscallName(symbol)
+		}
+
 		hostFileID := host.CalculateKernelFileID(fileID)
 		t.processManager.FileIDMapper.Set(hostFileID, fileID)
 
@@ -763,34 +882,59 @@ func (t *Tracer) monitorPIDEventsMap(keys *[]uint32) {
 	}
 }
 
+// readEBPFMetric returns the value of the eBPF metric with the given ID, summed up over
+// all CPUs.
+func (t *Tracer) readEBPFMetric(ebpfID uint32) (metrics.MetricValue, error) {
+	var perCPUValues []uint64
+	if err := t.ebpfMaps["metrics"].Lookup(unsafe.Pointer(&ebpfID), &perCPUValues); err != nil {
+		return 0, err
+	}
+	value := metrics.MetricValue(0)
+	for _, val := range perCPUValues {
+		value += metrics.MetricValue(val)
+	}
+	return value, nil
+}
+
+// logUnwindVerifyMismatches logs a warning if the stack deltas of native frames disagreed
+// with their frame pointers, according to the metric updates ebpfMetrics of an interval.
+func logUnwindVerifyMismatches(ebpfMetrics []metrics.Metric) {
+	var verified, mismatches metrics.MetricValue
+	for _, m := range ebpfMetrics {
+		switch m.ID {
+		case metrics.IDUnwindNativeVerifiedFrames:
+			verified = m.Value
+		case metrics.IDUnwindNativeVerifyMismatches:
+			mismatches = m.Value
+		}
+	}
+	if mismatches != 0 {
+		log.Warnf("Stack deltas of %d of %d checked native frames disagreed with the "+
+			"frame pointers", mismatches, verified)
+	}
+}
+
 // eBPFMetricsCollector retrieves the eBPF metrics, calculates their delta values,
 // and translates eBPF IDs into Metric ID.
 // Returns a slice of Metric ID/Value pairs.
 func (t *Tracer) eBPFMetricsCollector(
 	translateIDs []metrics.MetricID,
 	previousMetricValue []metrics.MetricValue) []metrics.Metric {
-	metricsMap := t.ebpfMaps["metrics"]
 	metricsUpdates := make([]metrics.Metric, 0, len(translateIDs))
 
 	// Iterate over all known metric IDs
 	for ebpfID, metricID := range translateIDs {
-		var perCPUValues []uint64
-
 		// Checking for 'gaps' in the translation table.
 		// That allows non-contiguous metric IDs, e.g. after removal/deprecation of a metric ID.
 		if metricID == metrics.IDInvalid {
 			continue
 		}
 
-		eID := uint32(ebpfID)
-		if err := metricsMap.Lookup(unsafe.Pointer(&eID), &perCPUValues); err != nil {
+		value, err := t.readEBPFMetric(uint32(ebpfID))
+		if err != nil {
 			log.Errorf("Failed trying to lookup per CPU element: %v", err)
 			continue
 		}
-		value := metrics.MetricValue(0)
-		for _, val := range perCPUValues {
-			value += metrics.MetricValue(val)
-		}
 
 		// The monitoring infrastructure expects instantaneous values (gauges).
 		// => for cumulative metrics (counters), send deltas of the observed values, so they
@@ -822,7 +966,7 @@ func (t *Tracer) eBPFMetricsCollector(
 //
 // If the raw trace contains a kernel stack ID, the kernel stack is also
 // retrieved and inserted at the appropriate position.
-func (t *Tracer) loadBpfTrace(raw []byte) *host.Trace {
+func (t *Tracer) loadBpfTrace(raw []byte, cpu int) *host.Trace {
 	frameListOffs := int(unsafe.Offsetof(C.Trace{}.frames))
 
 	if len(raw) < frameListOffs {
@@ -838,17 +982,24 @@ func (t *Tracer) loadBpfTrace(raw []byte) *host.Trace {
 	}
 
 	trace := &host.Trace{
-		Comm:  C.GoString((*C.char)(unsafe.Pointer(&ptr.comm))),
-		PID:   libpf.PID(ptr.pid),
-		KTime: libpf.KTime(ptr.ktime),
+		Comm:     C.GoString((*C.char)(unsafe.Pointer(&ptr.comm))),
+		PID:      libpf.PID(ptr.pid),
+		TID:      libpf.PID(ptr.tid),
+		KTime:    libpf.KTime(ptr.ktime),
+		CPU:      cpu,
+		EventSet: libpf.EventSet(ptr.event_set),
+		Period:   uint64(ptr.period),
 	}
 
 	// Trace fields included in the hash:
 	//  - PID, kernel stack ID, length & frame array.
 	// Intentionally excluded:
-	//  - ktime, COMM
+	//  - ktime, COMM, CPU, TID, event set, period
 	ptr.comm = [16]C.char{}
 	ptr.ktime = 0
+	ptr.tid = 0
+	ptr.event_set = 0
+	ptr.period = 0
 	trace.Hash = host.TraceHash(xxh3.Hash128(raw).Lo)
 
 	userFrameOffs := 0
@@ -881,15 +1032,36 @@ func (t *Tracer) loadBpfTrace(raw []byte) *host.Trace {
 	return trace
 }
 
+// reportLostTraces forwards the number of traces of a CPU that the kernel could not write
+// to the trace perf event buffer to the reporter, if it accounts for lost samples.
+func (t *Tracer) reportLostTraces(cpu int, lost uint64) {
+	log.Debugf("Lost %d traces of CPU %d", lost, cpu)
+	if lostReporter, ok := t.reporter.(reporter.LostSamplesReporter); ok {
+		lostReporter.ReportLostSamples(cpu, lost)
+	}
+}
+
 // StartMapMonitors starts goroutines for collecting metrics and monitoring eBPF
 // maps for tracepoints, new traces, trace count updates and unknown PCs.
+// Once ctx is done, sampling is disabled and traceOutChan is closed after the
+// traces remaining in the trace perf event buffer have been sent.
 func (t *Tracer) StartMapMonitors(ctx context.Context, traceOutChan chan *host.Trace) error {
 	eventMetricCollector := t.startEventMonitor(ctx)
 
-	startPollingPerfEventMonitor(ctx, t.ebpfMaps["trace_events"], t.intervals.TracePollInterval(),
-		int(config.SamplesPerSecond())*int(unsafe.Sizeof(C.Trace{})), func(rawTrace []byte) {
-			traceOutChan <- t.loadBpfTrace(rawTrace)
+	_, traceMonitorDone := startPollingPerfEventMonitor(ctx, t.ebpfMaps["trace_events"],
+		t.intervals.TracePollInterval(),
+		int(config.SamplesPerSecond())*int(unsafe.Sizeof(C.Trace{})), func(cpu int, rawTrace []byte) {
+			t.tracesReceived.Add(1)
+			traceOutChan <- t.loadBpfTrace(rawTrace, cpu)
+		}, t.reportLostTraces, func() {
+			if err := t.DisableProfiling(); err != nil {
+				log.Errorf("Failed to stop sampling: %v", err)
+			}
 		})
+	go func() {
+		<-traceMonitorDone
+		close(traceOutChan)
+	}()
 
 	pidEvents := make([]uint32, 0)
 	periodiccaller.StartWithManualTrigger(ctx, t.intervals.MonitorInterval(),
@@ -990,6 +1162,22 @@ func (t *Tracer) StartMapMonitors(ctx context.Context, traceOutChan chan *host.T
 		C.metricID_UnwindNativeErrChaseIrqStackLink:           metrics.IDUnwindNativeErrChaseIrqStackLink,
 		C.metricID_UnwindV8ErrNoProcInfo:                      metrics.IDUnwindV8ErrNoProcInfo,
 		C.metricID_UnwindNativeErrBadUnwindInfoIndex:          metrics.IDUnwindNativeErrBadUnwindInfoIndex,
+		C.metricID_UnwindBEAMAttempts:                         metrics.IDUnwindBEAMAttempts,
+		C.metricID_UnwindBEAMFrames:                           metrics.IDUnwindBEAMFrames,
+		C.metricID_UnwindBEAMErrNoProcInfo:                    metrics.IDUnwindBEAMErrNoProcInfo,
+		C.metricID_UnwindBEAMErrReadTsdBase:                   metrics.IDUnwindBEAMErrReadTsdBase,
+		C.metricID_UnwindBEAMErrBadEsdKeyAddr:                 metrics.IDUnwindBEAMErrBadEsdKeyAddr,
+		C.metricID_UnwindBEAMErrReadSchedulerData:             metrics.IDUnwindBEAMErrReadSchedulerData,
+		C.metricID_UnwindBEAMErrReadProcess:                   metrics.IDUnwindBEAMErrReadProcess,
+		C.metricID_UnwindRubyErrReadTsdBase:                   metrics.IDUnwindRubyErrReadTsdBase,
+		C.metricID_UnwindRubyErrReadTLS:                       metrics.IDUnwindRubyErrReadTLS,
+		C.metricID_PerfEventsIdle:                             metrics.IDPerfEventsIdle,
+		C.metricID_PerfEventsActive:                           metrics.IDPerfEventsActive,
+		C.metricID_UnwindNativeErrRbxUnwindingMidTrace:        metrics.IDUnwindNativeErrRbxUnwindingMidTrace,
+		C.metricID_UnwindPythonThreadStateFromList:            metrics.IDUnwindPythonThreadStateFromList,
+		C.metricID_UnwindPythonErrBadThreadStateListAddr:      metrics.IDUnwindPythonErrBadThreadStateListAddr,
+		C.metricID_UnwindNativeVerifiedFrames:                 metrics.IDUnwindNativeVerifiedFrames,
+		C.metricID_UnwindNativeVerifyMismatches:               metrics.IDUnwindNativeVerifyMismatches,
 	}
 
 	// previousMetricValue stores the previously retrieved metric values to
@@ -998,9 +1186,24 @@ func (t *Tracer) StartMapMonitors(ctx context.Context, traceOutChan chan *host.T
 
 	periodiccaller.Start(ctx, t.intervals.MonitorInterval(), func() {
 		metrics.AddSlice(eventMetricCollector())
-		metrics.AddSlice(t.eBPFMetricsCollector(translateIDs, previousMetricValue))
+		ebpfMetrics := t.eBPFMetricsCollector(translateIDs, previousMetricValue)
+		metrics.AddSlice(ebpfMetrics)
+		logUnwindVerifyMismatches(ebpfMetrics)
+
+		if event := t.primaryPerfEvent.Load(); event != nil {
+			metrics.Add(metrics.IDPerfEventType, metrics.MetricValue(*event))
+		}
 
+		symbolCacheHits, symbolCacheMisses := t.kernelSymbolCache.stats()
 		metrics.AddSlice([]metrics.Metric{
+			{
+				ID:    metrics.IDKernelSymbolCacheHit,
+				Value: metrics.MetricValue(symbolCacheHits),
+			},
+			{
+				ID:    metrics.IDKernelSymbolCacheMiss,
+				Value: metrics.MetricValue(symbolCacheMisses),
+			},
 			{
 				ID:    metrics.IDKernelFallbackSymbolLRUHit,
 				Value: metrics.MetricValue(t.fallbackSymbolHit.Swap(0)),
@@ -1015,19 +1218,81 @@ func (t *Tracer) StartMapMonitors(ctx context.Context, traceOutChan chan *host.T
 	return nil
 }
 
-// AttachTracer attaches the main tracer entry point to the perf interrupt events. The tracer
-// entry point is always the native tracer. The native tracer will determine when to invoke the
-// interpreter tracers based on address range information.
-func (t *Tracer) AttachTracer(sampleFreq int) error {
+// AttachTracer attaches the main tracer entry point to the perf interrupt events of the given
+// type. The tracer entry point is always the native tracer. The native tracer will determine
+// when to invoke the interpreter tracers based on address range information. Hardware events
+// are probed to count first, and if the PMU is not usable, as is common in virtual
+// machines, the software CPU clock is used instead.
+//
+// If aligned is set, the events of all CPUs are driven by the CPU clock with the same fixed
+// period and are started together by EnableProfiling. This results in samples that are
+// roughly synchronized across all CPUs, allowing a snapshot view of the system at each tick.
+// The drawback is that all CPUs are interrupted at the same time, which causes bursts of
+// load on the shared eBPF maps and on the processing of the traces in user space, whereas
+// the default frequency based events spread the samples of the CPUs over time.
+func (t *Tracer) AttachTracer(sampleFreq int, event PerfEvent, aligned bool) error {
 	tracerProg, ok := t.ebpfProgs["native_tracer_entry"]
 	if !ok {
 		return fmt.Errorf("entry program is not available")
 	}
 
-	perfAttribute := new(perf.Attr)
-	perfAttribute.SetSampleFreq(uint64(sampleFreq))
-	if err := perf.CPUClock.Configure(perfAttribute); err != nil {
-		return fmt.Errorf("failed to configure software perf event: %v", err)
+	onlineCPUIDs, err := hostcpu.ParseCPUCoreIDs(hostcpu.CPUOnlinePath)
+	if err != nil {
+		return fmt.Errorf("failed to get online CPUs: %v", err)
+	}
+
+	if aligned && event != PerfEventCPUClock {
+		return fmt.Errorf("aligned sampling requires the %s perf event", PerfEventCPUClock)
+	}
+
+	if event.IsHardware() {
+		// Hardware events are often unavailable or do not count in virtual machines.
+		if err = probeHardwareEvent(event); err != nil {
+			log.Warnf("Hardware perf event %s is not usable (%v), falling back to %s",
+				event, err, PerfEventCPUClock)
+			event = PerfEventCPUClock
+		} else {
+			log.Infof("Hardware PMU is available, sampling with perf event %s", event)
+		}
+	}
+
+	perfEvents, err := openPerfEvents(sampleFreq, event, aligned, onlineCPUIDs,
+		tracerProg.FD())
+	if err != nil && event.IsHardware() {
+		log.Warnf("Hardware perf event %s is not supported (%v), falling back to %s",
+			event, err, PerfEventCPUClock)
+		event = PerfEventCPUClock
+		perfEvents, err = openPerfEvents(sampleFreq, event, false, onlineCPUIDs,
+			tracerProg.FD())
+	}
+	if err != nil {
+		return err
+	}
+
+	events := t.perfEntrypoints.WLock()
+	defer t.perfEntrypoints.WUnlock(&events)
+	*events = append(*events, perfEvents...)
+	t.alignedSampling = aligned
+	t.sampleFreq = sampleFreq
+	t.primaryPerfEvent.Store(&event)
+	t.primaryAttachment = &perfAttachment{
+		progName:   "native_tracer_entry",
+		sampleFreq: sampleFreq,
+		event:      event,
+		aligned:    aligned,
+	}
+	return nil
+}
+
+// AttachSecondaryTracer attaches the tracer entry point of the secondary event set to the
+// frequency based perf interrupt events of the given type. The traces sampled by these
+// events are tagged with libpf.SecondaryEventSet, so that they are reported separately from
+// the traces of AttachTracer. Both sets are enabled and disabled together, but the sampling
+// frequency of the secondary set is not changed by SetSampleFrequency.
+func (t *Tracer) AttachSecondaryTracer(sampleFreq int, event PerfEvent) error {
+	tracerProg, ok := t.ebpfProgs["native_tracer_entry_secondary"]
+	if !ok {
+		return fmt.Errorf("secondary entry program is not available")
 	}
 
 	onlineCPUIDs, err := hostcpu.ParseCPUCoreIDs(hostcpu.CPUOnlinePath)
@@ -1035,19 +1300,85 @@ func (t *Tracer) AttachTracer(sampleFreq int) error {
 		return fmt.Errorf("failed to get online CPUs: %v", err)
 	}
 
+	// The secondary set is meant to capture different events than the primary set, so
+	// unusable hardware events do not fall back to the CPU clock.
+	if event.IsHardware() {
+		if err = probeHardwareEvent(event); err != nil {
+			return fmt.Errorf("hardware perf event %s is not usable: %v", event, err)
+		}
+	}
+	perfEvents, err := openPerfEvents(sampleFreq, event, false, onlineCPUIDs,
+		tracerProg.FD())
+	if err != nil {
+		return err
+	}
+
 	events := t.perfEntrypoints.WLock()
 	defer t.perfEntrypoints.WUnlock(&events)
-	for _, id := range onlineCPUIDs {
+	if t.secondaryEvents == nil {
+		t.secondaryEvents = make(libpf.Set[*perf.Event], len(perfEvents))
+	}
+	for _, perfEvent := range perfEvents {
+		t.secondaryEvents[perfEvent] = libpf.Void{}
+	}
+	*events = append(*events, perfEvents...)
+	t.secondaryAttachment = &perfAttachment{
+		progName:   "native_tracer_entry_secondary",
+		sampleFreq: sampleFreq,
+		event:      event,
+	}
+	return nil
+}
+
+// samplePeriod returns the period in nanoseconds of a clock based perf event that samples
+// with the given frequency.
+func samplePeriod(sampleFreq int) uint64 {
+	return uint64(time.Second) / uint64(sampleFreq)
+}
+
+// openPerfEvents opens a perf event of the given type on each of the CPUs and attaches the
+// eBPF program progFD to them. The events are frequency based, unless aligned is set. Then
+// they use a fixed period and are opened disabled, so that they can be enabled together.
+// Events that are sampled on each occurrence ignore sampleFreq. On error, all opened events
+// are closed.
+func openPerfEvents(sampleFreq int, event PerfEvent, aligned bool, cpus []int,
+	progFD int) ([]*perf.Event, error) {
+	perfAttribute := new(perf.Attr)
+	switch {
+	case event.samplesEachEvent():
+		perfAttribute.SetSamplePeriod(1)
+	case aligned:
+		perfAttribute.SetSamplePeriod(samplePeriod(sampleFreq))
+		perfAttribute.Options.Disabled = true
+	default:
+		perfAttribute.SetSampleFreq(uint64(sampleFreq))
+	}
+	if err := event.configurator().Configure(perfAttribute); err != nil {
+		return nil, fmt.Errorf("failed to configure perf event %s: %v", event, err)
+	}
+
+	perfEvents := make([]*perf.Event, 0, len(cpus))
+	closeAll := func() {
+		for _, perfEvent := range perfEvents {
+			if err := perfEvent.Close(); err != nil {
+				log.Errorf("Failed to close perf event: %v", err)
+			}
+		}
+	}
+	for _, id := range cpus {
 		perfEvent, err := perf.Open(perfAttribute, perf.AllThreads, id, nil)
 		if err != nil {
-			return fmt.Errorf("failed to attach to perf event on CPU %d: %v", id, err)
+			closeAll()
+			return nil, fmt.Errorf("failed to attach to perf event on CPU %d: %v", id,
+				capabilityError(opPerfEvents, err))
 		}
-		if err := perfEvent.SetBPF(uint32(tracerProg.FD())); err != nil {
-			return fmt.Errorf("failed to attach eBPF program to perf event: %v", err)
+		perfEvents = append(perfEvents, perfEvent)
+		if err := perfEvent.SetBPF(uint32(progFD)); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to attach eBPF program to perf event: %v", err)
 		}
-		*events = append(*events, perfEvent)
 	}
-	return nil
+	return perfEvents, nil
 }
 
 // EnableProfiling enables the perf interrupt events with the attached eBPF programs.
@@ -1058,6 +1389,216 @@ func (t *Tracer) EnableProfiling() error {
 		return fmt.Errorf("no perf events available to enable for profiling")
 	}
 	for id, event := range *events {
+		if _, disabled := t.disabledEventSets[t.eventSetOf(event)]; disabled {
+			continue
+		}
+		if err := event.Enable(); err != nil {
+			return fmt.Errorf("failed to enable perf event on CPU %d: %v", id, err)
+		}
+	}
+	t.samplingEnabled = true
+	return nil
+}
+
+// eventSetOf returns the event set of a perf event of perfEntrypoints. The caller must hold
+// the lock of perfEntrypoints.
+func (t *Tracer) eventSetOf(event *perf.Event) libpf.EventSet {
+	if _, ok := t.secondaryEvents[event]; ok {
+		return libpf.SecondaryEventSet
+	}
+	return libpf.PrimaryEventSet
+}
+
+// SetEventSetEnabled enables or disables sampling with the perf events of an event set.
+// Disabled event sets stay disabled when profiling is enabled, e.g. by probabilistic
+// profiling, until they are enabled again. Enabling an event set while profiling is
+// disabled only records its state, and its perf events are enabled with profiling.
+func (t *Tracer) SetEventSetEnabled(eventSet libpf.EventSet, enabled bool) error {
+	events := t.perfEntrypoints.WLock()
+	defer t.perfEntrypoints.WUnlock(&events)
+	var setEvents []*perf.Event
+	for _, event := range *events {
+		if t.eventSetOf(event) == eventSet {
+			setEvents = append(setEvents, event)
+		}
+	}
+	if len(setEvents) == 0 {
+		return fmt.Errorf("no perf events available for event set %d", eventSet)
+	}
+
+	if enabled {
+		delete(t.disabledEventSets, eventSet)
+	} else {
+		if t.disabledEventSets == nil {
+			t.disabledEventSets = make(libpf.Set[libpf.EventSet])
+		}
+		t.disabledEventSets[eventSet] = libpf.Void{}
+	}
+	if enabled && !t.samplingEnabled {
+		return nil
+	}
+	for _, event := range setEvents {
+		if enabled {
+			if err := event.Enable(); err != nil {
+				return fmt.Errorf("failed to enable perf event of event set %d: %v",
+					eventSet, err)
+			}
+			continue
+		}
+		if err := event.Disable(); err != nil {
+			return fmt.Errorf("failed to disable perf event of event set %d: %v",
+				eventSet, err)
+		}
+	}
+	return nil
+}
+
+// DisableProfiling disables the perf interrupt events with the attached eBPF programs,
+// so that no further traces are sampled.
+func (t *Tracer) DisableProfiling() error {
+	events := t.perfEntrypoints.WLock()
+	defer t.perfEntrypoints.WUnlock(&events)
+	t.samplingEnabled = false
+	for id, event := range *events {
+		if err := event.Disable(); err != nil {
+			return fmt.Errorf("failed to disable perf event on CPU %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// sampleFreqReducer identifies a mechanism that lowers the configured sampling frequency.
+type sampleFreqReducer int
+
+const (
+	reducerSelfThrottle sampleFreqReducer = iota
+	reducerIdleBackoff
+	numSampleFreqReducers
+)
+
+// SetSampleFrequency changes the configured sampling frequency of the perf events that
+// AttachTracer attached the tracer to, without the need to re-attach the eBPF program.
+// The perf events sample with it lowered by the self-throttle and the idle backoff.
+func (t *Tracer) SetSampleFrequency(sampleFreq int) error {
+	events := t.perfEntrypoints.WLock()
+	defer t.perfEntrypoints.WUnlock(&events)
+	prevFreq := t.sampleFreq
+	t.sampleFreq = sampleFreq
+	if err := t.applySampleFrequency(*events); err != nil {
+		t.sampleFreq = prevFreq
+		return err
+	}
+	return nil
+}
+
+// SampleFrequency returns the configured sampling frequency of the primary event set.
+func (t *Tracer) SampleFrequency() int {
+	events := t.perfEntrypoints.RLock()
+	defer t.perfEntrypoints.RUnlock(&events)
+	return t.sampleFreq
+}
+
+// EffectiveSampleFrequency returns the sampling frequency of the primary event set after
+// the self-throttle and the idle backoff lowered it.
+func (t *Tracer) EffectiveSampleFrequency() int {
+	events := t.perfEntrypoints.RLock()
+	defer t.perfEntrypoints.RUnlock(&events)
+	return t.effectiveSampleFrequency()
+}
+
+// effectiveSampleFrequency returns the configured sampling frequency divided by the
+// divisors of the reducers. The caller must hold the lock of perfEntrypoints.
+func (t *Tracer) effectiveSampleFrequency() int {
+	freq := t.sampleFreq
+	for _, divisor := range t.sampleFreqDivisors {
+		if divisor > 1 {
+			freq /= divisor
+		}
+	}
+	return max(freq, 1)
+}
+
+// setSampleFreqDivisor sets the divisor by which reducer lowers the configured sampling
+// frequency, and applies the resulting frequency to the perf events. It returns the
+// effective sampling frequencies before and after the change.
+func (t *Tracer) setSampleFreqDivisor(reducer sampleFreqReducer, divisor int) (
+	prevFreq, freq int, err error) {
+	events := t.perfEntrypoints.WLock()
+	defer t.perfEntrypoints.WUnlock(&events)
+	prevFreq = t.effectiveSampleFrequency()
+	prevDivisor := t.sampleFreqDivisors[reducer]
+	t.sampleFreqDivisors[reducer] = divisor
+	if err = t.applySampleFrequency(*events); err != nil {
+		t.sampleFreqDivisors[reducer] = prevDivisor
+		return prevFreq, prevFreq, err
+	}
+	return prevFreq, t.effectiveSampleFrequency(), nil
+}
+
+// applySampleFrequency changes the sampling frequency of the primary perf events to the
+// effective sampling frequency. The caller must hold the lock of perfEntrypoints.
+func (t *Tracer) applySampleFrequency(events []*perf.Event) error {
+	sampleFreq := t.effectiveSampleFrequency()
+	primaryEvents := make([]*perf.Event, 0, len(events))
+	for _, event := range events {
+		if _, ok := t.secondaryEvents[event]; !ok {
+			primaryEvents = append(primaryEvents, event)
+		}
+	}
+	if len(primaryEvents) == 0 {
+		return fmt.Errorf("no perf events available to reconfigure")
+	}
+	if event := t.primaryAttachment.event; event.samplesEachEvent() {
+		return fmt.Errorf("perf event %s is sampled on each occurrence, not with a frequency",
+			event)
+	}
+	if t.alignedSampling {
+		_, primaryDisabled := t.disabledEventSets[libpf.PrimaryEventSet]
+		if err := updateAlignedPeriod(primaryEvents, samplePeriod(sampleFreq),
+			t.samplingEnabled && !primaryDisabled); err != nil {
+			return err
+		}
+		t.primaryAttachment.sampleFreq = sampleFreq
+		return nil
+	}
+	for id, event := range primaryEvents {
+		// For frequency based perf events the kernel interprets the new
+		// period as the new sampling frequency.
+		if err := event.UpdatePeriod(uint64(sampleFreq)); err != nil {
+			return fmt.Errorf("failed to update frequency of perf event on CPU %d: %v",
+				id, err)
+		}
+	}
+	t.primaryAttachment.sampleFreq = sampleFreq
+	return nil
+}
+
+// periodEvent is the part of perf.Event that updateAlignedPeriod uses.
+type periodEvent interface {
+	Enable() error
+	Disable() error
+	UpdatePeriod(period uint64) error
+}
+
+// updateAlignedPeriod changes the period of aligned perf events. Updating the period restarts
+// the clock of an event, so all events are stopped first and then restarted together to keep
+// them aligned. The events are only restarted if enable is set, so that the update does not
+// enable events whose sampling is disabled.
+func updateAlignedPeriod[E periodEvent](events []E, period uint64, enable bool) error {
+	for id, event := range events {
+		if err := event.Disable(); err != nil {
+			return fmt.Errorf("failed to disable perf event on CPU %d: %v", id, err)
+		}
+	}
+	for id, event := range events {
+		if err := event.UpdatePeriod(period); err != nil {
+			return fmt.Errorf("failed to update period of perf event on CPU %d: %v", id, err)
+		}
+	}
+	if !enable {
+		return nil
+	}
+	for id, event := range events {
 		if err := event.Enable(); err != nil {
 			return fmt.Errorf("failed to enable perf event on CPU %d: %v", id, err)
 		}
@@ -1065,6 +1606,63 @@ func (t *Tracer) EnableProfiling() error {
 	return nil
 }
 
+// SetPIDFilter restricts profiling to the given set of PIDs by synchronizing the eBPF map
+// pid_filter with it. Passing a nil set disables the filter and all PIDs are profiled again.
+func (t *Tracer) SetPIDFilter(pids libpf.Set[libpf.PID]) error {
+	filterMap := t.ebpfMaps["pid_filter"]
+
+	// The presence of key 0 marks the filter as active in the eBPF code.
+	activeKey := uint32(0)
+	value := true
+
+	if pids == nil {
+		if err := filterMap.Delete(unsafe.Pointer(&activeKey)); err != nil &&
+			!errors.Is(err, cebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to deactivate pid_filter: %v", err)
+		}
+		t.pidFilterActive.Store(false)
+	} else {
+		// The filter is considered active as soon as it is possibly applied.
+		t.pidFilterActive.Store(true)
+	}
+
+	// Collect the PIDs that are no longer part of the filter.
+	var key uint32
+	var present bool
+	stale := make([]uint32, 0)
+	iter := filterMap.Iterate()
+	for iter.Next(unsafe.Pointer(&key), unsafe.Pointer(&present)) {
+		if _, ok := pids[libpf.PID(key)]; !ok && key != activeKey {
+			stale = append(stale, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to iterate pid_filter: %v", err)
+	}
+
+	for pid := range pids {
+		key = uint32(pid)
+		if err := filterMap.Update(unsafe.Pointer(&key), unsafe.Pointer(&value),
+			cebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to add PID %d to pid_filter: %v", pid, err)
+		}
+	}
+	if pids != nil {
+		if err := filterMap.Update(unsafe.Pointer(&activeKey), unsafe.Pointer(&value),
+			cebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to activate pid_filter: %v", err)
+		}
+	}
+
+	for _, key := range stale {
+		if err := filterMap.Delete(unsafe.Pointer(&key)); err != nil &&
+			!errors.Is(err, cebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to remove PID %d from pid_filter: %v", key, err)
+		}
+	}
+	return nil
+}
+
 // probabilisticProfile performs a single iteration of probabilistic profiling. It will generate
 // a random number between 0 and ProbabilisticThresholdMax-1 every interval. If the random
 // number is smaller than threshold it will enable the frequency based sampling for this
@@ -1083,8 +1681,12 @@ func (t *Tracer) probabilisticProfile(interval time.Duration, threshold uint) {
 
 	events := t.perfEntrypoints.WLock()
 	defer t.perfEntrypoints.WUnlock(&events)
+	t.samplingEnabled = enableSampling
 	var enableErr, disableErr metrics.MetricValue
 	for _, event := range *events {
+		if _, disabled := t.disabledEventSets[t.eventSetOf(event)]; disabled {
+			continue
+		}
 		if enableSampling {
 			if err := event.Enable(); err != nil {
 				enableErr++
@@ -1131,3 +1733,11 @@ func (t *Tracer) ConvertTrace(trace *host.Trace) *libpf.Trace {
 func (t *Tracer) SymbolizationComplete(traceCaptureKTime libpf.KTime) {
 	t.processManager.SymbolizationComplete(traceCaptureKTime)
 }
+
+func (t *Tracer) ExecutableName(pid libpf.PID) string {
+	return t.processManager.ExecutableName(pid)
+}
+
+func (t *Tracer) ProcessLabels(pid libpf.PID) map[string]string {
+	return t.processManager.ProcessLabels(pid)
+}
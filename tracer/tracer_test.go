@@ -0,0 +1,71 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePeriodEvent records the state a perf event would be in.
+type fakePeriodEvent struct {
+	enabled bool
+	period  uint64
+}
+
+func (e *fakePeriodEvent) Enable() error {
+	e.enabled = true
+	return nil
+}
+
+func (e *fakePeriodEvent) Disable() error {
+	e.enabled = false
+	return nil
+}
+
+func (e *fakePeriodEvent) UpdatePeriod(period uint64) error {
+	e.period = period
+	return nil
+}
+
+func TestUpdateAlignedPeriod(t *testing.T) {
+	events := []*fakePeriodEvent{{enabled: true}, {enabled: true}}
+	require.NoError(t, updateAlignedPeriod(events, 1000, true))
+	for _, event := range events {
+		assert.Equal(t, fakePeriodEvent{enabled: true, period: 1000}, *event)
+	}
+
+	// With sampling disabled, the update must not enable the events.
+	events = []*fakePeriodEvent{{}, {}}
+	require.NoError(t, updateAlignedPeriod(events, 2000, false))
+	for _, event := range events {
+		assert.Equal(t, fakePeriodEvent{period: 2000}, *event)
+	}
+}
+
+func TestEffectiveSampleFrequency(t *testing.T) {
+	tests := map[string]struct {
+		sampleFreq int
+		divisors   [numSampleFreqReducers]int
+		expected   int
+	}{
+		"not lowered":      {sampleFreq: 20, expected: 20},
+		"throttled":        {sampleFreq: 20, divisors: [...]int{4, 1}, expected: 5},
+		"throttled & idle": {sampleFreq: 20, divisors: [...]int{2, 4}, expected: 2},
+		"minimum":          {sampleFreq: 4, divisors: [...]int{8, 8}, expected: 1},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			tracer := &Tracer{sampleFreq: test.sampleFreq,
+				sampleFreqDivisors: test.divisors}
+			assert.Equal(t, test.expected, tracer.effectiveSampleFrequency())
+		})
+	}
+}
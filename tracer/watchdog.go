@@ -0,0 +1,225 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-perf"
+	log "github.com/sirupsen/logrus"
+
+	hostcpu "github.com/elastic/otel-profiling-agent/hostmetadata/host"
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/libpf/periodiccaller"
+	"github.com/elastic/otel-profiling-agent/metrics"
+	"github.com/elastic/otel-profiling-agent/proc"
+)
+
+// watchdogMinBusyFraction is the fraction of a CPU that the tasks other than the agent need
+// to use in an interval, so that traces are expected to arrive in it.
+const watchdogMinBusyFraction = 0.1
+
+// perfAttachment holds the parameters the perf events of an event set were opened with.
+type perfAttachment struct {
+	// progName is the name of the eBPF program attached to the perf events.
+	progName   string
+	sampleFreq int
+	event      PerfEvent
+	aligned    bool
+}
+
+// samplerWatchdog keeps the state for detecting that no traces arrive, although the host
+// is busy and sampling is enabled.
+type samplerWatchdog struct {
+	// stallIntervals is the number of consecutive intervals without traces after which
+	// the sampling is restarted.
+	stallIntervals int
+	// stalled counts the consecutive intervals without traces.
+	stalled int
+	// restarted is set after a restart until traces arrive again.
+	restarted bool
+
+	lastTraces   uint64
+	lastBusy     time.Duration
+	lastSelf     time.Duration
+	lastWallTime time.Time
+	lastActive   bool
+}
+
+func newSamplerWatchdog(stallIntervals int, traces uint64, busy, self time.Duration,
+	now time.Time, active bool) *samplerWatchdog {
+	return &samplerWatchdog{
+		stallIntervals: stallIntervals,
+		lastTraces:     traces,
+		lastBusy:       busy,
+		lastSelf:       self,
+		lastWallTime:   now,
+		lastActive:     active,
+	}
+}
+
+// update takes the cumulative number of received traces, the cumulative busy CPU time of
+// the host and of the agent itself, and whether sampling is active. An interval without
+// traces counts as stalled if sampling was active throughout it and the other tasks of the
+// host kept at least watchdogMinBusyFraction of a CPU busy. After stallIntervals stalled
+// intervals, restart is returned. recovered is returned once traces arrive after a restart.
+func (w *samplerWatchdog) update(traces uint64, busy, self time.Duration, now time.Time,
+	active bool) (restart, recovered bool) {
+	tracesDelta := traces - w.lastTraces
+	othersBusy := (busy - w.lastBusy) - (self - w.lastSelf)
+	wallDelta := now.Sub(w.lastWallTime)
+	wasActive := w.lastActive
+	w.lastTraces = traces
+	w.lastBusy = busy
+	w.lastSelf = self
+	w.lastWallTime = now
+	w.lastActive = active
+
+	if tracesDelta != 0 {
+		w.stalled = 0
+		recovered = w.restarted
+		w.restarted = false
+		return false, recovered
+	}
+	if !active || !wasActive || wallDelta <= 0 ||
+		float64(othersBusy) < float64(wallDelta)*watchdogMinBusyFraction {
+		w.stalled = 0
+		return false, false
+	}
+
+	w.stalled++
+	if w.stalled < w.stallIntervals {
+		return false, false
+	}
+	w.stalled = 0
+	w.restarted = true
+	return true, false
+}
+
+// samplingActive returns true if the perf events sample all processes of the host, so that
+// traces are expected to arrive while the host is busy. This is not the case for primary
+// perf events that do not trigger while the CPUs are busy, like major faults.
+func (t *Tracer) samplingActive() bool {
+	events := t.perfEntrypoints.RLock()
+	defer t.perfEntrypoints.RUnlock(&events)
+	_, primaryDisabled := t.disabledEventSets[libpf.PrimaryEventSet]
+	return t.samplingEnabled && !primaryDisabled && !t.pidFilterActive.Load() &&
+		(t.primaryAttachment == nil || t.primaryAttachment.event.triggersWhileBusy())
+}
+
+// restartSampling closes all perf events and opens them again with the parameters they were
+// attached with, which attaches the eBPF programs to the new events. The new events are
+// enabled if sampling is enabled and their event set is not disabled.
+func (t *Tracer) restartSampling() error {
+	onlineCPUIDs, err := hostcpu.ParseCPUCoreIDs(hostcpu.CPUOnlinePath)
+	if err != nil {
+		return fmt.Errorf("failed to get online CPUs: %v", err)
+	}
+
+	events := t.perfEntrypoints.WLock()
+	defer t.perfEntrypoints.WUnlock(&events)
+	if t.primaryAttachment == nil {
+		return errors.New("no perf events attached")
+	}
+	for _, event := range *events {
+		if err := event.Close(); err != nil {
+			log.Errorf("Failed to close perf event: %v", err)
+		}
+	}
+	*events = nil
+	t.secondaryEvents = nil
+
+	for _, attachment := range []*perfAttachment{t.primaryAttachment, t.secondaryAttachment} {
+		if attachment == nil {
+			continue
+		}
+		prog, ok := t.ebpfProgs[attachment.progName]
+		if !ok {
+			return fmt.Errorf("program %s is not available", attachment.progName)
+		}
+		perfEvents, err := openPerfEvents(attachment.sampleFreq, attachment.event,
+			attachment.aligned, onlineCPUIDs, prog.FD())
+		if err != nil {
+			return err
+		}
+		if attachment == t.secondaryAttachment {
+			t.secondaryEvents = make(libpf.Set[*perf.Event], len(perfEvents))
+			for _, perfEvent := range perfEvents {
+				t.secondaryEvents[perfEvent] = libpf.Void{}
+			}
+		}
+		*events = append(*events, perfEvents...)
+	}
+
+	for id, event := range *events {
+		if _, disabled := t.disabledEventSets[t.eventSetOf(event)]; t.samplingEnabled &&
+			!disabled {
+			if err := event.Enable(); err != nil {
+				return fmt.Errorf("failed to enable perf event on CPU %d: %v", id, err)
+			}
+			continue
+		}
+		if err := event.Disable(); err != nil {
+			return fmt.Errorf("failed to disable perf event on CPU %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// readWatchdogCPUTimes returns the busy CPU time of the host and the CPU time of the agent.
+func readWatchdogCPUTimes() (busy, self time.Duration, err error) {
+	if busy, err = proc.GetBusyCPUTime(); err != nil {
+		return 0, 0, fmt.Errorf("failed to read host CPU time: %v", err)
+	}
+	if self, err = getSelfCPUTime(); err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch Rusage: %v", err)
+	}
+	return busy, self, nil
+}
+
+// StartSamplerWatchdog periodically checks whether traces arrive from the eBPF tracer, to
+// recover from perf events that silently stopped delivering samples. If no traces arrive for
+// stallIntervals consecutive intervals, while sampling is enabled for all processes and the
+// host is busy, the perf events are re-created and the eBPF programs attached to them again.
+func (t *Tracer) StartSamplerWatchdog(ctx context.Context, interval time.Duration,
+	stallIntervals int) error {
+	busy, self, err := readWatchdogCPUTimes()
+	if err != nil {
+		return err
+	}
+	watchdog := newSamplerWatchdog(stallIntervals, t.tracesReceived.Load(), busy, self,
+		time.Now(), t.samplingActive())
+
+	periodiccaller.Start(ctx, interval, func() {
+		busy, self, err := readWatchdogCPUTimes()
+		if err != nil {
+			log.Errorf("Sampler watchdog: %v", err)
+			return
+		}
+
+		restart, recovered := watchdog.update(t.tracesReceived.Load(), busy, self,
+			time.Now(), t.samplingActive())
+		if recovered {
+			log.Infof("Sampling recovered, traces are arriving again")
+		}
+		if !restart {
+			return
+		}
+
+		log.Warnf("No traces arrived for %d intervals while the host was busy, "+
+			"restarting sampling", stallIntervals)
+		metrics.Add(metrics.IDSamplerRestarts, 1)
+		if err := t.restartSampling(); err != nil {
+			log.Errorf("Failed to restart sampling: %v", err)
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+package tracer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplerWatchdog(t *testing.T) {
+	now := time.Unix(1000, 0)
+	watchdog := newSamplerWatchdog(3, 0, 0, 0, now, true)
+
+	var traces uint64
+	var busy, self time.Duration
+	step := func(newTraces uint64, busyDelta, selfDelta time.Duration,
+		active bool) (restart, recovered bool) {
+		traces += newTraces
+		busy += busyDelta
+		self += selfDelta
+		now = now.Add(time.Second)
+		return watchdog.update(traces, busy, self, now, active)
+	}
+	stalled := func(n int) {
+		for i := 0; i < n; i++ {
+			restart, _ := step(0, time.Second, 0, true)
+			assert.False(t, restart, "interval %d", i)
+		}
+	}
+
+	// Traces arrive while the host is busy.
+	restart, recovered := step(10, time.Second, 0, true)
+	assert.False(t, restart)
+	assert.False(t, recovered)
+	// An idle host does not produce traces, and neither does the agent itself.
+	for i := 0; i < 5; i++ {
+		restart, _ = step(0, 50*time.Millisecond, 0, true)
+		assert.False(t, restart)
+		restart, _ = step(0, time.Second, 950*time.Millisecond, true)
+		assert.False(t, restart)
+	}
+	// No traces are expected while sampling is inactive, and the first active interval
+	// may have been partly inactive.
+	for i := 0; i < 5; i++ {
+		restart, _ = step(0, time.Second, 0, false)
+		assert.False(t, restart)
+	}
+	stalled(1)
+	// A busy host without traces restarts the sampling after the stalled intervals.
+	stalled(2)
+	restart, _ = step(0, time.Second, 0, true)
+	assert.True(t, restart)
+	// The recovery is reported for the first traces after the restart.
+	_, recovered = step(5, time.Second, 0, true)
+	assert.True(t, recovered)
+	_, recovered = step(5, time.Second, 0, true)
+	assert.False(t, recovered)
+	// Traces reset the stalled intervals.
+	stalled(2)
+	restart, _ = step(1, time.Second, 0, true)
+	assert.False(t, restart)
+	stalled(2)
+}
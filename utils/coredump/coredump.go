@@ -33,7 +33,7 @@ import (
 
 // #include <stdlib.h>
 // #include "../../support/ebpf/types.h"
-// int unwind_traces(u64 id, int debug, u64 tp_base, void *ctx);
+// int unwind_traces(u64 id, u32 tid, int debug, u64 tp_base, void *ctx);
 import "C"
 
 // sliceBuffer creates a Go slice from C buffer
@@ -90,7 +90,7 @@ func newSymbolizationCache() *symbolizationCache {
 }
 
 func (c *symbolizationCache) ExecutableMetadata(_ context.Context, fileID libpf.FileID,
-	fileName, _ string) {
+	fileName, _ string, _, _ uint64, _ pfelf.AddressMapper) {
 	c.files[fileID] = fileName
 }
 
@@ -176,7 +176,7 @@ func ExtractTraces(ctx context.Context, pr process.Process, debug bool,
 	for _, mapName := range []string{"interpreter_offsets",
 		"pid_page_to_mapping_info", "stack_delta_page_to_info", "pid_page_to_mapping_info",
 		"perl_procs", "py_procs", "hotspot_procs", "ruby_procs", "php_procs",
-		"v8_procs"} {
+		"v8_procs", "beam_procs"} {
 		dummyMaps[mapName] = &cebpf.Map{}
 	}
 	for i := support.StackDeltaBucketSmallest; i <= support.StackDeltaBucketLargest; i++ {
@@ -246,8 +246,8 @@ func ExtractTraces(ctx context.Context, pr process.Process, debug bool,
 
 		// Get traces by calling ebpf code via CGO
 		ebpfCtx.resetTrace()
-		if rc := C.unwind_traces(ebpfCtx.PIDandTGID, debugFlag, C.u64(thread.TPBase),
-			unsafe.Pointer(&thread.GPRegs[0])); rc != 0 {
+		if rc := C.unwind_traces(ebpfCtx.PIDandTGID, C.u32(thread.LWP), debugFlag,
+			C.u64(thread.TPBase), unsafe.Pointer(&thread.GPRegs[0])); rc != 0 {
 			return nil, fmt.Errorf("failed to unwind lwp %v: %v", thread.LWP, rc)
 		}
 		// Symbolize traces with interpreter manager
@@ -26,6 +26,7 @@ package main
 struct cgo_ctx {
 	jmp_buf jmpbuf;
 	u64 id, tp_base;
+	u32 tid;
 	int ret;
 	int debug;
 };
@@ -55,13 +56,15 @@ int bpf_log(const char *fmt, ...)
 #include "../../support/ebpf/hotspot_tracer.ebpf.c"
 #include "../../support/ebpf/ruby_tracer.ebpf.c"
 #include "../../support/ebpf/v8_tracer.ebpf.c"
+#include "../../support/ebpf/beam_tracer.ebpf.c"
 #include "../../support/ebpf/system_config.ebpf.c"
 
-int unwind_traces(u64 id, int debug, u64 tp_base, void *ctx)
+int unwind_traces(u64 id, u32 tid, int debug, u64 tp_base, void *ctx)
 {
 	struct cgo_ctx cgoctx;
 
 	cgoctx.id = id;
+	cgoctx.tid = tid;
 	cgoctx.ret = 0;
 	cgoctx.debug = debug;
 	cgoctx.tp_base = tp_base;
@@ -116,6 +119,9 @@ int bpf_tail_call(void *ctx, bpf_map_def *map, int index)
 	case PROG_UNWIND_V8:
 		rc = unwind_v8(ctx);
 		break;
+	case PROG_UNWIND_BEAM:
+		rc = unwind_beam(ctx);
+		break;
 	default:
 		return -1;
 	}
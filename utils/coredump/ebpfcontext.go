@@ -30,9 +30,9 @@ type ebpfContext struct {
 	// remotememory provides access to the target process memory space
 	remoteMemory remotememory.RemoteMemory
 
-	// PIDandTGID is the value for bpf_get_current_pid_tgid(), and is also the
-	// unique context ID passed from eBPF code to the helper functions written
-	// in Go to find the matching ebpfContext struct
+	// PIDandTGID is the value for bpf_get_current_pid_tgid() without the thread ID of the
+	// unwound thread, and is also the unique context ID passed from eBPF code to the helper
+	// functions written in Go to find the matching ebpfContext struct
 	PIDandTGID C.u64
 
 	// perCPURecord is the ebpf code PerCPURecord
@@ -99,6 +99,9 @@ func initSystemConfig(md process.MachineData) unsafe.Pointer {
 	// `tsd_get_base`, the function reading this field, is special-cased
 	// for coredump tests via `ifdefs`, so the value we set here doesn't matter.
 	sv.tpbase_offset = 0
+	// The paging mode of the host the coredump was taken on is unknown, so let
+	// `is_kernel_address` fall back to its heuristic.
+	sv.kernel_address_start = 0
 	sv.drop_error_only_traces = C.bool(false)
 
 	return rawPtr
@@ -102,7 +102,7 @@ func __bpf_map_lookup_elem(id C.u64, mapdef *C.bpf_map_def, keyptr unsafe.Pointe
 	case &C.per_cpu_records:
 		return ctx.perCPURecord
 	case &C.interpreter_offsets, &C.perl_procs, &C.php_procs, &C.py_procs, &C.hotspot_procs,
-		&C.ruby_procs, &C.v8_procs:
+		&C.ruby_procs, &C.v8_procs, &C.beam_procs:
 		var key any
 		switch mapdef.key_size {
 		case 8:
@@ -134,6 +134,9 @@ func __bpf_map_lookup_elem(id C.u64, mapdef *C.bpf_map_def, keyptr unsafe.Pointe
 		}
 	case &C.metrics:
 		return unsafe.Pointer(uintptr(0))
+	case &C.pid_filter, &C.pid_sample_weights:
+		// PID filtering and sample weights are never active when analyzing coredumps.
+		return nil
 	case &C.system_config:
 		return ctx.systemConfig
 	default:
@@ -71,6 +71,8 @@ func (emc *ebpfMapsCoredump) UpdateProcData(t libpf.InterpType, pid libpf.PID,
 		emc.ctx.addMap(&C.ruby_procs, C.u32(pid), sliceBuffer(ptr, C.sizeof_RubyProcInfo))
 	case libpf.V8:
 		emc.ctx.addMap(&C.v8_procs, C.u32(pid), sliceBuffer(ptr, C.sizeof_V8ProcInfo))
+	case libpf.BEAM:
+		emc.ctx.addMap(&C.beam_procs, C.u32(pid), sliceBuffer(ptr, C.sizeof_BEAMProcInfo))
 	}
 	return nil
 }
@@ -89,6 +91,8 @@ func (emc *ebpfMapsCoredump) DeleteProcData(t libpf.InterpType, pid libpf.PID) e
 		emc.ctx.delMap(&C.ruby_procs, C.u32(pid))
 	case libpf.V8:
 		emc.ctx.delMap(&C.v8_procs, C.u32(pid))
+	case libpf.BEAM:
+		emc.ctx.delMap(&C.beam_procs, C.u32(pid))
 	}
 	return nil
 }
@@ -0,0 +1,80 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Embeds Python and runs Python code in two threads created in C, whose thread states are
+// not in the TSD slot of PyGILState before Python 3.12: one in the main interpreter and one
+// in a sub-interpreter, which has its own GIL in Python 3.12+. Once both sleep, the main
+// thread aborts the process to create the coredump.
+//
+// gcc -o threads threads.c $(pythonX.Y-config --includes) $(pythonX.Y-config --ldflags --embed)
+// ulimit -c unlimited && ./threads
+
+#include <Python.h>
+#include <pthread.h>
+#include <unistd.h>
+
+static const char main_script[] =
+    "import time\n"
+    "def c_thread():\n"
+    "    time.sleep(1000)\n"
+    "c_thread()\n";
+
+static const char sub_script[] =
+    "import time\n"
+    "def sub_interpreter():\n"
+    "    time.sleep(1000)\n"
+    "sub_interpreter()\n";
+
+struct thread_args {
+  PyInterpreterState *interp;
+  const char *script;
+};
+
+static void *run(void *arg) {
+  struct thread_args *args = arg;
+  // Unlike PyGILState_Ensure and PyThreadState_New, this does not store the thread state
+  // in TSD. Python 3.12+ stores it once the thread state is activated.
+  PyThreadState *tstate = _PyThreadState_Prealloc(args->interp);
+  PyEval_RestoreThread(tstate);
+  PyRun_SimpleString(args->script);
+  PyThreadState_Clear(tstate);
+  PyThreadState_DeleteCurrent();
+  return NULL;
+}
+
+int main(void) {
+  Py_Initialize();
+  PyThreadState *main_tstate = PyThreadState_Get();
+  struct thread_args main_args = {PyInterpreterState_Main(), main_script};
+
+#if PY_VERSION_HEX >= 0x030c0000
+  PyInterpreterConfig config = {
+    .check_multi_interp_extensions = 1,
+    .gil = PyInterpreterConfig_OWN_GIL,
+  };
+  PyThreadState *sub_tstate;
+  if (PyStatus_Exception(Py_NewInterpreterFromConfig(&sub_tstate, &config))) {
+    return 1;
+  }
+#else
+  PyThreadState *sub_tstate = Py_NewInterpreter();
+#endif
+  if (!sub_tstate) {
+    return 1;
+  }
+  struct thread_args sub_args = {sub_tstate->interp, sub_script};
+  // Switch back to the main interpreter, releasing the GIL of the sub-interpreter if it has
+  // its own, and release the GIL for the threads.
+  PyThreadState_Swap(main_tstate);
+  PyEval_SaveThread();
+
+  pthread_t main_thread, sub_thread;
+  pthread_create(&main_thread, NULL, run, &main_args);
+  sleep(1);
+  pthread_create(&sub_thread, NULL, run, &sub_args);
+  sleep(1);
+  abort();
+}
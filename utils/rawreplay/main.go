@@ -0,0 +1,146 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Implements a command-line utility that replays a dump written with the -raw-dump option
+// of the agent, symbolizing the native frames of the recorded traces with local copies
+// of the executables of the profiled host. The traces are either printed, or written as
+// pprof profile after passing through the symbolization pipeline of the agent.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elastic/otel-profiling-agent/libpf"
+	"github.com/elastic/otel-profiling-agent/rawdump"
+	"github.com/elastic/otel-profiling-agent/reporter"
+)
+
+// printTrace writes trace with its symbolized frames to w.
+func printTrace(w io.Writer, trace *rawdump.Trace, frames []rawdump.SymbolizedFrame) {
+	fmt.Fprintf(w, "pid %d tid %d comm %q cpu %d ktime %d\n",
+		trace.PID, trace.TID, trace.Comm, trace.CPU, trace.KTime)
+	for i, frame := range frames {
+		interp := frame.Type.Interpreter()
+		switch {
+		case frame.Type.IsError() || (interp != libpf.Native && interp != libpf.Kernel):
+			fmt.Fprintf(w, "  #%-3d %-8s %016x %#x\n", i, frame.Type, uint64(frame.FileID),
+				uint64(frame.Address))
+		case frame.Function == "":
+			fmt.Fprintf(w, "  #%-3d %-8s %#x %s\n", i, frame.Type, uint64(frame.Address),
+				frame.FileName)
+		case frame.SourceFile != "":
+			fmt.Fprintf(w, "  #%-3d %-8s %#x %s %s+%#x (%s:%d)\n", i, frame.Type,
+				uint64(frame.Address), frame.FileName, frame.Function, frame.Offset,
+				frame.SourceFile, frame.SourceLine)
+		default:
+			fmt.Fprintf(w, "  #%-3d %-8s %#x %s %s+%#x\n", i, frame.Type,
+				uint64(frame.Address), frame.FileName, frame.Function, frame.Offset)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// writeProfile replays the traces of dump through the symbolization pipeline of the agent
+// and writes the resulting pprof profile to path.
+func writeProfile(dump *rawdump.Dump, symbolizer *rawdump.Symbolizer, path string,
+	samplesPerSecond int) error {
+	rep, err := reporter.NewPprofReporter(&reporter.Config{}, samplesPerSecond)
+	if err != nil {
+		return err
+	}
+	if err = rawdump.Replay(dump, symbolizer, rep); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err = rep.WriteProfile(out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func tryMain() error {
+	var binariesDirs, debuginfodURLs, pprofOutput string
+	var pid uint
+	var samplesPerSecond int
+
+	flag.StringVar(&binariesDirs, "binaries", ".",
+		"Comma-separated list of directories with the executables of the profiled host")
+	flag.StringVar(&debuginfodURLs, "debuginfod", os.Getenv("DEBUGINFOD_URLS"),
+		"Space-separated list of debuginfod servers to download executables from "+
+			"that are not found in the binaries directories")
+	flag.UintVar(&pid, "pid", 0, "Only replay the traces of the given PID")
+	flag.StringVar(&pprofOutput, "pprof", "",
+		"Write the traces as pprof profile to the given file instead of printing them")
+	flag.IntVar(&samplesPerSecond, "samples-per-second", 20,
+		"The sampling frequency of the agent the dump was taken with")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		return fmt.Errorf("usage: %s [flags] <dump>", os.Args[0])
+	}
+
+	in, err := os.Open(flag.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer in.Close()
+	dump, err := rawdump.Read(in)
+	if err != nil {
+		return fmt.Errorf("failed to read dump: %w", err)
+	}
+	if pid != 0 {
+		traces := dump.Traces[:0]
+		for _, trace := range dump.Traces {
+			if trace.PID == libpf.PID(pid) {
+				traces = append(traces, trace)
+			}
+		}
+		dump.Traces = traces
+	}
+
+	roots := rawdump.SymbolRoots{
+		Dirs:           strings.Split(binariesDirs, ","),
+		DebuginfodURLs: strings.Fields(debuginfodURLs),
+	}
+	if len(roots.DebuginfodURLs) > 0 {
+		if roots.CacheDir, err = os.MkdirTemp("", "rawreplay"); err != nil {
+			return fmt.Errorf("failed to create download directory: %w", err)
+		}
+		defer os.RemoveAll(roots.CacheDir)
+	}
+	symbolizer, err := rawdump.NewSymbolizer(dump, roots)
+	if err != nil {
+		return err
+	}
+
+	if pprofOutput != "" {
+		if err = writeProfile(dump, symbolizer, pprofOutput, samplesPerSecond); err != nil {
+			return fmt.Errorf("failed to write profile: %w", err)
+		}
+		return nil
+	}
+	for i := range dump.Traces {
+		trace := &dump.Traces[i]
+		printTrace(os.Stdout, trace, symbolizer.Symbolize(trace))
+	}
+	return nil
+}
+
+func main() {
+	if err := tryMain(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+ * or more contributor license agreements. Licensed under the Apache License 2.0.
+ * See the file "LICENSE" for details.
+ */
+
+// Implements a command-line utility for extracting the stack deltas of executables ahead of
+// time, for use with the -stack-deltas-dir option of the agent.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/elfunwindinfo"
+	"github.com/elastic/otel-profiling-agent/libpf/nativeunwind/precomputed"
+	sdtypes "github.com/elastic/otel-profiling-agent/libpf/nativeunwind/stackdeltatypes"
+	"github.com/elastic/otel-profiling-agent/libpf/pfelf"
+)
+
+// extract writes the stack deltas of the executable at path to outDir.
+func extract(outDir, path string) error {
+	ef, err := pfelf.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	buildID, err := ef.GetBuildID()
+	ef.Close()
+	if err != nil {
+		return fmt.Errorf("failed to get build ID of %s: %w", path, err)
+	}
+
+	var interval sdtypes.IntervalData
+	if err = elfunwindinfo.Extract(path, &interval); err != nil {
+		return fmt.Errorf("failed to extract stack deltas from %s: %w", path, err)
+	}
+	if err = precomputed.Write(outDir, buildID, &interval); err != nil {
+		return fmt.Errorf("failed to write stack deltas of %s: %w", path, err)
+	}
+	fmt.Printf("%s: %d stack deltas written to %s\n", path, len(interval.Deltas),
+		precomputed.Path(outDir, buildID))
+	return nil
+}
+
+func tryMain() error {
+	var outDir string
+
+	flag.StringVar(&outDir, "o", "", "The output directory")
+	flag.Parse()
+
+	if outDir == "" {
+		return fmt.Errorf("missing required argument `o`")
+	}
+	if flag.NArg() == 0 {
+		return fmt.Errorf("no executables given")
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, path := range flag.Args() {
+		if err := extract(outDir, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	if err := tryMain(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}